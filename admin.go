@@ -0,0 +1,565 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Web UI and JSON API to manage the nodes
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"serverpool"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed admin.html
+var adminPage []byte
+
+// adminServer serves the UI and the API it uses. Requests hold mu, which
+// the command loop also holds while it runs a command, so they do not
+// change the load balancer halfway through a command.
+type adminServer struct {
+	mu *sync.Mutex
+	lb LoadBalancer[netip.Addr, int]
+
+	// Bearer token API requests must carry, none if empty
+	token string
+
+	// Host names requests must be addressed to, so pages of other sites
+	// cannot reach the server through names rebound to its address. IP
+	// addresses are accepted too if anyAddr is set.
+	hosts   map[string]bool
+	anyAddr bool
+
+	// Called with mu held after a request changed the load balancer
+	changed func()
+}
+
+// Largest body of a request changing the load balancer
+const maxAdminBody = 1 << 16
+
+// Time allowed to read a request and to handle it and write the reply,
+// which may wait for the lock while another change rebalances objects
+const (
+	adminReadTimeout  = 10 * time.Second
+	adminWriteTimeout = time.Minute
+)
+
+// JSON body of a request changing the load balancer, each request uses the
+// fields it needs and zero values take their defaults
+type adminRequest struct {
+	Addr     string `json:"addr"`
+	Weight   int    `json:"weight"`
+	Batch    int    `json:"batch"`
+	Interval string `json:"interval"`
+}
+
+// Node as shown by the UI
+type adminNode struct {
+	Address string `json:"address"`
+
+	// Bucket of the node, -1 while it is drained
+	Bucket  int `json:"bucket"`
+	Weight  int `json:"weight"`
+	Objects int `json:"objects"`
+
+	// Health of the node: "up", "draining" or "quarantined"
+	Health string `json:"health"`
+
+	Drain            *DrainProgress `json:"drain,omitempty"`
+	QuarantinedUntil *time.Time     `json:"quarantinedUntil,omitempty"`
+}
+
+// Automatic actions as shown by the UI
+type adminAutomation struct {
+	Paused bool `json:"paused"`
+}
+
+type adminBucket struct {
+	Bucket int    `json:"bucket"`
+	Node   string `json:"node"`
+}
+
+type adminMapping struct {
+	Key        string   `json:"key"`
+	Node       string   `json:"node"`
+	Candidates []string `json:"candidates"`
+}
+
+// Serve the UI on addr in the background, requiring token for the API if
+// it is not empty. An address without a host only listens on localhost,
+// other than loopback addresses require a token.
+func serveAdmin(addr, token string, mu *sync.Mutex, lb LoadBalancer[netip.Addr, int], changed func()) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		host = "localhost"
+		addr = net.JoinHostPort(host, port)
+	}
+	s := newAdminServer(host, token, mu, lb, changed)
+	if token == "" && !isLoopback(host) {
+		return errors.New("a token is required to listen on " + host)
+	}
+	server := &http.Server{Addr: addr, Handler: s.handler(), ReadHeaderTimeout: adminReadTimeout,
+		ReadTimeout: adminReadTimeout, WriteTimeout: adminWriteTimeout}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			out.info("Admin server stopped:", err)
+		}
+	}()
+	return nil
+}
+
+// Create an admin server listening on host. Requests must be addressed to
+// localhost or host, or to any IP address if host is unspecified.
+func newAdminServer(host, token string, mu *sync.Mutex, lb LoadBalancer[netip.Addr, int], changed func()) *adminServer {
+	s := &adminServer{mu: mu, lb: lb, token: token, changed: changed,
+		hosts: map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true}}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.IsUnspecified() {
+		s.anyAddr = true
+	} else {
+		s.hosts[strings.ToLower(host)] = true
+	}
+	return s
+}
+
+// Whether host only listens on the local machine
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+func (s *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(adminPage)
+	})
+	mux.HandleFunc("GET /api/nodes", s.read(s.nodes))
+	mux.HandleFunc("GET /api/buckets", s.read(s.buckets))
+	mux.HandleFunc("GET /api/map", s.read(s.mapKey))
+	mux.HandleFunc("GET /api/rebalances", s.read(s.rebalances))
+	mux.HandleFunc("GET /api/decommissions", s.read(s.decommissions))
+	mux.HandleFunc("GET /api/pins", s.read(s.pins))
+	mux.HandleFunc("PUT /api/pins/{key}", s.write(s.pinKey))
+	mux.HandleFunc("DELETE /api/pins/{key}", s.write(s.unpinKey))
+	mux.HandleFunc("POST /api/nodes", s.write(s.addNode))
+	mux.HandleFunc("DELETE /api/nodes/{addr}", s.write(s.removeNode))
+	mux.HandleFunc("POST /api/nodes/{addr}/drain", s.write(s.drainNode))
+	mux.HandleFunc("DELETE /api/nodes/{addr}/drain", s.write(s.abortDrain))
+	mux.HandleFunc("GET /api/automation", s.read(s.automation))
+	mux.HandleFunc("POST /api/automation/pause", s.write(s.pauseAutomation))
+	mux.HandleFunc("POST /api/automation/resume", s.write(s.resumeAutomation))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.knownHost(r) {
+			s.reply(w, nil, adminStatusError{http.StatusForbidden, errors.New("unknown host " + r.Host)})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Whether a request is addressed to a name of the server
+func (s *adminServer) knownHost(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if s.hosts[host] {
+		return true
+	}
+	_, err := netip.ParseAddr(host)
+	return s.anyAddr && err == nil
+}
+
+// Error answered with a client error status
+type adminError struct{ error }
+
+// Error answered with a status of its own
+type adminStatusError struct {
+	status int
+	error
+}
+
+// Handler for a request that reads the load balancer
+func (s *adminServer) read(fn func(r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authorize(r); err != nil {
+			s.reply(w, nil, err)
+			return
+		}
+		s.mu.Lock()
+		v, err := fn(r)
+		s.mu.Unlock()
+		s.reply(w, v, err)
+	}
+}
+
+// Handler for a request that changes the load balancer. Such requests
+// have JSON bodies, which browsers only send across sites after asking the
+// server, and must come from the UI's own origin, so other sites cannot
+// make a browser change the load balancer.
+func (s *adminServer) write(fn func(r *http.Request, req adminRequest) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := s.request(w, r)
+		if err != nil {
+			s.reply(w, nil, err)
+			return
+		}
+		s.mu.Lock()
+		v, err := fn(r, req)
+		if s.changed != nil {
+			s.changed()
+		}
+		s.mu.Unlock()
+		s.reply(w, v, err)
+	}
+}
+
+// Check the token of an API request
+func (s *adminServer) authorize(r *http.Request) error {
+	if s.token == "" {
+		return nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		return adminStatusError{http.StatusUnauthorized, errors.New("missing or invalid token")}
+	}
+	return nil
+}
+
+// Check a request changing the load balancer and decode its body, which
+// may be empty
+func (s *adminServer) request(w http.ResponseWriter, r *http.Request) (adminRequest, error) {
+	var req adminRequest
+	if err := s.authorize(r); err != nil {
+		return req, err
+	}
+	if !sameOrigin(r) {
+		return req, adminStatusError{http.StatusForbidden, errors.New("cross-origin request")}
+	}
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		return req, adminStatusError{http.StatusUnsupportedMediaType, errors.New("request body must be application/json")}
+	}
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil && err != io.EOF {
+		return req, adminError{err}
+	}
+	return req, nil
+}
+
+// Whether a request comes from the origin it is sent to, as far as the
+// headers browsers set tell. Other clients send neither header.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (s *adminServer) reply(w http.ResponseWriter, v any, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		status := http.StatusInternalServerError
+		var serr adminStatusError
+		if errors.As(err, &serr) {
+			status = serr.status
+		} else if errors.As(err, new(adminError)) {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+
+		// Changes that failed partway send what they did along
+		body := map[string]any{"error": err.Error()}
+		if v != nil {
+			body["result"] = v
+		}
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *adminServer) nodes(*http.Request) (any, error) {
+	buckets := make(map[netip.Addr]int)
+	for node, bucket := range s.lb.Nodes() {
+		buckets[node.Name()] = bucket
+	}
+	var drains map[netip.Addr]DrainProgress
+	if d, ok := s.lb.(Drainer[netip.Addr, int]); ok {
+		drains = d.DrainStats()
+	}
+	var quarantined map[netip.Addr]time.Time
+	if r, ok := s.lb.(StatsReporter[netip.Addr, int]); ok {
+		quarantined = r.QuarantinedNodes()
+	}
+
+	nodes := []adminNode{}
+	for node, objects := range s.lb.ObjectsByNode() {
+		n := adminNode{Address: node.Name().String(), Bucket: -1, Weight: serverpool.Weight(node), Health: "up"}
+		if bucket, ok := buckets[node.Name()]; ok {
+			n.Bucket = bucket
+		}
+		for range objects {
+			n.Objects++
+		}
+		if d, ok := drains[node.Name()]; ok {
+			n.Health, n.Drain = "draining", &d
+		}
+		if until, ok := quarantined[node.Name()]; ok {
+			n.Health, n.QuarantinedUntil = "quarantined", &until
+		}
+		nodes = append(nodes, n)
+	}
+	slices.SortFunc(nodes, func(a, b adminNode) int {
+		return cmp.Or(cmp.Compare(uint(a.Bucket), uint(b.Bucket)), cmp.Compare(a.Address, b.Address))
+	})
+	return nodes, nil
+}
+
+func (s *adminServer) buckets(*http.Request) (any, error) {
+	buckets := []adminBucket{}
+	for bucket, node := range s.lb.Buckets() {
+		buckets = append(buckets, adminBucket{bucket, node.Name().String()})
+	}
+	slices.SortFunc(buckets, func(a, b adminBucket) int { return cmp.Compare(a.Bucket, b.Bucket) })
+	return buckets, nil
+}
+
+func (s *adminServer) rebalances(*http.Request) (any, error) {
+	r, err := capability[StatsReporter[netip.Addr, int]](s.lb)
+	if err != nil {
+		return nil, adminStatusError{http.StatusNotImplemented, err}
+	}
+	profiles := r.RebalanceProfiles()
+	if profiles == nil {
+		profiles = []RebalanceProfile{}
+	}
+	return profiles, nil
+}
+
+func (s *adminServer) decommissions(*http.Request) (any, error) {
+	r, err := capability[StatsReporter[netip.Addr, int]](s.lb)
+	if err != nil {
+		return nil, adminStatusError{http.StatusNotImplemented, err}
+	}
+	reports := r.DecommissionReports()
+	if reports == nil {
+		reports = []DecommissionReport[netip.Addr, int]{}
+	}
+	return reports, nil
+}
+
+func (s *adminServer) pins(*http.Request) (any, error) {
+	p, err := capability[Pinner[netip.Addr, int]](s.lb)
+	if err != nil {
+		return nil, adminStatusError{http.StatusNotImplemented, err}
+	}
+	pins := make(map[string]string)
+	for key, node := range p.PinnedKeys() {
+		pins[key] = node.String()
+	}
+	return pins, nil
+}
+
+func (s *adminServer) pinKey(r *http.Request, req adminRequest) (any, error) {
+	ip, err := nodeAddr(r, req)
+	if err != nil {
+		return nil, err
+	}
+	p, err := capability[Pinner[netip.Addr, int]](s.lb)
+	if err != nil {
+		return nil, adminStatusError{http.StatusNotImplemented, err}
+	}
+	node := NewServerNode[int](ip)
+	if err := p.PinKey(r.PathValue("key"), &node); err != nil {
+		return nil, adminError{err}
+	}
+	return map[string]string{"pinned": r.PathValue("key"), "node": ip.String()}, nil
+}
+
+func (s *adminServer) unpinKey(r *http.Request, _ adminRequest) (any, error) {
+	p, err := capability[Pinner[netip.Addr, int]](s.lb)
+	if err != nil {
+		return nil, adminStatusError{http.StatusNotImplemented, err}
+	}
+	if err := p.UnpinKey(r.PathValue("key")); err != nil {
+		return nil, adminError{err}
+	}
+	return map[string]string{"unpinned": r.PathValue("key")}, nil
+}
+
+func (s *adminServer) mapKey(r *http.Request) (any, error) {
+	key := r.FormValue("key")
+	if key == "" {
+		return nil, adminError{errors.New("key is required")}
+	}
+	candidates, err := s.lb.Candidates(key, 3)
+	if err != nil {
+		return nil, adminError{err}
+	}
+	node, err := s.lb.GetNode(key)
+	if err != nil {
+		return nil, adminError{err}
+	}
+	m := adminMapping{Key: key, Node: node.Name().String()}
+	for _, c := range candidates {
+		m.Candidates = append(m.Candidates, c.Name().String())
+	}
+	return m, nil
+}
+
+// Address of the node in the path, or the addr of the body
+func nodeAddr(r *http.Request, req adminRequest) (netip.Addr, error) {
+	addr := r.PathValue("addr")
+	if addr == "" {
+		addr = req.Addr
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Addr{}, adminError{err}
+	}
+	return ip, nil
+}
+
+func (s *adminServer) addNode(r *http.Request, req adminRequest) (any, error) {
+	ip, err := nodeAddr(r, req)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := addrs[ip]; ok {
+		return nil, adminError{errors.New("node already present")}
+	}
+	if req.Weight < 0 {
+		return nil, adminError{errors.New("invalid weight " + strconv.Itoa(req.Weight))}
+	}
+	node := NewWeightedServerNode[int](ip, max(req.Weight, 1))
+	result, err := s.lb.AddNodes([]serverpool.Node[netip.Addr, int]{&node})
+	if err != nil {
+		return nil, err
+	}
+	addrs[ip] = struct{}{}
+	return nodeResults(result), nil
+}
+
+func (s *adminServer) removeNode(r *http.Request, req adminRequest) (any, error) {
+	ip, err := nodeAddr(r, req)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := addrs[ip]; !ok {
+		return nil, adminError{errors.New("node not found")}
+	}
+	node := NewServerNode[int](ip)
+	result, err := s.lb.RemoveNodes([]serverpool.Node[netip.Addr, int]{&node})
+	reassigned := map[string]int{"reassigned": result.Reassigned()}
+
+	// The node is removed even if some of its objects were not reassigned
+	if errors.As(err, new(*ReassignmentError[int])) {
+		delete(addrs, ip)
+		return reassigned, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	delete(addrs, ip)
+	return reassigned, nil
+}
+
+func (s *adminServer) drainNode(r *http.Request, req adminRequest) (any, error) {
+	ip, err := nodeAddr(r, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Batch < 0 {
+		return nil, adminError{errors.New("invalid batch " + strconv.Itoa(req.Batch))}
+	}
+	batch := cmp.Or(req.Batch, 10)
+	var interval time.Duration
+	if i := req.Interval; i != "" {
+		if interval, err = time.ParseDuration(i); err != nil || interval <= 0 {
+			return nil, adminError{errors.New("invalid interval " + strconv.Quote(i))}
+		}
+	}
+	d, err := capability[Drainer[netip.Addr, int]](s.lb)
+	if err != nil {
+		return nil, adminStatusError{http.StatusNotImplemented, err}
+	}
+	node := NewServerNode[int](ip)
+	if err := d.DrainNode(&node, batch); err != nil {
+		return nil, adminError{err}
+	}
+
+	// Without an interval, objects move off on each Rebalance
+	if interval > 0 {
+		go func() {
+			if err := PaceDrain(context.Background(), s.lb, s.mu, &node, interval, s.changed); err != nil {
+				out.info("Drain of", ip, "stopped:", err)
+			}
+		}()
+	}
+	return map[string]string{"draining": ip.String()}, nil
+}
+
+func (s *adminServer) abortDrain(r *http.Request, req adminRequest) (any, error) {
+	ip, err := nodeAddr(r, req)
+	if err != nil {
+		return nil, err
+	}
+	d, err := capability[Drainer[netip.Addr, int]](s.lb)
+	if err != nil {
+		return nil, adminStatusError{http.StatusNotImplemented, err}
+	}
+	node := NewServerNode[int](ip)
+	if err := d.AbortDrain(&node); err != nil {
+		return nil, adminError{err}
+	}
+	return map[string]string{"restored": ip.String()}, nil
+}
+
+func (s *adminServer) automation(*http.Request) (any, error) {
+	return adminAutomation{Paused: automationPaused(s.lb)}, nil
+}
+
+func (s *adminServer) pauseAutomation(*http.Request, adminRequest) (any, error) {
+	if err := s.lb.PauseAutomation(); err != nil {
+		return nil, err
+	}
+	return adminAutomation{Paused: true}, nil
+}
+
+func (s *adminServer) resumeAutomation(*http.Request, adminRequest) (any, error) {
+	if err := s.lb.ResumeAutomation(); err != nil {
+		return nil, err
+	}
+	return adminAutomation{Paused: false}, nil
+}
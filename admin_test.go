@@ -0,0 +1,267 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"serverpool"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAdminServer(t *testing.T) {
+	addrs = make(map[netip.Addr]struct{})
+	lb := NewLoadBalancer[netip.Addr, int]()
+	var mu sync.Mutex
+	changes := 0
+	s := newAdminServer("localhost", "secret", &mu, lb, func() { changes++ })
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	// Changes send their parameters as JSON
+	send := func(method, path, contentType, body string, header http.Header) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header = header
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	auth := http.Header{"Authorization": {"Bearer secret"}}
+	do := func(method, path string, want int, v any, body ...string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(strings.Join(body, "")))
+		req.Header.Set("Authorization", "Bearer secret")
+		if method != http.MethodGet {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: expected status %d, got %d", method, path, want, resp.StatusCode)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+	}
+
+	do(http.MethodGet, "/", http.StatusOK, nil)
+	for _, addr := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		do(http.MethodPost, "/api/nodes", http.StatusOK, nil, `{"addr":"`+addr+`"}`)
+	}
+	do(http.MethodPost, "/api/nodes", http.StatusBadRequest, nil, `{"addr":"10.0.0.1"}`)
+	do(http.MethodPost, "/api/nodes", http.StatusBadRequest, nil, `{"addr":"bad"}`)
+	do(http.MethodPost, "/api/nodes", http.StatusBadRequest, nil, `{"address":"10.0.0.4"}`)
+	if lb.NodeCount() != 3 || changes != 5 {
+		t.Fatalf("expected 3 nodes after 5 changes, got %d after %d", lb.NodeCount(), changes)
+	}
+
+	// Requests without the token, forms other sites could post and requests
+	// from other origins are turned away before changing anything
+	if resp := send(http.MethodGet, "/api/nodes", "", "", http.Header{}); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a read without the token to be unauthorized, got %d", resp.StatusCode)
+	}
+	if resp := send(http.MethodPost, "/api/nodes", "application/json", `{"addr":"10.0.0.4"}`, http.Header{}); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a change without the token to be unauthorized, got %d", resp.StatusCode)
+	}
+	if resp := send(http.MethodPost, "/api/nodes", "application/x-www-form-urlencoded", "addr=10.0.0.4", auth.Clone()); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected a form to be rejected, got %d", resp.StatusCode)
+	}
+	crossSite := auth.Clone()
+	crossSite.Set("Origin", "https://evil.example")
+	if resp := send(http.MethodPost, "/api/nodes", "application/json", `{"addr":"10.0.0.4"}`, crossSite); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a cross-origin change to be forbidden, got %d", resp.StatusCode)
+	}
+	sameSite := auth.Clone()
+	sameSite.Set("Origin", ts.URL)
+	sameSite.Set("Sec-Fetch-Site", "same-origin")
+	if resp := send(http.MethodPost, "/api/nodes", "application/json", `{"addr":"10.0.0.4"}`, sameSite); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a change from the UI's origin to succeed, got %d", resp.StatusCode)
+	}
+	do(http.MethodDelete, "/api/nodes/10.0.0.4", http.StatusOK, nil)
+
+	// Pages of a site whose name was rebound to the server's address send
+	// its name as the host and their own origin
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req, _ := http.NewRequest(method, ts.URL+"/api/nodes", strings.NewReader(`{"addr":"10.0.0.4"}`))
+		req.Host = "evil.example:" + port
+		req.Header = sameSite.Clone()
+		req.Header.Set("Origin", "http://evil.example:"+port)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("%s: expected a request to a foreign host to be forbidden, got %d", method, resp.StatusCode)
+		}
+	}
+	if lb.NodeCount() != 3 || changes != 7 {
+		t.Fatalf("expected 3 nodes after 7 changes, got %d after %d", lb.NodeCount(), changes)
+	}
+
+	var mapping adminMapping
+	do(http.MethodGet, "/api/map?key=user42", http.StatusOK, &mapping)
+	if node, _ := lb.GetNode("user42"); mapping.Node != node.Name().String() || len(mapping.Candidates) != 3 {
+		t.Fatalf("expected user42 to map to %v, got %+v", node, mapping)
+	}
+	do(http.MethodGet, "/api/map", http.StatusBadRequest, nil)
+
+	var objects []*serverpool.Object[netip.Addr, int]
+	for i := 0; i < 60; i++ {
+		objects = append(objects, &serverpool.Object[netip.Addr, int]{Id: i})
+	}
+	if _, err := lb.AddObjects(objects); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objects {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	do(http.MethodPost, "/api/nodes/10.0.0.2/drain", http.StatusOK, nil, `{"batch":5}`)
+	var nodes []adminNode
+	do(http.MethodGet, "/api/nodes", http.StatusOK, &nodes)
+	if len(nodes) != 3 || nodes[2].Address != "10.0.0.2" || nodes[2].Health != "draining" || nodes[2].Bucket != -1 {
+		t.Fatalf("expected 10.0.0.2 draining last, got %+v", nodes)
+	}
+	do(http.MethodDelete, "/api/nodes/10.0.0.2/drain", http.StatusOK, nil)
+	do(http.MethodDelete, "/api/nodes/10.0.0.3", http.StatusOK, nil)
+	do(http.MethodDelete, "/api/nodes/10.0.0.3", http.StatusBadRequest, nil)
+
+	var buckets []adminBucket
+	do(http.MethodGet, "/api/buckets", http.StatusOK, &buckets)
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %+v", buckets)
+	}
+
+	var automation adminAutomation
+	do(http.MethodPost, "/api/automation/pause", http.StatusOK, nil)
+	do(http.MethodGet, "/api/automation", http.StatusOK, &automation)
+	if !automation.Paused || !lb.ChurnStats().AutomationPaused {
+		t.Fatalf("expected automation to be paused")
+	}
+	do(http.MethodPost, "/api/automation/resume", http.StatusOK, nil)
+	do(http.MethodGet, "/api/automation", http.StatusOK, &automation)
+	if automation.Paused {
+		t.Fatalf("expected automation to run again")
+	}
+}
+
+func TestAdminHosts(t *testing.T) {
+	var mu sync.Mutex
+	lb := NewLoadBalancer[netip.Addr, int]()
+	for _, addr := range []string{"0.0.0.0:0", "10.0.0.1:0", "admin.example:0"} {
+		if err := serveAdmin(addr, "", &mu, lb, nil); err == nil {
+			t.Fatalf("%s: expected a token required", addr)
+		}
+	}
+
+	tests := []struct {
+		listen string
+		host   string
+		want   bool
+	}{
+		{"localhost", "localhost:8080", true},
+		{"localhost", "LOCALHOST", true},
+		{"localhost", "127.0.0.1:8080", true},
+		{"localhost", "[::1]:8080", true},
+		{"localhost", "evil.example:8080", false},
+		{"localhost", "10.0.0.1:8080", false},
+		{"admin.example", "admin.example:8080", true},
+		{"admin.example", "evil.example:8080", false},
+		{"0.0.0.0", "10.0.0.1:8080", true},
+		{"0.0.0.0", "evil.example:8080", false},
+	}
+	for _, tt := range tests {
+		s := newAdminServer(tt.listen, "secret", &mu, lb, nil)
+		if got := s.knownHost(&http.Request{Host: tt.host}); got != tt.want {
+			t.Fatalf("listening on %s: expected host %s known %v, got %v", tt.listen, tt.host, tt.want, got)
+		}
+	}
+}
+
+func TestAdminRemoveNodeUnassigned(t *testing.T) {
+	addrs = make(map[netip.Addr]struct{})
+	lb := NewLoadBalancer[netip.Addr, int]()
+	var mu sync.Mutex
+	ts := httptest.NewServer(newAdminServer("localhost", "", &mu, lb, nil).handler())
+	defer ts.Close()
+	do := func(method, path string, body string) (*http.Response, any) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		var v any
+		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return resp, v
+	}
+	do(http.MethodPost, "/api/nodes", `{"addr":"10.0.0.1"}`)
+	obj := &serverpool.Object[netip.Addr, int]{Id: 1}
+	lb.AddObjects([]*serverpool.Object[netip.Addr, int]{obj})
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The last node is removed although its object has nowhere to go
+	resp, v := do(http.MethodDelete, "/api/nodes/10.0.0.1", "")
+	if m, _ := v.(map[string]any); resp.StatusCode != http.StatusInternalServerError || m["error"] == nil || m["result"] == nil {
+		t.Fatalf("expected an error with the result, got %d %v", resp.StatusCode, v)
+	}
+	if _, ok := addrs[netip.MustParseAddr("10.0.0.1")]; ok || lb.NodeCount() != 0 {
+		t.Fatalf("expected the node to be gone")
+	}
+	if resp, _ := do(http.MethodPost, "/api/nodes", `{"addr":"10.0.0.1"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the node to be added again, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminUnsupported(t *testing.T) {
+	var mu sync.Mutex
+	lb := coreLoadBalancer[netip.Addr, int]{NewLoadBalancer[netip.Addr, int]()}
+	ts := httptest.NewServer(newAdminServer("localhost", "", &mu, lb, nil).handler())
+	defer ts.Close()
+
+	// Pages of capabilities the load balancer lacks are not implemented
+	resp, err := http.Get(ts.URL + "/api/pins")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("expected pins not implemented, got %d", resp.StatusCode)
+	}
+
+	// The nodes are listed without drains or quarantines
+	if resp, err = http.Get(ts.URL + "/api/nodes"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the nodes listed, got %d", resp.StatusCode)
+	}
+}
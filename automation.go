@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Pausing automatic actions during incidents
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+)
+
+// ErrAutomationPaused is returned by automatic actions skipped while
+// automation is paused
+var ErrAutomationPaused = errors.New("automation is paused")
+
+// PauseAutomation stops the load balancer and what drives it from acting on
+// their own: cooperative rebalancing only moves the objects of removed
+// nodes, health checks keep probing but neither take nodes out nor put them
+// back, claims do not expire and SyncNodes leaves the nodes as they are.
+// Manual operations still work. Pausing twice has no effect.
+func (lb *loadBalancer[T, O]) PauseAutomation() error {
+	if lb.dryRun || lb.paused {
+		return nil
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	lb.paused = true
+	lb.record(Change[T, O]{Op: ChangePauseAutomation})
+	return nil
+}
+
+// ResumeAutomation ends a pause and moves the objects cooperative
+// rebalancing held back, publishing them as assignments
+func (lb *loadBalancer[T, O]) ResumeAutomation() error {
+	if lb.dryRun || !lb.paused {
+		return nil
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	lb.paused = false
+	lb.record(Change[T, O]{Op: ChangeResumeAutomation})
+	if lb.cooperative == nil {
+		return nil
+	}
+
+	var errs ReassignmentError[O]
+	planned, _ := lb.planRebalance()
+	lb.rebalanceCooperatively(&errs)
+
+	var moved []*serverpool.Object[T, O]
+	for _, m := range planned {
+		if node := m.Object.Node(); node != nil && *node == m.To {
+			moved = append(moved, m.Object)
+		}
+	}
+	lb.publish(ChangeAssignObject, nil, moved)
+
+	if len(errs.Errors) > 0 {
+		return &errs
+	}
+	return nil
+}
+
+// Whether automatic actions on lb are paused
+func automationPaused[T, O comparable](lb LoadBalancer[T, O]) bool {
+	return lb.ChurnStats().AutomationPaused
+}
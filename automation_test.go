@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestPauseAutomation(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithCooperativeRebalance(RebalanceCallbacks[string, string]{}))
+	mirror := newMirror(t, lb)
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	node1, node2 := newNode("node1"), newNode("node2")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 30; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if err := lb.PauseAutomation(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !lb.ChurnStats().AutomationPaused || !mirror.ChurnStats().AutomationPaused {
+		t.Fatalf("expected automation to be paused")
+	}
+
+	// Adding a node moves nothing, removing one still moves its objects
+	node3 := newNode("node3")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node3}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(node3.objects) != 0 {
+		t.Fatalf("expected no objects to move while paused")
+	}
+	if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{node1}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if *obj.Node() == node1 {
+			t.Fatalf("expected %v to move off the removed node", obj)
+		}
+	}
+
+	if err := lb.ResumeAutomation(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if want, _ := lb.GetNode(obj.Name()); *obj.Node() != want {
+			t.Fatalf("expected %v on %v after resuming, got %v", obj, want, *obj.Node())
+		}
+	}
+	if lb.ChurnStats().AutomationPaused || mirror.ChurnStats().AutomationPaused || mirror.Version() != lb.Version() {
+		t.Fatalf("expected the mirror to follow the resume")
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Recommendations to add or remove nodes to keep utilization in a band
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"serverpool"
+	"time"
+)
+
+// ScalingPolicy tells a ScalingAdvisor how loaded nodes are and how loaded
+// they should be
+type ScalingPolicy[T, O comparable] struct {
+	// Load an object puts on its node, 1 if nil
+	Load func(obj *serverpool.Object[T, O]) float64
+
+	// Load a node can take, required
+	Capacity func(node serverpool.Node[T, O]) float64
+
+	// Utilization band to keep the nodes in, such as 0.5 and 0.8
+	Low, High float64
+
+	// Utilization to size the nodes for when leaving the band, the middle
+	// of the band if 0
+	Target float64
+
+	// Evaluations in a row outside the band before scaling is advised, so
+	// short spikes do not cause scaling. 1 if 0.
+	Sustain int
+
+	// Time after advising to scale during which no further scaling is
+	// advised, to let new nodes take load
+	CoolDown time.Duration
+
+	// Bounds on the number of nodes, no upper bound if MaxNodes is 0
+	MinNodes, MaxNodes int
+
+	// Called with every advice to scale, such as a ScalingWebhook
+	Trigger func(ScalingAdvice) error
+}
+
+// ScalingAdvice is the outcome of an evaluation
+type ScalingAdvice struct {
+	Time time.Time `json:"time"`
+
+	// Nodes taking keys and their utilization
+	Nodes       int     `json:"nodes"`
+	Utilization float64 `json:"utilization"`
+
+	// Nodes to add, or remove if negative, 0 to stay as is
+	Delta int `json:"delta"`
+
+	// Why the advice was given
+	Reason string `json:"reason"`
+}
+
+// ScalingAdvisor evaluates the utilization of the nodes of a load balancer
+// and advises adding or removing nodes to keep it within a band
+type ScalingAdvisor[T, O comparable] struct {
+	policy ScalingPolicy[T, O]
+
+	// Evaluations in a row above and below the band
+	above, below int
+
+	// When scaling was last advised
+	advised time.Time
+
+	// Clock, time.Now if nil
+	now func() time.Time
+}
+
+// Create an advisor for the policy
+func NewScalingAdvisor[T, O comparable](policy ScalingPolicy[T, O]) (*ScalingAdvisor[T, O], error) {
+	if policy.Capacity == nil {
+		return nil, errors.New("scaling policy needs a capacity")
+	}
+	if policy.Low < 0 || policy.High <= policy.Low {
+		return nil, fmt.Errorf("invalid utilization band [%v, %v]", policy.Low, policy.High)
+	}
+	if policy.Target == 0 {
+		policy.Target = (policy.Low + policy.High) / 2
+	}
+	if policy.Target <= 0 {
+		return nil, fmt.Errorf("invalid target utilization %v", policy.Target)
+	}
+	return &ScalingAdvisor[T, O]{policy: policy}, nil
+}
+
+// Evaluate the current utilization and advise how many nodes to add or
+// remove. The advice is passed to the policy's Trigger if it is to scale.
+func (a *ScalingAdvisor[T, O]) Evaluate(lb LoadBalancer[T, O]) (ScalingAdvice, error) {
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	p := a.policy
+
+	var load, capacity float64
+	nodes := 0
+	for node := range lb.Nodes() {
+		nodes++
+		capacity += p.Capacity(node)
+		for obj := range node.Objects() {
+			if p.Load != nil {
+				load += p.Load(obj)
+			} else {
+				load++
+			}
+		}
+	}
+	advice := ScalingAdvice{Time: now, Nodes: nodes}
+	if nodes == 0 || capacity <= 0 {
+		advice.Reason = "no capacity"
+		return advice, nil
+	}
+	advice.Utilization = load / capacity
+
+	switch {
+	case advice.Utilization > p.High:
+		a.above, a.below = a.above+1, 0
+	case advice.Utilization < p.Low:
+		a.above, a.below = 0, a.below+1
+	default:
+		a.above, a.below = 0, 0
+		advice.Reason = "within band"
+		return advice, nil
+	}
+
+	sustain := max(p.Sustain, 1)
+	switch {
+	case a.above < sustain && a.below < sustain:
+		advice.Reason = "waiting for sustained breach"
+		return advice, nil
+	case !a.advised.IsZero() && now.Before(a.advised.Add(p.CoolDown)):
+		advice.Reason = "cooling down"
+		return advice, nil
+	}
+
+	// Size the nodes for the target utilization at their average capacity
+	want := int(math.Ceil(load / (capacity / float64(nodes) * p.Target)))
+	want = max(want, p.MinNodes, 1)
+	if p.MaxNodes > 0 {
+		want = min(want, p.MaxNodes)
+	}
+	advice.Delta = want - nodes
+	if advice.Delta == 0 {
+		advice.Reason = "at node limit"
+		return advice, nil
+	}
+	if advice.Delta > 0 {
+		advice.Reason = fmt.Sprintf("utilization %.2f above %.2f", advice.Utilization, p.High)
+	} else {
+		advice.Reason = fmt.Sprintf("utilization %.2f below %.2f", advice.Utilization, p.Low)
+	}
+	a.advised, a.above, a.below = now, 0, 0
+
+	if p.Trigger != nil {
+		if err := p.Trigger(advice); err != nil {
+			return advice, fmt.Errorf("scaling trigger: %w", err)
+		}
+	}
+	return advice, nil
+}
+
+// ScalingWebhook posts each advice as JSON to url, for a policy's Trigger
+func ScalingWebhook(url string, client *http.Client) func(ScalingAdvice) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(advice ScalingAdvice) error {
+		body, err := json.Marshal(advice)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+}
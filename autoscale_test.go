@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestScalingAdvisor(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	var nodes []serverpool.Node[string, string]
+	for i := 0; i < 4; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i),
+			objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	next := 0
+	addObjects := func(n int) {
+		t.Helper()
+		var objs []*serverpool.Object[string, string]
+		for ; n > 0; n-- {
+			objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", next)})
+			next++
+		}
+		if _, err := lb.AddObjects(objs); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, obj := range objs {
+			if err := lb.AssignObject(obj); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+	}
+
+	var triggered []ScalingAdvice
+	advisor, err := NewScalingAdvisor(ScalingPolicy[string, string]{
+		Capacity: func(serverpool.Node[string, string]) float64 { return 10 },
+		Low:      0.25, High: 0.75, Sustain: 2, CoolDown: time.Minute, MinNodes: 2,
+		Trigger: func(a ScalingAdvice) error { triggered = append(triggered, a); return nil }})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	now := time.Unix(0, 0)
+	advisor.now = func() time.Time { return now }
+
+	// Half full is within the band
+	addObjects(20)
+	if advice, _ := advisor.Evaluate(lb); advice.Delta != 0 || advice.Utilization != 0.5 {
+		t.Fatalf("expected no scaling at 0.5, got %+v", advice)
+	}
+
+	// Full has to last two evaluations, then nodes are sized for 0.5
+	addObjects(20)
+	if advice, _ := advisor.Evaluate(lb); advice.Delta != 0 {
+		t.Fatalf("expected to wait for a sustained breach, got %+v", advice)
+	}
+	if advice, _ := advisor.Evaluate(lb); advice.Delta != 4 || len(triggered) != 1 {
+		t.Fatalf("expected 4 nodes to be added, got %+v", advice)
+	}
+
+	// No further advice while cooling down
+	advisor.Evaluate(lb)
+	if advice, _ := advisor.Evaluate(lb); advice.Delta != 0 || advice.Reason != "cooling down" {
+		t.Fatalf("expected a cool-down, got %+v", advice)
+	}
+	now = now.Add(time.Minute)
+	if advice, _ := advisor.Evaluate(lb); advice.Delta != 4 || len(triggered) != 2 {
+		t.Fatalf("expected 4 nodes to be added after the cool-down, got %+v", advice)
+	}
+
+	// The webhook gets the advice as JSON
+	var got ScalingAdvice
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+	if err := ScalingWebhook(server.URL, nil)(triggered[0]); err != nil || got.Delta != 4 {
+		t.Fatalf("expected the advice to be posted, got %+v, %v", got, err)
+	}
+}
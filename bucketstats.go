@@ -0,0 +1,275 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Statistics of the lookups and objects of each bucket and alarms on
+// buckets taking far more than their share
+
+package main
+
+import (
+	"cmp"
+	"maps"
+	"serverpool"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Types of events raised when a bucket becomes skewed, only delivered to
+// subscriptions
+const (
+	EventBucketHitsSkewed    = "BucketHitsSkewed"
+	EventBucketObjectsSkewed = "BucketObjectsSkewed"
+)
+
+// DefaultBucketSkew is the multiple of the mean beyond which a bucket is
+// skewed, if BucketStatsConfig.Threshold is not set
+const DefaultBucketSkew = 2.0
+
+// DefaultBucketMinHits is the number of sampled lookups before buckets are
+// judged on their hits, if BucketStatsConfig.MinHits is not set
+const DefaultBucketMinHits = 1000
+
+// BucketStatsConfig configures the statistics kept on each bucket
+type BucketStatsConfig struct {
+	// One in SampleRate lookups is counted, every lookup if 1 or less
+	SampleRate int
+
+	// Multiple of the mean hits or objects of the buckets beyond which a
+	// bucket is skewed, DefaultBucketSkew if 0
+	Threshold float64
+
+	// Sampled lookups before buckets are judged on their hits, so the first
+	// few lookups do not raise alarms, DefaultBucketMinHits if 0
+	MinHits int
+}
+
+// BucketStat reports the sampled lookups and the objects of a bucket
+type BucketStat[T comparable] struct {
+	Bucket int
+	Node   T
+
+	// Lookups sampled since the bucket was first hit
+	Hits uint64
+
+	// Objects on the node of the bucket, split evenly between the buckets
+	// of a node owning several
+	Objects int
+
+	// Hits or objects are beyond the threshold
+	HitsSkewed    bool
+	ObjectsSkewed bool
+}
+
+// BucketStats reports the statistics of each bucket, in bucket order, and
+// their spread
+type BucketStats[T comparable] struct {
+	Buckets []BucketStat[T]
+
+	HitMean, HitVariance       float64
+	ObjectMean, ObjectVariance float64
+}
+
+// Statistics kept on the buckets of a load balancer
+type bucketStats struct {
+	// Statistics are off if nil
+	config *BucketStatsConfig
+
+	// Lookups seen, sampled or not
+	lookups atomic.Uint64
+
+	// Lookups update the hits while holding no more than the read lock
+	mu    sync.Mutex
+	hits  map[int]uint64
+	total uint64
+
+	// Buckets currently beyond the threshold, alarms are raised once as a
+	// bucket crosses it
+	hitsSkewed    map[int]bool
+	objectsSkewed map[int]bool
+}
+
+func newBucketStats(config BucketStatsConfig) bucketStats {
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultBucketSkew
+	}
+	if config.MinHits <= 0 {
+		config.MinHits = DefaultBucketMinHits
+	}
+	return bucketStats{config: &config, hits: make(map[int]uint64),
+		hitsSkewed: make(map[int]bool), objectsSkewed: make(map[int]bool)}
+}
+
+// Count the lookup and tell if it is sampled
+func (s *bucketStats) sample() bool {
+	if s.config == nil {
+		return false
+	}
+	n := s.lookups.Add(1)
+	return s.config.SampleRate <= 1 || n%uint64(s.config.SampleRate) == 0
+}
+
+// Skewed reports whether value is beyond the threshold over mean, and if
+// it just crossed it given whether it was beyond it before
+func (s *bucketStats) skewed(value, mean float64, was bool) (skewed, crossed bool) {
+	skewed = mean > 0 && value > s.config.Threshold*mean
+	return skewed, skewed && !was
+}
+
+// Map a key to a node for a lookup, counting the hit of its bucket if the
+// lookup is sampled. Pinned keys count for no bucket.
+func (lb *loadBalancer[T, O]) lookupKey(key string) (serverpool.Node[T, O], error) {
+	node, bucket, err := lb.mapKeyBucket(key)
+	if err == nil && bucket >= 0 && lb.bucketStats.sample() {
+		lb.hitBucket(bucket)
+	}
+	return node, err
+}
+
+// Count a hit of the bucket and raise an alarm if it takes more than its
+// share of the lookups
+func (lb *loadBalancer[T, O]) hitBucket(bucket int) {
+	s := &lb.bucketStats
+
+	s.mu.Lock()
+	s.hits[bucket]++
+	s.total++
+	mean := float64(s.total) / float64(lb.ch.Size())
+	skewed, crossed := s.skewed(float64(s.hits[bucket]), mean, s.hitsSkewed[bucket])
+	if s.total < uint64(s.config.MinHits) {
+		skewed, crossed = false, false
+	}
+	if skewed {
+		s.hitsSkewed[bucket] = true
+	} else {
+		delete(s.hitsSkewed, bucket)
+	}
+	s.mu.Unlock()
+
+	if crossed {
+		lb.notifySkewed(EventBucketHitsSkewed, bucket)
+	}
+}
+
+// Objects of each bucket, the objects of a node owning several buckets
+// split evenly between them
+func (lb *loadBalancer[T, O]) bucketObjects() map[int]int {
+	owned := make(map[T][]int)
+	for bucket, node := range lb.sp.Buckets() {
+		owned[node.Name()] = append(owned[node.Name()], bucket)
+	}
+	objects := make(map[int]int)
+	for node := range lb.sp.Nodes() {
+		buckets := owned[node.Name()]
+		slices.Sort(buckets)
+		n := 0
+		for range node.Objects() {
+			n++
+		}
+		for i, bucket := range buckets {
+			objects[bucket] = n / len(buckets)
+			if i < n%len(buckets) {
+				objects[bucket]++
+			}
+		}
+	}
+	return objects
+}
+
+// Raise alarms on the buckets taking more than their share of the objects
+// after a change, and forget the hits of buckets that are gone
+func (lb *loadBalancer[T, O]) checkBuckets() {
+	s := &lb.bucketStats
+	if s.config == nil {
+		return
+	}
+	objects := lb.bucketObjects()
+	mean, variance := spread(slices.Collect(maps.Values(objects)))
+	if m := lb.profiler.metrics; m != nil {
+		m.objectVariance.Set(variance)
+	}
+
+	var crossed []int
+	for bucket, n := range objects {
+		skewed, c := s.skewed(float64(n), mean, s.objectsSkewed[bucket])
+		if skewed {
+			s.objectsSkewed[bucket] = true
+		} else {
+			delete(s.objectsSkewed, bucket)
+		}
+		if c {
+			crossed = append(crossed, bucket)
+		}
+	}
+	maps.DeleteFunc(s.objectsSkewed, func(bucket int, _ bool) bool { _, ok := objects[bucket]; return !ok })
+
+	s.mu.Lock()
+	for bucket, hits := range s.hits {
+		if _, ok := objects[bucket]; !ok {
+			s.total -= hits
+			delete(s.hits, bucket)
+			delete(s.hitsSkewed, bucket)
+		}
+	}
+	s.mu.Unlock()
+
+	slices.Sort(crossed)
+	for _, bucket := range crossed {
+		lb.notifySkewed(EventBucketObjectsSkewed, bucket)
+	}
+}
+
+// Mean and variance of values
+func spread[V int | uint64](values []V) (mean, variance float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sum, squares := 0.0, 0.0
+	for _, v := range values {
+		sum += float64(v)
+		squares += float64(v) * float64(v)
+	}
+	mean = sum / float64(len(values))
+	return mean, squares/float64(len(values)) - mean*mean
+}
+
+// Tell the subscriptions and the metrics of a bucket becoming skewed
+func (lb *loadBalancer[T, O]) notifySkewed(event string, bucket int) {
+	if m := lb.profiler.metrics; m != nil {
+		m.skewAlarm(event)
+	}
+	node, ok := lb.sp.GetNode(bucket)
+	if !ok {
+		return
+	}
+	lb.emit(Event[T, O]{Type: event, Time: lb.churn.clock(), Node: node, Bucket: bucket})
+}
+
+// BucketStats reports the sampled lookups and the objects of each bucket.
+// It is empty unless the load balancer was created WithBucketStats.
+func (lb *loadBalancer[T, O]) BucketStats() BucketStats[T] {
+	s := &lb.bucketStats
+	if s.config == nil {
+		return BucketStats[T]{}
+	}
+	objects := lb.bucketObjects()
+
+	var stats BucketStats[T]
+	s.mu.Lock()
+	for bucket, node := range lb.sp.Buckets() {
+		stats.Buckets = append(stats.Buckets, BucketStat[T]{Bucket: bucket, Node: node.Name(),
+			Hits: s.hits[bucket], Objects: objects[bucket],
+			HitsSkewed: s.hitsSkewed[bucket], ObjectsSkewed: s.objectsSkewed[bucket]})
+	}
+	s.mu.Unlock()
+	slices.SortFunc(stats.Buckets, func(a, b BucketStat[T]) int { return cmp.Compare(a.Bucket, b.Bucket) })
+
+	hits := make([]uint64, len(stats.Buckets))
+	for i, b := range stats.Buckets {
+		hits[i] = b.Hits
+	}
+	stats.HitMean, stats.HitVariance = spread(hits)
+	stats.ObjectMean, stats.ObjectVariance = spread(slices.Collect(maps.Values(objects)))
+	return stats
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"consistenthash"
+	"serverpool"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucketStats(t *testing.T) {
+	prom := NewPrometheusMetrics()
+	lb := NewConcurrentLoadBalancer(WithBucketStats[string, string](BucketStatsConfig{SampleRate: 2, Threshold: 1.5, MinHits: 10}),
+		WithMetrics[string, string](prom))
+	ch, watch := lb.Watch(0, EventBucketHitsSkewed, EventBucketObjectsSkewed)
+	defer watch.Unsubscribe()
+
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Every object is placed on node1
+	objs := []*serverpool.Object[string, string]{
+		{Id: "obj1", Preferred: []string{"node1"}}, {Id: "obj2", Preferred: []string{"node1"}}}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// Every lookup hits the same bucket, one in two is sampled
+	for range 40 {
+		if _, err := lb.GetNode("key"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	next := func() Event[string, string] {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected an event")
+		}
+		return Event[string, string]{}
+	}
+	node, _ := lb.GetNode("key")
+	for _, want := range []struct {
+		Type string
+		Node serverpool.Node[string, string]
+	}{{EventBucketObjectsSkewed, node1}, {EventBucketHitsSkewed, node}} {
+		if e := next(); e.Type != want.Type || e.Node != want.Node {
+			t.Fatalf("expected %s on %v, got %s on %v", want.Type, want.Node.Name(), e.Type, e.Node.Name())
+		}
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("expected alarms to be raised once, got %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	stats := lb.(StatsReporter[string, string]).BucketStats()
+	if len(stats.Buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %v", stats.Buckets)
+	}
+	hits, objects := 0, 0
+	for _, b := range stats.Buckets {
+		hits += int(b.Hits)
+		objects += b.Objects
+		if b.Node == "node1" && !b.ObjectsSkewed {
+			t.Fatalf("expected the bucket of node1 to be skewed, got %+v", b)
+		}
+	}
+	if hits != 20 || objects != 2 {
+		t.Fatalf("expected 20 hits and 2 objects, got %d and %d", hits, objects)
+	}
+	if stats.ObjectMean != 1 || stats.ObjectVariance != 1 || stats.HitMean != 10 || stats.HitVariance != 100 {
+		t.Fatalf("expected means 1 and 10 and variances 1 and 100, got %+v", stats)
+	}
+
+	var exposition strings.Builder
+	prom.WriteTo(&exposition)
+	for _, line := range []string{
+		`loadbalance_bucket_skew_alarms_total{measure="hits"} 1`,
+		`loadbalance_bucket_skew_alarms_total{measure="objects"} 1`,
+		"loadbalance_bucket_object_variance 1\n",
+	} {
+		if !strings.Contains(exposition.String(), line) {
+			t.Fatalf("expected %q in\n%s", line, exposition.String())
+		}
+	}
+}
+
+// Hasher counting the keys it hashes
+type countingHasher struct {
+	consistenthash.ConsistentHasher
+	lookups int
+}
+
+func (h *countingHasher) GetBucket(key string) int {
+	h.lookups++
+	return h.ConsistentHasher.GetBucket(key)
+}
+
+func TestBucketStatsHashOnce(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithBucketStats[string, string](BucketStatsConfig{SampleRate: 1})).(*loadBalancer[string, string])
+	node := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	h := &countingHasher{ConsistentHasher: lb.ch}
+	lb.ch = h
+
+	// Sampled lookups count the bucket the lookup hashed the key to
+	if _, err := lb.GetNode("key"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if h.lookups != 1 {
+		t.Fatalf("expected the key hashed once, got %d", h.lookups)
+	}
+	if stats := lb.BucketStats(); len(stats.Buckets) != 1 || stats.Buckets[0].Hits != 1 {
+		t.Fatalf("expected a hit of the bucket, got %+v", stats)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Deterministic order of fallback nodes per key
+
+package main
+
+import (
+	"cmp"
+	"consistenthash"
+	"errors"
+	"fmt"
+	"hashing"
+	"serverpool"
+	"slices"
+)
+
+var rendezvousHash = hashing.NewHashFunction(hashing.DefaultHashAlgorithm)
+
+// Rendezvous score of a node for a key, the node with the highest score wins
+func rendezvousScore[T comparable](name T, key string) uint64 {
+	return rendezvousHash.HashString(fmt.Sprint(name) + "\x00" + key)
+}
+
+// GetNodes returns up to n distinct nodes for key, fewer if there are
+// fewer nodes: the node GetNode maps the key to, then the nodes of the
+// buckets the hasher's GetBuckets returns, or with nodes holding several
+// buckets, of the buckets the replica keys of the key map to. Unlike
+// Candidates, the replicas follow the hasher, so thin clients holding a
+// topology snapshot compute the same nodes. If the hasher runs out before
+// n nodes are found, the rest are taken in Candidates order.
+func (lb *loadBalancer[T, O]) GetNodes(key string, n int) ([]serverpool.Node[T, O], error) {
+	if n <= 0 {
+		return nil, errors.New("number of nodes must be positive")
+	}
+	first, err := lb.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	replicaKey := key
+	if lb.normalize != nil {
+		replicaKey = lb.normalize(key)
+	}
+	n = min(n, lb.sp.Len())
+	nodes := []serverpool.Node[T, O]{first}
+	if lb.sp.Len() == lb.ch.Size() {
+		for _, bucket := range lb.ch.GetBuckets(replicaKey, n) {
+			if node, ok := lb.sp.GetNode(bucket); ok && len(nodes) < n && !slices.Contains(nodes, node) {
+				nodes = append(nodes, node)
+			}
+		}
+	} else {
+		nodes = consistenthash.AppendReplicas(nodes, replicaKey, n,
+			func(key string) (serverpool.Node[T, O], bool) { return lb.sp.GetNode(lb.ch.GetBucket(key)) })
+	}
+	if len(nodes) < n {
+		candidates, err := lb.Candidates(key, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range candidates {
+			if len(nodes) < n && !slices.Contains(nodes, c) {
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// Candidates returns up to n nodes for key in order of preference, or every
+// node if n is 0: the node GetNode maps the key to, then the others ranked
+// by rendezvous hashing. The order only depends on the key and the names of
+// the nodes, so routers with the same nodes agree on the second and third
+// choice for retries and replicas without coordinating, and removing a node
+// keeps the order of the others. With failure domains, each of the first n
+// nodes after the first is instead the one that spreads the most from the
+// nodes before it, in rendezvous order among equals.
+func (lb *loadBalancer[T, O]) Candidates(key string, n int) ([]serverpool.Node[T, O], error) {
+	first, err := lb.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if lb.normalize != nil {
+		key = lb.normalize(key)
+	}
+
+	type ranked struct {
+		node  serverpool.Node[T, O]
+		score uint64
+	}
+	var rest []ranked
+	for node := range lb.sp.Nodes() {
+		if node.Name() != first.Name() {
+			rest = append(rest, ranked{node, rendezvousScore(node.Name(), key)})
+		}
+	}
+	slices.SortFunc(rest, func(a, b ranked) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(fmt.Sprint(a.node.Name()), fmt.Sprint(b.node.Name())))
+	})
+
+	if n <= 0 || n > len(rest)+1 {
+		n = len(rest) + 1
+	}
+	candidates := []serverpool.Node[T, O]{first}
+	for _, r := range rest {
+		candidates = append(candidates, r.node)
+	}
+	lb.domains.spread(candidates, n)
+	return candidates[:n], nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"consistenthash"
+	"fmt"
+	"net/netip"
+	"serverpool"
+	"slices"
+	"testing"
+)
+
+func TestCandidates(t *testing.T) {
+	newLB := func() LoadBalancer[string, string] {
+		lb := NewLoadBalancer[string, string]()
+		var nodes []serverpool.Node[string, string]
+		for i := 0; i < 6; i++ {
+			nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i)})
+		}
+		if _, err := lb.AddNodes(nodes); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return lb
+	}
+	names := func(nodes []serverpool.Node[string, string]) []string {
+		var s []string
+		for _, n := range nodes {
+			s = append(s, n.Name())
+		}
+		return s
+	}
+
+	// Routers with the same nodes agree on the order
+	lb1, lb2 := newLB(), newLB()
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		c1, err := lb1.Candidates(key, 0)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		c2, _ := lb2.Candidates(key, 3)
+		first, _ := lb1.GetNode(key)
+		if len(c1) != 6 || c1[0] != first || !slices.Equal(names(c1[:3]), names(c2)) {
+			t.Fatalf("expected %v to start with %v and %v", names(c1), first, names(c2))
+		}
+
+		// Removing a fallback keeps the order of the rest
+		removed := c1[len(c1)-1]
+		lb3 := newLB()
+		if _, err := lb3.RemoveNodes([]serverpool.Node[string, string]{removed}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		c3, _ := lb3.Candidates(key, 0)
+		if !slices.Equal(names(c3), names(c1[:len(c1)-1])) {
+			t.Fatalf("expected %v, got %v", names(c1[:len(c1)-1]), names(c3))
+		}
+	}
+}
+
+func TestGetNodes(t *testing.T) {
+	lb := NewLoadBalancer[netip.Addr, int]()
+	if _, err := lb.GetNodes("key", 2); err != ErrClusterUnavailable {
+		t.Fatalf("expected ErrClusterUnavailable, got %v", err)
+	}
+	big := NewWeightedServerNode[int](netip.MustParseAddr("10.0.0.1"), 4)
+	nodes := []serverpool.Node[netip.Addr, int]{&big}
+	for i := 2; i <= 5; i++ {
+		node := NewServerNode[int](netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}))
+		nodes = append(nodes, &node)
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	topology, err := lb.Topology()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	lookup, err := consistenthash.NewTopologyLookup(topology)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for i := range 200 {
+		key := fmt.Sprintf("key%d", i)
+		got, err := lb.GetNodes(key, 3)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		first, _ := lb.GetNode(key)
+		if len(got) != 3 || got[0] != first {
+			t.Fatalf("expected 3 nodes starting with %v, got %v", first, got)
+		}
+		// Distinct nodes even though the weighted node has several buckets
+		var names []string
+		for _, node := range got {
+			names = append(names, node.Name().String())
+		}
+		if len(slices.Compact(slices.Sorted(slices.Values(names)))) != 3 {
+			t.Fatalf("expected distinct nodes, got %v", names)
+		}
+		// Thin clients compute the same replicas
+		if remote := lookup.GetNodes(key, 3); !slices.Equal(remote, names) {
+			t.Fatalf("expected %v from the topology, got %v", names, remote)
+		}
+	}
+
+	// Asking for more nodes than there are returns them all
+	all, err := lb.GetNodes("key", 10)
+	if err != nil || len(all) != 5 {
+		t.Fatalf("expected all 5 nodes, got %v, %v", all, err)
+	}
+	if _, err := lb.GetNodes("key", 0); err == nil {
+		t.Fatal("expected an error for 0 nodes")
+	}
+}
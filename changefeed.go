@@ -0,0 +1,226 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Change feed of mutations applied to a load balancer
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+	"slices"
+	"time"
+)
+
+// ChangeOp identifies the mutation recorded in a change
+type ChangeOp int
+
+const (
+	ChangeAddNodes ChangeOp = iota + 1
+	ChangeRemoveNodes
+	ChangeAddObjects
+	ChangeRemoveObjects
+	ChangeAssignObject
+	ChangeUnassignObject
+	ChangeTransferObject
+	ChangeBatch
+	ChangeDrainNode
+	ChangePauseAutomation
+	ChangeResumeAutomation
+	ChangePinKey
+	ChangeUnpinKey
+	ChangeDialTraffic
+	ChangeRollbackDial
+)
+
+var changeOpNames = map[ChangeOp]string{
+	ChangeAddNodes:         "AddNodes",
+	ChangeRemoveNodes:      "RemoveNodes",
+	ChangeAddObjects:       "AddObjects",
+	ChangeRemoveObjects:    "RemoveObjects",
+	ChangeAssignObject:     "AssignObject",
+	ChangeUnassignObject:   "UnassignObject",
+	ChangeTransferObject:   "TransferObject",
+	ChangeBatch:            "Batch",
+	ChangeDrainNode:        "DrainNode",
+	ChangePauseAutomation:  "PauseAutomation",
+	ChangeResumeAutomation: "ResumeAutomation",
+	ChangePinKey:           "PinKey",
+	ChangeUnpinKey:         "UnpinKey",
+	ChangeDialTraffic:      "DialTraffic",
+	ChangeRollbackDial:     "RollbackDial",
+}
+
+func (op ChangeOp) String() string {
+	if name, ok := changeOpNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("ChangeOp(%d)", int(op))
+}
+
+// Change is a single successful mutation of a load balancer.
+// Replaying changes in version order on an empty load balancer
+// reproduces the same bucket history and key to node mapping.
+type Change[T, O comparable] struct {
+	// Position of the change in the feed, starting at 1
+	Version uint64
+
+	// When the change was applied, zero for changes of a transaction. A
+	// dial starts at this time.
+	Time time.Time
+
+	// Mutation that was applied
+	Op ChangeOp
+
+	// Nodes added or removed, in the order they were applied, the
+	// destination of a transfer, the node being drained or the node a key
+	// is pinned to, or the source and the target of a dial
+	Nodes []serverpool.Node[T, O]
+
+	// Keys pinned or unpinned
+	Keys []string
+
+	// Schedule of a dial started
+	Schedule []DialStep
+
+	// Ids of the objects added, removed, assigned, unassigned or moved off
+	// a draining node, or whose moves adding or removing nodes deferred
+	Objects []O
+
+	// Changes applied by a committed transaction, in order and without
+	// versions of their own
+	Changes []Change[T, O]
+}
+
+func (c Change[T, O]) String() string {
+	if c.Op == ChangeBatch {
+		return fmt.Sprintf("Change(%d %v %v)", c.Version, c.Op, c.Changes)
+	}
+	if len(c.Keys) > 0 {
+		return fmt.Sprintf("Change(%d %v nodes=%v keys=%q)", c.Version, c.Op, c.Nodes, c.Keys)
+	}
+	return fmt.Sprintf("Change(%d %v nodes=%v objects=%v)", c.Version, c.Op, c.Nodes, c.Objects)
+}
+
+// DefaultFeedRetention is the number of changes a load balancer keeps at
+// least unless created WithFeedRetention
+const DefaultFeedRetention = 10000
+
+// ErrFeedTruncated is returned when reading changes from before the oldest
+// change retained
+var ErrFeedTruncated = errors.New("change feed truncated")
+
+type changeFeed[T, O comparable] struct {
+	// Changes retained, in version order, and the number of older changes
+	// dropped
+	log     []Change[T, O]
+	dropped uint64
+
+	// Changes retained at least, all if 0
+	retention int
+
+	// Consumers registered for new changes
+	consumers map[int]func(Change[T, O])
+
+	// Id of the next registered consumer
+	next int
+}
+
+// Record a change and deliver it to all consumers
+func (lb *loadBalancer[T, O]) publish(op ChangeOp, nodes []serverpool.Node[T, O], objects []*serverpool.Object[T, O]) {
+	if len(nodes) == 0 && len(objects) == 0 {
+		return
+	}
+
+	c := Change[T, O]{Op: op}
+	if len(nodes) > 0 {
+		c.Nodes = append([]serverpool.Node[T, O](nil), nodes...)
+	}
+	for _, obj := range objects {
+		c.Objects = append(c.Objects, obj.Id)
+	}
+	lb.record(c)
+}
+
+// Publish the changes of a transaction as a single change
+func (lb *loadBalancer[T, O]) publishBatch(changes []Change[T, O]) {
+	if len(changes) == 0 {
+		return
+	}
+	lb.record(Change[T, O]{Op: ChangeBatch, Changes: changes})
+}
+
+// Append a change to the log, or to the open transaction, and deliver it.
+// Changes are stamped with the current time unless they carry one.
+func (lb *loadBalancer[T, O]) record(c Change[T, O]) {
+	if lb.batch != nil {
+		*lb.batch = append(*lb.batch, c)
+		return
+	}
+
+	c.Version = lb.Version() + 1
+	if c.Time.IsZero() {
+		c.Time = lb.churn.clock()
+	}
+	lb.feed.log = append(lb.feed.log, c)
+	lb.feed.trim()
+
+	for _, fn := range lb.feed.consumers {
+		fn(c)
+	}
+	lb.injectRemoval()
+	lb.reportMetrics()
+	lb.checkBuckets()
+	lb.shedding.update(lb.ch.Size() > 0)
+}
+
+// Drop the oldest changes beyond the retention. Up to twice the retention
+// is kept so dropping copies the log once every retention changes.
+func (f *changeFeed[T, O]) trim() {
+	if f.retention == 0 || len(f.log) <= 2*f.retention {
+		return
+	}
+	drop := len(f.log) - f.retention
+	f.log = slices.Clone(f.log[drop:])
+	f.dropped += uint64(drop)
+}
+
+// Changes after version since, ErrFeedTruncated if some were dropped
+func (f *changeFeed[T, O]) since(since uint64) ([]Change[T, O], error) {
+	if since < f.dropped {
+		return nil, fmt.Errorf("%w: changes before version %d are not retained", ErrFeedTruncated, f.dropped+1)
+	}
+	return f.log[min(since-f.dropped, uint64(len(f.log))):], nil
+}
+
+// Version of the last change applied to the load balancer
+func (lb *loadBalancer[T, O]) Version() uint64 {
+	return lb.feed.dropped + uint64(len(lb.feed.log))
+}
+
+// Feed replays every change after version since to fn and then delivers
+// new changes as they are applied until cancel is called. Starting before
+// the oldest change retained, DefaultFeedRetention ago at least unless the
+// load balancer was created WithFeedRetention, fails with ErrFeedTruncated.
+func (lb *loadBalancer[T, O]) Feed(since uint64, fn func(Change[T, O])) (cancel func(), err error) {
+	changes, err := lb.feed.since(since)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		fn(c)
+	}
+
+	if lb.feed.consumers == nil {
+		lb.feed.consumers = make(map[int]func(Change[T, O]))
+	}
+	id := lb.feed.next
+	lb.feed.next++
+	lb.feed.consumers[id] = fn
+
+	return func() {
+		delete(lb.feed.consumers, id)
+	}, nil
+}
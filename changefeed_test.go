@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestFeedRetention(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithFeedRetention[string, string](10))
+	for i := 0; i < 50; i++ {
+		node := &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])}
+		if _, err := lb.AddNodes([]serverpool.Node[string, string]{node}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if lb.Version() != 50 {
+		t.Fatalf("expected version 50, got %d", lb.Version())
+	}
+	if n := len(lb.(*loadBalancer[string, string]).feed.log); n < 10 || n > 20 {
+		t.Fatalf("expected 10 to 20 changes retained, got %d", n)
+	}
+
+	// Recent changes are still delivered in order
+	var versions []uint64
+	if _, err := lb.(Feeder[string, string]).Feed(45, func(c Change[string, string]) { versions = append(versions, c.Version) }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(versions) != 5 || versions[0] != 46 || versions[4] != 50 {
+		t.Fatalf("expected versions 46 to 50, got %v", versions)
+	}
+
+	// The start of the history is gone
+	if _, err := lb.(Feeder[string, string]).Feed(0, func(Change[string, string]) {}); !errors.Is(err, ErrFeedTruncated) {
+		t.Fatalf("expected a truncated feed, got %v", err)
+	}
+	if _, err := NewMirrorLoadBalancer(lb); !errors.Is(err, ErrFeedTruncated) {
+		t.Fatalf("expected a truncated feed, got %v", err)
+	}
+	if _, err := lb.(Feeder[string, string]).StateAt(50); !errors.Is(err, ErrFeedTruncated) {
+		t.Fatalf("expected a truncated feed, got %v", err)
+	}
+}
+
+func TestFeedRetentionDefault(t *testing.T) {
+	newNode := func(i int) serverpool.Node[string, string] {
+		return &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	for _, tt := range []struct {
+		opts []Option[string, string]
+		kept int
+	}{
+		{nil, DefaultFeedRetention},
+		{[]Option[string, string]{WithFeedRetention[string, string](0)}, 3 * DefaultFeedRetention},
+	} {
+		lb := NewLoadBalancerWithOptions(tt.opts...).(*loadBalancer[string, string])
+		if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode(0)}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for i := 1; i < 3*DefaultFeedRetention; i++ {
+			lb.record(Change[string, string]{Op: ChangeAddNodes, Nodes: []serverpool.Node[string, string]{newNode(i)}})
+		}
+		if n := len(lb.feed.log); n < tt.kept || n > 2*tt.kept {
+			t.Fatalf("expected %d to %d changes retained, got %d", tt.kept, 2*tt.kept, n)
+		}
+	}
+}
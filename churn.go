@@ -0,0 +1,278 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Accounting of object movement and the movement budget
+
+package main
+
+import (
+	"serverpool"
+	"time"
+)
+
+// DefaultChurnWindow is the time window objects moves are counted over
+const DefaultChurnWindow = time.Minute
+
+// ChurnStats reports object movement caused by rebalancing
+type ChurnStats struct {
+	// Length of the window moves are counted over
+	Window time.Duration
+
+	// Moves allowed per window, 0 if unlimited
+	Budget int
+
+	// Objects moved in the current window
+	Moved int
+
+	// Objects moved since the load balancer was created
+	TotalMoved int
+
+	// Objects waiting for budget to be moved
+	Deferred int
+
+	// End of the cool-down after the last topology change, zero if none
+	CoolDownUntil time.Time
+
+	// Nodes quarantined for flapping, see QuarantinedNodes
+	Quarantined int
+
+	// Automatic actions are paused, see PauseAutomation
+	AutomationPaused bool
+}
+
+// ChurnAlert is emitted the first time in a window that moves are deferred
+// because the movement budget is exhausted
+type ChurnAlert struct {
+	// Start of the window the budget was exceeded in
+	WindowStart time.Time
+
+	// Objects moved in the window
+	Moved int
+
+	// Moves allowed per window
+	Budget int
+
+	// Objects waiting for budget to be moved
+	Deferred int
+}
+
+type churnTracker[T, O comparable] struct {
+	window time.Duration
+	budget int
+	alert  func(ChurnAlert)
+
+	// Clock, time.Now if nil
+	now func() time.Time
+
+	start   time.Time
+	moved   int
+	total   int
+	alerted bool
+
+	// Moves are suppressed for cooldown after a topology change
+	cooldown time.Duration
+	changed  time.Time
+
+	// Objects of removed nodes waiting to be reassigned, each queued once
+	deferred []*serverpool.Object[T, O]
+	queued   map[*serverpool.Object[T, O]]bool
+}
+
+func (c *churnTracker[T, O]) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Start a new window once the current one has elapsed
+func (c *churnTracker[T, O]) roll() {
+	window := c.window
+	if window <= 0 {
+		window = DefaultChurnWindow
+	}
+	if now := c.clock(); c.start.IsZero() || now.Sub(c.start) >= window {
+		c.start, c.moved, c.alerted = now, 0, false
+	}
+}
+
+// Record that nodes were added or removed, which starts a cool-down
+func (c *churnTracker[T, O]) topologyChanged() {
+	if c.cooldown > 0 {
+		c.changed = c.clock()
+	}
+}
+
+// End of the current cool-down, zero if none is active
+func (c *churnTracker[T, O]) coolDownUntil() time.Time {
+	if c.changed.IsZero() {
+		return time.Time{}
+	}
+	if until := c.changed.Add(c.cooldown); c.clock().Before(until) {
+		return until
+	}
+	return time.Time{}
+}
+
+// Split moves into those the cool-down and the budget of the current
+// window allow and those that have to wait
+func (c *churnTracker[T, O]) admit(moves []Move[T, O]) (allowed, deferred []Move[T, O]) {
+	c.roll()
+	if !c.coolDownUntil().IsZero() {
+		return nil, moves
+	}
+	if c.budget <= 0 {
+		return moves, nil
+	}
+	n := min(len(moves), max(0, c.budget-c.moved))
+	return moves[:n], moves[n:]
+}
+
+// Split the moves of a change of nodes into those to make now and those to
+// defer, which the budget, a cool-down or a prefetch still in flight hold
+// back. A mirror defers the moves its primary deferred instead, so both
+// keep the same objects in place. Deferred objects are collected for the
+// change of nodes to publish.
+func (lb *loadBalancer[T, O]) admit(moves []Move[T, O]) (allowed, deferred []Move[T, O]) {
+	if lb.readOnly {
+		lb.churn.roll()
+		for _, m := range moves {
+			if lb.replayDeferred[m.Object.Id] {
+				deferred = append(deferred, m)
+			} else {
+				allowed = append(allowed, m)
+			}
+		}
+	} else {
+		var held []Move[T, O]
+		allowed, deferred = lb.churn.admit(moves)
+		allowed, held = lb.prefetch(allowed)
+		deferred = append(deferred, held...)
+	}
+	if lb.deferring != nil {
+		for _, m := range deferred {
+			*lb.deferring = append(*lb.deferring, m.Object)
+		}
+	}
+	return allowed, deferred
+}
+
+// Count objects moved in the current window
+func (c *churnTracker[T, O]) record(moved int) {
+	c.moved += moved
+	c.total += moved
+}
+
+// Queue the objects of deferred moves and alert once per window if the
+// budget is exhausted
+func (c *churnTracker[T, O]) postpone(moves []Move[T, O]) {
+	if len(moves) == 0 {
+		return
+	}
+	if c.queued == nil {
+		c.queued = make(map[*serverpool.Object[T, O]]bool)
+	}
+	for _, m := range moves {
+		if !c.queued[m.Object] {
+			c.queued[m.Object] = true
+			c.deferred = append(c.deferred, m.Object)
+		}
+	}
+	if c.budget > 0 && c.moved >= c.budget && !c.alerted && c.alert != nil {
+		c.alerted = true
+		c.alert(ChurnAlert{WindowStart: c.start, Moved: c.moved, Budget: c.budget, Deferred: len(c.deferred)})
+	}
+}
+
+func (c *churnTracker[T, O]) stats() ChurnStats {
+	c.roll()
+	window := c.window
+	if window <= 0 {
+		window = DefaultChurnWindow
+	}
+	return ChurnStats{Window: window, Budget: c.budget, Moved: c.moved, TotalMoved: c.total,
+		Deferred: len(c.deferred), CoolDownUntil: c.coolDownUntil()}
+}
+
+// Report object movement caused by rebalancing
+func (lb *loadBalancer[T, O]) ChurnStats() ChurnStats {
+	stats := lb.churn.stats()
+	stats.Quarantined = len(lb.flaps.active(lb.churn.clock()))
+	stats.AutomationPaused = lb.paused
+	return stats
+}
+
+// Rebalance moves the objects deferred by the movement budget or a cool-down,
+// and the next batch of objects off each draining node, as far as the budget
+// of the current window allows and returns the number of objects moved.
+// Nothing moves while a cool-down is active.
+func (lb *loadBalancer[T, O]) Rebalance() (moved int, err error) {
+	if lb.dryRun {
+		return lb.planDeferred(), nil
+	}
+	if lb.readOnly {
+		return 0, ErrReadOnly
+	}
+	lb.profileRebalance("Rebalance", func() { moved, err = lb.rebalance() })
+	return moved, err
+}
+
+func (lb *loadBalancer[T, O]) rebalance() (int, error) {
+	pending := lb.churn.deferred
+	lb.churn.deferred, lb.churn.queued = nil, nil
+
+	// Skip objects removed or assigned while they were waiting
+	objects := func(yield func(*serverpool.Object[T, O]) bool) {
+		for _, obj := range pending {
+			o, ok := lb.objects.get(obj.Id)
+			if !ok || o != obj {
+				continue
+			}
+			if node := obj.Node(); node != nil && *node != nil {
+				continue
+			}
+			if !yield(obj) {
+				return
+			}
+		}
+	}
+
+	var errs ReassignmentError[O]
+	moves, unmapped := lb.planMoves(nil, objects)
+	for id, err := range unmapped {
+		errs.add(id, err)
+	}
+
+	allowed, deferred := lb.churn.admit(moves)
+	allowed, held := lb.prefetch(allowed)
+	deferred = append(deferred, held...)
+	var assigned []*serverpool.Object[T, O]
+	for _, m := range allowed {
+		if err := lb.assignObject(m.Object); err != nil {
+			errs.add(m.Object.Id, err)
+			continue
+		}
+		assigned = append(assigned, m.Object)
+	}
+	lb.churn.record(len(assigned))
+	lb.churn.postpone(deferred)
+	if len(assigned) > 0 {
+		lb.publish(ChangeAssignObject, nil, assigned)
+	}
+
+	moved := len(assigned)
+	for _, d := range lb.drains {
+		drained := lb.drainStep(d, &errs)
+		lb.finishDrain(d)
+		if len(drained) > 0 {
+			lb.publish(ChangeDrainNode, []serverpool.Node[T, O]{d.node}, drained)
+		}
+		moved += len(drained)
+	}
+
+	if len(errs.Errors) > 0 {
+		return moved, &errs
+	}
+	return moved, nil
+}
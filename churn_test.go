@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestMovementBudget(t *testing.T) {
+	var alerts []ChurnAlert
+	lb := NewLoadBalancerWithOptions(WithMovementBudget[string, string](1, time.Minute,
+		func(a ChurnAlert) { alerts = append(alerts, a) })).(*loadBalancer[string, string])
+	now := time.Unix(0, 0)
+	lb.churn.now = func() time.Time { return now }
+
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	objs := []*serverpool.Object[string, string]{{Id: "obj1"}, {Id: "obj2"}, {Id: "obj3"}, {Id: "obj4"}}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Put every object on node1 so removing it moves all of them
+	var from serverpool.Node[string, string] = node1
+	for _, obj := range objs {
+		node1.AssignObject(obj)
+		obj.AssignToNode(&from)
+	}
+
+	result, err := lb.RemoveNodes([]serverpool.Node[string, string]{node1})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result.Reassigned() != 1 || result.Deferred() != 3 {
+		t.Fatalf("expected 1 reassigned and 3 deferred, got %d and %d", result.Reassigned(), result.Deferred())
+	}
+	if len(alerts) != 1 || alerts[0].Deferred != 3 {
+		t.Fatalf("expected one alert with 3 deferred objects, got %v", alerts)
+	}
+
+	// The budget of the window is spent
+	if moved, err := lb.Rebalance(); err != nil || moved != 0 {
+		t.Fatalf("expected no moves, got %d, %v", moved, err)
+	}
+
+	now = now.Add(time.Minute)
+	if moved, err := lb.Rebalance(); err != nil || moved != 1 {
+		t.Fatalf("expected 1 move, got %d, %v", moved, err)
+	}
+	stats := lb.ChurnStats()
+	if stats.Moved != 1 || stats.TotalMoved != 2 || stats.Deferred != 2 {
+		t.Fatalf("unexpected churn stats %+v", stats)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("expected an alert in the new window, got %d", len(alerts))
+	}
+}
+
+func TestCoolDown(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithCoolDown[string, string](time.Minute)).(*loadBalancer[string, string])
+	now := time.Unix(0, 0)
+	lb.churn.now = func() time.Time { return now }
+
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	objs := []*serverpool.Object[string, string]{{Id: "obj1"}, {Id: "obj2"}}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var from serverpool.Node[string, string] = node1
+	for _, obj := range objs {
+		node1.AssignObject(obj)
+		obj.AssignToNode(&from)
+	}
+
+	// Removing a node right after adding nodes defers its objects
+	result, err := lb.RemoveNodes([]serverpool.Node[string, string]{node1})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result.Reassigned() != 0 || result.Deferred() != 2 {
+		t.Fatalf("expected 0 reassigned and 2 deferred, got %d and %d", result.Reassigned(), result.Deferred())
+	}
+	if until := lb.ChurnStats().CoolDownUntil; !until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected cool-down until %v, got %v", now.Add(time.Minute), until)
+	}
+
+	now = now.Add(30 * time.Second)
+	if moved, err := lb.Rebalance(); err != nil || moved != 0 {
+		t.Fatalf("expected no moves during cool-down, got %d, %v", moved, err)
+	}
+
+	now = now.Add(time.Minute)
+	if moved, err := lb.Rebalance(); err != nil || moved != 2 {
+		t.Fatalf("expected 2 moves after cool-down, got %d, %v", moved, err)
+	}
+	for _, obj := range objs {
+		if *obj.Node() != node2 {
+			t.Fatalf("expected %v on node2, got %v", obj, *obj.Node())
+		}
+	}
+}
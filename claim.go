@@ -0,0 +1,152 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Pull based assignment of objects to workers claiming them
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+	"sync"
+	"time"
+)
+
+// DefaultClaimExpiry is how long a claim lasts unless it is renewed
+const DefaultClaimExpiry = 30 * time.Second
+
+// Claim of an object by the node it is assigned to
+type claim[T comparable] struct {
+	node    T
+	expires time.Time
+}
+
+type claims[T, O comparable] struct {
+	// Claims last this long, DefaultClaimExpiry if 0
+	expiry time.Duration
+
+	// Serializes workers claiming objects
+	mu     sync.Mutex
+	claims map[*serverpool.Object[T, O]]claim[T]
+}
+
+func (c *claims[T, O]) until(now time.Time) time.Time {
+	expiry := c.expiry
+	if expiry <= 0 {
+		expiry = DefaultClaimExpiry
+	}
+	return now.Add(expiry)
+}
+
+// ClaimObjects assigns up to limit unassigned objects whose keys map to node
+// to it and returns them, for workers that pull their objects instead of
+// having them pushed. Each claim expires unless renewed with RenewClaims,
+// after which the object is unassigned and can be claimed again. Workers
+// may claim concurrently as long as nothing else mutates the load balancer.
+func (lb *loadBalancer[T, O]) ClaimObjects(node serverpool.Node[T, O], limit int) ([]*serverpool.Object[T, O], error) {
+	if limit <= 0 {
+		return nil, errors.New("claim limit must be positive")
+	}
+	if lb.readOnly && !lb.dryRun {
+		return nil, ErrReadOnly
+	}
+	lb.claims.mu.Lock()
+	defer lb.claims.mu.Unlock()
+
+	n, ok := lb.lookupNode(node)
+	if !ok {
+		return nil, fmt.Errorf("%v not found", node)
+	}
+
+	var claimed []*serverpool.Object[T, O]
+	lb.profiler.do("ClaimObjects", func() {
+		if !lb.dryRun {
+			lb.expireClaims()
+		}
+		for obj := range lb.objects.all() {
+			if len(claimed) == limit {
+				break
+			}
+			if o := obj.Node(); o != nil && *o != nil {
+				continue
+			}
+			if target, err := lb.placement(obj); err != nil || target.Name() != n.Name() {
+				continue
+			}
+			claimed = append(claimed, obj)
+		}
+		if lb.dryRun {
+			return
+		}
+
+		if lb.claims.claims == nil {
+			lb.claims.claims = make(map[*serverpool.Object[T, O]]claim[T])
+		}
+		expires := lb.claims.until(lb.churn.clock())
+		for _, obj := range claimed {
+			lb.assignObject(obj)
+			lb.claims.claims[obj] = claim[T]{node: n.Name(), expires: expires}
+		}
+	})
+	if len(claimed) > 0 && !lb.dryRun {
+		lb.publish(ChangeAssignObject, nil, claimed)
+	}
+	return claimed, nil
+}
+
+// RenewClaims extends every claim of node that has not expired and returns
+// how many were renewed
+func (lb *loadBalancer[T, O]) RenewClaims(node serverpool.Node[T, O]) (int, error) {
+	if lb.dryRun {
+		return 0, nil
+	}
+	if lb.readOnly {
+		return 0, ErrReadOnly
+	}
+	lb.claims.mu.Lock()
+	defer lb.claims.mu.Unlock()
+
+	lb.expireClaims()
+	expires := lb.claims.until(lb.churn.clock())
+	renewed := 0
+	for obj, c := range lb.claims.claims {
+		if c.node == node.Name() {
+			lb.claims.claims[obj] = claim[T]{node: c.node, expires: expires}
+			renewed++
+		}
+	}
+	return renewed, nil
+}
+
+// Unassign the objects whose claims expired. Claims of objects that were
+// removed or have moved to another node since are dropped. Claims do not
+// expire while automation is paused.
+func (lb *loadBalancer[T, O]) expireClaims() {
+	if lb.paused {
+		return
+	}
+	now := lb.churn.clock()
+	var expired []*serverpool.Object[T, O]
+	for obj, c := range lb.claims.claims {
+		if stored, ok := lb.objects.get(obj.Id); !ok || stored != obj {
+			delete(lb.claims.claims, obj)
+			continue
+		}
+		node := obj.Node()
+		if node == nil || *node == nil || (*node).Name() != c.node {
+			delete(lb.claims.claims, obj)
+			continue
+		}
+		if now.Before(c.expires) {
+			continue
+		}
+		delete(lb.claims.claims, obj)
+		lb.detach(obj)
+		expired = append(expired, obj)
+	}
+	if len(expired) > 0 {
+		lb.publish(ChangeUnassignObject, nil, expired)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestClaimObjects(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithClaimExpiry[string, string](time.Minute)).(*loadBalancer[string, string])
+	now := time.Unix(0, 0)
+	lb.churn.now = func() time.Time { return now }
+
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	node1, node2 := newNode("node1"), newNode("node2")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 20; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	mapped := 0
+	for _, obj := range objs {
+		if node, _ := lb.GetNode(obj.Name()); node.Name() == "node1" {
+			mapped++
+		}
+	}
+
+	// A worker only gets objects mapping to it, each only once
+	claimed, err := lb.ClaimObjects(node1, 3)
+	if err != nil || len(claimed) != min(3, mapped) {
+		t.Fatalf("expected %d objects, got %d, %v", min(3, mapped), len(claimed), err)
+	}
+	rest, err := lb.ClaimObjects(node1, 100)
+	if err != nil || len(claimed)+len(rest) != mapped {
+		t.Fatalf("expected %d objects in total, got %d, %v", mapped, len(claimed)+len(rest), err)
+	}
+	for _, obj := range append(claimed, rest...) {
+		if node := obj.Node(); node == nil || (*node).Name() != "node1" {
+			t.Fatalf("expected %v on node1", obj)
+		}
+	}
+	if more, _ := lb.ClaimObjects(node1, 100); len(more) != 0 {
+		t.Fatalf("expected nothing left to claim, got %d", len(more))
+	}
+
+	// Renewed claims outlive the expiry, the others can be claimed again
+	now = now.Add(30 * time.Second)
+	if renewed, err := lb.RenewClaims(node1); err != nil || renewed != mapped {
+		t.Fatalf("expected %d renewed claims, got %d, %v", mapped, renewed, err)
+	}
+	claimed2, _ := lb.ClaimObjects(node2, 100)
+	now = now.Add(45 * time.Second)
+	if again, _ := lb.ClaimObjects(node1, 100); len(again) != 0 {
+		t.Fatalf("expected renewed claims to hold, got %d objects", len(again))
+	}
+	now = now.Add(30 * time.Second)
+	again, _ := lb.ClaimObjects(node2, 100)
+	if len(again) != len(claimed2) {
+		t.Fatalf("expected %d expired objects to be claimed again, got %d", len(claimed2), len(again))
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Claims do not expire while automation is paused
+	if err := lb.PauseAutomation(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if more, _ := lb.ClaimObjects(node2, 100); len(more) != 0 {
+		t.Fatalf("expected claims to hold while paused, got %d objects", len(more))
+	}
+	if err := lb.ResumeAutomation(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if more, _ := lb.ClaimObjects(node2, 100); len(more) != len(again) {
+		t.Fatalf("expected %d expired objects after resuming, got %d", len(again), len(more))
+	}
+}
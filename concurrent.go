@@ -0,0 +1,500 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Load balancer safe for use by multiple goroutines
+
+package main
+
+import (
+	"consistenthash"
+	"context"
+	"io"
+	"iter"
+	"serverpool"
+	"sync"
+	"time"
+)
+
+// concurrentLoadBalancer serializes changes to a load balancer with a
+// read-write lock. Lookups, iteration and statistics that do not change
+// anything take the read lock and run concurrently with each other.
+type concurrentLoadBalancer[T, O comparable] struct {
+	mu sync.RWMutex
+	lb *loadBalancer[T, O]
+
+	// Assignments of objects in progress by GetOrAssignObject
+	assigning flights[O, serverpool.Node[T, O]]
+}
+
+// Create a load balancer configured by the given options that is safe for
+// use by multiple goroutines. Iterators yield a copy taken under the lock,
+// so the load balancer may be changed while iterating. Nodes and objects
+// are shared with the load balancer, only their names and ids are safe to
+// read during changes. Feed consumers are called while the lock is held and
+// must not call back into the load balancer, subscribers may.
+func NewConcurrentLoadBalancer[T, O comparable](opts ...Option[T, O]) LoadBalancer[T, O] {
+	return &concurrentLoadBalancer[T, O]{lb: NewLoadBalancerWithOptions(opts...).(*loadBalancer[T, O])}
+}
+
+// Run fn with the read lock held
+func readLocked[T, O comparable, R any](c *concurrentLoadBalancer[T, O], fn func() R) R {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return fn()
+}
+
+// Run fn with the write lock held
+func writeLocked[T, O comparable, R any](c *concurrentLoadBalancer[T, O], fn func() R) R {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fn()
+}
+
+// Result and error of a call, to pass both through readLocked and
+// writeLocked
+type outcome[R any] struct {
+	value R
+	err   error
+}
+
+func outcomeOf[R any](value R, err error) outcome[R] { return outcome[R]{value, err} }
+
+// Calls in progress by key, so that concurrent calls with the same key run
+// once and share the outcome
+type flights[K comparable, R any] struct {
+	mu    sync.Mutex
+	calls map[K]*flight[R]
+}
+
+type flight[R any] struct {
+	done chan struct{}
+	outcome[R]
+}
+
+// Run fn unless a call with the same key is in progress, in which case wait
+// for its outcome instead
+func (f *flights[K, R]) do(key K, fn func() (R, error)) (R, error) {
+	f.mu.Lock()
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-c.done
+		return c.value, c.err
+	}
+	if f.calls == nil {
+		f.calls = make(map[K]*flight[R])
+	}
+	c := &flight[R]{done: make(chan struct{})}
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	c.outcome = outcomeOf(fn())
+	return c.value, c.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) AddNodes(nodes []serverpool.Node[T, O]) (NodesResult[T, O], error) {
+	r := writeLocked(c, func() outcome[NodesResult[T, O]] { return outcomeOf(c.lb.AddNodes(nodes)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) RemoveNodes(nodes []serverpool.Node[T, O]) (NodesResult[T, O], error) {
+	r := writeLocked(c, func() outcome[NodesResult[T, O]] { return outcomeOf(c.lb.RemoveNodes(nodes)) })
+	return r.value, r.err
+}
+
+// GetNode waits for nodes without holding the lock, so that they can be
+// added meanwhile
+func (c *concurrentLoadBalancer[T, O]) GetNode(key string) (serverpool.Node[T, O], error) {
+	return c.lb.getNode(key, c.lookupKey)
+}
+
+func (c *concurrentLoadBalancer[T, O]) GetNodeBytes(key []byte) (serverpool.Node[T, O], error) {
+	return c.GetNode(keyString(key))
+}
+
+// GetNodeWait also waits without holding the lock
+func (c *concurrentLoadBalancer[T, O]) GetNodeWait(ctx context.Context, key string) (serverpool.Node[T, O], error) {
+	return c.lb.getNodeWait(ctx, key, c.lookupKey)
+}
+
+func (c *concurrentLoadBalancer[T, O]) lookupKey(key string) (serverpool.Node[T, O], error) {
+	r := readLocked(c, func() outcome[serverpool.Node[T, O]] { return outcomeOf(c.lb.lookupKey(key)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) mapKey(key string) (serverpool.Node[T, O], error) {
+	r := readLocked(c, func() outcome[serverpool.Node[T, O]] { return outcomeOf(c.lb.mapKey(key)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) NodeCount() int {
+	return readLocked(c, c.lb.NodeCount)
+}
+
+func (c *concurrentLoadBalancer[T, O]) Nodes() iter.Seq2[serverpool.Node[T, O], int] {
+	return pairs(c, c.lb.Nodes)
+}
+
+func (c *concurrentLoadBalancer[T, O]) Buckets() iter.Seq2[int, serverpool.Node[T, O]] {
+	return pairs(c, c.lb.Buckets)
+}
+
+func (c *concurrentLoadBalancer[T, O]) Objects() iter.Seq[*serverpool.Object[T, O]] {
+	objects := readLocked(c, func() []*serverpool.Object[T, O] {
+		var objects []*serverpool.Object[T, O]
+		for obj := range c.lb.Objects() {
+			objects = append(objects, obj)
+		}
+		return objects
+	})
+	return func(yield func(*serverpool.Object[T, O]) bool) {
+		for _, obj := range objects {
+			if !yield(obj) {
+				return
+			}
+		}
+	}
+}
+
+func (c *concurrentLoadBalancer[T, O]) ObjectsByNode() iter.Seq2[serverpool.Node[T, O], iter.Seq[*serverpool.Object[T, O]]] {
+	type nodeObjects struct {
+		node    serverpool.Node[T, O]
+		objects []*serverpool.Object[T, O]
+	}
+	nodes := readLocked(c, func() []nodeObjects {
+		var nodes []nodeObjects
+		for node, objects := range c.lb.ObjectsByNode() {
+			n := nodeObjects{node: node}
+			for obj := range objects {
+				n.objects = append(n.objects, obj)
+			}
+			nodes = append(nodes, n)
+		}
+		return nodes
+	})
+	return func(yield func(serverpool.Node[T, O], iter.Seq[*serverpool.Object[T, O]]) bool) {
+		for _, n := range nodes {
+			objects := func(yield func(*serverpool.Object[T, O]) bool) {
+				for _, obj := range n.objects {
+					if !yield(obj) {
+						return
+					}
+				}
+			}
+			if !yield(n.node, objects) {
+				return
+			}
+		}
+	}
+}
+
+// Iterator over a copy of the pairs seq yields, taken under the read lock
+func pairs[T, O comparable, K, V any](c *concurrentLoadBalancer[T, O], seq func() iter.Seq2[K, V]) iter.Seq2[K, V] {
+	type pair struct {
+		k K
+		v V
+	}
+	copied := readLocked(c, func() []pair {
+		var copied []pair
+		for k, v := range seq() {
+			copied = append(copied, pair{k, v})
+		}
+		return copied
+	})
+	return func(yield func(K, V) bool) {
+		for _, p := range copied {
+			if !yield(p.k, p.v) {
+				return
+			}
+		}
+	}
+}
+
+func (c *concurrentLoadBalancer[T, O]) AddObjects(objects []*serverpool.Object[T, O]) (ObjectsResult[T, O], error) {
+	r := writeLocked(c, func() outcome[ObjectsResult[T, O]] { return outcomeOf(c.lb.AddObjects(objects)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) RemoveObjects(objects []*serverpool.Object[T, O]) (ObjectsResult[T, O], error) {
+	r := writeLocked(c, func() outcome[ObjectsResult[T, O]] { return outcomeOf(c.lb.RemoveObjects(objects)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) AssignObject(obj *serverpool.Object[T, O]) error {
+	return writeLocked(c, func() error { return c.lb.AssignObject(obj) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) UnassignObject(obj *serverpool.Object[T, O]) error {
+	return writeLocked(c, func() error { return c.lb.UnassignObject(obj) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) GetNodes(key string, n int) ([]serverpool.Node[T, O], error) {
+	r := readLocked(c, func() outcome[[]serverpool.Node[T, O]] { return outcomeOf(c.lb.GetNodes(key, n)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) Candidates(key string, n int) ([]serverpool.Node[T, O], error) {
+	r := readLocked(c, func() outcome[[]serverpool.Node[T, O]] { return outcomeOf(c.lb.Candidates(key, n)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) GetTierNode(key, tier string) (serverpool.Node[T, O], error) {
+	r := readLocked(c, func() outcome[serverpool.Node[T, O]] { return outcomeOf(c.lb.GetTierNode(key, tier)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) SetObjectTier(obj *serverpool.Object[T, O], tier string) error {
+	return writeLocked(c, func() error { return c.lb.SetObjectTier(obj, tier) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) MapPrefix(prefix string, sampler PrefixSampler, samples int) (PrefixMapping[T], error) {
+	r := readLocked(c, func() outcome[PrefixMapping[T]] { return outcomeOf(c.lb.MapPrefix(prefix, sampler, samples)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) PinKey(key string, node serverpool.Node[T, O]) error {
+	return writeLocked(c, func() error { return c.lb.PinKey(key, node) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) UnpinKey(key string) error {
+	return writeLocked(c, func() error { return c.lb.UnpinKey(key) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) PinnedKeys() map[string]T {
+	return readLocked(c, c.lb.PinnedKeys)
+}
+
+func (c *concurrentLoadBalancer[T, O]) Version() uint64 {
+	return readLocked(c, c.lb.Version)
+}
+
+func (c *concurrentLoadBalancer[T, O]) Feed(since uint64, fn func(Change[T, O])) (cancel func(), err error) {
+	r := writeLocked(c, func() outcome[func()] { return outcomeOf(c.lb.Feed(since, fn)) })
+	if r.err != nil {
+		return nil, r.err
+	}
+	return func() { writeLocked(c, func() struct{} { r.value(); return struct{}{} }) }, nil
+}
+
+func (c *concurrentLoadBalancer[T, O]) Subscribe(fn func(Event[T, O]), buffer int, types ...string) *Subscription[T, O] {
+	return c.subscribe(newSubscription(fn, buffer, types))
+}
+
+func (c *concurrentLoadBalancer[T, O]) Watch(buffer int, types ...string) (<-chan Event[T, O], *Subscription[T, O]) {
+	ch, s := newWatch[T, O](buffer, types)
+	return ch, c.subscribe(s)
+}
+
+// Register a subscription, taking the lock again to unregister it
+func (c *concurrentLoadBalancer[T, O]) subscribe(s *Subscription[T, O]) *Subscription[T, O] {
+	s = writeLocked(c, func() *Subscription[T, O] { return c.lb.subscribe(s) })
+	remove := s.remove
+	s.remove = func() { writeLocked(c, func() struct{} { remove(); return struct{}{} }) }
+	return s
+}
+
+func (c *concurrentLoadBalancer[T, O]) ReadOnly() bool {
+	return readLocked(c, c.lb.ReadOnly)
+}
+
+// Promote stops following the primary without the lock held, as the
+// primary takes the lock while holding its own to apply its changes
+func (c *concurrentLoadBalancer[T, O]) Promote() {
+	unfollow := writeLocked(c, func() func() {
+		unfollow := c.lb.unfollow
+		c.lb.unfollow = nil
+		return unfollow
+	})
+	if unfollow != nil {
+		unfollow()
+	}
+	writeLocked(c, func() struct{} { c.lb.Promote(); return struct{}{} })
+}
+
+func (c *concurrentLoadBalancer[T, O]) MemoryStats() MemoryStats {
+	return readLocked(c, c.lb.MemoryStats)
+}
+
+// ChurnStats takes the write lock since it rolls the movement window
+func (c *concurrentLoadBalancer[T, O]) ChurnStats() ChurnStats {
+	return writeLocked(c, c.lb.ChurnStats)
+}
+
+func (c *concurrentLoadBalancer[T, O]) Rebalance() (int, error) {
+	r := writeLocked(c, func() outcome[int] { return outcomeOf(c.lb.Rebalance()) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) RebalanceAll() (RebalanceReport[T, O], error) {
+	r := writeLocked(c, func() outcome[RebalanceReport[T, O]] { return outcomeOf(c.lb.RebalanceAll()) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) BucketStats() BucketStats[T] {
+	return readLocked(c, c.lb.BucketStats)
+}
+
+func (c *concurrentLoadBalancer[T, O]) RebalanceProfiles() []RebalanceProfile {
+	return readLocked(c, c.lb.RebalanceProfiles)
+}
+
+func (c *concurrentLoadBalancer[T, O]) DecommissionReports() []DecommissionReport[T, O] {
+	return readLocked(c, c.lb.DecommissionReports)
+}
+
+// QuarantinedNodes takes the write lock since it forgets ended quarantines
+func (c *concurrentLoadBalancer[T, O]) QuarantinedNodes() map[T]time.Time {
+	return writeLocked(c, c.lb.QuarantinedNodes)
+}
+
+// GetOrAssignObject answers under the read lock once the object is
+// assigned. Concurrent calls for an object not assigned yet share a single
+// assignment, so placement and the callbacks of the assignment run once and
+// the other callers do not queue for the write lock.
+func (c *concurrentLoadBalancer[T, O]) GetOrAssignObject(obj *serverpool.Object[T, O]) (serverpool.Node[T, O], error) {
+	r := readLocked(c, func() outcome[serverpool.Node[T, O]] {
+		if node, ok := c.lb.assignedNode(obj.Id); ok {
+			return outcomeOf(node, nil)
+		}
+		return outcome[serverpool.Node[T, O]]{}
+	})
+	if r.value != nil {
+		return r.value, nil
+	}
+	return c.assigning.do(obj.Id, func() (serverpool.Node[T, O], error) {
+		r := writeLocked(c, func() outcome[serverpool.Node[T, O]] { return outcomeOf(c.lb.GetOrAssignObject(obj)) })
+		return r.value, r.err
+	})
+}
+
+// MoveObjects copies the objects without holding the lock, so that they can
+// be looked up and reported migrating meanwhile
+func (c *concurrentLoadBalancer[T, O]) MoveObjects(ids []O, to serverpool.Node[T, O]) error {
+	if writeLocked(c, c.lb.planned) {
+		return writeLocked(c, func() error { return c.lb.MoveObjects(ids, to) })
+	}
+	var err error
+	c.lb.profiler.do("MoveObjects", func() {
+		err = c.lb.runMoves(c.lb.movesCheck(ids, to), movesAborted[T, O](to), c.locked)
+	})
+	return err
+}
+
+// TransferObject also copies the object without holding the lock
+func (c *concurrentLoadBalancer[T, O]) TransferObject(obj *serverpool.Object[T, O], to serverpool.Node[T, O]) error {
+	if writeLocked(c, c.lb.planned) {
+		return writeLocked(c, func() error { return c.lb.TransferObject(obj, to) })
+	}
+	var err error
+	c.lb.profiler.do("TransferObject", func() {
+		err = c.lb.runMoves(c.lb.transferCheck(obj, to), transferAborted(obj, to), c.locked)
+	})
+	return err
+}
+
+// Run fn with the write lock held
+func (c *concurrentLoadBalancer[T, O]) locked(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn()
+}
+
+func (c *concurrentLoadBalancer[T, O]) Migrating(obj *serverpool.Object[T, O]) (Move[T, O], bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lb.Migrating(obj)
+}
+
+func (c *concurrentLoadBalancer[T, O]) Topology() (consistenthash.Topology, error) {
+	r := readLocked(c, func() outcome[consistenthash.Topology] { return outcomeOf(c.lb.Topology()) })
+	return r.value, r.err
+}
+
+// Begin a transaction whose Commit takes the write lock. Queueing
+// operations is not safe from several goroutines.
+func (c *concurrentLoadBalancer[T, O]) Begin() *Transaction[T, O] {
+	tx := c.lb.Begin()
+	tx.locker = &c.mu
+	return tx
+}
+
+// StateAt returns a load balancer of its own, read-only and not changed by
+// later changes of this one
+func (c *concurrentLoadBalancer[T, O]) StateAt(version uint64) (LoadBalancer[T, O], error) {
+	r := readLocked(c, func() outcome[LoadBalancer[T, O]] { return outcomeOf(c.lb.StateAt(version)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) Save(w io.Writer) error {
+	return readLocked(c, func() error { return c.lb.Save(w) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) Load(r io.Reader, newNode func(name T) serverpool.Node[T, O]) error {
+	return writeLocked(c, func() error { return c.lb.Load(r, newNode) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) DrainNode(node serverpool.Node[T, O], batch int) error {
+	return writeLocked(c, func() error { return c.lb.DrainNode(node, batch) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) SetDrainBatch(node serverpool.Node[T, O], batch int) error {
+	return writeLocked(c, func() error { return c.lb.SetDrainBatch(node, batch) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) AbortDrain(node serverpool.Node[T, O]) error {
+	return writeLocked(c, func() error { return c.lb.AbortDrain(node) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) DrainStats() map[T]DrainProgress {
+	return readLocked(c, c.lb.DrainStats)
+}
+
+func (c *concurrentLoadBalancer[T, O]) DialTraffic(from, to serverpool.Node[T, O], schedule []DialStep) error {
+	return writeLocked(c, func() error { return c.lb.DialTraffic(from, to, schedule) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) RollbackDial(from serverpool.Node[T, O]) error {
+	return writeLocked(c, func() error { return c.lb.RollbackDial(from) })
+}
+
+func (c *concurrentLoadBalancer[T, O]) DialStats() map[T]DialProgress[T] {
+	return readLocked(c, c.lb.DialStats)
+}
+
+func (c *concurrentLoadBalancer[T, O]) SpreadStats(replicas int) (SpreadStats, error) {
+	r := readLocked(c, func() outcome[SpreadStats] { return outcomeOf(c.lb.SpreadStats(replicas)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) PauseAutomation() error {
+	return writeLocked(c, c.lb.PauseAutomation)
+}
+
+func (c *concurrentLoadBalancer[T, O]) ResumeAutomation() error {
+	return writeLocked(c, c.lb.ResumeAutomation)
+}
+
+func (c *concurrentLoadBalancer[T, O]) Verify() error {
+	return readLocked(c, c.lb.Verify)
+}
+
+func (c *concurrentLoadBalancer[T, O]) CollectOrphans() ([]Stray[T, O], error) {
+	r := writeLocked(c, func() outcome[[]Stray[T, O]] { return outcomeOf(c.lb.CollectOrphans()) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) ClaimObjects(node serverpool.Node[T, O], limit int) ([]*serverpool.Object[T, O], error) {
+	r := writeLocked(c, func() outcome[[]*serverpool.Object[T, O]] { return outcomeOf(c.lb.ClaimObjects(node, limit)) })
+	return r.value, r.err
+}
+
+func (c *concurrentLoadBalancer[T, O]) RenewClaims(node serverpool.Node[T, O]) (int, error) {
+	r := writeLocked(c, func() outcome[int] { return outcomeOf(c.lb.RenewClaims(node)) })
+	return r.value, r.err
+}
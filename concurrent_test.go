@@ -0,0 +1,298 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Run with -race to check lookups and iteration against concurrent changes
+func TestConcurrentLoadBalancer(t *testing.T) {
+	lb := NewConcurrentLoadBalancer(WithLookupTable[string, string](101))
+	newNode := func(i int) *mockNode {
+		return &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	var initial []serverpool.Node[string, string]
+	for i := 0; i < 4; i++ {
+		initial = append(initial, newNode(i))
+	}
+	if _, err := lb.AddNodes(initial); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan error, 16)
+
+	// Writers add and remove nodes and add and assign objects
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 4; i < 40; i++ {
+			node := newNode(i)
+			if _, err := lb.AddNodes([]serverpool.Node[string, string]{node}); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{node}); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			obj := &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)}
+			if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+				errs <- err
+				return
+			}
+			if err := lb.AssignObject(obj); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	// Readers look up keys and iterate until the writers are done
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("key%d", i)
+				if _, err := lb.GetNode(key); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := lb.Candidates(key, 2); err != nil {
+					errs <- err
+					return
+				}
+				for node := range lb.Nodes() {
+					_ = node.Name()
+				}
+				for bucket, node := range lb.Buckets() {
+					_, _ = bucket, node.Name()
+				}
+				for obj := range lb.Objects() {
+					_ = obj.Id
+				}
+				_ = lb.NodeCount()
+				_ = lb.Version()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if lb.NodeCount() != 4 {
+		t.Fatalf("expected 4 nodes, got %d", lb.NodeCount())
+	}
+	objects := 0
+	for range lb.Objects() {
+		objects++
+	}
+	if objects != 200 {
+		t.Fatalf("expected 200 objects, got %d", objects)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected a consistent state, got %v", err)
+	}
+
+	// Changing the load balancer while iterating does not deadlock
+	for node := range lb.Nodes() {
+		if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{node}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		break
+	}
+
+	// Transactions commit under the lock
+	tx := lb.(Transactor[string, string]).Begin()
+	tx.AddNodes([]serverpool.Node[string, string]{newNode(100)})
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if lb.NodeCount() != 4 {
+		t.Fatalf("expected 4 nodes, got %d", lb.NodeCount())
+	}
+}
+
+// Run with -race to check object operations against each other and
+// against changes of the nodes
+func TestConcurrentObjects(t *testing.T) {
+	lb := NewConcurrentLoadBalancer[string, string]()
+	newNode := func(i int) *mockNode {
+		return &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode(0), newNode(1), newNode(2)}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	// Each writer adds, assigns, looks up and removes objects of its own.
+	// Every writer also looks up the objects of the others, which may be
+	// assigned, unassigned or gone meanwhile.
+	const writers, objects = 4, 100
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range objects {
+				obj := &serverpool.Object[string, string]{Id: "obj" + strconv.Itoa(w*objects+i)}
+				if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+					fail(err)
+					return
+				}
+				if err := lb.AssignObject(obj); err != nil {
+					fail(err)
+					return
+				}
+				node, err := lb.GetOrAssignObject(obj)
+				if err != nil {
+					fail(err)
+					return
+				}
+				if got := *obj.Node(); got.Name() != node.Name() {
+					fail(fmt.Errorf("expected %v on %v, got %v", obj, node, got))
+					return
+				}
+				other := &serverpool.Object[string, string]{Id: "obj" + strconv.Itoa((w+1)%writers*objects+i)}
+				lb.GetOrAssignObject(other)
+				lb.Migrating(other)
+				if i%3 == 0 {
+					if err := lb.UnassignObject(obj); err != nil {
+						fail(err)
+						return
+					}
+				}
+				if i%5 == 0 {
+					if _, err := lb.RemoveObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+						fail(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 3; i < 20; i++ {
+			node := newNode(i)
+			if _, err := lb.AddNodes([]serverpool.Node[string, string]{node}); err != nil {
+				fail(err)
+				return
+			}
+			if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{node}); err != nil {
+				fail(err)
+				return
+			}
+			for obj := range lb.Objects() {
+				_ = obj.Id
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Objects not removed by their writer stay. GetOrAssignObject adds the
+	// objects it looks up, so the lookups of others may add removed ones back.
+	present := make(map[string]bool)
+	for obj := range lb.Objects() {
+		present[obj.Id] = true
+	}
+	for i := range writers * objects {
+		if id := "obj" + strconv.Itoa(i); !present[id] && i%objects%5 != 0 {
+			t.Fatalf("expected %s kept", id)
+		}
+	}
+	if len(present) > writers*objects {
+		t.Fatalf("expected at most %d objects, got %d", writers*objects, len(present))
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected a consistent state, got %v", err)
+	}
+}
+
+// Compare lookups of assigned objects, which share the read lock, with
+// assignments, which take the write lock, from concurrent goroutines with
+// go test -bench ConcurrentObjects -cpu 1,8
+func BenchmarkConcurrentObjects(b *testing.B) {
+	lb := NewConcurrentLoadBalancer[string, string]()
+	var nodes []serverpool.Node[string, string]
+	for i := range 8 {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		b.Fatalf("expected no error, got %v", err)
+	}
+	objs := make([]*serverpool.Object[string, string], 1024)
+	for i := range objs {
+		objs[i] = &serverpool.Object[string, string]{Id: "obj" + strconv.Itoa(i)}
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		b.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			b.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	for _, bench := range []struct {
+		name string
+
+		// One in every writes operations assigns, the others look up
+		writes int
+	}{
+		{"lookup", 0},
+		{"assign", 1},
+		{"mixed", 5},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					obj := objs[i%len(objs)]
+					if bench.writes > 0 && i%bench.writes == 0 {
+						lb.AssignObject(obj)
+					} else {
+						lb.GetOrAssignObject(obj)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Allocation of the bucket ids handed out by a hasher.
+package consistenthash
+
+// BucketAllocator maps the dense slots the memento algorithm works with to
+// the bucket ids seen by callers. Memento decides which slot a new bucket
+// takes and how keys are replaced when slots are removed, the allocator
+// decides the id each slot is known by.
+type BucketAllocator interface {
+	// Allocate the id of a bucket added in the given slot
+	Allocate(slot int) int
+
+	// Release the id of a removed bucket and return its slot,
+	// false if the id is not allocated
+	Release(id int) (slot int, ok bool)
+
+	// Slot of the bucket with the given id, false if the id is not
+	// allocated
+	Slot(id int) (slot int, ok bool)
+
+	// Id of the bucket in the given slot
+	ID(slot int) int
+}
+
+// sequentialAllocator uses the slot as the bucket id
+type sequentialAllocator struct{}
+
+func (sequentialAllocator) Allocate(slot int) int { return slot }
+
+func (sequentialAllocator) Release(id int) (int, bool) { return id, true }
+
+func (sequentialAllocator) Slot(id int) (int, bool) { return id, id >= 0 }
+
+func (sequentialAllocator) ID(slot int) int { return slot }
+
+// mappedAllocator hands out ids chosen by the caller
+type mappedAllocator struct {
+	next func(slot int) int
+
+	// Id of each slot, indexed by slot
+	ids []int
+
+	// Slot of each allocated id
+	slots map[int]int
+}
+
+// NewMappedAllocator creates an allocator that asks next for the id of each
+// added bucket, e.g. to use ids assigned by a database. Ids must be unique
+// among the buckets in use.
+func NewMappedAllocator(next func(slot int) int) BucketAllocator {
+	return &mappedAllocator{next: next, slots: make(map[int]int)}
+}
+
+func (a *mappedAllocator) Allocate(slot int) int {
+	id := a.next(slot)
+	for len(a.ids) <= slot {
+		a.ids = append(a.ids, -1)
+	}
+	a.ids[slot] = id
+	a.slots[id] = slot
+	return id
+}
+
+func (a *mappedAllocator) Release(id int) (int, bool) {
+	slot, ok := a.slots[id]
+	if !ok {
+		return -1, false
+	}
+	delete(a.slots, id)
+	return slot, true
+}
+
+func (a *mappedAllocator) Slot(id int) (int, bool) {
+	slot, ok := a.slots[id]
+	if !ok {
+		return -1, false
+	}
+	return slot, true
+}
+
+func (a *mappedAllocator) ID(slot int) int {
+	if slot < 0 || slot >= len(a.ids) {
+		return -1
+	}
+	return a.ids[slot]
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Comparison of consistent hash implementations on identical scenarios.
+package consistenthash
+
+import (
+	"fmt"
+	"hashing"
+	"io"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Ring is the part of a consistent hash implementation a comparison
+// exercises. Libraries take node names rather than numbering buckets, so
+// hashers are compared through HasherRing, and other libraries through
+// adapters. The loadbalance command adapts stathat/consistent,
+// buraksezer/consistent and lafikl/consistent.
+type Ring interface {
+	Add(node string)
+	Remove(node string)
+	Get(key string) string
+}
+
+// hasherRing names the buckets of a hasher
+type hasherRing struct {
+	h       ConsistentHasher
+	buckets map[string]int
+	names   map[int]string
+}
+
+// HasherRing adapts a hasher to the Ring of a comparison
+func HasherRing(h ConsistentHasher) Ring {
+	return &hasherRing{h: h, buckets: make(map[string]int), names: make(map[int]string)}
+}
+
+func (r *hasherRing) Add(node string) {
+	bucket := r.h.AddBucket()
+	r.buckets[node], r.names[bucket] = bucket, node
+}
+
+func (r *hasherRing) Remove(node string) {
+	if bucket, ok := r.buckets[node]; ok {
+		r.h.RemoveBucket(bucket)
+		delete(r.buckets, node)
+		delete(r.names, bucket)
+	}
+}
+
+func (r *hasherRing) Get(key string) string {
+	return r.names[r.h.GetBucket(key)]
+}
+
+// virtualNodeRing is the classic ring of Karger et al: every node hashes to
+// several points of a circle and a key belongs to the first point after
+// its hash, the design of most consistent hashing libraries
+type virtualNodeRing struct {
+	hashing.HashFn
+	replicas int
+
+	// Points sorted by hash
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+// NewVirtualNodeRing creates a classic ring with the given number of points
+// per node, the baseline of comparisons
+func NewVirtualNodeRing(hashAlgo hashing.HashAlgorithm, replicas int) Ring {
+	return &virtualNodeRing{HashFn: hashing.NewHashFunction(hashAlgo), replicas: max(replicas, 1)}
+}
+
+func (r *virtualNodeRing) Add(node string) {
+	for i := range r.replicas {
+		r.points = append(r.points, ringPoint{mix64(r.HashString(node + "#" + strconv.Itoa(i))), node})
+	}
+	slices.SortFunc(r.points, func(a, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return 0
+	})
+}
+
+func (r *virtualNodeRing) Remove(node string) {
+	r.points = slices.DeleteFunc(r.points, func(p ringPoint) bool { return p.node == node })
+}
+
+func (r *virtualNodeRing) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := mix64(r.HashString(key))
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p ringPoint, h uint64) int {
+		switch {
+		case p.hash < h:
+			return -1
+		case p.hash > h:
+			return 1
+		}
+		return 0
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// Scenario is the workload every ring of a comparison runs
+type Scenario struct {
+	// Nodes added before measuring
+	Nodes int
+
+	// Keys whose node is compared before and after a membership change
+	Keys int
+
+	// Lookups timed for throughput
+	Lookups int
+}
+
+// DefaultScenario is a mid-sized pool
+var DefaultScenario = Scenario{Nodes: 100, Keys: 100000, Lookups: 1000000}
+
+// ComparisonResult is the outcome of one ring on a scenario
+type ComparisonResult struct {
+	Name string
+
+	// Mean time of a lookup
+	Lookup time.Duration
+
+	// Share of the keys that changed node when a node was added and when
+	// one was removed. The least possible are 1/(Nodes+1) and 1/Nodes.
+	RemapOnAdd, RemapOnRemove float64
+
+	// Heap bytes allocated building the ring with its nodes
+	Memory uint64
+}
+
+// Compare runs the scenario on a fresh ring from each constructor, one
+// ring at a time so their allocations do not mix
+func Compare(rings map[string]func() Ring, s Scenario) []ComparisonResult {
+	names := make([]string, 0, len(rings))
+	for name := range rings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	keys := make([]string, s.Keys)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	mapping := func(r Ring) []string {
+		m := make([]string, len(keys))
+		for i, key := range keys {
+			m[i] = r.Get(key)
+		}
+		return m
+	}
+	changed := func(a, b []string) float64 {
+		n := 0
+		for i := range a {
+			if a[i] != b[i] {
+				n++
+			}
+		}
+		return float64(n) / float64(max(len(a), 1))
+	}
+
+	results := make([]ComparisonResult, 0, len(names))
+	for _, name := range names {
+		result := ComparisonResult{Name: name}
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		r := rings[name]()
+		for i := range s.Nodes {
+			r.Add("node" + strconv.Itoa(i))
+		}
+		runtime.ReadMemStats(&after)
+		result.Memory = after.TotalAlloc - before.TotalAlloc
+
+		if s.Lookups > 0 && len(keys) > 0 {
+			start := time.Now()
+			for i := range s.Lookups {
+				r.Get(keys[i%len(keys)])
+			}
+			result.Lookup = time.Since(start) / time.Duration(s.Lookups)
+		}
+
+		base := mapping(r)
+		r.Add("node" + strconv.Itoa(s.Nodes))
+		result.RemapOnAdd = changed(base, mapping(r))
+		r.Remove("node" + strconv.Itoa(s.Nodes))
+		base = mapping(r)
+		r.Remove("node0")
+		result.RemapOnRemove = changed(base, mapping(r))
+		runtime.KeepAlive(r)
+
+		results = append(results, result)
+	}
+	return results
+}
+
+// WriteComparisonReport writes the results of a comparison as a Markdown
+// table
+func WriteComparisonReport(w io.Writer, s Scenario, results []ComparisonResult) error {
+	_, err := fmt.Fprintf(w, "Consistent hash comparison: %d nodes, %d keys, %d lookups, %s/%s\n\n"+
+		"| Ring | Lookup | Remap on add | Remap on remove | Memory |\n|---|---|---|---|---|\n",
+		s.Nodes, s.Keys, s.Lookups, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		_, err := fmt.Fprintf(w, "| %s | %v | %.2f%% | %.2f%% | %d B |\n",
+			r.Name, r.Lookup, 100*r.RemapOnAdd, 100*r.RemapOnRemove, r.Memory)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "\nLeast possible remap: %.2f%% on add, %.2f%% on remove.\n",
+		100/float64(s.Nodes+1), 100/float64(max(s.Nodes, 1)))
+	return err
+}
+
+// BuiltinRings are the hashers of this package and a classic ring of 160
+// points per node, the default of many ring libraries, for comparisons
+func BuiltinRings(hashAlgo hashing.HashAlgorithm) map[string]func() Ring {
+	return map[string]func() Ring{
+		"memento":    func() Ring { return HasherRing(NewMementoHasher(hashAlgo)) },
+		"rendezvous": func() Ring { return HasherRing(NewRendezvousHasher(hashAlgo)) },
+		"maglev":     func() Ring { return HasherRing(NewMaglevHasher(hashAlgo, DefaultMaglevTableSize)) },
+		"ring":       func() Ring { return NewVirtualNodeRing(hashAlgo, 160) },
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"hashing"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	s := Scenario{Nodes: 20, Keys: 20000, Lookups: 1000}
+	results := Compare(BuiltinRings(hashing.DefaultHashAlgorithm), s)
+	if len(results) != 4 || results[0].Name != "maglev" {
+		t.Fatalf("expected 4 results sorted by name, got %+v", results)
+	}
+	for _, r := range results {
+		// Each ring moves close to the least possible share of keys
+		if r.RemapOnAdd < 0.5/21 || r.RemapOnAdd > 2.0/21 {
+			t.Fatalf("%s: expected about %.3f of keys remapped on add, got %.3f", r.Name, 1.0/21, r.RemapOnAdd)
+		}
+		if r.RemapOnRemove < 0.5/20 || r.RemapOnRemove > 2.0/20 {
+			t.Fatalf("%s: expected about %.3f of keys remapped on remove, got %.3f", r.Name, 1.0/20, r.RemapOnRemove)
+		}
+		if r.Lookup <= 0 || r.Memory == 0 {
+			t.Fatalf("%s: expected lookup time and memory, got %+v", r.Name, r)
+		}
+	}
+
+	var report strings.Builder
+	if err := WriteComparisonReport(&report, s, results); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, name := range []string{"| maglev |", "| memento |", "| rendezvous |", "| ring |", "20 nodes"} {
+		if !strings.Contains(report.String(), name) {
+			t.Fatalf("expected %q in the report:\n%s", name, report.String())
+		}
+	}
+}
+
+func BenchmarkRingGet(b *testing.B) {
+	for name, ring := range BuiltinRings(hashing.DefaultHashAlgorithm) {
+		b.Run(name, func(b *testing.B) {
+			r := ring()
+			for i := range DefaultScenario.Nodes {
+				r.Add("node" + strconv.Itoa(i))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.Get("object-key-1234")
+			}
+		})
+	}
+}
@@ -6,9 +6,16 @@
 package consistenthash
 
 import (
+	"errors"
+	"fmt"
 	"hashing"
+	"unsafe"
 )
 
+// ErrEmptyRing is returned by LookupBucket for keys of a hasher without
+// buckets to map them to
+var ErrEmptyRing = errors.New("hash ring has no buckets")
+
 type ConsistentHasher interface {
 	// Add a bucket to the hash ring
 	AddBucket() (int)
@@ -16,13 +23,38 @@ type ConsistentHasher interface {
 	// Remove a bucket from the hash ring
 	RemoveBucket(bucket int) int
 
-	// Get the bucket responsible for the given key
+	// Get the bucket responsible for the given key, -1 if there is none
+	// such as when all buckets are removed
 	GetBucket(key string) int
 
+	// Get up to n distinct buckets for the given key, the one GetBucket
+	// returns first, fewer if the working set is smaller
+	GetBuckets(key string, n int) []int
+
 	// Get the size of the working set
 	Size() int
 }
 
+// LookupBucket gets the bucket of a key, failing with ErrEmptyRing rather
+// than returning -1 when the hasher has no bucket for it
+func LookupBucket(h ConsistentHasher, key string) (int, error) {
+	if h.Size() == 0 {
+		return -1, ErrEmptyRing
+	}
+	bucket := h.GetBucket(key)
+	if bucket < 0 {
+		return -1, fmt.Errorf("%w for key %q", ErrEmptyRing, key)
+	}
+	return bucket, nil
+}
+
+// GetBucketBytes gets the bucket of a binary key, the bucket of the string
+// of the same bytes, without copying the key. Hashers only read the key
+// while looking it up, so the key may be reused once it returns.
+func GetBucketBytes(h ConsistentHasher, key []byte) int {
+	return h.GetBucket(unsafe.String(unsafe.SliceData(key), len(key)))
+}
+
 func NewConsistentHasher() ConsistentHasher {
 	return NewMementoHasher(hashing.DefaultHashAlgorithm)
 }
@@ -30,3 +62,54 @@ func NewConsistentHasher() ConsistentHasher {
 func NewConsistentHasherWithAlgo(algo hashing.HashAlgorithm) ConsistentHasher {
 	return NewMementoHasher(algo)
 }
+
+// Strategy is a consistent hash algorithm
+type Strategy int
+
+const (
+	// Memento hashing, fast lookups in any pool size
+	StrategyMemento Strategy = iota
+
+	// Rendezvous hashing, lookups linear in the pool size but weights and
+	// replicas come naturally, a fit for small pools that change often
+	StrategyRendezvous
+
+	// Maglev hashing, lookups in a table of fixed size, for lookup heavy
+	// pools whose membership changes rarely
+	StrategyMaglev
+)
+
+var strategyNames = map[Strategy]string{
+	StrategyMemento:    "memento",
+	StrategyRendezvous: "rendezvous",
+	StrategyMaglev:     "maglev",
+}
+
+func (s Strategy) String() string {
+	if name, ok := strategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// ParseStrategy returns the strategy with the given name
+func ParseStrategy(name string) (Strategy, error) {
+	for s, n := range strategyNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash strategy %q", name)
+}
+
+// NewConsistentHasherWithStrategy creates a hasher of the given strategy,
+// memento for unknown strategies
+func NewConsistentHasherWithStrategy(strategy Strategy, algo hashing.HashAlgorithm) ConsistentHasher {
+	switch strategy {
+	case StrategyRendezvous:
+		return NewRendezvousHasher(algo)
+	case StrategyMaglev:
+		return NewMaglevHasher(algo, DefaultMaglevTableSize)
+	}
+	return NewMementoHasher(algo)
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Multi-level hashing of hierarchical keys such as tenant/table/partition.
+package consistenthash
+
+import (
+	"fmt"
+	"hashing"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// LevelStrategy decides how one level of a hierarchical key affects its bucket
+type LevelStrategy int
+
+const (
+	// The level selects the group of buckets, keys that only differ in
+	// later levels land in the same group
+	LevelGroup LevelStrategy = iota
+
+	// The level spreads keys across the buckets of their group
+	LevelSpread
+
+	// The level does not affect the bucket
+	LevelIgnore
+)
+
+var levelStrategyNames = map[LevelStrategy]string{
+	LevelGroup:  "group",
+	LevelSpread: "spread",
+	LevelIgnore: "ignore",
+}
+
+func (s LevelStrategy) String() string {
+	if name, ok := levelStrategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("LevelStrategy(%d)", int(s))
+}
+
+// hierarchical splits keys into levels and maps the group levels to a group
+// of buckets of the wrapped hasher, then picks a bucket within the group
+// from the spread levels
+type hierarchical struct {
+	hashing.HashFn
+
+	// Hasher that owns the buckets
+	ConsistentHasher
+
+	// Separator between levels
+	separator string
+
+	// Buckets in each group
+	groupSize int
+
+	// Strategy for each level, levels past the end are spread
+	levels []LevelStrategy
+
+	// Groups by group key, cleared when buckets are added or removed
+	mu     sync.Mutex
+	groups map[string][]int
+}
+
+// Group keys whose groups are kept before the cache starts over
+const maxCachedGroups = 4096
+
+// Split the key into the parts selecting the group and spreading within it
+func (h *hierarchical) split(key string) (group, spread string) {
+	var groupParts, spreadParts []string
+	for i, part := range strings.Split(key, h.separator) {
+		strategy := LevelSpread
+		if i < len(h.levels) {
+			strategy = h.levels[i]
+		}
+		switch strategy {
+		case LevelGroup:
+			groupParts = append(groupParts, part)
+		case LevelSpread:
+			spreadParts = append(spreadParts, part)
+		}
+	}
+	return strings.Join(groupParts, h.separator), strings.Join(spreadParts, h.separator)
+}
+
+// Buckets of the slots of the group. Slot i holds the bucket of the i-th
+// replica key of the group key, so a slot only changes when its bucket is
+// removed, or rebuilt, and then only moves the keys of that bucket. Slots
+// may share a bucket, which gives it their keys. Groups are cached until
+// membership changes, so a lookup costs one map access rather than
+// groupSize lookups in the wrapped hasher.
+func (h *hierarchical) group(key string) []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if group, ok := h.groups[key]; ok {
+		return group
+	}
+	if h.groups == nil || len(h.groups) >= maxCachedGroups {
+		h.groups = make(map[string][]int)
+	}
+	group := h.slots(key)
+	h.groups[key] = group
+	return group
+}
+
+// Look up the buckets of the slots of the group of key in the wrapped
+// hasher, none if it has no buckets
+func (h *hierarchical) slots(key string) []int {
+	slots := make([]int, h.groupSize)
+	for i := range slots {
+		if slots[i] = h.ConsistentHasher.GetBucket(ReplicaKey(key, i)); slots[i] < 0 {
+			return nil
+		}
+	}
+	return slots
+}
+
+// Get the bucket of a hierarchical key: the spread levels pick a slot of
+// the group by jump hashing over the slots, whose order never changes
+func (h *hierarchical) GetBucket(key string) int {
+	groupKey, spreadKey := h.split(key)
+	if h.groupSize <= 1 || spreadKey == "" {
+		return h.ConsistentHasher.GetBucket(groupKey)
+	}
+	group := h.group(groupKey)
+	if len(group) == 0 {
+		return -1
+	}
+	return group[jumpHash(h.HashString(spreadKey), len(group))]
+}
+
+// Add a bucket to the wrapped hasher, which may change groups
+func (h *hierarchical) AddBucket() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.groups = nil
+	return h.ConsistentHasher.AddBucket()
+}
+
+// Remove a bucket from the wrapped hasher, which changes the groups it is in
+func (h *hierarchical) RemoveBucket(bucket int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.groups = nil
+	return h.ConsistentHasher.RemoveBucket(bucket)
+}
+
+// Get distinct buckets of a hierarchical key: the bucket GetBucket returns,
+// the other buckets of its group in slot order, then buckets of the group
+// key outside the group
+func (h *hierarchical) GetBuckets(key string, n int) []int {
+	n = min(n, h.ConsistentHasher.Size())
+	first := h.GetBucket(key)
+	if n <= 0 || first < 0 {
+		return nil
+	}
+	buckets := []int{first}
+	groupKey, _ := h.split(key)
+	if h.groupSize > 1 {
+		for _, bucket := range h.group(groupKey) {
+			if len(buckets) < n && !slices.Contains(buckets, bucket) {
+				buckets = append(buckets, bucket)
+			}
+		}
+	}
+	return AppendReplicas(buckets, groupKey, n, func(key string) (int, bool) {
+		bucket := h.ConsistentHasher.GetBucket(key)
+		return bucket, bucket >= 0
+	})
+}
+
+// NewHierarchicalHasher wraps a consistent hasher for keys made of levels
+// joined by separator, with one strategy per level. Keys with the same
+// group levels map to the same groupSize buckets, e.g. with levels
+// LevelGroup, LevelGroup, LevelSpread all partitions of a tenant's table
+// share a group of nodes. A groupSize of 1 places each group on one bucket.
+func NewHierarchicalHasher(hasher ConsistentHasher, hashAlgo hashing.HashAlgorithm, separator string, groupSize int, levels ...LevelStrategy) ConsistentHasher {
+	return &hierarchical{HashFn: hashing.NewHashFunction(hashAlgo), ConsistentHasher: hasher,
+		separator: separator, groupSize: max(groupSize, 1), levels: levels}
+}
+
+// Estimate the bytes used by the wrapped hasher
+func (h *hierarchical) MemoryUsage() int {
+	if m, ok := h.ConsistentHasher.(interface{ MemoryUsage() int }); ok {
+		return m.MemoryUsage()
+	}
+	return 0
+}
+
+func (h *hierarchical) String() string {
+	return fmt.Sprintf("Hierarchical{separator: %q, groupSize: %d, levels: %v, hasher: %v}",
+		h.separator, h.groupSize, h.levels, h.ConsistentHasher)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"hashing"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestHierarchicalHasher(t *testing.T) {
+	tests := []struct {
+		name      string
+		groupSize int
+		levels    []LevelStrategy
+		wantMin   int
+		wantMax   int
+	}{
+		{name: "table on one bucket", groupSize: 1, levels: []LevelStrategy{LevelGroup, LevelGroup, LevelSpread}, wantMin: 1, wantMax: 1},
+		{name: "table on a group", groupSize: 3, levels: []LevelStrategy{LevelGroup, LevelGroup, LevelSpread}, wantMin: 2, wantMax: 3},
+		{name: "partition ignored", groupSize: 3, levels: []LevelStrategy{LevelGroup, LevelGroup, LevelIgnore}, wantMin: 1, wantMax: 1},
+		{name: "tenant on a group", groupSize: 4, levels: []LevelStrategy{LevelGroup}, wantMin: 2, wantMax: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHierarchicalHasher(NewMementoHasher(hashing.DefaultHashAlgorithm), hashing.DefaultHashAlgorithm, "/", tt.groupSize, tt.levels...)
+			for i := 0; i < 16; i++ {
+				h.AddBucket()
+			}
+
+			buckets := make(map[int]bool)
+			for p := 0; p < 100; p++ {
+				buckets[h.GetBucket("tenant1/table1/"+strconv.Itoa(p))] = true
+			}
+			if len(buckets) < tt.wantMin || len(buckets) > tt.wantMax {
+				t.Errorf("partitions spread over %d buckets, want %d to %d", len(buckets), tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestHierarchicalHasherRemoval(t *testing.T) {
+	h := NewHierarchicalHasher(NewMementoHasher(hashing.DefaultHashAlgorithm), hashing.DefaultHashAlgorithm, "/", 4, LevelGroup, LevelSpread)
+	for i := 0; i < 16; i++ {
+		h.AddBucket()
+	}
+
+	before := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := "tenant" + strconv.Itoa(i%25) + "/" + strconv.Itoa(i)
+		before[key] = h.GetBucket(key)
+	}
+	removed := before["tenant0/0"]
+	h.RemoveBucket(removed)
+
+	// Only the keys of the removed bucket move
+	moved := 0
+	for key, bucket := range before {
+		after := h.GetBucket(key)
+		switch {
+		case bucket == removed:
+			if after == removed {
+				t.Fatalf("expected %s to leave bucket %d", key, removed)
+			}
+			moved++
+		case after != bucket:
+			t.Fatalf("expected %s to stay on bucket %d, got %d", key, bucket, after)
+		}
+	}
+	if moved == 0 {
+		t.Fatalf("expected keys on bucket %d", removed)
+	}
+
+	for key := range before {
+		buckets := h.GetBuckets(key, 4)
+		if len(buckets) != 4 || buckets[0] != h.GetBucket(key) {
+			t.Fatalf("expected 4 buckets led by %d for %s, got %v", h.GetBucket(key), key, buckets)
+		}
+		if slices.Contains(buckets, removed) || len(slices.Compact(slices.Sorted(slices.Values(buckets)))) != 4 {
+			t.Fatalf("expected 4 distinct buckets for %s, got %v", key, buckets)
+		}
+	}
+}
@@ -5,15 +5,32 @@
 // Implementation of JunpHash consistent hashing algorithm.
 package consistenthash
 
+import "math"
+
 func jumpHash(key uint64, numBuckets int) int {
+	return int(JumpHash64(key, int64(numBuckets)))
+}
+
+// JumpHash64 maps key to a bucket in [0, numBuckets) with the jump
+// consistent hash of Lamping and Veach. It gives the same buckets as the
+// reference implementation for up to 2^31 buckets and stays correct for any
+// positive int64 bucket count, where the reference overflows converting the
+// next jump to an integer. Bucket counts below 1 give -1.
+func JumpHash64(key uint64, numBuckets int64) int64 {
 	var b int64 = -1
 	var j int64
 
-	for j < int64(numBuckets) {
+	for j < numBuckets {
 		b = j
 		key = key*2862933555777941757 + 1
-		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+		next := float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1))
+
+		// A jump past the range of int64 is past every bucket
+		if next >= math.MaxInt64 {
+			break
+		}
+		j = int64(next)
 	}
 
-	return int(b)
+	return b
 }
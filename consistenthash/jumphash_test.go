@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJumpHash64(t *testing.T) {
+	// Vectors of the reference implementation
+	tests := []struct {
+		key      uint64
+		buckets  int64
+		expected int64
+	}{
+		{key: 1, buckets: 1, expected: 0},
+		{key: 42, buckets: 57, expected: 43},
+		{key: 0xDEAD10CC, buckets: 1, expected: 0},
+		{key: 0xDEAD10CC, buckets: 666, expected: 361},
+		{key: 256, buckets: 1024, expected: 520},
+		{key: 0, buckets: 0, expected: -1},
+	}
+	for _, tt := range tests {
+		if got := JumpHash64(tt.key, tt.buckets); got != tt.expected {
+			t.Fatalf("JumpHash64(%d, %d) = %d, want %d", tt.key, tt.buckets, got, tt.expected)
+		}
+	}
+
+	boundaries := []int64{math.MaxInt32 - 1, math.MaxInt32, math.MaxInt32 + 1, 1 << 32,
+		1<<32 + 1, 1 << 53, 1<<53 + 1, math.MaxInt64 - 1, math.MaxInt64}
+	key := uint64(0x9E3779B97F4A7C15)
+	for i := 0; i < 2000; i++ {
+		key = key*6364136223846793005 + 1442695040888963407
+		for _, n := range boundaries {
+			// Every bucket is in range, and one more bucket either keeps
+			// the key where it was or moves it to the new bucket
+			got := JumpHash64(key, n)
+			if got < 0 || got >= n {
+				t.Fatalf("JumpHash64(%d, %d) = %d, out of range", key, n, got)
+			}
+			if n == math.MaxInt64 {
+				continue
+			}
+			if next := JumpHash64(key, n+1); next != got && next != n {
+				t.Fatalf("JumpHash64(%d, %d) = %d but JumpHash64(%d, %d) = %d", key, n, got, key, n+1, next)
+			}
+		}
+
+		// The reference range agrees with the int sized helper
+		if got, want := JumpHash64(key, 1000), int64(jumpHash(key, 1000)); got != want {
+			t.Fatalf("JumpHash64(%d, 1000) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestJumpHash64Uniform(t *testing.T) {
+	// Keys spread evenly over a range far past 2^31 buckets
+	const n, keys = int64(1) << 40, 100000
+	var quarters [4]int
+	key := uint64(1)
+	for i := 0; i < keys; i++ {
+		key = key*6364136223846793005 + 1442695040888963407
+		quarters[JumpHash64(key, n)/(n/4)]++
+	}
+	for q, count := range quarters {
+		if count < keys/4*95/100 || count > keys/4*105/100 {
+			t.Fatalf("quarter %d got %d of %d keys", q, count, keys)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Precomputed lookup table for periods of stable membership.
+package consistenthash
+
+import (
+	"fmt"
+	"hashing"
+	"strconv"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// DefaultLookupTableSize is a prime number of slots large enough to
+	// keep the share of each bucket close to even for a few hundred buckets
+	DefaultLookupTableSize = 65537
+)
+
+// lookupTable compiles the state of another hasher into a flat table of
+// slots used for reads. Keys are hashed to a slot and each slot holds the
+// bucket the wrapped hasher assigns to that slot. The table is rebuilt on
+// the first lookup after a membership change. Concurrent lookups may each
+// rebuild it, but never see a partly built table.
+type lookupTable struct {
+	hashing.HashFn
+
+	// Hasher that owns the bucket history
+	ConsistentHasher
+
+	// Bucket for each slot, nil when the table must be rebuilt
+	table atomic.Pointer[[]int]
+
+	// Number of slots in the table
+	size int
+}
+
+// Rebuild the table from the wrapped hasher
+func (l *lookupTable) build() []int {
+	table := make([]int, l.size)
+	for slot := range table {
+		table[slot] = l.ConsistentHasher.GetBucket(strconv.Itoa(slot))
+	}
+	l.table.Store(&table)
+	return table
+}
+
+// Add a bucket and invalidate the table
+func (l *lookupTable) AddBucket() int {
+	l.table.Store(nil)
+	return l.ConsistentHasher.AddBucket()
+}
+
+// Remove a bucket and invalidate the table
+func (l *lookupTable) RemoveBucket(bucket int) int {
+	l.table.Store(nil)
+	return l.ConsistentHasher.RemoveBucket(bucket)
+}
+
+// Get the bucket from the table, rebuilding it if membership changed
+func (l *lookupTable) GetBucket(key string) int {
+	table := l.table.Load()
+	if table == nil {
+		t := l.build()
+		table = &t
+	}
+	return (*table)[l.HashString(key)%uint64(l.size)]
+}
+
+// Get distinct buckets for the replica keys from the table
+func (l *lookupTable) GetBuckets(key string, n int) []int {
+	return getBuckets(l, key, n)
+}
+
+// NewLookupTableHasher wraps a consistent hasher with a lookup table of the
+// given number of slots. Keys in the same slot always share a bucket, and a
+// membership change only moves the slots the wrapped hasher moves.
+func NewLookupTableHasher(hasher ConsistentHasher, hashAlgo hashing.HashAlgorithm, size int) ConsistentHasher {
+	if size <= 0 {
+		size = DefaultLookupTableSize
+	}
+	return &lookupTable{HashFn: hashing.NewHashFunction(hashAlgo), ConsistentHasher: hasher, size: size}
+}
+
+// Estimate the bytes used by the table and the wrapped hasher
+func (l *lookupTable) MemoryUsage() int {
+	size := 0
+	if table := l.table.Load(); table != nil {
+		size = cap(*table) * int(unsafe.Sizeof(int(0)))
+	}
+	if m, ok := l.ConsistentHasher.(interface{ MemoryUsage() int }); ok {
+		size += m.MemoryUsage()
+	}
+	return size
+}
+
+func (l *lookupTable) String() string {
+	return fmt.Sprintf("LookupTable{size: %d, built: %t, hasher: %v}", l.size, l.table.Load() != nil, l.ConsistentHasher)
+}
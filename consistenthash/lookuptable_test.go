@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"hashing"
+	"strconv"
+	"testing"
+)
+
+func TestLookupTable(t *testing.T) {
+	m := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	l := NewLookupTableHasher(m, hashing.DefaultHashAlgorithm, 101).(*lookupTable)
+
+	for i := 0; i < 5; i++ {
+		l.AddBucket()
+	}
+
+	check := func() {
+		for slot := 0; slot < l.size; slot++ {
+			if got, want := (*l.table.Load())[slot], m.GetBucket(strconv.Itoa(slot)); got != want {
+				t.Fatalf("slot %d = %d, want %d", slot, got, want)
+			}
+		}
+	}
+
+	l.GetBucket("testkey1")
+	check()
+
+	// Removing a bucket invalidates the table and the next lookup rebuilds it
+	l.RemoveBucket(2)
+	if l.table.Load() != nil {
+		t.Fatalf("expected table to be invalidated")
+	}
+	if got := l.GetBucket("testkey1"); got == 2 {
+		t.Fatalf("GetBucket() returned removed bucket %d", got)
+	}
+	check()
+}
+
+func BenchmarkLookupTableGetBucket(b *testing.B) {
+	l := NewLookupTableHasher(NewMementoHasher(hashing.DefaultHashAlgorithm), hashing.DefaultHashAlgorithm, 0)
+	for i := 0; i < 1000; i++ {
+		l.AddBucket()
+	}
+	for i := 0; i < 1000; i += 2 {
+		l.RemoveBucket(i)
+	}
+	l.GetBucket("warmup")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.GetBucket("object-key-1234")
+	}
+}
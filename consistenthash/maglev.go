@@ -0,0 +1,208 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Implementation of Maglev lookup table hashing.
+package consistenthash
+
+import (
+	"fmt"
+	"hashing"
+	"slices"
+	"strconv"
+	"unsafe"
+)
+
+const (
+	// DefaultMaglevTableSize is the prime number of slots of a Maglev
+	// table, enough to keep the shares of a few hundred buckets within a
+	// percent of each other
+	DefaultMaglevTableSize = 65537
+)
+
+// maglev maps keys to a table of slots, each owned by a bucket, so a lookup
+// is one hash and one index whatever the size of the working set. Every
+// bucket has a permutation of the slots, its order of preference, and
+// claims slots in that order.
+//
+// Unlike the Maglev paper, which repopulates the whole table, the table is
+// updated in place: an added bucket takes its share of slots from the
+// buckets holding more than that share, and the slots of a removed bucket
+// go to the buckets holding the fewest. Only the slots that change owner
+// move keys, but the table depends on the order of the changes, not just
+// on the working set.
+type maglev struct {
+	hashing.HashFn
+
+	// Bucket owning each slot, -1 while the working set is empty
+	table []int
+
+	// Slots owned by each bucket in the working set
+	counts map[int]int
+
+	// Buckets in the working set, sorted
+	buckets []int
+
+	// Removed buckets, the most recently removed last, reused by AddBucket
+	free []int
+
+	// Smallest bucket never used
+	next int
+}
+
+// Slot at position i of the permutation of a bucket
+type permutation struct {
+	offset, skip int
+}
+
+func (m *maglev) permutation(bucket int) permutation {
+	size := uint64(len(m.table))
+	name := strconv.Itoa(bucket)
+	return permutation{
+		offset: int(mix64(m.HashStringWithSeed(name, 0)) % size),
+		skip:   int(mix64(m.HashStringWithSeed(name, 1))%(size-1)) + 1,
+	}
+}
+
+func (p permutation) slot(i, size int) int {
+	return (p.offset + i*p.skip) % size
+}
+
+// Get the bucket owning the slot of the key
+func (m *maglev) GetBucket(key string) int {
+	if len(m.buckets) == 0 {
+		return -1
+	}
+	return m.table[mix64(m.HashString(key))%uint64(len(m.table))]
+}
+
+// Get distinct buckets for the replica keys from the table
+func (m *maglev) GetBuckets(key string, n int) []int {
+	return getBuckets(m, key, n)
+}
+
+// Add a bucket, the most recently removed one if any, and give it its share
+// of the slots
+func (m *maglev) AddBucket() int {
+	bucket := m.next
+	if len(m.free) > 0 {
+		bucket = m.free[len(m.free)-1]
+		m.free = m.free[:len(m.free)-1]
+	} else {
+		m.next++
+	}
+	i, _ := slices.BinarySearch(m.buckets, bucket)
+	m.buckets = slices.Insert(m.buckets, i, bucket)
+	m.counts[bucket] = 0
+
+	// Walk the permutation once, taking free slots and slots of buckets
+	// holding more than the share, or than one more slot than the new
+	// bucket once it has its share. Other counts only go down and the new
+	// one only goes up, so a slot passed over would not be taken later.
+	size := len(m.table)
+	share := size / len(m.buckets)
+	p := m.permutation(bucket)
+	for i := range size {
+		slot := p.slot(i, size)
+		owner := m.table[slot]
+		if owner >= 0 && m.counts[owner] <= max(share, m.counts[bucket]+1) {
+			continue
+		}
+		if owner >= 0 {
+			m.counts[owner]--
+		}
+		m.table[slot] = bucket
+		m.counts[bucket]++
+	}
+	return bucket
+}
+
+// Remove a bucket, returning it or -1 if it is not in the working set. Each
+// of its slots goes to the bucket holding the fewest, which takes the next
+// free slot in its permutation.
+func (m *maglev) RemoveBucket(bucket int) int {
+	i, ok := slices.BinarySearch(m.buckets, bucket)
+	if !ok {
+		return -1
+	}
+	m.buckets = slices.Delete(m.buckets, i, i+1)
+	m.free = append(m.free, bucket)
+	freed := m.counts[bucket]
+	delete(m.counts, bucket)
+	for slot, owner := range m.table {
+		if owner == bucket {
+			m.table[slot] = -1
+		}
+	}
+	if len(m.buckets) == 0 {
+		return bucket
+	}
+
+	size := len(m.table)
+	perms := make([]permutation, len(m.buckets))
+	for i, b := range m.buckets {
+		perms[i] = m.permutation(b)
+	}
+	next := make([]int, len(m.buckets))
+	for ; freed > 0; freed-- {
+		fewest := 0
+		for i, b := range m.buckets {
+			if m.counts[b] < m.counts[m.buckets[fewest]] {
+				fewest = i
+			}
+		}
+		slot := perms[fewest].slot(next[fewest], size)
+		for m.table[slot] >= 0 {
+			next[fewest]++
+			slot = perms[fewest].slot(next[fewest], size)
+		}
+		m.table[slot] = m.buckets[fewest]
+		m.counts[m.buckets[fewest]]++
+	}
+	return bucket
+}
+
+// Get size of the working set
+func (m *maglev) Size() int {
+	return len(m.buckets)
+}
+
+// Estimate the bytes used by the hasher
+func (m *maglev) MemoryUsage() int {
+	word := unsafe.Sizeof(int(0))
+	return int(unsafe.Sizeof(*m)) + (cap(m.table)+cap(m.buckets)+cap(m.free))*int(word) +
+		hashing.MapBytes(len(m.counts), 2*word)
+}
+
+func (m *maglev) String() string {
+	return fmt.Sprintf("maglev{size: %d, buckets: %v}", len(m.table), m.buckets)
+}
+
+// Smallest prime at least n
+func nextPrime(n int) int {
+	for ; ; n++ {
+		prime := n >= 2
+		for d := 2; prime && d*d <= n; d++ {
+			prime = n%d != 0
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+// NewMaglevHasher creates a Maglev hasher with a table of the given number
+// of slots, rounded up to a prime, or DefaultMaglevTableSize if size is not
+// positive. The table should have about 100 slots per bucket or more for
+// even shares. Adding or removing a bucket costs time linear in the size of
+// the table.
+func NewMaglevHasher(hashAlgo hashing.HashAlgorithm, size int) ConsistentHasher {
+	if size <= 0 {
+		size = DefaultMaglevTableSize
+	}
+	table := make([]int, nextPrime(size))
+	for slot := range table {
+		table[slot] = -1
+	}
+	return &maglev{HashFn: hashing.NewHashFunction(hashAlgo), table: table, counts: make(map[int]int)}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"hashing"
+	"strconv"
+	"testing"
+)
+
+func TestMaglev(t *testing.T) {
+	h := NewMaglevHasher(hashing.DefaultHashAlgorithm, 1000).(*maglev)
+	if len(h.table) != 1009 {
+		t.Fatalf("expected the table size rounded up to 1009, got %d", len(h.table))
+	}
+	if h.GetBucket("key") != -1 {
+		t.Fatal("expected no bucket without buckets")
+	}
+
+	// Every slot is owned and the shares stay within a few slots
+	check := func() {
+		counts := make(map[int]int)
+		for slot, b := range h.table {
+			if _, ok := h.counts[b]; !ok {
+				t.Fatalf("slot %d owned by bucket %d outside the working set", slot, b)
+			}
+			counts[b]++
+		}
+		share := len(h.table) / h.Size()
+		for _, b := range h.buckets {
+			if counts[b] != h.counts[b] {
+				t.Fatalf("bucket %d owns %d slots, counted %d", b, counts[b], h.counts[b])
+			}
+			if counts[b] < share-1 || counts[b] > share+3 {
+				t.Fatalf("expected about %d slots for bucket %d, got %d", share, b, counts[b])
+			}
+		}
+	}
+	for i := range 10 {
+		if b := h.AddBucket(); b != i {
+			t.Fatalf("expected bucket %d, got %d", i, b)
+		}
+		check()
+	}
+
+	mapping := func() []int {
+		m := make([]int, len(h.table))
+		copy(m, h.table)
+		return m
+	}
+	before := mapping()
+
+	// Only the slots of a removed bucket move
+	if h.RemoveBucket(3) != 3 || h.Size() != 9 || h.RemoveBucket(3) != -1 {
+		t.Fatalf("expected bucket 3 to be removed once, size %d", h.Size())
+	}
+	check()
+	after := mapping()
+	for slot, b := range after {
+		if before[slot] != 3 && b != before[slot] {
+			t.Fatalf("expected slot %d to stay in bucket %d, got %d", slot, before[slot], b)
+		}
+	}
+
+	// Only slots taken by an added bucket move
+	if b := h.AddBucket(); b != 3 {
+		t.Fatalf("expected bucket 3 back, got %d", b)
+	}
+	check()
+	for slot, b := range mapping() {
+		if b != 3 && b != after[slot] {
+			t.Fatalf("expected slot %d to stay in bucket %d, got %d", slot, after[slot], b)
+		}
+	}
+
+	for i := range 100 {
+		key := "key" + strconv.Itoa(i)
+		buckets := h.GetBuckets(key, 3)
+		if len(buckets) != 3 || buckets[0] != h.GetBucket(key) {
+			t.Fatalf("expected 3 buckets starting with %d, got %v", h.GetBucket(key), buckets)
+		}
+	}
+
+	for _, b := range []int{0, 5, 9, 1} {
+		h.RemoveBucket(b)
+		check()
+	}
+	for h.Size() > 1 {
+		h.RemoveBucket(h.buckets[0])
+	}
+	check()
+	h.RemoveBucket(h.buckets[0])
+	if h.GetBucket("key") != -1 {
+		t.Fatal("expected no bucket after removing all buckets")
+	}
+}
+
+func BenchmarkMaglevGetBucket(b *testing.B) {
+	h := NewMaglevHasher(hashing.DefaultHashAlgorithm, 0)
+	for i := 0; i < 1000; i++ {
+		h.AddBucket()
+	}
+	for i := 0; i < 1000; i += 2 {
+		h.RemoveBucket(i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.GetBucket("object-key-1234")
+	}
+}
@@ -8,6 +8,7 @@ package consistenthash
 import (
 	"fmt"
 	"hashing"
+	"unsafe"
 )
 
 type replace struct {
@@ -30,6 +31,9 @@ func (r *replace) String() string {
 type mementohash struct {
 	hashing.HashFn
 
+	// Maps slots to the bucket ids returned to callers, nil uses slots as ids
+	alloc BucketAllocator
+
 	// The number of buckets in the hash ring
 	buckets int
 
@@ -38,6 +42,15 @@ type mementohash struct {
 
 	// Information about the removed buckets
 	removed map[int]replace
+
+	// Rebuild once more buckets than this are removed, 0 for no limit
+	limit int
+
+	// Allocator ids come from after a rebuild, nil if not rebuilt
+	base BucketAllocator
+
+	// Number of rebuilds, each of which may move keys between buckets
+	rebuilds int
 }
 
 // Function to add a removed buck to the replace table
@@ -69,8 +82,31 @@ func (m *mementohash) restore(bucket int) int {
 	return -1
 }
 
+func (m *mementohash) allocator() BucketAllocator {
+	if m.alloc == nil {
+		return sequentialAllocator{}
+	}
+	return m.alloc
+}
+
 // Returns the getBucket for the given key
 func (m *mementohash) GetBucket(key string) int {
+	// No bucket can be returned once all buckets are removed
+	if m.Size() == 0 {
+		return -1
+	}
+	return m.allocator().ID(m.getSlot(key))
+}
+
+// Returns up to n distinct buckets for the given key, in the order the
+// replica keys map to them
+func (m *mementohash) GetBuckets(key string, n int) []int {
+	return getBuckets(m, key, n)
+}
+
+// Returns the slot for the given key
+func (m *mementohash) getSlot(key string) int {
+
 	// Use Jump Hash to get buck in range of [0, m.buckets)
 	bucket := jumpHash(m.HashString(key), m.buckets)
 
@@ -80,7 +116,7 @@ func (m *mementohash) GetBucket(key string) int {
 		// Get new bucket in remaining working set
 		// The replacement bucket is the size of the working set after removal
 		// Find new bucket in [0, replace - 1)
-		bucket = int(m.HashStringWithSeed(key, bucket)) % replace
+		bucket = int(m.HashStringWithSeed(key, bucket) % uint64(replace))
 
 		// If bucket is removed, follow replacement chain till we find a valid bucket
 		// in [0, replace -1)
@@ -108,27 +144,115 @@ func (m *mementohash) AddBucket() int {
 		m.buckets = bucket + 1
 	}
 
-	return bucket
+	return m.allocator().Allocate(bucket)
 }
 
 // Remove a bucket from the hash ring
-func (m *mementohash) RemoveBucket(bucket int) int {
-	// If the bucket is not in the hash ring, return
-	if bucket >= m.buckets {
+func (m *mementohash) RemoveBucket(id int) int {
+	bucket, ok := m.allocator().Slot(id)
+
+	// If the bucket is not in the hash ring, return keeping its id
+	if !ok || bucket >= m.buckets {
 		return -1
 	}
+	m.allocator().Release(id)
 
 	// If no buckets have been removed and the bucket to remove is last,
 	// just update the number of buckets
 	if len(m.removed) == 0 && bucket == m.buckets-1 {
 		m.lastRemoved = bucket
 		m.buckets = bucket
-		return bucket
+		return id
 	}
 	// Remove the bucket and add it to the replace table
 	m.lastRemoved = m.remove(bucket, m.Size()-1, m.lastRemoved)
 
-	return bucket
+	if m.limit > 0 && len(m.removed) > m.limit {
+		m.rebuild()
+	}
+	return id
+}
+
+// Renumber the working set into slots [0, size) with an empty removal table,
+// keeping the id of every bucket. Buckets in range keep their slot and those
+// beyond it fill the removed slots, so only keys of the moved buckets and
+// keys jump hash maps differently for the smaller range change bucket.
+func (m *mementohash) rebuild() {
+	if m.base == nil {
+		m.base = m.allocator()
+	}
+	old, size := m.allocator(), m.Size()
+
+	var holes []int
+	ids := make([]int, size)
+	for slot := range size {
+		if _, removed := m.removed[slot]; removed {
+			holes = append(holes, slot)
+		} else {
+			ids[slot] = old.ID(slot)
+		}
+	}
+	for slot := size; slot < m.buckets; slot++ {
+		if _, removed := m.removed[slot]; !removed {
+			ids[holes[0]] = old.ID(slot)
+			holes = holes[1:]
+		}
+	}
+
+	alloc := &mappedAllocator{ids: ids, slots: make(map[int]int, size)}
+	for slot, id := range ids {
+		alloc.slots[id] = slot
+	}
+
+	// New buckets get the id the original allocator would give them, or the
+	// lowest free id if another bucket already has it
+	next := m.base.Allocate
+	if base, ok := m.base.(*mappedAllocator); ok {
+		next = base.next
+	}
+	alloc.next = func(slot int) int {
+		id := next(slot)
+		if _, used := alloc.slots[id]; used {
+			for id = 0; ; id++ {
+				if _, used := alloc.slots[id]; !used {
+					break
+				}
+			}
+		}
+		return id
+	}
+
+	m.alloc = alloc
+	m.buckets, m.lastRemoved = size, size
+	m.removed = make(map[int]replace)
+	m.rebuilds++
+}
+
+// SetRemovedLimit bounds the removal table of a mementohash, which may be
+// wrapped by a lookup table or hierarchical hasher. When a removal leaves
+// more than limit buckets removed the hasher is rebuilt with every bucket
+// keeping its id, which moves some keys but keeps memory and lookup chains
+// bounded under perpetual churn. A limit of 0 removes the bound.
+func SetRemovedLimit(h ConsistentHasher, limit int) error {
+	m, ok := unwrapMemento(h)
+	if !ok {
+		return fmt.Errorf("cannot limit removed buckets of %T", h)
+	}
+	m.limit = limit
+	return nil
+}
+
+// Rebuilds returns how many times the removal table of a mementohash,
+// which may be wrapped by a lookup table or hierarchical hasher, was
+// rebuilt. Unlike a removal, a rebuild moves keys between buckets that
+// remain, so callers compare counts to tell when to re-place keys. False
+// if h is not a mementohash, whose removals may move such keys too.
+func Rebuilds(h ConsistentHasher) (int, bool) {
+	m, ok := unwrapMemento(h)
+	if !ok {
+		return 0, false
+	}
+	return m.rebuilds, true
 }
 
 // Get size of the working set
@@ -136,10 +260,36 @@ func (m *mementohash) Size() int {
 	return m.buckets - len(m.removed)
 }
 
+// MementoOption configures a mementohash
+type MementoOption func(m *mementohash)
+
+// WithHashFunction hashes keys with h instead of the hash algorithm, such
+// as a hash function of NewHashFunctionFromHasher
+func WithHashFunction(h hashing.HashFn) MementoOption {
+	return func(m *mementohash) {
+		m.HashFn = h
+	}
+}
+
 // NewMementoHasher creates a new instance of the mementohash consistent hashing algorithm
-func NewMementoHasher(hashAlgo hashing.HashAlgorithm) ConsistentHasher {
+func NewMementoHasher(hashAlgo hashing.HashAlgorithm, opts ...MementoOption) ConsistentHasher {
+	m := NewMementoHasherWithAllocator(hashAlgo, sequentialAllocator{}).(*mementohash)
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// NewMementoHasherWithAllocator creates a mementohash whose bucket ids are
+// assigned by the given allocator
+func NewMementoHasherWithAllocator(hashAlgo hashing.HashAlgorithm, alloc BucketAllocator) ConsistentHasher {
 	return &mementohash{removed: make(map[int]replace),
-		HashFn: hashing.NewHashFunction(hashAlgo)}
+		HashFn: hashing.NewHashFunction(hashAlgo), alloc: alloc}
+}
+
+// Estimate the bytes used by the removal table
+func (m *mementohash) MemoryUsage() int {
+	return int(unsafe.Sizeof(*m)) + hashing.MapBytes(len(m.removed), unsafe.Sizeof(int(0))+unsafe.Sizeof(replace{}))
 }
 
 func (m *mementohash) String() string {
@@ -5,7 +5,11 @@
 package consistenthash
 
 import (
+	"errors"
 	"hashing"
+	"math/rand"
+	"sort"
+	"strconv"
 	"testing"
 )
 
@@ -281,3 +285,253 @@ func TestAddBucket(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkGetBucket(b *testing.B) {
+	m := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	for i := 0; i < 1000; i++ {
+		m.AddBucket()
+	}
+	// Remove half of the buckets so lookups follow replacement chains
+	for i := 0; i < 1000; i += 2 {
+		m.RemoveBucket(i)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.GetBucket("object-key-1234")
+	}
+}
+
+func TestMappedAllocator(t *testing.T) {
+	plain := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	mapped := NewMementoHasherWithAllocator(hashing.DefaultHashAlgorithm,
+		NewMappedAllocator(func(slot int) int { return 1000 + 7*slot }))
+
+	// Apply the same membership changes to both hashers
+	ids := make(map[int]int)
+	add := func() {
+		slot := plain.AddBucket()
+		ids[slot] = mapped.AddBucket()
+	}
+	remove := func(slot int) {
+		plain.RemoveBucket(slot)
+		if got := mapped.RemoveBucket(ids[slot]); got != ids[slot] {
+			t.Fatalf("RemoveBucket(%d) = %d", ids[slot], got)
+		}
+	}
+
+	for i := 0; i < 8; i++ {
+		add()
+	}
+	remove(3)
+	remove(6)
+	add()
+	remove(0)
+
+	if got := mapped.RemoveBucket(42); got != -1 {
+		t.Fatalf("RemoveBucket() of unknown id = %d, want -1", got)
+	}
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if got, want := mapped.GetBucket(key), ids[plain.GetBucket(key)]; got != want {
+			t.Fatalf("GetBucket(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestRemoveBucketOutOfRange(t *testing.T) {
+	alloc := NewMappedAllocator(func(slot int) int { return 1000 + slot })
+	m := NewMementoHasherWithAllocator(hashing.DefaultHashAlgorithm, alloc)
+	m.AddBucket()
+	m.AddBucket()
+
+	// An id of the allocator whose slot the hasher does not have stays
+	// allocated
+	id := alloc.Allocate(5)
+	if got := m.RemoveBucket(id); got != -1 {
+		t.Fatalf("RemoveBucket(%d) = %d, want -1", id, got)
+	}
+	if slot, ok := alloc.Slot(id); !ok || slot != 5 {
+		t.Fatalf("Slot(%d) = %d, %v, want 5, true", id, slot, ok)
+	}
+	if m.Size() != 2 {
+		t.Fatalf("Size() = %d, want 2", m.Size())
+	}
+
+	plain := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	plain.AddBucket()
+	for _, id := range []int{-1, 1, 5} {
+		if got := plain.RemoveBucket(id); got != -1 {
+			t.Fatalf("RemoveBucket(%d) = %d, want -1", id, got)
+		}
+	}
+	if plain.Size() != 1 {
+		t.Fatalf("Size() = %d, want 1", plain.Size())
+	}
+}
+
+func TestRemovedLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		alloc BucketAllocator
+	}{
+		{name: "sequential", alloc: sequentialAllocator{}},
+		{name: "mapped", alloc: NewMappedAllocator(func(slot int) int { return 1000 + slot })},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewMementoHasherWithAllocator(hashing.DefaultHashAlgorithm, tt.alloc)
+			if err := SetRemovedLimit(h, 8); err != nil {
+				t.Fatalf("SetRemovedLimit() error = %v", err)
+			}
+			m := h.(*mementohash)
+
+			live := make(map[int]bool)
+			for i := 0; i < 50; i++ {
+				live[h.AddBucket()] = true
+			}
+			keys := make([]string, 1000)
+			for i := range keys {
+				keys[i] = "key" + strconv.Itoa(i)
+			}
+
+			r := rand.New(rand.NewSource(1))
+			rebuilds := 0
+			for i := 0; i < 500; i++ {
+				// Remove a bucket and only add one back now and then, so
+				// removals pile up in the table
+				var ids []int
+				for id := range live {
+					ids = append(ids, id)
+				}
+				sort.Ints(ids)
+				id := ids[r.Intn(len(ids))]
+				before := make(map[string]int, len(keys))
+				for _, key := range keys {
+					before[key] = h.GetBucket(key)
+				}
+				removed := len(m.removed)
+				if got := h.RemoveBucket(id); got != id {
+					t.Fatalf("RemoveBucket(%d) = %d", id, got)
+				}
+				delete(live, id)
+
+				if len(m.removed) < removed {
+					// A rebuild moves fewer keys than a full reshuffle
+					rebuilds++
+					moved := 0
+					for _, key := range keys {
+						if before[key] != id && h.GetBucket(key) != before[key] {
+							moved++
+						}
+					}
+					if moved > len(keys)/2 {
+						t.Fatalf("rebuild moved %d of %d keys", moved, len(keys))
+					}
+				}
+				for len(live) < 20 || r.Intn(3) == 0 {
+					added := h.AddBucket()
+					if live[added] {
+						t.Fatalf("AddBucket() = %d, already in use", added)
+					}
+					live[added] = true
+				}
+
+				if len(m.removed) > 8 {
+					t.Fatalf("removed table has %d entries, limit 8", len(m.removed))
+				}
+				if h.Size() != len(live) {
+					t.Fatalf("Size() = %d, want %d", h.Size(), len(live))
+				}
+				for _, key := range keys[:100] {
+					if b := h.GetBucket(key); !live[b] {
+						t.Fatalf("GetBucket(%q) = %d, not a live bucket", key, b)
+					}
+				}
+			}
+			if rebuilds == 0 {
+				t.Fatalf("expected the removed table to be rebuilt")
+			}
+			if got, ok := Rebuilds(h); !ok || got != rebuilds {
+				t.Fatalf("Rebuilds() = %d, %v, want %d, true", got, ok, rebuilds)
+			}
+		})
+	}
+}
+
+func TestRebuildsNotMemento(t *testing.T) {
+	if _, ok := Rebuilds(NewMaglevHasher(hashing.DefaultHashAlgorithm, 0)); ok {
+		t.Fatalf("expected Rebuilds() of a maglev hasher to fail")
+	}
+}
+
+func TestGetBucketBytes(t *testing.T) {
+	for _, h := range []ConsistentHasher{NewMementoHasher(hashing.DefaultHashAlgorithm), NewMaglevHasher(hashing.DefaultHashAlgorithm, 0)} {
+		for i := 0; i < 10; i++ {
+			h.AddBucket()
+		}
+		key := make([]byte, 0, 16)
+		for i := 0; i < 100; i++ {
+			key = strconv.AppendInt(key[:0], int64(i), 10)
+			if got, want := GetBucketBytes(h, key), h.GetBucket(string(key)); got != want {
+				t.Fatalf("expected bucket %d for key %q, got %d", want, key, got)
+			}
+		}
+		if allocs := testing.AllocsPerRun(100, func() { GetBucketBytes(h, key) }); allocs != 0 {
+			t.Fatalf("expected no allocations, got %v", allocs)
+		}
+	}
+}
+
+// Hashes every key to 0
+type zeroHasher struct{}
+
+func (zeroHasher) Hash([]byte) uint64                 { return 0 }
+func (zeroHasher) HashWithSeed([]byte, uint64) uint64 { return 0 }
+
+func TestWithHashFunction(t *testing.T) {
+	h := NewMementoHasher(hashing.DefaultHashAlgorithm, WithHashFunction(hashing.NewHashFunctionFromHasher(zeroHasher{})))
+	for i := 0; i < 10; i++ {
+		h.AddBucket()
+	}
+	for i := 0; i < 100; i++ {
+		if bucket := h.GetBucket(strconv.Itoa(i)); bucket != 0 {
+			t.Fatalf("expected every key in bucket 0, got %d", bucket)
+		}
+	}
+	if _, err := ExportState(h); err == nil {
+		t.Fatalf("expected exporting a custom hash function to fail")
+	}
+}
+
+func TestLookupBucket(t *testing.T) {
+	hashers := map[string]ConsistentHasher{
+		"memento":    NewMementoHasher(hashing.DefaultHashAlgorithm),
+		"maglev":     NewMaglevHasher(hashing.DefaultHashAlgorithm, 0),
+		"rendezvous": NewRendezvousHasher(hashing.DefaultHashAlgorithm),
+	}
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			if bucket, err := LookupBucket(h, "key"); !errors.Is(err, ErrEmptyRing) || bucket != -1 {
+				t.Fatalf("expected an empty ring, got bucket %d and %v", bucket, err)
+			}
+
+			// A single bucket takes every key
+			only := h.AddBucket()
+			for i := 0; i < 100; i++ {
+				if bucket, err := LookupBucket(h, strconv.Itoa(i)); err != nil || bucket != only {
+					t.Fatalf("expected bucket %d, got %d and %v", only, bucket, err)
+				}
+			}
+
+			h.RemoveBucket(only)
+			if bucket := h.GetBucket("key"); bucket != -1 {
+				t.Fatalf("expected -1 once the last bucket is removed, got %d", bucket)
+			}
+			if _, err := LookupBucket(h, "key"); !errors.Is(err, ErrEmptyRing) {
+				t.Fatalf("expected an empty ring, got %v", err)
+			}
+		})
+	}
+}
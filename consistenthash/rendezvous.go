@@ -0,0 +1,181 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Implementation of rendezvous (highest random weight) hashing.
+package consistenthash
+
+import (
+	"fmt"
+	"hashing"
+	"math"
+	"slices"
+	"unsafe"
+)
+
+// WeightedHasher is a consistent hasher whose buckets can receive shares
+// of the keys in proportion to a weight, instead of holding one bucket per
+// unit of weight
+type WeightedHasher interface {
+	ConsistentHasher
+
+	// Set the weight of a bucket in the working set, 1 when it is added
+	SetBucketWeight(bucket int, weight float64) error
+}
+
+// rendezvous maps a key to the bucket with the highest score for it. The
+// score of a bucket only depends on the key, the bucket and its weight, so
+// adding or removing a bucket only moves the keys it wins or held. Lookups
+// score every bucket, which suits small pools that change often, where
+// mementohash would build up a long replacement table.
+type rendezvous struct {
+	hashing.HashFn
+
+	// Buckets in the working set, sorted
+	buckets []int
+
+	// Weights other than 1, nil until one is set
+	weights map[int]float64
+
+	// Removed buckets, the most recently removed last, reused by AddBucket
+	// so a node added back gets the same bucket and keys
+	free []int
+
+	// Smallest bucket never used
+	next int
+}
+
+// Mix the bits of a hash so that hash functions with fewer than 64 bits
+// of output, like crc32, spread over the whole range
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// Score of a bucket for a key. Without weights the mixed hash is compared
+// directly. With weights, -w/ln(u) for u uniform in (0, 1) gives each
+// bucket a share of the keys proportional to its weight.
+func (r *rendezvous) score(key string, bucket int) float64 {
+	h := mix64(r.HashStringWithSeed(key, bucket))
+	if r.weights == nil {
+		return float64(h >> 11)
+	}
+	w, ok := r.weights[bucket]
+	if !ok {
+		w = 1
+	}
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -w / math.Log(u)
+}
+
+// Get the bucket with the highest score for the key, the smallest bucket
+// among equal scores
+func (r *rendezvous) GetBucket(key string) int {
+	best, bestScore := -1, math.Inf(-1)
+	for _, bucket := range r.buckets {
+		if s := r.score(key, bucket); s > bestScore {
+			best, bestScore = bucket, s
+		}
+	}
+	return best
+}
+
+// Get the n buckets with the highest scores for the key in order of score,
+// which are the buckets the key moves to as the better ones are removed
+func (r *rendezvous) GetBuckets(key string, n int) []int {
+	type scored struct {
+		bucket int
+		score  float64
+	}
+	all := make([]scored, len(r.buckets))
+	for i, bucket := range r.buckets {
+		all[i] = scored{bucket, r.score(key, bucket)}
+	}
+	slices.SortStableFunc(all, func(a, b scored) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		return 0
+	})
+	buckets := make([]int, 0, min(n, len(all)))
+	for _, s := range all[:min(max(n, 0), len(all))] {
+		buckets = append(buckets, s.bucket)
+	}
+	return buckets
+}
+
+// Add a bucket, the most recently removed one if any
+func (r *rendezvous) AddBucket() int {
+	bucket := r.next
+	if len(r.free) > 0 {
+		bucket = r.free[len(r.free)-1]
+		r.free = r.free[:len(r.free)-1]
+	} else {
+		r.next++
+	}
+	i, _ := slices.BinarySearch(r.buckets, bucket)
+	r.buckets = slices.Insert(r.buckets, i, bucket)
+	return bucket
+}
+
+// Remove a bucket, returning it or -1 if it is not in the working set
+func (r *rendezvous) RemoveBucket(bucket int) int {
+	i, ok := slices.BinarySearch(r.buckets, bucket)
+	if !ok {
+		return -1
+	}
+	r.buckets = slices.Delete(r.buckets, i, i+1)
+	delete(r.weights, bucket)
+	r.free = append(r.free, bucket)
+	return bucket
+}
+
+// Set the weight of a bucket in the working set
+func (r *rendezvous) SetBucketWeight(bucket int, weight float64) error {
+	if _, ok := slices.BinarySearch(r.buckets, bucket); !ok {
+		return fmt.Errorf("bucket %d not found", bucket)
+	}
+	if !(weight > 0) || math.IsInf(weight, 1) {
+		return fmt.Errorf("invalid weight %v", weight)
+	}
+	if weight == 1 {
+		delete(r.weights, bucket)
+		return nil
+	}
+	if r.weights == nil {
+		r.weights = make(map[int]float64)
+	}
+	r.weights[bucket] = weight
+	return nil
+}
+
+// Get size of the working set
+func (r *rendezvous) Size() int {
+	return len(r.buckets)
+}
+
+// Estimate the bytes used by the hasher
+func (r *rendezvous) MemoryUsage() int {
+	size := int(unsafe.Sizeof(*r)) + (cap(r.buckets)+cap(r.free))*int(unsafe.Sizeof(int(0)))
+	if r.weights != nil {
+		size += hashing.MapBytes(len(r.weights), unsafe.Sizeof(int(0))+unsafe.Sizeof(float64(0)))
+	}
+	return size
+}
+
+func (r *rendezvous) String() string {
+	return fmt.Sprintf("rendezvous{buckets: %v, weights: %v}", r.buckets, r.weights)
+}
+
+// NewRendezvousHasher creates a rendezvous hasher using the given hash
+// algorithm
+func NewRendezvousHasher(hashAlgo hashing.HashAlgorithm) WeightedHasher {
+	return &rendezvous{HashFn: hashing.NewHashFunction(hashAlgo)}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"hashing"
+	"strconv"
+	"testing"
+)
+
+func TestRendezvous(t *testing.T) {
+	h := NewConsistentHasherWithStrategy(StrategyRendezvous, hashing.DefaultHashAlgorithm)
+	if h.GetBucket("key") != -1 {
+		t.Fatal("expected no bucket without buckets")
+	}
+	for i := range 10 {
+		if b := h.AddBucket(); b != i {
+			t.Fatalf("expected bucket %d, got %d", i, b)
+		}
+	}
+
+	const keys = 20000
+	mapping := func() []int {
+		m := make([]int, keys)
+		for i := range m {
+			m[i] = h.GetBucket("key" + strconv.Itoa(i))
+		}
+		return m
+	}
+	before := mapping()
+	counts := make(map[int]int)
+	for _, b := range before {
+		counts[b]++
+	}
+	for b, c := range counts {
+		if c < keys/10*8/10 || c > keys/10*12/10 {
+			t.Fatalf("expected about %d keys in bucket %d, got %d", keys/10, b, c)
+		}
+	}
+
+	// Only the keys of a removed bucket move
+	if h.RemoveBucket(3) != 3 || h.Size() != 9 {
+		t.Fatalf("expected bucket 3 to be removed, size %d", h.Size())
+	}
+	for i, b := range mapping() {
+		if before[i] != 3 && b != before[i] {
+			t.Fatalf("expected key%d to stay in bucket %d, got %d", i, before[i], b)
+		}
+		if b == 3 {
+			t.Fatalf("expected key%d off the removed bucket", i)
+		}
+	}
+
+	// The removed bucket comes back with its keys
+	if b := h.AddBucket(); b != 3 {
+		t.Fatalf("expected bucket 3 back, got %d", b)
+	}
+	for i, b := range mapping() {
+		if b != before[i] {
+			t.Fatalf("expected key%d back in bucket %d, got %d", i, before[i], b)
+		}
+	}
+
+	// Replicas are the buckets the key falls back to
+	for i := range 100 {
+		key := "key" + strconv.Itoa(i)
+		buckets := h.GetBuckets(key, 3)
+		if len(buckets) != 3 || buckets[0] != h.GetBucket(key) {
+			t.Fatalf("expected 3 buckets starting with %d, got %v", h.GetBucket(key), buckets)
+		}
+		h.RemoveBucket(buckets[0])
+		if h.GetBucket(key) != buckets[1] {
+			t.Fatalf("expected %s to fall back to %d, got %d", key, buckets[1], h.GetBucket(key))
+		}
+		h.AddBucket()
+	}
+	if got := h.GetBuckets("key", 20); len(got) != 10 {
+		t.Fatalf("expected all 10 buckets, got %v", got)
+	}
+}
+
+func TestRendezvousWeights(t *testing.T) {
+	h := NewRendezvousHasher(hashing.DefaultHashAlgorithm)
+	for range 4 {
+		h.AddBucket()
+	}
+	if err := h.SetBucketWeight(0, 3); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if h.SetBucketWeight(9, 2) == nil || h.SetBucketWeight(1, 0) == nil {
+		t.Fatal("expected errors for a missing bucket and a zero weight")
+	}
+
+	// Bucket 0 has 3 of the 6 units of weight
+	const keys = 20000
+	share := 0
+	for i := range keys {
+		if h.GetBucket("key"+strconv.Itoa(i)) == 0 {
+			share++
+		}
+	}
+	if share < keys/2*9/10 || share > keys/2*11/10 {
+		t.Fatalf("expected about %d keys in bucket 0, got %d", keys/2, share)
+	}
+
+	// A bucket added back starts with weight 1
+	h.RemoveBucket(0)
+	h.AddBucket()
+	share = 0
+	for i := range keys {
+		if h.GetBucket("key"+strconv.Itoa(i)) == 0 {
+			share++
+		}
+	}
+	if share < keys/4*8/10 || share > keys/4*12/10 {
+		t.Fatalf("expected about %d keys in bucket 0, got %d", keys/4, share)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, s := range []Strategy{StrategyMemento, StrategyRendezvous, StrategyMaglev} {
+		if got, err := ParseStrategy(s.String()); err != nil || got != s {
+			t.Fatalf("expected %v, got %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParseStrategy("ring"); err == nil {
+		t.Fatal("expected an error for an unknown strategy")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Distinct buckets for the replicas of a key
+package consistenthash
+
+import (
+	"slices"
+	"strconv"
+)
+
+// ReplicaKey is the key hashed for the i-th probe of the replicas of key,
+// the key itself for the first so the first replica is GetBucket(key)
+func ReplicaKey(key string, i int) string {
+	if i == 0 {
+		return key
+	}
+	return key + "#" + strconv.Itoa(i)
+}
+
+// Probes made for n distinct replicas before giving up, enough to find
+// every bucket with high probability when n is the size of the working set
+func replicaProbes(n int) int {
+	return 32*n + 64
+}
+
+// AppendReplicas appends to dst the distinct values get returns for the
+// replica keys of key, in probe order, until dst holds n values or the
+// probes run out. Values already in dst are skipped. Callers with several
+// buckets per node pass a get returning nodes, so the replicas are
+// distinct nodes rather than buckets.
+func AppendReplicas[V comparable](dst []V, key string, n int, get func(key string) (V, bool)) []V {
+	for i := 0; len(dst) < n && i < replicaProbes(n); i++ {
+		if v, ok := get(ReplicaKey(key, i)); ok && !slices.Contains(dst, v) {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
+
+// Distinct buckets of the replica keys of key from a hasher's GetBucket
+func getBuckets(h ConsistentHasher, key string, n int) []int {
+	n = min(n, h.Size())
+	if n <= 0 {
+		return nil
+	}
+	return AppendReplicas(make([]int, 0, n), key, n, func(key string) (int, bool) {
+		bucket := h.GetBucket(key)
+		return bucket, bucket >= 0
+	})
+}
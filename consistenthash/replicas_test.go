@@ -0,0 +1,81 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"hashing"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestGetBuckets(t *testing.T) {
+	memento := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	for range 50 {
+		memento.AddBucket()
+	}
+	memento.RemoveBucket(7)
+	memento.RemoveBucket(30)
+
+	hashers := map[string]ConsistentHasher{
+		"memento":      memento,
+		"lookup table": NewLookupTableHasher(memento, hashing.DefaultHashAlgorithm, 0),
+		"hierarchical": NewHierarchicalHasher(memento, hashing.DefaultHashAlgorithm, "/", 3, LevelGroup, LevelSpread),
+	}
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			for i := range 100 {
+				key := "tenant" + strconv.Itoa(i%10) + "/key" + strconv.Itoa(i)
+				buckets := h.GetBuckets(key, 5)
+				if len(buckets) != 5 || buckets[0] != h.GetBucket(key) {
+					t.Fatalf("expected 5 buckets starting with %d, got %v", h.GetBucket(key), buckets)
+				}
+				sorted := slices.Sorted(slices.Values(buckets))
+				if len(slices.Compact(sorted)) != 5 {
+					t.Fatalf("expected distinct buckets, got %v", buckets)
+				}
+				if slices.Contains(buckets, 7) || slices.Contains(buckets, 30) {
+					t.Fatalf("expected no removed bucket, got %v", buckets)
+				}
+				if !slices.Equal(buckets, h.GetBuckets(key, 5)) {
+					t.Fatalf("expected the same buckets for %q", key)
+				}
+			}
+
+			// Asking for more buckets than there are returns them all
+			all := h.GetBuckets("key", 100)
+			if len(all) != 48 {
+				t.Fatalf("expected all 48 buckets, got %d", len(all))
+			}
+		})
+	}
+
+	empty := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	if buckets := empty.GetBuckets("key", 3); len(buckets) != 0 {
+		t.Fatalf("expected no buckets, got %v", buckets)
+	}
+}
+
+func TestTopologyLookupGetNodes(t *testing.T) {
+	h := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	nodes := make(map[int]string)
+	for i := range 6 {
+		// Buckets 0-3 belong to a node of weight 4
+		nodes[h.AddBucket()] = "node" + strconv.Itoa(max(i-3, 0))
+	}
+	topology, err := NewTopology(h, nodes)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	lookup, err := NewTopologyLookup(topology)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got := lookup.GetNodes("key", 5)
+	first, _ := lookup.GetNode("key")
+	if len(got) != 3 || got[0] != first {
+		t.Fatalf("expected the 3 nodes starting with %s, got %v", first, got)
+	}
+}
@@ -0,0 +1,356 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Export and import of mementohash state in a portable format.
+//
+// The state is everything needed to reproduce the key to bucket mapping:
+// the hash algorithm, the number of buckets b, the last removed bucket and
+// the replacement table of removed buckets, plus the bucket id of every
+// slot when ids are not the slots themselves. A consumer in any language
+// maps a key as follows:
+//
+//  1. h = hash(key) where hash is the 64 bit hash of the algorithm: the IEEE
+//     CRC-32 for "crc32", the first 8 bytes of the digest read big endian
+//     for "md5" and "sha256", XXH64 with seed 0 for "xxhash64" and 64 bit
+//     FNV-1a for "fnv1a". A seeded state mixes its seed into the hashes of
+//     steps 1 and 3 as hashing.NewHashFunctionWithSeed describes.
+//  2. bucket = JumpHash(h, b) as in Lamping and Veach, with the 64 bit
+//     linear congruential generator key*2862933555777941757 + 1.
+//  3. While bucket is removed with replacement r: bucket =
+//     hash(key || uint64be(bucket)) mod r, then while the new bucket is
+//     removed with a replacement >= r, follow its replacement chain.
+//     Repeat with the replacement of the bucket reached.
+//  4. The result is ids[bucket], or bucket if there are no ids.
+//
+// The JSON form is MementoState. The binary form is big endian:
+//
+//	offset  size  field
+//	0       4     magic "MMNT"
+//	4       1     format version, StateVersion
+//	5       1     hash algorithm: 0 crc32, 1 md5, 2 sha256, 3 xxhash64,
+//	              4 fnv1a
+//	6       2     flags, bit 0 set if bucket ids follow the removed table
+//	8       4     buckets
+//	12      4     last removed bucket
+//	16      4     number of removed buckets n
+//	20      12*n  removed buckets sorted by bucket: bucket, replacement,
+//	              previously removed bucket, 4 bytes each
+//	20+12n  8*b   if flag 0 is set, the signed id of each slot, -1 if unused
+package consistenthash
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hashing"
+	"slices"
+)
+
+// StateVersion is the version of the exported state format
+const StateVersion = 1
+
+var stateMagic = [4]byte{'M', 'M', 'N', 'T'}
+
+const stateHeaderSize = 20
+
+// MementoState is the portable state of a mementohash
+type MementoState struct {
+	// Format version, StateVersion
+	Version int `json:"version"`
+
+	// Name of the hash algorithm, e.g. "crc32"
+	Algorithm string `json:"algorithm"`
+
+	// Seed of the hash function, 0 if unseeded, see
+	// hashing.NewHashFunctionWithSeed. The binary form has no seed.
+	Seed uint64 `json:"seed,omitempty"`
+
+	// Number of buckets including removed ones
+	Buckets int `json:"buckets"`
+
+	// Last removed bucket, the next bucket added takes its place
+	LastRemoved int `json:"lastRemoved"`
+
+	// Removed buckets sorted by bucket
+	Removed []RemovedBucket `json:"removed"`
+
+	// Id of each slot if the hasher uses a bucket allocator, -1 if unused
+	IDs []int `json:"ids,omitempty"`
+}
+
+// RemovedBucket is an entry of the replacement table
+type RemovedBucket struct {
+	Bucket      int `json:"bucket"`
+	Replacement int `json:"replacement"`
+	PrevRemoved int `json:"prevRemoved"`
+}
+
+// Find the mementohash under any wrapping hashers
+func unwrapMemento(h ConsistentHasher) (*mementohash, bool) {
+	for {
+		switch w := h.(type) {
+		case *mementohash:
+			return w, true
+		case *lookupTable:
+			h = w.ConsistentHasher
+		case *hierarchical:
+			h = w.ConsistentHasher
+		default:
+			return nil, false
+		}
+	}
+}
+
+// ExportState returns the state of a mementohash, which may be wrapped by
+// a lookup table or hierarchical hasher
+func ExportState(h ConsistentHasher) (MementoState, error) {
+	m, ok := unwrapMemento(h)
+	if !ok {
+		return MementoState{}, fmt.Errorf("cannot export state of %T", h)
+	}
+	if m.Algorithm() == hashing.Custom {
+		return MementoState{}, errors.New("cannot export state hashed by a custom hash function")
+	}
+
+	s := MementoState{Version: StateVersion, Algorithm: m.HashFn.String(), Seed: m.Seed(),
+		Buckets: m.buckets, LastRemoved: m.lastRemoved, Removed: []RemovedBucket{}}
+	for _, r := range m.removed {
+		s.Removed = append(s.Removed, RemovedBucket{r.bucket, r.replacement, r.prevRemoved})
+	}
+	slices.SortFunc(s.Removed, func(a, b RemovedBucket) int { return a.Bucket - b.Bucket })
+
+	if _, sequential := m.allocator().(sequentialAllocator); !sequential {
+		s.IDs = make([]int, m.buckets)
+		for slot := range s.IDs {
+			s.IDs[slot] = -1
+			if _, removed := m.removed[slot]; !removed {
+				s.IDs[slot] = m.allocator().ID(slot)
+			}
+		}
+	}
+	return s, nil
+}
+
+// ImportState creates a mementohash from exported state, rejecting a
+// replacement table removals could not have left. A hasher imported
+// with bucket ids is meant for lookups, buckets added to it get id -1 since
+// their ids are not known.
+func ImportState(s MementoState) (ConsistentHasher, error) {
+	if s.Version != StateVersion {
+		return nil, fmt.Errorf("unsupported state version %d", s.Version)
+	}
+	algo, err := hashing.ParseHashAlgorithm(s.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if s.Buckets < 0 || len(s.Removed) > s.Buckets || (s.IDs != nil && len(s.IDs) != s.Buckets) {
+		return nil, errors.New("inconsistent state")
+	}
+
+	if err := s.checkRemoved(); err != nil {
+		return nil, err
+	}
+
+	m := &mementohash{HashFn: hashing.NewHashFunctionWithSeed(algo, s.Seed), buckets: s.Buckets,
+		lastRemoved: s.LastRemoved, removed: make(map[int]replace, len(s.Removed))}
+	for _, r := range s.Removed {
+		m.removed[r.Bucket] = replace{r.Bucket, r.Replacement, r.PrevRemoved}
+	}
+
+	if s.IDs != nil {
+		ids := slices.Clone(s.IDs)
+		alloc := &mappedAllocator{next: func(slot int) int {
+			if slot < len(ids) {
+				return ids[slot]
+			}
+			return -1
+		}, slots: make(map[int]int)}
+		for slot, id := range ids {
+			if _, removed := m.removed[slot]; !removed {
+				alloc.Allocate(slot)
+			} else if id >= 0 {
+				return nil, fmt.Errorf("removed slot %d has id %d", slot, id)
+			}
+		}
+		m.alloc = alloc
+	}
+	return m, nil
+}
+
+// Check the replacement table is one removals could have left, so that
+// lookups terminate. The k-th removal of a bucket records the size of the
+// working set after it, Buckets-k, as replacement and the bucket removed
+// before it as PrevRemoved, Buckets for the first. LastRemoved is the last
+// bucket removed, or Buckets if none is.
+func (s MementoState) checkRemoved() error {
+	removed := slices.Clone(s.Removed)
+	slices.SortFunc(removed, func(a, b RemovedBucket) int { return b.Replacement - a.Replacement })
+	seen := make(map[int]bool, len(removed))
+	prev := s.Buckets
+	for k, r := range removed {
+		if r.Bucket < 0 || r.Bucket >= s.Buckets {
+			return fmt.Errorf("removed bucket %d out of range", r.Bucket)
+		}
+		if seen[r.Bucket] {
+			return fmt.Errorf("bucket %d removed twice", r.Bucket)
+		}
+		if r.Replacement != s.Buckets-k-1 {
+			return fmt.Errorf("removed bucket %d has replacement %d, want %d", r.Bucket, r.Replacement, s.Buckets-k-1)
+		}
+		if r.PrevRemoved != prev {
+			return fmt.Errorf("removed bucket %d follows %d, want %d", r.Bucket, r.PrevRemoved, prev)
+		}
+		seen[r.Bucket] = true
+		prev = r.Bucket
+	}
+	if s.LastRemoved != prev {
+		return fmt.Errorf("last removed bucket is %d, want %d", s.LastRemoved, prev)
+	}
+	return nil
+}
+
+// StatefulHasher is a consistent hasher whose bucket history can be
+// exported and imported, so several stateless frontends seeded with the
+// same state agree on the bucket of every key and keep agreeing as they
+// apply the same changes. Mementohash and the lookup table and
+// hierarchical hashers wrapping it are stateful.
+type StatefulHasher interface {
+	ConsistentHasher
+
+	// Export the bucket history
+	ExportState() (MementoState, error)
+
+	// Replace the bucket history with exported state
+	ImportState(s MementoState) error
+}
+
+// RestoreState replaces the state of a stateful hasher with exported
+// state, so buckets keep their keys across a restart and later changes
+// move keys as they would have before it. State with bucket ids cannot be
+// restored, ids of added buckets are not known.
+func RestoreState(h ConsistentHasher, s MementoState) error {
+	sh, ok := h.(StatefulHasher)
+	if !ok {
+		return fmt.Errorf("cannot restore state into %T", h)
+	}
+	return sh.ImportState(s)
+}
+
+// Export the state of the hasher
+func (m *mementohash) ExportState() (MementoState, error) {
+	return ExportState(m)
+}
+
+// Replace the state of the hasher, keeping its rebuild limit
+func (m *mementohash) ImportState(s MementoState) error {
+	if s.IDs != nil {
+		return errors.New("cannot restore state with bucket ids")
+	}
+	if m.Algorithm() == hashing.Custom {
+		return errors.New("cannot restore state into a hasher with a custom hash function")
+	}
+	imported, err := ImportState(s)
+	if err != nil {
+		return err
+	}
+	limit := m.limit
+	*m = *imported.(*mementohash)
+	m.limit = limit
+	return nil
+}
+
+// Export the state of the wrapped hasher
+func (l *lookupTable) ExportState() (MementoState, error) {
+	return ExportState(l)
+}
+
+// Replace the state of the wrapped hasher and invalidate the table
+func (l *lookupTable) ImportState(s MementoState) error {
+	l.table.Store(nil)
+	return RestoreState(l.ConsistentHasher, s)
+}
+
+// Export the state of the wrapped hasher
+func (h *hierarchical) ExportState() (MementoState, error) {
+	return ExportState(h)
+}
+
+// Replace the state of the wrapped hasher and forget the groups
+func (h *hierarchical) ImportState(s MementoState) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.groups = nil
+	return RestoreState(h.ConsistentHasher, s)
+}
+
+// MarshalBinary encodes the state in the binary format
+func (s MementoState) MarshalBinary() ([]byte, error) {
+	algo, err := hashing.ParseHashAlgorithm(s.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if s.Seed != 0 {
+		return nil, errors.New("cannot encode the state of a seeded hasher in the binary format")
+	}
+
+	var flags uint16
+	if s.IDs != nil {
+		flags |= 1
+	}
+	b := make([]byte, 0, stateHeaderSize+12*len(s.Removed)+8*len(s.IDs))
+	b = append(b, stateMagic[:]...)
+	b = append(b, byte(s.Version), byte(algo))
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = binary.BigEndian.AppendUint32(b, uint32(s.Buckets))
+	b = binary.BigEndian.AppendUint32(b, uint32(s.LastRemoved))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s.Removed)))
+	for _, r := range s.Removed {
+		b = binary.BigEndian.AppendUint32(b, uint32(r.Bucket))
+		b = binary.BigEndian.AppendUint32(b, uint32(r.Replacement))
+		b = binary.BigEndian.AppendUint32(b, uint32(r.PrevRemoved))
+	}
+	for _, id := range s.IDs {
+		b = binary.BigEndian.AppendUint64(b, uint64(int64(id)))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes state in the binary format
+func (s *MementoState) UnmarshalBinary(b []byte) error {
+	if len(b) < stateHeaderSize || [4]byte(b[:4]) != stateMagic {
+		return errors.New("not a memento state")
+	}
+	if b[4] != StateVersion {
+		return fmt.Errorf("unsupported state version %d", b[4])
+	}
+
+	flags := binary.BigEndian.Uint16(b[6:])
+	buckets := int(binary.BigEndian.Uint32(b[8:]))
+	n := int(binary.BigEndian.Uint32(b[16:]))
+	size := stateHeaderSize + 12*n
+	if flags&1 != 0 {
+		size += 8 * buckets
+	}
+	if n > buckets || len(b) != size {
+		return errors.New("truncated memento state")
+	}
+
+	*s = MementoState{Version: int(b[4]), Algorithm: hashing.HashAlgorithm(b[5]).String(),
+		Buckets: buckets, LastRemoved: int(binary.BigEndian.Uint32(b[12:])),
+		Removed: make([]RemovedBucket, n)}
+	p := b[stateHeaderSize:]
+	for i := range s.Removed {
+		s.Removed[i] = RemovedBucket{int(binary.BigEndian.Uint32(p)),
+			int(binary.BigEndian.Uint32(p[4:])), int(binary.BigEndian.Uint32(p[8:]))}
+		p = p[12:]
+	}
+	if flags&1 != 0 {
+		s.IDs = make([]int, buckets)
+		for i := range s.IDs {
+			s.IDs[i] = int(int64(binary.BigEndian.Uint64(p)))
+			p = p[8:]
+		}
+	}
+	return nil
+}
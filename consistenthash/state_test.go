@@ -0,0 +1,283 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"encoding/json"
+	"hashing"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher ConsistentHasher
+	}{
+		{name: "sequential", hasher: NewMementoHasher(hashing.MD5)},
+		{name: "mapped", hasher: NewMementoHasherWithAllocator(hashing.CRC32,
+			NewMappedAllocator(func(slot int) int { return 100 + slot }))},
+		{name: "lookup table", hasher: NewLookupTableHasher(NewMementoHasher(hashing.SHA256), hashing.SHA256, 101)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.hasher
+			var ids []int
+			for i := 0; i < 10; i++ {
+				ids = append(ids, h.AddBucket())
+			}
+			h.RemoveBucket(ids[2])
+			h.RemoveBucket(ids[7])
+			h.RemoveBucket(ids[4])
+
+			// Wrapping hashers are not part of the state
+			m, _ := unwrapMemento(h)
+
+			state, err := ExportState(h)
+			if err != nil {
+				t.Fatalf("ExportState() error = %v", err)
+			}
+
+			data, err := state.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary() error = %v", err)
+			}
+			var fromBinary MementoState
+			if err := fromBinary.UnmarshalBinary(data); err != nil {
+				t.Fatalf("UnmarshalBinary() error = %v", err)
+			}
+
+			text, err := json.Marshal(state)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			var fromJSON MementoState
+			if err := json.Unmarshal(text, &fromJSON); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+
+			for _, s := range []MementoState{fromBinary, fromJSON} {
+				imported, err := ImportState(s)
+				if err != nil {
+					t.Fatalf("ImportState() error = %v", err)
+				}
+				if imported.Size() != m.Size() {
+					t.Fatalf("Size() = %d, want %d", imported.Size(), m.Size())
+				}
+				for i := 0; i < 1000; i++ {
+					key := "key" + strconv.Itoa(i)
+					if got, want := imported.GetBucket(key), m.GetBucket(key); got != want {
+						t.Fatalf("GetBucket(%q) = %d, want %d", key, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	h := NewMementoHasher(hashing.CRC32)
+	for i := 0; i < 10; i++ {
+		h.AddBucket()
+	}
+	h.RemoveBucket(3)
+	h.RemoveBucket(6)
+	state, err := ExportState(h)
+	if err != nil {
+		t.Fatalf("ExportState() error = %v", err)
+	}
+
+	restored := NewMementoHasher(hashing.CRC32)
+	if err := RestoreState(restored, state); err != nil {
+		t.Fatalf("RestoreState() error = %v", err)
+	}
+
+	// Later changes reuse the removed buckets as the original does
+	if got, want := restored.AddBucket(), h.AddBucket(); got != want {
+		t.Fatalf("AddBucket() = %d, want %d", got, want)
+	}
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if got, want := restored.GetBucket(key), h.GetBucket(key); got != want {
+			t.Fatalf("GetBucket(%q) = %d, want %d", key, got, want)
+		}
+	}
+
+	// Frontends behind a lookup table agree with the hasher they were
+	// seeded from
+	frontend := NewLookupTableHasher(NewMementoHasher(hashing.CRC32), hashing.CRC32, 101).(StatefulHasher)
+	frontend.GetBucket("key")
+	if err := frontend.ImportState(state); err != nil {
+		t.Fatalf("ImportState() error = %v", err)
+	}
+	exported, err := frontend.ExportState()
+	if err != nil || exported.Buckets != state.Buckets || len(exported.Removed) != len(state.Removed) {
+		t.Fatalf("ExportState() = %+v, %v, want %+v", exported, err, state)
+	}
+	table := NewLookupTableHasher(NewMementoHasher(hashing.CRC32), hashing.CRC32, 101)
+	RestoreState(table, state)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if got, want := frontend.GetBucket(key), table.GetBucket(key); got != want {
+			t.Fatalf("GetBucket(%q) = %d, want %d", key, got, want)
+		}
+	}
+
+	if err := RestoreState(NewRendezvousHasher(hashing.CRC32), state); err == nil {
+		t.Fatalf("RestoreState() into rendezvous hashing succeeded")
+	}
+	state.IDs = make([]int, state.Buckets)
+	if err := RestoreState(NewMementoHasher(hashing.CRC32), state); err == nil {
+		t.Fatalf("RestoreState() with bucket ids succeeded")
+	}
+}
+
+func TestStateInvalid(t *testing.T) {
+	var s MementoState
+	if err := s.UnmarshalBinary([]byte("not a state")); err == nil {
+		t.Errorf("UnmarshalBinary() expected error")
+	}
+	if _, err := ImportState(MementoState{Version: 2, Algorithm: "crc32"}); err == nil {
+		t.Errorf("ImportState() expected error for unknown version")
+	}
+	if _, err := ImportState(MementoState{Version: StateVersion, Algorithm: "crc32", Buckets: 2,
+		Removed: []RemovedBucket{{Bucket: 5}}}); err == nil {
+		t.Errorf("ImportState() expected error for out of range bucket")
+	}
+
+	// Tables removals cannot leave, some of which lookups never get out of
+	tables := map[string]MementoState{
+		"cycle": {Buckets: 4, LastRemoved: 2,
+			Removed: []RemovedBucket{{0, 1, -1}, {1, 2, 0}, {2, 1, 1}}},
+		"replacement": {Buckets: 4, LastRemoved: 1,
+			Removed: []RemovedBucket{{0, 3, 4}, {1, 1, 0}}},
+		"chain": {Buckets: 4, LastRemoved: 1,
+			Removed: []RemovedBucket{{0, 3, 4}, {1, 2, 3}}},
+		"twice": {Buckets: 4, LastRemoved: 0,
+			Removed: []RemovedBucket{{0, 3, 4}, {0, 2, 0}}},
+		"last removed": {Buckets: 4, LastRemoved: 0,
+			Removed: []RemovedBucket{{0, 3, 4}, {1, 2, 0}}},
+		"none removed": {Buckets: 4, LastRemoved: 2},
+	}
+	for name, s := range tables {
+		s.Version, s.Algorithm = StateVersion, "crc32"
+		if _, err := ImportState(s); err == nil {
+			t.Errorf("ImportState() expected error for %s", name)
+		}
+	}
+}
+
+func TestStateRemovals(t *testing.T) {
+	h := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 500; i++ {
+		if h.Size() < 2 || rng.IntN(3) == 0 {
+			h.AddBucket()
+		} else {
+			exported, _ := ExportState(h)
+			buckets := slices.Sorted(maps.Keys(bucketSet(exported)))
+			h.RemoveBucket(buckets[rng.IntN(len(buckets))])
+		}
+		s, err := ExportState(h)
+		if err != nil {
+			t.Fatalf("ExportState() error = %v", err)
+		}
+		if _, err := ImportState(s); err != nil {
+			t.Fatalf("ImportState() error = %v after %d changes", err, i+1)
+		}
+	}
+}
+
+// Buckets in the working set of an exported hasher
+func bucketSet(s MementoState) map[int]bool {
+	set := make(map[int]bool)
+	for b := 0; b < s.Buckets; b++ {
+		set[b] = true
+	}
+	for _, r := range s.Removed {
+		delete(set, r.Bucket)
+	}
+	return set
+}
+
+func TestTopology(t *testing.T) {
+	h := NewMementoHasher(hashing.DefaultHashAlgorithm)
+	nodes := make(map[int]string)
+	for i := 0; i < 5; i++ {
+		b := h.AddBucket()
+		nodes[b] = "node" + strconv.Itoa(b)
+	}
+	h.RemoveBucket(1)
+	delete(nodes, 1)
+
+	topology, err := NewTopology(h, nodes)
+	if err != nil {
+		t.Fatalf("NewTopology() error = %v", err)
+	}
+	text, err := json.Marshal(topology)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	l, err := ParseTopologyLookup(text)
+	if err != nil {
+		t.Fatalf("ParseTopologyLookup() error = %v", err)
+	}
+	if _, err := ParseTopologyLookup(text[:len(text)/2]); err == nil {
+		t.Errorf("ParseTopologyLookup() expected error for truncated JSON")
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if got, ok := l.GetNode(key); !ok || got != nodes[h.GetBucket(key)] {
+			t.Fatalf("GetNode(%q) = %q, want %q", key, got, nodes[h.GetBucket(key)])
+		}
+	}
+
+	if _, err := NewTopology(NewLookupTableHasher(h, hashing.DefaultHashAlgorithm, 0), nodes); err == nil {
+		t.Errorf("NewTopology() expected error for a wrapped hasher")
+	}
+}
+
+func TestStateSeed(t *testing.T) {
+	h := NewMementoHasher(hashing.XXHash64, WithHashFunction(hashing.NewHashFunctionWithSeed(hashing.XXHash64, 7)))
+	for i := 0; i < 10; i++ {
+		h.AddBucket()
+	}
+	h.RemoveBucket(3)
+
+	state, err := ExportState(h)
+	if err != nil {
+		t.Fatalf("ExportState() error = %v", err)
+	}
+	if state.Seed != 7 {
+		t.Fatalf("Seed = %d, want 7", state.Seed)
+	}
+	if _, err := state.MarshalBinary(); err == nil {
+		t.Fatalf("expected MarshalBinary() to refuse a seeded state")
+	}
+
+	text, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var fromJSON MementoState
+	if err := json.Unmarshal(text, &fromJSON); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	imported, err := ImportState(fromJSON)
+	if err != nil {
+		t.Fatalf("ImportState() error = %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if got, want := imported.GetBucket(key), h.GetBucket(key); got != want {
+			t.Fatalf("GetBucket(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
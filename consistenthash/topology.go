@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Snapshot of a key to node mapping for use outside the load balancer.
+//
+// The binary form of a topology is big endian:
+//
+//	offset  size  field
+//	0       4     magic "TOPO"
+//	4       4     length s of the hasher state
+//	8       s     hasher state in the binary form of MementoState
+//	8+s     4     number of nodes n
+//	12+s          n nodes sorted by bucket: bucket id, 4 bytes, length of
+//	              the node name, 2 bytes, and the name
+package consistenthash
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+var topologyMagic = [4]byte{'T', 'O', 'P', 'O'}
+
+// Topology is a portable snapshot of the hasher state and the name of the
+// node in each bucket, enough to map keys to nodes without the load balancer
+type Topology struct {
+	Hasher MementoState `json:"hasher"`
+
+	// Node name of each bucket id. JSON object keys are decimal bucket ids.
+	Nodes map[int]string `json:"nodes"`
+}
+
+// NewTopology snapshots a plain mementohash and the node names of its
+// buckets. Wrapping hashers change the mapping in ways the state does not
+// capture, so they cannot be snapshot.
+func NewTopology(h ConsistentHasher, nodes map[int]string) (Topology, error) {
+	if _, ok := h.(*mementohash); !ok {
+		return Topology{}, fmt.Errorf("mapping of %T cannot be exported", h)
+	}
+	state, err := ExportState(h)
+	if err != nil {
+		return Topology{}, err
+	}
+	return Topology{Hasher: state, Nodes: nodes}, nil
+}
+
+// MarshalBinary encodes the topology in the binary form
+func (t Topology) MarshalBinary() ([]byte, error) {
+	state, err := t.Hasher.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 12+len(state)+16*len(t.Nodes))
+	b = append(b, topologyMagic[:]...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(state)))
+	b = append(b, state...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(t.Nodes)))
+	buckets := make([]int, 0, len(t.Nodes))
+	for bucket := range t.Nodes {
+		buckets = append(buckets, bucket)
+	}
+	slices.Sort(buckets)
+	for _, bucket := range buckets {
+		name := t.Nodes[bucket]
+		if len(name) > math.MaxUint16 {
+			return nil, fmt.Errorf("name of node in bucket %d is too long", bucket)
+		}
+		b = binary.BigEndian.AppendUint32(b, uint32(bucket))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(name)))
+		b = append(b, name...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a topology in the binary form
+func (t *Topology) UnmarshalBinary(b []byte) error {
+	if len(b) < 8 || [4]byte(b[:4]) != topologyMagic {
+		return errors.New("not a topology")
+	}
+	size := int(binary.BigEndian.Uint32(b[4:]))
+	if len(b) < 12+size {
+		return errors.New("truncated topology")
+	}
+	var state MementoState
+	if err := state.UnmarshalBinary(b[8 : 8+size]); err != nil {
+		return err
+	}
+	p := b[8+size:]
+	n := int(binary.BigEndian.Uint32(p))
+	p = p[4:]
+	nodes := make(map[int]string, min(n, len(p)/6))
+	for range n {
+		if len(p) < 6 {
+			return errors.New("truncated topology")
+		}
+		bucket, length := int(binary.BigEndian.Uint32(p)), int(binary.BigEndian.Uint16(p[4:]))
+		if len(p) < 6+length {
+			return errors.New("truncated topology")
+		}
+		nodes[bucket] = string(p[6 : 6+length])
+		p = p[6+length:]
+	}
+	if len(p) != 0 {
+		return errors.New("trailing data after topology")
+	}
+	*t = Topology{Hasher: state, Nodes: nodes}
+	return nil
+}
+
+// TopologyLookup maps keys to node names from a topology snapshot
+type TopologyLookup struct {
+	hasher ConsistentHasher
+	nodes  map[int]string
+
+	// Number of distinct node names, less than the buckets with weights
+	names int
+}
+
+// NewTopologyLookup loads a topology snapshot for lookups
+func NewTopologyLookup(t Topology) (*TopologyLookup, error) {
+	h, err := ImportState(t.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, name := range t.Nodes {
+		names[name] = true
+	}
+	return &TopologyLookup{hasher: h, nodes: t.Nodes, names: len(names)}, nil
+}
+
+// ParseTopologyLookup loads a topology snapshot in JSON, as the load
+// balancer exports it, for lookups
+func ParseTopologyLookup(data []byte) (*TopologyLookup, error) {
+	var t Topology
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return NewTopologyLookup(t)
+}
+
+// GetBucket returns the bucket of the key, -1 if there are no buckets
+func (l *TopologyLookup) GetBucket(key string) int {
+	return l.hasher.GetBucket(key)
+}
+
+// GetNode returns the name of the node of the key, false if there is none
+func (l *TopologyLookup) GetNode(key string) (string, bool) {
+	node, ok := l.nodes[l.hasher.GetBucket(key)]
+	return node, ok
+}
+
+// GetNodes returns the names of up to n distinct nodes for the key, the
+// one GetNode returns first, as the load balancer's GetNodes orders them
+// unless probing runs out of replica keys
+func (l *TopologyLookup) GetNodes(key string, n int) []string {
+	return AppendReplicas(nil, key, min(n, l.names), func(key string) (string, bool) {
+		node, ok := l.nodes[l.hasher.GetBucket(key)]
+		return node, ok
+	})
+}
+
+func (l *TopologyLookup) String() string {
+	return fmt.Sprintf("TopologyLookup{nodes: %d, hasher: %v}", len(l.nodes), l.hasher)
+}
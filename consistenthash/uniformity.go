@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Chi-squared test of how evenly a hasher spreads keys over its buckets.
+package consistenthash
+
+import (
+	"errors"
+	"math"
+)
+
+// UniformityResult is the outcome of a chi-squared test of the key to
+// bucket distribution against an even spread
+type UniformityResult struct {
+	// Keys mapped by each bucket that got any
+	Counts map[int]int
+
+	// Keys expected in each bucket
+	Expected float64
+
+	// Chi-squared statistic of the counts
+	ChiSquared float64
+
+	// Degrees of freedom, one less than the number of buckets
+	DegreesOfFreedom int
+
+	// Probability of a statistic at least this large if keys were spread
+	// evenly. Small values, say below 0.01, suggest a skewed hash.
+	PValue float64
+}
+
+// Uniformity maps n keys generated by key with h and tests whether they are
+// spread evenly over its buckets. Use it to check a hash algorithm or key
+// scheme: with enough keys, at least 5 per bucket, a good hash gives p-values
+// spread evenly over [0, 1].
+func Uniformity(h ConsistentHasher, key func(i int) string, n int) (UniformityResult, error) {
+	buckets := h.Size()
+	if buckets < 2 {
+		return UniformityResult{}, errors.New("uniformity needs at least 2 buckets")
+	}
+	if n <= 0 {
+		return UniformityResult{}, errors.New("uniformity needs at least 1 key")
+	}
+
+	result := UniformityResult{Counts: make(map[int]int), Expected: float64(n) / float64(buckets),
+		DegreesOfFreedom: buckets - 1}
+	for i := 0; i < n; i++ {
+		result.Counts[h.GetBucket(key(i))]++
+	}
+
+	// Buckets without keys are not in the counts but still add to the statistic
+	for _, count := range result.Counts {
+		d := float64(count) - result.Expected
+		result.ChiSquared += d * d / result.Expected
+	}
+	result.ChiSquared += float64(buckets-len(result.Counts)) * result.Expected
+
+	result.PValue = chiSquaredSurvival(result.ChiSquared, result.DegreesOfFreedom)
+	return result, nil
+}
+
+// Probability that a chi-squared variable with k degrees of freedom exceeds
+// x, the regularized upper incomplete gamma function Q(k/2, x/2)
+func chiSquaredSurvival(x float64, k int) float64 {
+	if x <= 0 {
+		return 1
+	}
+	a, x := float64(k)/2, x/2
+	lgamma, _ := math.Lgamma(a)
+	prefix := math.Exp(a*math.Log(x) - x - lgamma)
+
+	// The series of the lower function converges quickly below a+1, the
+	// continued fraction of the upper one above it
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if term < sum*1e-15 {
+				break
+			}
+		}
+		return max(0, 1-prefix*sum)
+	}
+
+	// Modified Lentz's method
+	const tiny = 1e-300
+	b := x + 1 - a
+	c, d := 1/tiny, 1/b
+	f := d
+	for n := 1; n < 1000; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		f *= delta
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+	return prefix * f
+}
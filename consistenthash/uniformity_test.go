@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package consistenthash
+
+import (
+	"hashing"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestChiSquaredSurvival(t *testing.T) {
+	// Values from chi-squared tables
+	tests := []struct {
+		x        float64
+		k        int
+		expected float64
+	}{
+		{x: 0, k: 3, expected: 1},
+		{x: 3.841, k: 1, expected: 0.05},
+		{x: 6.635, k: 1, expected: 0.01},
+		{x: 18.307, k: 10, expected: 0.05},
+		{x: 9.342, k: 10, expected: 0.5},
+		{x: 124.342, k: 100, expected: 0.05},
+		{x: 2, k: 2, expected: math.Exp(-1)},
+	}
+	for _, tt := range tests {
+		if got := chiSquaredSurvival(tt.x, tt.k); math.Abs(got-tt.expected) > 1e-3 {
+			t.Fatalf("chiSquaredSurvival(%v, %d) = %v, want %v", tt.x, tt.k, got, tt.expected)
+		}
+	}
+}
+
+func TestUniformity(t *testing.T) {
+	m := NewMementoHasher(hashing.SHA256)
+	for i := 0; i < 20; i++ {
+		m.AddBucket()
+	}
+	m.RemoveBucket(7)
+
+	key := func(i int) string { return "key" + strconv.Itoa(i) }
+	result, err := Uniformity(m, key, 20000)
+	if err != nil {
+		t.Fatalf("Uniformity() error = %v", err)
+	}
+	if result.DegreesOfFreedom != 18 || result.Counts[7] != 0 {
+		t.Fatalf("Uniformity() = %+v", result)
+	}
+	if result.PValue < 0.001 {
+		t.Fatalf("expected an even spread, got p-value %v", result.PValue)
+	}
+
+	// A key scheme that only ever produces a few distinct keys is skewed
+	skewed, err := Uniformity(m, func(i int) string { return key(i % 30) }, 20000)
+	if err != nil {
+		t.Fatalf("Uniformity() error = %v", err)
+	}
+	if skewed.PValue > 1e-6 {
+		t.Fatalf("expected a skewed spread, got p-value %v", skewed.PValue)
+	}
+
+	if _, err := Uniformity(NewMementoHasher(hashing.SHA256), key, 100); err == nil {
+		t.Fatalf("expected an error without buckets")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Hard placement constraints of objects, such as data residency
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+)
+
+// ErrConstraintViolated is wrapped by the errors of objects that no node
+// meeting their constraints can take
+var ErrConstraintViolated = errors.New("placement constraint violated")
+
+// ConstraintError reports an object that cannot be placed on a node, or is
+// on a node, that does not meet one of its constraints
+type ConstraintError[T, O comparable] struct {
+	Object     O
+	Node       T
+	Constraint string
+}
+
+func (e *ConstraintError[T, O]) Error() string {
+	return fmt.Sprintf("%v on %v violates constraint %q", e.Object, e.Node, e.Constraint)
+}
+
+func (e *ConstraintError[T, O]) Unwrap() error {
+	return ErrConstraintViolated
+}
+
+// PlacementConstraint tells whether a node may hold the objects having the
+// constraint
+type PlacementConstraint[T, O comparable] func(node serverpool.Node[T, O]) bool
+
+// TagConstraint is met by nodes tagged key=value, such as region=eu for
+// objects that must stay in the EU. Nodes without tags never meet it.
+func TagConstraint[T, O comparable](key, value string) PlacementConstraint[T, O] {
+	return func(node serverpool.Node[T, O]) bool {
+		tagged, ok := node.(interface{ Tags() map[string]string })
+		return ok && tagged.Tags()[key] == value
+	}
+}
+
+// First constraint of an object node does not meet, "" if it meets all of
+// them. Constraints that are not configured are met by no node, so that a
+// typo fails closed.
+func (lb *loadBalancer[T, O]) violated(obj *serverpool.Object[T, O], node serverpool.Node[T, O]) string {
+	for _, name := range obj.Constraints {
+		if c, ok := lb.constraints[name]; !ok || !c(node) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Check that node meets the constraints of an object
+func (lb *loadBalancer[T, O]) checkConstraints(obj *serverpool.Object[T, O], node serverpool.Node[T, O]) error {
+	if name := lb.violated(obj, node); name != "" {
+		return &ConstraintError[T, O]{Object: obj.Id, Node: node.Name(), Constraint: name}
+	}
+	return nil
+}
+
+// Node of a constrained object given the node it maps to: that node if it
+// meets the constraints, otherwise the first candidate of its key that does
+func (lb *loadBalancer[T, O]) constrain(obj *serverpool.Object[T, O], node serverpool.Node[T, O]) (serverpool.Node[T, O], error) {
+	err := lb.checkConstraints(obj, node)
+	if err == nil {
+		return node, nil
+	}
+	candidates, cerr := lb.Candidates(obj.Name(), 0)
+	if cerr != nil {
+		return nil, cerr
+	}
+	for _, c := range candidates {
+		if lb.violated(obj, c) == "" {
+			return c, nil
+		}
+	}
+	return nil, err
+}
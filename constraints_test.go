@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"serverpool"
+	"testing"
+)
+
+func TestPlacementConstraints(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithPlacementConstraints(map[string]PlacementConstraint[netip.Addr, int]{
+		"eu-only":   TagConstraint[netip.Addr, int]("region", "eu"),
+		"apac-only": TagConstraint[netip.Addr, int]("region", "apac"),
+	}))
+	var nodes []serverpool.Node[netip.Addr, int]
+	for i, region := range []string{"eu", "us", "eu", "us"} {
+		node := NewTaggedServerNode[int](netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 1)}), map[string]string{"region": region})
+		nodes = append(nodes, &node)
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[netip.Addr, int]
+	for i := 0; i < 40; i++ {
+		objs = append(objs, &serverpool.Object[netip.Addr, int]{Id: i, Constraints: []string{"eu-only"}})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	onEU := func() bool {
+		for _, obj := range objs {
+			if n := obj.Node(); n == nil || (*n != nodes[0] && *n != nodes[2]) {
+				return false
+			}
+		}
+		return true
+	}
+	if !onEU() {
+		t.Fatalf("expected every object on an eu node")
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Objects of a removed eu node stay in the eu
+	if _, err := lb.RemoveNodes(nodes[:1]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !onEU() {
+		t.Fatalf("expected every object on the remaining eu node")
+	}
+
+	// No node meets the constraint
+	apac := &serverpool.Object[netip.Addr, int]{Id: 100, Constraints: []string{"apac-only"}}
+	if _, err := lb.AddObjects([]*serverpool.Object[netip.Addr, int]{apac}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var cerr *ConstraintError[netip.Addr, int]
+	if err := lb.AssignObject(apac); !errors.As(err, &cerr) || !errors.Is(err, ErrConstraintViolated) || cerr.Constraint != "apac-only" {
+		t.Fatalf("expected apac-only violated, got %v", err)
+	}
+	if err := lb.TransferObject(objs[0], nodes[1]); !errors.As(err, &cerr) || cerr.Node != nodes[1].Name() {
+		t.Fatalf("expected transfer to a us node to fail, got %v", err)
+	}
+
+	// Verify reports objects placed around the constraints
+	us := nodes[1]
+	from := *objs[0].Node()
+	from.UnassignObject(objs[0])
+	us.AssignObject(objs[0])
+	objs[0].AssignToNode(&us)
+	if err := lb.Verify(); !errors.Is(err, ErrInvariant) || !errors.Is(err, ErrConstraintViolated) {
+		t.Fatalf("expected a constraint violation, got %v", err)
+	}
+}
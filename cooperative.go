@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Cooperative rebalancing of objects after membership changes
+
+package main
+
+import (
+	"serverpool"
+)
+
+// RebalanceCallbacks receive the two phases of a cooperative rebalance.
+// All revocations complete before the first object is granted, so no
+// object is ever assigned to two nodes at once.
+type RebalanceCallbacks[T, O comparable] struct {
+	// Called for each node losing objects, before they are unassigned
+	Revoked func(node serverpool.Node[T, O], objects []*serverpool.Object[T, O])
+
+	// Called for each node gaining objects, after they are assigned
+	Assigned func(node serverpool.Node[T, O], objects []*serverpool.Object[T, O])
+}
+
+// Outcome of a cooperative rebalance for the objects of one source node
+type rebalanceCounts struct {
+	reassigned, deferred, orphaned int
+}
+
+// Move every assigned object whose node changed with the membership, and
+// only those, revoking all of them before granting any. Moves beyond the
+// movement budget or during a cool-down are deferred; objects of nodes that
+// are gone are then unassigned and queued for Rebalance, others stay put.
+// Returns the counts per source node.
+func (lb *loadBalancer[T, O]) rebalanceCooperatively(errs *ReassignmentError[O]) map[serverpool.Node[T, O]]*rebalanceCounts {
+	counts := make(map[serverpool.Node[T, O]]*rebalanceCounts)
+	count := func(node serverpool.Node[T, O]) *rebalanceCounts {
+		c, ok := counts[node]
+		if !ok {
+			c = &rebalanceCounts{}
+			counts[node] = c
+		}
+		return c
+	}
+	registered := func(node serverpool.Node[T, O]) bool {
+		n, ok := lb.lookupNode(node)
+		return ok && n == node
+	}
+
+	lb.profiler.do("rebalance", func() {
+		leave := lb.rebalances.enter(PhasePlanning)
+		stale := false
+		var moves []Move[T, O]
+		for obj := range lb.objects.all() {
+			from := obj.Node()
+			if from == nil || *from == nil {
+				continue
+			}
+
+			// Only objects of removed nodes move while automation is paused
+			if lb.paused && registered(*from) {
+				stale = true
+				continue
+			}
+			to, err := lb.placement(obj)
+			if err != nil {
+				lb.prefetcher.forget(obj)
+				lb.detach(obj)
+				errs.add(obj.Id, err)
+				count(*from).orphaned++
+				continue
+			}
+			if to == *from {
+				lb.prefetcher.replan(obj, nil)
+				continue
+			}
+			lb.prefetcher.replan(obj, to)
+			moves = append(moves, Move[T, O]{Object: obj, From: *from, To: to})
+		}
+
+		lb.rebalances.planned(len(moves))
+		allowed, postponed := lb.admit(moves)
+		leave()
+		var queued []Move[T, O]
+		for _, m := range postponed {
+			if !registered(m.From) {
+				lb.detach(m.Object)
+				queued = append(queued, m)
+				count(m.From).deferred++
+			} else {
+				stale = true
+			}
+		}
+		lb.stale = stale
+
+		// Phase one revokes every moving object from its node
+		for from, ms := range groupMoves(allowed, func(m Move[T, O]) serverpool.Node[T, O] { return m.From }) {
+			if lb.cooperative != nil {
+				lb.callCooperative(lb.cooperative.Revoked, from, ms)
+			}
+			for _, m := range ms {
+				lb.detach(m.Object)
+			}
+		}
+
+		// Phase two grants the objects to their new nodes
+		for to, ms := range groupMoves(allowed, func(m Move[T, O]) serverpool.Node[T, O] { return m.To }) {
+			node := to
+			for _, m := range ms {
+				node.AssignObject(m.Object)
+				m.Object.AssignToNode(&node)
+				lb.notifyPlaced(m.Object, m.From, node)
+				count(m.From).reassigned++
+			}
+			if lb.cooperative != nil {
+				lb.callCooperative(lb.cooperative.Assigned, to, ms)
+			}
+		}
+
+		lb.churn.record(len(allowed))
+		lb.churn.postpone(queued)
+	})
+	return counts
+}
+
+// RebalanceReport is the outcome of RebalanceAll
+type RebalanceReport[T, O comparable] struct {
+	// Objects moved to the node they now map to
+	Moved []Move[T, O]
+
+	// Objects whose node changed left in place by the movement budget, a
+	// cool-down or paused automation
+	Deferred int
+
+	// Objects unassigned because no node could take them
+	Orphaned int
+}
+
+// RebalanceAll moves every assigned object whose node changed since it was
+// placed, typically after nodes were added, and only those. Objects move
+// in the two phases of WithCooperativeRebalance, which does the same on
+// every membership change, and its callbacks are notified if set. A dry run
+// reports the moves ignoring the movement budget.
+func (lb *loadBalancer[T, O]) RebalanceAll() (report RebalanceReport[T, O], err error) {
+	if lb.dryRun {
+		moves, orphaned := lb.planRebalance()
+		report.Moved = moves
+		for _, n := range orphaned {
+			report.Orphaned += n
+		}
+		return report, nil
+	}
+	if lb.readOnly {
+		return report, ErrReadOnly
+	}
+	lb.profileRebalance("RebalanceAll", func() { report, err = lb.rebalanceAll() })
+	return report, err
+}
+
+func (lb *loadBalancer[T, O]) rebalanceAll() (RebalanceReport[T, O], error) {
+	var report RebalanceReport[T, O]
+	var errs ReassignmentError[O]
+	planned, _ := lb.planRebalance()
+	for _, c := range lb.rebalanceCooperatively(&errs) {
+		report.Deferred += c.deferred
+		report.Orphaned += c.orphaned
+	}
+
+	var moved []*serverpool.Object[T, O]
+	for _, m := range planned {
+		if node := m.Object.Node(); node != nil && *node == m.To {
+			report.Moved = append(report.Moved, m)
+			moved = append(moved, m.Object)
+		} else if node != nil && *node == m.From {
+			report.Deferred++
+		}
+	}
+	if len(moved) > 0 {
+		lb.publish(ChangeAssignObject, nil, moved)
+	}
+
+	if len(errs.Errors) > 0 {
+		return report, &errs
+	}
+	return report, nil
+}
+
+// Group moves by the node key returns, preserving their order
+func groupMoves[T, O comparable](moves []Move[T, O], key func(Move[T, O]) serverpool.Node[T, O]) map[serverpool.Node[T, O]][]Move[T, O] {
+	groups := make(map[serverpool.Node[T, O]][]Move[T, O])
+	for _, m := range moves {
+		groups[key(m)] = append(groups[key(m)], m)
+	}
+	return groups
+}
+
+func moveObjects[T, O comparable](moves []Move[T, O]) []*serverpool.Object[T, O] {
+	objects := make([]*serverpool.Object[T, O], len(moves))
+	for i, m := range moves {
+		objects[i] = m.Object
+	}
+	return objects
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestCooperativeRebalance(t *testing.T) {
+	var phases []string
+	revoked := make(map[string]bool)
+	assigned := make(map[string]bool)
+	lb := NewLoadBalancerWithOptions(WithCooperativeRebalance(RebalanceCallbacks[string, string]{
+		Revoked: func(node serverpool.Node[string, string], objects []*serverpool.Object[string, string]) {
+			phases = append(phases, "revoke")
+			for _, obj := range objects {
+				if *obj.Node() != node {
+					t.Errorf("expected %v to be on %v when revoked", obj, node)
+				}
+				revoked[obj.Id] = true
+			}
+		},
+		Assigned: func(node serverpool.Node[string, string], objects []*serverpool.Object[string, string]) {
+			phases = append(phases, "assign")
+			for _, obj := range objects {
+				assigned[obj.Id] = true
+			}
+		},
+	}))
+
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	nodes := []serverpool.Node[string, string]{newNode("node1"), newNode("node2"), newNode("node3")}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 50; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	check := func(before map[string]serverpool.Node[string, string]) {
+		t.Helper()
+		for i, phase := range phases {
+			if phase == "revoke" && i > 0 && phases[i-1] == "assign" {
+				t.Fatalf("expected all revocations before assignments, got %v", phases)
+			}
+		}
+		for _, obj := range objs {
+			want, _ := lb.GetNode(obj.Name())
+			if *obj.Node() != want {
+				t.Fatalf("expected %v on %v, got %v", obj, want, *obj.Node())
+			}
+			moved := before[obj.Id] != want
+			if revoked[obj.Id] != moved || assigned[obj.Id] != moved {
+				t.Fatalf("expected only moved objects in callbacks, %v moved %t", obj, moved)
+			}
+		}
+	}
+	snapshot := func() map[string]serverpool.Node[string, string] {
+		phases = nil
+		clear(revoked)
+		clear(assigned)
+		before := make(map[string]serverpool.Node[string, string])
+		for _, obj := range objs {
+			before[obj.Id] = *obj.Node()
+		}
+		return before
+	}
+
+	before := snapshot()
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode("node4")}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(revoked) == 0 {
+		t.Fatalf("expected some objects to move to the new node")
+	}
+	check(before)
+
+	before = snapshot()
+	onNode2 := len(nodes[1].(*mockNode).objects)
+	result, err := lb.RemoveNodes(nodes[1:2])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result.Reassigned() != onNode2 {
+		t.Fatalf("expected %d objects reassigned, got %d", onNode2, result.Reassigned())
+	}
+	check(before)
+}
+
+func TestRebalanceAll(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode("node1"), newNode("node2")}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objects []*serverpool.Object[string, string]
+	for i := range 200 {
+		objects = append(objects, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objects); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objects {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// Adding a node leaves every object in place until a rebalance
+	node3 := newNode("node3")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node3}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(node3.objects) != 0 {
+		t.Fatalf("expected no objects on node3 before rebalancing, got %d", len(node3.objects))
+	}
+	want := 0
+	for _, obj := range objects {
+		if node, _ := lb.GetNode(obj.Id); node != *obj.Node() {
+			want++
+		}
+	}
+
+	report, err := lb.RebalanceAll()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if want == 0 || len(report.Moved) != want || report.Deferred != 0 || report.Orphaned != 0 {
+		t.Fatalf("expected %d objects moved, got %+v", want, report)
+	}
+	for _, m := range report.Moved {
+		if m.To != node3 || *m.Object.Node() != node3 {
+			t.Fatalf("expected %v moved to node3, got %v", m.Object, m)
+		}
+	}
+	for _, obj := range objects {
+		if node, _ := lb.GetNode(obj.Id); node != *obj.Node() {
+			t.Fatalf("expected %v on the node its key maps to", obj)
+		}
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if report, err := lb.RebalanceAll(); err != nil || len(report.Moved) != 0 {
+		t.Fatalf("expected nothing left to move, got %+v, %v", report, err)
+	}
+	if _, err := newMirror(t, NewLoadBalancer[string, string]()).RebalanceAll(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly from a mirror, got %v", err)
+	}
+}
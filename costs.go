@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Attribution of objects and their cost to nodes and tenants
+
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"serverpool"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// CostModel tells a cost report who owns an object and what it costs
+type CostModel[T, O comparable] struct {
+	// Tenant an object belongs to, nil puts every object in tenant ""
+	Tenant func(obj *serverpool.Object[T, O]) string
+
+	// Cost of an object, nil gives every object a cost of 0
+	Cost func(obj *serverpool.Object[T, O]) float64
+}
+
+// CostSample is the share of one tenant on one node at a point in time
+type CostSample[T comparable] struct {
+	Time    time.Time
+	Node    T
+	Tenant  string
+	Objects int
+	Cost    float64
+}
+
+// CostReport collects samples of the objects assigned to each node for
+// chargeback of shared capacity
+type CostReport[T, O comparable] struct {
+	model   CostModel[T, O]
+	samples []CostSample[T]
+}
+
+// Create an empty cost report
+func NewCostReport[T, O comparable](model CostModel[T, O]) *CostReport[T, O] {
+	return &CostReport[T, O]{model: model}
+}
+
+// Record a sample per node and tenant of the objects currently assigned.
+// Unassigned objects are not attributed to anyone.
+func (r *CostReport[T, O]) Record(lb LoadBalancer[T, O], at time.Time) {
+	type share struct {
+		node   T
+		tenant string
+	}
+	shares := make(map[share]*CostSample[T])
+	for obj := range lb.Objects() {
+		node := obj.Node()
+		if node == nil || *node == nil {
+			continue
+		}
+		key := share{node: (*node).Name()}
+		if r.model.Tenant != nil {
+			key.tenant = r.model.Tenant(obj)
+		}
+		s, ok := shares[key]
+		if !ok {
+			s = &CostSample[T]{Time: at, Node: key.node, Tenant: key.tenant}
+			shares[key] = s
+		}
+		s.Objects++
+		if r.model.Cost != nil {
+			s.Cost += r.model.Cost(obj)
+		}
+	}
+
+	samples := make([]CostSample[T], 0, len(shares))
+	for _, s := range shares {
+		samples = append(samples, *s)
+	}
+	slices.SortFunc(samples, func(a, b CostSample[T]) int {
+		return cmp.Or(cmp.Compare(fmt.Sprint(a.Node), fmt.Sprint(b.Node)), cmp.Compare(a.Tenant, b.Tenant))
+	})
+	r.samples = append(r.samples, samples...)
+}
+
+// Samples recorded so far, in the order they were taken
+func (r *CostReport[T, O]) Samples() []CostSample[T] {
+	return r.samples
+}
+
+// Write the samples as CSV with a header row
+func (r *CostReport[T, O]) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "node", "tenant", "objects", "cost"})
+	for _, s := range r.samples {
+		cw.Write([]string{s.Time.UTC().Format(time.RFC3339), fmt.Sprint(s.Node), s.Tenant,
+			strconv.Itoa(s.Objects), strconv.FormatFloat(s.Cost, 'f', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Samples the nodes after each command and rewrites the report
+type costWriter struct {
+	path   string
+	report *CostReport[netip.Addr, int]
+}
+
+func newCostWriter(path string) *costWriter {
+	return &costWriter{path: path, report: NewCostReport(CostModel[netip.Addr, int]{})}
+}
+
+func (c *costWriter) update(lb LoadBalancer[netip.Addr, int]) {
+	c.report.Record(lb, time.Now())
+	f, err := os.Create(c.path)
+	if err == nil {
+		err = c.report.WriteCSV(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		out.info("Error writing cost report:", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"serverpool"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCostReport(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode("node1")}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	objs := []*serverpool.Object[string, string]{{Id: "a/1"}, {Id: "a/2"}, {Id: "b/1"}, {Id: "b/2"}}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs[:3] {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	report := NewCostReport(CostModel[string, string]{
+		Tenant: func(obj *serverpool.Object[string, string]) string { return obj.Id[:1] },
+		Cost:   func(obj *serverpool.Object[string, string]) float64 { return 0.5 },
+	})
+	report.Record(lb, time.Unix(0, 0))
+	if err := lb.AssignObject(objs[3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	report.Record(lb, time.Unix(60, 0))
+
+	var b strings.Builder
+	if err := report.WriteCSV(&b); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := `time,node,tenant,objects,cost
+1970-01-01T00:00:00Z,node1,a,2,1
+1970-01-01T00:00:00Z,node1,b,1,0.5
+1970-01-01T00:01:00Z,node1,a,2,1
+1970-01-01T00:01:00Z,node1,b,2,1
+`
+	if b.String() != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,35 @@
+/*
+ * Copyright (c) 2024 Rishabh Parekh
+ * Use of this source code is governed by an MIT license that can be
+ * found in the LICENSE file.
+ *
+ * Stable C ABI of libloadbalance. Keys and topologies are passed as
+ * pointer and length and need not be NUL terminated.
+ */
+
+#ifndef LOADBALANCE_H
+#define LOADBALANCE_H
+
+#include <stddef.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* Load a JSON topology snapshot. Returns 0 on success, -1 if it is invalid,
+ * in which case the previous topology stays loaded. */
+int lb_load_topology(const char *data, size_t len);
+
+/* Bucket of the key, -1 if no topology is loaded or it has no buckets. */
+long long lb_get_bucket(const char *key, size_t len);
+
+/* Copy the name of the node of the key to out, truncated to size bytes and
+ * not NUL terminated. Returns the full length of the name, -1 if there is
+ * no node for the key. */
+long long lb_get_node(const char *key, size_t len, char *out, size_t size);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
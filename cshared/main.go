@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// C ABI computing the key to node mapping from a topology snapshot, for
+// proxies that are not written in Go. Build with
+//
+//	go build -buildmode=c-shared -o libloadbalance.so ./cshared
+//
+// and include loadbalance.h. All functions are safe to call from any
+// thread, lookups see either the previous or the newly loaded topology.
+
+package main
+
+/*
+#include <stddef.h>
+*/
+import "C"
+
+import (
+	"consistenthash"
+	"encoding/json"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Lookup of the last successfully loaded topology
+var lookup atomic.Pointer[consistenthash.TopologyLookup]
+
+// View C memory as a string without copying, valid until the call returns
+func cString(data *C.char, n C.size_t) string {
+	if data == nil || n == 0 {
+		return ""
+	}
+	return unsafe.String((*byte)(unsafe.Pointer(data)), int(n))
+}
+
+//export lb_load_topology
+func lb_load_topology(data *C.char, n C.size_t) C.int {
+	var t consistenthash.Topology
+	if err := json.Unmarshal([]byte(cString(data, n)), &t); err != nil {
+		return -1
+	}
+	l, err := consistenthash.NewTopologyLookup(t)
+	if err != nil {
+		return -1
+	}
+	lookup.Store(l)
+	return 0
+}
+
+//export lb_get_bucket
+func lb_get_bucket(key *C.char, n C.size_t) C.longlong {
+	l := lookup.Load()
+	if l == nil {
+		return -1
+	}
+	return C.longlong(l.GetBucket(cString(key, n)))
+}
+
+//export lb_get_node
+func lb_get_node(key *C.char, n C.size_t, out *C.char, size C.size_t) C.longlong {
+	l := lookup.Load()
+	if l == nil {
+		return -1
+	}
+	node, ok := l.GetNode(cString(key, n))
+	if !ok {
+		return -1
+	}
+	if out != nil && size > 0 {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(out)), int(size)), node)
+	}
+	return C.longlong(len(node))
+}
+
+func main() {}
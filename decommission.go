@@ -0,0 +1,162 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Reports of the nodes decommissioned by RemoveNodes
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"serverpool"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DefaultDecommissionSample is the number of keys sampled to estimate the
+// keys a removal remaps, if WithDecommissionReports is given 0
+const DefaultDecommissionSample = 1000
+
+// DefaultDecommissionReports is the number of decommission reports kept
+const DefaultDecommissionReports = 16
+
+// DecommissionReport reports what removing nodes did to their objects and
+// keys
+type DecommissionReport[T, O comparable] struct {
+	// Nodes removed
+	Nodes []T `json:"nodes"`
+
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+
+	// Objects of the removed nodes moved and the node each moved to
+	Moved map[O]T `json:"moved"`
+
+	// Objects of the removed nodes left unassigned for now by the movement
+	// budget or a cool-down, to be moved by Rebalance
+	Deferred []O `json:"deferred,omitempty"`
+
+	// Objects that could not be reassigned or were evicted and why
+	Errors map[O]string `json:"errors,omitempty"`
+
+	// Keys sampled and the share of them mapping to another node after the
+	// removal
+	KeysSampled  int     `json:"keys_sampled"`
+	KeysRemapped float64 `json:"keys_remapped"`
+
+	// Error of the removal, such as a node not found, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// Decommission reports of a load balancer
+type decommissions[T, O comparable] struct {
+	// Reports are off if 0, otherwise the keys sampled
+	sample int
+
+	// Last reports kept, oldest first
+	reports []DecommissionReport[T, O]
+
+	// Called with each report, if set
+	written func(DecommissionReport[T, O])
+}
+
+// Remove nodes with remove and report the decommission of the nodes removed
+func (lb *loadBalancer[T, O]) decommission(nodes []serverpool.Node[T, O], remove func() (NodesResult[T, O], error)) (NodesResult[T, O], error) {
+	d := &lb.decommissions
+	if d.sample == 0 {
+		return remove()
+	}
+	start := lb.churn.clock()
+
+	// Map the sampled keys and note the objects held before anything moves
+	keys := make([]serverpool.Node[T, O], d.sample)
+	for i := range keys {
+		keys[i], _ = lb.mapKey(strconv.Itoa(i))
+	}
+	var held []*serverpool.Object[T, O]
+	for _, node := range nodes {
+		if n, ok := lb.lookupNode(node); ok {
+			for obj := range n.Objects() {
+				held = append(held, obj)
+			}
+		}
+	}
+
+	began := time.Now()
+	result, err := remove()
+
+	report := DecommissionReport[T, O]{Start: start, Duration: time.Since(began),
+		Moved: make(map[O]T), KeysSampled: d.sample}
+	for _, nr := range result.Nodes {
+		if nr.Status == StatusOK {
+			report.Nodes = append(report.Nodes, nr.Node.Name())
+		}
+	}
+	if len(report.Nodes) == 0 {
+		return result, err
+	}
+
+	var rerr *ReassignmentError[O]
+	if errors.As(err, &rerr) {
+		report.Errors = make(map[O]string, len(rerr.Errors))
+		for id, e := range rerr.Errors {
+			report.Errors[id] = e.Error()
+		}
+	} else if err != nil {
+		report.Error = err.Error()
+	}
+	for _, obj := range held {
+		if _, failed := report.Errors[obj.Id]; failed {
+			continue
+		}
+		if n := obj.Node(); n != nil && *n != nil && !slices.Contains(report.Nodes, (*n).Name()) {
+			report.Moved[obj.Id] = (*n).Name()
+		} else {
+			report.Deferred = append(report.Deferred, obj.Id)
+		}
+	}
+
+	remapped := 0
+	for i, before := range keys {
+		after, _ := lb.mapKey(strconv.Itoa(i))
+		if before != nil && (after == nil || after.Name() != before.Name()) {
+			remapped++
+		}
+	}
+	report.KeysRemapped = float64(remapped) / float64(d.sample)
+
+	if len(d.reports) == DefaultDecommissionReports {
+		d.reports = slices.Delete(d.reports, 0, 1)
+	}
+	d.reports = append(d.reports, report)
+	if d.written != nil {
+		lb.afterCommit(func() { d.written(report) })
+	}
+	return result, err
+}
+
+// DecommissionReports returns the reports of the last nodes removed,
+// oldest first. It is empty unless the load balancer was created
+// WithDecommissionReports.
+func (lb *loadBalancer[T, O]) DecommissionReports() []DecommissionReport[T, O] {
+	return slices.Clone(lb.decommissions.reports)
+}
+
+// Append each report to the audit log at path as a line of JSON
+func auditLog[T, O comparable](path string) func(DecommissionReport[T, O]) {
+	return func(report DecommissionReport[T, O]) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err == nil {
+			err = json.NewEncoder(f).Encode(report)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			out.info("Error writing audit log:", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"slices"
+	"testing"
+)
+
+func TestDecommissionReports(t *testing.T) {
+	var written []DecommissionReport[string, string]
+	lb := NewConcurrentLoadBalancer(WithDecommissionReports(500, func(r DecommissionReport[string, string]) {
+		written = append(written, r)
+	}))
+	var nodes []serverpool.Node[string, string]
+	for i := 0; i < 4; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 40; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	held := slices.Collect(nodes[0].Objects())
+
+	if _, err := lb.RemoveNodes(nodes[:1]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	reports := lb.(StatsReporter[string, string]).DecommissionReports()
+	if len(reports) != 1 || len(written) != 1 {
+		t.Fatalf("expected 1 report kept and written, got %d and %d", len(reports), len(written))
+	}
+	r := reports[0]
+	if !slices.Equal(r.Nodes, []string{"node0"}) || len(r.Moved) != len(held) || len(r.Errors) != 0 {
+		t.Fatalf("expected the %d objects of node0 moved, got %+v", len(held), r)
+	}
+	for _, obj := range held {
+		if to, ok := r.Moved[obj.Id]; !ok || to != (*obj.Node()).Name() {
+			t.Fatalf("expected %v moved to %v, got %v", obj, (*obj.Node()).Name(), to)
+		}
+	}
+	if r.KeysSampled != 500 || r.KeysRemapped <= 0 || r.KeysRemapped > 0.5 {
+		t.Fatalf("expected about a quarter of 500 keys remapped, got %v of %d", r.KeysRemapped, r.KeysSampled)
+	}
+
+	// Nothing is reported when no node is removed
+	if _, err := lb.RemoveNodes(nodes[:1]); err == nil {
+		t.Fatalf("expected an error removing node0 again")
+	}
+	if reports := lb.(StatsReporter[string, string]).DecommissionReports(); len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Gradual shift of the keys of a node to another node on a schedule
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+	"time"
+)
+
+// ErrNoDial is returned when rolling back a node that is not being dialed
+var ErrNoDial = errors.New("node is not being dialed")
+
+// DialStep is a point of a dial schedule: from After the start of the dial
+// on, Percent of the keys of the source node go to the target node
+type DialStep struct {
+	After   time.Duration
+	Percent float64
+}
+
+// DialProgress reports where the dial of a node stands
+type DialProgress[T comparable] struct {
+	// Node receiving the keys
+	To T
+
+	// When the dial started
+	Started time.Time
+
+	// Percent of the keys of the source node going to the target now
+	Percent float64
+
+	// The last step of the schedule is reached
+	Done bool
+}
+
+// Dial of the keys of a node
+type dial[T, O comparable] struct {
+	to      serverpool.Node[T, O]
+	steps   []DialStep
+	started time.Time
+}
+
+// Percent of the keys dialed at the given time
+func (d *dial[T, O]) percent(now time.Time) (percent float64, done bool) {
+	elapsed := now.Sub(d.started)
+	for i, step := range d.steps {
+		if elapsed < step.After {
+			break
+		}
+		percent, done = step.Percent, i == len(d.steps)-1
+	}
+	return percent, done
+}
+
+// DialTraffic shifts the keys of a node to another node by percentage on a
+// schedule, e.g. 10% at once, 50% after an hour and 100% after a day, to
+// migrate gradually. Keys only move from the source to the target as the
+// percentage grows, the same keys at every step, so a key never moves back
+// until the dial is rolled back. Objects follow on the next rebalance.
+// Dials are published to the change feed with their start, so mirrors and
+// past states shift the same keys, and saved with the state. A dial ends
+// when either node leaves.
+func (lb *loadBalancer[T, O]) DialTraffic(from, to serverpool.Node[T, O], schedule []DialStep) error {
+	if err := lb.checkDial(from, to, schedule); err != nil || lb.dryRun {
+		return err
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	from, _ = lb.lookupNode(from)
+	to, _ = lb.lookupNode(to)
+	lb.startDial(from, to, schedule, lb.churn.clock())
+	return nil
+}
+
+// Start the dial of a node at the given time and publish it
+func (lb *loadBalancer[T, O]) startDial(from, to serverpool.Node[T, O], schedule []DialStep, started time.Time) {
+	if lb.dials == nil {
+		lb.dials = make(map[T]*dial[T, O])
+	}
+	steps := append([]DialStep(nil), schedule...)
+	lb.dials[from.Name()] = &dial[T, O]{to: to, steps: steps, started: started}
+	lb.record(Change[T, O]{Op: ChangeDialTraffic, Time: started, Nodes: []serverpool.Node[T, O]{from, to}, Schedule: steps})
+}
+
+// Check that both nodes are in the pool and the schedule only grows
+func (lb *loadBalancer[T, O]) checkDial(from, to serverpool.Node[T, O], schedule []DialStep) error {
+	for _, node := range []serverpool.Node[T, O]{from, to} {
+		if _, ok := lb.lookupNode(node); !ok {
+			return fmt.Errorf("%v not found", node)
+		}
+	}
+	if from.Name() == to.Name() {
+		return errors.New("cannot dial a node to itself")
+	}
+	if _, ok := lb.dials[from.Name()]; ok {
+		return fmt.Errorf("%v is already being dialed", from)
+	}
+	if len(schedule) == 0 {
+		return errors.New("empty dial schedule")
+	}
+	for i, step := range schedule {
+		if step.Percent < 0 || step.Percent > 100 {
+			return fmt.Errorf("dial step %d: percent %v is not between 0 and 100", i, step.Percent)
+		}
+		if i > 0 && (step.After < schedule[i-1].After || step.Percent < schedule[i-1].Percent) {
+			return fmt.Errorf("dial step %d: steps must not go back in time or percent", i)
+		}
+	}
+	return nil
+}
+
+// RollbackDial ends the dial of a node, so all its keys map to it again.
+// Objects moved to the target return on the next rebalance.
+func (lb *loadBalancer[T, O]) RollbackDial(from serverpool.Node[T, O]) error {
+	if _, ok := lb.dials[from.Name()]; !ok {
+		return fmt.Errorf("%w: %v", ErrNoDial, from)
+	}
+	if lb.dryRun {
+		return nil
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	if node, ok := lb.lookupNode(from); ok {
+		from = node
+	}
+	lb.rollbackDial(from)
+	return nil
+}
+
+// End the dial of a node and publish it
+func (lb *loadBalancer[T, O]) rollbackDial(from serverpool.Node[T, O]) {
+	delete(lb.dials, from.Name())
+	lb.record(Change[T, O]{Op: ChangeRollbackDial, Nodes: []serverpool.Node[T, O]{from}})
+}
+
+// DialStats reports the dials in progress keyed by source node
+func (lb *loadBalancer[T, O]) DialStats() map[T]DialProgress[T] {
+	stats := make(map[T]DialProgress[T], len(lb.dials))
+	now := lb.churn.clock()
+	for name, d := range lb.dials {
+		percent, done := d.percent(now)
+		stats[name] = DialProgress[T]{To: d.to.Name(), Started: d.started, Percent: percent, Done: done}
+	}
+	return stats
+}
+
+// Node a key mapped to node goes to, the target of a dial of node if the
+// key is among the keys dialed
+func (lb *loadBalancer[T, O]) dialed(key string, node serverpool.Node[T, O]) serverpool.Node[T, O] {
+	d, ok := lb.dials[node.Name()]
+	if !ok {
+		return node
+	}
+	percent, _ := d.percent(lb.churn.clock())
+
+	// Score by the target, so keys of a source do not all shift together
+	// with the keys of other dials
+	if float64(rendezvousScore(d.to.Name(), key)%10000) < percent*100 {
+		return d.to
+	}
+	return node
+}
+
+// End the dials from or to a node leaving the pool
+func (lb *loadBalancer[T, O]) endDials(name T) {
+	delete(lb.dials, name)
+	for from, d := range lb.dials {
+		if d.to.Name() == name {
+			delete(lb.dials, from)
+		}
+	}
+}
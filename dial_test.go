@@ -0,0 +1,166 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"serverpool"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDialTraffic(t *testing.T) {
+	lb := NewLoadBalancer[string, string]().(*loadBalancer[string, string])
+	now := time.Unix(1000, 0)
+	lb.churn.now = func() time.Time { return now }
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	node1, node2, node3 := newNode("node1"), newNode("node2"), newNode("node3")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2, node3}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var keys []string
+	for i := range 2000 {
+		if node, _ := lb.GetNode("key" + strconv.Itoa(i)); node == node1 {
+			keys = append(keys, "key"+strconv.Itoa(i))
+		}
+	}
+
+	schedule := []DialStep{{0, 10}, {time.Hour, 50}, {2 * time.Hour, 100}}
+	for _, bad := range [][]DialStep{nil, {{0, 110}}, {{0, 50}, {time.Hour, 10}}, {{time.Hour, 10}, {0, 50}}} {
+		if err := lb.DialTraffic(node1, node3, bad); err == nil {
+			t.Fatalf("expected an error for schedule %v", bad)
+		}
+	}
+	if err := lb.DialTraffic(node1, node1, schedule); err == nil {
+		t.Fatalf("expected an error dialing a node to itself")
+	}
+	if err := lb.DialTraffic(node1, node3, schedule); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.DialTraffic(node1, node2, schedule); err == nil {
+		t.Fatalf("expected an error dialing a node twice")
+	}
+
+	// Keys only move from node1 to node3, and keys moved stay moved as
+	// the percentage grows
+	moved := make(map[string]bool)
+	for _, step := range schedule {
+		now = time.Unix(1000, 0).Add(step.After)
+		n := 0
+		for _, key := range keys {
+			node, _ := lb.GetNode(key)
+			switch {
+			case node == node3:
+				n++
+				moved[key] = true
+			case node != node1:
+				t.Fatalf("expected %s on node1 or node3, got %v", key, node)
+			case moved[key]:
+				t.Fatalf("expected %s to stay on node3", key)
+			}
+		}
+		share := 100 * float64(n) / float64(len(keys))
+		if share < step.Percent-5 || share > step.Percent+5 {
+			t.Fatalf("expected about %v%% of the keys of node1 on node3, got %.1f%%", step.Percent, share)
+		}
+		progress := lb.DialStats()["node1"]
+		if progress.To != "node3" || progress.Percent != step.Percent || progress.Done != (step.Percent == 100) {
+			t.Fatalf("expected %v%% dialed to node3, got %+v", step.Percent, progress)
+		}
+	}
+
+	if err := lb.RollbackDial(node1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, key := range keys {
+		if node, _ := lb.GetNode(key); node != node1 {
+			t.Fatalf("expected %s back on node1, got %v", key, node)
+		}
+	}
+	if err := lb.RollbackDial(node1); !errors.Is(err, ErrNoDial) {
+		t.Fatalf("expected ErrNoDial, got %v", err)
+	}
+
+	// Removing the target ends the dial
+	if err := lb.DialTraffic(node1, node3, schedule); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{node3}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(lb.DialStats()) != 0 {
+		t.Fatalf("expected no dials after removing the target, got %v", lb.DialStats())
+	}
+	if err := newMirror(t, NewLoadBalancer[string, string]()).(Drainer[string, string]).RollbackDial(node1); err == nil {
+		t.Fatalf("expected an error from a mirror")
+	}
+}
+
+func TestDialTrafficReplayed(t *testing.T) {
+	lb := NewLoadBalancer[string, string]().(*loadBalancer[string, string])
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+	lb.churn.now = clock
+	newNode := func(id string) serverpool.Node[string, string] {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	nodes := []serverpool.Node[string, string]{newNode("node1"), newNode("node2"), newNode("node3")}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	mirror := newMirror(t, lb).(*concurrentLoadBalancer[string, string]).lb
+	mirror.churn.now = clock
+
+	if err := lb.DialTraffic(nodes[0], nodes[2], []DialStep{{0, 10}, {time.Hour, 50}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := lb.Topology(); !errors.Is(err, ErrNotExportable) {
+		t.Fatalf("expected ErrNotExportable while dialing, got %v", err)
+	}
+
+	// Mirrors, past states and loaded states shift the same keys
+	past, err := lb.StateAt(lb.Version())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	past.(*loadBalancer[string, string]).churn.now = clock
+	var saved bytes.Buffer
+	if err := lb.Save(&saved); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	loaded := NewLoadBalancer[string, string]().(*loadBalancer[string, string])
+	loaded.churn.now = clock
+	if err := loaded.Load(&saved, newNode); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, other := range []LoadBalancer[string, string]{mirror, past, loaded} {
+		want, got := lb.DialStats()["node1"], other.(Drainer[string, string]).DialStats()["node1"]
+		if got.To != want.To || !got.Started.Equal(want.Started) || got.Percent != want.Percent {
+			t.Fatalf("expected dial %+v, got %+v", want, got)
+		}
+		for i := range 500 {
+			key := "key" + strconv.Itoa(i)
+			want, _ := lb.GetNode(key)
+			if got, _ := other.GetNode(key); got.Name() != want.Name() {
+				t.Fatalf("expected %s on %v, got %v", key, want, got)
+			}
+		}
+	}
+
+	if err := lb.RollbackDial(nodes[0]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(mirror.DialStats()) != 0 {
+		t.Fatalf("expected the rollback on the mirror, got %v", mirror.DialStats())
+	}
+	if _, err := lb.Topology(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
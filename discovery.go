@@ -0,0 +1,171 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Discovery of nodes from cloud provider APIs
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"serverpool"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Instance is a server found by a Discoverer
+type Instance struct {
+	// Provider's id of the instance
+	ID string
+
+	// Private address the node is known by
+	Addr netip.Addr
+
+	// Tags or labels of the instance
+	Tags map[string]string
+}
+
+// Discoverer lists the instances that should be nodes
+type Discoverer interface {
+	Instances(ctx context.Context) ([]Instance, error)
+}
+
+// SyncNodes makes the nodes of the load balancer match the instances: it
+// adds a node tagged with the instance's tags for each new instance and
+// removes the nodes whose instance is gone. An empty list of instances is
+// taken as a failed listing rather than the end of every instance, so it
+// never removes all nodes. Nothing changes while automation is paused.
+func SyncNodes[O comparable](lb LoadBalancer[netip.Addr, O], instances []Instance) (added, removed int, err error) {
+	if len(instances) == 0 {
+		return 0, 0, errors.New("no instances discovered")
+	}
+	if automationPaused(lb) {
+		return 0, 0, ErrAutomationPaused
+	}
+
+	want := make(map[netip.Addr]Instance, len(instances))
+	for _, inst := range instances {
+		want[inst.Addr] = inst
+	}
+	var gone []serverpool.Node[netip.Addr, O]
+	for node := range lb.Nodes() {
+		if _, ok := want[node.Name()]; ok {
+			delete(want, node.Name())
+		} else {
+			gone = append(gone, node)
+		}
+	}
+
+	var errs []error
+	if len(want) > 0 {
+		nodes := make([]serverpool.Node[netip.Addr, O], 0, len(want))
+		for _, inst := range want {
+			node := NewTaggedServerNode[O](inst.Addr, inst.Tags)
+			nodes = append(nodes, &node)
+		}
+		result, err := lb.AddNodes(nodes)
+		added = result.Count(StatusOK)
+		errs = append(errs, err)
+	}
+	if len(gone) > 0 {
+		result, err := lb.RemoveNodes(gone)
+		removed = result.Count(StatusOK)
+		errs = append(errs, err)
+	}
+	return added, removed, errors.Join(errs...)
+}
+
+// WatchInstances lists the instances every interval until ctx is done and
+// passes each listing to fn, so autoscaling changes can be synced with
+// SyncNodes from the goroutine that owns the load balancer
+func WatchInstances(ctx context.Context, d Discoverer, interval time.Duration, fn func([]Instance, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn(d.Instances(ctx))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Parse a discovery source: ec2:<region>:<key>=<value>,<key> for the EC2
+// instances with the tags, or gce:<project>/<zone>/<group> for the instances
+// of a GCP instance group
+func parseDiscoverer(spec string) (Discoverer, error) {
+	provider, rest, _ := strings.Cut(spec, ":")
+	switch provider {
+	case "ec2":
+		region, filter, _ := strings.Cut(rest, ":")
+		if region == "" {
+			return nil, fmt.Errorf("missing region in %q", spec)
+		}
+		tags := make(map[string]string)
+		for _, tag := range strings.Split(filter, ",") {
+			if key, value, _ := strings.Cut(tag, "="); key != "" {
+				tags[key] = value
+			}
+		}
+		return NewEC2Discoverer(region, tags), nil
+	case "gce":
+		parts := strings.Split(rest, "/")
+		if len(parts) != 3 || slices.Contains(parts, "") {
+			return nil, fmt.Errorf("expected gce:<project>/<zone>/<group>, got %q", spec)
+		}
+		return NewGCEDiscoverer(parts[0], parts[1], parts[2]), nil
+	}
+	return nil, fmt.Errorf("unknown discovery provider %q", provider)
+}
+
+// Sync the nodes with a listing of the instances, then keep them in sync
+// with a listing every interval from the background until ctx is done.
+// Listings are synced holding mu, which commands and admin requests also
+// hold, and changed is called after each with mu held. Listings wait while
+// automation is paused.
+func watchDiscovery(ctx context.Context, lb LoadBalancer[netip.Addr, int], d Discoverer, interval time.Duration, mu sync.Locker, changed func()) error {
+	instances, err := d.Instances(ctx)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	syncDiscovered(lb, instances)
+	changed()
+	mu.Unlock()
+
+	go WatchInstances(ctx, d, interval, func(instances []Instance, err error) {
+		if err != nil {
+			out.info("Error discovering nodes:", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if automationPaused(lb) {
+			return
+		}
+		syncDiscovered(lb, instances)
+		changed()
+	})
+	return nil
+}
+
+// Sync the nodes with a listing and the addresses of the nodes with them
+func syncDiscovered(lb LoadBalancer[netip.Addr, int], instances []Instance) {
+	added, removed, err := SyncNodes(lb, instances)
+	if err != nil {
+		out.info("Error syncing discovered nodes:", err)
+	}
+	if added > 0 || removed > 0 {
+		out.info("Discovered", added, "new nodes and", removed, "nodes gone")
+	}
+	clear(addrs)
+	for node := range lb.Nodes() {
+		addrs[node.Name()] = struct{}{}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncNodes(t *testing.T) {
+	lb := NewLoadBalancer[netip.Addr, int]()
+	instance := func(addr string) Instance {
+		return Instance{ID: addr, Addr: netip.MustParseAddr(addr), Tags: map[string]string{"id": addr}}
+	}
+
+	added, removed, err := SyncNodes(lb, []Instance{instance("10.0.0.1"), instance("10.0.0.2")})
+	if err != nil || added != 2 || removed != 0 {
+		t.Fatalf("expected 2 nodes added, got %d added, %d removed, %v", added, removed, err)
+	}
+
+	// Scaling replaces one instance with another
+	added, removed, err = SyncNodes(lb, []Instance{instance("10.0.0.2"), instance("10.0.0.3")})
+	if err != nil || added != 1 || removed != 1 {
+		t.Fatalf("expected 1 node added and 1 removed, got %d added, %d removed, %v", added, removed, err)
+	}
+	for node := range lb.Nodes() {
+		tags := node.(interface{ Tags() map[string]string }).Tags()
+		if tags["id"] != node.Name().String() || node.Name() == netip.MustParseAddr("10.0.0.1") {
+			t.Fatalf("unexpected node %v with tags %v", node, tags)
+		}
+	}
+
+	if _, _, err := SyncNodes(lb, nil); err == nil || lb.NodeCount() != 2 {
+		t.Fatalf("expected an empty listing to be refused")
+	}
+
+	// Nothing changes while automation is paused
+	if err := lb.PauseAutomation(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, _, err := SyncNodes(lb, []Instance{instance("10.0.0.4")}); !errors.Is(err, ErrAutomationPaused) || lb.NodeCount() != 2 {
+		t.Fatalf("expected the listing to wait for the pause to end, got %v", err)
+	}
+}
+
+// Discoverer listing the instances last set
+type listingDiscoverer struct {
+	mu        sync.Mutex
+	instances []Instance
+	err       error
+}
+
+func (d *listingDiscoverer) set(addrs ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.instances = nil
+	for _, addr := range addrs {
+		d.instances = append(d.instances, Instance{ID: addr, Addr: netip.MustParseAddr(addr)})
+	}
+}
+
+func (d *listingDiscoverer) Instances(context.Context) ([]Instance, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.instances), d.err
+}
+
+func TestWatchDiscovery(t *testing.T) {
+	savedOut, savedAddrs := out, addrs
+	t.Cleanup(func() { out, addrs = savedOut, savedAddrs })
+	out = &output{out: io.Discard, log: io.Discard}
+	addrs = make(map[netip.Addr]struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lb := NewLoadBalancer[netip.Addr, int]()
+	var mu sync.Mutex
+	changes := 0
+	d := &listingDiscoverer{err: errors.New("unavailable")}
+	if err := watchDiscovery(ctx, lb, d, time.Millisecond, &mu, func() { changes++ }); err == nil {
+		t.Fatalf("expected a failed first listing to fail")
+	}
+	d.err = nil
+	d.set("10.0.0.1", "10.0.0.2")
+	if err := watchDiscovery(ctx, lb, d, time.Millisecond, &mu, func() { changes++ }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if lb.NodeCount() != 2 || len(addrs) != 2 || changes != 1 {
+		t.Fatalf("expected 2 nodes after 1 change, got %d after %d", lb.NodeCount(), changes)
+	}
+
+	// Later listings are synced without any command
+	synced := func(want ...string) bool {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			mu.Lock()
+			var got []string
+			for node := range lb.Nodes() {
+				got = append(got, node.Name().String())
+			}
+			slices.Sort(got)
+			ok := slices.Equal(got, want) && len(addrs) == len(want)
+			mu.Unlock()
+			if ok {
+				return true
+			}
+		}
+		return false
+	}
+	d.set("10.0.0.2", "10.0.0.3")
+	if !synced("10.0.0.2", "10.0.0.3") {
+		t.Fatalf("expected the scaled instances synced")
+	}
+
+	// Listings wait while automation is paused
+	mu.Lock()
+	if err := lb.PauseAutomation(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	mu.Unlock()
+	d.set("10.0.0.4")
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	if lb.NodeCount() != 2 {
+		t.Fatalf("expected no changes while paused, got %d nodes", lb.NodeCount())
+	}
+	err := lb.ResumeAutomation()
+	mu.Unlock()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !synced("10.0.0.4") {
+		t.Fatalf("expected the listing synced once resumed")
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// dns package is a small authoritative DNS responder publishing the nodes
+// of a pool as A/AAAA and SRV records, for clients that discover services
+// through DNS.
+//
+// For the zone lb.local. and the service _http._tcp the server answers
+//
+//	lb.local.                A/AAAA  address of every node
+//	_http._tcp.lb.local.     SRV     one record per node, targets below
+//	<target>.lb.local.       A/AAAA  address of the node
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Record types
+const (
+	typeA    = 1
+	typeAAAA = 28
+	typeSRV  = 33
+	classIN  = 1
+)
+
+// Response codes
+const (
+	rcodeOK       = 0
+	rcodeFormat   = 1
+	rcodeNXDomain = 3
+	rcodeNotImpl  = 4
+	rcodeRefused  = 5
+)
+
+// Largest UDP response without EDNS
+const maxUDPSize = 512
+
+// Target is a node published in DNS
+type Target struct {
+	// Host label of the node within the zone, e.g. node0
+	Name string
+	Addr netip.Addr
+	Port uint16
+
+	// SRV weight, nodes with a higher weight are picked more often
+	Weight uint16
+}
+
+// Source provides the targets to publish
+type Source interface {
+	Targets() []Target
+}
+
+// Server answers queries for a single zone
+type Server struct {
+	// Fully qualified lower case zone and service names
+	zone    string
+	service string
+
+	ttl    uint32
+	source Source
+}
+
+// NewServer creates a responder for the zone, e.g. lb.local, publishing
+// SRV records for the service, e.g. _http._tcp, with the given TTL in seconds
+func NewServer(zone, service string, ttl uint32, source Source) *Server {
+	zone = strings.ToLower(strings.TrimSuffix(zone, ".")) + "."
+	return &Server{zone: zone, service: strings.ToLower(service) + "." + zone, ttl: ttl, source: source}
+}
+
+// ListenAndServe answers queries on the UDP address
+func (s *Server) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return s.Serve(conn)
+}
+
+// Serve answers queries received on conn until it is closed
+func (s *Server) Serve(conn net.PacketConn) error {
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if resp, err := s.answer(buf[:n]); err == nil {
+			conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+type question struct {
+	name  string
+	qtype uint16
+	class uint16
+
+	// Query bytes from the start of the header to the end of the question
+	raw []byte
+}
+
+// Parse the single question of a query
+func parseQuestion(query []byte) (question, error) {
+	if len(query) < 12 || binary.BigEndian.Uint16(query[4:]) != 1 {
+		return question{}, errors.New("expected one question")
+	}
+
+	var labels []string
+	p := 12
+	for {
+		if p >= len(query) {
+			return question{}, errors.New("truncated name")
+		}
+		n := int(query[p])
+		p++
+		if n == 0 {
+			break
+		}
+		if n > 63 || p+n > len(query) {
+			return question{}, errors.New("invalid label")
+		}
+		labels = append(labels, string(query[p:p+n]))
+		p += n
+	}
+	if p+4 > len(query) {
+		return question{}, errors.New("truncated question")
+	}
+	return question{
+		name:  strings.ToLower(strings.Join(labels, ".")) + ".",
+		qtype: binary.BigEndian.Uint16(query[p:]),
+		class: binary.BigEndian.Uint16(query[p+2:]),
+		raw:   query[:p+4],
+	}, nil
+}
+
+// Build the response to a query
+func (s *Server) answer(query []byte) ([]byte, error) {
+	q, err := parseQuestion(query)
+	if err != nil {
+		if len(query) < 12 {
+			return nil, err
+		}
+		return header(query, rcodeFormat, 0, 0, 0), nil
+	}
+
+	if q.class != classIN {
+		return s.response(q, rcodeNotImpl, nil, nil), nil
+	}
+	if q.name != s.zone && !strings.HasSuffix(q.name, "."+s.zone) {
+		return s.response(q, rcodeRefused, nil, nil), nil
+	}
+
+	targets := s.source.Targets()
+	var answers, additional [][]byte
+	found := false
+	switch {
+	case q.name == s.zone:
+		found = true
+		for _, t := range targets {
+			if rr := s.address(questionName, t, q.qtype); rr != nil {
+				answers = append(answers, rr)
+			}
+		}
+	case q.name == s.service:
+		found = true
+		if q.qtype == typeSRV {
+			for _, t := range targets {
+				answers = append(answers, s.srv(t))
+				if rr := s.address(s.hostName(t), t, addressType(t)); rr != nil {
+					additional = append(additional, rr)
+				}
+			}
+		}
+	default:
+		for _, t := range targets {
+			if q.name == strings.ToLower(t.Name)+"."+s.zone {
+				found = true
+				if rr := s.address(questionName, t, q.qtype); rr != nil {
+					answers = append(answers, rr)
+				}
+			}
+		}
+	}
+
+	if !found {
+		return s.response(q, rcodeNXDomain, nil, nil), nil
+	}
+	return s.response(q, rcodeOK, answers, additional), nil
+}
+
+// Pointer to the question name, which always starts after the header
+var questionName = []byte{0xc0, 12}
+
+// Encode a fully qualified name
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func (s *Server) hostName(t Target) []byte {
+	return encodeName(strings.ToLower(t.Name) + "." + s.zone)
+}
+
+func addressType(t Target) uint16 {
+	if t.Addr.Is4() {
+		return typeA
+	}
+	return typeAAAA
+}
+
+// Resource record header followed by the data
+func (s *Server) record(name []byte, rtype uint16, data []byte) []byte {
+	rr := append([]byte{}, name...)
+	rr = binary.BigEndian.AppendUint16(rr, rtype)
+	rr = binary.BigEndian.AppendUint16(rr, classIN)
+	rr = binary.BigEndian.AppendUint32(rr, s.ttl)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(data)))
+	return append(rr, data...)
+}
+
+// Address record of the target if it matches the query type
+func (s *Server) address(name []byte, t Target, qtype uint16) []byte {
+	if qtype != addressType(t) {
+		return nil
+	}
+	return s.record(name, qtype, t.Addr.AsSlice())
+}
+
+func (s *Server) srv(t Target) []byte {
+	data := binary.BigEndian.AppendUint16(nil, 0)
+	data = binary.BigEndian.AppendUint16(data, max(t.Weight, 1))
+	data = binary.BigEndian.AppendUint16(data, t.Port)
+	return s.record(questionName, typeSRV, append(data, s.hostName(t)...))
+}
+
+// Response header for the query with the given code and counts
+func header(query []byte, rcode int, questions, answers, additional int) []byte {
+	h := make([]byte, 12)
+	copy(h, query[:2])
+
+	// Response, authoritative, copying the opcode and recursion desired bits
+	h[2] = 0x80 | 0x04 | query[2]&0x79
+	h[3] = byte(rcode)
+	binary.BigEndian.PutUint16(h[4:], uint16(questions))
+	binary.BigEndian.PutUint16(h[6:], uint16(answers))
+	binary.BigEndian.PutUint16(h[10:], uint16(additional))
+	return h
+}
+
+// Assemble the response, dropping records that do not fit in a UDP packet
+func (s *Server) response(q question, rcode int, answers, additional [][]byte) []byte {
+	size := len(q.raw)
+	fit := func(records [][]byte) int {
+		n := 0
+		for _, rr := range records {
+			if size+len(rr) > maxUDPSize {
+				break
+			}
+			size += len(rr)
+			n++
+		}
+		return n
+	}
+	nAnswers := fit(answers)
+	truncated := nAnswers < len(answers)
+	nAdditional := 0
+	if !truncated {
+		nAdditional = fit(additional)
+	}
+
+	resp := header(q.raw, rcode, 1, nAnswers, nAdditional)
+	if truncated {
+		resp[2] |= 0x02
+	}
+	resp = append(resp, q.raw[12:]...)
+	for _, rr := range answers[:nAnswers] {
+		resp = append(resp, rr...)
+	}
+	for _, rr := range additional[:nAdditional] {
+		resp = append(resp, rr...)
+	}
+	return resp
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+type staticSource []Target
+
+func (s staticSource) Targets() []Target {
+	return s
+}
+
+// Start a server on a local UDP port and return a resolver using it
+func startServer(t *testing.T, s *Server) *net.Resolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go s.Serve(conn)
+
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+	}}
+}
+
+func TestServer(t *testing.T) {
+	source := staticSource{
+		{Name: "node0", Addr: netip.MustParseAddr("10.0.0.1"), Port: 8080},
+		{Name: "node1", Addr: netip.MustParseAddr("10.0.0.2"), Port: 8080, Weight: 5},
+		{Name: "node2", Addr: netip.MustParseAddr("fd00::3"), Port: 8080},
+	}
+	r := startServer(t, NewServer("lb.local", "_http._tcp", 30, source))
+	ctx := context.Background()
+
+	addrs, err := r.LookupHost(ctx, "lb.local")
+	if err != nil {
+		t.Fatalf("LookupHost() error = %v", err)
+	}
+	slices.Sort(addrs)
+	if want := []string{"10.0.0.1", "10.0.0.2", "fd00::3"}; !slices.Equal(addrs, want) {
+		t.Errorf("LookupHost() = %v, want %v", addrs, want)
+	}
+
+	_, srvs, err := r.LookupSRV(ctx, "http", "tcp", "lb.local")
+	if err != nil {
+		t.Fatalf("LookupSRV() error = %v", err)
+	}
+	if len(srvs) != 3 {
+		t.Fatalf("LookupSRV() returned %d records, want 3", len(srvs))
+	}
+	for _, srv := range srvs {
+		if srv.Port != 8080 || (srv.Target == "node1.lb.local." && srv.Weight != 5) {
+			t.Errorf("unexpected SRV record %+v", srv)
+		}
+	}
+
+	addrs, err = r.LookupHost(ctx, "node1.lb.local")
+	if err != nil || !slices.Equal(addrs, []string{"10.0.0.2"}) {
+		t.Errorf("LookupHost() = %v, %v, want [10.0.0.2]", addrs, err)
+	}
+
+	if _, err := r.LookupHost(ctx, "node9.lb.local"); err == nil {
+		t.Errorf("LookupHost() expected error for unknown node")
+	}
+}
+
+func TestAnswerMalformed(t *testing.T) {
+	s := NewServer("lb.local", "_http._tcp", 30, staticSource{})
+	if _, err := s.answer([]byte{1, 2}); err == nil {
+		t.Errorf("answer() expected error for short query")
+	}
+
+	// One question whose name runs past the end of the packet
+	query := []byte{0, 1, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0, 5, 'a'}
+	resp, err := s.answer(query)
+	if err != nil || resp[3]&0x0f != rcodeFormat {
+		t.Errorf("answer() = %v, %v, want format error", resp, err)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Publishing of the node set as DNS records
+
+package main
+
+import (
+	"dns"
+	"math"
+	"strconv"
+)
+
+// dnsSource publishes the snapshot as SRV and address records, with each
+// node named after its first bucket and weighted by its weight
+type dnsSource struct {
+	*nodeSnapshot
+	port uint16
+}
+
+func (s dnsSource) Targets() []dns.Target {
+	nodes, _ := s.get()
+	targets := make([]dns.Target, 0, len(nodes))
+	for _, n := range nodes {
+		targets = append(targets, dns.Target{Name: "node" + strconv.Itoa(n.bucket), Addr: n.addr,
+			Port: s.port, Weight: uint16(min(n.weight, math.MaxUint16))})
+	}
+	return targets
+}
+
+// Serve DNS for the zone on the UDP address in the background
+func serveDNS(snapshot *nodeSnapshot, addr, zone, service string, port uint16) {
+	go func() {
+		if err := dns.NewServer(zone, service, 5, dnsSource{snapshot, port}).ListenAndServe(addr); err != nil {
+			out.info("DNS server stopped:", err)
+		}
+	}()
+}
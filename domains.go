@@ -0,0 +1,172 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Spread of candidate nodes across failure domains
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+)
+
+// FailureDomain locates a node. A zone failing takes down its racks and a
+// rack failing takes down its hosts, so a host is only identified within
+// its rack and a rack within its zone.
+type FailureDomain struct {
+	Zone, Rack, Host string
+}
+
+// SpreadScore scores how well a node in domain d spreads from the domains
+// of the nodes already chosen for an object, higher is better
+type SpreadScore func(chosen []FailureDomain, d FailureDomain) float64
+
+// DefaultSpreadScore is the number of levels separating d from the closest
+// chosen domain: 3 for another zone, 2 for another rack, 1 for another
+// host and 0 for the same host
+func DefaultSpreadScore(chosen []FailureDomain, d FailureDomain) float64 {
+	score := 3.0
+	for _, c := range chosen {
+		switch {
+		case c.Zone != d.Zone:
+		case c.Rack != d.Rack:
+			score = min(score, 2)
+		case c.Host != d.Host:
+			score = min(score, 1)
+		default:
+			return 0
+		}
+	}
+	return score
+}
+
+type domains[T, O comparable] struct {
+	// Domain of a node, nil if domains are not configured
+	of func(node serverpool.Node[T, O]) FailureDomain
+
+	score SpreadScore
+}
+
+// Reorder candidates after the first so that each one spreads the most
+// from those before it, keeping the given order among equal scores
+func (d *domains[T, O]) spread(candidates []serverpool.Node[T, O], n int) {
+	if d.of == nil || len(candidates) < 2 {
+		return
+	}
+	score := d.score
+	if score == nil {
+		score = DefaultSpreadScore
+	}
+	of := make([]FailureDomain, len(candidates))
+	for i, node := range candidates {
+		of[i] = d.of(node)
+	}
+	for i := 1; i < min(n, len(candidates)); i++ {
+		best, bestScore := i, score(of[:i], of[i])
+		for j := i + 1; j < len(candidates); j++ {
+			if s := score(of[:i], of[j]); s > bestScore {
+				best, bestScore = j, s
+			}
+		}
+		// Shift rather than swap to keep the order of the rest
+		node, domain := candidates[best], of[best]
+		copy(candidates[i+1:best+1], candidates[i:best])
+		copy(of[i+1:best+1], of[i:best])
+		candidates[i], of[i] = node, domain
+	}
+}
+
+// SpreadStats reports how the candidates of the objects spread across
+// failure domains
+type SpreadStats struct {
+	// Candidates considered per object
+	Replicas int `json:"replicas"`
+
+	Objects int `json:"objects"`
+
+	// Mean over the objects of the mean spread score of each candidate
+	// from those before it, 0 with a single replica
+	MeanScore float64 `json:"meanScore"`
+
+	// Mean number of distinct zones, racks and hosts of the candidates of
+	// an object
+	Zones float64 `json:"zones"`
+	Racks float64 `json:"racks"`
+	Hosts float64 `json:"hosts"`
+
+	// Objects with several candidates that all share a zone, rack or
+	// host, so one failure takes down every replica
+	SameZone int `json:"sameZone"`
+	SameRack int `json:"sameRack"`
+	SameHost int `json:"sameHost"`
+
+	// Objects per zone of the node they are placed on, the spread of
+	// objects without replicas
+	ObjectsPerZone map[string]int `json:"objectsPerZone"`
+}
+
+// SpreadStats scores the spread of the first replicas candidates of every
+// object, as Candidates orders them, across the failure domains set by
+// WithFailureDomains
+func (lb *loadBalancer[T, O]) SpreadStats(replicas int) (SpreadStats, error) {
+	if lb.domains.of == nil {
+		return SpreadStats{}, errors.New("failure domains are not configured")
+	}
+	if replicas < 1 {
+		return SpreadStats{}, errors.New("replicas must be positive")
+	}
+	score := lb.domains.score
+	if score == nil {
+		score = DefaultSpreadScore
+	}
+
+	stats := SpreadStats{Replicas: replicas, ObjectsPerZone: make(map[string]int)}
+	for obj := range lb.objects.all() {
+		candidates, err := lb.Candidates(obj.Name(), replicas)
+		if err != nil {
+			return SpreadStats{}, err
+		}
+		placed, err := lb.placement(obj)
+		if err != nil {
+			return SpreadStats{}, err
+		}
+		of := make([]FailureDomain, len(candidates))
+		zones, racks, hosts := make(map[string]bool), make(map[[2]string]bool), make(map[FailureDomain]bool)
+		for i, node := range candidates {
+			of[i] = lb.domains.of(node)
+			zones[of[i].Zone] = true
+			racks[[2]string{of[i].Zone, of[i].Rack}] = true
+			hosts[of[i]] = true
+		}
+		if len(of) > 1 {
+			var total float64
+			for i := 1; i < len(of); i++ {
+				total += score(of[:i], of[i])
+			}
+			stats.MeanScore += total / float64(len(of)-1)
+		}
+		stats.Objects++
+		stats.Zones += float64(len(zones))
+		stats.Racks += float64(len(racks))
+		stats.Hosts += float64(len(hosts))
+		if len(of) > 1 && len(zones) == 1 {
+			stats.SameZone++
+		}
+		if len(of) > 1 && len(racks) == 1 {
+			stats.SameRack++
+		}
+		if len(of) > 1 && len(hosts) == 1 {
+			stats.SameHost++
+		}
+		stats.ObjectsPerZone[lb.domains.of(placed).Zone]++
+	}
+	if stats.Objects > 0 {
+		n := float64(stats.Objects)
+		stats.MeanScore /= n
+		stats.Zones /= n
+		stats.Racks /= n
+		stats.Hosts /= n
+	}
+	return stats, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestFailureDomains(t *testing.T) {
+	// Two zones of three nodes, the first two of each zone share a rack
+	domain := func(node serverpool.Node[string, string]) FailureDomain {
+		var i int
+		fmt.Sscanf(node.Name(), "node%d", &i)
+		return FailureDomain{Zone: fmt.Sprint("zone", i/3), Rack: fmt.Sprint("rack", i/3, (i%3)/2),
+			Host: node.Name()}
+	}
+	newLB := func(score SpreadScore) LoadBalancer[string, string] {
+		lb := NewLoadBalancerWithOptions(WithFailureDomains(domain, score))
+		var nodes []serverpool.Node[string, string]
+		for i := 0; i < 6; i++ {
+			nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i)})
+		}
+		if _, err := lb.AddNodes(nodes); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return lb
+	}
+
+	lb := newLB(nil)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		c, err := lb.Candidates(key, 3)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		first, _ := lb.GetNode(key)
+		if c[0] != first {
+			t.Fatalf("expected %v first, got %v", first.Name(), c[0].Name())
+		}
+		d := []FailureDomain{domain(c[0]), domain(c[1]), domain(c[2])}
+		if d[0].Zone == d[1].Zone {
+			t.Fatalf("expected the second candidate in another zone, got %v", d)
+		}
+		if d[2].Rack == d[0].Rack || d[2].Rack == d[1].Rack {
+			t.Fatalf("expected the third candidate in another rack, got %v", d)
+		}
+		if all, _ := lb.Candidates(key, 0); len(all) != 6 {
+			t.Fatalf("expected 6 candidates, got %d", len(all))
+		}
+	}
+
+	var objects []*serverpool.Object[string, string]
+	for i := 0; i < 100; i++ {
+		objects = append(objects, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objects); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	stats, err := lb.(StatsReporter[string, string]).SpreadStats(2)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stats.Objects != 100 || stats.SameZone != 0 || stats.Zones != 2 || stats.MeanScore != 3 {
+		t.Fatalf("expected every object spread across zones, got %+v", stats)
+	}
+	if stats.ObjectsPerZone["zone0"]+stats.ObjectsPerZone["zone1"] != 100 {
+		t.Fatalf("expected 100 objects across the zones, got %v", stats.ObjectsPerZone)
+	}
+
+	// A score preferring the same zone keeps replicas local
+	local := newLB(func(chosen []FailureDomain, d FailureDomain) float64 {
+		if d.Zone == chosen[0].Zone {
+			return 1
+		}
+		return 0
+	})
+	for i := 0; i < 20; i++ {
+		c, _ := local.Candidates(fmt.Sprintf("key%d", i), 3)
+		if domain(c[1]).Zone != domain(c[0]).Zone || domain(c[2]).Zone != domain(c[0]).Zone {
+			t.Fatalf("expected candidates in one zone, got %v %v %v", c[0].Name(), c[1].Name(), c[2].Name())
+		}
+	}
+
+	if _, err := NewLoadBalancer[string, string]().(StatsReporter[string, string]).SpreadStats(2); err == nil {
+		t.Fatal("expected an error without failure domains")
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Gradual draining of nodes
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"serverpool"
+	"sync"
+	"time"
+)
+
+// DrainProgress reports how far the draining of a node has got
+type DrainProgress struct {
+	// Objects on the node when the drain started
+	Total int
+
+	// Objects still on the node
+	Remaining int
+
+	// Objects moved off the node so far
+	Moved int
+
+	// Objects moved off the node per call to Rebalance
+	Batch int
+
+	// When the drain started
+	Started time.Time
+
+	// Objects moved per second since the drain started
+	Rate float64
+
+	// Estimated time until the node is empty, 0 until an object has moved
+	ETA time.Duration
+}
+
+// Node being drained
+type drain[T, O comparable] struct {
+	node    serverpool.Node[T, O]
+	batch   int
+	total   int
+	moved   int
+	started time.Time
+}
+
+// Count the objects still on the node
+func (d *drain[T, O]) remaining() int {
+	n := 0
+	for range d.node.Objects() {
+		n++
+	}
+	return n
+}
+
+// DrainNode stops mapping keys to node but leaves its objects on it until
+// Rebalance moves them off, batch objects per call within the movement
+// budget, see PaceDrain. The node is gone once it is empty, when the
+// callback of WithDrainCallback is called. Each batch is published as a
+// ChangeDrainNode with the objects moved.
+func (lb *loadBalancer[T, O]) DrainNode(node serverpool.Node[T, O], batch int) error {
+	if lb.dryRun {
+		if _, ok := lb.lookupNode(node); !ok {
+			return fmt.Errorf("%v not found", node)
+		}
+		return nil
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	var err error
+	lb.profileRebalance("DrainNode", func() { err = lb.drainNode(node, batch) })
+	if err != nil {
+		return err
+	}
+	lb.publish(ChangeDrainNode, []serverpool.Node[T, O]{node}, nil)
+	return nil
+}
+
+func (lb *loadBalancer[T, O]) drainNode(node serverpool.Node[T, O], batch int) error {
+	if batch <= 0 {
+		return errors.New("drain batch must be positive")
+	}
+	if lb.sp.Len() == 1 {
+		return errors.New("cannot drain the last node")
+	}
+
+	_, removed, err := lb.removeNodeBuckets(node)
+	if err != nil {
+		return err
+	}
+	lb.tierRemove(removed)
+	lb.prefetcher.forgetNode(removed)
+	lb.endDials(removed.Name())
+
+	d := &drain[T, O]{node: removed, batch: batch, started: lb.churn.clock()}
+	d.total = d.remaining()
+	if lb.drains == nil {
+		lb.drains = make(map[T]*drain[T, O])
+	}
+	lb.drains[removed.Name()] = d
+	lb.finishDrain(d)
+
+	lb.flaps.record(removed.Name(), lb.churn.clock())
+	lb.churn.topologyChanged()
+	return nil
+}
+
+// Move the next batch of objects off a draining node
+func (lb *loadBalancer[T, O]) drainStep(d *drain[T, O], errs *ReassignmentError[O]) []*serverpool.Object[T, O] {
+	moves, unmapped := lb.planMoves(d.node, func(yield func(*serverpool.Object[T, O]) bool) {
+		n := 0
+		for obj := range d.node.Objects() {
+			if n == d.batch || !yield(obj) {
+				return
+			}
+			n++
+		}
+	})
+	for id, err := range unmapped {
+		errs.add(id, err)
+	}
+
+	// Objects over the budget or waiting for their prefetch stay on the node
+	// until the next call
+	allowed, _ := lb.churn.admit(moves)
+	allowed, _ = lb.prefetch(allowed)
+	var moved []*serverpool.Object[T, O]
+	for _, m := range allowed {
+		if err := lb.assignObject(m.Object); err != nil {
+			errs.add(m.Object.Id, err)
+			continue
+		}
+		moved = append(moved, m.Object)
+	}
+	lb.churn.record(len(moved))
+	d.moved += len(moved)
+	return moved
+}
+
+// Forget a drain once its node is empty
+func (lb *loadBalancer[T, O]) finishDrain(d *drain[T, O]) {
+	if d.remaining() == 0 {
+		delete(lb.drains, d.node.Name())
+		lb.notifyRemoved(d.node)
+		if lb.drained != nil {
+			lb.drained(d.node)
+		}
+	}
+}
+
+// PaceDrain calls Rebalance every interval while node is draining, so its
+// objects move off it a batch at a time, and then stepped, if not nil. It
+// holds lock, if not nil, around each step. It returns once the node is
+// empty and gone, with an error if the drain was aborted, or with the
+// error of ctx once it is done, leaving the node draining. Fails with
+// ErrUnsupported if lb is not a Drainer.
+func PaceDrain[T, O comparable](ctx context.Context, lb LoadBalancer[T, O], lock sync.Locker, node serverpool.Node[T, O], interval time.Duration, stepped func()) error {
+	drainer, err := capability[Drainer[T, O]](lb)
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		lock = &sync.Mutex{}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		lock.Lock()
+		_, draining := drainer.DrainStats()[node.Name()]
+		if draining {
+			lb.Rebalance()
+			if stepped != nil {
+				stepped()
+			}
+			_, draining = drainer.DrainStats()[node.Name()]
+		}
+		present := false
+		if !draining {
+			for n := range lb.Nodes() {
+				if n.Name() == node.Name() {
+					present = true
+					break
+				}
+			}
+		}
+		lock.Unlock()
+
+		switch {
+		case present:
+			return fmt.Errorf("drain of %v aborted", node)
+		case !draining:
+			return nil
+		}
+	}
+}
+
+// Apply a drain published by a primary: start it or move its objects
+func (lb *loadBalancer[T, O]) applyDrain(node serverpool.Node[T, O], objects []*serverpool.Object[T, O]) {
+	d, ok := lb.drains[node.Name()]
+	if !ok {
+		lb.drainNode(node, 1)
+		return
+	}
+	for _, obj := range objects {
+		lb.assignObject(obj)
+	}
+	d.moved += len(objects)
+	lb.finishDrain(d)
+}
+
+// SetDrainBatch changes the number of objects moved off a draining node per
+// call to Rebalance
+func (lb *loadBalancer[T, O]) SetDrainBatch(node serverpool.Node[T, O], batch int) error {
+	if batch <= 0 {
+		return errors.New("drain batch must be positive")
+	}
+	d, ok := lb.drains[node.Name()]
+	if !ok {
+		return fmt.Errorf("%v is not draining", node)
+	}
+	if lb.dryRun {
+		return nil
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	d.batch = batch
+	return nil
+}
+
+// AbortDrain adds a draining node back with the objects it still has
+func (lb *loadBalancer[T, O]) AbortDrain(node serverpool.Node[T, O]) error {
+	d, ok := lb.drains[node.Name()]
+	if !ok {
+		return fmt.Errorf("%v is not draining", node)
+	}
+	if lb.dryRun {
+		return nil
+	}
+	_, err := lb.AddNodes([]serverpool.Node[T, O]{d.node})
+	return err
+}
+
+// Report the progress of every node being drained
+func (lb *loadBalancer[T, O]) DrainStats() map[T]DrainProgress {
+	now := lb.churn.clock()
+	stats := make(map[T]DrainProgress, len(lb.drains))
+	for name, d := range lb.drains {
+		p := DrainProgress{Total: d.total, Remaining: d.remaining(), Moved: d.moved,
+			Batch: d.batch, Started: d.started}
+		if elapsed := now.Sub(d.started); elapsed > 0 && d.moved > 0 {
+			p.Rate = float64(d.moved) / elapsed.Seconds()
+			p.ETA = time.Duration(float64(p.Remaining) / p.Rate * float64(time.Second))
+		}
+		stats[name] = p
+	}
+	return stats
+}
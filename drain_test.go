@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestDrainNode(t *testing.T) {
+	lb := NewLoadBalancer[string, string]().(*loadBalancer[string, string])
+	now := time.Unix(0, 0)
+	lb.churn.now = func() time.Time { return now }
+	var drained int
+	lb.Feed(0, func(c Change[string, string]) {
+		if c.Op == ChangeDrainNode {
+			drained += len(c.Objects)
+		}
+	})
+
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	nodes := []serverpool.Node[string, string]{newNode("node1"), newNode("node2"), newNode("node3")}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 30; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	node2 := nodes[1].(*mockNode)
+	total := len(node2.objects)
+	if total < 3 {
+		t.Fatalf("expected node2 to have objects, got %d", total)
+	}
+	if err := lb.DrainNode(node2, 2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if node, _ := lb.GetNode(obj.Name()); node == node2 {
+			t.Fatalf("expected no keys to map to a draining node")
+		}
+	}
+	if p := lb.DrainStats()["node2"]; p.Total != total || p.Remaining != total || p.ETA != 0 {
+		t.Fatalf("expected %d objects remaining and no ETA, got %+v", total, p)
+	}
+
+	now = now.Add(time.Second)
+	if moved, err := lb.Rebalance(); err != nil || moved != 2 {
+		t.Fatalf("expected 2 objects moved, got %d, %v", moved, err)
+	}
+	p := lb.DrainStats()["node2"]
+	wantETA := time.Duration(total-2) * time.Second / 2
+	if p.Moved != 2 || p.Remaining != total-2 || p.Rate != 2 || p.ETA != wantETA {
+		t.Fatalf("expected 2 moved at 2/s with ETA %v, got %+v", wantETA, p)
+	}
+
+	// Speed up the drain to finish it
+	if err := lb.SetDrainBatch(node2, total); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.Rebalance(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(node2.objects) != 0 || len(lb.DrainStats()) != 0 || drained != total {
+		t.Fatalf("expected node2 drained of %d objects, published %d", total, drained)
+	}
+
+	// Aborting adds the node back with its objects
+	node3 := nodes[2].(*mockNode)
+	onNode3 := len(node3.objects)
+	if err := lb.DrainNode(node3, 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AbortDrain(node3); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if lb.NodeCount() != 2 || len(lb.DrainStats()) != 0 || len(node3.objects) != onNode3 {
+		t.Fatalf("expected node3 back with its %d objects", onNode3)
+	}
+}
+
+func TestPaceDrain(t *testing.T) {
+	drained := make(chan serverpool.Node[string, string], 1)
+	lb := NewConcurrentLoadBalancer(WithDrainCallback(func(node serverpool.Node[string, string]) {
+		drained <- node
+	}))
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	node1, node2 := newNode("node1"), newNode("node2")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 10; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i), Preferred: []string{"node2"}})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if err := lb.(Drainer[string, string]).DrainNode(node2, 3); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	steps := 0
+	if err := PaceDrain(context.Background(), lb, nil, node2, time.Millisecond, func() { steps++ }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if steps != 4 || len(node1.objects) != 10 || lb.NodeCount() != 1 {
+		t.Fatalf("expected 10 objects moved to node1 in 4 steps, got %d objects in %d steps", len(node1.objects), steps)
+	}
+	select {
+	case node := <-drained:
+		if node != node2 {
+			t.Fatalf("expected node2 drained, got %v", node)
+		}
+	default:
+		t.Fatalf("expected the drain callback to be called")
+	}
+
+	// An aborted drain stops the pacing
+	if err := lb.(Drainer[string, string]).DrainNode(node1, 1); err == nil {
+		t.Fatalf("expected the last node not to be drained")
+	}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode("node3")}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.(Drainer[string, string]).DrainNode(node1, 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.(Drainer[string, string]).AbortDrain(node1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := PaceDrain(context.Background(), lb, nil, node1, time.Millisecond, nil); err == nil {
+		t.Fatalf("expected an error for an aborted drain")
+	}
+}
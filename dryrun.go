@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Dry runs that report the effects of mutations without applying them
+
+package main
+
+import (
+	"consistenthash"
+	"errors"
+	"fmt"
+	"serverpool"
+)
+
+// Plan adding nodes: the buckets they would get and, with cooperative
+// rebalancing, the objects that would move to them
+func (lb *loadBalancer[T, O]) planAddNodes(nodes []serverpool.Node[T, O]) (NodesResult[T, O], error) {
+	result := newNodesResult(nodes)
+	if len(nodes) == 0 {
+		return result, errors.New("no nodes to add")
+	}
+
+	var added []serverpool.Node[T, O]
+	defer func() {
+		for i := len(added) - 1; i >= 0; i-- {
+			lb.removeNodeBuckets(added[i])
+			lb.tierRemove(added[i])
+		}
+	}()
+
+	for i, node := range nodes {
+		nr := &result.Nodes[i]
+		if lb.flaps.isQuarantined(node.Name(), lb.churn.clock()) {
+			err := fmt.Errorf("%w: %v", ErrNodeQuarantined, node)
+			nr.Status, nr.Err = StatusFailed, err
+			return result, err
+		}
+
+		bucket, err := lb.addNodeBuckets(node)
+		if err != nil {
+			nr.Status, nr.Err = StatusFailed, err
+			return result, err
+		}
+		nr.Status, nr.Bucket = StatusOK, bucket
+		lb.tierAdd(node)
+		added = append(added, node)
+	}
+
+	// Without cooperative rebalancing objects stay where they are
+	if lb.cooperative != nil {
+		result.Moves, _ = lb.planRebalance()
+	}
+	return result, nil
+}
+
+// Plan removing nodes: the buckets they would release and where their
+// objects, and with cooperative rebalancing any other moved objects, would go
+func (lb *loadBalancer[T, O]) planRemoveNodes(nodes []serverpool.Node[T, O]) (NodesResult[T, O], error) {
+	result := newNodesResult(nodes)
+	if len(nodes) == 0 {
+		return result, errors.New("no nodes to remove")
+	}
+
+	if len(nodes) > lb.sp.Len() {
+		return result, fmt.Errorf("cannot remove more nodes than the size of the working set %d", lb.sp.Len())
+	}
+
+	if lb.removalPolicy == FailOnRemoval {
+		if err := lb.checkEmpty(nodes); err != nil {
+			return result, err
+		}
+	}
+
+	// Memento restores the most recently removed bucket first, so undoing
+	// in reverse order gives every node back its bucket. A rebuild would
+	// not be undone, so it is held off while planning.
+	if lb.removedLimit > 0 {
+		consistenthash.SetRemovedLimit(lb.ch, 0)
+		defer consistenthash.SetRemovedLimit(lb.ch, lb.removedLimit)
+	}
+	var removed []serverpool.Node[T, O]
+	defer func() {
+		for i := len(removed) - 1; i >= 0; i-- {
+			lb.addNodeBuckets(removed[i])
+			lb.tierAdd(removed[i])
+		}
+	}()
+
+	var err error
+	for i, node := range nodes {
+		nr := &result.Nodes[i]
+		bucket, removedNode, e := lb.removeNodeBuckets(node)
+		if e != nil {
+			nr.Status, nr.Err, err = StatusFailed, e, e
+			break
+		}
+		lb.tierRemove(removedNode)
+		nr.Status, nr.Bucket = StatusOK, bucket
+		removed = append(removed, removedNode)
+	}
+
+	switch {
+	case lb.removalPolicy == OrphanOnRemoval:
+		for i, node := range removed {
+			for range node.Objects() {
+				result.Nodes[i].Orphaned++
+			}
+		}
+	case lb.cooperative != nil:
+		moves, orphaned := lb.planRebalance()
+		result.Moves = moves
+		for i, node := range removed {
+			for _, m := range moves {
+				if m.From == node {
+					result.Nodes[i].Reassigned++
+				}
+			}
+			result.Nodes[i].Orphaned = orphaned[node]
+		}
+	default:
+		for i, node := range removed {
+			moves, unmapped := lb.planMoves(node, node.Objects())
+			result.Moves = append(result.Moves, moves...)
+			result.Nodes[i].Reassigned, result.Nodes[i].Orphaned = len(moves), len(unmapped)
+		}
+	}
+	return result, err
+}
+
+// Plan the moves of every assigned object whose node changed and count the
+// objects that could not be placed by the node they are on
+func (lb *loadBalancer[T, O]) planRebalance() ([]Move[T, O], map[serverpool.Node[T, O]]int) {
+	var moves []Move[T, O]
+	orphaned := make(map[serverpool.Node[T, O]]int)
+	for obj := range lb.objects.all() {
+		from := obj.Node()
+		if from == nil || *from == nil {
+			continue
+		}
+		to, err := lb.placement(obj)
+		if err != nil {
+			orphaned[*from]++
+			continue
+		}
+		if to != *from {
+			moves = append(moves, Move[T, O]{Object: obj, From: *from, To: to})
+		}
+	}
+	return moves, orphaned
+}
+
+// Check that an object could be assigned
+func (lb *loadBalancer[T, O]) planAssignObject(obj *serverpool.Object[T, O]) error {
+	o, ok := lb.objects.get(obj.Id)
+	if !ok {
+		return fmt.Errorf("%v not found", obj)
+	}
+	_, err := lb.placement(o)
+	return err
+}
+
+// Check that an object could be moved to the given node
+func (lb *loadBalancer[T, O]) planTransferObject(obj *serverpool.Object[T, O], to serverpool.Node[T, O]) error {
+	o, ok := lb.objects.get(obj.Id)
+	if !ok {
+		return fmt.Errorf("%v not found", obj)
+	}
+	if _, ok := lb.migrating[o.Id]; ok {
+		return fmt.Errorf("%w: %v", ErrTransferInProgress, o)
+	}
+	if _, ok := lb.lookupNode(to); !ok {
+		return fmt.Errorf("%v not found", to)
+	}
+	return nil
+}
+
+// Count the deferred objects that have a node to move to, ignoring the
+// movement budget
+func (lb *loadBalancer[T, O]) planDeferred() int {
+	n := 0
+	for _, obj := range lb.churn.deferred {
+		if _, err := lb.placement(obj); err == nil {
+			n++
+		}
+	}
+	return n
+}
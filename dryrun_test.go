@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	primary := NewLoadBalancer[string, string]()
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	nodes := []serverpool.Node[string, string]{newNode("node1"), newNode("node2"), newNode("node3")}
+	if _, err := primary.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 50; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := primary.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := primary.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	lb := newMirror(t, primary, WithDryRun[string, string]())
+	mapping := func() map[string]serverpool.Node[string, string] {
+		m := make(map[string]serverpool.Node[string, string])
+		for _, obj := range objs {
+			m[obj.Id], _ = lb.GetNode(obj.Name())
+		}
+		return m
+	}
+	before, version := mapping(), lb.Version()
+	unchanged := func() {
+		t.Helper()
+		if lb.NodeCount() != 3 || lb.Version() != version {
+			t.Fatalf("expected dry run to leave the load balancer unchanged")
+		}
+		for id, node := range mapping() {
+			if before[id] != node {
+				t.Fatalf("expected %s to stay on %v, got %v", id, before[id], node)
+			}
+		}
+	}
+
+	onNode2 := len(nodes[1].(*mockNode).objects)
+	result, err := lb.RemoveNodes(nodes[1:2])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result.Nodes[0].Bucket != 1 || result.Reassigned() != onNode2 || len(result.Moves) != onNode2 {
+		t.Fatalf("expected bucket 1 and %d moves, got %+v", onNode2, result)
+	}
+	for _, m := range result.Moves {
+		if m.From.Name() != nodes[1].Name() || m.To.Name() == nodes[1].Name() {
+			t.Fatalf("expected %v to move off node2", m)
+		}
+	}
+	if len(nodes[1].(*mockNode).objects) != onNode2 {
+		t.Fatalf("expected node2 to keep its objects")
+	}
+	unchanged()
+
+	for range 2 {
+		result, err = lb.AddNodes([]serverpool.Node[string, string]{newNode("node4")})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if result.Nodes[0].Bucket != 3 {
+			t.Fatalf("expected bucket 3, got %d", result.Nodes[0].Bucket)
+		}
+		unchanged()
+	}
+
+	if err := lb.AssignObject(&serverpool.Object[string, string]{Id: "missing"}); err == nil {
+		t.Fatalf("expected an error for an unknown object")
+	}
+	if err := lb.AssignObject(objs[0]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	unchanged()
+}
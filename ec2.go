@@ -0,0 +1,185 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Discovery of AWS EC2 instances by tag
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EC2Discoverer lists the running EC2 instances of a region that carry all
+// of the given tags, through the EC2 query API signed with Signature V4
+type EC2Discoverer struct {
+	Region string
+
+	// Tags the instances must have, an empty value matches any value
+	Tags map[string]string
+
+	// Credentials, read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN by NewEC2Discoverer
+	AccessKey, SecretKey, SessionToken string
+
+	// API endpoint, https://ec2.<region>.amazonaws.com if empty
+	Endpoint string
+
+	// HTTP client, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// Create an EC2 discoverer with credentials from the environment
+func NewEC2Discoverer(region string, tags map[string]string) *EC2Discoverer {
+	return &EC2Discoverer{Region: region, Tags: tags, AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+}
+
+// Parts of the DescribeInstances response the discoverer uses
+type ec2Response struct {
+	Reservations []struct {
+		Instances []struct {
+			ID   string `xml:"instanceId"`
+			IP   string `xml:"privateIpAddress"`
+			Tags []struct {
+				Key   string `xml:"key"`
+				Value string `xml:"value"`
+			} `xml:"tagSet>item"`
+		} `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+func (d *EC2Discoverer) Instances(ctx context.Context) ([]Instance, error) {
+	if d.AccessKey == "" || d.SecretKey == "" {
+		return nil, errors.New("no AWS credentials")
+	}
+
+	query := url.Values{"Action": {"DescribeInstances"}, "Version": {"2016-11-15"},
+		"Filter.1.Name": {"instance-state-name"}, "Filter.1.Value.1": {"running"}}
+	keys := slices.Sorted(func(yield func(string) bool) {
+		for key := range d.Tags {
+			if !yield(key) {
+				return
+			}
+		}
+	})
+	for i, key := range keys {
+		filter := "Filter." + strconv.Itoa(i+2)
+		if value := d.Tags[key]; value != "" {
+			query.Set(filter+".Name", "tag:"+key)
+			query.Set(filter+".Value.1", value)
+		} else {
+			query.Set(filter+".Name", "tag-key")
+			query.Set(filter+".Value.1", key)
+		}
+	}
+
+	var instances []Instance
+	for {
+		var resp ec2Response
+		if err := d.call(ctx, query, &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Reservations {
+			for _, inst := range r.Instances {
+				addr, err := netip.ParseAddr(inst.IP)
+				if err != nil {
+					continue
+				}
+				tags := make(map[string]string, len(inst.Tags))
+				for _, tag := range inst.Tags {
+					tags[tag.Key] = tag.Value
+				}
+				instances = append(instances, Instance{ID: inst.ID, Addr: addr, Tags: tags})
+			}
+		}
+		if resp.NextToken == "" {
+			return instances, nil
+		}
+		query.Set("NextToken", resp.NextToken)
+	}
+}
+
+// Send a signed query and decode the XML response
+func (d *EC2Discoverer) call(ctx context.Context, query url.Values, v any) error {
+	endpoint := d.Endpoint
+	if endpoint == "" {
+		endpoint = "https://ec2." + d.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	u.Path = "/"
+	u.RawQuery = awsQuery(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	signV4(req, time.Now().UTC(), d.Region, "ec2", d.AccessKey, d.SecretKey, d.SessionToken)
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("DescribeInstances: %s: %s", resp.Status, body)
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// Sign a request without a body with AWS Signature V4
+func signV4(req *http.Request, now time.Time, region, service, accessKey, secretKey, token string) {
+	date, stamp := now.Format("20060102"), now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+	headers := "host:" + req.URL.Host + "\nx-amz-date:" + stamp + "\n"
+	signed := "host;x-amz-date"
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		headers += "x-amz-security-token:" + token + "\n"
+		signed += ";x-amz-security-token"
+	}
+
+	empty := sha256.Sum256(nil)
+	canonical := strings.Join([]string{req.Method, "/", req.URL.RawQuery, headers, signed,
+		hex.EncodeToString(empty[:])}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(key))
+}
+
+// Encode a query sorted by key with spaces as %20, as Signature V4 expects
+func awsQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
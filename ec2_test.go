@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	// Requests of the AWS Signature Version 4 test suite the EC2 API sends,
+	// with the signatures the suite publishes
+	tests := []struct {
+		name, method, url, signature string
+	}{
+		{"get-vanilla", http.MethodGet, "https://example.amazonaws.com/",
+			"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-empty-query-key", http.MethodGet, "https://example.amazonaws.com/?Param1=value1",
+			"a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{"get-vanilla-query-order-key-case", http.MethodGet, "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			"b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"post-vanilla", http.MethodPost, "https://example.amazonaws.com/",
+			"5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		req.URL.RawQuery = awsQuery(req.URL.Query())
+		signV4(req, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC), "us-east-1", "service",
+			"AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "")
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+			"SignedHeaders=host;x-amz-date, Signature=" + tt.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Fatalf("%s: expected %s, got %s", tt.name, want, got)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Fatalf("%s: expected the date of the request, got %s", tt.name, got)
+		}
+	}
+}
+
+func TestEC2Discoverer(t *testing.T) {
+	pages := []string{`<DescribeInstancesResponse><reservationSet><item><instancesSet>
+		<item><instanceId>i-1</instanceId><privateIpAddress>10.0.0.1</privateIpAddress>
+		<tagSet><item><key>role</key><value>web</value></item></tagSet></item>
+		</instancesSet></item></reservationSet><nextToken>page2</nextToken></DescribeInstancesResponse>`,
+		`<DescribeInstancesResponse><reservationSet><item><instancesSet>
+		<item><instanceId>i-2</instanceId><privateIpAddress>10.0.0.2</privateIpAddress></item>
+		</instancesSet></item></reservationSet></DescribeInstancesResponse>`}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("Action") != "DescribeInstances" || q.Get("Filter.2.Name") != "tag:role" ||
+			q.Get("Filter.2.Value.1") != "web" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			t.Errorf("expected a signed request, got %q", r.Header.Get("Authorization"))
+		}
+		page := 0
+		if q.Get("NextToken") == "page2" {
+			page = 1
+		}
+		fmt.Fprint(w, pages[page])
+	}))
+	defer server.Close()
+
+	d := &EC2Discoverer{Region: "us-east-1", Tags: map[string]string{"role": "web"},
+		AccessKey: "key", SecretKey: "secret", Endpoint: server.URL}
+	instances, err := d.Instances(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(instances) != 2 || instances[0].ID != "i-1" || instances[0].Tags["role"] != "web" ||
+		instances[1].Addr != netip.MustParseAddr("10.0.0.2") {
+		t.Fatalf("unexpected instances %+v", instances)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Publishing of the node set to Envoy over EDS
+
+package main
+
+import (
+	"net/http"
+	"xds"
+)
+
+// edsSource publishes the snapshot to Envoy, each node as one endpoint with
+// the weight of the node. Nodes taken out of rotation are not in the
+// snapshot, so every endpoint is published healthy.
+type edsSource struct {
+	*nodeSnapshot
+
+	// Port of the endpoints, nodes are only known by address
+	port uint32
+}
+
+func (s edsSource) Endpoints() ([]xds.Endpoint, uint64) {
+	nodes, version := s.get()
+	endpoints := make([]xds.Endpoint, 0, len(nodes))
+	for _, n := range nodes {
+		endpoints = append(endpoints, xds.Endpoint{Address: n.addr.String(), Port: s.port,
+			Weight: uint32(n.weight), Health: xds.Healthy})
+	}
+	return endpoints, version
+}
+
+// Serve EDS for the cluster on addr in the background
+func serveEDS(snapshot *nodeSnapshot, addr, cluster string, port uint32) {
+	go func() {
+		if err := http.ListenAndServe(addr, xds.NewServer(cluster, edsSource{snapshot, port})); err != nil {
+			out.info("EDS server stopped:", err)
+		}
+	}()
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Eviction of objects from nodes left beyond their capacity by removals
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+	"slices"
+	"time"
+)
+
+// ErrObjectEvicted is reported for objects evicted from a full node
+var ErrObjectEvicted = errors.New("object evicted")
+
+// EvictionOrder decides which objects of a full node are evicted first
+type EvictionOrder int
+
+const (
+	// Evict the objects of lowest priority first, the least recently
+	// assigned first among objects of the same priority
+	EvictLowestPriority EvictionOrder = iota
+
+	// Evict the least recently assigned objects first
+	EvictLeastRecentlyAssigned
+)
+
+// EvictionPolicy limits the objects each node holds. When removing nodes
+// leaves a node with more objects than its capacity, the objects beyond
+// it are evicted in the policy's order and left unassigned instead of
+// over-packing the node.
+type EvictionPolicy[T, O comparable] struct {
+	// Number of objects a node holds at most
+	Capacity func(node serverpool.Node[T, O]) int
+
+	Order EvictionOrder
+
+	// Priority of an object, higher priorities are evicted last. All
+	// objects have the same priority if nil.
+	Priority func(id O) int
+}
+
+// Eviction state of a load balancer
+type eviction[T, O comparable] struct {
+	// Eviction is off if nil
+	policy *EvictionPolicy[T, O]
+
+	// When each object was assigned to a node, kept across moves
+	assigned map[O]time.Time
+
+	// Saves the times the transaction being committed changes, if any
+	undo entryUndo[O, time.Time]
+}
+
+// Record the assignment of an unassigned object, for evicting the least
+// recently assigned objects first. Moves keep the time of the assignment,
+// so objects moving off removed nodes do not push out the objects already
+// on their new nodes.
+func (e *eviction[T, O]) touch(id O, now time.Time) {
+	if e.policy != nil {
+		e.undo.save(e.assigned, id)
+		e.assigned[id] = now
+	}
+}
+
+func (e *eviction[T, O]) forget(id O) {
+	e.undo.save(e.assigned, id)
+	delete(e.assigned, id)
+}
+
+// Compare objects in the order they are evicted
+func (e *eviction[T, O]) compare(a, b *serverpool.Object[T, O]) int {
+	if e.policy.Order == EvictLowestPriority && e.policy.Priority != nil {
+		if c := e.policy.Priority(a.Id) - e.policy.Priority(b.Id); c != 0 {
+			return c
+		}
+	}
+	return e.assigned[a.Id].Compare(e.assigned[b.Id])
+}
+
+// Evict the objects of each node beyond its capacity, returning the
+// objects evicted. A mirror follows the evictions of its primary instead.
+func (lb *loadBalancer[T, O]) evict(errs *ReassignmentError[O]) []*serverpool.Object[T, O] {
+	if lb.eviction.policy == nil || lb.eviction.policy.Capacity == nil || lb.readOnly {
+		return nil
+	}
+	var evicted []*serverpool.Object[T, O]
+	for node := range lb.sp.Nodes() {
+		objects := slices.Collect(node.Objects())
+		excess := len(objects) - max(lb.eviction.policy.Capacity(node), 0)
+		if excess <= 0 {
+			continue
+		}
+		slices.SortStableFunc(objects, lb.eviction.compare)
+		for _, obj := range objects[:excess] {
+			lb.undo.moving(obj)
+			node.UnassignObject(obj)
+			obj.UnassignFromNode()
+			lb.eviction.forget(obj.Id)
+			lb.prefetcher.forget(obj)
+			lb.notifyEvicted(obj, node)
+			errs.add(obj.Id, ErrObjectEvicted)
+			evicted = append(evicted, obj)
+		}
+	}
+	return evicted
+}
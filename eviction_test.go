@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"maps"
+	"serverpool"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEviction(t *testing.T) {
+	for order, kept := range map[EvictionOrder][]string{
+		EvictLowestPriority:        {"obj0", "obj1", "obj2"},
+		EvictLeastRecentlyAssigned: {"obj3", "obj4", "obj5"},
+	} {
+		// Objects assigned later have lower priorities, so the orders differ
+		lb := NewLoadBalancerWithOptions(WithEviction(EvictionPolicy[string, string]{
+			Capacity: func(serverpool.Node[string, string]) int { return 3 },
+			Order:    order,
+			Priority: func(id string) int { n, _ := strconv.Atoi(strings.TrimPrefix(id, "obj")); return -n },
+		})).(*loadBalancer[string, string])
+		now := time.Unix(1000, 0)
+		lb.churn.now = func() time.Time { return now }
+		mirror := newMirror(t, lb)
+		events, sub := lb.Watch(0, EventObjectEvicted)
+		defer sub.Unsubscribe()
+
+		node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+		node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+		if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for i := range 6 {
+			obj := &serverpool.Object[string, string]{Id: "obj" + strconv.Itoa(i)}
+			if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := lb.AssignObject(obj); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			now = now.Add(time.Second)
+		}
+
+		_, err := lb.RemoveNodes([]serverpool.Node[string, string]{node2})
+		var errs *ReassignmentError[string]
+		if !errors.As(err, &errs) || len(errs.Errors) != 3 || !errors.Is(err, ErrObjectEvicted) {
+			t.Fatalf("expected 3 objects evicted, got %v", err)
+		}
+		if ids := slices.Sorted(maps.Keys(node1.objects)); !slices.Equal(ids, kept) {
+			t.Fatalf("expected order %d to keep %v, got %v", order, kept, ids)
+		}
+		for range 3 {
+			if e := <-events; slices.Contains(kept, e.Object) || e.Node != node1 {
+				t.Fatalf("expected an eviction of an object dropped from node1, got %+v", e)
+			}
+		}
+
+		// The mirror follows the evictions instead of over-packing
+		for node, objects := range mirror.ObjectsByNode() {
+			if n := len(slices.Collect(objects)); n != 3 {
+				t.Fatalf("expected 3 objects on the mirror's %v, got %d", node, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Export of the change feed to external sinks
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChangeRecord is the exported form of a change. The version identifies a
+// change, so consumers can drop records delivered more than once.
+type ChangeRecord[T, O comparable] struct {
+	Version uint64               `json:"version,omitempty"`
+	Time    time.Time            `json:"time,omitzero"`
+	Op      string               `json:"op"`
+	Nodes   []T                  `json:"nodes,omitempty"`
+	Objects []O                  `json:"objects,omitempty"`
+	Keys    []string             `json:"keys,omitempty"`
+	Dial    []DialStep           `json:"dial,omitempty"`
+	Changes []ChangeRecord[T, O] `json:"changes,omitempty"`
+}
+
+func newChangeRecord[T, O comparable](c Change[T, O]) ChangeRecord[T, O] {
+	r := ChangeRecord[T, O]{Version: c.Version, Time: c.Time, Op: c.Op.String(), Objects: c.Objects, Keys: c.Keys,
+		Dial: c.Schedule}
+	for _, node := range c.Nodes {
+		r.Nodes = append(r.Nodes, node.Name())
+	}
+	for _, change := range c.Changes {
+		r.Changes = append(r.Changes, newChangeRecord(change))
+	}
+	return r
+}
+
+// ChangeSink stores exported changes. Write returns only once the records
+// are stored, they are written again after an error.
+type ChangeSink interface {
+	Write(ctx context.Context, records [][]byte) error
+}
+
+// ChangeCursor persists the version of the last change a sink stored
+type ChangeCursor interface {
+	Load() (uint64, error)
+	Save(version uint64) error
+}
+
+// FileCursor keeps the cursor in a file, starting at 0 if it is missing
+type FileCursor string
+
+func (f FileCursor) Load() (uint64, error) {
+	b, err := os.ReadFile(string(f))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// Save replaces the file so that a crash leaves the old or the new cursor
+func (f FileCursor) Save(version uint64) error {
+	tmp := string(f) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(version, 10)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, string(f))
+}
+
+// memoryCursor keeps the cursor for the life of the process
+type memoryCursor struct {
+	version atomic.Uint64
+}
+
+func (m *memoryCursor) Load() (uint64, error) { return m.version.Load(), nil }
+
+func (m *memoryCursor) Save(version uint64) error {
+	m.version.Store(version)
+	return nil
+}
+
+// JSONLSink appends each record as a line to a file, synced before Write
+// returns
+type JSONLSink struct {
+	Path string
+}
+
+func (s JSONLSink) Write(_ context.Context, records [][]byte) error {
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, r := range records {
+		buf.Write(r)
+		buf.WriteByte('\n')
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WebhookSink posts the records as a JSON array to a URL and counts them as
+// stored on any 2xx response
+type WebhookSink struct {
+	URL string
+
+	// HTTP client, http.DefaultClient if nil
+	Client *http.Client
+}
+
+func (s WebhookSink) Write(ctx context.Context, records [][]byte) error {
+	body := append([]byte{'['}, bytes.Join(records, []byte{','})...)
+	body = append(body, ']')
+	return postJSON(ctx, s.Client, s.URL, "application/json", body)
+}
+
+// KafkaSink produces the records to a Kafka topic through a Kafka REST
+// proxy, keyed by version so that retries of a record land in the same
+// partition
+type KafkaSink struct {
+	// URL of the REST proxy, e.g. http://kafka-rest:8082
+	Proxy string
+	Topic string
+
+	// HTTP client, http.DefaultClient if nil
+	Client *http.Client
+}
+
+func (s KafkaSink) Write(ctx context.Context, records [][]byte) error {
+	type kafkaRecord struct {
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	var req struct {
+		Records []kafkaRecord `json:"records"`
+	}
+	for _, r := range records {
+		var key struct {
+			Version uint64 `json:"version"`
+		}
+		if err := json.Unmarshal(r, &key); err != nil {
+			return err
+		}
+		req.Records = append(req.Records, kafkaRecord{strconv.FormatUint(key.Version, 10), r})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.Client, strings.TrimSuffix(s.Proxy, "/")+"/topics/"+s.Topic,
+		"application/vnd.kafka.json.v2+json", body)
+}
+
+func postJSON(ctx context.Context, client *http.Client, url, contentType string, body []byte) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", url, resp.Status, strconv.Quote(string(msg)))
+	}
+	return nil
+}
+
+// ChangeExporter delivers the changes of a load balancer to a sink at least
+// once, in version order, from a goroutine of its own
+type ChangeExporter[T, O comparable] struct {
+	sink   ChangeSink
+	cursor ChangeCursor
+
+	// Records per write
+	batch int
+
+	// Called with delivery errors before they are retried, if set
+	onError func(error)
+
+	mu      sync.Mutex
+	pending []ChangeRecord[T, O]
+	wake    chan struct{}
+
+	cancel func()
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// ExportChanges delivers every change after the version saved in cursor to
+// sink, batch records at a time, and saves the version of each batch
+// stored. A crash between storing a batch and saving the cursor delivers
+// the batch again. Errors are passed to onError, if not nil, and retried
+// with backoff. Fails with ErrUnsupported if lb is not a Feeder.
+func ExportChanges[T, O comparable](lb LoadBalancer[T, O], sink ChangeSink, cursor ChangeCursor, batch int, onError func(error)) (*ChangeExporter[T, O], error) {
+	feeder, err := capability[Feeder[T, O]](lb)
+	if err != nil {
+		return nil, err
+	}
+	since, err := cursor.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading export cursor: %w", err)
+	}
+	if since > lb.Version() {
+		return nil, fmt.Errorf("export cursor %d is ahead of version %d", since, lb.Version())
+	}
+	e := &ChangeExporter[T, O]{sink: sink, cursor: cursor, batch: max(batch, 1), onError: onError,
+		wake: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	if e.cancel, err = feeder.Feed(since, e.enqueue); err != nil {
+		return nil, err
+	}
+	go e.run()
+	return e, nil
+}
+
+// Queue a change, called by the feed so it must not block
+func (e *ChangeExporter[T, O]) enqueue(c Change[T, O]) {
+	e.mu.Lock()
+	e.pending = append(e.pending, newChangeRecord(c))
+	e.mu.Unlock()
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending is the number of changes not stored yet
+func (e *ChangeExporter[T, O]) Pending() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.pending)
+}
+
+func (e *ChangeExporter[T, O]) run() {
+	defer close(e.done)
+	backoff := time.Duration(0)
+	for {
+		if backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-e.stop:
+				return
+			}
+		}
+		if err := e.deliver(); err != nil {
+			if e.onError != nil {
+				e.onError(err)
+			}
+			backoff = min(max(2*backoff, 100*time.Millisecond), 30*time.Second)
+			continue
+		}
+		backoff = 0
+		if e.Pending() > 0 {
+			continue
+		}
+		select {
+		case <-e.wake:
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Write the oldest pending changes and save the cursor
+func (e *ChangeExporter[T, O]) deliver() error {
+	e.mu.Lock()
+	batch := e.pending[:min(e.batch, len(e.pending))]
+	e.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	records := make([][]byte, len(batch))
+	for i, r := range batch {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		records[i] = b
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := e.sink.Write(ctx, records); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.pending = e.pending[len(batch):]
+	e.mu.Unlock()
+	return e.cursor.Save(batch[len(batch)-1].Version)
+}
+
+// Close stops following the load balancer and delivering changes, after
+// trying to deliver the pending ones until ctx is done
+func (e *ChangeExporter[T, O]) Close(ctx context.Context) error {
+	e.cancel()
+	for e.Pending() > 0 && ctx.Err() == nil {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+		}
+	}
+	close(e.stop)
+	<-e.done
+	if n := e.Pending(); n > 0 {
+		return fmt.Errorf("%d changes not exported", n)
+	}
+	return nil
+}
+
+// Parse a sink given as file:<path>, webhook:<url> or
+// kafka:<proxy url>/topics/<topic>
+func parseChangeSink(s string) (ChangeSink, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	switch kind {
+	case "file":
+		return JSONLSink{Path: arg}, nil
+	case "webhook":
+		return WebhookSink{URL: arg}, nil
+	case "kafka":
+		proxy, topic, ok := strings.Cut(arg, "/topics/")
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("invalid kafka sink %q, expected kafka:<proxy url>/topics/<topic>", s)
+		}
+		return KafkaSink{Proxy: proxy, Topic: topic}, nil
+	case "redis":
+		addr, channel, ok := strings.Cut(arg, "/")
+		if !ok || addr == "" || channel == "" {
+			return nil, fmt.Errorf("invalid redis sink %q, expected redis:<host:port>/<channel>", s)
+		}
+		return RedisSink{Client: &RedisClient{Addr: addr}, Channel: channel}, nil
+	}
+	return nil, fmt.Errorf("unknown change sink %q", s)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"serverpool"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExportChanges(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	addNode := func(name string) {
+		t.Helper()
+		if _, err := lb.AddNodes([]serverpool.Node[string, string]{&mockNode{ID: name}}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	addNode("node0")
+	addNode("node1")
+
+	// A webhook failing its first request gets the records again
+	var mu sync.Mutex
+	var received []ChangeRecord[string, string]
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var records []ChangeRecord[string, string]
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = append(received, records...)
+	}))
+	defer ts.Close()
+
+	cursor := FileCursor(t.TempDir() + "/cursor")
+	exporter, err := ExportChanges(lb, WebhookSink{URL: ts.URL}, cursor, 2, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	addNode("node2")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Close(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	mu.Lock()
+	if len(received) != 3 || received[0].Version != 1 || received[2].Version != 3 ||
+		received[2].Op != "AddNodes" || !slices.Equal(received[2].Nodes, []string{"node2"}) {
+		t.Fatalf("expected the 3 changes in order, got %+v", received)
+	}
+	mu.Unlock()
+	if v, err := cursor.Load(); err != nil || v != 3 {
+		t.Fatalf("expected cursor 3, got %d, %v", v, err)
+	}
+
+	// Resuming from the cursor only exports later changes, as JSON lines
+	path := t.TempDir() + "/changes.jsonl"
+	addNode("node3")
+	exporter, err = ExportChanges(lb, JSONLSink{Path: path}, cursor, 10, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := exporter.Close(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var record ChangeRecord[string, string]
+	if len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &record) != nil || record.Version != 4 {
+		t.Fatalf("expected only change 4, got %q", data)
+	}
+
+	if err := cursor.Save(10); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := ExportChanges(lb, JSONLSink{Path: path}, cursor, 10, nil); err == nil {
+		t.Fatal("expected an error for a cursor ahead of the load balancer")
+	}
+}
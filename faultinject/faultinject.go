@@ -0,0 +1,147 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// faultinject package decides when to inject failures into a load
+// balancer, so applications embedding it can test their error handling.
+package faultinject
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the error of failed lookups
+var ErrInjected = errors.New("injected fault")
+
+// Config sets how often each fault is injected. Rates are probabilities
+// from 0, never, to 1, every time.
+type Config struct {
+	// Share of lookups failing with ErrInjected
+	LookupErrorRate float64
+
+	// Share of object assignments held up by AssignDelay
+	AssignDelayRate float64
+	AssignDelay     time.Duration
+
+	// Share of changes followed by an event reporting a node that is still
+	// there as removed
+	SpuriousRemovalRate float64
+
+	// Seed of the random choices, so a failing test can be replayed. The
+	// current time if 0.
+	Seed int64
+}
+
+// Stats counts the faults injected so far
+type Stats struct {
+	LookupErrors     uint64
+	AssignDelays     uint64
+	SpuriousRemovals uint64
+}
+
+// Injector makes the random choices of a Config. The methods of a nil
+// Injector never inject anything. An Injector is safe for concurrent use.
+type Injector struct {
+	config Config
+
+	// Waits out assignment delays, time.Sleep unless replaced by tests
+	sleep func(time.Duration)
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	enabled bool
+	stats   Stats
+}
+
+// New creates an enabled injector
+func New(config Config) (*Injector, error) {
+	rates := map[string]float64{
+		"lookup error":     config.LookupErrorRate,
+		"assign delay":     config.AssignDelayRate,
+		"spurious removal": config.SpuriousRemovalRate,
+	}
+	for name, rate := range rates {
+		if !(rate >= 0 && rate <= 1) {
+			return nil, fmt.Errorf("%s rate %v is not between 0 and 1", name, rate)
+		}
+	}
+	if config.AssignDelay < 0 {
+		return nil, fmt.Errorf("negative assign delay %v", config.AssignDelay)
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Injector{config: config, sleep: time.Sleep, rng: rand.New(rand.NewSource(seed)), enabled: true}, nil
+}
+
+// SetEnabled turns injection on or off, e.g. to set up a test before
+// injecting faults
+func (i *Injector) SetEnabled(enabled bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.enabled = enabled
+}
+
+// Stats returns the faults injected so far
+func (i *Injector) Stats() Stats {
+	if i == nil {
+		return Stats{}
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stats
+}
+
+// Draw whether to inject a fault of the given rate, counting it if so
+func (i *Injector) draw(rate float64, count *uint64) bool {
+	if !i.enabled || rate <= 0 || i.rng.Float64() >= rate {
+		return false
+	}
+	*count++
+	return true
+}
+
+// LookupError returns ErrInjected if a lookup should fail
+func (i *Injector) LookupError() error {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.draw(i.config.LookupErrorRate, &i.stats.LookupErrors) {
+		return ErrInjected
+	}
+	return nil
+}
+
+// DelayAssignment waits for AssignDelay if an assignment should be held up
+func (i *Injector) DelayAssignment() {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	delay := i.draw(i.config.AssignDelayRate, &i.stats.AssignDelays)
+	i.mu.Unlock()
+	if delay {
+		i.sleep(i.config.AssignDelay)
+	}
+}
+
+// SpuriousRemoval picks one of n nodes to report as removed, if a change
+// should be followed by a spurious removal
+func (i *Injector) SpuriousRemoval(n int) (int, bool) {
+	if i == nil || n <= 0 {
+		return 0, false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.draw(i.config.SpuriousRemovalRate, &i.stats.SpuriousRemovals) {
+		return 0, false
+	}
+	return i.rng.Intn(n), true
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package faultinject
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRates(t *testing.T) {
+	inj, err := New(Config{LookupErrorRate: 0.25, SpuriousRemovalRate: 1, Seed: 1})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	failed := 0
+	for range 10000 {
+		if err := inj.LookupError(); err != nil {
+			if !errors.Is(err, ErrInjected) {
+				t.Fatalf("expected ErrInjected, got %v", err)
+			}
+			failed++
+		}
+	}
+	if failed < 2300 || failed > 2700 {
+		t.Fatalf("expected about 2500 lookup errors, got %d", failed)
+	}
+	if k, ok := inj.SpuriousRemoval(3); !ok || k < 0 || k >= 3 {
+		t.Fatalf("expected a node out of 3, got %d, %v", k, ok)
+	}
+	if _, ok := inj.SpuriousRemoval(0); ok {
+		t.Fatalf("expected no removal without nodes")
+	}
+	if stats := inj.Stats(); stats.LookupErrors != uint64(failed) || stats.SpuriousRemovals != 1 || stats.AssignDelays != 0 {
+		t.Fatalf("expected the injected faults counted, got %+v", stats)
+	}
+
+	inj.SetEnabled(false)
+	if err := inj.LookupError(); err != nil {
+		t.Fatalf("expected no error while disabled, got %v", err)
+	}
+	if _, ok := inj.SpuriousRemoval(3); ok {
+		t.Fatalf("expected no removal while disabled")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	draws := func() []bool {
+		inj, err := New(Config{LookupErrorRate: 0.5, Seed: 42})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var d []bool
+		for range 100 {
+			d = append(d, inj.LookupError() != nil)
+		}
+		return d
+	}
+	a, b := draws(), draws()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("expected the same faults from the same seed, differ at %d", i)
+		}
+	}
+}
+
+func TestDelayAssignment(t *testing.T) {
+	inj, err := New(Config{AssignDelayRate: 1, AssignDelay: time.Minute})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var slept time.Duration
+	inj.sleep = func(d time.Duration) { slept += d }
+	inj.DelayAssignment()
+	inj.DelayAssignment()
+	if slept != 2*time.Minute || inj.Stats().AssignDelays != 2 {
+		t.Fatalf("expected two delays of a minute, got %v, %+v", slept, inj.Stats())
+	}
+}
+
+func TestNilInjector(t *testing.T) {
+	var inj *Injector
+	if err := inj.LookupError(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	inj.DelayAssignment()
+	if _, ok := inj.SpuriousRemoval(3); ok {
+		t.Fatalf("expected no removal")
+	}
+	if inj.Stats() != (Stats{}) {
+		t.Fatalf("expected no faults counted")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, config := range []Config{
+		{LookupErrorRate: -0.1},
+		{AssignDelayRate: 1.5},
+		{SpuriousRemovalRate: 2},
+		{AssignDelay: -time.Second},
+	} {
+		if _, err := New(config); err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module faultinject
+
+go 1.23.0
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Quarantine of nodes that are added and removed too often
+
+package main
+
+import (
+	"errors"
+	"maps"
+	"time"
+)
+
+// ErrNodeQuarantined is returned when adding a node that is quarantined
+// for flapping
+var ErrNodeQuarantined = errors.New("node is quarantined")
+
+type flapDetector[T comparable] struct {
+	// Node changes allowed per window before the node is quarantined, 0 to disable
+	limit   int
+	window  time.Duration
+	backoff time.Duration
+
+	// Recent adds and removals of each node
+	changes map[T][]time.Time
+
+	// End of the quarantine of each quarantined node
+	quarantined map[T]time.Time
+}
+
+// Record that node was added or removed and quarantine it if it changed
+// more than the limit within the window
+func (f *flapDetector[T]) record(node T, now time.Time) {
+	if f.limit <= 0 {
+		return
+	}
+	if f.changes == nil {
+		f.changes = make(map[T][]time.Time)
+	}
+
+	// Drop changes that fell out of the window
+	changes := f.changes[node]
+	i := 0
+	for i < len(changes) && now.Sub(changes[i]) >= f.window {
+		i++
+	}
+	changes = append(changes[i:], now)
+	f.changes[node] = changes
+
+	if len(changes) > f.limit {
+		if f.quarantined == nil {
+			f.quarantined = make(map[T]time.Time)
+		}
+		f.quarantined[node] = now.Add(f.backoff)
+		delete(f.changes, node)
+	}
+}
+
+// Check if node is quarantined, forgetting quarantines that have ended
+func (f *flapDetector[T]) isQuarantined(node T, now time.Time) bool {
+	until, ok := f.quarantined[node]
+	if ok && !now.Before(until) {
+		delete(f.quarantined, node)
+		return false
+	}
+	return ok
+}
+
+// Nodes in quarantine and the end of their quarantine
+func (f *flapDetector[T]) active(now time.Time) map[T]time.Time {
+	maps.DeleteFunc(f.quarantined, func(_ T, until time.Time) bool { return !now.Before(until) })
+	return maps.Clone(f.quarantined)
+}
+
+// Nodes quarantined for flapping and the end of their quarantine
+func (lb *loadBalancer[T, O]) QuarantinedNodes() map[T]time.Time {
+	return lb.flaps.active(lb.churn.clock())
+}
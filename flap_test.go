@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestFlapQuarantine(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithFlapQuarantine[string, string](2, time.Minute, time.Hour)).(*loadBalancer[string, string])
+	now := time.Unix(0, 0)
+	lb.churn.now = func() time.Time { return now }
+
+	node := []serverpool.Node[string, string]{&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}}
+	other := []serverpool.Node[string, string]{&mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}}
+	if _, err := lb.AddNodes(other); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Add, remove and add again within the window
+	for i := 0; i < 3; i++ {
+		var err error
+		if i%2 == 0 {
+			_, err = lb.AddNodes(node)
+		} else {
+			_, err = lb.RemoveNodes(node)
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		now = now.Add(time.Second)
+	}
+	if _, ok := lb.QuarantinedNodes()["node1"]; !ok || lb.ChurnStats().Quarantined != 1 {
+		t.Fatalf("expected node1 to be quarantined, got %v", lb.QuarantinedNodes())
+	}
+
+	if _, err := lb.RemoveNodes(node); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.AddNodes(node); !errors.Is(err, ErrNodeQuarantined) {
+		t.Fatalf("expected ErrNodeQuarantined, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := lb.AddNodes(node); err != nil {
+		t.Fatalf("expected no error after back-off, got %v", err)
+	}
+	if len(lb.QuarantinedNodes()) != 0 {
+		t.Fatalf("expected no quarantined nodes, got %v", lb.QuarantinedNodes())
+	}
+}
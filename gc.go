@@ -0,0 +1,78 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Collection of objects held by nodes that the load balancer does not track
+
+package main
+
+import "serverpool"
+
+// Stray is an object a node holds without the load balancer tracking it
+// on that node
+type Stray[T, O comparable] struct {
+	Node   serverpool.Node[T, O]
+	Object *serverpool.Object[T, O]
+}
+
+// CollectOrphans unassigns the strays of every node, including draining
+// nodes: objects no longer in the load balancer, stale copies of an object
+// added again and objects recorded on another node. Webhooks receive an
+// ObjectCollected event for each. A dry run only reports the strays. A
+// mirror shares its nodes with the primary, so it cannot collect them.
+func (lb *loadBalancer[T, O]) CollectOrphans() (strays []Stray[T, O], err error) {
+	if lb.dryRun {
+		return lb.strays(), nil
+	}
+	if lb.readOnly {
+		return nil, ErrReadOnly
+	}
+	lb.profiler.do("CollectOrphans", func() { strays = lb.collectOrphans() })
+	return strays, nil
+}
+
+// Find the strays of the nodes in the pool and the draining nodes
+func (lb *loadBalancer[T, O]) strays() []Stray[T, O] {
+	var strays []Stray[T, O]
+	check := func(node serverpool.Node[T, O]) {
+		for obj := range node.Objects() {
+			if !lb.tracksOn(obj, node) {
+				strays = append(strays, Stray[T, O]{node, obj})
+			}
+		}
+	}
+	for node := range lb.sp.Nodes() {
+		check(node)
+	}
+	for _, d := range lb.drains {
+		check(d.node)
+	}
+	return strays
+}
+
+// Check that an object is stored and recorded on the node
+func (lb *loadBalancer[T, O]) tracksOn(obj *serverpool.Object[T, O], node serverpool.Node[T, O]) bool {
+	stored, ok := lb.objects.get(obj.Id)
+	if !ok || stored != obj {
+		return false
+	}
+	n := obj.Node()
+	return n != nil && *n == node
+}
+
+func (lb *loadBalancer[T, O]) collectOrphans() []Stray[T, O] {
+	strays := lb.strays()
+	for _, s := range strays {
+		s.Node.UnassignObject(s.Object)
+
+		// Nodes may keep objects by id, so unassigning a stale copy can
+		// take the stored object off the node it is recorded on
+		if stored, ok := lb.objects.get(s.Object.Id); ok && stored != s.Object {
+			if n := stored.Node(); n != nil && *n == s.Node {
+				s.Node.AssignObject(stored)
+			}
+		}
+		lb.notifyCollected(s)
+	}
+	return strays
+}
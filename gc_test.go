@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"serverpool"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollectOrphans(t *testing.T) {
+	var mu sync.Mutex
+	var collected []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e WebhookEvent[string, string]
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		collected = append(collected, *e.Object+"@"+e.Node)
+	}))
+	defer ts.Close()
+
+	lb := NewLoadBalancerWithOptions(WithWebhooks[string, string]([]Webhook{{URL: ts.URL, Events: []string{EventObjectCollected}}}, nil))
+	node := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	a := &serverpool.Object[string, string]{Id: "a"}
+	b := &serverpool.Object[string, string]{Id: "b"}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{a, b}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range []*serverpool.Object[string, string]{a, b} {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// An object the load balancer never tracked and a stale copy of one
+	// added again
+	node.AssignObject(&serverpool.Object[string, string]{Id: "ghost"})
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{{Id: "b"}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Mirrors share nodes with their primary
+	mirror := newMirror(t, NewLoadBalancer[string, string]())
+	if _, err := mirror.CollectOrphans(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly from a mirror, got %v", err)
+	}
+	strays, err := lb.CollectOrphans()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var ids []string
+	for _, s := range strays {
+		if s.Node != node {
+			t.Fatalf("expected strays on node1, got %v", s.Node)
+		}
+		ids = append(ids, s.Object.Id)
+	}
+	if slices.Sort(ids); !slices.Equal(ids, []string{"b", "ghost"}) {
+		t.Fatalf("expected b and ghost collected, got %v", ids)
+	}
+	if ids := slices.Collect(maps.Keys(node.objects)); !slices.Equal(ids, []string{"a"}) {
+		t.Fatalf("expected only a left on node1, got %v", ids)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strays, err := lb.CollectOrphans(); err != nil || len(strays) != 0 {
+		t.Fatalf("expected nothing left to collect, got %v, %v", strays, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		got := slices.Sorted(slices.Values(collected))
+		mu.Unlock()
+		if slices.Equal(got, []string{"b@node1", "ghost@node1"}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected events for b and ghost, got %v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
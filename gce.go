@@ -0,0 +1,174 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Discovery of the instances of GCP instance groups
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+)
+
+// GCEDiscoverer lists the running instances of a zonal GCP instance group,
+// managed or not, through the Compute Engine API. Instance labels become
+// node tags.
+type GCEDiscoverer struct {
+	Project, Zone, Group string
+
+	// OAuth access token for the API, the token of the default service
+	// account from the metadata server if nil
+	Token func(ctx context.Context) (string, error)
+
+	// API endpoint, https://compute.googleapis.com/compute/v1 if empty
+	Endpoint string
+
+	// Metadata server, http://metadata.google.internal if empty
+	Metadata string
+
+	// HTTP client, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// Create a discoverer for the instance group using the default service
+// account of the instance it runs on
+func NewGCEDiscoverer(project, zone, group string) *GCEDiscoverer {
+	return &GCEDiscoverer{Project: project, Zone: zone, Group: group}
+}
+
+func (d *GCEDiscoverer) Instances(ctx context.Context) ([]Instance, error) {
+	endpoint := d.Endpoint
+	if endpoint == "" {
+		endpoint = "https://compute.googleapis.com/compute/v1"
+	}
+	token, err := d.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The group only lists the URLs of its instances
+	group := endpoint + "/projects/" + d.Project + "/zones/" + d.Zone + "/instanceGroups/" + d.Group
+	var urls []string
+	page := ""
+	for {
+		var resp struct {
+			Items []struct {
+				Instance string `json:"instance"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		body := map[string]string{"instanceState": "RUNNING"}
+		list := group + "/listInstances"
+		if page != "" {
+			list += "?pageToken=" + url.QueryEscape(page)
+		}
+		if err := d.call(ctx, http.MethodPost, list, token, body, &resp); err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Items {
+			urls = append(urls, item.Instance)
+		}
+		if page = resp.NextPageToken; page == "" {
+			break
+		}
+	}
+
+	instances := make([]Instance, 0, len(urls))
+	for _, instance := range urls {
+		var inst struct {
+			ID                string `json:"id"`
+			Name              string `json:"name"`
+			NetworkInterfaces []struct {
+				NetworkIP string `json:"networkIP"`
+			} `json:"networkInterfaces"`
+			Labels map[string]string `json:"labels"`
+		}
+		if err := d.call(ctx, http.MethodGet, instance, token, nil, &inst); err != nil {
+			return nil, err
+		}
+		if len(inst.NetworkInterfaces) == 0 {
+			continue
+		}
+		addr, err := netip.ParseAddr(inst.NetworkInterfaces[0].NetworkIP)
+		if err != nil {
+			continue
+		}
+		tags := inst.Labels
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags["name"] = inst.Name
+		instances = append(instances, Instance{ID: inst.ID, Addr: addr, Tags: tags})
+	}
+	return instances, nil
+}
+
+// Access token from Token or the metadata server
+func (d *GCEDiscoverer) token(ctx context.Context) (string, error) {
+	if d.Token != nil {
+		return d.Token(ctx)
+	}
+	metadata := d.Metadata
+	if metadata == "" {
+		metadata = "http://metadata.google.internal"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		metadata+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := d.do(req, &token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// Call the API with a JSON body, if any, and decode the JSON response
+func (d *GCEDiscoverer) call(ctx context.Context, method, url, token string, body, v any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return d.do(req, v)
+}
+
+func (d *GCEDiscoverer) do(req *http.Request, v any) error {
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strconv.Quote(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestGCEDiscoverer(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				t.Errorf("expected the metadata header")
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token"})
+			return
+		case r.Header.Get("Authorization") != "Bearer token":
+			t.Errorf("expected the access token, got %q", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/projects/p/zones/z/instanceGroups/g/listInstances":
+			// A page per instance
+			switch r.URL.Query().Get("pageToken") {
+			case "":
+				fmt.Fprintf(w, `{"items": [{"instance": "%s/instances/a"}], "nextPageToken": "page/2+"}`, server.URL)
+			case "page/2+":
+				fmt.Fprintf(w, `{"items": [{"instance": "%s/instances/b"}]}`, server.URL)
+			default:
+				t.Errorf("unexpected page token in %s", r.URL.RawQuery)
+			}
+		case "/instances/a":
+			fmt.Fprint(w, `{"id": "1", "name": "a", "networkInterfaces": [{"networkIP": "10.0.0.1"}],
+				"labels": {"env": "prod"}}`)
+		case "/instances/b":
+			fmt.Fprint(w, `{"id": "2", "name": "b", "networkInterfaces": [{"networkIP": "10.0.0.2"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	d := &GCEDiscoverer{Project: "p", Zone: "z", Group: "g", Endpoint: server.URL, Metadata: server.URL}
+	instances, err := d.Instances(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(instances) != 2 || instances[0].Tags["env"] != "prod" || instances[1].Tags["name"] != "b" ||
+		instances[1].Addr != netip.MustParseAddr("10.0.0.2") {
+		t.Fatalf("unexpected instances %+v", instances)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Random workload generator for what-if experiments
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/netip"
+	"serverpool"
+	"simulator"
+	"slices"
+	"strings"
+)
+
+// Parse a key distribution spec such as "zipf:1.2" or "trace:keys.txt",
+// checking only the name since arguments are validated on creation
+func parseDistribution(text string) (string, error) {
+	name, _, _ := strings.Cut(text, ":")
+	if !slices.Contains(simulator.Names(), name) {
+		return "", fmt.Errorf("expected one of %v", simulator.Names())
+	}
+	return text, nil
+}
+
+// Summary of how evenly a quantity is spread across nodes
+type distributionStats struct {
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+
+	// Max divided by mean, 1 for a perfectly even spread
+	Skew float64 `json:"skew"`
+}
+
+func newDistributionStats(counts []int) distributionStats {
+	if len(counts) == 0 {
+		return distributionStats{}
+	}
+
+	s := distributionStats{Min: slices.Min(counts), Max: slices.Max(counts)}
+	for _, c := range counts {
+		s.Mean += float64(c)
+	}
+	s.Mean /= float64(len(counts))
+	for _, c := range counts {
+		s.StdDev += (float64(c) - s.Mean) * (float64(c) - s.Mean)
+	}
+	s.StdDev = math.Sqrt(s.StdDev / float64(len(counts)))
+	if s.Mean > 0 {
+		s.Skew = float64(s.Max) / s.Mean
+	}
+	return s
+}
+
+func (s distributionStats) String() string {
+	return fmt.Sprintf("min %d, max %d, mean %.1f, stddev %.1f, max/mean %.2f", s.Min, s.Max, s.Mean, s.StdDev, s.Skew)
+}
+
+// Add numNodes random nodes and up to numAccesses accesses to keys drawn
+// from the distribution, assign an object per distinct key and print how
+// objects and accesses spread across all nodes
+func generate(lb LoadBalancer[netip.Addr, int], numNodes, numAccesses int, dist string) error {
+	keys, err := simulator.Parse(dist, r, numAccesses)
+	if err != nil {
+		out.fail("generate", "Invalid distribution", err)
+		return err
+	}
+
+	if err := addNodes(lb, numNodes); err != nil {
+		return err
+	}
+
+	// Start ids after the existing objects so they are not replaced
+	base := 0
+	for obj := range lb.Objects() {
+		base = max(base, obj.Id+1)
+	}
+
+	// Give each distinct key the next free object id
+	ids := make(map[string]int)
+	accesses := make(map[int]int)
+	generated := 0
+	for ; generated < numAccesses; generated++ {
+		key, ok := keys.Next()
+		if !ok {
+			break
+		}
+		id, ok := ids[key]
+		if !ok {
+			id = base + len(ids)
+			ids[key] = id
+		}
+		accesses[id]++
+	}
+
+	var objects []*serverpool.Object[netip.Addr, int]
+	for id := range accesses {
+		objects = append(objects, &NewWorkObject[netip.Addr](id).Object)
+	}
+	if _, err := lb.AddObjects(objects); err != nil {
+		out.fail("generate", "Error adding work", err)
+		return err
+	}
+
+	// Count objects and accesses per node
+	objectCount := make(map[netip.Addr]int)
+	requestCount := make(map[netip.Addr]int)
+	for _, obj := range objects {
+		if err := lb.AssignObject(obj); err != nil {
+			out.fail("generate", "Error assigning work", err)
+			return err
+		}
+		node := (*obj.Node()).Name()
+		objectCount[node]++
+		requestCount[node] += accesses[obj.Id]
+	}
+
+	var nodes []nodeResult
+	var objectCounts, requestCounts []int
+	for node, bucket := range lb.Nodes() {
+		addr := node.Name()
+		nodes = append(nodes, nodeResult{Address: addr.String(), Bucket: bucket,
+			Objects: objectCount[addr], Requests: requestCount[addr]})
+		objectCounts = append(objectCounts, objectCount[addr])
+		requestCounts = append(requestCounts, requestCount[addr])
+	}
+	slices.SortFunc(nodes, func(a, b nodeResult) int { return cmp.Compare(a.Bucket, b.Bucket) })
+
+	objectStats, requestStats := newDistributionStats(objectCounts), newDistributionStats(requestCounts)
+	if out.json {
+		out.emit(commandResult{Command: "generate", Nodes: nodes,
+			Stats: map[string]distributionStats{"objects": objectStats, "requests": requestStats}})
+		return nil
+	}
+
+	for _, n := range nodes {
+		fmt.Fprintf(out.out, "Node: %-15s Bucket: %-4d Objects: %-6d Requests: %d\n", n.Address, n.Bucket, n.Objects, n.Requests)
+	}
+	fmt.Fprintln(out.out, "Generated", len(objects), "objects from", generated, dist, "accesses")
+	fmt.Fprintln(out.out, "Objects per node: ", objectStats)
+	fmt.Fprintln(out.out, "Requests per node:", requestStats)
+	return nil
+}
@@ -1,12 +1,59 @@
 module loadbalance
 
-go 1.23.0
+// Go 1.24 for the omitzero JSON tag of ChangeRecord in export.go
+go 1.24.0
 
 replace serverpool => ./serverpool
 
 require (
 	consistenthash v0.0.0-00010101000000-000000000000
+	dns v0.0.0-00010101000000-000000000000
+	faultinject v0.0.0-00010101000000-000000000000
+	proxy v0.0.0-00010101000000-000000000000
+	proxyconf v0.0.0-00010101000000-000000000000
 	serverpool v0.0.0-00010101000000-000000000000
+	simulator v0.0.0-00010101000000-000000000000
+	tcpproxy v0.0.0-00010101000000-000000000000
+	topology v0.0.0-00010101000000-000000000000
+	xds v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/buraksezer/consistent v0.10.0
+	github.com/lafikl/consistent v0.0.0-20220512074542-bdd3606bfc3e
+	github.com/stathat/consistent v1.0.0
+	go.opentelemetry.io/otel v1.35.0
+	go.opentelemetry.io/otel/metric v1.35.0
+	go.opentelemetry.io/otel/sdk/metric v1.35.0
+	golang.org/x/term v0.27.0
+	golang.org/x/text v0.21.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/minio/blake2b-simd v0.0.0-20160723061019-3f5f724cb5b1 // indirect
+	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
+	go.opentelemetry.io/otel/sdk v1.35.0 // indirect
+	go.opentelemetry.io/otel/trace v1.35.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 )
 
 replace consistenthash => ./consistenthash
+
+replace simulator => ./simulator
+
+replace xds => ./xds
+
+replace proxyconf => ./proxyconf
+
+replace dns => ./dns
+
+replace topology => ./topology
+
+replace faultinject => ./faultinject
+
+replace proxy => ./proxy
+
+replace tcpproxy => ./tcpproxy
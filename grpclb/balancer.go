@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// gRPC balancer picking the backend of each call from a Ring
+
+package grpclb
+
+import (
+	"context"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	balancer.Register(NewBuilder(Name, DefaultKeyHeader))
+}
+
+// NewBuilder creates a builder of balancers, registered under name with
+// balancer.Register, that route calls by the key in the header metadata
+func NewBuilder(name, header string) balancer.Builder {
+	return &builder{name: name, header: header}
+}
+
+// WithKey returns a context whose calls are routed by key with the
+// balancer registered under Name
+func WithKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, DefaultKeyHeader, key)
+}
+
+type builder struct {
+	name   string
+	header string
+}
+
+func (b *builder) Name() string {
+	return b.name
+}
+
+// Build a balancer for a client connection. The base balancer keeps a
+// SubConn per resolved address and rebuilds the picker from the ready
+// ones, which a ring of the connection's own follows so keys only move
+// when their backend comes or goes.
+func (b *builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &pickerBuilder{header: b.header, ring: NewRing[balancer.SubConn]()}
+	return base.NewBalancerBuilder(b.name, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+type pickerBuilder struct {
+	header string
+	ring   *Ring[balancer.SubConn]
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	conns := make(map[string]balancer.SubConn, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		conns[sci.Address.Addr] = sc
+	}
+	pb.ring.Update(conns)
+	if len(conns) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	return &picker{header: pb.header, ring: pb.ring}
+}
+
+type picker struct {
+	header string
+	ring   *Ring[balancer.SubConn]
+}
+
+// Pick the backend of the key of a call. Calls without a key fail rather
+// than spread, since their backend would be arbitrary. They fail with
+// Internal, since gRPC does not let pickers fail calls with codes such as
+// InvalidArgument that servers use.
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	md, _ := metadata.FromOutgoingContext(info.Ctx)
+	keys := md.Get(p.header)
+	if len(keys) == 0 || keys[0] == "" {
+		return balancer.PickResult{}, status.Errorf(codes.Internal, "no %s metadata to route the call by", p.header)
+	}
+	_, sc, err := p.ring.Pick(keys[0])
+	if err != nil {
+		// Wait for a backend to become ready
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	return balancer.PickResult{SubConn: sc}, nil
+}
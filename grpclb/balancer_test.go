@@ -0,0 +1,165 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package grpclb
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+// SubConn of a picker test, told apart by its address
+type testSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func TestPicker(t *testing.T) {
+	pb := &pickerBuilder{header: DefaultKeyHeader, ring: NewRing[balancer.SubConn]()}
+	ready := func(addrs ...string) base.PickerBuildInfo {
+		info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+		for _, addr := range addrs {
+			info.ReadySCs[&testSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{Addr: addr}}
+		}
+		return info
+	}
+	pick := func(p balancer.Picker, key string) (string, error) {
+		ctx := context.Background()
+		if key != "" {
+			ctx = WithKey(ctx, key)
+		}
+		result, err := p.Pick(balancer.PickInfo{FullMethodName: "/test/Call", Ctx: ctx})
+		if err != nil {
+			return "", err
+		}
+		return result.SubConn.(*testSubConn).addr, nil
+	}
+
+	// Nothing is picked until a backend is ready
+	if _, err := pick(pb.Build(ready()), "key"); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("expected ErrNoSubConnAvailable, got %v", err)
+	}
+
+	p := pb.Build(ready("10.0.0.1:443", "10.0.0.2:443", "10.0.0.3:443"))
+	if _, err := pick(p, ""); status.Code(err) != codes.Internal {
+		t.Fatalf("expected calls without a key to fail, got %v", err)
+	}
+	before := make(map[string]string)
+	for i := range 300 {
+		key := "key" + strconv.Itoa(i)
+		addr, err := pick(p, key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		before[key] = addr
+	}
+
+	// Only the keys of a backend that is no longer ready move
+	p = pb.Build(ready("10.0.0.1:443", "10.0.0.3:443"))
+	for key, was := range before {
+		addr, err := pick(p, key)
+		if err != nil || (was != "10.0.0.2:443" && addr != was) || addr == "10.0.0.2:443" {
+			t.Fatalf("expected %s to stay on %s unless it was on the gone backend, got %s, %v", key, was, addr, err)
+		}
+	}
+}
+
+// Health server counting the calls it answers
+type countingServer struct {
+	*health.Server
+	mu    *sync.Mutex
+	calls map[string]int
+	addr  string
+}
+
+func (s countingServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.mu.Lock()
+	s.calls[s.addr]++
+	s.mu.Unlock()
+	return s.Server.Check(ctx, req)
+}
+
+func TestBalancer(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	var addrs []resolver.Address
+	for range 3 {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		server := grpc.NewServer()
+		healthpb.RegisterHealthServer(server, countingServer{health.NewServer(), &mu, calls, ln.Addr().String()})
+		go server.Serve(ln)
+		defer server.Stop()
+		addrs = append(addrs, resolver.Address{Addr: ln.Addr().String()})
+	}
+
+	r := manual.NewBuilderWithScheme("test")
+	r.InitialState(resolver.State{Addresses: addrs})
+	conn, err := grpc.NewClient(r.Scheme()+":///backends", grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"`+Name+`": {}}]}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Backends join the ring as they become ready, wait for all of them
+	for backends := 0; backends < len(addrs); {
+		mu.Lock()
+		clear(calls)
+		mu.Unlock()
+		for i := range 50 {
+			if _, err := client.Check(WithKey(ctx, "warmup"+strconv.Itoa(i)), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		mu.Lock()
+		backends = len(calls)
+		mu.Unlock()
+	}
+
+	// Calls with the same key reach the same backend
+	for i := range 30 {
+		mu.Lock()
+		clear(calls)
+		mu.Unlock()
+		key := "key" + strconv.Itoa(i)
+		for range 5 {
+			if _, err := client.Check(WithKey(ctx, key), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		mu.Lock()
+		if len(calls) != 1 {
+			t.Fatalf("expected the calls of %s on one backend, got %v", key, calls)
+		}
+		mu.Unlock()
+	}
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if s := status.Convert(err); s.Code() != codes.Internal || !strings.HasPrefix(s.Message(), "no "+DefaultKeyHeader) {
+		t.Fatalf("expected a call without a key to fail, got %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+module grpclb
+
+go 1.23.0
+
+require (
+	consistenthash v0.0.0-00010101000000-000000000000
+	google.golang.org/grpc v1.65.0
+	serverpool v0.0.0-00010101000000-000000000000
+)
+
+require (
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
+	google.golang.org/protobuf v1.34.1 // indirect
+	hashing v0.0.0-00010101000000-000000000000 // indirect
+)
+
+replace consistenthash => ../consistenthash
+
+replace hashing => ../hashing
+
+replace serverpool => ../serverpool
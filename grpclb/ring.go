@@ -0,0 +1,123 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// grpc package routes each gRPC call to a backend by a key in its outgoing
+// metadata with consistent hashing, so calls with the same key reach the
+// same backend as long as it is there. The backends are the addresses the
+// resolver of the target reports.
+//
+// Importing the package registers the balancer under Name. Select it with
+// the service config of the client:
+//
+//	grpc.NewClient(target, grpc.WithDefaultServiceConfig(
+//		`{"loadBalancingConfig": [{"consistent_hash": {}}]}`))
+//
+// and pass the key of each call with WithKey.
+package grpclb
+
+import (
+	"consistenthash"
+	"errors"
+	"iter"
+	"maps"
+	"serverpool"
+	"slices"
+	"sync"
+)
+
+// Name of the balancer in service configs
+const Name = "consistent_hash"
+
+// DefaultKeyHeader is the metadata key calls carry their routing key in
+const DefaultKeyHeader = "x-lb-key"
+
+// ErrNoBackends is returned when picking while no backend is ready
+var ErrNoBackends = errors.New("no backends available")
+
+// Backend of a ring, named by its address
+type backend[C any] struct {
+	addr string
+	conn C
+}
+
+func (b *backend[C]) Name() string { return b.addr }
+
+func (b *backend[C]) AssignObject(*serverpool.Object[string, string]) {}
+
+func (b *backend[C]) UnassignObject(*serverpool.Object[string, string]) {}
+
+func (b *backend[C]) Objects() iter.Seq[*serverpool.Object[string, string]] {
+	return func(func(*serverpool.Object[string, string]) bool) {}
+}
+
+// Ring maps keys to the connections of the backends, such as the SubConns
+// of a gRPC balancer, by their address. Backends join and leave as the set
+// of addresses is updated, moving only the keys of the backends that
+// changed. A Ring is safe for concurrent use.
+type Ring[C any] struct {
+	mu sync.RWMutex
+	sp serverpool.ServerPool[string, string]
+	ch consistenthash.ConsistentHasher
+}
+
+// NewRing creates a ring without backends
+func NewRing[C any]() *Ring[C] {
+	return &Ring[C]{sp: serverpool.NewServerPool[string, string](), ch: consistenthash.NewConsistentHasher()}
+}
+
+// Update sets the backends to the connections keyed by address, removing
+// the backends that are gone before adding the new ones so their buckets
+// are reused
+func (r *Ring[C]) Update(conns map[string]C) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var gone []serverpool.Node[string, string]
+	kept := make(map[string]bool)
+	for node := range r.sp.Nodes() {
+		b := node.(*backend[C])
+		if conn, ok := conns[b.addr]; ok {
+			b.conn = conn
+			kept[b.addr] = true
+		} else {
+			gone = append(gone, node)
+		}
+	}
+	for _, node := range gone {
+		if bucket, _, err := r.sp.RemoveNode(node); err == nil {
+			r.ch.RemoveBucket(bucket)
+		}
+	}
+
+	// Add in address order so the same updates lead to the same mapping
+	for _, addr := range slices.Sorted(maps.Keys(conns)) {
+		if kept[addr] {
+			continue
+		}
+		bucket := r.ch.AddBucket()
+		r.sp.AddNode(&backend[C]{addr: addr, conn: conns[addr]}, bucket)
+	}
+}
+
+// Pick returns the address and connection of the backend a key maps to
+func (r *Ring[C]) Pick(key string) (string, C, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var conn C
+	if r.ch.Size() == 0 {
+		return "", conn, ErrNoBackends
+	}
+	node, ok := r.sp.GetNode(r.ch.GetBucket(key))
+	if !ok {
+		return "", conn, ErrNoBackends
+	}
+	b := node.(*backend[C])
+	return b.addr, b.conn, nil
+}
+
+// Len returns the number of backends
+func (r *Ring[C]) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sp.Len()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package grpclb
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing[int]()
+	if _, _, err := r.Pick("key"); !errors.Is(err, ErrNoBackends) {
+		t.Fatalf("expected ErrNoBackends, got %v", err)
+	}
+
+	conns := map[string]int{"10.0.0.1:443": 1, "10.0.0.2:443": 2, "10.0.0.3:443": 3, "10.0.0.4:443": 4}
+	r.Update(conns)
+	if r.Len() != 4 {
+		t.Fatalf("expected 4 backends, got %d", r.Len())
+	}
+	before := make(map[string]string)
+	for i := range 1000 {
+		key := "key" + strconv.Itoa(i)
+		addr, conn, err := r.Pick(key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if conns[addr] != conn {
+			t.Fatalf("expected the connection of %s, got %d", addr, conn)
+		}
+		before[key] = addr
+	}
+
+	// Only the keys of the backend that left move
+	delete(conns, "10.0.0.2:443")
+	conns["10.0.0.3:443"] = 30
+	r.Update(conns)
+	for key, was := range before {
+		addr, conn, _ := r.Pick(key)
+		if was != "10.0.0.2:443" && addr != was {
+			t.Fatalf("expected %s to stay on %s, got %s", key, was, addr)
+		}
+		if addr == "10.0.0.2:443" {
+			t.Fatalf("expected %s off the removed backend", key)
+		}
+		if conns[addr] != conn {
+			t.Fatalf("expected the updated connection of %s, got %d", addr, conn)
+		}
+	}
+
+	// A backend coming back takes its keys back
+	conns["10.0.0.2:443"] = 2
+	r.Update(conns)
+	for key, was := range before {
+		if addr, _, _ := r.Pick(key); addr != was {
+			t.Fatalf("expected %s back on %s, got %s", key, was, addr)
+		}
+	}
+
+	r.Update(nil)
+	if _, _, err := r.Pick("key"); !errors.Is(err, ErrNoBackends) {
+		t.Fatalf("expected ErrNoBackends, got %v", err)
+	}
+}
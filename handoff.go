@@ -0,0 +1,142 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Handoff of the state of a running process to its successor over a unix
+// socket, for upgrades without downtime
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"serverpool"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Line a successor sends once it has loaded the state handed off
+const handoffAck = "ok"
+
+// Time a handoff may take, from the state being sent to the successor
+// acknowledging it, while the process holds its lock
+var handoffTimeout = 30 * time.Second
+
+// Listen on the handoff socket at path, replacing the socket of a process
+// that is gone. Only the user running the process may connect, since the
+// socket hands out the whole state.
+func listenHandoff(path string) (net.Listener, error) {
+	ln, err := listenPrivate(path)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		conn, dialErr := net.Dial("unix", path)
+		if dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another process is serving handoffs on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		ln, err = listenPrivate(path)
+	}
+	return ln, err
+}
+
+// Listen on a unix socket at path that only the user can connect to. The
+// umask applies as the socket is created, so unlike a chmod afterwards it
+// leaves no moment where others could connect.
+func listenPrivate(path string) (net.Listener, error) {
+	handoffUmask.Lock()
+	defer handoffUmask.Unlock()
+	mask := syscall.Umask(0o177)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
+
+// Serializes changes of the process umask for handoff sockets
+var handoffUmask sync.Mutex
+
+// Serve handoffs on the socket at path in the background, one successor
+// at a time. A successor gets the state saved under lock, which stays held
+// so nothing changes after the state is sent. Once the successor
+// acknowledges the state, the socket is removed for it to listen on and
+// done is called. Should the successor fail to take over within
+// handoffTimeout, the lock is released and the process carries on.
+func serveHandoff[T, O comparable](path string, lock sync.Locker, lb LoadBalancer[T, O], done func()) error {
+	ln, err := listenHandoff(path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				out.info("Handoff server stopped:", err)
+				return
+			}
+			lock.Lock()
+			if err := handOff(conn, lb); err != nil {
+				conn.Close()
+				lock.Unlock()
+				out.info("Handoff failed:", err)
+				continue
+			}
+
+			// The successor waits for the connection to close
+			ln.Close()
+			conn.Close()
+			done()
+			return
+		}
+	}()
+	return nil
+}
+
+// Send the state to a successor and wait for it to acknowledge it
+func handOff[T, O comparable](conn net.Conn, lb LoadBalancer[T, O]) error {
+	if err := conn.SetDeadline(time.Now().Add(handoffTimeout)); err != nil {
+		return err
+	}
+	if err := lb.Save(conn); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("waiting for the successor: %w", err)
+	}
+	if ack := strings.TrimSpace(line); ack != handoffAck {
+		return fmt.Errorf("successor failed to take over: %s", ack)
+	}
+	return nil
+}
+
+// Take over the state of the process serving handoffs on the socket at
+// path, if there is one, loading it into the empty load balancer lb. Once
+// it returns true, the socket is free to serve handoffs on. Returns false
+// if no process is serving handoffs.
+func takeOver[T, O comparable](path string, lb LoadBalancer[T, O], newNode func(name T) serverpool.Node[T, O]) (bool, error) {
+	conn, err := net.Dial("unix", path)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if err := lb.Load(conn, newNode); err != nil {
+		fmt.Fprintln(conn, strings.ReplaceAll(err.Error(), "\n", " "))
+		return false, err
+	}
+	if _, err := fmt.Fprintln(conn, handoffAck); err != nil {
+		return false, err
+	}
+
+	// Wait for the process to let go of the socket
+	io.Copy(io.Discard, conn)
+	return true, nil
+}
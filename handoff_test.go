@@ -0,0 +1,163 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"serverpool"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandoff(t *testing.T) {
+	dir, err := os.MkdirTemp("", "handoff")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/lb.sock"
+	newNode := func(id string) serverpool.Node[string, string] {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+
+	old := NewLoadBalancer[string, string]()
+	nodes := []serverpool.Node[string, string]{newNode("node0"), newNode("node1"), newNode("node2")}
+	if _, err := old.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i := range 30 {
+		obj := &serverpool.Object[string, string]{Id: "obj" + strconv.Itoa(i)}
+		if _, err := old.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := old.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := old.(Drainer[string, string]).DrainNode(nodes[1], 2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := old.Rebalance(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if ok, err := takeOver(path, NewLoadBalancer[string, string](), newNode); ok || err != nil {
+		t.Fatalf("expected nothing to take over, got %v, %v", ok, err)
+	}
+	saved := out
+	out = &output{out: io.Discard, log: io.Discard}
+	defer func() { out = saved }()
+	savedTimeout := handoffTimeout
+	handoffTimeout = time.Second
+	defer func() { handoffTimeout = savedTimeout }()
+	var mu sync.Mutex
+	done := make(chan struct{})
+	if err := serveHandoff(path, &mu, old, func() { close(done) }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := serveHandoff(path, &mu, old, nil); err == nil {
+		t.Fatalf("expected an error serving handoffs twice on a socket")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a socket only its user may use, got %v, %v", info, err)
+	}
+
+	// A successor that never answers gives up the lock once the handoff
+	// times out
+	stalled, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := bufio.NewReader(stalled).ReadString('\n'); err != nil {
+		t.Fatalf("expected the state, got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !mu.TryLock() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the lock back after the handoff timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Unlock()
+	stalled.Close()
+
+	// A successor failing to load leaves the old process serving
+	busy := NewLoadBalancer[string, string]()
+	busy.AddNodes([]serverpool.Node[string, string]{newNode("node9")})
+	if ok, err := takeOver(path, busy, newNode); ok || err == nil {
+		t.Fatalf("expected an error loading into a load balancer with nodes, got %v", ok)
+	}
+	successor := NewLoadBalancer[string, string]()
+	if ok, err := takeOver(path, successor, newNode); !ok || err != nil {
+		t.Fatalf("expected to take over, got %v, %v", ok, err)
+	}
+	<-done
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket removed for the successor, got %v", err)
+	}
+	if mu.TryLock() {
+		t.Fatalf("expected the old process to keep the lock after handing off")
+	}
+
+	for i := range 200 {
+		key := "key" + strconv.Itoa(i)
+		want, _ := old.GetNode(key)
+		if got, err := successor.GetNode(key); err != nil || got.Name() != want.Name() {
+			t.Fatalf("expected %s on %v, got %v, %v", key, want, got, err)
+		}
+	}
+	want, got := old.(Drainer[string, string]).DrainStats()["node1"], successor.(Drainer[string, string]).DrainStats()["node1"]
+	if got.Remaining == 0 || got.Total != want.Total || got.Moved != want.Moved || got.Remaining != want.Remaining ||
+		got.Batch != 2 || !got.Started.Equal(want.Started) {
+		t.Fatalf("expected the drain to carry on as %+v, got %+v", want, got)
+	}
+	if _, err := successor.Rebalance(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if successor.(Drainer[string, string]).DrainStats()["node1"].Remaining != want.Remaining-2 {
+		t.Fatalf("expected the drain to move on, got %+v", successor.(Drainer[string, string]).DrainStats())
+	}
+}
+
+func TestListenHandoff(t *testing.T) {
+	dir, err := os.MkdirTemp("", "handoff")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/lb.sock"
+
+	// The socket is private even when the umask lets anyone in
+	mask := syscall.Umask(0)
+	defer syscall.Umask(mask)
+	ln, err := listenHandoff(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a socket only its user may use, got %v, %v", info, err)
+	}
+	if got := syscall.Umask(0); got != 0 {
+		t.Fatalf("expected the umask restored, got %o", got)
+	}
+
+	// The socket of a process that is gone is replaced
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listenHandoff(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer ln.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a socket only its user may use, got %v, %v", info, err)
+	}
+}
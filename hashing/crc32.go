@@ -15,8 +15,17 @@ func crc32Hasher() Hasher {
 	return &crc32Hash{}
 }
 
-func (c *crc32Hash) hash(bytes []byte) uint64 {
-	h := crc32.NewIEEE()
-	h.Write(bytes)
-	return uint64(h.Sum32())
+func (c *crc32Hash) Hash(bytes []byte) uint64 {
+	return uint64(crc32.ChecksumIEEE(bytes))
+}
+
+func (c *crc32Hash) HashWithSeed(bytes []byte, seed uint64) uint64 {
+	crc := ^crc32.Update(0, crc32.IEEETable, bytes)
+
+	// Extend the checksum with the big endian seed one byte at a time
+	// so the seed never has to be copied next to the input
+	for shift := 56; shift >= 0; shift -= 8 {
+		crc = crc32.IEEETable[byte(crc)^byte(seed>>shift)] ^ (crc >> 8)
+	}
+	return uint64(^crc)
 }
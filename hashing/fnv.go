@@ -0,0 +1,44 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Provides FNV-1a hashing functions.
+package hashing
+
+// Parameters of the 64 bit FNV-1a hash, as in hash/fnv
+const (
+	fnvOffset64 uint64 = 14695981039346656037
+	fnvPrime64  uint64 = 1099511628211
+)
+
+type fnvHash struct{}
+
+func fnvHasher() Hasher {
+	return &fnvHash{}
+}
+
+// 64 bit FNV-1a of the bytes, computed inline since the hash.Hash of
+// hash/fnv would allocate on every call
+func (f *fnvHash) Hash(bytes []byte) uint64 {
+	return fnvUpdate(fnvOffset64, bytes)
+}
+
+func (f *fnvHash) HashWithSeed(bytes []byte, seed uint64) uint64 {
+	h := fnvUpdate(fnvOffset64, bytes)
+
+	// Extend the hash with the big endian seed one byte at a time
+	// so the seed never has to be copied next to the input
+	for shift := 56; shift >= 0; shift -= 8 {
+		h ^= uint64(byte(seed >> shift))
+		h *= fnvPrime64
+	}
+	return h
+}
+
+func fnvUpdate(h uint64, bytes []byte) uint64 {
+	for _, c := range bytes {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return h
+}
@@ -8,6 +8,8 @@ package hashing
 
 import (
 	"encoding/binary"
+	"fmt"
+	"unsafe"
 )
 
 type HashAlgorithm int
@@ -16,12 +18,25 @@ const (
 	CRC32 HashAlgorithm = iota
 	MD5
 	SHA256
+
+	// xxHash64 with seed 0, fast with a good distribution of similar keys
+	XXHash64
+
+	// 64 bit FNV-1a, simple and fast on short keys
+	FNV1a
 )
 
+// Custom is the algorithm of hash functions created from a Hasher of the
+// caller. It has no name ParseHashAlgorithm accepts, since the hasher
+// cannot be recreated from it.
+const Custom HashAlgorithm = -1
+
 var hashAlgorithmNames = map[HashAlgorithm]string{
-	CRC32:  "crc32",
-	MD5:    "md5",
-	SHA256: "sha256",
+	CRC32:    "crc32",
+	MD5:      "md5",
+	SHA256:   "sha256",
+	XXHash64: "xxhash64",
+	FNV1a:    "fnv1a",
 }
 
 const (
@@ -29,45 +44,95 @@ const (
 	DefaultHashAlgorithm = CRC32
 )
 
+// Hasher is a 64 bit hash function. Implement it to hash keys with a hash
+// of your own, such as SipHash with a secret key against hash flooding,
+// and see NewHashFunctionFromHasher. Hashers must not modify or retain the
+// bytes, which may be the bytes of a string.
 type Hasher interface {
-	// Hash generates a hash value for a given byte slice and seed
-	hash(bytes []byte) uint64
-
-	// // HashString generates a hash value for a given string
-	// HashString(input string) uint64
+	// Hash generates a hash value for a given byte slice
+	Hash(bytes []byte) uint64
 
-	// // HashStringWithSeed generates a hash value for a given string and seed
-	// HashStringWithSeed(input string, seed int) uint64
+	// HashWithSeed generates the hash value of the byte slice followed by
+	// the big endian encoding of seed. It is called repeatedly while
+	// following replacement chains, so it should not allocate.
+	HashWithSeed(bytes []byte, seed uint64) uint64
 }
 
 type HashFn struct {
 	hashAlgo HashAlgorithm
 	Hasher
-}
 
-func (h HashFn) Hash(bytes []byte) uint64 {
-	return h.hash(bytes)
+	// Seed of the instance, 0 if unseeded, and the seeds derived from it
+	// that HashString and HashStringWithSeed mix in
+	seed                uint64
+	stringSeed, xorSeed uint64
 }
 
 // HashString generates a hash value for a given string using the configured algorithm
 func (h HashFn) HashString(input string) uint64 {
-	return h.hash([]byte(input))
+	if h.seed != 0 {
+		return h.HashWithSeed(stringBytes(input), h.stringSeed)
+	}
+	return h.Hash(stringBytes(input))
 }
 
-// HashStringWithSeed generates a hash value for a given string and seed using the configured algorithm
+// HashStringWithSeed generates a hash value for a given string and seed using the configured algorithm.
+// This is called repeatedly while following replacement chains, so it must not allocate.
 func (h HashFn) HashStringWithSeed(input string, seed int) uint64 {
-	strBytes := []byte(input)
+	return h.HashWithSeed(stringBytes(input), uint64(seed)^h.xorSeed)
+}
 
-	seedBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(seedBytes, uint64(seed))
+// View the bytes of a string without copying.
+// Hashers only read their input so the string is never modified.
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
 
-	combined := append(strBytes, seedBytes...)
+// seedBuffer holds a short input followed by a seed on the stack
+type seedBuffer [64]byte
 
-	return h.hash(combined)
+// Return the bytes followed by the big endian seed, using the buffer when they fit
+func (b *seedBuffer) append(bytes []byte, seed uint64) []byte {
+	combined := b[:0]
+	if len(bytes)+8 > len(b) {
+		combined = make([]byte, 0, len(bytes)+8)
+	}
+	combined = append(combined, bytes...)
+	return binary.BigEndian.AppendUint64(combined, seed)
 }
 
 func (h HashFn) String() string {
-	return hashAlgorithmNames[h.hashAlgo]
+	return h.hashAlgo.String()
+}
+
+// Algorithm of the hash function
+func (h HashFn) Algorithm() HashAlgorithm {
+	return h.hashAlgo
+}
+
+// Seed of the hash function, 0 if unseeded
+func (h HashFn) Seed() uint64 {
+	return h.seed
+}
+
+func (a HashAlgorithm) String() string {
+	if name, ok := hashAlgorithmNames[a]; ok {
+		return name
+	}
+	if a == Custom {
+		return "custom"
+	}
+	return fmt.Sprintf("HashAlgorithm(%d)", int(a))
+}
+
+// ParseHashAlgorithm returns the algorithm with the given name
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	for algo, n := range hashAlgorithmNames {
+		if n == name {
+			return algo, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash algorithm %q", name)
 }
 
 func NewHashFunction(algorithm HashAlgorithm) HashFn {
@@ -79,8 +144,55 @@ func NewHashFunction(algorithm HashAlgorithm) HashFn {
 		hasher = md5Hasher()
 	case SHA256:
 		hasher = sha256Hasher()
+	case XXHash64:
+		hasher = xxHasher()
+	case FNV1a:
+		hasher = fnvHasher()
 	default:
 		hasher = crc32Hasher()
 	}
 	return HashFn{hashAlgo: algorithm, Hasher: hasher}
 }
+
+// NewHashFunctionFromHasher creates a hash function hashing with h, whose
+// algorithm is Custom
+func NewHashFunctionFromHasher(h Hasher) HashFn {
+	return HashFn{hashAlgo: Custom, Hasher: h}
+}
+
+// NewHashFunctionWithSeed creates a hash function of the algorithm whose
+// string hashes depend on seed, so that load balancers of different tenants
+// map the same keys independently. With the first two outputs s1 and s2 of
+// SplitMix64 seeded with seed, HashString(key) is the hash of key followed
+// by the big endian s1 and HashStringWithSeed(key, n) is the hash of key
+// followed by the big endian n XOR s2. Seed 0 hashes as NewHashFunction.
+func NewHashFunctionWithSeed(algorithm HashAlgorithm, seed uint64) HashFn {
+	return NewHashFunction(algorithm).WithSeed(seed)
+}
+
+// WithSeed returns the hash function seeded with seed, see
+// NewHashFunctionWithSeed
+func (h HashFn) WithSeed(seed uint64) HashFn {
+	h.seed, h.stringSeed, h.xorSeed = seed, 0, 0
+	if seed != 0 {
+		state := seed
+		h.stringSeed, h.xorSeed = splitMix64(&state), splitMix64(&state)
+	}
+	return h
+}
+
+// Next output of the SplitMix64 generator
+func splitMix64(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// MapBytes estimates the bytes used by a map with the given number of
+// entries of the given size, assuming the map is kept at most 7/8 full
+func MapBytes(entries int, entry uintptr) int {
+	const header = 48
+	return header + entries*(int(entry)+1)*8/7
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package hashing
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"testing"
+)
+
+var algorithms = []HashAlgorithm{CRC32, MD5, SHA256, XXHash64, FNV1a}
+
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{input: "", want: 0xef46db3751d8e999},
+		{input: "a", want: 0xd24ec4f1a98c6e5b},
+		{input: "abc", want: 0x44bc2cf5ad770999},
+		{input: "Nobody inspects the spammish repetition", want: 0xfbcea83c8a378bf1},
+	}
+	h := NewHashFunction(XXHash64)
+	for _, tt := range tests {
+		if got := h.HashString(tt.input); got != tt.want {
+			t.Errorf("HashString(%q) = %#x, want %#x", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFNV1a(t *testing.T) {
+	h := NewHashFunction(FNV1a)
+	for _, input := range []string{"", "a", "object-key-1234", string(make([]byte, 300))} {
+		want := fnv.New64a()
+		want.Write([]byte(input))
+		if got := h.HashString(input); got != want.Sum64() {
+			t.Errorf("HashString(%q) = %#x, want %#x", input, got, want.Sum64())
+		}
+	}
+}
+
+func TestHashStringWithSeed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		seed  int
+	}{
+		{name: "empty input", input: "", seed: 7},
+		{name: "short input", input: "testkey1", seed: 3},
+		{name: "negative seed", input: "testkey2", seed: -1},
+		{name: "long input", input: string(make([]byte, 300)), seed: 42},
+	}
+
+	for _, algo := range algorithms {
+		h := NewHashFunction(algo)
+		for _, tt := range tests {
+			t.Run(h.String()+"/"+tt.name, func(t *testing.T) {
+				// The seeded hash is the hash of the input followed by the big endian seed
+				combined := binary.BigEndian.AppendUint64([]byte(tt.input), uint64(tt.seed))
+				if got, want := h.HashStringWithSeed(tt.input, tt.seed), h.Hash(combined); got != want {
+					t.Errorf("HashStringWithSeed() = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkHashString(b *testing.B) {
+	for _, algo := range algorithms {
+		h := NewHashFunction(algo)
+		b.Run(h.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.HashString("object-key-1234")
+			}
+		})
+	}
+}
+
+func BenchmarkHashStringWithSeed(b *testing.B) {
+	for _, algo := range algorithms {
+		h := NewHashFunction(algo)
+		b.Run(h.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.HashStringWithSeed("object-key-1234", i)
+			}
+		})
+	}
+}
+
+func BenchmarkHashLongKey(b *testing.B) {
+	key := string(make([]byte, 1024))
+	for _, algo := range algorithms {
+		h := NewHashFunction(algo)
+		b.Run(h.String(), func(b *testing.B) {
+			b.SetBytes(int64(len(key)))
+			for i := 0; i < b.N; i++ {
+				h.HashString(key)
+			}
+		})
+	}
+}
+
+// Spread of similar keys over buckets, reported as the coefficient of
+// variation of the keys per bucket, lower is more even
+func BenchmarkDistribution(b *testing.B) {
+	const buckets, keys = 64, 64 * 1000
+	for _, algo := range algorithms {
+		h := NewHashFunction(algo)
+		b.Run(h.String(), func(b *testing.B) {
+			var counts [buckets]float64
+			for i := 0; i < b.N; i++ {
+				counts = [buckets]float64{}
+				for k := 0; k < keys; k++ {
+					counts[h.HashString("key-"+strconv.Itoa(k))%buckets]++
+				}
+			}
+			mean, variance := float64(keys)/buckets, 0.0
+			for _, c := range counts {
+				variance += (c - mean) * (c - mean) / buckets
+			}
+			b.ReportMetric(math.Sqrt(variance)/mean, "cv")
+		})
+	}
+}
+
+// keyedHasher is FNV-1a of a secret key followed by the bytes
+type keyedHasher struct{ key []byte }
+
+func (k keyedHasher) Hash(bytes []byte) uint64 {
+	return fnvUpdate(fnvUpdate(fnvOffset64, k.key), bytes)
+}
+
+func (k keyedHasher) HashWithSeed(bytes []byte, seed uint64) uint64 {
+	return k.Hash(binary.BigEndian.AppendUint64(append([]byte(nil), bytes...), seed))
+}
+
+func TestNewHashFunctionFromHasher(t *testing.T) {
+	h := NewHashFunctionFromHasher(keyedHasher{key: []byte("secret")})
+	if h.Algorithm() != Custom || h.String() != "custom" {
+		t.Fatalf("expected the custom algorithm, got %v", h)
+	}
+	if _, err := ParseHashAlgorithm(h.String()); err == nil {
+		t.Fatalf("expected custom not to parse")
+	}
+	if h.HashString("key") == NewHashFunctionFromHasher(keyedHasher{key: []byte("other")}).HashString("key") {
+		t.Fatalf("expected the hash to depend on the key")
+	}
+	if got, want := h.HashStringWithSeed("key", 5), h.Hash(binary.BigEndian.AppendUint64([]byte("key"), 5)); got != want {
+		t.Fatalf("HashStringWithSeed() = %v, want %v", got, want)
+	}
+}
+
+func TestNewHashFunctionWithSeed(t *testing.T) {
+	for _, algo := range []HashAlgorithm{CRC32, MD5, SHA256, XXHash64, FNV1a} {
+		t.Run(algo.String(), func(t *testing.T) {
+			unseeded := NewHashFunction(algo)
+			if h := NewHashFunctionWithSeed(algo, 0); h.HashString("key") != unseeded.HashString("key") ||
+				h.HashStringWithSeed("key", 3) != unseeded.HashStringWithSeed("key", 3) {
+				t.Fatalf("expected seed 0 to hash as unseeded")
+			}
+
+			h := NewHashFunctionWithSeed(algo, 42)
+			if h.Seed() != 42 || h.Algorithm() != algo {
+				t.Fatalf("expected %v seeded with 42, got %v seeded with %d", algo, h.Algorithm(), h.Seed())
+			}
+			if h.HashString("key") == unseeded.HashString("key") {
+				t.Fatalf("expected the seed to change HashString")
+			}
+			if h.HashStringWithSeed("key", 3) == unseeded.HashStringWithSeed("key", 3) {
+				t.Fatalf("expected the seed to change HashStringWithSeed")
+			}
+			if h.HashString("key") == NewHashFunctionWithSeed(algo, 43).HashString("key") {
+				t.Fatalf("expected different seeds to hash differently")
+			}
+			if h.HashString("key") != NewHashFunction(algo).WithSeed(42).HashString("key") {
+				t.Fatalf("expected WithSeed to match NewHashFunctionWithSeed")
+			}
+		})
+	}
+}
@@ -16,9 +16,12 @@ func md5Hasher() Hasher {
 	return &md5Hash{}
 }
 
-func (m *md5Hash) hash(bytes []byte) uint64 {
-	h := md5.New()
-	h.Write(bytes)
-	sum := h.Sum(nil)
+func (m *md5Hash) Hash(bytes []byte) uint64 {
+	sum := md5.Sum(bytes)
 	return binary.BigEndian.Uint64(sum[:8])
 }
+
+func (m *md5Hash) HashWithSeed(bytes []byte, seed uint64) uint64 {
+	var buf seedBuffer
+	return m.Hash(buf.append(bytes, seed))
+}
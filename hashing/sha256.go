@@ -16,9 +16,12 @@ func sha256Hasher() Hasher {
 	return &sha256Hash{}
 }
 
-func (s *sha256Hash) hash(bytes []byte) uint64 {
-	h := sha256.New()
-	h.Write(bytes)
-	sum := h.Sum(nil)
+func (s *sha256Hash) Hash(bytes []byte) uint64 {
+	sum := sha256.Sum256(bytes)
 	return binary.BigEndian.Uint64(sum[:8])
 }
+
+func (s *sha256Hash) HashWithSeed(bytes []byte, seed uint64) uint64 {
+	var buf seedBuffer
+	return s.Hash(buf.append(bytes, seed))
+}
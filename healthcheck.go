@@ -0,0 +1,310 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Health checks taking nodes that fail their probes out of rotation and
+// putting them back once they pass again
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"serverpool"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults of HealthCheckConfig
+const (
+	DefaultHealthInterval     = 10 * time.Second
+	DefaultHealthTimeout      = 2 * time.Second
+	DefaultUnhealthyThreshold = 3
+	DefaultHealthyThreshold   = 2
+)
+
+// Prober probes a node, returning an error if it is down
+type Prober[T, O comparable] func(ctx context.Context, node serverpool.Node[T, O]) error
+
+// Address of a node to probe, its canonical name with port if not 0
+func probeAddr[T, O comparable](node serverpool.Node[T, O], port uint16) string {
+	if port == 0 {
+		return CanonicalName(node.Name())
+	}
+	return net.JoinHostPort(CanonicalName(node.Name()), strconv.Itoa(int(port)))
+}
+
+// TCPProber probes nodes by connecting to port on their address, or to
+// their name as an address if port is 0
+func TCPProber[T, O comparable](port uint16) Prober[T, O] {
+	return func(ctx context.Context, node serverpool.Node[T, O]) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", probeAddr(node, port))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPProber probes nodes with a GET of path on port of their address,
+// or on their name as an address if port is 0, expecting a 2xx response.
+// Uses http.DefaultClient if client is nil.
+func HTTPProber[T, O comparable](port uint16, path string, client *http.Client) Prober[T, O] {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return func(ctx context.Context, node serverpool.Node[T, O]) error {
+		url := "http://" + probeAddr(node, port) + path
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// IsHardFailure reports whether a probe error means the node is down rather
+// than slow or misbehaving: it refuses or resets connections, or cannot be
+// reached at all
+func IsHardFailure(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EHOSTUNREACH, syscall.ENETUNREACH} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseProber parses a probe, tcp or http[:<path>], against port
+func ParseProber[T, O comparable](spec string, port uint16) (Prober[T, O], error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "tcp":
+		if path != "" {
+			return nil, fmt.Errorf("unexpected path in tcp probe %q", spec)
+		}
+		return TCPProber[T, O](port), nil
+	case "http":
+		return HTTPProber[T, O](port, path, nil), nil
+	}
+	return nil, fmt.Errorf("invalid probe %q, expected tcp or http[:<path>]", spec)
+}
+
+// HealthCheckConfig configures the health checks of a pool
+type HealthCheckConfig[T, O comparable] struct {
+	// Probe of a node, required
+	Probe Prober[T, O]
+
+	// Time between rounds of probes and for each probe to answer,
+	// DefaultHealthInterval and DefaultHealthTimeout if 0
+	Interval, Timeout time.Duration
+
+	// Failed probes in a row before a node is taken out of rotation and
+	// passed probes in a row before it is put back,
+	// DefaultUnhealthyThreshold and DefaultHealthyThreshold if 0
+	UnhealthyThreshold, HealthyThreshold int
+
+	// Reports whether the error of a probe is a hard failure, which takes a
+	// node out even during the cool-down after a topology change,
+	// IsHardFailure if nil
+	HardFailure func(err error) bool
+
+	// Called for each node taken out of or put back into rotation, with the
+	// error of its last probe when taken out, if set
+	OnChange func(node serverpool.Node[T, O], healthy bool, err error)
+}
+
+// Probes in a row a node passed or failed
+type nodeHealth struct {
+	passed, failed int
+	err            error
+}
+
+// HealthChecker probes the nodes of a load balancer and removes the nodes
+// failing their probes, so that their buckets go away and their objects
+// are reassigned. It keeps probing the nodes it removed and adds them back
+// once they pass again. The last node in rotation is never removed, since
+// a failing node serves keys better than no node at all. During the
+// cool-down after a topology change only hard failures take nodes out, and
+// while automation is paused nodes are only probed.
+type HealthChecker[T, O comparable] struct {
+	config HealthCheckConfig[T, O]
+	lb     LoadBalancer[T, O]
+	lock   sync.Locker
+
+	// Probes in a row of each node in or out of rotation
+	health map[T]*nodeHealth
+
+	// Nodes taken out of rotation by name
+	out map[T]serverpool.Node[T, O]
+}
+
+// Create a health checker of the nodes of lb. Rounds hold lock, if not
+// nil, while reading or changing lb but not while probing.
+func NewHealthChecker[T, O comparable](lb LoadBalancer[T, O], lock sync.Locker, config HealthCheckConfig[T, O]) (*HealthChecker[T, O], error) {
+	if config.Probe == nil {
+		return nil, errors.New("health check needs a probe")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultHealthInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultHealthTimeout
+	}
+	if config.UnhealthyThreshold <= 0 {
+		config.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	if config.HealthyThreshold <= 0 {
+		config.HealthyThreshold = DefaultHealthyThreshold
+	}
+	if config.HardFailure == nil {
+		config.HardFailure = IsHardFailure
+	}
+	if lock == nil {
+		lock = &sync.Mutex{}
+	}
+	return &HealthChecker[T, O]{config: config, lb: lb, lock: lock,
+		health: make(map[T]*nodeHealth), out: make(map[T]serverpool.Node[T, O])}, nil
+}
+
+// Run probes the nodes every interval until ctx is done
+func (h *HealthChecker[T, O]) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		h.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check probes every node in and out of rotation once, in parallel, then
+// takes out the nodes that reached the unhealthy threshold and puts back
+// those that reached the healthy one, unless automation is paused. Nodes
+// that are only failing softly stay in during a cool-down. OnChange is
+// called with the lock held.
+func (h *HealthChecker[T, O]) Check(ctx context.Context) {
+	h.lock.Lock()
+	var nodes []serverpool.Node[T, O]
+	for node := range h.lb.Nodes() {
+		// Nodes added back by someone else are in rotation again
+		delete(h.out, node.Name())
+		nodes = append(nodes, node)
+	}
+	for _, node := range h.out {
+		nodes = append(nodes, node)
+	}
+	h.lock.Unlock()
+
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.config.Timeout)
+			defer cancel()
+			errs[i] = h.config.Probe(ctx, node)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	seen := make(map[T]bool, len(nodes))
+	for i, node := range nodes {
+		seen[node.Name()] = true
+		health, ok := h.health[node.Name()]
+		if !ok {
+			health = &nodeHealth{}
+			h.health[node.Name()] = health
+		}
+		if health.err = errs[i]; health.err != nil {
+			health.passed, health.failed = 0, health.failed+1
+		} else {
+			health.passed, health.failed = health.passed+1, 0
+		}
+	}
+
+	// Forget the nodes removed by someone else
+	for name := range h.health {
+		if !seen[name] {
+			delete(h.health, name)
+		}
+	}
+
+	if automationPaused(h.lb) {
+		return
+	}
+	for _, node := range nodes {
+		health := h.health[node.Name()]
+		_, out := h.out[node.Name()]
+		switch {
+		case out && health.passed >= h.config.HealthyThreshold:
+			h.restore(node)
+		case !out && health.failed >= h.config.UnhealthyThreshold && h.lb.NodeCount() > 1:
+			// Taking a node out starts a cool-down of its own
+			if h.config.HardFailure(health.err) || h.lb.ChurnStats().CoolDownUntil.IsZero() {
+				h.takeOut(node, health.err)
+			}
+		}
+	}
+}
+
+// Remove a failing node from the load balancer
+func (h *HealthChecker[T, O]) takeOut(node serverpool.Node[T, O], err error) {
+	if _, removeErr := h.lb.RemoveNodes([]serverpool.Node[T, O]{node}); removeErr != nil {
+		return
+	}
+	h.out[node.Name()] = node
+	if h.config.OnChange != nil {
+		h.config.OnChange(node, false, err)
+	}
+}
+
+// Add a node that passes its probes again back to the load balancer. Nodes
+// that cannot be added, such as quarantined ones, stay out for now.
+func (h *HealthChecker[T, O]) restore(node serverpool.Node[T, O]) {
+	if _, err := h.lb.AddNodes([]serverpool.Node[T, O]{node}); err != nil {
+		return
+	}
+	delete(h.out, node.Name())
+	if h.config.OnChange != nil {
+		h.config.OnChange(node, true, nil)
+	}
+}
+
+// Unhealthy returns the nodes taken out of rotation
+func (h *HealthChecker[T, O]) Unhealthy() []serverpool.Node[T, O] {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	nodes := make([]serverpool.Node[T, O], 0, len(h.out))
+	for _, node := range h.out {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"serverpool"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	obj := &serverpool.Object[string, string]{Id: "obj", Preferred: []string{"node2"}}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	down := map[string]bool{}
+	var changes []string
+	checker, err := NewHealthChecker(lb, nil, HealthCheckConfig[string, string]{
+		Probe: func(ctx context.Context, node serverpool.Node[string, string]) error {
+			if down[node.Name()] {
+				return errors.New("down")
+			}
+			return nil
+		},
+		UnhealthyThreshold: 2, HealthyThreshold: 2,
+		OnChange: func(node serverpool.Node[string, string], healthy bool, err error) {
+			changes = append(changes, fmt.Sprint(node.Name(), " ", healthy))
+		}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// A node is taken out after failing twice and its objects reassigned
+	down["node2"] = true
+	checker.Check(context.Background())
+	if lb.NodeCount() != 2 {
+		t.Fatalf("expected node2 to stay after one failure, got %d nodes", lb.NodeCount())
+	}
+	checker.Check(context.Background())
+	if lb.NodeCount() != 1 || *obj.Node() != node1 {
+		t.Fatalf("expected node2 out and obj on node1, got %d nodes and obj on %v", lb.NodeCount(), obj.Node())
+	}
+
+	// The last node in rotation stays even when failing
+	down["node1"] = true
+	checker.Check(context.Background())
+	checker.Check(context.Background())
+	if lb.NodeCount() != 1 {
+		t.Fatalf("expected the last node to stay, got %d nodes", lb.NodeCount())
+	}
+
+	// A node is put back after passing twice
+	down["node2"] = false
+	checker.Check(context.Background())
+	if unhealthy := checker.Unhealthy(); len(unhealthy) != 1 || unhealthy[0] != node2 {
+		t.Fatalf("expected node2 out after one pass, got %v", unhealthy)
+	}
+	checker.Check(context.Background())
+	if len(checker.Unhealthy()) != 0 {
+		t.Fatalf("expected node2 back in rotation, got %v", checker.Unhealthy())
+	}
+
+	// With node2 back, failing node1 goes out
+	checker.Check(context.Background())
+	want := []string{"node2 false", "node2 true", "node1 false"}
+	if !slices.Equal(changes, want) {
+		t.Fatalf("expected changes %v, got %v", want, changes)
+	}
+
+	// Nodes are only probed while automation is paused
+	if err := lb.PauseAutomation(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	down["node1"], down["node2"] = false, true
+	for range 3 {
+		checker.Check(context.Background())
+	}
+	if lb.NodeCount() != 1 || len(checker.Unhealthy()) != 1 {
+		t.Fatalf("expected nodes to stay as they were while paused, got %d nodes", lb.NodeCount())
+	}
+	if err := lb.ResumeAutomation(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	checker.Check(context.Background())
+	checker.Check(context.Background())
+	if lb.NodeCount() != 1 || checker.Unhealthy()[0] != node2 {
+		t.Fatalf("expected node1 back and node2 out after resuming, got %v out", checker.Unhealthy())
+	}
+}
+
+func TestHealthCheckerCoolDown(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithCoolDown[string, string](time.Minute)).(*loadBalancer[string, string])
+	now := time.Unix(0, 0)
+	lb.churn.now = func() time.Time { return now }
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode("node1"), newNode("node2"), newNode("node3")}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	errs := map[string]error{}
+	checker, err := NewHealthChecker(lb, nil, HealthCheckConfig[string, string]{
+		Probe: func(ctx context.Context, node serverpool.Node[string, string]) error {
+			return errs[node.Name()]
+		},
+		UnhealthyThreshold: 1, HealthyThreshold: 1})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Only nodes refusing connections go out during the cool-down
+	errs["node1"] = context.DeadlineExceeded
+	errs["node2"] = fmt.Errorf("dial: %w", syscall.ECONNREFUSED)
+	checker.Check(context.Background())
+	if unhealthy := checker.Unhealthy(); len(unhealthy) != 1 || unhealthy[0].Name() != "node2" {
+		t.Fatalf("expected only node2 out during the cool-down, got %v", unhealthy)
+	}
+
+	// Slow nodes go out once it is over
+	now = now.Add(time.Minute)
+	checker.Check(context.Background())
+	if lb.NodeCount() != 1 {
+		t.Fatalf("expected node1 out after the cool-down, got %d nodes", lb.NodeCount())
+	}
+}
+
+func TestProbers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	node := &mockNode{ID: server.Listener.Addr().String()}
+
+	probe, err := ParseProber[string, string]("http:/healthz", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := probe(context.Background(), node); err != nil {
+		t.Fatalf("expected the probe to pass, got %v", err)
+	}
+	if err := HTTPProber[string, string](0, "/missing", nil)(context.Background(), node); err == nil {
+		t.Fatalf("expected the probe to fail")
+	}
+	if err := TCPProber[string, string](0)(context.Background(), node); err != nil {
+		t.Fatalf("expected the probe to pass, got %v", err)
+	}
+	if _, err := ParseProber[string, string]("udp", 0); err == nil {
+		t.Fatalf("expected an error for an invalid probe")
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Reconstruction of past states from the change feed
+
+package main
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"serverpool"
+)
+
+// StateAt replays the changes up to version into a new read-only load
+// balancer created with the same options, for looking at how keys were
+// routed at that time. Its nodes stand in for the nodes of the load
+// balancer with the same names, so replaying leaves the real nodes alone,
+// and its objects only carry their ids. The moves the load balancer
+// deferred are deferred during the replay, as with a mirror, rather than
+// enforcing budgets and cool-downs again. Fails with ErrFeedTruncated once
+// the first changes are no longer retained, see WithFeedRetention.
+func (lb *loadBalancer[T, O]) StateAt(version uint64) (LoadBalancer[T, O], error) {
+	if version > lb.Version() {
+		return nil, fmt.Errorf("version %d is after the current version %d", version, lb.Version())
+	}
+	changes, err := lb.feed.since(0)
+	if err != nil {
+		return nil, err
+	}
+
+	past := NewLoadBalancerWithOptions(lb.opts...).(*loadBalancer[T, O])
+	past.readOnly = true
+
+	// Replaying must not call back into the application
+	past.prefetcher = prefetcher[T, O]{}
+	past.churn.alert = nil
+	past.drained = nil
+	past.decommissions.written = nil
+	past.profiler.metrics = nil
+	past.bucketStats = bucketStats{}
+	past.webhooks = nil
+	past.faults = nil
+
+	// The objects come from the replay, not from the object store
+	past.records = nil
+	past.objects = objectMap[T, O]{}
+	if past.cooperative != nil {
+		past.cooperative = &RebalanceCallbacks[T, O]{}
+	}
+
+	past.unwrapStandIns()
+	nodes := make(map[T]*pastNode[T, O])
+	for _, c := range changes[:version] {
+		past.apply(standIn(c, nodes))
+	}
+	return past, nil
+}
+
+// Replace the nodes of a change with stand-ins shared across changes
+func standIn[T, O comparable](c Change[T, O], nodes map[T]*pastNode[T, O]) Change[T, O] {
+	if len(c.Nodes) > 0 {
+		replaced := make([]serverpool.Node[T, O], len(c.Nodes))
+		for i, node := range c.Nodes {
+			n, ok := nodes[node.Name()]
+			if !ok {
+				n = &pastNode[T, O]{objects: make(map[O]*serverpool.Object[T, O])}
+				nodes[node.Name()] = n
+			}
+			n.node = node
+			replaced[i] = n
+		}
+		c.Nodes = replaced
+	}
+	if len(c.Changes) > 0 {
+		changes := make([]Change[T, O], len(c.Changes))
+		for i, change := range c.Changes {
+			changes[i] = standIn(change, nodes)
+		}
+		c.Changes = changes
+	}
+	return c
+}
+
+// Call the functions of the options that are given nodes with the nodes
+// stand-ins stand in for, so they place objects as they do for those nodes
+func (lb *loadBalancer[T, O]) unwrapStandIns() {
+	if of := lb.tiers.of; of != nil {
+		lb.tiers.of = func(node serverpool.Node[T, O]) string { return of(standingIn(node)) }
+	}
+	if of := lb.domains.of; of != nil {
+		lb.domains.of = func(node serverpool.Node[T, O]) FailureDomain { return of(standingIn(node)) }
+	}
+	if lb.constraints != nil {
+		constraints := make(map[string]PlacementConstraint[T, O], len(lb.constraints))
+		for name, c := range lb.constraints {
+			constraints[name] = func(node serverpool.Node[T, O]) bool { return c(standingIn(node)) }
+		}
+		lb.constraints = constraints
+	}
+}
+
+// Node a stand-in stands in for, node itself if it is not a stand-in
+func standingIn[T, O comparable](node serverpool.Node[T, O]) serverpool.Node[T, O] {
+	if n, ok := node.(*pastNode[T, O]); ok {
+		return n.node
+	}
+	return node
+}
+
+// Stand-in for a node of another load balancer, with objects of its own
+type pastNode[T, O comparable] struct {
+	// Node stood in for, the last one of its name
+	node    serverpool.Node[T, O]
+	objects map[O]*serverpool.Object[T, O]
+}
+
+func (n *pastNode[T, O]) Name() T {
+	return n.node.Name()
+}
+
+// Weight of the node stood in for, so it gets as many buckets
+func (n *pastNode[T, O]) Weight() int {
+	return serverpool.Weight(n.node)
+}
+
+func (n *pastNode[T, O]) AssignObject(obj *serverpool.Object[T, O]) {
+	n.objects[obj.Id] = obj
+}
+
+func (n *pastNode[T, O]) UnassignObject(obj *serverpool.Object[T, O]) {
+	delete(n.objects, obj.Id)
+}
+
+func (n *pastNode[T, O]) Objects() iter.Seq[*serverpool.Object[T, O]] {
+	return maps.Values(n.objects)
+}
+
+func (n *pastNode[T, O]) String() string {
+	return fmt.Sprint(n.node.Name())
+}
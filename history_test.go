@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestStateAt(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	nodes := []serverpool.Node[string, string]{newNode("node1"), newNode("node2"), newNode("node3")}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 20; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	version := lb.Version()
+	want := make(map[string]string)
+	for _, obj := range objs {
+		want[obj.Id] = (*obj.Node()).Name()
+	}
+	if _, err := lb.RemoveNodes(nodes[1:2]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	onNode1 := len(nodes[0].(*mockNode).objects)
+
+	past, err := lb.(Feeder[string, string]).StateAt(version)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !past.(Feeder[string, string]).ReadOnly() || past.Version() != version || past.NodeCount() != 3 {
+		t.Fatalf("expected a read-only state with 3 nodes at version %d", version)
+	}
+	for obj := range past.Objects() {
+		if got := (*obj.Node()).Name(); got != want[obj.Id] {
+			t.Fatalf("expected %v on %s, got %s", obj, want[obj.Id], got)
+		}
+	}
+	if len(nodes[0].(*mockNode).objects) != onNode1 {
+		t.Fatalf("expected replaying to leave the nodes alone")
+	}
+
+	if _, err := lb.(Feeder[string, string]).StateAt(lb.Version() + 1); err == nil {
+		t.Fatalf("expected an error for a future version")
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build integration
+
+// End-to-end tests against real HTTP backends, run with
+// go test -tags integration -run Integration
+//
+// Every backend is a process of its own, the test binary serving HTTP, so
+// killing a backend kills its process the way a container would be killed
+// and needs nothing but Go to run. The proxy tests put the reverse proxy
+// of proxy mode in front of the backends.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"proxy"
+	"serverpool"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Environment variable telling the test binary to serve as a backend
+const backendEnv = "LOADBALANCE_INTEGRATION_BACKEND"
+
+// TestIntegrationBackend is the backend process, answering every request
+// with the address it serves on until it is killed
+func TestIntegrationBackend(t *testing.T) {
+	if os.Getenv(backendEnv) == "" {
+		t.Skip("only runs as a backend process")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	addr := ln.Addr().String()
+	fmt.Println(addr)
+	http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, addr)
+	}))
+}
+
+// HTTP backend process
+type backend struct {
+	cmd  *exec.Cmd
+	addr string
+	node *mockNode
+}
+
+func startBackends(t *testing.T, n int) []*backend {
+	t.Helper()
+	backends := make([]*backend, n)
+	for i := range backends {
+		b := &backend{cmd: exec.Command(os.Args[0], "-test.run=^TestIntegrationBackend$")}
+		b.cmd.Env = append(os.Environ(), backendEnv+"=1")
+		stdout, err := b.cmd.StdoutPipe()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := b.cmd.Start(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		t.Cleanup(b.kill)
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected the address of the backend, got %v", err)
+		}
+		b.addr = line[:len(line)-1]
+		b.node = &mockNode{ID: b.addr, objects: make(map[string]*serverpool.Object[string, string])}
+		backends[i] = b
+	}
+	return backends
+}
+
+// Kill the process of the backend
+func (b *backend) kill() {
+	if b.cmd.ProcessState == nil {
+		b.cmd.Process.Kill()
+		b.cmd.Wait()
+	}
+}
+
+// Send a request for obj to the node it is assigned to and check that node
+// answered
+func request(obj *serverpool.Object[string, string]) error {
+	node := *obj.Node()
+	body, err := get("http://"+node.Name()+"/"+obj.Name(), "")
+	if err != nil {
+		return err
+	}
+	if body != node.Name() {
+		return fmt.Errorf("request for %v sent to %s answered by %s", obj, node.Name(), body)
+	}
+	return nil
+}
+
+// Get url with the key header if key is not empty and return the body of
+// a successful response
+func get(url, key string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if key != "" {
+		req.Header.Set("X-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return string(body), nil
+}
+
+func setupIntegration(t *testing.T) (LoadBalancer[string, string], []*backend, []*serverpool.Object[string, string]) {
+	t.Helper()
+	lb := NewLoadBalancer[string, string]()
+	backends := startBackends(t, 4)
+	var nodes []serverpool.Node[string, string]
+	for _, b := range backends {
+		nodes = append(nodes, b.node)
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 200; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := request(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	return lb, backends, objs
+}
+
+func TestIntegrationFailover(t *testing.T) {
+	lb, backends, objs := setupIntegration(t)
+
+	// Kill a backend and remove its node on the first failed request, as
+	// health checking would
+	dead := backends[1]
+	dead.kill()
+	failed := 0
+	for _, obj := range objs {
+		if err := request(obj); err != nil {
+			if *obj.Node() != serverpool.Node[string, string](dead.node) {
+				t.Fatalf("expected only requests to the dead backend to fail, got %v", err)
+			}
+			failed++
+			if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{dead.node}); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := request(obj); err != nil {
+				t.Fatalf("expected failover to a live backend, got %v", err)
+			}
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("expected one failed request before failover, got %d", failed)
+	}
+
+	for _, obj := range objs {
+		if err := request(obj); err != nil {
+			t.Fatalf("expected every request to succeed after failover, got %v", err)
+		}
+	}
+}
+
+func TestIntegrationDrain(t *testing.T) {
+	lb, backends, objs := setupIntegration(t)
+
+	draining := backends[2]
+	if err := lb.DrainNode(draining.node, 10); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Every request succeeds while objects move off the draining backend
+	for len(lb.DrainStats()) > 0 {
+		if _, err := lb.Rebalance(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, obj := range objs {
+			if err := request(obj); err != nil {
+				t.Fatalf("expected no failed requests during the drain, got %v", err)
+			}
+		}
+	}
+
+	// Nothing is sent to the backend once it is drained
+	draining.kill()
+	for _, obj := range objs {
+		if err := request(obj); err != nil {
+			t.Fatalf("expected no requests to the drained backend, got %v", err)
+		}
+	}
+}
+
+// Start proxy mode in front of the backends, returning the load balancer it
+// routes with, the URL of the proxy and the node of each backend
+func setupProxy(t *testing.T, backends []*backend) (LoadBalancer[netip.Addr, int], string, map[string]serverpool.Node[netip.Addr, int]) {
+	t.Helper()
+	lb := NewConcurrentLoadBalancer[netip.Addr, int]()
+	nodes := make(map[string]serverpool.Node[netip.Addr, int])
+	for i, b := range backends {
+		node := NewBackendServerNode[int](netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 1)}), &url.URL{Scheme: "http", Host: b.addr})
+		if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&node}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		nodes[b.addr] = &node
+	}
+	front := httptest.NewServer(proxy.NewHandler(&proxyRouter{lb: lb}, proxy.HeaderKey("X-Key")))
+	t.Cleanup(front.Close)
+	return lb, front.URL, nodes
+}
+
+// Send a request for key through the proxy and return the backend that
+// answered
+func proxyRequest(front, key string) (string, error) {
+	return get(front+"/"+key, key)
+}
+
+func TestIntegrationProxyFailover(t *testing.T) {
+	backends := startBackends(t, 4)
+	lb, front, nodes := setupProxy(t, backends)
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		addr, err := proxyRequest(front, keys[i])
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if node, _ := lb.GetNode(keys[i]); nodes[addr] != node {
+			t.Fatalf("expected %s proxied to %v, answered by %s", keys[i], node, addr)
+		}
+	}
+
+	// Requests to a killed backend fail until health checking takes its
+	// node out
+	dead := backends[1]
+	dead.kill()
+	failed := 0
+	for _, key := range keys {
+		if _, err := proxyRequest(front, key); err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Fatalf("expected requests to the killed backend to fail")
+	}
+	checker, err := NewHealthChecker(lb, nil, HealthCheckConfig[netip.Addr, int]{
+		Probe: func(ctx context.Context, node serverpool.Node[netip.Addr, int]) error {
+			_, err := get(node.(interface{ Backend() *url.URL }).Backend().String(), "")
+			return err
+		},
+		Timeout: time.Second, UnhealthyThreshold: 1})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	checker.Check(context.Background())
+	if unhealthy := checker.Unhealthy(); len(unhealthy) != 1 || unhealthy[0] != nodes[dead.addr] {
+		t.Fatalf("expected the node of the killed backend out, got %v", unhealthy)
+	}
+
+	for _, key := range keys {
+		addr, err := proxyRequest(front, key)
+		if err != nil {
+			t.Fatalf("expected every request to succeed after failover, got %v", err)
+		}
+		if addr == dead.addr {
+			t.Fatalf("expected no requests to the killed backend, got %s", key)
+		}
+	}
+}
+
+func TestIntegrationProxyDrain(t *testing.T) {
+	backends := startBackends(t, 4)
+	lb, front, nodes := setupProxy(t, backends)
+	var objs []*serverpool.Object[netip.Addr, int]
+	for i := range 200 {
+		objs = append(objs, &serverpool.Object[netip.Addr, int]{Id: i})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	draining := backends[2]
+	if err := lb.DrainNode(nodes[draining.addr], 10); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Every request succeeds while the objects of the backend move off it,
+	// and none reaches it
+	if len(lb.DrainStats()) == 0 {
+		t.Fatalf("expected the backend to drain in batches")
+	}
+	for len(lb.DrainStats()) > 0 {
+		if _, err := lb.Rebalance(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for i := range 200 {
+			addr, err := proxyRequest(front, "key"+strconv.Itoa(i))
+			if err != nil {
+				t.Fatalf("expected no failed requests during the drain, got %v", err)
+			}
+			if addr == draining.addr {
+				t.Fatalf("expected no requests to the draining backend")
+			}
+		}
+	}
+
+	// Nothing fails once the drained backend is killed
+	draining.kill()
+	for i := range 200 {
+		if _, err := proxyRequest(front, "key"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("expected no requests to the drained backend, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Key canonicalization applied before hashing
+
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// KeyNormalizer maps a key to its canonical form before it is hashed.
+// Keys that normalize to the same string always map to the same node.
+type KeyNormalizer func(key string) string
+
+// TrimKey removes leading and trailing white space from the key
+func TrimKey(key string) string {
+	return strings.TrimSpace(key)
+}
+
+// LowercaseKey maps all Unicode letters in the key to lower case.
+// The mapping does not depend on the process locale.
+func LowercaseKey(key string) string {
+	return strings.ToLower(key)
+}
+
+// FoldKey folds the case of the key with Unicode full case folding, so
+// that keys differing only in case are equal. Folding is not lowercasing:
+// it can change the length of a key, e.g. "Straße" folds to "strasse".
+// The folding does not depend on the process locale.
+func FoldKey(key string) string {
+	// A Caser keeps state, so one is not shared between goroutines
+	return cases.Fold().String(key)
+}
+
+// NFCKey converts the key to Unicode normalization form C so that
+// composed and decomposed spellings of the same text are equal
+func NFCKey(key string) string {
+	return norm.NFC.String(key)
+}
+
+// CanonicalKey trims, NFC-normalizes and case folds the key with
+// FoldKey. Folding is only consistent for normalized text and can itself
+// decompose characters, so the key is normalized again afterwards.
+func CanonicalKey(key string) string {
+	return NFCKey(FoldKey(NFCKey(TrimKey(key))))
+}
+
+// ChainKeyNormalizers applies the given normalizers in order
+func ChainKeyNormalizers(normalizers ...KeyNormalizer) KeyNormalizer {
+	return func(key string) string {
+		for _, normalize := range normalizers {
+			key = normalize(key)
+		}
+		return key
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"serverpool"
+	"testing"
+)
+
+func TestGetNodeKeyNormalizer(t *testing.T) {
+	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
+	ch := &mockConsistentHasher{}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch, normalize: CanonicalKey}
+
+	nodes := []serverpool.Node[string, string]{
+		&mockNode{ID: "node1"},
+		&mockNode{ID: "node2"},
+		&mockNode{ID: "node3"},
+	}
+
+	_, err := lb.AddNodes(nodes)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	want, err := lb.GetNode("café")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, key := range []string{"Café", " CAFÉ ", "cafe\u0301"} {
+		got, err := lb.GetNode(key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got != want {
+			t.Fatalf("expected key %q to map to %v, got %v", key, want, got)
+		}
+	}
+
+	// A key that is only white space normalizes to the empty key
+	_, err = lb.GetNode("   ")
+	if err == nil || err.Error() != "key cannot be empty" {
+		t.Fatalf("expected 'key cannot be empty' error, got %v", err)
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+	}{
+		// Decomposed and precomposed spellings differing in case
+		{"E\u0301cole", "\u00e9COLE"},
+		{"\u00c5ngstr\u00f6m", "a\u030aNGSTRO\u0308M"},
+		{"STRASSE", "straße"},
+		{"ẞ", "ss"},
+		{"ΣΙΣ", "σις"},
+	} {
+		a, b := CanonicalKey(tt.a), CanonicalKey(tt.b)
+		if a != b {
+			t.Fatalf("expected %q and %q to be equal, got %q and %q", tt.a, tt.b, a, b)
+		}
+	}
+}
+
+func TestFoldKey(t *testing.T) {
+	for _, tt := range []struct {
+		key, lower, folded string
+	}{
+		{"Straße", "straße", "strasse"},
+		{"ΣΊΣΥΦΟΣ", "σίσυφοσ", "σίσυφοσ"},
+		{"Key", "key", "key"},
+	} {
+		if got := LowercaseKey(tt.key); got != tt.lower {
+			t.Fatalf("LowercaseKey(%q) = %q, want %q", tt.key, got, tt.lower)
+		}
+		if got := FoldKey(tt.key); got != tt.folded {
+			t.Fatalf("FoldKey(%q) = %q, want %q", tt.key, got, tt.folded)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Keys pinned to a node regardless of hashing
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"serverpool"
+)
+
+// Normalize a key to pin, as lookups normalize theirs
+func (lb *loadBalancer[T, O]) pinKey(key string) (string, error) {
+	if lb.normalize != nil {
+		key = lb.normalize(key)
+	}
+	if len(key) == 0 {
+		return "", errors.New("key cannot be empty")
+	}
+	return key, nil
+}
+
+// PinKey maps key to node regardless of hashing, overriding the prefix pins
+// too, until UnpinKey. The node must be in the pool. While it is not, such
+// as after it is removed, the key maps as if it was not pinned. Objects of
+// the key already assigned move on the next RebalanceAll. The pin is
+// published to the change feed, so mirrors and past states route the key
+// the same way.
+func (lb *loadBalancer[T, O]) PinKey(key string, node serverpool.Node[T, O]) error {
+	key, err := lb.pinKey(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := lb.lookupNode(node); !ok {
+		return fmt.Errorf("%v not found", node)
+	}
+	if lb.dryRun {
+		return nil
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	node, _ = lb.lookupNode(node)
+	lb.pinKeyTo(key, node)
+	return nil
+}
+
+// Pin a normalized key to node and publish it
+func (lb *loadBalancer[T, O]) pinKeyTo(key string, node serverpool.Node[T, O]) {
+	if lb.keyPins == nil {
+		lb.keyPins = make(map[string]T)
+	}
+	lb.keyPins[key] = node.Name()
+	lb.keysMoved()
+	lb.record(Change[T, O]{Op: ChangePinKey, Nodes: []serverpool.Node[T, O]{node}, Keys: []string{key}})
+}
+
+// UnpinKey maps a key pinned by PinKey by hashing again. Objects of the key
+// already assigned move back on the next RebalanceAll.
+func (lb *loadBalancer[T, O]) UnpinKey(key string) error {
+	key, err := lb.pinKey(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := lb.keyPins[key]; !ok {
+		return fmt.Errorf("key %q is not pinned", key)
+	}
+	if lb.dryRun {
+		return nil
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	lb.unpinKey(key)
+	return nil
+}
+
+// Unpin a normalized key and publish it
+func (lb *loadBalancer[T, O]) unpinKey(key string) {
+	delete(lb.keyPins, key)
+	lb.keysMoved()
+	lb.record(Change[T, O]{Op: ChangeUnpinKey, Keys: []string{key}})
+}
+
+// Leave the moves of assigned objects whose key changed node for
+// RebalanceAll
+func (lb *loadBalancer[T, O]) keysMoved() {
+	if lb.objects.len() > 0 {
+		lb.stale = true
+	}
+}
+
+// PinnedKeys returns the keys pinned by PinKey and their nodes, including
+// pins to nodes no longer in the pool
+func (lb *loadBalancer[T, O]) PinnedKeys() map[string]T {
+	return maps.Clone(lb.keyPins)
+}
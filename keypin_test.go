@@ -0,0 +1,178 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestPinKey(t *testing.T) {
+	newNode := func(id string) serverpool.Node[string, string] {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	lb := NewLoadBalancerWithOptions(WithPrefixPin[string, string]("debug/", "node1"))
+	var nodes []serverpool.Node[string, string]
+	for i := range 4 {
+		nodes = append(nodes, newNode(fmt.Sprintf("node%d", i)))
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Pick a key that hashes elsewhere than node3
+	key := ""
+	for i := 0; key == ""; i++ {
+		if node, _ := lb.GetNode(fmt.Sprintf("key%d", i)); node.Name() != "node3" {
+			key = fmt.Sprintf("key%d", i)
+		}
+	}
+	hashed, _ := lb.GetNode(key)
+	if err := lb.(Pinner[string, string]).PinKey(key, nodes[3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node, err := lb.GetNode(key); err != nil || node.Name() != "node3" {
+		t.Fatalf("expected %s pinned to node3, got %v, %v", key, node, err)
+	}
+
+	// Key pins override prefix pins
+	if err := lb.(Pinner[string, string]).PinKey("debug/tenant", nodes[2]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node, _ := lb.GetNode("debug/tenant"); node.Name() != "node2" {
+		t.Fatalf("expected debug/tenant on node2, got %v", node)
+	}
+	if err := lb.(Pinner[string, string]).PinKey("other", newNode("node9")); err == nil {
+		t.Fatalf("expected an error pinning to a node not in the pool")
+	}
+	if err := lb.(Pinner[string, string]).UnpinKey("other"); err == nil {
+		t.Fatalf("expected an error unpinning a key that is not pinned")
+	}
+
+	// Pins persist in the saved state
+	var saved bytes.Buffer
+	if err := lb.Save(&saved); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	restored := NewLoadBalancerWithOptions(WithPrefixPin[string, string]("debug/", "node1"))
+	if err := restored.Load(bytes.NewReader(saved.Bytes()), newNode); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if pins := restored.(Pinner[string, string]).PinnedKeys(); len(pins) != 2 || pins[key] != "node3" || pins["debug/tenant"] != "node2" {
+		t.Fatalf("expected 2 pins restored, got %v", pins)
+	}
+	if node, _ := restored.GetNode(key); node.Name() != "node3" {
+		t.Fatalf("expected %s on node3 after load, got %v", key, node)
+	}
+
+	// Keys pinned to a removed node map by hashing until it is back
+	if _, err := lb.RemoveNodes(nodes[3:]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node, _ := lb.GetNode(key); node.Name() != hashed.Name() {
+		t.Fatalf("expected %s on %v, got %v", key, hashed, node)
+	}
+	if _, err := lb.AddNodes(nodes[3:]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node, _ := lb.GetNode(key); node.Name() != "node3" {
+		t.Fatalf("expected %s back on node3, got %v", key, node)
+	}
+
+	if err := lb.(Pinner[string, string]).UnpinKey(key); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node, _ := lb.GetNode(key); node.Name() != hashed.Name() {
+		t.Fatalf("expected %s on %v once unpinned, got %v", key, hashed, node)
+	}
+}
+
+func TestPinKeyAssigned(t *testing.T) {
+	newNode := func(id string) serverpool.Node[string, string] {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	lb := NewLoadBalancer[string, string]()
+	var nodes []serverpool.Node[string, string]
+	for i := range 4 {
+		nodes = append(nodes, newNode(fmt.Sprintf("node%d", i)))
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	mirror, err := NewMirrorLoadBalancer(lb)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Assign an object whose key hashes elsewhere than node3
+	var obj *serverpool.Object[string, string]
+	for i := 0; obj == nil; i++ {
+		if node, _ := lb.GetNode(fmt.Sprintf("obj%d", i)); node.Name() != "node3" {
+			obj = &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)}
+		}
+	}
+	hashed, _ := lb.GetNode(obj.Id)
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	before := lb.Version()
+
+	// The object stays put until RebalanceAll, which Verify allows for
+	if err := lb.(Pinner[string, string]).PinKey(obj.Id, nodes[3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.Topology(); !errors.Is(err, ErrNotExportable) {
+		t.Fatalf("expected ErrNotExportable while a key is pinned, got %v", err)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.RebalanceAll(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := obj.Node(); n == nil || (*n).Name() != "node3" {
+		t.Fatalf("expected %v moved to node3, got %v", obj, n)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Mirrors and past states replay the pin from the feed
+	if node, _ := mirror.GetNode(obj.Id); node.Name() != "node3" {
+		t.Fatalf("expected %s pinned to node3 on the mirror, got %v", obj.Id, node)
+	}
+	past, err := lb.(Feeder[string, string]).StateAt(before)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node, _ := past.GetNode(obj.Id); node.Name() != hashed.Name() {
+		t.Fatalf("expected %s on %v before the pin, got %v", obj.Id, hashed, node)
+	}
+
+	if err := lb.(Pinner[string, string]).UnpinKey(obj.Id); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.RebalanceAll(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := obj.Node(); n == nil || (*n).Name() != hashed.Name() {
+		t.Fatalf("expected %v back on %v, got %v", obj, hashed, n)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node, _ := mirror.GetNode(obj.Id); node.Name() != hashed.Name() {
+		t.Fatalf("expected %s unpinned on the mirror, got %v", obj.Id, node)
+	}
+	if _, err := lb.Topology(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
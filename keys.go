@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Lookups of binary keys, hashed as they are instead of formatted as strings
+
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"serverpool"
+	"unsafe"
+)
+
+// KeyMarshaler is a key with a binary form. A key maps to the node of the
+// string of its bytes.
+type KeyMarshaler interface {
+	// Append the binary form of the key to b
+	AppendKey(b []byte) []byte
+}
+
+// BytesKey is a key of raw bytes
+type BytesKey []byte
+
+func (k BytesKey) AppendKey(b []byte) []byte { return append(b, k...) }
+
+// Uint64Key is an integer key, in big endian order
+type Uint64Key uint64
+
+func (k Uint64Key) AppendKey(b []byte) []byte { return binary.BigEndian.AppendUint64(b, uint64(k)) }
+
+// UUIDKey is a UUID key, in its 16 bytes
+type UUIDKey [16]byte
+
+func (k UUIDKey) AppendKey(b []byte) []byte { return append(b, k[:]...) }
+
+// AddrKey is an IP address key, in its 4 or 16 bytes
+type AddrKey netip.Addr
+
+func (k AddrKey) AppendKey(b []byte) []byte {
+	if addr := netip.Addr(k); addr.Is4() {
+		a := addr.As4()
+		return append(b, a[:]...)
+	}
+	a := netip.Addr(k).As16()
+	return append(b, a[:]...)
+}
+
+// GetNodeFor gets the node of a binary key, appending it to a buffer on the
+// stack rather than formatting it
+func GetNodeFor[T, O comparable, K KeyMarshaler](lb LoadBalancer[T, O], key K) (serverpool.Node[T, O], error) {
+	var buf [64]byte
+	return lb.GetNodeBytes(key.AppendKey(buf[:0]))
+}
+
+// View the bytes of a key as a string without copying. Lookups only read
+// their key, so the bytes may be reused once the lookup returns.
+func keyString(key []byte) string {
+	return unsafe.String(unsafe.SliceData(key), len(key))
+}
+
+// GetNodeBytes gets the node of a binary key, the node of the string of
+// the same bytes, without copying the key
+func (lb *loadBalancer[T, O]) GetNodeBytes(key []byte) (serverpool.Node[T, O], error) {
+	return lb.GetNode(keyString(key))
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"serverpool"
+	"testing"
+)
+
+func TestGetNodeFor(t *testing.T) {
+	lb := NewConcurrentLoadBalancer[string, string]()
+	var nodes []serverpool.Node[string, string]
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for i := uint64(0); i < 100; i++ {
+		node, err := GetNodeFor(lb, Uint64Key(i))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		want, _ := lb.GetNode(string(binary.BigEndian.AppendUint64(nil, i)))
+		if node != want {
+			t.Fatalf("expected key %d on %v, got %v", i, want, node)
+		}
+	}
+
+	for _, s := range []string{"10.0.0.1", "2001:db8::1"} {
+		addr := netip.MustParseAddr(s)
+		node, err := GetNodeFor(lb, AddrKey(addr))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		want, _ := lb.GetNode(string(addr.AsSlice()))
+		if node != want {
+			t.Fatalf("expected %v on %v, got %v", addr, want, node)
+		}
+	}
+
+	if _, err := GetNodeFor(lb, BytesKey(nil)); err == nil {
+		t.Fatalf("expected an error for an empty key")
+	}
+}
@@ -8,22 +8,44 @@ package main
 
 import (
 	"consistenthash"
+	"context"
 	"errors"
+	"faultinject"
 	"fmt"
+	"hashing"
+	"io"
 	"iter"
+	"reflect"
 	"serverpool"
+	"time"
 )
 
+// Error for a capability the load balancer lacks
+var ErrUnsupported = errors.New("load balancer does not support")
+
+// Load balancer mapping keys and objects to nodes. The optional
+// capabilities of a load balancer are in Feeder, Transactor, Pinner, Drainer
+// and StatsReporter, which the load balancers of this package implement.
 type LoadBalancer[T,O comparable] interface {
 	// Add a list of nodes to the hash ring
-	AddNodes(nodes []serverpool.Node[T, O]) error
+	AddNodes(nodes []serverpool.Node[T, O]) (NodesResult[T,O], error)
 
 	// Remove a node from the hash ring
-	RemoveNodes(nodes []serverpool.Node[T, O]) error
+	RemoveNodes(nodes []serverpool.Node[T, O]) (NodesResult[T,O], error)
 
 	// Get the node responsible for the given key
 	GetNode(key string) (serverpool.Node[T,O], error)
 
+	// Get the node responsible for a binary key, see GetNodeFor
+	GetNodeBytes(key []byte) (serverpool.Node[T,O], error)
+
+	// Get the node responsible for the given key, waiting for a node to be
+	// added while there are none until ctx is done
+	GetNodeWait(ctx context.Context, key string) (serverpool.Node[T,O], error)
+
+	// Get up to n distinct nodes for the given key for replicas
+	GetNodes(key string, n int) ([]serverpool.Node[T,O], error)
+
 	// Count of nodes in the cluster
 	NodeCount() int
 
@@ -34,10 +56,10 @@ type LoadBalancer[T,O comparable] interface {
 	Buckets() iter.Seq2[int, serverpool.Node[T,O]]
 
 	// Add objects to the load balancer
-	AddObjects(objects []*serverpool.Object[T,O]) error
+	AddObjects(objects []*serverpool.Object[T,O]) (ObjectsResult[T,O], error)
 
 	// Remove objects from the load balancer
-	RemoveObjects(objects []*serverpool.Object[T,O]) error
+	RemoveObjects(objects []*serverpool.Object[T,O]) (ObjectsResult[T,O], error)
 
 	// Assign an object to a node
 	AssignObject(obj *serverpool.Object[T,O]) error
@@ -45,8 +67,168 @@ type LoadBalancer[T,O comparable] interface {
 	// Unassign an object from a node
 	UnassignObject(obj *serverpool.Object[T,O]) error
 
+	// Node of an object, adding and assigning the object first if needed
+	GetOrAssignObject(obj *serverpool.Object[T,O]) (serverpool.Node[T,O], error)
+
 	// Iterate over all objects in the load balancer
 	Objects() iter.Seq[*serverpool.Object[T,O]]
+
+	// Get the nodes for a key in order of preference
+	Candidates(key string, n int) ([]serverpool.Node[T,O], error)
+
+	// Get the node of a tier responsible for the given key
+	GetTierNode(key, tier string) (serverpool.Node[T,O], error)
+
+	// Move an object to another tier
+	SetObjectTier(obj *serverpool.Object[T,O], tier string) error
+
+	// Report the nodes owning sample keys under a prefix
+	MapPrefix(prefix string, sampler PrefixSampler, samples int) (PrefixMapping[T], error)
+
+	// Get the objects assigned to each node, node by node
+	ObjectsByNode() iter.Seq2[serverpool.Node[T,O], iter.Seq[*serverpool.Object[T,O]]]
+
+	// Version of the last change applied to the load balancer
+	Version() uint64
+
+	// Report object movement caused by rebalancing
+	ChurnStats() ChurnStats
+
+	// Call fn with node and object events of the given types, all if none
+	Subscribe(fn func(Event[T,O]), buffer int, types ...string) *Subscription[T,O]
+
+	// Send node and object events of the given types, all if none, on a
+	// channel
+	Watch(buffer int, types ...string) (<-chan Event[T,O], *Subscription[T,O])
+
+	// Move objects deferred by the movement budget as the budget allows
+	Rebalance() (int, error)
+
+	// Move every assigned object whose node changed, and only those
+	RebalanceAll() (RebalanceReport[T,O], error)
+
+	// Move an object to the given node with a migration handshake
+	TransferObject(obj *serverpool.Object[T,O], to serverpool.Node[T,O]) error
+
+	// Move objects to a node, all of them or none
+	MoveObjects(ids []O, to serverpool.Node[T,O]) error
+
+	// Transfer of an object in progress, if any
+	Migrating(obj *serverpool.Object[T,O]) (Move[T,O], bool)
+
+	// Snapshot the key to node mapping for use outside the load balancer
+	Topology() (consistenthash.Topology, error)
+
+	// Write the mapping and object assignments for Load
+	Save(w io.Writer) error
+
+	// Restore what Save wrote into an empty load balancer
+	Load(r io.Reader, newNode func(name T) serverpool.Node[T,O]) error
+
+	// Stop objects from moving unless an operation requires it
+	PauseAutomation() error
+
+	// Move the objects held back while automation was paused
+	ResumeAutomation() error
+
+	// Check that the internal state is consistent
+	Verify() error
+
+	// Unassign objects nodes hold that the load balancer does not track
+	CollectOrphans() ([]Stray[T,O], error)
+
+	// Assign unassigned objects mapping to a node to it on its request
+	ClaimObjects(node serverpool.Node[T,O], limit int) ([]*serverpool.Object[T,O], error)
+
+	// Extend the claims of a node
+	RenewClaims(node serverpool.Node[T,O]) (int, error)
+}
+
+// Publishes the changes of a load balancer and follows those of another
+type Feeder[T,O comparable] interface {
+	// Register fn to receive every change after the given version
+	Feed(since uint64, fn func(Change[T,O])) (cancel func(), err error)
+
+	// Check if the load balancer rejects mutations
+	ReadOnly() bool
+
+	// Make a read-only mirror writable and stop following its primary
+	Promote()
+
+	// Read-only copy of the load balancer as it was at a past version
+	StateAt(version uint64) (LoadBalancer[T,O], error)
+}
+
+// Applies groups of operations atomically
+type Transactor[T,O comparable] interface {
+	// Begin a transaction of operations applied atomically by Commit
+	Begin() *Transaction[T,O]
+}
+
+// Maps keys to nodes regardless of hashing
+type Pinner[T,O comparable] interface {
+	// Map a key to a node regardless of hashing
+	PinKey(key string, node serverpool.Node[T,O]) error
+
+	// Map a pinned key by hashing again
+	UnpinKey(key string) error
+
+	// Keys pinned and their nodes
+	PinnedKeys() map[string]T
+}
+
+// Moves the keys and objects of nodes off them gradually
+type Drainer[T,O comparable] interface {
+	// Move the objects off a node gradually before it leaves
+	DrainNode(node serverpool.Node[T,O], batch int) error
+
+	// Change the number of objects moved off a draining node at a time
+	SetDrainBatch(node serverpool.Node[T,O], batch int) error
+
+	// Stop draining a node and add it back
+	AbortDrain(node serverpool.Node[T,O]) error
+
+	// Progress of the nodes being drained
+	DrainStats() map[T]DrainProgress
+
+	// Shift the keys of a node to another node by percentage on a schedule
+	DialTraffic(from, to serverpool.Node[T,O], schedule []DialStep) error
+
+	// End the dial of a node, mapping all its keys back to it
+	RollbackDial(from serverpool.Node[T,O]) error
+
+	// Progress of the dials keyed by source node
+	DialStats() map[T]DialProgress[T]
+}
+
+// Reports statistics about the load balancer
+type StatsReporter[T,O comparable] interface {
+	// Estimate the memory used by the load balancer
+	MemoryStats() MemoryStats
+
+	// Nodes quarantined for flapping and the end of their quarantine
+	QuarantinedNodes() map[T]time.Time
+
+	// Sampled lookups and objects of each bucket and their spread
+	BucketStats() BucketStats[T]
+
+	// Per-phase timing of the last large rebalances
+	RebalanceProfiles() []RebalanceProfile
+
+	// Reports of the last nodes removed
+	DecommissionReports() []DecommissionReport[T,O]
+
+	// Report how the candidates of the objects spread across failure domains
+	SpreadStats(replicas int) (SpreadStats, error)
+}
+
+// Capability C of a load balancer, such as Pinner, or ErrUnsupported
+func capability[C any, T,O comparable](lb LoadBalancer[T,O]) (C, error) {
+	c, ok := lb.(C)
+	if !ok {
+		return c, fmt.Errorf("%w %v", ErrUnsupported, reflect.TypeFor[C]())
+	}
+	return c, nil
 }
 
 type loadBalancer[T,O comparable] struct {
@@ -56,138 +238,597 @@ type loadBalancer[T,O comparable] struct {
 	// consistentHasher is the consistent hash algorithm implementation
 	ch consistenthash.ConsistentHasher
 
+	// Algorithm keys are hashed with by the hashers options create
+	hashAlgo hashing.HashAlgorithm
+
+	// Seed of the hashers options create, unseeded if 0
+	hashSeed uint64
+
+	// Assign objects to their nodes as they are added
+	autoAssign bool
+
 	// Objects assigned to the nodes
-	objects map[O]*serverpool.Object[T,O]
+	objects objectMap[T,O]
+
+	// Canonicalizes keys before hashing, nil leaves keys untouched
+	normalize KeyNormalizer
+
+	// Change feed of all mutations applied to the load balancer
+	feed changeFeed[T,O]
+
+	// Mutations are rejected while the load balancer mirrors a primary
+	readOnly bool
+
+	// Stops following the primary's change feed
+	unfollow func()
+
+	// Sends prefetch hints ahead of object migrations
+	prefetcher prefetcher[T,O]
+
+	// What happens to the objects of removed nodes
+	removalPolicy RemovalPolicy
+
+	// Labels operations for CPU profiles and execution traces
+	profiler profiler
+
+	// Counts object moves and enforces the movement budget
+	churn churnTracker[T,O]
+
+	// Quarantines nodes that are added and removed too often
+	flaps flapDetector[T]
+
+	// Application hooks run during object transfers
+	transferHooks TransferHooks[T,O]
+
+	// Transfers in progress keyed by object id
+	migrating map[O]Move[T,O]
+
+	// Nodes objects were transferred to by name, keyed by object id
+	transferred map[O]T
+
+	// Rebalance every moved object after membership changes if set
+	cooperative *RebalanceCallbacks[T,O]
+
+	// Report the effects of mutations without applying them
+	dryRun bool
+
+	// Collects the changes of the transaction being committed, if any
+	batch *[]Change[T,O]
+
+	// Side effects held back until the transaction being committed
+	// succeeds, if any
+	effects *[]func()
+
+	// Saves the state of objects the transaction being committed changes,
+	// if any
+	undo *txUndo[T,O]
+
+	// Collects the objects whose moves the change of nodes being applied
+	// deferred, if any, to publish them with it
+	deferring *[]*serverpool.Object[T,O]
+
+	// Ids of the objects whose moves the change of nodes a mirror is
+	// replaying deferred
+	replayDeferred map[O]bool
+
+	// Options the load balancer was created with, to rebuild past states
+	opts []Option[T,O]
+
+	// Nodes being drained keyed by name
+	drains map[T]*drain[T,O]
+
+	// Called with each node once its drain is over, if set
+	drained func(node serverpool.Node[T,O])
+
+	// Bound on removed buckets before the hasher is rebuilt, 0 if none
+	removedLimit int
+
+	// What lookups do while there are no nodes
+	shedding shedding[T,O]
+
+	// Objects claimed by the nodes they are assigned to
+	claims claims[T,O]
+
+	// Prefixes whose keys only map to a group of nodes
+	pins []prefixPin[T]
+
+	// Keys pinned to a node by name, nil if none
+	keyPins map[string]T
+
+	// Hashers of the tiers of nodes
+	tiers tiers[T,O]
+
+	// Failure domains of the nodes and how candidates are spread over them
+	domains domains[T,O]
+
+	// Objects only move when an operation requires it
+	paused bool
+
+	// Objects may be on another node than they map to until RebalanceAll
+	stale bool
+
+	// Endpoints notified of assignments, moves and removals, nil if none
+	webhooks *webhooks[T,O]
+
+	// Ownership records kept in an object store, nil if none
+	records *objectRecords[T,O]
+
+	// Subscribers to node and object events
+	subscriptions subscriptions[T,O]
+
+	// Faults injected for testing, nil if none
+	faults *faultinject.Injector
+
+	// Keys of nodes shifting to other nodes, keyed by source node
+	dials map[T]*dial[T,O]
+
+	// Eviction of objects beyond the capacity of nodes
+	eviction eviction[T,O]
+
+	// Hits and objects of each bucket and their alarms
+	bucketStats bucketStats
+
+	// Per-phase timing of large rebalances
+	rebalances rebalanceProfiles
+
+	// Placement constraints of objects by name
+	constraints map[string]PlacementConstraint[T,O]
+
+	// Reports of the nodes removed
+	decommissions decommissions[T,O]
 }
 
 // Create a new load balancer
 func NewLoadBalancer[T,O comparable]() LoadBalancer[T,O] {
-	return &loadBalancer[T,O]{sp: serverpool.NewServerPool[T,O](),
-		ch: consistenthash.NewConsistentHasher(),
-	objects: make(map[O]*serverpool.Object[T,O])}
+	return NewLoadBalancerWithOptions[T,O]()
+}
+
+// Create a new load balancer configured by the given options
+func NewLoadBalancerWithOptions[T,O comparable](opts ...Option[T,O]) LoadBalancer[T,O] {
+	lb := &loadBalancer[T,O]{sp: serverpool.NewServerPool[T,O](),
+		ch: consistenthash.NewConsistentHasher(), hashAlgo: hashing.DefaultHashAlgorithm, opts: opts}
+	lb.feed.retention = DefaultFeedRetention
+
+	for _, opt := range opts {
+		opt(lb)
+	}
+	return lb
 }
 
 // Add a list of nodes to the load balancer
-func (lb *loadBalancer[T,O]) AddNodes(nodes []serverpool.Node[T,O]) error {
+func (lb *loadBalancer[T,O]) AddNodes(nodes []serverpool.Node[T,O]) (result NodesResult[T,O], err error) {
+	if lb.dryRun {
+		return lb.planAddNodes(nodes)
+	}
+	if lb.readOnly {
+		return newNodesResult(nodes), ErrReadOnly
+	}
+	lb.profileRebalance("AddNodes", func() { result, err = lb.addNodes(nodes) })
+	return result, err
+}
+
+func (lb *loadBalancer[T,O]) addNodes(nodes []serverpool.Node[T,O]) (NodesResult[T,O], error) {
+	result := newNodesResult(nodes)
 	if len(nodes) == 0 {
-		return errors.New("no nodes to add")
+		return result, errors.New("no nodes to add")
 	}
+	var deferred []*serverpool.Object[T,O]
+	lb.deferring = &deferred
+	defer func() { lb.deferring = nil }()
+
+	for i, node := range nodes {
+		nr := &result.Nodes[i]
+
+		// A mirror replays adds the primary already allowed and a
+		// transaction checks quarantines before applying anything
+		if !lb.readOnly && lb.batch == nil && lb.flaps.isQuarantined(node.Name(), lb.churn.clock()) {
+			err := fmt.Errorf("%w: %v", ErrNodeQuarantined, node)
+			nr.Status, nr.Err = StatusFailed, err
+			if i > 0 {
+				lb.churn.topologyChanged()
+			}
+			lb.publish(ChangeAddNodes, nodes[:i], nil)
+			return result, err
+		}
 
-	for _, node := range nodes {
-		bucket := lb.ch.AddBucket()
-		if err := lb.sp.AddNode(node, bucket); err != nil {
-			return err
+		bucket, err := lb.addNodeBuckets(node)
+		if err != nil {
+			nr.Status, nr.Err = StatusFailed, err
+			if i > 0 {
+				lb.churn.topologyChanged()
+			}
+			lb.publish(ChangeAddNodes, nodes[:i], nil)
+			return result, err
 		}
+		nr.Status, nr.Bucket = StatusOK, bucket
+		lb.tierAdd(node)
+		lb.adoptRecords(node)
+		lb.notifyAdded(node)
+		delete(lb.drains, node.Name())
+		lb.flaps.record(node.Name(), lb.churn.clock())
 	}
-	return nil
+	if lb.cooperative != nil {
+		var errs ReassignmentError[O]
+		lb.rebalanceCooperatively(&errs)
+	} else if lb.objects.len() > 0 {
+		lb.stale = true
+	}
+	lb.churn.topologyChanged()
+	lb.publish(ChangeAddNodes, nodes, deferred)
+	return result, nil
 }
 
 // Remove a list of nodes from the load balancer
-func (lb *loadBalancer[T,O]) RemoveNodes(nodes []serverpool.Node[T,O]) error {
+func (lb *loadBalancer[T,O]) RemoveNodes(nodes []serverpool.Node[T,O]) (result NodesResult[T,O], err error) {
+	if lb.dryRun {
+		return lb.planRemoveNodes(nodes)
+	}
+	if lb.readOnly {
+		return newNodesResult(nodes), ErrReadOnly
+	}
+	lb.profileRebalance("RemoveNodes", func() {
+		result, err = lb.decommission(nodes, func() (NodesResult[T,O], error) { return lb.removeNodes(nodes) })
+	})
+	return result, err
+}
+
+func (lb *loadBalancer[T,O]) removeNodes(nodes []serverpool.Node[T,O]) (NodesResult[T,O], error) {
+	result := newNodesResult(nodes)
 	if len(nodes) == 0 {
-		return errors.New("no nodes to remove")
+		return result, errors.New("no nodes to remove")
 	}
 
-	if len(nodes) > lb.ch.Size() {
-		return fmt.Errorf("cannot remove more nodes than the size of the working set %d", lb.ch.Size())
+	if len(nodes) > lb.sp.Len() {
+		return result, fmt.Errorf("cannot remove more nodes than the size of the working set %d", lb.sp.Len())
 	}
 
-	for _, node := range nodes {
-		bucket, removedNode, err := lb.sp.RemoveNode(node)
-		if err != nil {
-			return err
+	if lb.removalPolicy == FailOnRemoval {
+		if err := lb.checkEmpty(nodes); err != nil {
+			return result, err
 		}
-		lb.ch.RemoveBucket(bucket)
+	}
 
-		// Re-assign objects assigned to the deleted after removing the bucket 
-		// so they are reassined to other nodes
-		for obj := range removedNode.Objects() {
-			lb.AssignObject(obj)
+	var deferred []*serverpool.Object[T,O]
+	lb.deferring = &deferred
+	defer func() { lb.deferring = nil }()
+
+	var errs ReassignmentError[O]
+	cooperative := lb.cooperative != nil && lb.removalPolicy == ReassignOnRemoval
+	removed := make([]serverpool.Node[T,O], len(nodes))
+	rebuilds, minimal := consistenthash.Rebuilds(lb.ch)
+
+	// Objects of the removed nodes move along with any others that changed
+	// node. Otherwise only those of the removed nodes moved, unless the
+	// hasher was rebuilt, which moves keys between the remaining nodes too.
+	rebalance := func() {
+		if !cooperative {
+			if n, _ := consistenthash.Rebuilds(lb.ch); n != rebuilds {
+				lb.rebalanceCooperatively(&errs)
+			} else if !minimal {
+				lb.stale = true
+			}
+			return
+		}
+		counts := lb.rebalanceCooperatively(&errs)
+		for i, node := range removed {
+			if c, ok := counts[node]; ok && node != nil {
+				nr := &result.Nodes[i]
+				nr.Reassigned, nr.Deferred, nr.Orphaned = c.reassigned, c.deferred, c.orphaned
+			}
 		}
 	}
-	return nil
+
+	for i, node := range nodes {
+		nr := &result.Nodes[i]
+		bucket, removedNode, err := lb.removeNodeBuckets(node)
+		if err != nil {
+			nr.Status, nr.Err = StatusFailed, err
+			if i > 0 {
+				rebalance()
+				lb.churn.topologyChanged()
+			}
+			lb.publish(ChangeRemoveNodes, nodes[:i], deferred)
+			return result, err
+		}
+		lb.tierRemove(removedNode)
+		lb.prefetcher.forgetNode(removedNode)
+		lb.endDials(removedNode.Name())
+
+		nr.Status, nr.Bucket = StatusOK, bucket
+		removed[i] = removedNode
+		lb.notifyRemoved(removedNode)
+		if !cooperative {
+			nr.Reassigned, nr.Deferred, nr.Orphaned = lb.evacuate(removedNode, &errs)
+		}
+		lb.flaps.record(node.Name(), lb.churn.clock())
+	}
+	rebalance()
+	evicted := lb.evict(&errs)
+	lb.churn.topologyChanged()
+	lb.publish(ChangeRemoveNodes, nodes, deferred)
+	lb.publish(ChangeUnassignObject, nil, evicted)
+
+	if len(errs.Errors) > 0 {
+		return result, &errs
+	}
+	return result, nil
 }
 
 // Get the node responsible for the given key
 func (lb *loadBalancer[T,O]) GetNode(key string) (serverpool.Node[T,O], error) {
+	return lb.getNode(key, lb.lookupKey)
+}
+
+// Map a key with mapKey, shedding the lookup while there are no nodes
+func (lb *loadBalancer[T,O]) getNode(key string, mapKey func(string) (serverpool.Node[T,O], error)) (node serverpool.Node[T,O], err error) {
+	if m := lb.profiler.metrics; m != nil {
+		start := time.Now()
+		defer func() { m.lookup(start, err) }()
+	}
+	if err := lb.faults.LookupError(); err != nil {
+		return nil, err
+	}
+	if lb.shedding.wait > 0 && !lb.shedding.available.Load() {
+		return lb.shed(key, mapKey)
+	}
+	node, err = mapKey(key)
+	if err == ErrClusterUnavailable {
+		return lb.shed(key, mapKey)
+	}
+	return node, err
+}
+
+// Map a key to a node, without the fallback or wait for nodes of GetNode
+func (lb *loadBalancer[T,O]) mapKey(key string) (serverpool.Node[T,O], error) {
+	node, _, err := lb.mapKeyBucket(key)
+	return node, err
+}
+
+// Map a key to a node and the bucket it hashes to, -1 if it is pinned
+func (lb *loadBalancer[T,O]) mapKeyBucket(key string) (serverpool.Node[T,O], int, error) {
+	if lb.normalize != nil {
+		key = lb.normalize(key)
+	}
 	if len(key) == 0 {
-		return nil, errors.New("key cannot be empty")
+		return nil, -1, errors.New("key cannot be empty")
+	}
+	if lb.ch.Size() == 0 {
+		return nil, -1, ErrClusterUnavailable
+	}
+	if node, ok := lb.pinned(key); ok {
+		return node, -1, nil
+	}
+	bucket, err := consistenthash.LookupBucket(lb.ch, key)
+	if err != nil {
+		return nil, -1, err
 	}
-	bucket := lb.ch.GetBucket(key)
 	node, ok := lb.sp.GetNode(bucket)
 	if !ok {
-		return nil, fmt.Errorf("node not found for bucket %d", bucket)
+		return nil, -1, fmt.Errorf("%w %d", ErrBucketNotFound, bucket)
 	}
-	return node, nil
+	return lb.dialed(key, node), bucket, nil
 }
 
 // AddObjects adds a list of objects to the load balancer's object pool.
-func (lb *loadBalancer[T,O]) AddObjects(objects []*serverpool.Object[T,O]) error {
+func (lb *loadBalancer[T,O]) AddObjects(objects []*serverpool.Object[T,O]) (result ObjectsResult[T,O], err error) {
+	if lb.dryRun {
+		if len(objects) == 0 {
+			return ObjectsResult[T,O]{}, errors.New("no objects to add")
+		}
+		return newObjectsResult(objects, StatusOK), nil
+	}
+	if lb.readOnly {
+		return newObjectsResult(objects, StatusSkipped), ErrReadOnly
+	}
+	lb.profiler.do("AddObjects", func() {
+		if result, err = lb.addObjects(objects); err == nil && lb.autoAssign {
+			lb.assignAdded(objects)
+		}
+	})
+	return result, err
+}
+
+// Assign objects just added, leaving those that cannot be placed unassigned
+func (lb *loadBalancer[T,O]) assignAdded(objects []*serverpool.Object[T,O]) {
+	var assigned []*serverpool.Object[T,O]
+	for _, obj := range objects {
+		if err := lb.assignObject(obj); err == nil {
+			assigned = append(assigned, obj)
+		}
+	}
+	if len(assigned) > 0 {
+		lb.publish(ChangeAssignObject, nil, assigned)
+	}
+}
+
+func (lb *loadBalancer[T,O]) addObjects(objects []*serverpool.Object[T,O]) (ObjectsResult[T,O], error) {
 	if len(objects) == 0 {
-		return errors.New("no objects to add")
+		return ObjectsResult[T,O]{}, errors.New("no objects to add")
 	}
 
 	for _, obj := range objects {
-		lb.objects[obj.Id] = obj
+		lb.objects.set(obj)
+		lb.putRecord(obj, nil)
 	}
-	return nil
+	lb.publish(ChangeAddObjects, nil, objects)
+	return newObjectsResult(objects, StatusOK), nil
 }
 
 // RemoveObjects removes the specified objects from the load balancer's pool.
-func (lb *loadBalancer[T,O]) RemoveObjects(objects []*serverpool.Object[T,O]) error {
+func (lb *loadBalancer[T,O]) RemoveObjects(objects []*serverpool.Object[T,O]) (result ObjectsResult[T,O], err error) {
+	if lb.dryRun {
+		if len(objects) == 0 {
+			return ObjectsResult[T,O]{}, errors.New("no objects to remove")
+		}
+		return newObjectsResult(objects, StatusOK), nil
+	}
+	if lb.readOnly {
+		return newObjectsResult(objects, StatusSkipped), ErrReadOnly
+	}
+	lb.profiler.do("RemoveObjects", func() { result, err = lb.removeObjects(objects) })
+	return result, err
+}
+
+func (lb *loadBalancer[T,O]) removeObjects(objects []*serverpool.Object[T,O]) (ObjectsResult[T,O], error) {
 	if len(objects) == 0 {
-		return errors.New("no objects to remove")
+		return ObjectsResult[T,O]{}, errors.New("no objects to remove")
 	}
 
 	for _, obj := range objects {
-		delete(lb.objects, obj.Id)
+		if o, ok := lb.objects.get(obj.Id); ok {
+			lb.release(o)
+			lb.prefetcher.forget(o)
+		}
+		lb.objects.delete(obj.Id)
+		lb.eviction.forget(obj.Id)
+		lb.removeRecord(obj.Id)
 	}
-	return nil
+	lb.publish(ChangeRemoveObjects, nil, objects)
+	return newObjectsResult(objects, StatusOK), nil
 }
 
 // AssignObject assigns an object to a node in the load balancer
 func (lb *loadBalancer[T,O]) AssignObject(obj *serverpool.Object[T,O]) error {
-	o, ok := lb.objects[obj.Id]
+	if lb.dryRun {
+		return lb.planAssignObject(obj)
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	if err := lb.assignObject(obj); err != nil {
+		return err
+	}
+	lb.publish(ChangeAssignObject, nil, []*serverpool.Object[T,O]{obj})
+	return nil
+}
+
+func (lb *loadBalancer[T,O]) assignObject(obj *serverpool.Object[T,O]) error {
+	o, ok := lb.objects.get(obj.Id)
 	if !ok {
 		return fmt.Errorf("%v not found", obj)
 	}
 
-	node, err := lb.GetNode(obj.Name())
+	node, err := lb.placement(o)
 	if err != nil {
 		return err
 	}
+	lb.faults.DelayAssignment()
 
+	var from serverpool.Node[T,O]
+	if n := o.Node(); n != nil {
+		from = *n
+	}
+
+	// Leave the node the object was on if membership changed since
+	lb.detach(o)
 	node.AssignObject(o)
 	o.AssignToNode(&node)
+	lb.notifyPlaced(o, from, node)
 
 	return nil
 }
 
+// GetOrAssignObject returns the node of the object, adding the object and
+// assigning it first if it is not assigned yet. In a dry run it returns the
+// node the object would be assigned to.
+func (lb *loadBalancer[T,O]) GetOrAssignObject(obj *serverpool.Object[T,O]) (serverpool.Node[T,O], error) {
+	if node, ok := lb.assignedNode(obj.Id); ok {
+		return node, nil
+	}
+	if lb.dryRun {
+		return lb.placement(obj)
+	}
+	if lb.readOnly {
+		return nil, ErrReadOnly
+	}
+	if _, ok := lb.objects.get(obj.Id); !ok {
+		if _, err := lb.AddObjects([]*serverpool.Object[T,O]{obj}); err != nil {
+			return nil, err
+		}
+	}
+	if err := lb.AssignObject(obj); err != nil {
+		return nil, err
+	}
+	node, _ := lb.assignedNode(obj.Id)
+	return node, nil
+}
+
+// Node of the object with the given id, false if it is not assigned
+func (lb *loadBalancer[T,O]) assignedNode(id O) (serverpool.Node[T,O], bool) {
+	o, ok := lb.objects.get(id)
+	if !ok || o.Node() == nil || *o.Node() == nil {
+		return nil, false
+	}
+	return *o.Node(), true
+}
+
 // UnassignObject unassigns an object from a node in the load balancer
 func (lb *loadBalancer[T,O]) UnassignObject(obj *serverpool.Object[T,O]) error {
-	o, ok := lb.objects[obj.Id]
-	if !ok {
-		return fmt.Errorf("%v not found", obj)
+	if lb.dryRun {
+		if _, ok := lb.objects.get(obj.Id); !ok {
+			return fmt.Errorf("%v not found", obj)
+		}
+		return nil
 	}
-	
-	node, err := lb.GetNode(o.Name())
-	if err != nil {
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	if err := lb.unassignObject(obj); err != nil {
 		return err
 	}
+	lb.publish(ChangeUnassignObject, nil, []*serverpool.Object[T,O]{obj})
+	return nil
+}
 
-	node.UnassignObject(o)
-	o.UnassignFromNode()
+func (lb *loadBalancer[T,O]) unassignObject(obj *serverpool.Object[T,O]) error {
+	o, ok := lb.objects.get(obj.Id)
+	if !ok {
+		return fmt.Errorf("%v not found", obj)
+	}
 
+	// The node the object is on, not the one its key maps to now
+	lb.release(o)
 	return nil
 }
 
+// Remove the object from the node it is recorded on, if any, for good
+func (lb *loadBalancer[T,O]) release(o *serverpool.Object[T,O]) {
+	node := o.Node()
+	lb.detach(o)
+	lb.undo.transferring(lb.transferred, o.Id)
+	delete(lb.transferred, o.Id)
+	if node != nil && *node != nil {
+		lb.notifyUnassigned(o, *node)
+	}
+}
+
+// Remove the object from the node it is recorded on, if any
+func (lb *loadBalancer[T,O]) detach(o *serverpool.Object[T,O]) {
+	lb.undo.moving(o)
+	if node := o.Node(); node != nil && *node != nil {
+		(*node).UnassignObject(o)
+	}
+	o.UnassignFromNode()
+}
 
 // Objects returns a sequence of pointers to serverpool.Object[O].
 func (lb *loadBalancer[T,O]) Objects() iter.Seq[*serverpool.Object[T,O]] {
-	return func(yield func(*serverpool.Object[T,O]) bool) {
-		for _, obj := range lb.objects {
-			if !yield(obj) {
-				break
+	return lb.objects.all()
+}
+
+// ObjectsByNode yields every node with the objects assigned to it, including
+// draining nodes that still have objects, so each node's objects can be
+// handed to a goroutine of its own. Unassigned objects are not yielded.
+func (lb *loadBalancer[T,O]) ObjectsByNode() iter.Seq2[serverpool.Node[T,O], iter.Seq[*serverpool.Object[T,O]]] {
+	return func(yield func(serverpool.Node[T,O], iter.Seq[*serverpool.Object[T,O]]) bool) {
+		for node := range lb.sp.Nodes() {
+			if !yield(node, node.Objects()) {
+				return
+			}
+		}
+		for _, d := range lb.drains {
+			if !yield(d.node, d.node.Objects()) {
+				return
 			}
 		}
 	}
@@ -195,7 +836,7 @@ func (lb *loadBalancer[T,O]) Objects() iter.Seq[*serverpool.Object[T,O]] {
 
 // Count of nodes in the cluster
 func (lb *loadBalancer[T,O]) NodeCount() int {
-	return lb.ch.Size()
+	return lb.sp.Len()
 }
 
 // Iterate over all nodes in the load balancer
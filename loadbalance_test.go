@@ -7,11 +7,16 @@
 package main
 
 import (
+	"consistenthash"
+	"context"
 	"errors"
 	"fmt"
 	"hashing"
 	"iter"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"serverpool"
 )
@@ -38,6 +43,24 @@ func (m *mockServerPool[T,O]) RemoveNode(node serverpool.Node[T,O]) (int, server
 	return 0, nil, errors.New("node not found")
 }
 
+func (m *mockServerPool[T,O]) AddBucket(node serverpool.Node[T,O], bucket int) error {
+	return m.AddNode(node, bucket)
+}
+
+func (m *mockServerPool[T,O]) NodeBuckets(node serverpool.Node[T,O]) []int {
+	var buckets []int
+	for bucket, n := range m.nodes {
+		if n == node {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+func (m *mockServerPool[T,O]) Len() int {
+	return len(m.nodes)
+}
+
 func (m *mockServerPool[T,O]) GetNode(bucket int) (serverpool.Node[T,O], bool) {
 	node, exists := m.nodes[bucket]
 	return node, exists
@@ -116,6 +139,14 @@ func (m *mockConsistentHasher) GetBucket(key string) int {
 	return int(h.HashString(key)) % m.buckets
 }
 
+func (m *mockConsistentHasher) GetBuckets(key string, n int) []int {
+	var buckets []int
+	for i := range min(n, m.buckets) {
+		buckets = append(buckets, (m.GetBucket(key)+i)%m.buckets)
+	}
+	return buckets
+}
+
 func (m *mockConsistentHasher) Size() int {
 	return m.buckets
 }
@@ -131,7 +162,7 @@ func TestAddNodes(t *testing.T) {
 		&mockNode{ID: "node2"},
 	}
 
-	err := lb.AddNodes(nodes)
+	_, err := lb.AddNodes(nodes)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -159,7 +190,7 @@ func TestAddNodesEmpty(t *testing.T) {
 	ch := &mockConsistentHasher{}
 	lb := &loadBalancer[string,string]{sp: sp, ch: ch}
 
-	err := lb.AddNodes([]serverpool.Node[string,string]{})
+	_, err := lb.AddNodes([]serverpool.Node[string,string]{})
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -179,13 +210,13 @@ func TestRemoveNodes(t *testing.T) {
 	}
 
 	// Add nodes first
-	err := lb.AddNodes(nodes)
+	_, err := lb.AddNodes(nodes)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
 	// Now remove nodes
-	err = lb.RemoveNodes(nodes)
+	_, err = lb.RemoveNodes(nodes)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -200,7 +231,7 @@ func TestRemoveNodesEmpty(t *testing.T) {
 	ch := &mockConsistentHasher{}
 	lb := &loadBalancer[string,string]{sp: sp, ch: ch}
 
-	err := lb.RemoveNodes([]serverpool.Node[string,string]{})
+	_, err := lb.RemoveNodes([]serverpool.Node[string,string]{})
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -220,13 +251,13 @@ func TestRemoveNodesMoreThanExist(t *testing.T) {
 	}
 
 	// Add one node first
-	err := lb.AddNodes(nodes)
+	_, err := lb.AddNodes(nodes)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
 	// Try to remove more nodes than exist
-	err = lb.RemoveNodes([]serverpool.Node[string,string]{
+	_, err = lb.RemoveNodes([]serverpool.Node[string,string]{
 		&mockNode{ID: "node1"},
 		&mockNode{ID: "node2"},
 	})
@@ -250,7 +281,7 @@ func TestGetNode(t *testing.T) {
 	}
 
 	// Add nodes first
-	err := lb.AddNodes(nodes)
+	_, err := lb.AddNodes(nodes)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -283,32 +314,31 @@ func TestGetNode(t *testing.T) {
 		t.Fatalf("expected error, got nil")
 	}
 
-	expectedErr := fmt.Sprintf("node not found for bucket %d", -1)
-	if err.Error() != expectedErr {
-		t.Fatalf("expected '%s' error, got %v", expectedErr, err)
+	if !errors.Is(err, ErrClusterUnavailable) {
+		t.Fatalf("expected ErrClusterUnavailable, got %v", err)
 	}
 }
 func TestAddObjects(t *testing.T) {
 	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
 	ch := &mockConsistentHasher{}
-	lb := &loadBalancer[string, string]{sp: sp, ch: ch, objects: make(map[string]*serverpool.Object[string, string])}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
 
 	objects := []*serverpool.Object[string, string]{
 		{Id: "obj1"},
 		{Id: "obj2"},
 	}
 
-	err := lb.AddObjects(objects)
+	_, err := lb.AddObjects(objects)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	if len(lb.objects) != 2 {
-		t.Fatalf("expected 2 objects, got %d", len(lb.objects))
+	if lb.objects.len() != 2 {
+		t.Fatalf("expected 2 objects, got %d", lb.objects.len())
 	}
 
 	for _, obj := range objects {
-		if _, exists := lb.objects[obj.Id]; !exists {
+		if _, exists := lb.objects.get(obj.Id); !exists {
 			t.Fatalf("expected object %v to be added", obj)
 		}
 	}
@@ -317,9 +347,9 @@ func TestAddObjects(t *testing.T) {
 func TestAddObjectsEmpty(t *testing.T) {
 	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
 	ch := &mockConsistentHasher{}
-	lb := &loadBalancer[string, string]{sp: sp, ch: ch, objects: make(map[string]*serverpool.Object[string, string])}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
 
-	err := lb.AddObjects([]*serverpool.Object[string, string]{})
+	_, err := lb.AddObjects([]*serverpool.Object[string, string]{})
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -331,7 +361,7 @@ func TestAddObjectsEmpty(t *testing.T) {
 func TestRemoveObjects(t *testing.T) {
 	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
 	ch := &mockConsistentHasher{}
-	lb := &loadBalancer[string, string]{sp: sp, ch: ch, objects: make(map[string]*serverpool.Object[string, string])}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
 
 	objects := []*serverpool.Object[string, string]{
 		{Id: "obj1"},
@@ -339,28 +369,28 @@ func TestRemoveObjects(t *testing.T) {
 	}
 
 	// Add objects first
-	err := lb.AddObjects(objects)
+	_, err := lb.AddObjects(objects)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
 	// Now remove objects
-	err = lb.RemoveObjects(objects)
+	_, err = lb.RemoveObjects(objects)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	if len(lb.objects) != 0 {
-		t.Fatalf("expected 0 objects, got %d", len(lb.objects))
+	if lb.objects.len() != 0 {
+		t.Fatalf("expected 0 objects, got %d", lb.objects.len())
 	}
 }
 
 func TestRemoveObjectsEmpty(t *testing.T) {
 	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
 	ch := &mockConsistentHasher{}
-	lb := &loadBalancer[string, string]{sp: sp, ch: ch, objects: make(map[string]*serverpool.Object[string, string])}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
 
-	err := lb.RemoveObjects([]*serverpool.Object[string, string]{})
+	_, err := lb.RemoveObjects([]*serverpool.Object[string, string]{})
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -372,7 +402,7 @@ func TestRemoveObjectsEmpty(t *testing.T) {
 func TestAssignObject(t *testing.T) {
 	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
 	ch := &mockConsistentHasher{}
-	lb := &loadBalancer[string, string]{sp: sp, ch: ch, objects: make(map[string]*serverpool.Object[string, string])}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
 
 	nodes := []serverpool.Node[string, string]{
 		&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
@@ -380,7 +410,7 @@ func TestAssignObject(t *testing.T) {
 	}
 
 	// Add nodes first
-	err := lb.AddNodes(nodes)
+	_, err := lb.AddNodes(nodes)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -391,7 +421,7 @@ func TestAssignObject(t *testing.T) {
 	}
 
 	// Add objects to the load balancer
-	err = lb.AddObjects(objects)
+	_, err = lb.AddObjects(objects)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -418,7 +448,7 @@ func TestAssignObject(t *testing.T) {
 func TestAssignObjectNotFound(t *testing.T) {
 	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
 	ch := &mockConsistentHasher{}
-	lb := &loadBalancer[string, string]{sp: sp, ch: ch, objects: make(map[string]*serverpool.Object[string, string])}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
 
 	obj := &serverpool.Object[string, string]{Id: "obj1"}
 
@@ -435,7 +465,7 @@ func TestAssignObjectNotFound(t *testing.T) {
 func TestUnassignObject(t *testing.T) {
 	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
 	ch := &mockConsistentHasher{}
-	lb := &loadBalancer[string, string]{sp: sp, ch: ch, objects: make(map[string]*serverpool.Object[string, string])}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
 
 	nodes := []serverpool.Node[string, string]{
 		&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
@@ -443,7 +473,7 @@ func TestUnassignObject(t *testing.T) {
 	}
 
 	// Add nodes first
-	err := lb.AddNodes(nodes)
+	_, err := lb.AddNodes(nodes)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -454,7 +484,7 @@ func TestUnassignObject(t *testing.T) {
 	}
 
 	// Add objects to the load balancer
-	err = lb.AddObjects(objects)
+	_, err = lb.AddObjects(objects)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -489,7 +519,7 @@ func TestUnassignObject(t *testing.T) {
 func TestUnassignObjectNotFound(t *testing.T) {
 	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
 	ch := &mockConsistentHasher{}
-	lb := &loadBalancer[string, string]{sp: sp, ch: ch, objects: make(map[string]*serverpool.Object[string, string])}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
 
 	obj := &serverpool.Object[string, string]{Id: "obj1"}
 
@@ -502,4 +532,247 @@ func TestUnassignObjectNotFound(t *testing.T) {
 	if err.Error() != expectedErr {
 		t.Fatalf("expected '%s' error, got %v", expectedErr, err)
 	}
-}
\ No newline at end of file
+}
+func TestUnassignAfterTopologyChange(t *testing.T) {
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	nodes := []*mockNode{newNode("node1"), newNode("node2"), newNode("node3"), newNode("node4")}
+
+	setup := func() (LoadBalancer[string, string], []*serverpool.Object[string, string]) {
+		for _, n := range nodes {
+			clear(n.objects)
+		}
+		lb := NewLoadBalancer[string, string]()
+		if _, err := lb.AddNodes([]serverpool.Node[string, string]{nodes[0], nodes[1]}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var objs []*serverpool.Object[string, string]
+		for i := 0; i < 20; i++ {
+			objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+		}
+		if _, err := lb.AddObjects(objs); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, obj := range objs {
+			if err := lb.AssignObject(obj); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		return lb, objs
+	}
+	assigned := func() int {
+		n := 0
+		for _, node := range nodes {
+			n += len(node.objects)
+		}
+		return n
+	}
+
+	tests := []struct {
+		name   string
+		change func(lb LoadBalancer[string, string]) error
+	}{
+		{name: "add nodes", change: func(lb LoadBalancer[string, string]) error {
+			_, err := lb.AddNodes([]serverpool.Node[string, string]{nodes[2], nodes[3]})
+			return err
+		}},
+		{name: "remove node", change: func(lb LoadBalancer[string, string]) error {
+			_, err := lb.RemoveNodes([]serverpool.Node[string, string]{nodes[0]})
+			return err
+		}},
+		{name: "remove node then add nodes", change: func(lb LoadBalancer[string, string]) error {
+			if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{nodes[0]}); err != nil {
+				return err
+			}
+			_, err := lb.AddNodes([]serverpool.Node[string, string]{nodes[2], nodes[3]})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Unassigning removes each object from the node it is on
+			lb, objs := setup()
+			if err := tt.change(lb); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, obj := range objs {
+				if err := lb.UnassignObject(obj); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if obj.Node() != nil {
+					t.Fatalf("expected %v to be unassigned", obj)
+				}
+			}
+			if n := assigned(); n != 0 {
+				t.Fatalf("expected no objects left on nodes, got %d", n)
+			}
+
+			// Reassigning moves each object off the node it was on
+			lb, objs = setup()
+			if err := tt.change(lb); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, obj := range objs {
+				if err := lb.AssignObject(obj); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			if n := assigned(); n != len(objs) {
+				t.Fatalf("expected %d assignments, got %d", len(objs), n)
+			}
+		})
+	}
+}
+
+func TestGetOrAssignObject(t *testing.T) {
+	lb := NewConcurrentLoadBalancer[string, string]()
+	var assigned, added int
+	lb.(Feeder[string, string]).Feed(0, func(c Change[string, string]) {
+		switch c.Op {
+		case ChangeAssignObject:
+			assigned++
+		case ChangeAddObjects:
+			added++
+		}
+	})
+	nodes := []serverpool.Node[string, string]{
+		&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
+		&mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Concurrent requests for an unknown object add and assign it once
+	got := make([]serverpool.Node[string, string], 50)
+	var wg sync.WaitGroup
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node, err := lb.GetOrAssignObject(&serverpool.Object[string, string]{Id: "obj"})
+			if err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			got[i] = node
+		}()
+	}
+	wg.Wait()
+	for _, node := range got {
+		if node == nil || node != got[0] {
+			t.Fatalf("expected every request to get the same node, got %v", got)
+		}
+	}
+	if added != 1 || assigned != 1 {
+		t.Fatalf("expected the object added and assigned once, got %d and %d", added, assigned)
+	}
+
+	// Unassigned objects already added are only assigned
+	obj := &serverpool.Object[string, string]{Id: "obj2"}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	node, err := lb.GetOrAssignObject(obj)
+	if err != nil || node == nil || *obj.Node() != node || added != 2 || assigned != 2 {
+		t.Fatalf("expected obj2 assigned to %v, got %v, %v", node, obj.Node(), err)
+	}
+}
+
+func TestAutoAssign(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithAutoAssign[string, string](true))
+	var ops []ChangeOp
+	lb.(Feeder[string, string]).Feed(0, func(c Change[string, string]) { ops = append(ops, c.Op) })
+
+	// Objects added without nodes stay unassigned
+	orphan := &serverpool.Object[string, string]{Id: "orphan"}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{orphan}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if orphan.Node() != nil {
+		t.Fatalf("expected the object to stay unassigned, got %v", *orphan.Node())
+	}
+
+	node := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	objs := []*serverpool.Object[string, string]{{Id: "obj1"}, {Id: "obj2"}}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(node.objects) != 2 {
+		t.Fatalf("expected both objects assigned, got %v", node.objects)
+	}
+	want := []ChangeOp{ChangeAddObjects, ChangeAddNodes, ChangeAddObjects, ChangeAssignObject}
+	if !slices.Equal(ops, want) {
+		t.Fatalf("expected changes %v, got %v", want, ops)
+	}
+}
+
+func TestGetNodeEmptyRing(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	if _, err := lb.GetNode("key"); !errors.Is(err, ErrClusterUnavailable) || !errors.Is(err, consistenthash.ErrEmptyRing) {
+		t.Fatalf("expected an empty ring, got %v", err)
+	}
+
+	// A single node takes every key
+	node := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if got, err := lb.GetNode(fmt.Sprintf("key%d", i)); err != nil || got != node {
+			t.Fatalf("expected node1, got %v and %v", got, err)
+		}
+	}
+
+	if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.GetNode("key"); !errors.Is(err, consistenthash.ErrEmptyRing) {
+		t.Fatalf("expected an empty ring, got %v", err)
+	}
+
+	// A hasher and pool that disagree fail with a typed error
+	broken := &loadBalancer[string, string]{sp: &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])},
+		ch: &mockConsistentHasher{buckets: 1}}
+	if _, err := broken.mapKey("key"); !errors.Is(err, ErrBucketNotFound) {
+		t.Fatalf("expected a bucket without a node, got %v", err)
+	}
+}
+
+// Load balancer with none of the optional capabilities
+type coreLoadBalancer[T, O comparable] struct {
+	LoadBalancer[T, O]
+}
+
+func TestCapabilities(t *testing.T) {
+	for _, lb := range []LoadBalancer[string, string]{NewLoadBalancer[string, string](), NewConcurrentLoadBalancer[string, string]()} {
+		if _, err := capability[Feeder[string, string]](lb); err != nil {
+			t.Fatalf("expected a feeder, got %v", err)
+		}
+		if _, err := capability[Transactor[string, string]](lb); err != nil {
+			t.Fatalf("expected a transactor, got %v", err)
+		}
+		if _, err := capability[Pinner[string, string]](lb); err != nil {
+			t.Fatalf("expected a pinner, got %v", err)
+		}
+		if _, err := capability[Drainer[string, string]](lb); err != nil {
+			t.Fatalf("expected a drainer, got %v", err)
+		}
+		if _, err := capability[StatsReporter[string, string]](lb); err != nil {
+			t.Fatalf("expected a stats reporter, got %v", err)
+		}
+	}
+
+	// Functions needing a capability the load balancer lacks fail
+	core := coreLoadBalancer[string, string]{NewLoadBalancer[string, string]()}
+	if _, err := NewMirrorLoadBalancer[string, string](core); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected a primary without a feed unsupported, got %v", err)
+	}
+	node := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	if err := PaceDrain[string, string](context.Background(), core, nil, node, time.Millisecond, nil); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected draining unsupported, got %v", err)
+	}
+}
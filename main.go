@@ -6,14 +6,30 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"consistenthash"
+	"context"
 	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"hashing"
+	"io"
+	"maps"
 	"math/rand"
+	"net"
 	"net/netip"
 	"os"
+	"proxy"
 	"serverpool"
+	"simulator"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"topology"
 )
 
 const (
@@ -26,14 +42,46 @@ const (
 	ADDWORK
 	REMWORK
 	SHOWWORK
+	GENERATE
 	EXIT
 )
 
+// Exit codes in batch mode
+const (
+	exitOK = iota
+
+	// At least one command failed
+	exitCommandFailed
+
+	// At least one line of input could not be parsed
+	exitInvalidInput
+)
+
 var r *rand.Rand
 var addrs map[netip.Addr]struct{}
+var out *output
+
+// In batch mode input is not re-prompted and failures set the exit code
+var batch bool
+
+// errInvalidInput marks input that could not be parsed
+var errInvalidInput = errors.New("invalid input")
+
+// Convert a bulk node result for output
+func nodeResults(result NodesResult[netip.Addr, int]) []nodeResult {
+	var nodes []nodeResult
+	for _, nr := range result.Nodes {
+		res := nodeResult{Address: nr.Node.Name().String(), Bucket: nr.Bucket, Status: nr.Status.String()}
+		if nr.Err != nil {
+			res.Error = nr.Err.Error()
+		}
+		nodes = append(nodes, res)
+	}
+	return nodes
+}
 
 // Add the number of nodes specified to the load balancer
-func addNodes(lb LoadBalancer[netip.Addr, int], numNodes int) {
+func addNodes(lb LoadBalancer[netip.Addr, int], numNodes int) error {
 	var bs [4]byte
 	var nodes []serverpool.Node[netip.Addr, int]
 
@@ -43,206 +91,796 @@ func addNodes(lb LoadBalancer[netip.Addr, int], numNodes int) {
 
 		// Convert to byte array (little endian)
 		binary.BigEndian.PutUint32(bs[:], uint32(addr))
-		fmt.Println("Adding node with address:", bs)
+		out.info("Adding node with address:", bs)
 
 		node := NewServerNodeBytes[int](bs)
 		nodes = append(nodes, &node)
 
 		addrs[node.Name()] = struct{}{}
 	}
-	lb.AddNodes(nodes)
-}
-
-// Add a node with given address
-func addNode(lb LoadBalancer[netip.Addr, int], address string) {
-	ip, err := netip.ParseAddr(address)
+	result, err := lb.AddNodes(nodes)
+	res := commandResult{Command: "add", Nodes: nodeResults(result)}
 	if err != nil {
-		fmt.Println("Invalid address")
-		os.Exit(1)
+		res.Error = err.Error()
+		out.info("Error adding nodes:", err)
 	}
+	out.emit(res, "Added", result.Count(StatusOK), "of", len(nodes), "nodes")
+	return err
+}
 
+// Add a node with given address and weight
+func addNode(lb LoadBalancer[netip.Addr, int], ip netip.Addr, weight int) error {
 	if _, ok := addrs[ip]; ok {
-		fmt.Println("Node already present")
-		return
+		out.fail("addnode", "Node already present", nil)
+		return errors.New("node already present")
 	}
 
-	fmt.Println("Adding node with address:", ip)
+	out.info("Adding node with address:", ip)
 
-	node := NewServerNode[int](ip)
-	lb.AddNodes([]serverpool.Node[netip.Addr, int]{&node})
+	node := NewWeightedServerNode[int](ip, weight)
+	result, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&node})
+	if err != nil {
+		out.fail("addnode", "Error adding node", err)
+		return err
+	}
+	out.emit(commandResult{Command: "addnode", Nodes: nodeResults(result)},
+		"Node assigned bucket", result.Nodes[0].Bucket)
 
 	addrs[ip] = struct{}{}
+	return nil
 }
 
 // Delete a node with given address
-func delNode(lb LoadBalancer[netip.Addr,int], address string) {
-	ip, err := netip.ParseAddr(address)
-	if err != nil {
-		fmt.Println("Invalid address")
-		return
-	}
-
+func delNode(lb LoadBalancer[netip.Addr,int], ip netip.Addr) error {
 	if _, ok := addrs[ip]; !ok {
-		fmt.Println("Node not found")
-		return
+		out.fail("delnode", "Node not found", nil)
+		return errors.New("node not found")
 	}
 
-	fmt.Println("Deleting node with address:", ip)
+	out.info("Deleting node with address:", ip)
 
 	node := NewServerNode[int](ip)
-	lb.RemoveNodes([]serverpool.Node[netip.Addr, int]{&node})
+	result, err := lb.RemoveNodes([]serverpool.Node[netip.Addr, int]{&node})
+	res := commandResult{Command: "delnode", Nodes: nodeResults(result)}
+	if err != nil {
+		res.Error = err.Error()
+		out.info("Error deleting node:", err)
+	}
+	out.emit(res, "Reassigned", result.Reassigned(), "objects")
 
 	delete(addrs, ip)
+	return err
 }
 
-// Add work to the load balancer
-func addWork(lb LoadBalancer[netip.Addr, int], id string) {
-	objid, err := strconv.Atoi(id)
+// Map a key to a node
+func mapKey(lb LoadBalancer[netip.Addr, int], key string) error {
+	node, err := lb.GetNode(key)
 	if err != nil {
-		fmt.Println("Invalid object ID")
-		return
+		out.fail("map", "Error mapping key", err)
+		return err
 	}
+	out.emit(commandResult{Command: "map", Key: key, Node: node.Name().String()},
+		"Key", key, "maps to node", node)
+	return nil
+}
 
+// Add work to the load balancer
+func addWork(lb LoadBalancer[netip.Addr, int], objid int) error {
 	obj := NewWorkObject[netip.Addr](objid)
 
-	if err := lb.AddObjects([]*serverpool.Object[netip.Addr, int]{&obj.Object}); err != nil {
-		fmt.Println("Error adding work:", err)
-		return
+	if _, err := lb.AddObjects([]*serverpool.Object[netip.Addr, int]{&obj.Object}); err != nil {
+		out.fail("addwork", "Error adding work", err)
+		return err
 	}
 	if err := lb.AssignObject(&obj.Object); err != nil {
-		fmt.Println("Error assigning work:", err)
-		return
-	}	
+		out.fail("addwork", "Error assigning work", err)
+		return err
+	}
+	out.emit(commandResult{Command: "addwork", Objects: []objectEntry{workEntry(&obj.Object)}},
+		obj, "==>", workNode(&obj.Object))
+	return nil
 }
 
 // Remove work from the load balancer
-func remWork(lb LoadBalancer[netip.Addr, int], id string) {
-	objid, err := strconv.Atoi(id)
-	if err != nil {
-		fmt.Println("Invalid object ID")
-		return
+func remWork(lb LoadBalancer[netip.Addr, int], objid int) error {
+	if err := lb.UnassignObject(&serverpool.Object[netip.Addr, int]{Id: objid}); err != nil {
+		out.fail("remwork", "Error unassigning work", err)
+		return err
 	}
 
-	if err := lb.UnassignObject(&serverpool.Object[netip.Addr, int]{Id: objid}); err != nil {
-		fmt.Println("Error unassigning work:", err)
+	if _, err := lb.RemoveObjects([]*serverpool.Object[netip.Addr, int]{{Id: objid}}); err != nil {
+		out.fail("remwork", "Error removing work", err)
+		return err
+	}
+	out.emit(commandResult{Command: "remwork", Objects: []objectEntry{{Id: objid, Status: StatusOK.String()}}},
+		"Removed work", objid)
+	return nil
+}
+
+// Name of the node an object is assigned to, empty if unassigned
+func workNode(obj *serverpool.Object[netip.Addr, int]) string {
+	if obj.Node() == nil || *obj.Node() == nil {
+		return ""
+	}
+	return fmt.Sprint(*obj.Node())
+}
+
+func workEntry(obj *serverpool.Object[netip.Addr, int]) objectEntry {
+	entry := objectEntry{Id: obj.Id}
+	if obj.Node() != nil && *obj.Node() != nil {
+		entry.Node = (*obj.Node()).Name().String()
+	}
+	return entry
+}
+
+// Show nodes ordered by bucket
+func showNodes(lb LoadBalancer[netip.Addr, int], command string) {
+	var nodes []nodeResult
+	for node, bucket := range lb.Nodes() {
+		nodes = append(nodes, nodeResult{Address: node.Name().String(), Bucket: bucket})
+	}
+	slices.SortFunc(nodes, func(a, b nodeResult) int { return cmp.Compare(a.Bucket, b.Bucket) })
+
+	if out.json {
+		out.emit(commandResult{Command: command, Nodes: nodes})
 		return
 	}
+	for _, n := range nodes {
+		if command == "buckets" {
+			fmt.Fprintf(out.out, "Bucket: %d Node: %-15s\n", n.Bucket, "ServerNode("+n.Address+")")
+		} else {
+			fmt.Fprintf(out.out, "Node: %-15s Bucket: %d\n", "ServerNode("+n.Address+")", n.Bucket)
+		}
+	}
+}
+
+// Show work objects ordered by id
+func showWork(lb LoadBalancer[netip.Addr, int]) {
+	var objects []*serverpool.Object[netip.Addr, int]
+	for obj := range lb.Objects() {
+		objects = append(objects, obj)
+	}
+	slices.SortFunc(objects, func(a, b *serverpool.Object[netip.Addr, int]) int { return cmp.Compare(a.Id, b.Id) })
 
-	if err := lb.RemoveObjects([]*serverpool.Object[netip.Addr, int]{{Id: objid}}); err != nil {
-		fmt.Println("Error removing work:", err)
+	if out.json {
+		var entries []objectEntry
+		for _, obj := range objects {
+			entries = append(entries, workEntry(obj))
+		}
+		out.emit(commandResult{Command: "work", Objects: entries})
 		return
 	}
+	for _, obj := range objects {
+		fmt.Fprintln(out.out, obj, "==>", workNode(obj))
+	}
 }
 
-func readNewLine(reader *bufio.Reader) string {
-	text, _ := reader.ReadString('\n') // Read until newline
-	text = text[:len(text)-1]          // Remove newline character
+// Read a line without the line ending. Returns io.EOF once input ends.
+func readNewLine(reader *bufio.Reader) (string, error) {
+	text, err := reader.ReadString('\n') // Read until newline
+	if err != nil && len(text) == 0 {
+		return "", err
+	}
+	return strings.TrimRight(text, "\r\n"), nil
+}
 
-	return text
+// Parse a positive number
+func parseCount(text string) (int, error) {
+	n, err := strconv.Atoi(text)
+	if err == nil && n <= 0 {
+		err = errors.New("must be positive")
+	}
+	return n, err
 }
 
-func main() {
-	lb := NewLoadBalancer[netip.Addr,int]()
-	r = rand.New(rand.NewSource(time.Now().UnixNano()))
-	addrs = make(map[netip.Addr]struct{})
+// Address of a node to add and its weight
+type weightedAddr struct {
+	ip     netip.Addr
+	weight int
+}
 
-	reader := bufio.NewReader(os.Stdin)
-
-	op := 0
-	for op < EXIT {
-		fmt.Println()
-		fmt.Println("1. Add nodes")
-		fmt.Println("2. Add node")
-		fmt.Println("3. Delete node")
-		fmt.Println("4. Map Key")
-		fmt.Println("5. Show Nodes")
-		fmt.Println("6. Show Buckets")
-		fmt.Println("7. Add Work")
-		fmt.Println("8. Remove Work")
-		fmt.Println("9. Show Work")
-		fmt.Println("10. Exit")
-		fmt.Print("Operation: ")
-		text := readNewLine(reader)
-
-		op, err := strconv.Atoi(text)
-		if err != nil {
-			fmt.Println("Invalid operation")
-			os.Exit(1)
-		}
-		switch op {
-		case ADD:
-			fmt.Print("Enter number of nodes to add: ")
-		text := readNewLine(reader)
-
-			numNodes, err := strconv.Atoi(text)
-			if err != nil {
-				fmt.Println("Invalid number of nodes")
-				os.Exit(1)
-			}
+// Parse an address optionally followed by a positive weight, 1 by default
+func parseWeightedAddr(text string) (weightedAddr, error) {
+	addr, w, hasWeight := strings.Cut(strings.TrimSpace(text), " ")
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return weightedAddr{}, err
+	}
+	weight := 1
+	if hasWeight {
+		if weight, err = parseCount(strings.TrimSpace(w)); err != nil {
+			return weightedAddr{}, fmt.Errorf("weight: %w", err)
+		}
+	}
+	return weightedAddr{ip, weight}, nil
+}
+
+// Parse a non-empty key
+func parseKey(text string) (string, error) {
+	if len(text) == 0 {
+		return "", errors.New("key cannot be empty")
+	}
+	return text, nil
+}
+
+// Prompt for a line and parse it. In interactive mode invalid input is
+// reported and the prompt repeated; in batch mode errInvalidInput is returned.
+func ask[V any](reader lineReader, command, prompt, what string, parse func(string) (V, error), completions func() []string) (V, error) {
+	for {
+		text, err := reader.readLine(prompt, completions)
+		if err != nil {
+			var zero V
+			return zero, err
+		}
+
+		v, err := parse(text)
+		if err == nil {
+			return v, nil
+		}
+		out.fail(command, "Invalid "+what, err)
+		if batch {
+			return v, errInvalidInput
+		}
+	}
+}
+
+// Addresses of the nodes in the cluster for completion
+func nodeAddresses() []string {
+	var candidates []string
+	for addr := range addrs {
+		candidates = append(candidates, addr.String())
+	}
+	slices.Sort(candidates)
+	return candidates
+}
+
+// Ids of the work objects for completion
+func objectIds(lb LoadBalancer[netip.Addr, int]) func() []string {
+	return func() []string {
+		var candidates []string
+		for obj := range lb.Objects() {
+			candidates = append(candidates, strconv.Itoa(obj.Id))
+		}
+		slices.Sort(candidates)
+		return candidates
+	}
+}
+
+// Completions computed holding mu, as admin requests change the nodes and
+// objects while a prompt waits for input
+func lockedCompletions(mu sync.Locker, fn func() []string) func() []string {
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return fn()
+	}
+}
+
+// Read the input of an operation without holding mu, then return the
+// command running it, which the caller runs holding mu
+func readCommand(lb LoadBalancer[netip.Addr, int], reader lineReader, op int, mu sync.Locker) (func() error, error) {
+	switch op {
+	case ADD:
+		numNodes, err := ask(reader, "add", "Enter number of nodes to add: ", "number of nodes", parseCount, nil)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			out.info("Adding", numNodes, "nodes")
+			return addNodes(lb, numNodes)
+		}, nil
+
+	case ADDNODE:
+		na, err := ask(reader, "addnode", "Enter address of node to add and optionally its weight: ", "address", parseWeightedAddr, nil)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			out.info("Adding node", na.ip, "with weight", na.weight)
+			return addNode(lb, na.ip, na.weight)
+		}, nil
+
+	case DELNODE:
+		ip, err := ask(reader, "delnode", "Enter address of node to delete: ", "address", netip.ParseAddr, lockedCompletions(mu, nodeAddresses))
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			out.info("Deleting node", ip)
+			return delNode(lb, ip)
+		}, nil
+
+	case MAP:
+		key, err := ask(reader, "map", "Enter key to map: ", "key", parseKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		return func() error { return mapKey(lb, key) }, nil
+
+	case SHOWNODES:
+		return func() error {
+			out.info("Nodes in the cluster:")
+			showNodes(lb, "nodes")
+			return nil
+		}, nil
+
+	case SHOWBUCKETS:
+		return func() error {
+			out.info("Buckets in the cluster:")
+			showNodes(lb, "buckets")
+			return nil
+		}, nil
+
+	case ADDWORK:
+		id, err := ask(reader, "addwork", "Enter id of work object to add: ", "object ID", strconv.Atoi, nil)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			out.info("Adding work", id)
+			return addWork(lb, id)
+		}, nil
 
-			fmt.Println("Adding", numNodes, "nodes")
-			addNodes(lb, numNodes)
+	case REMWORK:
+		id, err := ask(reader, "remwork", "Enter id of work object to remove: ", "object ID", strconv.Atoi, lockedCompletions(mu, objectIds(lb)))
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			out.info("Removing work", id)
+			return remWork(lb, id)
+		}, nil
+
+	case SHOWWORK:
+		return func() error {
+			out.info("Work assigned to nodes:")
+			showWork(lb)
+			return nil
+		}, nil
+
+	case GENERATE:
+		numNodes, err := ask(reader, "generate", "Enter number of nodes to add: ", "number of nodes", parseCount, nil)
+		if err != nil {
+			return nil, err
+		}
+		numAccesses, err := ask(reader, "generate", "Enter number of object accesses: ", "number of accesses", parseCount, nil)
+		if err != nil {
+			return nil, err
+		}
+		dist, err := ask(reader, "generate", "Enter key distribution (uniform, zipf[:s], hotset[:fraction:probability], trace:file, clf:field:file, jsonl:field:file, pcap:field:file): ", "distribution", parseDistribution,
+			simulator.Names)
+		if err != nil {
+			return nil, err
+		}
+		return func() error { return generate(lb, numNodes, numAccesses, dist) }, nil
+	}
+	return func() error { return nil }, nil
+}
 
-		case ADDNODE:
-			fmt.Print("Enter address of node to add: ")
-			text := readNewLine(reader)
+// Compare the hash algorithms and the consistent hashing libraries on the
+// default scenario and write the report to a file, or stdout for -
+func writeComparison(path string) error {
+	s := consistenthash.DefaultScenario
+	rings := consistenthash.BuiltinRings(hashing.DefaultHashAlgorithm)
+	maps.Copy(rings, libraryRings(hashing.DefaultHashAlgorithm))
+	results := consistenthash.Compare(rings, s)
+	if path == "-" {
+		return consistenthash.WriteComparisonReport(os.Stdout, s, results)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := consistenthash.WriteComparisonReport(f, s, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-			fmt.Println("Adding node", text)
-			addNode(lb, text)
+// Parse a menu operation
+func parseOperation(text string) (int, error) {
+	op, err := strconv.Atoi(text)
+	if err == nil && (op < ADD || op > EXIT) {
+		err = fmt.Errorf("must be between %d and %d", ADD, EXIT)
+	}
+	return op, err
+}
 
-		case DELNODE:
-			fmt.Print("Enter address of node to delete: ")
-			text := readNewLine(reader)
+func showMenu() {
+	out.info()
+	out.info("1. Add nodes")
+	out.info("2. Add node")
+	out.info("3. Delete node")
+	out.info("4. Map Key")
+	out.info("5. Show Nodes")
+	out.info("6. Show Buckets")
+	out.info("7. Add Work")
+	out.info("8. Remove Work")
+	out.info("9. Show Work")
+	out.info("10. Generate Workload")
+	out.info("11. Exit")
+}
 
-			fmt.Println("Deleting node", text)
-			delNode(lb, text)
+func main() {
+	jsonOutput := flag.Bool("json", false, "print command results as JSON, one object per line")
+	flag.BoolVar(&batch, "batch", false, "read operations from input without menus and exit with a non-zero status if any fail")
+	edsAddr := flag.String("xds", "", "serve the nodes to Envoy over REST EDS on this address, e.g. :18000")
+	edsCluster := flag.String("xds-cluster", "loadbalance", "Envoy cluster name of the nodes served over EDS")
+	edsPort := flag.Uint("xds-port", 80, "port of the nodes served over EDS")
+	dnsAddr := flag.String("dns", "", "serve the nodes as DNS SRV and A records on this UDP address, e.g. :5353")
+	dnsZone := flag.String("dns-zone", "lb.local", "DNS zone of the nodes")
+	dnsService := flag.String("dns-service", "_http._tcp", "service and protocol labels of the SRV records")
+	dnsPort := flag.Uint("dns-port", 80, "port of the nodes in SRV records")
+	proxyConf := flag.String("proxy-conf", "", "write the nodes as proxy upstream configuration to this file after each command")
+	proxyFormat := flag.String("proxy-format", "nginx", "format of the proxy configuration, nginx or haproxy")
+	proxyName := flag.String("proxy-upstream", "loadbalance", "name of the upstream or backend in the proxy configuration")
+	proxyPort := flag.Uint("proxy-port", 80, "port of the nodes in the proxy configuration")
+	proxyReload := flag.String("proxy-reload", "", "command run when the proxy configuration changes, e.g. \"nginx -s reload\"")
+	proxyAddr := flag.String("proxy", "", "proxy HTTP requests on this address to the node of their key, e.g. :8000")
+	proxyKey := flag.String("proxy-key", "remote", "key of proxied requests, header:<name>, cookie:<name> or remote for the client address, comma separated to fall back")
+	proxyBackendPort := flag.Uint("proxy-backend-port", 80, "port requests are proxied to on nodes without a backend URL")
+	proxyConcurrency := flag.Int("proxy-concurrency", 0, "requests proxied to a node at once, others wait in a queue, 0 for no limit")
+	proxyQueueDepth := flag.Int("proxy-queue-depth", 100, "requests waiting for a node beyond which its queue is full")
+	proxyQueueWait := flag.Duration("proxy-queue-wait", 10*time.Second, "time a request waits for its node before it fails, 0 for no limit")
+	proxyOverflow := flag.String("proxy-overflow", "shed", "what happens to requests whose node queue is full, shed with 503 or spill to the next replica of their key")
+	tcpProxyAddr := flag.String("tcp-proxy", "", "forward TCP connections on this address to the node of their client address, e.g. :9000")
+	tcpProxyPort := flag.Uint("tcp-proxy-port", 80, "port TCP connections are forwarded to on the nodes")
+	tcpProxyDrain := flag.Duration("tcp-proxy-drain", 30*time.Second, "time connections to a removed node carry on before they are closed")
+	healthCheck := flag.String("health-check", "", "probe the nodes and take those failing out of rotation until they pass again, tcp or http[:<path>]")
+	healthPort := flag.Uint("health-port", 80, "port the nodes are probed on")
+	healthInterval := flag.Duration("health-interval", DefaultHealthInterval, "time between health probes")
+	healthTimeout := flag.Duration("health-timeout", DefaultHealthTimeout, "time a health probe waits for an answer")
+	healthUnhealthy := flag.Int("health-unhealthy", DefaultUnhealthyThreshold, "failed probes in a row before a node is taken out of rotation")
+	healthHealthy := flag.Int("health-healthy", DefaultHealthyThreshold, "passed probes in a row before a node is put back into rotation")
+	feedRetention := flag.Int("feed-retention", DefaultFeedRetention, "changes kept for exports and history, 0 to keep every change")
+	rebalanceProfile := flag.Int("rebalance-profile", 0, "time the phases of rebalances moving at least this many objects, served by the admin UI at /api/rebalances, 0 for off")
+	auditLogPath := flag.String("audit-log", "", "append a JSON report of each removal of nodes to this file, also served by the admin UI at /api/decommissions")
+	costReport := flag.String("cost-report", "", "record the objects on each node after each command and write them to this CSV file")
+	metricsAddr := flag.String("metrics", "", "serve Prometheus metrics on this address at /metrics, e.g. :9100")
+	discover := flag.String("discover", "", "add the instances of a cloud provider as nodes and track them, ec2:<region>:<tag>=<value>,... or gce:<project>/<zone>/<group>")
+	discoverInterval := flag.Duration("discover-interval", 30*time.Second, "how often discovered instances are listed")
+	export := flag.String("export", "", "export changes to file:<path> as JSON lines, webhook:<url>, kafka:<REST proxy url>/topics/<topic> or redis:<host:port>/<channel>")
+	exportCursor := flag.String("export-cursor", "", "file keeping the version of the last exported change, changes are exported from the start if empty")
+	adminAddr := flag.String("admin", "", "serve a web UI to manage the nodes on this address, e.g. localhost:8080, a port alone listens on localhost only")
+	adminToken := flag.String("admin-token", "", "require the bearer token in this file for the admin API, needed unless --admin listens on loopback only; the UI takes it from /#token=<token>")
+	topologyAddr := flag.String("topology", "", "serve topology snapshots to thin clients on this address, e.g. :8300")
+	topologyKey := flag.String("topology-key", "", "sign topology snapshots with the HMAC key in this file")
+	hashStrategy := flag.String("hash", "memento", "consistent hash algorithm, memento, rendezvous or maglev")
+	maglevSize := flag.Int("maglev-size", consistenthash.DefaultMaglevTableSize, "slots in the table of maglev hashing, rounded up to a prime")
+	webhook := flag.String("webhook", "", "notify this URL of objects assigned or moved and nodes removed, comma separated for several")
+	webhookKey := flag.String("webhook-key", "", "sign webhook requests with the HMAC key in this file")
+	statsdAddr := flag.String("statsd", "", "send metrics to the statsd server at this UDP address, e.g. localhost:8125")
+	handoff := flag.String("handoff", "", "take over the nodes and objects of the process serving handoffs on this unix socket, then serve handoffs on it to the next process")
+	statePath := flag.String("state", "", "restore the nodes and objects from this file on start if it exists and save them to it after each command, memento hashing only")
+	compare := flag.String("compare", "", "compare the consistent hash algorithms and libraries, write a Markdown report to this file, - for stdout, and exit")
+	flag.Parse()
+
+	if *compare != "" {
+		if err := writeComparison(*compare); err != nil {
+			fmt.Fprintln(os.Stderr, "Error comparing hash algorithms:", err)
+			os.Exit(exitCommandFailed)
+		}
+		os.Exit(exitOK)
+	}
 
-		case MAP:
-			fmt.Print("Enter key to map: ")
-			key := readNewLine(reader)
+	out = &output{json: *jsonOutput, out: os.Stdout, log: os.Stdout}
+	if out.json {
+		out.log = os.Stderr
+	}
+	if batch {
+		out.log = io.Discard
+	}
 
-			node, err := lb.GetNode(key)
+	var metrics []Metrics
+	if *metricsAddr != "" {
+		metrics = append(metrics, serveMetrics(*metricsAddr))
+	}
+	if *statsdAddr != "" {
+		conn, err := net.Dial("udp", *statsdAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error connecting to statsd:", err)
+			os.Exit(exitInvalidInput)
+		}
+		metrics = append(metrics, NewStatsdMetrics(conn, ""))
+	}
+	var opts []Option[netip.Addr, int]
+	strategy, err := consistenthash.ParseStrategy(*hashStrategy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitInvalidInput)
+	}
+	if strategy == consistenthash.StrategyMaglev {
+		opts = append(opts, WithMaglevTable[netip.Addr, int](*maglevSize))
+	} else {
+		opts = append(opts, WithHashStrategy[netip.Addr, int](strategy))
+	}
+	if len(metrics) > 0 {
+		opts = append(opts, WithMetrics[netip.Addr, int](TeeMetrics(metrics...)))
+	}
+	if *webhook != "" {
+		var key []byte
+		if *webhookKey != "" {
+			b, err := os.ReadFile(*webhookKey)
 			if err != nil {
-				fmt.Println("Error mapping key:", err)
-			} else {
-				fmt.Println("Key", key, "maps to node", node)
+				fmt.Fprintln(os.Stderr, "Error reading webhook key:", err)
+				os.Exit(exitInvalidInput)
+			}
+			key = bytes.TrimSpace(b)
+		}
+		var hooks []Webhook
+		for _, url := range strings.Split(*webhook, ",") {
+			hooks = append(hooks, Webhook{URL: url, Secret: key})
+		}
+		opts = append(opts, WithWebhooks[netip.Addr, int](hooks, func(err error) {
+			out.info("Webhook error:", err)
+		}))
+	}
+	if *auditLogPath != "" {
+		opts = append(opts, WithDecommissionReports(0, auditLog[netip.Addr, int](*auditLogPath)))
+	} else if *adminAddr != "" {
+		opts = append(opts, WithDecommissionReports[netip.Addr, int](0, nil))
+	}
+	opts = append(opts, WithFeedRetention[netip.Addr, int](*feedRetention))
+	if *rebalanceProfile > 0 {
+		opts = append(opts, WithRebalanceProfiling[netip.Addr, int](*rebalanceProfile))
+	}
+	opts = append(opts, WithDrainCallback(func(node serverpool.Node[netip.Addr, int]) {
+		out.info("Node", node.Name(), "drained")
+	}))
+	// Proxies route from their own goroutines while commands wait for input
+	// holding the lock commands and admin requests share, so the load
+	// balancer has a lock of its own for them
+	lb := NewConcurrentLoadBalancer(opts...)
+	r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	addrs = make(map[netip.Addr]struct{})
+	newNode := func(addr netip.Addr) serverpool.Node[netip.Addr, int] {
+		node := NewServerNode[int](addr)
+		addrs[addr] = struct{}{}
+		return &node
+	}
+	tookOver := false
+	if *handoff != "" {
+		var err error
+		if tookOver, err = takeOver(*handoff, lb, newNode); err != nil {
+			fmt.Fprintln(os.Stderr, "Error taking over:", err)
+			os.Exit(exitInvalidInput)
+		}
+	}
+	if *statePath != "" && !tookOver {
+		if err := loadStateFile(lb, *statePath, newNode); err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading state:", err)
+			os.Exit(exitInvalidInput)
+		}
+	}
+
+	// Servers read a snapshot of the nodes refreshed after each command
+	var snapshot *nodeSnapshot
+	if *edsAddr != "" || *dnsAddr != "" {
+		snapshot = &nodeSnapshot{}
+		snapshot.update(lb)
+	}
+	if *edsAddr != "" {
+		serveEDS(snapshot, *edsAddr, *edsCluster, uint32(*edsPort))
+	}
+	if *dnsAddr != "" {
+		serveDNS(snapshot, *dnsAddr, *dnsZone, *dnsService, uint16(*dnsPort))
+	}
+	var topologyServer *topology.Server
+	if *topologyAddr != "" {
+		var key []byte
+		if *topologyKey != "" {
+			b, err := os.ReadFile(*topologyKey)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error reading topology key:", err)
+				os.Exit(exitInvalidInput)
+			}
+			key = bytes.TrimSpace(b)
+		}
+		topologyServer = serveTopology(*topologyAddr, key)
+		publishTopology(topologyServer, lb)
+	}
+	var upstream *upstreamWriter
+	if *proxyConf != "" {
+		upstream = newUpstreamWriter(*proxyConf, *proxyFormat, *proxyName, uint32(*proxyPort), *proxyReload)
+		upstream.update(lb)
+	}
+	var costs *costWriter
+	if *costReport != "" {
+		costs = newCostWriter(*costReport)
+	}
+
+	// Refresh what is served or written from the nodes after a change
+	refresh := func() {
+		if snapshot != nil {
+			snapshot.update(lb)
+		}
+		if upstream != nil {
+			upstream.update(lb)
+		}
+		if topologyServer != nil {
+			publishTopology(topologyServer, lb)
+		}
+		if costs != nil {
+			costs.update(lb)
+		}
+		if *statePath != "" {
+			if err := saveStateFile(lb, *statePath); err != nil {
+				out.info("Error saving state:", err)
 			}
+		}
+	}
 
-		case SHOWNODES:
-			fmt.Println("Nodes in the cluster:")
-			for node, bucket := range lb.Nodes() {
-				fmt.Printf("Node: %-15s Bucket: %d\n", node, bucket)
+	// Changes are exported from their own goroutine, closing the exporter
+	// delivers the changes still pending on exit
+	closeExporter := func() {}
+	if *export != "" {
+		sink, err := parseChangeSink(*export)
+		var cursor ChangeCursor = &memoryCursor{}
+		if *exportCursor != "" {
+			cursor = FileCursor(*exportCursor)
+		}
+		var exporter *ChangeExporter[netip.Addr, int]
+		if err == nil {
+			exporter, err = ExportChanges(lb, sink, cursor, 100, func(err error) {
+				fmt.Fprintln(os.Stderr, "Error exporting changes:", err)
+			})
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error exporting changes:", err)
+			os.Exit(exitInvalidInput)
+		}
+		closeExporter = func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := exporter.Close(ctx); err != nil {
+				fmt.Fprintln(os.Stderr, "Error exporting changes:", err)
 			}
+		}
+	}
 
-		case SHOWBUCKETS:
-			fmt.Println("Buckets in the cluster:")
-			for bucket, node := range lb.Buckets() {
-				fmt.Printf("Bucket: %d Node: %-15s\n", bucket, node)
+	// Commands, admin requests and discovery take turns with the load
+	// balancer
+	var mu sync.Mutex
+	if *discover != "" {
+		d, err := parseDiscoverer(*discover)
+		if err == nil {
+			err = watchDiscovery(context.Background(), lb, d, *discoverInterval, &mu, refresh)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error discovering nodes:", err)
+			os.Exit(exitInvalidInput)
+		}
+	}
+	if *adminAddr != "" {
+		var token string
+		if *adminToken != "" {
+			b, err := os.ReadFile(*adminToken)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error reading admin token:", err)
+				os.Exit(exitInvalidInput)
+			}
+			if token = string(bytes.TrimSpace(b)); token == "" {
+				fmt.Fprintln(os.Stderr, "Error reading admin token: empty file", *adminToken)
+				os.Exit(exitInvalidInput)
 			}
-		case ADDWORK:
-			fmt.Print("Enter id of work object to add: ")
-			text := readNewLine(reader)
+		}
+		if err := serveAdmin(*adminAddr, token, &mu, lb, refresh); err != nil {
+			fmt.Fprintln(os.Stderr, "Error serving admin UI:", err)
+			os.Exit(exitInvalidInput)
+		}
+	}
+	if *proxyAddr != "" {
+		key, err := proxy.ParseKey(*proxyKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing proxy key:", err)
+			os.Exit(exitInvalidInput)
+		}
+		overflow, err := proxy.ParseOverflow(*proxyOverflow)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing proxy overflow:", err)
+			os.Exit(exitInvalidInput)
+		}
+		queue := proxy.QueueConfig{MaxConcurrent: *proxyConcurrency, MaxDepth: *proxyQueueDepth,
+			MaxWait: *proxyQueueWait, Overflow: overflow}
+		if len(metrics) > 0 {
+			queue.Metrics = proxyQueueMetrics{TeeMetrics(metrics...)}
+		}
+		serveProxy(*proxyAddr, lb, key, uint16(*proxyBackendPort), queue)
+	}
+	if *tcpProxyAddr != "" {
+		serveTCPProxy(*tcpProxyAddr, lb, uint16(*tcpProxyPort), *tcpProxyDrain)
+	}
+	if *healthCheck != "" {
+		probe, err := ParseProber[netip.Addr, int](*healthCheck, uint16(*healthPort))
+		var checker *HealthChecker[netip.Addr, int]
+		if err == nil {
+			checker, err = NewHealthChecker(lb, &mu, HealthCheckConfig[netip.Addr, int]{Probe: probe,
+				Interval: *healthInterval, Timeout: *healthTimeout,
+				UnhealthyThreshold: *healthUnhealthy, HealthyThreshold: *healthHealthy,
+				OnChange: func(node serverpool.Node[netip.Addr, int], healthy bool, err error) {
+					if healthy {
+						out.info("Node", node.Name(), "passes health checks again")
+					} else {
+						out.info("Node", node.Name(), "fails health checks:", err)
+					}
+					refresh()
+				}})
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error checking health:", err)
+			os.Exit(exitInvalidInput)
+		}
+		go checker.Run(context.Background())
+	}
 
-			fmt.Println("Adding work", text)
-			addWork(lb, text)
+	var reader lineReader = bufferedReader{bufio.NewReader(os.Stdin)}
+	restore := func() {}
 
-		case REMWORK:
-			fmt.Print("Enter id of work object to remove: ")
-			text := readNewLine(reader)
+	// Edit lines with history and completion when used from a terminal
+	if !batch && !out.json && isTerminal() {
+		if t, reset, err := newTerminalReader(); err == nil {
+			reader, restore = t, reset
+			out.out, out.log = t, t
+		}
+	}
 
-			fmt.Println("Removing work", text)
-			remWork(lb, text)
+	// The successor takes over from here, the lock stays held until exit
+	if *handoff != "" {
+		err := serveHandoff(*handoff, &mu, lb, func() {
+			restore()
+			closeExporter()
+			os.Exit(exitOK)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error serving handoffs:", err)
+			os.Exit(exitInvalidInput)
+		}
+	}
 
-		case SHOWWORK:
-			fmt.Println("Work assigned to nodes:")
-			for obj := range lb.Objects() {
-				fmt.Println(obj, "==>", *obj.Node())
-			}
+	status := exitOK
+	for {
+		showMenu()
+		op, err := ask(reader, "", "Operation: ", "operation", parseOperation, nil)
+		if err == io.EOF || op == EXIT {
+			break
+		}
+
+		// Admin and proxy requests go on while a prompt waits for input
+		var command func() error
+		if err == nil {
+			command, err = readCommand(lb, reader, op, &mu)
+		}
+		if err == nil {
+			mu.Lock()
+			err = command()
+			refresh()
+			mu.Unlock()
+		}
+		switch {
+		case err == io.EOF:
+			restore()
+			closeExporter()
+			os.Exit(max(status, exitInvalidInput))
+		case errors.Is(err, errInvalidInput):
+			status = max(status, exitInvalidInput)
+		case err != nil:
+			status = max(status, exitCommandFailed)
+		}
 
-		case EXIT:
-			os.Exit(0)
+		if !batch {
+			if _, err := reader.readLine("Hit [Enter] to continue.", nil); err == io.EOF {
+				break
+			}
 		}
-		fmt.Print("Hit [Enter] to continue.")
-		_ = readNewLine(reader)
 	}
+	restore()
+	closeExporter()
+
+	// Interactive sessions only end on request, so failures do not
+	// affect their exit status
+	if !batch {
+		status = exitOK
+	}
+	os.Exit(status)
 }
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+// Input of a command read while checking that mu is free
+type unlockedReader struct {
+	t    *testing.T
+	mu   *sync.Mutex
+	line string
+}
+
+func (u unlockedReader) readLine(prompt string, completions func() []string) (string, error) {
+	if !u.mu.TryLock() {
+		u.t.Fatalf("expected %q prompted without holding the lock", prompt)
+	}
+	u.mu.Unlock()
+	if completions != nil {
+		completions()
+	}
+	return u.line, nil
+}
+
+func TestReadCommand(t *testing.T) {
+	savedOut, savedAddrs := out, addrs
+	t.Cleanup(func() { out, addrs = savedOut, savedAddrs })
+	var results bytes.Buffer
+	out = &output{out: &results, log: &bytes.Buffer{}}
+	addrs = make(map[netip.Addr]struct{})
+
+	var mu sync.Mutex
+	lb := NewLoadBalancer[netip.Addr, int]()
+	objects := func() int {
+		n := 0
+		for range lb.Objects() {
+			n++
+		}
+		return n
+	}
+	for _, in := range []struct {
+		op   int
+		line string
+	}{{ADDNODE, "10.0.0.1"}, {ADDWORK, "1"}, {REMWORK, "1"}, {DELNODE, "10.0.0.1"}} {
+		command, err := readCommand(lb, unlockedReader{t, &mu, in.line}, in.op, &mu)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		// Nothing changes until the command runs
+		nodes, objs := lb.NodeCount(), objects()
+		if results.Len() != 0 {
+			t.Fatalf("expected no results before the command runs, got %q", results.String())
+		}
+		mu.Lock()
+		err = command()
+		mu.Unlock()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if lb.NodeCount() == nodes && objects() == objs {
+			t.Fatalf("expected operation %d to change the load balancer", in.op)
+		}
+		results.Reset()
+	}
+}
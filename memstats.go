@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Memory usage estimates for capacity planning
+
+package main
+
+import (
+	"fmt"
+	"hashing"
+	"serverpool"
+	"unsafe"
+)
+
+// MemoryStats estimates the bytes held by a load balancer. Nodes and any
+// data referenced by objects are owned by the caller and not counted.
+type MemoryStats struct {
+	// Object map and the objects it holds
+	Objects int
+
+	// Node and bucket maps of the server pool
+	ServerPool int
+
+	// Removal table and lookup tables of the consistent hasher
+	Hasher int
+
+	// History kept by the change feed
+	ChangeFeed int
+}
+
+// Total estimated bytes
+func (m MemoryStats) Total() int {
+	return m.Objects + m.ServerPool + m.Hasher + m.ChangeFeed
+}
+
+func (m MemoryStats) String() string {
+	return fmt.Sprintf("MemoryStats(objects: %d, serverpool: %d, hasher: %d, changefeed: %d, total: %d)",
+		m.Objects, m.ServerPool, m.Hasher, m.ChangeFeed, m.Total())
+}
+
+// Implemented by components that can estimate their own memory usage
+type memoryReporter interface {
+	MemoryUsage() int
+}
+
+// Estimate the memory used by the load balancer's own data structures
+func (lb *loadBalancer[T, O]) MemoryStats() MemoryStats {
+	var stats MemoryStats
+	var id O
+
+	// Each object costs a map entry plus the object itself
+	entry := unsafe.Sizeof(id) + unsafe.Sizeof((*serverpool.Object[T, O])(nil))
+	perObject := hashing.MapBytes(1, entry) - hashing.MapBytes(0, entry) + int(unsafe.Sizeof(serverpool.Object[T, O]{}))
+	stats.Objects = hashing.MapBytes(0, entry) + lb.objects.len()*perObject
+
+	if m, ok := lb.sp.(memoryReporter); ok {
+		stats.ServerPool = m.MemoryUsage()
+	}
+	if m, ok := lb.ch.(memoryReporter); ok {
+		stats.Hasher = m.MemoryUsage()
+	}
+
+	var node serverpool.Node[T, O]
+	stats.ChangeFeed = cap(lb.feed.log) * int(unsafe.Sizeof(Change[T, O]{}))
+	for _, c := range lb.feed.log {
+		stats.ChangeFeed += cap(c.Nodes)*int(unsafe.Sizeof(node)) + cap(c.Objects)*int(unsafe.Sizeof(id)) + cap(c.Keys)*int(unsafe.Sizeof(""))
+	}
+	return stats
+}
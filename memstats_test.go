@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"hashing"
+	"serverpool"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestMemoryStats(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	empty := lb.(StatsReporter[string, string]).MemoryStats()
+	if empty.Objects == 0 || empty.Total() != empty.Objects+empty.ServerPool+empty.Hasher+empty.ChangeFeed {
+		t.Fatalf("expected the object map counted in the total, got %v", empty)
+	}
+
+	var nodes []serverpool.Node[string, string]
+	for i := range 10 {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i),
+			objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	withNodes := lb.(StatsReporter[string, string]).MemoryStats()
+	if withNodes.ServerPool <= empty.ServerPool || withNodes.ChangeFeed <= empty.ChangeFeed {
+		t.Fatalf("expected nodes to grow the server pool and change feed, got %v after %v", withNodes, empty)
+	}
+	if withNodes.Objects != empty.Objects {
+		t.Fatalf("expected nodes to leave the object memory alone, got %v after %v", withNodes, empty)
+	}
+
+	// Every object costs its map entry and itself
+	var objs []*serverpool.Object[string, string]
+	for i := range 100 {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	entry := unsafe.Sizeof("") + unsafe.Sizeof((*serverpool.Object[string, string])(nil))
+	perObject := hashing.MapBytes(1, entry) - hashing.MapBytes(0, entry) + int(unsafe.Sizeof(serverpool.Object[string, string]{}))
+	withObjects := lb.(StatsReporter[string, string]).MemoryStats()
+	if got := withObjects.Objects - withNodes.Objects; got != len(objs)*perObject {
+		t.Fatalf("expected %d bytes for %d objects, got %d", len(objs)*perObject, len(objs), got)
+	}
+	if withObjects.ServerPool != withNodes.ServerPool {
+		t.Fatalf("expected objects to leave the server pool alone, got %v after %v", withObjects, withNodes)
+	}
+
+	// The hasher remembers removed buckets
+	if _, err := lb.RemoveNodes(nodes[3:4]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if removed := lb.(StatsReporter[string, string]).MemoryStats(); removed.Hasher <= withObjects.Hasher {
+		t.Fatalf("expected a removal to grow the hasher, got %v after %v", removed, withObjects)
+	}
+
+	if s := withObjects.String(); !strings.Contains(s, fmt.Sprintf("total: %d", withObjects.Total())) {
+		t.Fatalf("expected the total in %s", s)
+	}
+}
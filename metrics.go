@@ -0,0 +1,299 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Metrics reported by the load balancer to a pluggable sink
+
+package main
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+)
+
+// Names of the metrics the load balancer reports
+const (
+	// Counter of operations by the op label, one of the ProfileLabel values
+	MetricOperations = "loadbalance_operations_total"
+
+	// Histogram of the duration of operations in seconds by the op label
+	MetricOperationSeconds = "loadbalance_operation_seconds"
+
+	// Counter of objects moved between nodes
+	MetricObjectsMoved = "loadbalance_objects_moved_total"
+
+	// Gauges of the current state
+	MetricNodes       = "loadbalance_nodes"
+	MetricBuckets     = "loadbalance_buckets"
+	MetricObjects     = "loadbalance_objects"
+	MetricDeferred    = "loadbalance_deferred_objects"
+	MetricDraining    = "loadbalance_draining_nodes"
+	MetricQuarantined = "loadbalance_quarantined_nodes"
+	MetricVersion     = "loadbalance_version"
+
+	// Gauge of the objects on each node by the node label
+	MetricNodeObjects = "loadbalance_node_objects"
+
+	// Gauge of the objects on the fullest node relative to the mean, 1 when
+	// objects are spread evenly
+	MetricObjectSkew = "loadbalance_object_skew"
+
+	// Histogram of the duration of GetNode in seconds
+	MetricLookupSeconds = "loadbalance_lookup_seconds"
+
+	// Counter of lookups finding no node, whether they failed or were
+	// served by the fallback node
+	MetricLookupMisses = "loadbalance_lookup_misses_total"
+
+	// Counter of buckets becoming skewed by the measure label, hits or
+	// objects, see WithBucketStats
+	MetricBucketSkewAlarms = "loadbalance_bucket_skew_alarms_total"
+
+	// Gauge of the variance of the objects of the buckets
+	MetricBucketObjectVariance = "loadbalance_bucket_object_variance"
+)
+
+// Metrics creates the instruments the load balancer reports to. Labels are
+// given as name, value pairs. Implementations are called for the same name
+// and labels repeatedly and should return the same instrument each time.
+type Metrics interface {
+	Counter(name string, labels ...string) Counter
+	Gauge(name string, labels ...string) Gauge
+	Histogram(name string, labels ...string) Histogram
+}
+
+// Counter is a value that only goes up
+type Counter interface {
+	Add(delta float64)
+}
+
+// Gauge is a value that goes up and down
+type Gauge interface {
+	Set(value float64)
+}
+
+// Histogram records the distribution of observed values
+type Histogram interface {
+	Observe(value float64)
+}
+
+// MetricsFuncs adapts plain functions to Metrics, to route metrics into a
+// pipeline other than Prometheus, OpenTelemetry or statsd. A nil function
+// drops its metrics.
+type MetricsFuncs struct {
+	Add     func(name string, labels []string, delta float64)
+	Set     func(name string, labels []string, value float64)
+	Observe func(name string, labels []string, value float64)
+}
+
+type metricFunc struct {
+	name   string
+	labels []string
+	fn     func(name string, labels []string, value float64)
+}
+
+func (m metricFunc) Add(delta float64)     { m.call(delta) }
+func (m metricFunc) Set(value float64)     { m.call(value) }
+func (m metricFunc) Observe(value float64) { m.call(value) }
+
+func (m metricFunc) call(value float64) {
+	if m.fn != nil {
+		m.fn(m.name, m.labels, value)
+	}
+}
+
+func (f MetricsFuncs) Counter(name string, labels ...string) Counter {
+	return metricFunc{name, labels, f.Add}
+}
+
+func (f MetricsFuncs) Gauge(name string, labels ...string) Gauge {
+	return metricFunc{name, labels, f.Set}
+}
+
+func (f MetricsFuncs) Histogram(name string, labels ...string) Histogram {
+	return metricFunc{name, labels, f.Observe}
+}
+
+// Instruments of a load balancer, created once from its Metrics
+type lbMetrics struct {
+	moved, misses, hitAlarms, objectAlarms                                  Counter
+	nodes, buckets, objects, deferred, draining, quarantined, version, skew Gauge
+	objectVariance                                                          Gauge
+	lookups                                                                 Histogram
+
+	// Gauges of the objects of the nodes reported last, by node label
+	nodeObjects map[string]Gauge
+
+	// Total of moved objects already reported
+	reported int
+
+	metrics Metrics
+	mu      sync.Mutex
+	ops     map[string]opMetrics
+}
+
+type opMetrics struct {
+	count    Counter
+	duration Histogram
+}
+
+func newLBMetrics(m Metrics) *lbMetrics {
+	return &lbMetrics{metrics: m, ops: make(map[string]opMetrics),
+		nodeObjects:    make(map[string]Gauge),
+		moved:          m.Counter(MetricObjectsMoved),
+		misses:         m.Counter(MetricLookupMisses),
+		hitAlarms:      m.Counter(MetricBucketSkewAlarms, "measure", "hits"),
+		objectAlarms:   m.Counter(MetricBucketSkewAlarms, "measure", "objects"),
+		nodes:          m.Gauge(MetricNodes),
+		buckets:        m.Gauge(MetricBuckets),
+		objects:        m.Gauge(MetricObjects),
+		deferred:       m.Gauge(MetricDeferred),
+		draining:       m.Gauge(MetricDraining),
+		quarantined:    m.Gauge(MetricQuarantined),
+		version:        m.Gauge(MetricVersion),
+		skew:           m.Gauge(MetricObjectSkew),
+		objectVariance: m.Gauge(MetricBucketObjectVariance),
+		lookups:        m.Histogram(MetricLookupSeconds)}
+}
+
+// Observe a lookup that started at start, counting it as a miss if it
+// found no node
+func (m *lbMetrics) lookup(start time.Time, err error) {
+	m.lookups.Observe(time.Since(start).Seconds())
+	if errors.Is(err, ErrClusterUnavailable) {
+		m.misses.Add(1)
+	}
+}
+
+// Count an operation and observe how long it took
+func (m *lbMetrics) operation(op string, took time.Duration) {
+	m.mu.Lock()
+	o, ok := m.ops[op]
+	if !ok {
+		o = opMetrics{count: m.metrics.Counter(MetricOperations, "op", op),
+			duration: m.metrics.Histogram(MetricOperationSeconds, "op", op)}
+		m.ops[op] = o
+	}
+	m.mu.Unlock()
+
+	o.count.Add(1)
+	o.duration.Observe(took.Seconds())
+}
+
+// Count a bucket becoming skewed by the event raised for it
+func (m *lbMetrics) skewAlarm(event string) {
+	if event == EventBucketHitsSkewed {
+		m.hitAlarms.Add(1)
+	} else {
+		m.objectAlarms.Add(1)
+	}
+}
+
+// Report the state of the load balancer after a change
+func (lb *loadBalancer[T, O]) reportMetrics() {
+	m := lb.profiler.metrics
+	if m == nil {
+		return
+	}
+	m.nodes.Set(float64(lb.sp.Len()))
+	m.buckets.Set(float64(lb.ch.Size()))
+	m.objects.Set(float64(lb.objects.len()))
+	m.deferred.Set(float64(len(lb.churn.deferred)))
+	m.draining.Set(float64(len(lb.drains)))
+	m.quarantined.Set(float64(len(lb.flaps.active(lb.churn.clock()))))
+	m.version.Set(float64(lb.Version()))
+	if moved := lb.churn.total - m.reported; moved > 0 {
+		m.moved.Add(float64(moved))
+		m.reported = lb.churn.total
+	}
+	lb.reportNodeObjects(m)
+}
+
+// Report the objects of each node and how unevenly they are spread.
+// Nodes that left are reported with no objects.
+func (lb *loadBalancer[T, O]) reportNodeObjects(m *lbMetrics) {
+	gone := maps.Clone(m.nodeObjects)
+	total, most := 0, 0
+	for node := range lb.sp.Nodes() {
+		n := 0
+		for range node.Objects() {
+			n++
+		}
+		total, most = total+n, max(most, n)
+
+		label := CanonicalName(node.Name())
+		g, ok := m.nodeObjects[label]
+		if !ok {
+			g = m.metrics.Gauge(MetricNodeObjects, "node", label)
+			m.nodeObjects[label] = g
+		}
+		g.Set(float64(n))
+		delete(gone, label)
+	}
+	for label, g := range gone {
+		g.Set(0)
+		delete(m.nodeObjects, label)
+	}
+	skew := 1.0
+	if total > 0 {
+		skew = float64(most) / (float64(total) / float64(lb.sp.Len()))
+	}
+	m.skew.Set(skew)
+}
+
+// TeeMetrics reports every metric to each of ms
+func TeeMetrics(ms ...Metrics) Metrics {
+	return teeMetrics(ms)
+}
+
+type teeMetrics []Metrics
+
+type teeInstrument struct {
+	counters   []Counter
+	gauges     []Gauge
+	histograms []Histogram
+}
+
+func (t teeInstrument) Add(delta float64) {
+	for _, c := range t.counters {
+		c.Add(delta)
+	}
+}
+
+func (t teeInstrument) Set(value float64) {
+	for _, g := range t.gauges {
+		g.Set(value)
+	}
+}
+
+func (t teeInstrument) Observe(value float64) {
+	for _, h := range t.histograms {
+		h.Observe(value)
+	}
+}
+
+func (t teeMetrics) Counter(name string, labels ...string) Counter {
+	var i teeInstrument
+	for _, m := range t {
+		i.counters = append(i.counters, m.Counter(name, labels...))
+	}
+	return i
+}
+
+func (t teeMetrics) Gauge(name string, labels ...string) Gauge {
+	var i teeInstrument
+	for _, m := range t {
+		i.gauges = append(i.gauges, m.Gauge(name, labels...))
+	}
+	return i
+}
+
+func (t teeMetrics) Histogram(name string, labels ...string) Histogram {
+	var i teeInstrument
+	for _, m := range t {
+		i.histograms = append(i.histograms, m.Histogram(name, labels...))
+	}
+	return i
+}
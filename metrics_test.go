@@ -0,0 +1,164 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"serverpool"
+	"strings"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetrics(t *testing.T) {
+	prom := NewPrometheusMetrics()
+	var statsd strings.Builder
+	lb := NewLoadBalancerWithOptions(WithMetrics[string, string](
+		TeeMetrics(prom, NewStatsdMetrics(&statsd, "lb."))))
+
+	nodes := []serverpool.Node[string, string]{
+		&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
+		&mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	objs := []*serverpool.Object[string, string]{{Id: "obj1"}, {Id: "obj2"}, {Id: "obj3"}}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var exposition strings.Builder
+	prom.WriteTo(&exposition)
+	for _, line := range []string{
+		"# TYPE loadbalance_nodes gauge\nloadbalance_nodes 2\n",
+		"loadbalance_objects 3\n",
+		`loadbalance_operations_total{op="AddNodes"} 1`,
+		`loadbalance_operation_seconds_bucket{op="AddObjects",le="+Inf"} 1`,
+		`loadbalance_operation_seconds_count{op="AddObjects"} 1`,
+	} {
+		if !strings.Contains(exposition.String(), line) {
+			t.Fatalf("expected %q in\n%s", line, exposition.String())
+		}
+	}
+	for _, line := range []string{"lb.loadbalance_nodes:2|g\n", "lb.loadbalance_operations_total:1|c|#op:AddNodes\n"} {
+		if !strings.Contains(statsd.String(), line) {
+			t.Fatalf("expected %q in\n%s", line, statsd.String())
+		}
+	}
+}
+
+func TestLookupMetrics(t *testing.T) {
+	prom := NewPrometheusMetrics()
+	fallback := &mockNode{ID: "fallback"}
+	lb := NewLoadBalancerWithOptions(WithMetrics[string, string](prom),
+		WithFallbackNode[string, string](fallback))
+	if node, err := lb.GetNode("key"); err != nil || node != fallback {
+		t.Fatalf("expected the fallback node, got %v, %v", node, err)
+	}
+
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	objs := []*serverpool.Object[string, string]{{Id: "obj1"}, {Id: "obj2"}, {Id: "obj3"}, {Id: "obj4"}}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if _, err := lb.GetNode("key"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var exposition strings.Builder
+	prom.WriteTo(&exposition)
+	for _, line := range []string{
+		"loadbalance_lookup_seconds_count 2\n",
+		"loadbalance_lookup_misses_total 1\n",
+		"loadbalance_object_skew 1\n",
+		`loadbalance_node_objects{node="node1"} 4`,
+		`loadbalance_node_objects{node="node2"} 0`,
+	} {
+		if !strings.Contains(exposition.String(), line) {
+			t.Fatalf("expected %q in\n%s", line, exposition.String())
+		}
+	}
+	if !strings.Contains(exposition.String(), "loadbalance_buckets ") {
+		t.Fatalf("expected the bucket count in\n%s", exposition.String())
+	}
+}
+
+func TestOTelMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	lb := NewLoadBalancerWithOptions(WithMetrics[string, string](NewOTelMetrics(provider.Meter("loadbalance"))))
+
+	nodes := []serverpool.Node[string, string]{
+		&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
+		&mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	found := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			found[m.Name] = m.Data
+		}
+	}
+	if g, ok := found[MetricNodes].(metricdata.Gauge[float64]); !ok || len(g.DataPoints) != 1 || g.DataPoints[0].Value != 2 {
+		t.Fatalf("expected a gauge of 2 nodes, got %+v", found[MetricNodes])
+	}
+	ops, ok := found[MetricOperations].(metricdata.Sum[float64])
+	if !ok || len(ops.DataPoints) != 1 || ops.DataPoints[0].Value != 1 {
+		t.Fatalf("expected 1 operation counted, got %+v", found[MetricOperations])
+	}
+	if op, _ := ops.DataPoints[0].Attributes.Value("op"); op.AsString() != "AddNodes" {
+		t.Fatalf("expected op AddNodes, got %v", op.AsString())
+	}
+	if _, ok := found[MetricOperationSeconds].(metricdata.Histogram[float64]); !ok {
+		t.Fatalf("expected a histogram of operation durations, got %+v", found[MetricOperationSeconds])
+	}
+}
+
+func TestPrometheusLabels(t *testing.T) {
+	prom := NewPrometheusMetrics()
+	prom.Gauge(MetricNodeObjects, "node", "zürich-東京").Set(3)
+	prom.Gauge(MetricNodeObjects, "node", "a\"b\\c\nd").Set(1)
+
+	var exposition strings.Builder
+	prom.WriteTo(&exposition)
+	for _, line := range []string{
+		`loadbalance_node_objects{node="zürich-東京"} 3`,
+		`loadbalance_node_objects{node="a\"b\\c\nd"} 1`,
+	} {
+		if !strings.Contains(exposition.String(), line) {
+			t.Fatalf("expected %q in\n%s", line, exposition.String())
+		}
+	}
+}
+
+func TestStatsdNegativeGauge(t *testing.T) {
+	var statsd strings.Builder
+	g := NewStatsdMetrics(&statsd, "lb.").Gauge("skew", "node", "node1")
+	g.Set(-5)
+	g.Set(2)
+	if want := "lb.skew:0|g|#node:node1\nlb.skew:-5|g|#node:node1\nlb.skew:2|g|#node:node1\n"; statsd.String() != want {
+		t.Fatalf("expected %q, got %q", want, statsd.String())
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Read-only mirror of a primary load balancer
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+)
+
+// ErrReadOnly is returned by mutating calls on a read-only mirror
+var ErrReadOnly = errors.New("load balancer is read-only")
+
+// NewMirrorLoadBalancer creates a standby load balancer that consumes the
+// primary's change feed from the beginning and maintains an identical key to
+// node mapping. The mirror rejects mutations with ErrReadOnly until Promote
+// is called. Its nodes stand in for the nodes of the primary with the same
+// names, with the same weight but objects of their own, so the primary's
+// nodes only ever hold the primary's objects. Objects move when the
+// primary moves them: moves the primary deferred when nodes changed are
+// deferred by the mirror too, whatever its own budget, until the primary
+// makes them. Fails with ErrFeedTruncated if the primary no longer retains
+// its first changes, as after DefaultFeedRetention changes unless it was
+// created WithFeedRetention(0), and with ErrUnsupported if the primary is
+// not a Feeder.
+//
+// The mirror is safe for concurrent use like NewConcurrentLoadBalancer.
+// Changes of the primary are applied under the mirror's write lock, from
+// the goroutine changing the primary.
+func NewMirrorLoadBalancer[T, O comparable](primary LoadBalancer[T, O], opts ...Option[T, O]) (LoadBalancer[T, O], error) {
+	feeder, err := capability[Feeder[T, O]](primary)
+	if err != nil {
+		return nil, err
+	}
+	lb := NewLoadBalancerWithOptions(opts...).(*loadBalancer[T, O])
+	lb.readOnly = true
+	lb.unwrapStandIns()
+
+	// Objects move when the primary moves them, after its prefetch
+	lb.prefetcher = prefetcher[T, O]{}
+
+	// The objects come from the primary, the object store is only written
+	// once promoted
+	lb.objects = objectMap[T, O]{}
+	if lb.records != nil {
+		clear(lb.records.owners)
+	}
+	mirror := &concurrentLoadBalancer[T, O]{lb: lb}
+	nodes := make(map[T]*pastNode[T, O])
+	unfollow, err := feeder.Feed(0, func(c Change[T, O]) {
+		writeLocked(mirror, func() struct{} { lb.apply(standIn(c, nodes)); return struct{}{} })
+	})
+	if err != nil {
+		return nil, err
+	}
+	lb.unfollow = unfollow
+	return mirror, nil
+}
+
+// Apply a change received from the primary's feed
+func (lb *loadBalancer[T, O]) apply(c Change[T, O]) {
+	objects := make([]*serverpool.Object[T, O], 0, len(c.Objects))
+	for _, id := range c.Objects {
+		if obj, ok := lb.objects.get(id); ok && c.Op != ChangeAddObjects {
+			objects = append(objects, obj)
+		} else {
+			objects = append(objects, &serverpool.Object[T, O]{Id: id})
+		}
+	}
+
+	// The primary only publishes changes that succeeded, so replaying
+	// them on an identical state succeeds as well
+	switch c.Op {
+	case ChangeAddNodes:
+		lb.replayNodes(c.Objects, func() { lb.addNodes(c.Nodes) })
+	case ChangeRemoveNodes:
+		lb.replayNodes(c.Objects, func() { lb.removeNodes(c.Nodes) })
+	case ChangeAddObjects:
+		lb.addObjects(objects)
+	case ChangeRemoveObjects:
+		lb.removeObjects(objects)
+	case ChangeAssignObject:
+		for _, obj := range objects {
+			lb.assignObject(obj)
+		}
+		lb.publish(c.Op, nil, objects)
+	case ChangeUnassignObject:
+		for _, obj := range objects {
+			lb.unassignObject(obj)
+		}
+		lb.publish(c.Op, nil, objects)
+	case ChangeTransferObject:
+		// Hooks only run on the primary that owns the data
+		lb.transferObjects(objects, c.Nodes[0])
+	case ChangeDrainNode:
+		lb.applyDrain(c.Nodes[0], objects)
+		lb.publish(c.Op, c.Nodes, objects)
+	case ChangePauseAutomation, ChangeResumeAutomation:
+		// Objects the primary moves on resuming arrive as assignments
+		lb.paused = c.Op == ChangePauseAutomation
+		lb.record(Change[T, O]{Op: c.Op})
+	case ChangePinKey:
+		lb.pinKeyTo(c.Keys[0], c.Nodes[0])
+	case ChangeUnpinKey:
+		lb.unpinKey(c.Keys[0])
+	case ChangeDialTraffic:
+		lb.startDial(c.Nodes[0], c.Nodes[1], c.Schedule, c.Time)
+	case ChangeRollbackDial:
+		lb.rollbackDial(c.Nodes[0])
+	case ChangeBatch:
+		var changes []Change[T, O]
+		lb.batch = &changes
+		for _, change := range c.Changes {
+			lb.apply(change)
+		}
+		lb.batch = nil
+		lb.publishBatch(changes)
+	}
+}
+
+// Replay a change of nodes with fn, deferring the moves of the objects the
+// primary deferred and making all others, whatever the budget of the mirror
+func (lb *loadBalancer[T, O]) replayNodes(deferred []O, fn func()) {
+	lb.replayDeferred = make(map[O]bool, len(deferred))
+	for _, id := range deferred {
+		lb.replayDeferred[id] = true
+	}
+	fn()
+	lb.replayDeferred = nil
+}
+
+// Check if the load balancer rejects mutations
+func (lb *loadBalancer[T, O]) ReadOnly() bool {
+	return lb.readOnly
+}
+
+// Promote stops following the primary and makes the load balancer writable.
+// Moves still deferred are queued as on the primary, and made by the next
+// Rebalance. Promoting a writable load balancer has no effect.
+func (lb *loadBalancer[T, O]) Promote() {
+	if lb.unfollow != nil {
+		lb.unfollow()
+		lb.unfollow = nil
+	}
+	lb.readOnly = false
+}
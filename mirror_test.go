@@ -0,0 +1,280 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+// Check that every object of the mirror is where the primary's is, or
+// unassigned like it
+func checkMirrored(t *testing.T, primary, mirror LoadBalancer[string, string]) {
+	t.Helper()
+	nodeOf := func(lb LoadBalancer[string, string]) map[string]string {
+		nodes := make(map[string]string)
+		for obj := range lb.Objects() {
+			nodes[obj.Id] = ""
+			if n := obj.Node(); n != nil && *n != nil {
+				nodes[obj.Id] = (*n).Name()
+			}
+		}
+		return nodes
+	}
+	want, got := nodeOf(primary), nodeOf(mirror)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d objects on the mirror, got %d", len(want), len(got))
+	}
+	for id, node := range want {
+		if got[id] != node {
+			t.Fatalf("expected %s on %q as on the primary, got %q", id, node, got[id])
+		}
+	}
+}
+
+func TestMirrorDeferredMoves(t *testing.T) {
+	for _, cooperative := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cooperative=%v", cooperative), func(t *testing.T) {
+			var opts []Option[string, string]
+			if cooperative {
+				opts = append(opts, WithCooperativeRebalance(RebalanceCallbacks[string, string]{}))
+			}
+
+			primary := NewLoadBalancerWithOptions(append(opts, WithMovementBudget[string, string](3, time.Minute, nil))...).(*loadBalancer[string, string])
+			now := time.Now()
+			primary.churn.now = func() time.Time { return now }
+
+			// The mirror has no budget of its own
+			mirror := newMirror(t, primary, opts...)
+
+			var nodes []serverpool.Node[string, string]
+			for i := range 4 {
+				nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i),
+					objects: make(map[string]*serverpool.Object[string, string])})
+			}
+			if _, err := primary.AddNodes(nodes[:3]); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			var objs []*serverpool.Object[string, string]
+			for i := range 40 {
+				objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+			}
+			if _, err := primary.AddObjects(objs); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, obj := range objs {
+				if err := primary.AssignObject(obj); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+
+			// Moves beyond the budget of the primary are deferred on the
+			// mirror too
+			if _, err := primary.AddNodes(nodes[3:]); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			checkMirrored(t, primary, mirror)
+			result, err := primary.RemoveNodes(nodes[:1])
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if result.Nodes[0].Deferred == 0 {
+				t.Fatalf("expected moves deferred by the budget, got %+v", result.Nodes[0])
+			}
+			checkMirrored(t, primary, mirror)
+
+			// and made on the mirror when the primary makes them
+			now = now.Add(time.Minute)
+			if _, err := primary.Rebalance(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			checkMirrored(t, primary, mirror)
+
+			// A promoted mirror makes the moves still deferred itself
+			if _, err := primary.RemoveNodes(nodes[1:2]); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			checkMirrored(t, primary, mirror)
+			mirror.(Feeder[string, string]).Promote()
+			if _, err := mirror.Rebalance(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for obj := range mirror.Objects() {
+				if n := obj.Node(); n == nil || *n == nil {
+					t.Fatalf("expected %v assigned after Rebalance on the promoted mirror", obj)
+				}
+			}
+		})
+	}
+}
+
+func TestMirrorLoadBalancer(t *testing.T) {
+	primary := NewLoadBalancer[string, string]()
+
+	nodes := []serverpool.Node[string, string]{
+		&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
+		&mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])},
+		&mockNode{ID: "node3", objects: make(map[string]*serverpool.Object[string, string])},
+	}
+	if _, err := primary.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := primary.RemoveNodes(nodes[1:2]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The mirror replays the changes made before it was created
+	mirror := newMirror(t, primary)
+
+	// and follows changes made afterwards
+	if _, err := primary.AddNodes([]serverpool.Node[string, string]{
+		&mockNode{ID: "node4", objects: make(map[string]*serverpool.Object[string, string])},
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := primary.AddObjects([]*serverpool.Object[string, string]{{Id: "obj1"}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if mirror.Version() != primary.Version() {
+		t.Fatalf("expected mirror version %d, got %d", primary.Version(), mirror.Version())
+	}
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		want, err := primary.GetNode(key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		got, err := mirror.GetNode(key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got.Name() != want.Name() {
+			t.Fatalf("expected key %q to map to %v, got %v", key, want, got)
+		}
+	}
+
+	// The mirror holds its objects on nodes of its own
+	assigned := 0
+	for obj := range primary.Objects() {
+		if err := primary.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	for obj := range primary.Objects() {
+		if n := obj.Node(); n != nil {
+			assigned++
+			if owned := (*n).(*mockNode).objects[obj.Id]; owned != obj {
+				t.Fatalf("expected %v to hold the primary's %v, got %v", *n, obj, owned)
+			}
+		}
+	}
+	if assigned == 0 {
+		t.Fatalf("expected assigned objects")
+	}
+
+	if !mirror.(Feeder[string, string]).ReadOnly() {
+		t.Fatalf("expected mirror to be read-only")
+	}
+	_, err := mirror.AddObjects([]*serverpool.Object[string, string]{{Id: "obj2"}})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	// After promotion the mirror is writable and no longer follows the primary
+	mirror.(Feeder[string, string]).Promote()
+	if _, err := mirror.AddObjects([]*serverpool.Object[string, string]{{Id: "obj2"}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := primary.AddObjects([]*serverpool.Object[string, string]{{Id: "obj3"}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for obj := range mirror.Objects() {
+		if obj.Id == "obj3" {
+			t.Fatalf("expected promoted mirror to stop following the primary")
+		}
+	}
+}
+
+// Mirror of primary, failing the test if it cannot follow it
+// Run with -race to check reads of the mirror against the changes the
+// primary applies to it
+func TestMirrorConcurrent(t *testing.T) {
+	primary := NewConcurrentLoadBalancer[string, string]()
+	newNode := func(i int) *mockNode {
+		return &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	if _, err := primary.AddNodes([]serverpool.Node[string, string]{newNode(0)}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	mirror := newMirror(t, primary)
+
+	done, started, read := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(read)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			mirror.GetNode("key")
+			mirror.NodeCount()
+			if i == 0 {
+				close(started)
+			}
+			runtime.Gosched()
+		}
+	}()
+	<-started
+	for i := 1; i < 50; i++ {
+		if _, err := primary.AddNodes([]serverpool.Node[string, string]{newNode(i)}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		runtime.Gosched()
+	}
+	close(done)
+	<-read
+	if mirror.NodeCount() != 50 {
+		t.Fatalf("expected 50 nodes, got %d", mirror.NodeCount())
+	}
+
+	// Promoting while the primary changes does not deadlock
+	changed := make(chan struct{})
+	go func() {
+		defer close(changed)
+		for i := 50; i < 100; i++ {
+			primary.AddNodes([]serverpool.Node[string, string]{newNode(i)})
+		}
+	}()
+	promoted := make(chan struct{})
+	go func() {
+		mirror.(Feeder[string, string]).Promote()
+		close(promoted)
+	}()
+	for _, ch := range []chan struct{}{promoted, changed} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected Promote and the changes of the primary to finish")
+		}
+	}
+	if mirror.(Feeder[string, string]).ReadOnly() {
+		t.Fatalf("expected the mirror to be promoted")
+	}
+}
+
+func newMirror(t *testing.T, primary LoadBalancer[string, string], opts ...Option[string, string]) LoadBalancer[string, string] {
+	t.Helper()
+	mirror, err := NewMirrorLoadBalancer(primary, opts...)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return mirror
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Map of the objects tracked by a load balancer
+
+package main
+
+import (
+	"iter"
+	"serverpool"
+)
+
+// objectMap holds objects by id. It has no lock of its own: every change
+// of an object is published to the change feed in order, so changes are
+// serialized by the lock of the load balancer, such as the write lock of
+// concurrentLoadBalancer, and lookups by its read lock.
+// The zero value is an empty map ready to use.
+type objectMap[T, O comparable] struct {
+	objects map[O]*serverpool.Object[T, O]
+
+	// Saves the objects the transaction being committed changes, if any
+	undo entryUndo[O, *serverpool.Object[T, O]]
+}
+
+func (m *objectMap[T, O]) get(id O) (*serverpool.Object[T, O], bool) {
+	obj, ok := m.objects[id]
+	return obj, ok
+}
+
+func (m *objectMap[T, O]) set(obj *serverpool.Object[T, O]) {
+	if m.objects == nil {
+		m.objects = make(map[O]*serverpool.Object[T, O])
+	}
+	m.undo.save(m.objects, obj.Id)
+	m.objects[obj.Id] = obj
+}
+
+func (m *objectMap[T, O]) delete(id O) {
+	m.undo.save(m.objects, id)
+	delete(m.objects, id)
+}
+
+func (m *objectMap[T, O]) len() int {
+	return len(m.objects)
+}
+
+// Iterate over all objects. The objects are copied before they are
+// yielded, so the caller may modify the map while iterating.
+func (m *objectMap[T, O]) all() iter.Seq[*serverpool.Object[T, O]] {
+	return func(yield func(*serverpool.Object[T, O]) bool) {
+		objects := make([]*serverpool.Object[T, O], 0, len(m.objects))
+		for _, obj := range m.objects {
+			objects = append(objects, obj)
+		}
+		for _, obj := range objects {
+			if !yield(obj) {
+				return
+			}
+		}
+	}
+}
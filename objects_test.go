@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestObjectsByNode(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	nodes := []serverpool.Node[string, string]{newNode("node1"), newNode("node2"), newNode("node3")}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 30; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs[:25] {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// Each node's objects are counted by a goroutine of their own
+	counts := make(map[string]*int)
+	done := make(chan struct{})
+	started := 0
+	for node, objects := range lb.ObjectsByNode() {
+		n := new(int)
+		counts[node.Name()] = n
+		started++
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for obj := range objects {
+				if got := *obj.Node(); got != node {
+					t.Errorf("expected %v on %v, got %v", obj, node, got)
+				}
+				*n++
+			}
+		}()
+	}
+	for range started {
+		<-done
+	}
+
+	total := 0
+	for _, n := range counts {
+		total += *n
+	}
+	if len(counts) != 3 || total != 25 {
+		t.Fatalf("expected 25 objects on 3 nodes, got %d on %d", total, len(counts))
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Shared records of which node owns each object
+
+package main
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"serverpool"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrObjectNotFound is returned for objects without an ownership record
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrVersionConflict is returned when a record changed since it was read
+var ErrVersionConflict = errors.New("object record version conflict")
+
+// ObjectRecord is the authoritative record of the node owning an object
+type ObjectRecord[T, O comparable] struct {
+	ID O
+
+	// Node owning the object, nil if it is unassigned
+	Node *T
+
+	// Incremented by every write, 0 for a record not stored yet
+	Version int64
+
+	// Time of the last write
+	Updated time.Time
+}
+
+// ObjectStore holds the ownership records shared by the replicas of a
+// load balancer. Writes are optimistic: a replica reads a record, decides
+// on a new owner and writes it back with the version it read, and the
+// write fails with ErrVersionConflict if another replica wrote in between.
+type ObjectStore[T, O comparable] interface {
+	// Get the record of an object, ErrObjectNotFound if there is none
+	Get(ctx context.Context, id O) (ObjectRecord[T, O], error)
+
+	// Iterate over all records, stopping at the first error
+	Records(ctx context.Context) iter.Seq2[ObjectRecord[T, O], error]
+
+	// Create the record if its version is 0 or else replace the stored
+	// record of that version, returning the record with its new version
+	Put(ctx context.Context, rec ObjectRecord[T, O]) (ObjectRecord[T, O], error)
+
+	// Delete the record if it still has the given version
+	Delete(ctx context.Context, id O, version int64) error
+}
+
+// Check that a write of a record of version found over one of version
+// want can go ahead
+func checkVersion(found, want int64, exists bool) error {
+	switch {
+	case !exists && want != 0:
+		return ErrObjectNotFound
+	case found != want:
+		return fmt.Errorf("%w: stored version %d, expected %d", ErrVersionConflict, found, want)
+	}
+	return nil
+}
+
+// memoryObjectStore keeps records in memory, for a single process or tests
+type memoryObjectStore[T, O comparable] struct {
+	mu      sync.Mutex
+	records map[O]ObjectRecord[T, O]
+	clock   func() time.Time
+}
+
+// NewMemoryObjectStore creates an object store kept in memory
+func NewMemoryObjectStore[T, O comparable]() ObjectStore[T, O] {
+	return &memoryObjectStore[T, O]{records: make(map[O]ObjectRecord[T, O]), clock: time.Now}
+}
+
+func (m *memoryObjectStore[T, O]) Get(_ context.Context, id O) (ObjectRecord[T, O], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[id]
+	if !ok {
+		return ObjectRecord[T, O]{}, ErrObjectNotFound
+	}
+	return rec, nil
+}
+
+func (m *memoryObjectStore[T, O]) Records(context.Context) iter.Seq2[ObjectRecord[T, O], error] {
+	m.mu.Lock()
+	records := maps.Clone(m.records)
+	m.mu.Unlock()
+	return func(yield func(ObjectRecord[T, O], error) bool) {
+		for _, rec := range records {
+			if !yield(rec, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (m *memoryObjectStore[T, O]) Put(_ context.Context, rec ObjectRecord[T, O]) (ObjectRecord[T, O], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.records[rec.ID]
+	if err := checkVersion(stored.Version, rec.Version, ok); err != nil {
+		return rec, err
+	}
+	if rec.Node != nil {
+		node := *rec.Node
+		rec.Node = &node
+	}
+	rec.Version++
+	rec.Updated = m.clock()
+	m.records[rec.ID] = rec
+	return rec, nil
+}
+
+func (m *memoryObjectStore[T, O]) Delete(_ context.Context, id O, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.records[id]
+	if !ok {
+		return ErrObjectNotFound
+	}
+	if err := checkVersion(stored.Version, version, ok); err != nil {
+		return err
+	}
+	delete(m.records, id)
+	return nil
+}
+
+// Time a write of an ownership record may take
+var objectStoreTimeout = 5 * time.Second
+
+// Ownership records of the objects of a load balancer kept in a store
+type objectRecords[T, O comparable] struct {
+	store   ObjectStore[T, O]
+	onError func(error)
+
+	// Version of each record as last read or written
+	versions map[O]int64
+
+	// Objects loaded from the store waiting for their node, by node name
+	owners map[T][]O
+}
+
+// Load the records of the store as unassigned objects, remembering the
+// node each belongs on until a node of that name is added
+func (r *objectRecords[T, O]) load(lb *loadBalancer[T, O]) {
+	ctx, cancel := context.WithTimeout(context.Background(), objectStoreTimeout)
+	defer cancel()
+	for rec, err := range r.store.Records(ctx) {
+		if err != nil {
+			r.fail(fmt.Errorf("loading object records: %w", err))
+			return
+		}
+		lb.objects.set(&serverpool.Object[T, O]{Id: rec.ID})
+		r.versions[rec.ID] = rec.Version
+		if rec.Node != nil {
+			r.owners[*rec.Node] = append(r.owners[*rec.Node], rec.ID)
+		}
+	}
+}
+
+// Write the record of an object on node, nil if it is unassigned, once
+// the transaction being committed succeeds. Mirrors and past states do not
+// write.
+func (lb *loadBalancer[T, O]) putRecord(obj *serverpool.Object[T, O], node serverpool.Node[T, O]) {
+	if lb.records == nil || lb.readOnly {
+		return
+	}
+	lb.afterCommit(func() { lb.records.put(obj, node) })
+}
+
+// Delete the record of an object, once the transaction being committed
+// succeeds
+func (lb *loadBalancer[T, O]) removeRecord(id O) {
+	if lb.records == nil || lb.readOnly {
+		return
+	}
+	lb.afterCommit(func() { lb.records.remove(id) })
+}
+
+// Assign the loaded objects recorded on node to it, the store already
+// records them there
+func (lb *loadBalancer[T, O]) adoptRecords(node serverpool.Node[T, O]) {
+	r := lb.records
+	if r == nil || len(r.owners[node.Name()]) == 0 {
+		return
+	}
+	for _, id := range r.owners[node.Name()] {
+		o, ok := lb.objects.get(id)
+		if !ok {
+			continue
+		}
+		if n := o.Node(); n != nil && *n != nil {
+			continue
+		}
+		lb.undo.moving(o)
+		node.AssignObject(o)
+		o.AssignToNode(&node)
+	}
+	delete(r.owners, node.Name())
+}
+
+// Write the record of an object on node, nil if it is unassigned. A
+// record another replica wrote since it was read is not overwritten: the
+// conflict is reported and the version refreshed for the next write.
+func (r *objectRecords[T, O]) put(obj *serverpool.Object[T, O], node serverpool.Node[T, O]) {
+	if r == nil {
+		return
+	}
+	rec := ObjectRecord[T, O]{ID: obj.Id, Version: r.versions[obj.Id]}
+	if node != nil {
+		name := node.Name()
+		rec.Node = &name
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), objectStoreTimeout)
+	defer cancel()
+	written, err := r.store.Put(ctx, rec)
+	if err != nil {
+		r.refresh(ctx, obj.Id)
+		r.fail(fmt.Errorf("writing record of %v: %w", obj.Id, err))
+		return
+	}
+	r.versions[obj.Id] = written.Version
+}
+
+// Delete the record of an object that left the load balancer
+func (r *objectRecords[T, O]) remove(id O) {
+	if r == nil {
+		return
+	}
+	version, ok := r.versions[id]
+	delete(r.versions, id)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), objectStoreTimeout)
+	defer cancel()
+	if err := r.store.Delete(ctx, id, version); err != nil && !errors.Is(err, ErrObjectNotFound) {
+		r.fail(fmt.Errorf("deleting record of %v: %w", id, err))
+	}
+}
+
+// Read the current version of a record after a failed write
+func (r *objectRecords[T, O]) refresh(ctx context.Context, id O) {
+	rec, err := r.store.Get(ctx, id)
+	switch {
+	case err == nil:
+		r.versions[id] = rec.Version
+	case errors.Is(err, ErrObjectNotFound):
+		delete(r.versions, id)
+	}
+}
+
+func (r *objectRecords[T, O]) fail(err error) {
+	if r.onError != nil {
+		r.onError(err)
+	}
+}
+
+// Text form of an id or node name for storage outside the process
+func formatText(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case encoding.TextMarshaler:
+		b, err := v.MarshalText()
+		return string(b), err
+	}
+	return "", fmt.Errorf("cannot store %T as text", v)
+}
+
+// Parse the text form of an id or node name
+func parseText[V any](s string) (V, error) {
+	var v V
+	var err error
+	switch p := any(&v).(type) {
+	case *string:
+		*p = s
+	case *int:
+		*p, err = strconv.Atoi(s)
+	case *int64:
+		*p, err = strconv.ParseInt(s, 10, 64)
+	case *uint64:
+		*p, err = strconv.ParseUint(s, 10, 64)
+	case encoding.TextUnmarshaler:
+		err = p.UnmarshalText([]byte(s))
+	default:
+		err = fmt.Errorf("cannot load %T from text", v)
+	}
+	return v, err
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"serverpool"
+	"slices"
+	"testing"
+)
+
+// Check the semantics every object store shares on an empty store
+func checkObjectStore(t *testing.T, name string, store ObjectStore[string, string]) {
+	t.Helper()
+	ctx := context.Background()
+	node1, node2 := "node1", "node2"
+	rec, err := store.Put(ctx, ObjectRecord[string, string]{ID: "obj", Node: &node1})
+	if err != nil || rec.Version != 1 {
+		t.Fatalf("%s: expected version 1, got %d, %v", name, rec.Version, err)
+	}
+	if _, err := store.Put(ctx, ObjectRecord[string, string]{ID: "obj", Node: &node2}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("%s: expected a conflict creating an existing record, got %v", name, err)
+	}
+	stale := rec
+	rec.Node = &node2
+	if rec, err = store.Put(ctx, rec); err != nil || rec.Version != 2 {
+		t.Fatalf("%s: expected version 2, got %d, %v", name, rec.Version, err)
+	}
+	if _, err := store.Put(ctx, stale); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("%s: expected a conflict for a stale write, got %v", name, err)
+	}
+	if _, err := store.Put(ctx, ObjectRecord[string, string]{ID: "missing", Version: 3}); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("%s: expected not found updating a missing record, got %v", name, err)
+	}
+	if _, err := store.Put(ctx, ObjectRecord[string, string]{ID: "free"}); err != nil {
+		t.Fatalf("%s: expected no error, got %v", name, err)
+	}
+
+	got, err := store.Get(ctx, "obj")
+	if err != nil || got.Node == nil || *got.Node != node2 || got.Version != 2 {
+		t.Fatalf("%s: expected obj on node2 at version 2, got %+v, %v", name, got, err)
+	}
+	var ids []string
+	for rec, err := range store.Records(ctx) {
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		if rec.ID == "free" && rec.Node != nil {
+			t.Fatalf("%s: expected free to be unassigned, got %v", name, *rec.Node)
+		}
+		ids = append(ids, rec.ID)
+	}
+	if slices.Sort(ids); !slices.Equal(ids, []string{"free", "obj"}) {
+		t.Fatalf("%s: unexpected records %v", name, ids)
+	}
+
+	if err := store.Delete(ctx, "obj", 1); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("%s: expected a conflict for a stale delete, got %v", name, err)
+	}
+	if err := store.Delete(ctx, "obj", 2); err != nil {
+		t.Fatalf("%s: expected no error, got %v", name, err)
+	}
+	if _, err := store.Get(ctx, "obj"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("%s: expected not found after delete, got %v", name, err)
+	}
+}
+
+// Owner of each object the store records, "" for unassigned objects
+func storedOwners(t *testing.T, store ObjectStore[string, string]) map[string]string {
+	t.Helper()
+	owners := make(map[string]string)
+	for rec, err := range store.Records(context.Background()) {
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		owners[rec.ID] = ""
+		if rec.Node != nil {
+			owners[rec.ID] = *rec.Node
+		}
+	}
+	return owners
+}
+
+// Owner of each object of a load balancer, "" for unassigned objects
+func lbOwners(lb LoadBalancer[string, string]) map[string]string {
+	owners := make(map[string]string)
+	for obj := range lb.Objects() {
+		owners[obj.Id] = ""
+		if node := obj.Node(); node != nil && *node != nil {
+			owners[obj.Id] = (*node).Name()
+		}
+	}
+	return owners
+}
+
+func TestSharedObjectStore(t *testing.T) {
+	store := NewMemoryObjectStore[string, string]()
+	checkSharedObjectStore(t, store, store)
+}
+
+// checkSharedObjectStore runs two load balancers on one shared store,
+// reached through store1 and store2
+func checkSharedObjectStore(t *testing.T, store1, store2 ObjectStore[string, string]) {
+	store := store1
+	var errs []error
+	onError := func(err error) { errs = append(errs, err) }
+	newNodes := func(n int) []serverpool.Node[string, string] {
+		var nodes []serverpool.Node[string, string]
+		for i := range n {
+			nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+		}
+		return nodes
+	}
+
+	// Adding, assigning, moving, unassigning and removing objects writes
+	// their records
+	lb1 := NewLoadBalancerWithOptions(WithObjectStore(store, onError))
+	nodes1 := newNodes(3)
+	if _, err := lb1.AddNodes(nodes1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := range 30 {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb1.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs[:25] {
+		if err := lb1.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if _, err := lb1.RemoveNodes(nodes1[2:]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb1.UnassignObject(objs[0]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb1.RemoveObjects(objs[1:3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := lbOwners(lb1)
+	if got := storedOwners(t, store); !maps.Equal(got, want) {
+		t.Fatalf("expected the records %v, got %v", want, got)
+	}
+
+	// Another load balancer on the store loads the objects and assigns
+	// them to their recorded nodes as they are added
+	lb2 := NewLoadBalancerWithOptions(WithObjectStore(store2, onError))
+	if got := lbOwners(lb2); len(got) != len(want) {
+		t.Fatalf("expected %d objects loaded, got %d", len(want), len(got))
+	}
+	nodes2 := newNodes(2)
+	if _, err := lb2.AddNodes(nodes2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := lbOwners(lb2); !maps.Equal(got, want) {
+		t.Fatalf("expected the owners %v, got %v", want, got)
+	}
+	if err := lb2.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+
+	// A write over a record the other load balancer changed since is
+	// reported and leaves the record alone, the next write goes through
+	if err := lb2.UnassignObject(objs[3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb1.MoveObjects([]string{objs[3].Id}, nodes1[1-slices.Index(nodes1, *objs[3].Node())]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrVersionConflict) {
+		t.Fatalf("expected a version conflict, got %v", errs)
+	}
+	if got := storedOwners(t, store)[objs[3].Id]; got != "" {
+		t.Fatalf("expected %v recorded unassigned, got %q", objs[3], got)
+	}
+	if err := lb1.UnassignObject(objs[3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb1.AssignObject(objs[3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := storedOwners(t, store)[objs[3].Id]; len(errs) != 1 || got != (*objs[3].Node()).Name() {
+		t.Fatalf("expected %v recorded on %v, got %q, %v", objs[3], *objs[3].Node(), got, errs)
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Functional options for configuring a load balancer
+
+package main
+
+import (
+	"consistenthash"
+	"context"
+	"faultinject"
+	"hashing"
+	"serverpool"
+	"time"
+)
+
+// Option configures a load balancer created by NewLoadBalancerWithOptions
+type Option[T, O comparable] func(*loadBalancer[T, O])
+
+// WithKeyNormalizer canonicalizes every key before it is hashed
+func WithKeyNormalizer[T, O comparable](normalize KeyNormalizer) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.normalize = normalize
+	}
+}
+
+// WithPrefetchHook sends prefetch hints to destination nodes when objects
+// are about to migrate, and delays the cutover by lead after the hints.
+// Objects wait for the lead time as those held back by the movement budget
+// do: objects of removed nodes are deferred and others stay on their node,
+// and they are cut over by the first Rebalance, or RebalanceAll for objects
+// of nodes still there, after the lead time. No call waits for it.
+func WithPrefetchHook[T, O comparable](hook PrefetchHook[T, O], lead time.Duration) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.prefetcher = prefetcher[T, O]{hook: hook, lead: lead}
+	}
+}
+
+// WithRemovalPolicy sets what happens to the objects of removed nodes
+func WithRemovalPolicy[T, O comparable](policy RemovalPolicy) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.removalPolicy = policy
+	}
+}
+
+// WithHasher uses ch as the consistent hasher, such as an implementation of
+// the application's own. Like WithHashStrategy it must come before options
+// wrapping the hasher.
+func WithHasher[T, O comparable](ch consistenthash.ConsistentHasher) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.ch = ch
+	}
+}
+
+// WithServerPool keeps the nodes and their buckets in sp instead of a new
+// server pool. The pool must be empty.
+func WithServerPool[T, O comparable](sp serverpool.ServerPool[T, O]) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.sp = sp
+	}
+}
+
+// WithHashAlgorithm hashes keys with algo instead of
+// hashing.DefaultHashAlgorithm. It replaces the hasher with memento hashing
+// of algo, and the options after it that create or wrap the hasher use algo
+// too, so it must come first.
+func WithHashAlgorithm[T, O comparable](algo hashing.HashAlgorithm) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.hashAlgo = algo
+		lb.ch = lb.newHasher()
+	}
+}
+
+// WithHashSeed seeds the hash function with seed, so that load balancers
+// of different tenants given different seeds map the same keys
+// independently. It replaces the hasher with memento hashing seeded with
+// seed, and tiers are seeded too, so it must come first, after
+// WithHashAlgorithm if any.
+func WithHashSeed[T, O comparable](seed uint64) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.hashSeed = seed
+		lb.ch = lb.newHasher()
+	}
+}
+
+// Memento hasher of the hash algorithm and seed of the load balancer
+func (lb *loadBalancer[T, O]) newHasher() consistenthash.ConsistentHasher {
+	return consistenthash.NewMementoHasher(lb.hashAlgo,
+		consistenthash.WithHashFunction(hashing.NewHashFunctionWithSeed(lb.hashAlgo, lb.hashSeed)))
+}
+
+// WithAutoAssign assigns the objects added by AddObjects to their nodes
+// right away, publishing their assignment as one ChangeAssignObject after
+// the ChangeAddObjects. Objects that cannot be placed, such as while there
+// are no nodes, are added unassigned.
+func WithAutoAssign[T, O comparable](auto bool) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.autoAssign = auto
+	}
+}
+
+// WithHashStrategy selects the consistent hash algorithm. Options wrapping
+// the hasher, such as WithLookupTable, must come after it. Rendezvous
+// hashing gives weighted nodes a single weighted bucket, and its
+// topology cannot be exported to thin clients.
+func WithHashStrategy[T, O comparable](strategy consistenthash.Strategy) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.ch = consistenthash.NewConsistentHasherWithStrategy(strategy, lb.hashAlgo)
+	}
+}
+
+// WithMaglevTable selects Maglev hashing with a table of the given number
+// of slots, rounded up to a prime, for constant time lookups. Like
+// WithHashStrategy it must come before options wrapping the hasher.
+func WithMaglevTable[T, O comparable](size int) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.ch = consistenthash.NewMaglevHasher(lb.hashAlgo, size)
+	}
+}
+
+// WithBucketAllocator takes bucket ids from the given allocator instead of
+// numbering buckets from 0. It replaces the hasher, so it must come before
+// options that wrap the hasher such as WithLookupTable.
+func WithBucketAllocator[T, O comparable](alloc consistenthash.BucketAllocator) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.ch = consistenthash.NewMementoHasherWithAllocator(lb.hashAlgo, alloc)
+	}
+}
+
+// WithLookupTable compiles the consistent hasher into a lookup table of the
+// given number of slots for reads. The table is rebuilt lazily after
+// membership changes, so it suits periods of stable membership.
+func WithLookupTable[T, O comparable](size int) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.ch = consistenthash.NewLookupTableHasher(lb.ch, lb.hashAlgo, size)
+	}
+}
+
+// WithRemovedLimit rebuilds the hasher once more than limit buckets are
+// removed, so memory and lookups stay bounded under perpetual churn. Every
+// node keeps its bucket but a rebuild moves some keys between nodes.
+func WithRemovedLimit[T, O comparable](limit int) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		if consistenthash.SetRemovedLimit(lb.ch, limit) == nil {
+			lb.removedLimit = limit
+		}
+	}
+}
+
+// WithProfiling labels load balancer operations in CPU profiles under the
+// ProfileLabel key and, if trace is set, wraps them in runtime/trace regions
+func WithProfiling[T, O comparable](trace bool) Option[T, O] {
+	return WithProfilingContext[T, O](context.Background(), trace)
+}
+
+// WithProfilingContext is WithProfiling with the operation labels nested
+// in the labels of ctx, such as those of the service the load balancer
+// runs in. pprof only nests labels through contexts, so labels the calling
+// goroutine set some other way are replaced by those of ctx while and after
+// an operation runs.
+func WithProfilingContext[T, O comparable](ctx context.Context, trace bool) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.profiler.labels, lb.profiler.trace, lb.profiler.ctx = true, trace, ctx
+	}
+}
+
+// WithFallbackNode serves every key from node while the load balancer has
+// no nodes, instead of failing with ErrClusterUnavailable. The fallback is
+// not part of the pool, typically a static error page or overflow server.
+func WithFallbackNode[T, O comparable](node serverpool.Node[T, O]) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.shedding.fallback = node
+	}
+}
+
+// WithUnavailableWait makes GetNode wait up to wait for a node to be added
+// while the load balancer has no nodes, so a proxy can hold requests
+// briefly until a node recovers. Nodes have to be added from another
+// goroutine. Lookups still without a node after the wait fall back as if
+// the option was not set.
+func WithUnavailableWait[T, O comparable](wait time.Duration) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.shedding.wait = wait
+	}
+}
+
+// WithClaimExpiry sets how long objects claimed with ClaimObjects stay
+// assigned unless the claim is renewed
+func WithClaimExpiry[T, O comparable](expiry time.Duration) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.claims.expiry = expiry
+	}
+}
+
+// WithPrefixPin homes every key starting with prefix to the named nodes,
+// spread across those of them in the pool. The longest pinned prefix of a
+// key wins, and keys of a group without nodes in the pool map as if the
+// prefix was not pinned. Prefixes are matched against normalized keys.
+func WithPrefixPin[T, O comparable](prefix string, nodes ...T) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.pins = append(lb.pins, prefixPin[T]{prefix: prefix, nodes: nodes})
+	}
+}
+
+// WithNodeTiers places objects with a Tier on the nodes tier says are of
+// that tier, each tier with a hasher of its own. Objects without a tier, or
+// of a tier without nodes, map to any node as before.
+func WithNodeTiers[T, O comparable](tier func(node serverpool.Node[T, O]) string) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.tiers.of = tier
+	}
+}
+
+// WithTierHasher hashes the keys of a tier with the hasher newHasher
+// creates instead of memento hashing, so tiers can trade lookup speed for
+// fewer remaps independently, e.g. Maglev for a lookup heavy edge tier
+// next to memento hashing for a storage tier. It takes effect with
+// WithNodeTiers.
+func WithTierHasher[T, O comparable](tier string, newHasher func() consistenthash.ConsistentHasher) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		if lb.tiers.hashers == nil {
+			lb.tiers.hashers = make(map[string]func() consistenthash.ConsistentHasher)
+		}
+		lb.tiers.hashers[tier] = newHasher
+	}
+}
+
+// WithFailureDomains orders the candidates of a key to spread across the
+// failure domains domain places the nodes in, scored by score, or
+// DefaultSpreadScore if nil. The first candidate, where the key maps, does
+// not change.
+func WithFailureDomains[T, O comparable](domain func(node serverpool.Node[T, O]) FailureDomain, score SpreadScore) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.domains = domains[T, O]{of: domain, score: score}
+	}
+}
+
+// WithWebhooks notifies the webhooks of objects assigned or moved to a node
+// and of nodes removed or fully drained. Events are delivered in the
+// background and onError, if not nil, receives failed attempts and dropped
+// events. Mirrors do not notify, their primary does.
+func WithWebhooks[T, O comparable](hooks []Webhook, onError func(error)) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		w := &webhooks[T, O]{onError: onError}
+		for _, hook := range hooks {
+			w.endpoints = append(w.endpoints, &webhookEndpoint[T, O]{Webhook: hook})
+		}
+		lb.webhooks = w
+	}
+}
+
+// WithObjectStore keeps the ownership record of every object in store.
+// Adding, assigning, moving, unassigning and removing an object writes its
+// record. When the load balancer is created, the records in store are
+// loaded as unassigned objects, each assigned to its recorded node once a
+// node of that name is added, so replicas sharing the store agree on the
+// owners. A record another replica wrote since it was read is not
+// overwritten, onError, if not nil, receives such conflicts and failed
+// reads and writes. Mirrors do not write, their primary does.
+func WithObjectStore[T, O comparable](store ObjectStore[T, O], onError func(error)) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.records = &objectRecords[T, O]{store: store, onError: onError,
+			versions: make(map[O]int64), owners: make(map[T][]O)}
+		lb.records.load(lb)
+	}
+}
+
+// WithMetrics reports operation counts and durations, objects moved and
+// gauges of the nodes, objects, deferred moves, drains and quarantined
+// nodes to m, see the Metric constants for their names
+func WithMetrics[T, O comparable](m Metrics) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.profiler.metrics = newLBMetrics(m)
+	}
+}
+
+// WithBucketStats keeps statistics of the lookups and objects of each
+// bucket, see BucketStats. A bucket taking more than the threshold times
+// the mean of the sampled lookups or of the objects raises an
+// EventBucketHitsSkewed or EventBucketObjectsSkewed event for subscriptions
+// and counts MetricBucketSkewAlarms, once as it crosses the threshold.
+func WithBucketStats[T, O comparable](config BucketStatsConfig) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.bucketStats = newBucketStats(config)
+	}
+}
+
+// WithRebalanceProfiling times the phases of each operation that plans to
+// move at least minMoves objects and keeps the last DefaultRebalanceProfiles
+// of them, see RebalanceProfiles
+func WithRebalanceProfiling[T, O comparable](minMoves int) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.rebalances.minMoves = max(minMoves, 1)
+	}
+}
+
+// WithPlacementConstraints names the constraints objects may list in
+// Constraints. An object is only ever assigned to nodes meeting all of its
+// constraints, the assignment fails with a ConstraintError if no node
+// does, and Verify reports objects found on other nodes.
+func WithPlacementConstraints[T, O comparable](constraints map[string]PlacementConstraint[T, O]) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.constraints = constraints
+	}
+}
+
+// WithDecommissionReports reports each removal of nodes, see
+// DecommissionReports, sampling the given number of keys, or
+// DefaultDecommissionSample if 0, to estimate the keys remapped. written,
+// if not nil, is called with each report, e.g. to keep an audit log.
+func WithDecommissionReports[T, O comparable](sample int, written func(DecommissionReport[T, O])) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		if sample <= 0 {
+			sample = DefaultDecommissionSample
+		}
+		lb.decommissions.sample, lb.decommissions.written = sample, written
+	}
+}
+
+// WithDrainCallback calls drained with each node drained by DrainNode once
+// it is empty and gone from the load balancer
+func WithDrainCallback[T, O comparable](drained func(node serverpool.Node[T, O])) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.drained = drained
+	}
+}
+
+// WithMovementBudget limits how many objects rebalancing moves per window.
+// Moves beyond the budget are deferred until Rebalance is called in a later
+// window, and alert is called the first time moves are deferred in a window.
+// A window of 0 uses DefaultChurnWindow.
+func WithMovementBudget[T, O comparable](budget int, window time.Duration, alert func(ChurnAlert)) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.churn.budget, lb.churn.window, lb.churn.alert = budget, window, alert
+	}
+}
+
+// WithEviction evicts the objects beyond the capacity of a node when
+// removing nodes leaves it over capacity, see EvictionPolicy. Evicted
+// objects are left unassigned and reported as ErrObjectEvicted and with
+// EventObjectEvicted events.
+func WithEviction[T, O comparable](policy EvictionPolicy[T, O]) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.eviction = eviction[T, O]{policy: &policy, assigned: make(map[O]time.Time)}
+	}
+}
+
+// WithCoolDown suppresses object moves for the given duration after nodes
+// are added or removed, so a wave of deployments does not cascade into
+// repeated rebalancing. Objects of nodes removed during a cool-down are
+// deferred until Rebalance is called after it ends.
+func WithCoolDown[T, O comparable](cooldown time.Duration) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.churn.cooldown = cooldown
+	}
+}
+
+// WithFlapQuarantine quarantines a node that is added or removed more than
+// limit times within window. Adding a quarantined node fails with
+// ErrNodeQuarantined until backoff has passed.
+func WithFlapQuarantine[T, O comparable](limit int, window, backoff time.Duration) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.flaps = flapDetector[T]{limit: limit, window: window, backoff: backoff}
+	}
+}
+
+// WithHierarchicalKeys hashes keys made of levels joined by separator with
+// one strategy per level, so that keys sharing their LevelGroup levels map
+// to the same group of groupSize nodes
+func WithHierarchicalKeys[T, O comparable](separator string, groupSize int, levels ...consistenthash.LevelStrategy) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.ch = consistenthash.NewHierarchicalHasher(lb.ch, lb.hashAlgo, separator, groupSize, levels...)
+	}
+}
+
+// WithTransferHooks sets the hooks run by TransferObject to copy and
+// release object data
+func WithTransferHooks[T, O comparable](hooks TransferHooks[T, O]) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.transferHooks = hooks
+	}
+}
+
+// WithCooperativeRebalance moves objects whenever nodes are added or
+// removed, but only those whose node changed, in two phases: all moving
+// objects are revoked from their nodes before any is granted to its new
+// node, with the callbacks notified of each phase. Without it objects only
+// move when their node is removed.
+func WithCooperativeRebalance[T, O comparable](callbacks RebalanceCallbacks[T, O]) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.cooperative = &callbacks
+	}
+}
+
+// WithDryRun makes every mutation report what it would do without changing
+// any state or publishing a change. AddNodes and RemoveNodes return the
+// buckets and moves they would make, ignoring the movement budget, and the
+// other mutations only report whether they would fail. A mapped bucket
+// allocator is called again for buckets restored after planning a removal.
+// Dry runs take precedence over read-only, so a mirror created with this
+// option plans against the current state of its primary.
+func WithDryRun[T, O comparable]() Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.dryRun = true
+	}
+}
+
+// WithFeedRetention keeps at least the last changes of the change feed, up
+// to twice as many, instead of the last DefaultFeedRetention. Mirrors,
+// StateAt and feeds starting before the oldest change retained fail with
+// ErrFeedTruncated. With 0 every change is kept, so memory grows with every
+// change for as long as the load balancer lives.
+func WithFeedRetention[T, O comparable](changes int) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.feed.retention = max(changes, 0)
+	}
+}
+
+// WithFaultInjection injects the faults of the injector into the load
+// balancer, so applications can test their error handling: lookups fail
+// with faultinject.ErrInjected, assignments are delayed and subscriptions
+// receive NodeRemoved events for nodes that are still there. Use it in
+// tests only.
+func WithFaultInjection[T, O comparable](faults *faultinject.Injector) Option[T, O] {
+	return func(lb *loadBalancer[T, O]) {
+		lb.faults = faults
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"consistenthash"
+	"errors"
+	"faultinject"
+	"fmt"
+	"hashing"
+	"maps"
+	"net/netip"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestBucketAllocator(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithBucketAllocator[string, string](
+		consistenthash.NewMappedAllocator(func(slot int) int { return 1000 + slot })))
+
+	nodes := []serverpool.Node[string, string]{&mockNode{ID: "node1"}, &mockNode{ID: "node2"}}
+	result, err := lb.AddNodes(nodes)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, nr := range result.Nodes {
+		if nr.Bucket != 1000+i {
+			t.Fatalf("expected bucket %d, got %d", 1000+i, nr.Bucket)
+		}
+	}
+	if _, err := lb.GetNode("key"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestRemovedLimitReplacesObjects(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithRemovedLimit[string, string](1))
+	var nodes []serverpool.Node[string, string]
+	for i := 0; i < 6; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 300; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// The second removal rebuilds the hasher, moving keys between the nodes
+	// that remain
+	if _, err := lb.RemoveNodes(nodes[1:3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		node, err := lb.GetNode(obj.Name())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if assigned := obj.Node(); assigned == nil || *assigned != node {
+			t.Fatalf("expected %v on %v", obj, node)
+		}
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestRemovedLimit(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithRemovedLimit[string, string](2))
+	var nodes []serverpool.Node[string, string]
+	for i := 0; i < 6; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i)})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Remove nodes from the middle so removals pile up and force rebuilds
+	for i := 6; i < 30; i++ {
+		if _, err := lb.RemoveNodes(nodes[1:4]); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		nodes = append(nodes[:1], nodes[4:]...)
+		var added []serverpool.Node[string, string]
+		for j := 0; j < 3; j++ {
+			added = append(added, &mockNode{ID: fmt.Sprintf("node%d-%d", i, j)})
+		}
+		if _, err := lb.AddNodes(added); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		nodes = append(nodes, added...)
+
+		if err := lb.Verify(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if lb.NodeCount() != 6 {
+			t.Fatalf("expected 6 nodes, got %d", lb.NodeCount())
+		}
+	}
+}
+
+func TestRendezvousStrategy(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithHashStrategy[netip.Addr, int](consistenthash.StrategyRendezvous))
+	big := NewWeightedServerNode[int](netip.MustParseAddr("10.0.0.1"), 3)
+	small := NewServerNode[int](netip.MustParseAddr("10.0.0.2"))
+	if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&big, &small}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The weighted node has a single bucket of weight 3
+	buckets := 0
+	for range lb.Buckets() {
+		buckets++
+	}
+	if buckets != 2 {
+		t.Fatalf("expected 2 buckets, got %d", buckets)
+	}
+	share := 0
+	for i := range 4000 {
+		node, err := lb.GetNode(fmt.Sprintf("key%d", i))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if node.Name() == big.Name() {
+			share++
+		}
+	}
+	if share < 2700 || share > 3300 {
+		t.Fatalf("expected about 3000 keys on the weighted node, got %d", share)
+	}
+	nodes, err := lb.GetNodes("key", 2)
+	if err != nil || len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %v, %v", nodes, err)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.Topology(); err == nil {
+		t.Fatal("expected an error exporting a rendezvous topology")
+	}
+}
+
+func TestMaglevStrategy(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithMaglevTable[netip.Addr, int](1000))
+	var nodes []serverpool.Node[netip.Addr, int]
+	for i := range 4 {
+		node := NewServerNode[int](netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 1)}))
+		nodes = append(nodes, &node)
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	before := make(map[string]netip.Addr)
+	for i := range 2000 {
+		key := fmt.Sprintf("key%d", i)
+		node, err := lb.GetNode(key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		before[key] = node.Name()
+	}
+	if _, err := lb.RemoveNodes(nodes[1:2]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for key, name := range before {
+		node, err := lb.GetNode(key)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if name != nodes[1].Name() && node.Name() != name {
+			t.Fatalf("expected %s to stay on %v, got %v", key, name, node.Name())
+		}
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	faults, err := faultinject.New(faultinject.Config{LookupErrorRate: 1, SpuriousRemovalRate: 1, Seed: 1})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	faults.SetEnabled(false)
+	lb := NewLoadBalancerWithOptions(WithFaultInjection[string, string](faults))
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	node1, node2 := newNode("node1"), newNode("node2")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.GetNode("key"); err != nil {
+		t.Fatalf("expected no error while disabled, got %v", err)
+	}
+
+	faults.SetEnabled(true)
+	if _, err := lb.GetNode("key"); !errors.Is(err, faultinject.ErrInjected) {
+		t.Fatalf("expected ErrInjected, got %v", err)
+	}
+
+	// Changes are followed by the removal of a node that stays
+	ch, sub := lb.Watch(0, EventNodeRemoved)
+	defer sub.Unsubscribe()
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{{Id: "obj"}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case e := <-ch:
+		if e.Node != node1 && e.Node != node2 {
+			t.Fatalf("expected a removal of node1 or node2, got %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a spurious removal")
+	}
+	if lb.NodeCount() != 2 {
+		t.Fatalf("expected both nodes to stay, got %d", lb.NodeCount())
+	}
+	if stats := faults.Stats(); stats.LookupErrors != 1 || stats.SpuriousRemovals != 1 {
+		t.Fatalf("expected the injected faults counted, got %+v", stats)
+	}
+}
+
+func TestPluggableOptions(t *testing.T) {
+	// The application's own pool and hasher
+	sp := &mockServerPool[string, string]{nodes: make(map[int]serverpool.Node[string, string])}
+	ch := &mockConsistentHasher{}
+	lb := NewLoadBalancerWithOptions(WithServerPool(sp), WithHasher[string, string](ch))
+	nodes := []serverpool.Node[string, string]{
+		&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
+		&mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(sp.nodes) != 2 || ch.buckets != 2 {
+		t.Fatalf("expected the nodes in the given pool and hasher, got %d and %d", len(sp.nodes), ch.buckets)
+	}
+	if node, err := lb.GetNode("key"); err != nil || node != sp.nodes[ch.GetBucket("key")] {
+		t.Fatalf("expected the node of the given hasher, got %v, %v", node, err)
+	}
+
+	// Keys hashed with another algorithm
+	lb = NewLoadBalancerWithOptions(WithHashAlgorithm[string, string](hashing.SHA256))
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := consistenthash.NewConsistentHasherWithAlgo(hashing.SHA256)
+	want.AddBucket()
+	want.AddBucket()
+	buckets := maps.Collect(lb.Buckets())
+	for i := range 100 {
+		key := fmt.Sprint("key", i)
+		if node, err := lb.GetNode(key); err != nil || node != buckets[want.GetBucket(key)] {
+			t.Fatalf("expected %s to be hashed with sha256, got %v, %v", key, node, err)
+		}
+	}
+}
+
+func TestWithHashSeed(t *testing.T) {
+	newLB := func(opts ...Option[string, string]) LoadBalancer[string, string] {
+		lb := NewLoadBalancerWithOptions(opts...)
+		var nodes []serverpool.Node[string, string]
+		for i := 0; i < 10; i++ {
+			nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+		}
+		if _, err := lb.AddNodes(nodes); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return lb
+	}
+	unseeded := newLB()
+	zero := newLB(WithHashSeed[string, string](0))
+	tenant1 := newLB(WithHashSeed[string, string](1))
+	tenant1Again := newLB(WithHashSeed[string, string](1))
+	tenant2 := newLB(WithHashSeed[string, string](2))
+
+	differ := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		u, _ := unseeded.GetNode(key)
+		z, _ := zero.GetNode(key)
+		a, _ := tenant1.GetNode(key)
+		b, _ := tenant1Again.GetNode(key)
+		c, _ := tenant2.GetNode(key)
+		if u.Name() != z.Name() {
+			t.Fatalf("expected seed 0 to map %s as unseeded", key)
+		}
+		if a.Name() != b.Name() {
+			t.Fatalf("expected the same seed to map %s the same", key)
+		}
+		if a.Name() != c.Name() {
+			differ++
+		}
+	}
+
+	// Independent distributions over 10 nodes agree on about a tenth of keys
+	if differ < 800 {
+		t.Fatalf("expected tenants to map keys independently, %d of 1000 differ", differ)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Metrics recorded with an OpenTelemetry meter
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// OTelMetrics records metrics with an OpenTelemetry meter, with labels as
+// attributes. Counters are float64 counters, gauges synchronous float64
+// gauges and histograms float64 histograms in seconds. Instruments the
+// meter fails to create drop their metrics.
+type OTelMetrics struct {
+	meter metric.Meter
+
+	mu         sync.Mutex
+	counters   map[string]metric.Float64Counter
+	gauges     map[string]metric.Float64Gauge
+	histograms map[string]metric.Float64Histogram
+}
+
+// Create metrics recorded with meter, e.g. from
+// otel.GetMeterProvider().Meter("loadbalance")
+func NewOTelMetrics(meter metric.Meter) *OTelMetrics {
+	return &OTelMetrics{meter: meter, counters: make(map[string]metric.Float64Counter),
+		gauges: make(map[string]metric.Float64Gauge), histograms: make(map[string]metric.Float64Histogram)}
+}
+
+// Attributes of name, value label pairs
+func otelAttributes(labels []string) metric.MeasurementOption {
+	attrs := make([]attribute.KeyValue, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		attrs = append(attrs, attribute.String(labels[i], labels[i+1]))
+	}
+	return metric.WithAttributeSet(attribute.NewSet(attrs...))
+}
+
+func (o *OTelMetrics) Counter(name string, labels ...string) Counter {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	c, ok := o.counters[name]
+	if !ok {
+		var err error
+		if c, err = o.meter.Float64Counter(name); err != nil {
+			c = noop.Float64Counter{}
+		}
+		o.counters[name] = c
+	}
+	return otelCounter{c, otelAttributes(labels)}
+}
+
+func (o *OTelMetrics) Gauge(name string, labels ...string) Gauge {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	g, ok := o.gauges[name]
+	if !ok {
+		var err error
+		if g, err = o.meter.Float64Gauge(name); err != nil {
+			g = noop.Float64Gauge{}
+		}
+		o.gauges[name] = g
+	}
+	return otelGauge{g, otelAttributes(labels)}
+}
+
+func (o *OTelMetrics) Histogram(name string, labels ...string) Histogram {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h, ok := o.histograms[name]
+	if !ok {
+		var err error
+		if h, err = o.meter.Float64Histogram(name, metric.WithUnit("s")); err != nil {
+			h = noop.Float64Histogram{}
+		}
+		o.histograms[name] = h
+	}
+	return otelHistogram{h, otelAttributes(labels)}
+}
+
+type otelCounter struct {
+	c     metric.Float64Counter
+	attrs metric.MeasurementOption
+}
+
+type otelGauge struct {
+	g     metric.Float64Gauge
+	attrs metric.MeasurementOption
+}
+
+type otelHistogram struct {
+	h     metric.Float64Histogram
+	attrs metric.MeasurementOption
+}
+
+func (c otelCounter) Add(delta float64) {
+	c.c.Add(context.Background(), delta, c.attrs)
+}
+
+func (g otelGauge) Set(value float64) {
+	g.g.Record(context.Background(), value, g.attrs)
+}
+
+func (h otelHistogram) Observe(value float64) {
+	h.h.Record(context.Background(), value, h.attrs)
+}
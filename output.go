@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Text and JSON output of CLI commands
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// output writes command results either as text or as one JSON object per
+// line. In JSON mode menus, prompts and progress messages go to the log
+// writer so the result writer only carries machine-parseable results.
+type output struct {
+	json bool
+
+	// Command results
+	out io.Writer
+
+	// Menus, prompts and progress messages
+	log io.Writer
+}
+
+// Result of a command in JSON mode
+type commandResult struct {
+	Command string        `json:"command"`
+	Error   string        `json:"error,omitempty"`
+	Key     string        `json:"key,omitempty"`
+	Node    string        `json:"node,omitempty"`
+	Nodes   []nodeResult  `json:"nodes,omitempty"`
+	Objects []objectEntry `json:"objects,omitempty"`
+
+	// Distribution statistics by measured quantity
+	Stats map[string]distributionStats `json:"stats,omitempty"`
+}
+
+type nodeResult struct {
+	Address string `json:"address"`
+	Bucket  int    `json:"bucket"`
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// Objects assigned and requests received in a generated workload
+	Objects  int `json:"objects,omitempty"`
+	Requests int `json:"requests,omitempty"`
+}
+
+type objectEntry struct {
+	Id     int    `json:"id"`
+	Node   string `json:"node,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// Print a progress message
+func (o *output) info(a ...any) {
+	fmt.Fprintln(o.log, a...)
+}
+
+// Print a prompt without a trailing newline
+func (o *output) prompt(s string) {
+	fmt.Fprint(o.log, s)
+}
+
+// Print a command result, as JSON in JSON mode or as text otherwise
+func (o *output) emit(result commandResult, text ...any) {
+	if !o.json {
+		fmt.Fprintln(o.out, text...)
+		return
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		fmt.Fprintln(o.log, "Error encoding result:", err)
+		return
+	}
+	fmt.Fprintln(o.out, string(b))
+}
+
+// Print a command error
+func (o *output) fail(command string, text string, err error) {
+	msg := text
+	if err != nil {
+		msg = fmt.Sprint(text, ": ", err)
+	}
+	o.emit(commandResult{Command: command, Error: msg}, msg)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutput(t *testing.T) {
+	var results, log bytes.Buffer
+	o := &output{out: &results, log: &log}
+	o.info("Adding", 2, "nodes")
+	o.prompt("Operation: ")
+	o.emit(commandResult{Command: "map", Key: "key", Node: "10.0.0.1"}, "Key", "key", "maps to node", "10.0.0.1")
+	o.fail("map", "Error mapping key", errors.New("no nodes"))
+	if got, want := results.String(), "Key key maps to node 10.0.0.1\nError mapping key: no nodes\n"; got != want {
+		t.Fatalf("expected text results %q, got %q", want, got)
+	}
+	if got, want := log.String(), "Adding 2 nodes\nOperation: "; got != want {
+		t.Fatalf("expected messages %q, got %q", want, got)
+	}
+
+	// JSON results leave out empty fields
+	results.Reset()
+	o.json = true
+	o.emit(commandResult{Command: "map", Key: "key", Node: "10.0.0.1"}, "ignored")
+	o.fail("delnode", "Node not found", nil)
+	want := `{"command":"map","key":"key","node":"10.0.0.1"}` + "\n" +
+		`{"command":"delnode","error":"Node not found"}` + "\n"
+	if got := results.String(); got != want {
+		t.Fatalf("expected JSON results %q, got %q", want, got)
+	}
+}
+
+func TestJSONCommands(t *testing.T) {
+	var results, log bytes.Buffer
+	savedOut, savedBatch, savedAddrs, savedR := out, batch, addrs, r
+	t.Cleanup(func() { out, batch, addrs, r = savedOut, savedBatch, savedAddrs, savedR })
+	out = &output{json: true, out: &results, log: &log}
+	batch = true
+	addrs = make(map[netip.Addr]struct{})
+	r = rand.New(rand.NewSource(1))
+
+	lb := NewLoadBalancer[netip.Addr, int]()
+	input := []struct {
+		op   int
+		line string
+	}{
+		{ADDNODE, "10.0.0.1 2"},
+		{ADDNODE, "10.0.0.2"},
+		{ADD, "2"},
+		{MAP, "key"},
+		{ADDWORK, "7"},
+		{SHOWNODES, ""},
+		{SHOWWORK, ""},
+		{REMWORK, "7"},
+		{DELNODE, "10.0.0.9"},
+		{ADDWORK, "seven"},
+	}
+	for _, in := range input {
+		reader := bufferedReader{bufio.NewReader(strings.NewReader(in.line + "\n"))}
+		command, err := readCommand(lb, reader, in.op, &sync.Mutex{})
+		if err == nil {
+			command()
+		}
+	}
+
+	// Every line of the results is a command result
+	var got []commandResult
+	for _, line := range strings.Split(strings.TrimSuffix(results.String(), "\n"), "\n") {
+		var res commandResult
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			t.Fatalf("expected a JSON result, got %q: %v", line, err)
+		}
+		got = append(got, res)
+	}
+	if len(got) != len(input) {
+		t.Fatalf("expected %d results, got %d:\n%s", len(input), len(got), results.String())
+	}
+	if log.Len() == 0 {
+		t.Fatalf("expected prompts and progress messages in the log")
+	}
+
+	if res := got[0]; res.Command != "addnode" || len(res.Nodes) != 1 || res.Nodes[0].Address != "10.0.0.1" || res.Nodes[0].Status != StatusOK.String() {
+		t.Fatalf("expected the added node, got %+v", res)
+	}
+	if res := got[2]; res.Command != "add" || len(res.Nodes) != 2 || res.Error != "" {
+		t.Fatalf("expected two added nodes, got %+v", res)
+	}
+	node, err := lb.GetNode("key")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if res := got[3]; res.Command != "map" || res.Key != "key" || res.Node != node.Name().String() {
+		t.Fatalf("expected key mapped to %v, got %+v", node, res)
+	}
+	if res := got[4]; res.Command != "addwork" || len(res.Objects) != 1 || res.Objects[0].Id != 7 || res.Objects[0].Node == "" {
+		t.Fatalf("expected the assigned work, got %+v", res)
+	}
+	if res := got[5]; res.Command != "nodes" || len(res.Nodes) != 4 {
+		t.Fatalf("expected four nodes, got %+v", res)
+	}
+	for i := 1; i < len(got[5].Nodes); i++ {
+		if got[5].Nodes[i-1].Bucket >= got[5].Nodes[i].Bucket {
+			t.Fatalf("expected nodes ordered by bucket, got %+v", got[5].Nodes)
+		}
+	}
+	if res := got[6]; res.Command != "work" || len(res.Objects) != 1 || res.Objects[0] != got[4].Objects[0] {
+		t.Fatalf("expected the work added, got %+v", res)
+	}
+	if res := got[7]; res.Command != "remwork" || len(res.Objects) != 1 || res.Objects[0].Status != StatusOK.String() {
+		t.Fatalf("expected the work removed, got %+v", res)
+	}
+
+	// Failures are results with an error
+	if res := got[8]; res.Command != "delnode" || res.Error != "Node not found" {
+		t.Fatalf("expected the unknown node reported, got %+v", res)
+	}
+	if res := got[9]; res.Command != "addwork" || !strings.HasPrefix(res.Error, "Invalid object ID") {
+		t.Fatalf("expected the invalid input reported, got %+v", res)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Planning of object moves between nodes
+
+package main
+
+import (
+	"fmt"
+	"iter"
+	"serverpool"
+)
+
+// Move describes an object migrating from one node to another
+type Move[T, O comparable] struct {
+	// Object being moved
+	Object *serverpool.Object[T, O]
+
+	// Node the object is currently assigned to
+	From serverpool.Node[T, O]
+
+	// Node the object will be assigned to
+	To serverpool.Node[T, O]
+}
+
+func (m Move[T, O]) String() string {
+	return fmt.Sprintf("%v: %v -> %v", m.Object, m.From, m.To)
+}
+
+// Node an object belongs on: the node it was transferred to while it is in
+// the pool, or else the first of its preferred nodes in the pool, or else the node its key maps to within its tier, or among all nodes if
+// the tier has none. An object with constraints that node does not meet
+// belongs on the first candidate of its key that meets them.
+func (lb *loadBalancer[T, O]) placement(obj *serverpool.Object[T, O]) (serverpool.Node[T, O], error) {
+	defer lb.rebalances.enter(PhaseHashing)()
+	node, err := lb.mapObject(obj)
+	if err != nil || len(obj.Constraints) == 0 {
+		return node, err
+	}
+	return lb.constrain(obj, node)
+}
+
+// Node an object maps to regardless of its constraints
+func (lb *loadBalancer[T, O]) mapObject(obj *serverpool.Object[T, O]) (serverpool.Node[T, O], error) {
+	if node, ok := lb.transferredTo(obj); ok {
+		return node, nil
+	}
+	for _, name := range obj.Preferred {
+		if node, ok := lb.nodeByName(name); ok {
+			return node, nil
+		}
+	}
+	if obj.Tier != "" {
+		key := obj.Name()
+		if lb.normalize != nil {
+			key = lb.normalize(key)
+		}
+		if node, ok := lb.tierNode(key, obj.Tier); ok && key != "" {
+			return node, nil
+		}
+	}
+	return lb.mapKey(obj.Name())
+}
+
+// Compute the moves for objects currently on node given the current hasher state.
+// Objects that cannot be mapped to any node are left out of the plan and
+// returned with the mapping error.
+func (lb *loadBalancer[T, O]) planMoves(from serverpool.Node[T, O], objects iter.Seq[*serverpool.Object[T, O]]) ([]Move[T, O], map[O]error) {
+	defer lb.rebalances.enter(PhasePlanning)()
+	var moves []Move[T, O]
+	var unmapped map[O]error
+	for obj := range objects {
+		to, err := lb.placement(obj)
+		if err != nil {
+			lb.prefetcher.forget(obj)
+			if unmapped == nil {
+				unmapped = make(map[O]error)
+			}
+			unmapped[obj.Id] = err
+			continue
+		}
+		if to == from {
+			lb.prefetcher.replan(obj, nil)
+			continue
+		}
+		lb.prefetcher.replan(obj, to)
+		moves = append(moves, Move[T, O]{Object: obj, From: from, To: to})
+	}
+	lb.rebalances.planned(len(moves))
+	return moves, unmapped
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"serverpool"
+	"testing"
+)
+
+func TestPreferredNodes(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithCooperativeRebalance(RebalanceCallbacks[string, string]{}))
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	node1, node2, node3 := newNode("node1"), newNode("node2"), newNode("node3")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	obj := &serverpool.Object[string, string]{Id: "obj1", Preferred: []string{"node3", "node2"}}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// node3 is not in the pool, so the next preference is used
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if *obj.Node() != node2 {
+		t.Fatalf("expected %v on node2, got %v", obj, *obj.Node())
+	}
+
+	// Preferences are re-evaluated when membership changes
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node3}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if *obj.Node() != node3 {
+		t.Fatalf("expected %v on node3, got %v", obj, *obj.Node())
+	}
+
+	// Without any preferred node the key decides
+	if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{node3, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if *obj.Node() != node1 {
+		t.Fatalf("expected %v on node1, got %v", obj, *obj.Node())
+	}
+}
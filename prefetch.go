@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Prefetch hints sent to destination nodes ahead of a migration
+
+package main
+
+import (
+	"serverpool"
+	"time"
+)
+
+// PrefetchHook receives the moves planned towards a destination node before
+// the objects are cut over, so the node can warm its caches
+type PrefetchHook[T, O comparable] func(to serverpool.Node[T, O], moves []Move[T, O])
+
+type prefetcher[T, O comparable] struct {
+	hook PrefetchHook[T, O]
+
+	// Time between emitting the hints and cutting over the objects
+	lead time.Duration
+
+	// Objects hinted to move and when they may be cut over
+	hinted map[*serverpool.Object[T, O]]hint[T, O]
+
+	// Saves the hints the transaction being committed changes, if any
+	undo entryUndo[*serverpool.Object[T, O], hint[T, O]]
+}
+
+// Destination an object was hinted to move to and when it may be cut over
+type hint[T, O comparable] struct {
+	to  serverpool.Node[T, O]
+	due time.Time
+}
+
+// Emit prefetch hints for the planned moves grouped by destination node.
+// Moves whose objects were hinted the lead time ago or more are ready to
+// be cut over, others are held until a later rebalance plans them again,
+// so nothing waits for the lead time.
+func (p *prefetcher[T, O]) prefetch(moves []Move[T, O], now time.Time) (ready, held []Move[T, O]) {
+	if p.hook == nil || len(moves) == 0 {
+		return moves, nil
+	}
+
+	var order []serverpool.Node[T, O]
+	byNode := make(map[serverpool.Node[T, O]][]Move[T, O])
+	for _, m := range moves {
+		if p.lead <= 0 {
+			ready = append(ready, m)
+		} else if h, ok := p.hinted[m.Object]; ok && h.to == m.To {
+			if now.Before(h.due) {
+				held = append(held, m)
+			} else {
+				p.undo.save(p.hinted, m.Object)
+				delete(p.hinted, m.Object)
+				ready = append(ready, m)
+			}
+			continue
+		} else {
+			if p.hinted == nil {
+				p.hinted = make(map[*serverpool.Object[T, O]]hint[T, O])
+			}
+			p.undo.save(p.hinted, m.Object)
+			p.hinted[m.Object] = hint[T, O]{to: m.To, due: now.Add(p.lead)}
+			held = append(held, m)
+		}
+
+		if _, ok := byNode[m.To]; !ok {
+			order = append(order, m.To)
+		}
+		byNode[m.To] = append(byNode[m.To], m)
+	}
+
+	for _, to := range order {
+		p.hook(to, byNode[to])
+	}
+	return ready, held
+}
+
+// Drop the hint of an object the planner no longer moves to the hinted
+// node, to is nil if the object stays put. A move planned again later gets
+// a fresh lead time instead of being cut over on a hint nobody acted on.
+func (p *prefetcher[T, O]) replan(obj *serverpool.Object[T, O], to serverpool.Node[T, O]) {
+	if h, ok := p.hinted[obj]; ok && h.to != to {
+		p.undo.save(p.hinted, obj)
+		delete(p.hinted, obj)
+	}
+}
+
+// Forget the hint of an object that left the load balancer or its node
+func (p *prefetcher[T, O]) forget(obj *serverpool.Object[T, O]) {
+	p.undo.save(p.hinted, obj)
+	delete(p.hinted, obj)
+}
+
+// Forget the hints towards a node that left the load balancer
+func (p *prefetcher[T, O]) forgetNode(node serverpool.Node[T, O]) {
+	for obj, h := range p.hinted {
+		if h.to == node {
+			p.undo.save(p.hinted, obj)
+			delete(p.hinted, obj)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestPrefetchHook(t *testing.T) {
+	hinted := make(map[string]string)
+	hook := func(to serverpool.Node[string, string], moves []Move[string, string]) {
+		for _, m := range moves {
+			if _, ok := hinted[m.Object.Id]; ok {
+				t.Fatalf("expected %v hinted once", m.Object)
+			}
+			hinted[m.Object.Id] = to.Name()
+		}
+	}
+	lb := NewLoadBalancerWithOptions(WithPrefetchHook(hook, time.Minute)).(*loadBalancer[string, string])
+	now := time.Now()
+	lb.churn.now = func() time.Time { return now }
+
+	var nodes []serverpool.Node[string, string]
+	for i := 0; i < 3; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 60; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// The objects of the removed node are hinted and deferred without
+	// waiting for the lead time
+	moving := len(nodes[2].(*mockNode).objects)
+	result, err := lb.RemoveNodes(nodes[2:])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result.Deferred() != moving || len(hinted) != moving {
+		t.Fatalf("expected %d objects hinted and deferred, got %d hinted and %d deferred", moving, len(hinted), result.Deferred())
+	}
+	if moved, err := lb.Rebalance(); err != nil || moved != 0 {
+		t.Fatalf("expected nothing cut over before the lead time, got %d, %v", moved, err)
+	}
+
+	now = now.Add(time.Minute)
+	if moved, err := lb.Rebalance(); err != nil || moved != moving {
+		t.Fatalf("expected %d objects cut over after the lead time, got %d, %v", moving, moved, err)
+	}
+	for id, to := range hinted {
+		obj, _ := lb.objects.get(id)
+		if n := obj.Node(); n == nil || (*n).Name() != to {
+			t.Fatalf("expected %v on the hinted %s", obj, to)
+		}
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestPrefetchReplanned(t *testing.T) {
+	hints := make(map[string][]string)
+	hook := func(to serverpool.Node[string, string], moves []Move[string, string]) {
+		for _, m := range moves {
+			hints[m.Object.Id] = append(hints[m.Object.Id], to.Name())
+		}
+	}
+	lb := NewLoadBalancerWithOptions(WithPrefetchHook(hook, time.Minute)).(*loadBalancer[string, string])
+	now := time.Now()
+	lb.churn.now = func() time.Time { return now }
+
+	var nodes []serverpool.Node[string, string]
+	for i := 0; i < 3; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 60; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// The objects of node2 are hinted to the other nodes, then node1 leaves
+	// and comes back before they are cut over
+	if _, err := lb.RemoveNodes(nodes[2:]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	toNode1 := 0
+	for _, to := range hints {
+		if to[0] == "node1" {
+			toNode1++
+		}
+	}
+	if toNode1 == 0 {
+		t.Fatalf("expected objects hinted to node1, got %v", hints)
+	}
+	now = now.Add(30 * time.Second)
+	if _, err := lb.RemoveNodes(nodes[1:2]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.AddNodes(nodes[1:2]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The lead time of the first hints has passed, but moves planned to
+	// node1 again wait for a lead time of their own
+	now = now.Add(30 * time.Second)
+	if moved, err := lb.Rebalance(); err != nil || moved == 0 {
+		t.Fatalf("expected objects hinted to node0 cut over, got %d, %v", moved, err)
+	}
+	if n := len(nodes[1].(*mockNode).objects); n != 0 {
+		t.Fatalf("expected nothing cut over to node1 before a fresh lead time, got %d objects", n)
+	}
+	rehinted := 0
+	for _, to := range hints {
+		if len(to) > 1 && to[len(to)-1] == "node1" {
+			rehinted++
+		}
+	}
+	if rehinted == 0 {
+		t.Fatalf("expected moves to node1 hinted again, got %v", hints)
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, err := lb.Rebalance(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := len(nodes[1].(*mockNode).objects); n != 0 {
+		t.Fatalf("expected nothing cut over to node1 before a fresh lead time, got %d objects", n)
+	}
+	now = now.Add(30 * time.Second)
+	if _, err := lb.Rebalance(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := len(nodes[1].(*mockNode).objects); n != rehinted {
+		t.Fatalf("expected %d objects cut over to node1 after a fresh lead time, got %d", rehinted, n)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(lb.prefetcher.hinted) != 0 {
+		t.Fatalf("expected no hints left once every object was cut over, got %d", len(lb.prefetcher.hinted))
+	}
+}
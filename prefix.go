@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Mapping of key prefixes to the nodes owning their keys
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+	"strconv"
+	"strings"
+)
+
+// PrefixSampler generates the i-th sample key under a prefix
+type PrefixSampler func(prefix string, i int) string
+
+// SuffixSampler appends the sample number to the prefix
+func SuffixSampler(prefix string, i int) string {
+	return prefix + strconv.Itoa(i)
+}
+
+// LevelSampler appends a separator and the sample number to the prefix,
+// for prefixes of hierarchical keys such as tenant/table
+func LevelSampler(separator string) PrefixSampler {
+	return func(prefix string, i int) string {
+		return prefix + separator + strconv.Itoa(i)
+	}
+}
+
+// PrefixMapping reports which nodes own the keys under a prefix
+type PrefixMapping[T comparable] struct {
+	// Number of sample keys mapped
+	Samples int
+
+	// Sample keys mapped to each node
+	Nodes map[T]int
+
+	// Node group the prefix is pinned to, nil if it is not pinned
+	Pinned []T
+}
+
+// Prefix pinned to a group of nodes
+type prefixPin[T comparable] struct {
+	prefix string
+	nodes  []T
+}
+
+// MapPrefix maps sample keys generated by sampler under prefix and reports
+// the nodes they map to. The more samples, the more likely every node owning
+// keys under the prefix is found.
+func (lb *loadBalancer[T, O]) MapPrefix(prefix string, sampler PrefixSampler, samples int) (PrefixMapping[T], error) {
+	if samples <= 0 {
+		return PrefixMapping[T]{}, errors.New("samples must be positive")
+	}
+	if sampler == nil {
+		sampler = SuffixSampler
+	}
+
+	mapping := PrefixMapping[T]{Samples: samples, Nodes: make(map[T]int)}
+	if pin := lb.pinFor(prefix); pin != nil {
+		mapping.Pinned = pin.nodes
+	}
+	for i := 0; i < samples; i++ {
+		node, err := lb.mapKey(sampler(prefix, i))
+		if err != nil {
+			return mapping, err
+		}
+		mapping.Nodes[node.Name()]++
+	}
+	return mapping, nil
+}
+
+// Longest pinned prefix of a key, nil if there is none
+func (lb *loadBalancer[T, O]) pinFor(key string) *prefixPin[T] {
+	var longest *prefixPin[T]
+	for i := range lb.pins {
+		pin := &lb.pins[i]
+		if strings.HasPrefix(key, pin.prefix) && (longest == nil || len(pin.prefix) > len(longest.prefix)) {
+			longest = pin
+		}
+	}
+	return longest
+}
+
+// Node a key is pinned to by PinKey, otherwise the node of the pinned group
+// it maps to, by rendezvous hashing so removing a node of the group only
+// moves its own keys. Keys of a group without nodes in the pool are not
+// pinned, nor keys pinned to a node not in the pool.
+func (lb *loadBalancer[T, O]) pinned(key string) (serverpool.Node[T, O], bool) {
+	if name, ok := lb.keyPins[key]; ok {
+		if node, ok := lb.nodeByName(name); ok {
+			return node, true
+		}
+	}
+	pin := lb.pinFor(key)
+	if pin == nil {
+		return nil, false
+	}
+
+	var best serverpool.Node[T, O]
+	var bestScore uint64
+	for _, name := range pin.nodes {
+		node, ok := lb.nodeByName(name)
+		if !ok {
+			continue
+		}
+		if score := rendezvousScore(name, key); best == nil || score > bestScore {
+			best, bestScore = node, score
+		}
+	}
+	return best, best != nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+)
+
+func TestMapPrefix(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithPrefixPin[string, string]("tenant1/", "node1", "node2"),
+		WithPrefixPin[string, string]("tenant1/hot/", "node3"))
+	var nodes []serverpool.Node[string, string]
+	for i := 1; i <= 4; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i)})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Unpinned keys spread over every node
+	mapping, err := lb.MapPrefix("tenant2/", LevelSampler(""), 200)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(mapping.Nodes) != 4 || mapping.Pinned != nil {
+		t.Fatalf("expected keys on 4 unpinned nodes, got %+v", mapping)
+	}
+
+	// Pinned keys stay within their group, the longest prefix wins
+	mapping, err = lb.MapPrefix("tenant1/", nil, 200)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(mapping.Nodes) != 2 || mapping.Nodes["node1"]+mapping.Nodes["node2"] != 200 {
+		t.Fatalf("expected keys on node1 and node2, got %+v", mapping)
+	}
+	if node, _ := lb.GetNode("tenant1/hot/key"); node.Name() != "node3" {
+		t.Fatalf("expected node3, got %v", node)
+	}
+
+	// The keys of a removed node move to the rest of its group
+	if _, err := lb.RemoveNodes(nodes[1:2]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if node, _ := lb.GetNode(SuffixSampler("tenant1/", i)); node.Name() != "node1" {
+			t.Fatalf("expected node1, got %v", node)
+		}
+	}
+}
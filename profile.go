@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Profiler labels and trace regions for load balancer operations
+
+package main
+
+import (
+	"context"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+// Label key attributing samples in CPU profiles to load balancer operations
+const ProfileLabel = "loadbalance.op"
+
+type profiler struct {
+	// Add pprof labels to operations, nested in the labels of ctx
+	labels bool
+	ctx    context.Context
+
+	// Also wrap operations in runtime/trace regions
+	trace bool
+
+	// Count and time operations if set
+	metrics *lbMetrics
+}
+
+// Run fn labeled with the operation name when profiling is enabled. The
+// goroutine has the labels of p.ctx and the operation while fn runs and
+// those of p.ctx after it.
+func (p profiler) do(op string, fn func()) {
+	if p.metrics != nil {
+		defer func(start time.Time) { p.metrics.operation(op, time.Since(start)) }(time.Now())
+	}
+	if !p.labels {
+		fn()
+		return
+	}
+
+	pprof.Do(p.ctx, pprof.Labels(ProfileLabel, op), func(ctx context.Context) {
+		if p.trace {
+			defer trace.StartRegion(ctx, "loadbalance."+op).End()
+		}
+		fn()
+	})
+}
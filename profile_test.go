@@ -0,0 +1,96 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"serverpool"
+	"strings"
+	"testing"
+)
+
+// Labels of the calling goroutine as the goroutine profile shows them,
+// empty if it has none
+func goroutineLabels(t *testing.T) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&b, 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, record := range strings.Split(b.String(), "\n\n") {
+		if !strings.Contains(record, "TestProfilingLabels") {
+			continue
+		}
+		for _, line := range strings.Split(record, "\n") {
+			if labels, ok := strings.CutPrefix(line, "# labels: "); ok {
+				return labels
+			}
+		}
+		return ""
+	}
+	t.Fatalf("expected the calling goroutine in the goroutine profile")
+	return ""
+}
+
+func TestProfilingLabels(t *testing.T) {
+	run := func(opts ...Option[string, string]) (during, after string) {
+		opts = append(opts, WithCooperativeRebalance(RebalanceCallbacks[string, string]{
+			Assigned: func(serverpool.Node[string, string], []*serverpool.Object[string, string]) {
+				during = goroutineLabels(t)
+			},
+		}))
+		lb := NewLoadBalancerWithOptions(opts...)
+		if _, err := lb.AddNodes([]serverpool.Node[string, string]{
+			&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
+		}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var objs []*serverpool.Object[string, string]
+		for i := range 20 {
+			objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+		}
+		if _, err := lb.AddObjects(objs); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, obj := range objs {
+			if err := lb.AssignObject(obj); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+
+		// Objects moving to the new node are assigned during the operation
+		if _, err := lb.AddNodes([]serverpool.Node[string, string]{
+			&mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])},
+		}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return during, goroutineLabels(t)
+	}
+
+	if during, _ := run(); strings.Contains(during, ProfileLabel) {
+		t.Fatalf("expected no labels without profiling, got %s", during)
+	}
+
+	during, after := run(WithProfiling[string, string](false))
+	if !strings.Contains(during, `"`+ProfileLabel+`":"rebalance"`) {
+		t.Fatalf("expected the operation label, got %q", during)
+	}
+	if after != "" {
+		t.Fatalf("expected no labels after the operation, got %s", after)
+	}
+
+	// Operation labels nest in the labels of the context
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("service", "test"))
+	during, after = run(WithProfilingContext[string, string](ctx, false))
+	if !strings.Contains(during, `"`+ProfileLabel+`":"rebalance"`) || !strings.Contains(during, `"service":"test"`) {
+		t.Fatalf("expected the operation and service labels, got %q", during)
+	}
+	if after != `{"service":"test"}` {
+		t.Fatalf("expected the labels of the context after the operation, got %q", after)
+	}
+}
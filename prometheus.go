@@ -0,0 +1,203 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Metrics served in the Prometheus text exposition format
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultHistogramBuckets are the upper bounds of histogram buckets in
+// seconds, the defaults of the Prometheus client
+var DefaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics keeps metrics in memory and serves them to Prometheus
+// scrapes as an http.Handler
+type PrometheusMetrics struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	kind   string
+	name   string
+	labels string
+
+	// Value of a counter or gauge, sum of a histogram
+	value float64
+
+	// Cumulative counts of the histogram buckets and all observations
+	counts []uint64
+	count  uint64
+
+	m *PrometheusMetrics
+}
+
+// Create Prometheus metrics with histograms of the given buckets, or
+// DefaultHistogramBuckets if none are given
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultHistogramBuckets
+	}
+	return &PrometheusMetrics{buckets: slices.Sorted(slices.Values(buckets)),
+		series: make(map[string]*promSeries)}
+}
+
+// Get or create the series of a name and labels
+func (p *PrometheusMetrics) get(kind, name string, labels []string) *promSeries {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", labels[i], quoteLabel(labels[i+1]))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := name + "{" + b.String() + "}"
+	s, ok := p.series[key]
+	if !ok {
+		s = &promSeries{kind: kind, name: name, labels: b.String(), m: p}
+		if kind == "histogram" {
+			s.counts = make([]uint64, len(p.buckets))
+		}
+		p.series[key] = s
+	}
+	return s
+}
+
+func (p *PrometheusMetrics) Counter(name string, labels ...string) Counter {
+	return p.get("counter", name, labels)
+}
+
+func (p *PrometheusMetrics) Gauge(name string, labels ...string) Gauge {
+	return p.get("gauge", name, labels)
+}
+
+func (p *PrometheusMetrics) Histogram(name string, labels ...string) Histogram {
+	return p.get("histogram", name, labels)
+}
+
+func (s *promSeries) Add(delta float64) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.value += delta
+}
+
+func (s *promSeries) Set(value float64) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	s.value = value
+}
+
+func (s *promSeries) Observe(value float64) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, le := range s.m.buckets {
+		if value <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+// Write every series in the text exposition format
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	keys := slices.Sorted(func(yield func(string) bool) {
+		for key := range p.series {
+			if !yield(key) {
+				return
+			}
+		}
+	})
+	var b strings.Builder
+	typed := make(map[string]bool)
+	for _, key := range keys {
+		s := p.series[key]
+		if !typed[s.name] {
+			typed[s.name] = true
+			fmt.Fprintf(&b, "# TYPE %s %s\n", s.name, s.kind)
+		}
+		if s.kind != "histogram" {
+			fmt.Fprintf(&b, "%s%s %s\n", s.name, braces(s.labels), formatFloat(s.value))
+			continue
+		}
+		for i, le := range p.buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", s.name, braces(join(s.labels, "le="+quoteLabel(formatFloat(le)))), s.counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", s.name, braces(join(s.labels, `le="+Inf"`)), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", s.name, braces(s.labels), formatFloat(s.value))
+		fmt.Fprintf(&b, "%s_count%s %d\n", s.name, braces(s.labels), s.count)
+	}
+	p.mu.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Serve the metrics to a scrape
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// Escapes of label values, the only ones the text format allows
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Quote a label value, leaving any other character, such as non-ASCII
+// letters, as it is
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func join(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Serve Prometheus metrics at /metrics on addr in the background
+func serveMetrics(addr string) *PrometheusMetrics {
+	metrics := NewPrometheusMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			out.info("Metrics server stopped:", err)
+		}
+	}()
+	return metrics
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// proxy package routes HTTP requests to the backend of the node their key
+// maps to, so requests with the same key, such as those of one client or
+// one session, reach the same backend.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrNoKey is passed to the error handler of requests without a key
+var ErrNoKey = errors.New("request has no routing key")
+
+// Router maps a key to the URL of a backend, usually through the node the
+// load balancer maps the key to
+type Router interface {
+	Route(key string) (*url.URL, error)
+}
+
+// RouterFunc adapts a function to a Router
+type RouterFunc func(key string) (*url.URL, error)
+
+func (f RouterFunc) Route(key string) (*url.URL, error) {
+	return f(key)
+}
+
+// KeyFunc extracts the routing key of a request, false if it has none
+type KeyFunc func(r *http.Request) (string, bool)
+
+// HeaderKey takes the key from a request header
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		key := r.Header.Get(name)
+		return key, key != ""
+	}
+}
+
+// CookieKey takes the key from a cookie
+func CookieKey(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		c, err := r.Cookie(name)
+		if err != nil || c.Value == "" {
+			return "", false
+		}
+		return c.Value, true
+	}
+}
+
+// RemoteAddrKey takes the client IP address as the key, without the port
+// so all connections of a client share it
+func RemoteAddrKey() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return host, host != ""
+	}
+}
+
+// FirstKey takes the key from the first function finding one, e.g. a
+// session cookie falling back to the client address
+func FirstKey(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, key := range keys {
+			if k, ok := key(r); ok {
+				return k, true
+			}
+		}
+		return "", false
+	}
+}
+
+// ParseKey parses a key source: header:<name>, cookie:<name> or remote,
+// several separated by commas to fall back from one to the next
+func ParseKey(s string) (KeyFunc, error) {
+	var keys []KeyFunc
+	for _, part := range strings.Split(s, ",") {
+		kind, name, _ := strings.Cut(strings.TrimSpace(part), ":")
+		switch {
+		case kind == "header" && name != "":
+			keys = append(keys, HeaderKey(name))
+		case kind == "cookie" && name != "":
+			keys = append(keys, CookieKey(name))
+		case kind == "remote" && name == "":
+			keys = append(keys, RemoteAddrKey())
+		default:
+			return nil, fmt.Errorf("invalid key source %q, expected header:<name>, cookie:<name> or remote", part)
+		}
+	}
+	return FirstKey(keys...), nil
+}
+
+// Handler proxies each request to the backend its key routes to, reusing
+// a reverse proxy per backend
+type Handler struct {
+	router Router
+	key    KeyFunc
+
+	// Writes the response to requests that cannot be proxied: without a
+	// key, without a backend or whose backend failed
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+	// Transport of the requests to the backends, http.DefaultTransport if
+	// nil
+	Transport http.RoundTripper
+
+	// Bounds the requests proxied to each backend at once, set before
+	// serving
+	Queue QueueConfig
+
+	mu      sync.Mutex
+	proxies map[string]*httputil.ReverseProxy
+	queues  map[string]*queue
+}
+
+// NewHandler creates a handler routing requests by the key from key
+func NewHandler(router Router, key KeyFunc) *Handler {
+	return &Handler{router: router, key: key, proxies: make(map[string]*httputil.ReverseProxy),
+		queues: make(map[string]*queue)}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.key(r)
+	if !ok {
+		h.fail(w, r, ErrNoKey)
+		return
+	}
+	backend, err := h.router.Route(key)
+	if err != nil {
+		h.fail(w, r, fmt.Errorf("routing key %q: %w", key, err))
+		return
+	}
+	if h.Queue.MaxConcurrent > 0 {
+		h.serveQueued(w, r, key, backend)
+		return
+	}
+	h.proxy(backend).ServeHTTP(w, r)
+}
+
+// Reverse proxy of a backend
+func (h *Handler) proxy(backend *url.URL) *httputil.ReverseProxy {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.proxies[backend.String()]
+	if !ok {
+		p = httputil.NewSingleHostReverseProxy(backend)
+		p.Transport = h.Transport
+		p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			h.fail(w, r, &BackendError{Backend: backend, Err: err})
+		}
+		h.proxies[backend.String()] = p
+	}
+	return p
+}
+
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if h.ErrorHandler != nil {
+		h.ErrorHandler(w, r, err)
+		return
+	}
+	var backendErr *BackendError
+	switch {
+	case errors.Is(err, ErrNoKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.As(err, &backendErr):
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	default:
+		http.Error(w, "no backend available", http.StatusServiceUnavailable)
+	}
+}
+
+// BackendError is passed to the error handler when a backend fails
+type BackendError struct {
+	Backend *url.URL
+	Err     error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("backend %s: %v", e.Backend.Redacted(), e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
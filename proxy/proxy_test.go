@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Backend answering with its name
+func backend(t *testing.T, name string) *url.URL {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.Path)
+	}))
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return u
+}
+
+func get(t *testing.T, h http.Handler, req *http.Request) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestHandler(t *testing.T) {
+	a, b := backend(t, "a"), backend(t, "b")
+	errNoNodes := errors.New("no nodes")
+	h := NewHandler(RouterFunc(func(key string) (*url.URL, error) {
+		switch key {
+		case "alice":
+			return a, nil
+		case "bob":
+			return b, nil
+		}
+		return nil, errNoNodes
+	}), HeaderKey("X-User"))
+
+	req := httptest.NewRequest("GET", "/path", nil)
+	req.Header.Set("X-User", "alice")
+	if code, body := get(t, h, req); code != http.StatusOK || body != "a /path" {
+		t.Fatalf("expected backend a, got %d %q", code, body)
+	}
+	req.Header.Set("X-User", "bob")
+	if code, body := get(t, h, req); code != http.StatusOK || body != "b /path" {
+		t.Fatalf("expected backend b, got %d %q", code, body)
+	}
+
+	req.Header.Set("X-User", "carol")
+	if code, _ := get(t, h, req); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a backend, got %d", code)
+	}
+	req.Header.Del("X-User")
+	if code, _ := get(t, h, req); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a key, got %d", code)
+	}
+
+	var got error
+	h.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		got = err
+		w.WriteHeader(http.StatusTeapot)
+	}
+	req.Header.Set("X-User", "carol")
+	if code, _ := get(t, h, req); code != http.StatusTeapot || !errors.Is(got, errNoNodes) {
+		t.Fatalf("expected the error handler called with the routing error, got %d %v", code, got)
+	}
+}
+
+func TestBackendDown(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	down, _ := url.Parse(s.URL)
+	s.Close()
+	h := NewHandler(RouterFunc(func(string) (*url.URL, error) { return down, nil }), RemoteAddrKey())
+
+	if code, _ := get(t, h, httptest.NewRequest("GET", "/", nil)); code != http.StatusBadGateway {
+		t.Fatalf("expected 502 from a backend that is down, got %d", code)
+	}
+	var got error
+	h.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) { got = err }
+	get(t, h, httptest.NewRequest("GET", "/", nil))
+	var backendErr *BackendError
+	if !errors.As(got, &backendErr) || backendErr.Backend != down {
+		t.Fatalf("expected a BackendError for %v, got %v", down, got)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("cookie:session, header:X-User, remote")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if k, ok := key(req); !ok || k != "192.0.2.1" {
+		t.Fatalf("expected the client address, got %q %v", k, ok)
+	}
+	req.Header.Set("X-User", "alice")
+	if k, _ := key(req); k != "alice" {
+		t.Fatalf("expected the header, got %q", k)
+	}
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	if k, _ := key(req); k != "s1" {
+		t.Fatalf("expected the cookie, got %q", k)
+	}
+
+	for _, bad := range []string{"", "header", "cookie:", "remote:x", "query:q"} {
+		if _, err := ParseKey(bad); err == nil {
+			t.Fatalf("expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is passed to the error handler of requests whose
+	// backend has as many requests waiting as it queues
+	ErrQueueFull = errors.New("backend queue full")
+
+	// ErrQueueTimeout is passed to the error handler of requests that
+	// waited for their backend for longer than allowed
+	ErrQueueTimeout = errors.New("timed out waiting for the backend")
+)
+
+// OverflowPolicy decides what happens to a request whose backend queue is
+// full
+type OverflowPolicy int
+
+const (
+	// Fail the request with 503 Service Unavailable
+	Shed OverflowPolicy = iota
+
+	// Send the request to the next candidate of its key, see
+	// CandidateRouter
+	Spill
+)
+
+// ParseOverflow parses an overflow policy, shed or spill
+func ParseOverflow(s string) (OverflowPolicy, error) {
+	switch s {
+	case "shed":
+		return Shed, nil
+	case "spill":
+		return Spill, nil
+	}
+	return 0, fmt.Errorf("invalid overflow policy %q, expected shed or spill", s)
+}
+
+// DefaultSpillCandidates is the number of backends a request may be tried
+// on when spilling, if QueueConfig.Candidates is not set
+const DefaultSpillCandidates = 3
+
+// CandidateRouter is a Router that also lists the backends of a key in
+// order of preference, the one Route returns first, for requests to spill
+// to when their backend is full
+type CandidateRouter interface {
+	Router
+	Candidates(key string, n int) ([]*url.URL, error)
+}
+
+// QueueMetrics receives the activity of the backend queues
+type QueueMetrics interface {
+	// Requests waiting for and proxied to a backend, after each change
+	Queued(backend string, waiting, inFlight int)
+
+	// A request found the queue of a backend full and was shed or spilled
+	Overflowed(backend string, spilled bool)
+
+	// A request gave up waiting for a backend
+	TimedOut(backend string)
+
+	// A request waited for a backend for the given time before it was
+	// proxied
+	Waited(backend string, wait time.Duration)
+}
+
+// QueueConfig bounds the requests proxied to each backend at once, making
+// the others wait their turn in a queue of bounded depth. Queuing is off
+// if MaxConcurrent is 0.
+type QueueConfig struct {
+	// Requests proxied to a backend at once
+	MaxConcurrent int
+
+	// Requests waiting for a backend beyond which the queue is full
+	MaxDepth int
+
+	// Time a request waits for its backend before it fails with 503, no
+	// limit but the request's own if 0
+	MaxWait time.Duration
+
+	Overflow OverflowPolicy
+
+	// Backends a spilling request may be tried on, the first included,
+	// DefaultSpillCandidates if 0
+	Candidates int
+
+	// Receives the activity of the queues, if set
+	Metrics QueueMetrics
+}
+
+// QueueStats reports the state of the queue of a backend
+type QueueStats struct {
+	// Requests proxied and waiting now
+	InFlight int
+	Waiting  int
+
+	// Requests that found the queue full and failed or spilled to another
+	// backend
+	Shed    uint64
+	Spilled uint64
+
+	// Requests that gave up waiting
+	TimedOut uint64
+}
+
+// Queue of the requests to a backend
+type queue struct {
+	backend string
+
+	// Holds a token per request being proxied
+	slots chan struct{}
+
+	mu    sync.Mutex
+	stats QueueStats
+}
+
+// Take a turn to proxy a request to the backend, waiting if all turns are
+// taken
+func (q *queue) acquire(ctx context.Context, config *QueueConfig) error {
+	select {
+	case q.slots <- struct{}{}:
+		q.report(config, func(s *QueueStats) { s.InFlight++ })
+		return nil
+	default:
+	}
+
+	full := false
+	q.report(config, func(s *QueueStats) {
+		if full = s.Waiting >= config.MaxDepth; !full {
+			s.Waiting++
+		}
+	})
+	if full {
+		return ErrQueueFull
+	}
+
+	var timeout <-chan time.Time
+	if config.MaxWait > 0 {
+		timer := time.NewTimer(config.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	start := time.Now()
+	select {
+	case q.slots <- struct{}{}:
+		q.report(config, func(s *QueueStats) { s.Waiting--; s.InFlight++ })
+		if config.Metrics != nil {
+			config.Metrics.Waited(q.backend, time.Since(start))
+		}
+		return nil
+	case <-ctx.Done():
+		q.report(config, func(s *QueueStats) { s.Waiting-- })
+		return ctx.Err()
+	case <-timeout:
+		q.report(config, func(s *QueueStats) { s.Waiting--; s.TimedOut++ })
+		if config.Metrics != nil {
+			config.Metrics.TimedOut(q.backend)
+		}
+		return ErrQueueTimeout
+	}
+}
+
+// Give up the turn of a request that is done
+func (q *queue) release(config *QueueConfig) {
+	<-q.slots
+	q.report(config, func(s *QueueStats) { s.InFlight-- })
+}
+
+// Count a request that found the queue full
+func (q *queue) overflow(config *QueueConfig, spilled bool) {
+	q.mu.Lock()
+	if spilled {
+		q.stats.Spilled++
+	} else {
+		q.stats.Shed++
+	}
+	q.mu.Unlock()
+	if config.Metrics != nil {
+		config.Metrics.Overflowed(q.backend, spilled)
+	}
+}
+
+// Change the stats of the queue and report its depth
+func (q *queue) report(config *QueueConfig, change func(s *QueueStats)) {
+	q.mu.Lock()
+	change(&q.stats)
+	waiting, inFlight := q.stats.Waiting, q.stats.InFlight
+	q.mu.Unlock()
+	if config.Metrics != nil {
+		config.Metrics.Queued(q.backend, waiting, inFlight)
+	}
+}
+
+// Queue of a backend
+func (h *Handler) queue(backend *url.URL) *queue {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	q, ok := h.queues[backend.String()]
+	if !ok {
+		q = &queue{backend: backend.Redacted(), slots: make(chan struct{}, h.Queue.MaxConcurrent)}
+		h.queues[backend.String()] = q
+	}
+	return q
+}
+
+// QueueStats reports the queue of each backend requests were proxied to,
+// keyed by backend URL
+func (h *Handler) QueueStats() map[string]QueueStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := make(map[string]QueueStats, len(h.queues))
+	for backend, q := range h.queues {
+		q.mu.Lock()
+		stats[backend] = q.stats
+		q.mu.Unlock()
+	}
+	return stats
+}
+
+// Proxy a request once its backend has a turn for it, spilling it to the
+// next candidates of its key while their queues are full if configured
+func (h *Handler) serveQueued(w http.ResponseWriter, r *http.Request, key string, backend *url.URL) {
+	config := &h.Queue
+	backends := []*url.URL{backend}
+	for i := 0; i < len(backends); i++ {
+		q := h.queue(backends[i])
+		err := q.acquire(r.Context(), config)
+		if errors.Is(err, ErrQueueFull) && config.Overflow == Spill {
+			if i == 0 {
+				backends = append(backends, h.spillTo(key, backend)...)
+			}
+			if i+1 < len(backends) {
+				q.overflow(config, true)
+				continue
+			}
+		}
+		if err != nil {
+			if errors.Is(err, ErrQueueFull) {
+				q.overflow(config, false)
+			}
+			h.fail(w, r, fmt.Errorf("backend %s: %w", backends[i].Redacted(), err))
+			return
+		}
+		defer q.release(config)
+		h.proxy(backends[i]).ServeHTTP(w, r)
+		return
+	}
+}
+
+// Backends after the first a request of key may spill to
+func (h *Handler) spillTo(key string, first *url.URL) []*url.URL {
+	router, ok := h.router.(CandidateRouter)
+	if !ok {
+		return nil
+	}
+	n := h.Queue.Candidates
+	if n <= 0 {
+		n = DefaultSpillCandidates
+	}
+	candidates, err := router.Candidates(key, n)
+	if err != nil {
+		return nil
+	}
+	var rest []*url.URL
+	for _, c := range candidates {
+		if c.String() != first.String() {
+			rest = append(rest, c)
+		}
+	}
+	return rest
+}
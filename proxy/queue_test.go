@@ -0,0 +1,145 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Backend holding each request until release is closed
+func blocking(t *testing.T, name string, started chan<- string, release <-chan struct{}) *url.URL {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- name
+		<-release
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(s.Close)
+	u, _ := url.Parse(s.URL)
+	return u
+}
+
+type candidates []*url.URL
+
+func (c candidates) Route(string) (*url.URL, error) { return c[0], nil }
+
+func (c candidates) Candidates(_ string, n int) ([]*url.URL, error) { return c[:min(n, len(c))], nil }
+
+// Serve a request in the background
+func serve(h http.Handler) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Key", "k")
+		h.ServeHTTP(rec, req)
+		done <- rec
+	}()
+	return done
+}
+
+// Wait until a request is waiting in the queue of a backend
+func waitQueued(t *testing.T, h *Handler, backend *url.URL) {
+	t.Helper()
+	for range 500 {
+		if h.QueueStats()[backend.String()].Waiting > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected a request waiting for %v", backend)
+}
+
+func TestQueueShed(t *testing.T) {
+	started, release := make(chan string, 4), make(chan struct{})
+	a := blocking(t, "a", started, release)
+	h := NewHandler(candidates{a}, HeaderKey("X-Key"))
+	var errs []error
+	h.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		errs = append(errs, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	h.Queue = QueueConfig{MaxConcurrent: 1, MaxDepth: 1}
+
+	first := serve(h)
+	<-started
+	second := serve(h)
+	waitQueued(t, h, a)
+	if rec := <-serve(h); rec.Code != http.StatusServiceUnavailable || !errors.Is(errs[0], ErrQueueFull) {
+		t.Fatalf("expected a full queue to shed, got %d %v", rec.Code, errs)
+	}
+	if stats := h.QueueStats()[a.String()]; stats.InFlight != 1 || stats.Waiting != 1 || stats.Shed != 1 {
+		t.Fatalf("expected 1 in flight, 1 waiting and 1 shed, got %+v", stats)
+	}
+
+	close(release)
+	for _, done := range []<-chan *httptest.ResponseRecorder{first, second} {
+		if rec := <-done; rec.Code != http.StatusOK || rec.Body.String() != "a" {
+			t.Fatalf("expected the queued requests served, got %d %q", rec.Code, rec.Body)
+		}
+	}
+	if stats := h.QueueStats()[a.String()]; stats.InFlight != 0 || stats.Waiting != 0 {
+		t.Fatalf("expected an empty queue, got %+v", stats)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	started, release := make(chan string, 4), make(chan struct{})
+	defer close(release)
+	a := blocking(t, "a", started, release)
+	h := NewHandler(candidates{a}, HeaderKey("X-Key"))
+	h.Queue = QueueConfig{MaxConcurrent: 1, MaxDepth: 1, MaxWait: 10 * time.Millisecond}
+
+	serve(h)
+	<-started
+	if rec := <-serve(h); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 once the wait is over, got %d", rec.Code)
+	}
+	if stats := h.QueueStats()[a.String()]; stats.TimedOut != 1 || stats.Waiting != 0 {
+		t.Fatalf("expected 1 timed out, got %+v", stats)
+	}
+}
+
+func TestQueueSpill(t *testing.T) {
+	started, release := make(chan string, 4), make(chan struct{})
+	a := blocking(t, "a", started, release)
+	b := blocking(t, "b", started, release)
+	h := NewHandler(candidates{a, b}, HeaderKey("X-Key"))
+	h.Queue = QueueConfig{MaxConcurrent: 1, Overflow: Spill}
+
+	first := serve(h)
+	if name := <-started; name != "a" {
+		t.Fatalf("expected the first request on a, got %s", name)
+	}
+	second := serve(h)
+	if name := <-started; name != "b" {
+		t.Fatalf("expected the second request to spill to b, got %s", name)
+	}
+
+	// Every candidate is full
+	if rec := <-serve(h); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 with every candidate full, got %d", rec.Code)
+	}
+	close(release)
+	<-first
+	<-second
+	stats := h.QueueStats()
+	if stats[a.String()].Spilled != 2 || stats[b.String()].Shed != 1 {
+		t.Fatalf("expected 2 spilled from a and 1 shed by b, got %+v", stats)
+	}
+
+	if _, err := ParseOverflow("spill"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := ParseOverflow("drop"); err == nil {
+		t.Fatalf("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,3 @@
+module proxyconf
+
+go 1.23.0
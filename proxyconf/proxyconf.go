@@ -0,0 +1,123 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// proxyconf package renders the node set as HAProxy or nginx upstream
+// configuration using the proxies' own consistent hashing.
+package proxyconf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"text/template"
+)
+
+// Format of the generated configuration
+type Format string
+
+const (
+	HAProxy Format = "haproxy"
+	Nginx   Format = "nginx"
+)
+
+// Server of an upstream
+type Server struct {
+	Name    string
+	Address string
+	Port    uint32
+
+	// Relative weight, at least 1
+	Weight uint32
+}
+
+// Upstream is a named group of servers requests are balanced across
+type Upstream struct {
+	Name    string
+	Servers []Server
+
+	// Request property hashed to pick a server. For nginx this is a
+	// variable such as $request_uri, for HAProxy a balance algorithm
+	// argument such as "uri" or "hdr(host)". Defaults to the request URI.
+	HashKey string
+}
+
+var templates = map[Format]*template.Template{
+	HAProxy: template.Must(template.New("haproxy").Funcs(funcs).Parse(`backend {{.Name}}
+    balance {{or .HashKey "uri"}}
+    hash-type consistent
+{{- range .Servers}}
+    server {{.Name}} {{hostport .Address .Port}} weight {{weight .Weight}} check
+{{- end}}
+`)),
+	Nginx: template.Must(template.New("nginx").Funcs(funcs).Parse(`upstream {{.Name}} {
+    hash {{or .HashKey "$request_uri"}} consistent;
+{{- range .Servers}}
+    server {{hostport .Address .Port}} weight={{weight .Weight}};
+{{- end}}
+}
+`)),
+}
+
+var funcs = template.FuncMap{
+	"weight": func(w uint32) uint32 { return max(w, 1) },
+
+	// IPv6 addresses are bracketed so the port stays apart
+	"hostport": func(addr string, port uint32) string {
+		return net.JoinHostPort(addr, strconv.FormatUint(uint64(port), 10))
+	},
+}
+
+// Render writes the configuration of the upstream in the given format
+func Render(w io.Writer, format Format, u Upstream) error {
+	t, ok := templates[format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return t.Execute(w, u)
+}
+
+// WriteFile renders the configuration to path and, if the file changed and
+// reload is not empty, runs the reload command, e.g. nginx -s reload. The
+// file is replaced atomically so the proxy never reads a partial file.
+// It returns whether the file changed.
+func WriteFile(path string, format Format, u Upstream, reload []string) (bool, error) {
+	var buf bytes.Buffer
+	if err := Render(&buf, format, u); err != nil {
+		return false, err
+	}
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, buf.Bytes()) {
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+
+	if len(reload) > 0 {
+		if output, err := exec.Command(reload[0], reload[1:]...).CombinedOutput(); err != nil {
+			return true, fmt.Errorf("reload failed: %w: %s", err, bytes.TrimSpace(output))
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package proxyconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var upstream = Upstream{Name: "backend", Servers: []Server{
+	{Name: "node1", Address: "10.0.0.1", Port: 8080, Weight: 2},
+	{Name: "node2", Address: "10.0.0.2", Port: 8080},
+	{Name: "node3", Address: "2001:db8::1", Port: 80},
+}}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{format: HAProxy, want: `backend backend
+    balance uri
+    hash-type consistent
+    server node1 10.0.0.1:8080 weight 2 check
+    server node2 10.0.0.2:8080 weight 1 check
+    server node3 [2001:db8::1]:80 weight 1 check
+`},
+		{format: Nginx, want: `upstream backend {
+    hash $request_uri consistent;
+    server 10.0.0.1:8080 weight=2;
+    server 10.0.0.2:8080 weight=1;
+    server [2001:db8::1]:80 weight=1;
+}
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var b strings.Builder
+			if err := Render(&b, tt.format, upstream); err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("Render() =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+
+	if err := Render(&strings.Builder{}, "envoy", upstream); err == nil {
+		t.Errorf("Render() expected error for unknown format")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "upstream.conf")
+	marker := filepath.Join(dir, "reloaded")
+	reload := []string{"touch", marker}
+
+	changed, err := WriteFile(path, Nginx, upstream, reload)
+	if err != nil || !changed {
+		t.Fatalf("WriteFile() = %v, %v, want true, nil", changed, err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("expected reload to run: %v", err)
+	}
+
+	// Unchanged configuration does not reload
+	os.Remove(marker)
+	changed, err = WriteFile(path, Nginx, upstream, reload)
+	if err != nil || changed {
+		t.Fatalf("WriteFile() = %v, %v, want false, nil", changed, err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatalf("expected no reload for unchanged configuration")
+	}
+}
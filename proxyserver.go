@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Reverse proxy routing HTTP requests to the node of their key
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"proxy"
+	"serverpool"
+	"strconv"
+	"time"
+)
+
+// Names of the metrics of the proxy queues, labelled by backend
+const (
+	// Gauges of the requests waiting for and proxied to a backend
+	MetricProxyQueueWaiting  = "loadbalance_proxy_queue_waiting"
+	MetricProxyQueueInFlight = "loadbalance_proxy_queue_in_flight"
+
+	// Counter of requests finding a full queue by the action label, shed
+	// or spill
+	MetricProxyQueueOverflows = "loadbalance_proxy_queue_overflows_total"
+
+	// Counter of requests giving up waiting
+	MetricProxyQueueTimeouts = "loadbalance_proxy_queue_timeouts_total"
+
+	// Histogram of the time requests waited in seconds
+	MetricProxyQueueWaitSeconds = "loadbalance_proxy_queue_wait_seconds"
+)
+
+// Routes keys to the backend of their node. Requests are routed while
+// commands hold the lock waiting for input, so lb must be safe for
+// concurrent use rather than share the lock of the commands.
+type proxyRouter struct {
+	lb   LoadBalancer[netip.Addr, int]
+	port uint16
+}
+
+func (r *proxyRouter) Route(key string) (*url.URL, error) {
+	node, err := r.lb.GetNode(key)
+	if err != nil {
+		return nil, err
+	}
+	return r.backend(node), nil
+}
+
+// Candidates returns the backends of the replicas of a key, for requests
+// to spill to
+func (r *proxyRouter) Candidates(key string, n int) ([]*url.URL, error) {
+	nodes, err := r.lb.GetNodes(key, n)
+	if err != nil {
+		return nil, err
+	}
+	backends := make([]*url.URL, len(nodes))
+	for i, node := range nodes {
+		backends[i] = r.backend(node)
+	}
+	return backends, nil
+}
+
+// Backend URL of a node, or its address on the default port if it has
+// none
+func (r *proxyRouter) backend(node serverpool.Node[netip.Addr, int]) *url.URL {
+	if b, ok := node.(interface{ Backend() *url.URL }); ok && b.Backend() != nil {
+		return b.Backend()
+	}
+	host := net.JoinHostPort(node.Name().String(), strconv.Itoa(int(r.port)))
+	return &url.URL{Scheme: "http", Host: host}
+}
+
+// Reports the activity of the proxy queues to Metrics
+type proxyQueueMetrics struct {
+	m Metrics
+}
+
+func (p proxyQueueMetrics) Queued(backend string, waiting, inFlight int) {
+	p.m.Gauge(MetricProxyQueueWaiting, "backend", backend).Set(float64(waiting))
+	p.m.Gauge(MetricProxyQueueInFlight, "backend", backend).Set(float64(inFlight))
+}
+
+func (p proxyQueueMetrics) Overflowed(backend string, spilled bool) {
+	action := "shed"
+	if spilled {
+		action = "spill"
+	}
+	p.m.Counter(MetricProxyQueueOverflows, "backend", backend, "action", action).Add(1)
+}
+
+func (p proxyQueueMetrics) TimedOut(backend string) {
+	p.m.Counter(MetricProxyQueueTimeouts, "backend", backend).Add(1)
+}
+
+func (p proxyQueueMetrics) Waited(backend string, wait time.Duration) {
+	p.m.Histogram(MetricProxyQueueWaitSeconds, "backend", backend).Observe(wait.Seconds())
+}
+
+// Proxy requests on addr to the nodes of lb, which must be safe for
+// concurrent use, in the background, routed by the key key takes from them
+// and queued as queue says
+func serveProxy(addr string, lb LoadBalancer[netip.Addr, int], key proxy.KeyFunc, port uint16, queue proxy.QueueConfig) {
+	handler := proxy.NewHandler(&proxyRouter{lb: lb, port: port}, key)
+	handler.Queue = queue
+	go func() {
+		if err := http.ListenAndServe(addr, handler); err != nil {
+			out.info("Reverse proxy stopped:", err)
+		}
+	}()
+}
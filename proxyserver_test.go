@@ -0,0 +1,54 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net/netip"
+	"net/url"
+	"serverpool"
+	"testing"
+)
+
+func TestProxyRouter(t *testing.T) {
+	lb := NewConcurrentLoadBalancer[netip.Addr, int]()
+	r := &proxyRouter{lb: lb, port: 8080}
+	if _, err := r.Route("key"); err == nil {
+		t.Fatalf("expected an error without nodes")
+	}
+
+	node := NewServerNode[int](netip.MustParseAddr("10.0.0.1"))
+	if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	u, err := r.Route("key")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if u.String() != "http://10.0.0.1:8080" {
+		t.Fatalf("expected the node address, got %v", u)
+	}
+
+	backend := &url.URL{Scheme: "https", Host: "backend.example:443"}
+	node2 := NewBackendServerNode[int](netip.MustParseAddr("10.0.0.2"), backend)
+	if _, err := lb.RemoveNodes([]serverpool.Node[netip.Addr, int]{&node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if u, _ := r.Route("key"); u != backend {
+		t.Fatalf("expected the backend URL, got %v", u)
+	}
+
+	// Requests spill to the replicas of their key
+	if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	first, _ := r.Route("key")
+	candidates, err := r.Candidates("key", 3)
+	if err != nil || len(candidates) != 2 || candidates[0].String() != first.String() {
+		t.Fatalf("expected both nodes starting with %v, got %v, %v", first, candidates, err)
+	}
+}
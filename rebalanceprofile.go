@@ -0,0 +1,181 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Per-phase timing of large rebalances, kept for diagnosing slow migrations
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"serverpool"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Phases of a rebalance profile. Hashing nests within the other phases,
+// whose time excludes it.
+const (
+	// Choosing the objects that move and admitting them to the budget
+	PhasePlanning = "planning"
+
+	// Mapping objects to their nodes
+	PhaseHashing = "hashing"
+
+	// Notifying subscriptions, webhooks and cooperative rebalance callbacks
+	PhaseCallbacks = "callbacks"
+
+	// Waiting for the application to take the objects, the prefetch hook
+	// and its lead time
+	PhaseAcks = "acks"
+)
+
+// DefaultRebalanceProfiles is the number of rebalance profiles kept
+const DefaultRebalanceProfiles = 16
+
+// RebalanceProfile breaks down the time an operation spent rebalancing by
+// phase
+type RebalanceProfile struct {
+	// Operation that rebalanced, such as RemoveNodes or Rebalance
+	Op    string
+	Start time.Time
+	Total time.Duration
+
+	// Objects planned to move
+	Moves int
+
+	// Time spent in each phase, excluding the phases nested within it
+	Phases map[string]time.Duration
+
+	// Time spent in each stack of nested phases, the operation first,
+	// separated by semicolons. The operation alone holds the time spent
+	// outside any phase.
+	Stacks map[string]time.Duration
+}
+
+// WriteFolded writes the stacks of the profile in the folded format of
+// flame graph tools, one stack per line with its time in microseconds
+func (p RebalanceProfile) WriteFolded(w io.Writer) error {
+	for _, stack := range slices.Sorted(maps.Keys(p.Stacks)) {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, p.Stacks[stack].Microseconds()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Profile of the rebalance in progress
+type profileRecorder struct {
+	profile RebalanceProfile
+
+	// Phases entered, innermost last, and when the time of the innermost
+	// was last counted
+	stack []string
+	mark  time.Time
+}
+
+// Count the time since the mark to the current stack
+func (r *profileRecorder) flush() {
+	now := time.Now()
+	r.profile.Stacks[strings.Join(r.stack, ";")] += now.Sub(r.mark)
+	r.mark = now
+}
+
+// Profiles of the rebalances of a load balancer
+type rebalanceProfiles struct {
+	// Profiling is off if 0, otherwise the moves from which a rebalance is
+	// kept
+	minMoves int
+
+	// Last profiles kept, oldest first
+	profiles []RebalanceProfile
+
+	// Rebalance in progress, nil if none
+	current *profileRecorder
+}
+
+// Run fn, an operation that may rebalance, profiling its phases. An
+// operation run by another is part of its profile.
+func (p *rebalanceProfiles) record(op string, start time.Time, fn func()) {
+	if p.minMoves == 0 || p.current != nil {
+		fn()
+		return
+	}
+	r := &profileRecorder{stack: []string{op}, mark: time.Now(),
+		profile: RebalanceProfile{Op: op, Start: start, Stacks: make(map[string]time.Duration)}}
+	p.current = r
+	defer func(began time.Time) {
+		r.flush()
+		p.current = nil
+		if r.profile.Moves < p.minMoves {
+			return
+		}
+		r.profile.Total = time.Since(began)
+		r.profile.Phases = make(map[string]time.Duration)
+		for stack, d := range r.profile.Stacks {
+			phases := strings.Split(stack, ";")
+			if len(phases) > 1 {
+				r.profile.Phases[phases[len(phases)-1]] += d
+			}
+		}
+		if len(p.profiles) == DefaultRebalanceProfiles {
+			p.profiles = slices.Delete(p.profiles, 0, 1)
+		}
+		p.profiles = append(p.profiles, r.profile)
+	}(r.mark)
+	fn()
+}
+
+// Enter a phase of the rebalance in progress, returning the function that
+// leaves it
+func (p *rebalanceProfiles) enter(phase string) func() {
+	r := p.current
+	if r == nil {
+		return func() {}
+	}
+	r.flush()
+	r.stack = append(r.stack, phase)
+	return func() {
+		r.flush()
+		r.stack = r.stack[:len(r.stack)-1]
+	}
+}
+
+// Count moves planned by the rebalance in progress
+func (p *rebalanceProfiles) planned(moves int) {
+	if p.current != nil {
+		p.current.profile.Moves += moves
+	}
+}
+
+// Run fn, an operation that may rebalance, labeled for the profiler and
+// with its phases profiled
+func (lb *loadBalancer[T, O]) profileRebalance(op string, fn func()) {
+	lb.profiler.do(op, func() { lb.rebalances.record(op, lb.churn.clock(), fn) })
+}
+
+// Send prefetch hints, counting the time the application takes them to the
+// acks, and split the moves into those ready to be cut over and those held
+// for the lead time
+func (lb *loadBalancer[T, O]) prefetch(moves []Move[T, O]) (ready, held []Move[T, O]) {
+	defer lb.rebalances.enter(PhaseAcks)()
+	return lb.prefetcher.prefetch(moves, lb.churn.clock())
+}
+
+// Call a callback of the cooperative rebalance, if set
+func (lb *loadBalancer[T, O]) callCooperative(callback func(serverpool.Node[T, O], []*serverpool.Object[T, O]), node serverpool.Node[T, O], moves []Move[T, O]) {
+	if callback == nil {
+		return
+	}
+	defer lb.rebalances.enter(PhaseCallbacks)()
+	callback(node, moveObjects(moves))
+}
+
+// RebalanceProfiles returns the profiles of the last rebalances that moved
+// at least the objects given to WithRebalanceProfiling, oldest first
+func (lb *loadBalancer[T, O]) RebalanceProfiles() []RebalanceProfile {
+	return slices.Clone(lb.rebalances.profiles)
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"strings"
+	"testing"
+)
+
+func TestRebalanceProfiles(t *testing.T) {
+	hinted := func(serverpool.Node[string, string], []Move[string, string]) {}
+	lb := NewLoadBalancerWithOptions(WithRebalanceProfiling[string, string](5),
+		WithPrefetchHook(hinted, 0))
+	var nodes []serverpool.Node[string, string]
+	for i := 0; i < 3; i++ {
+		nodes = append(nodes, &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 60; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// Adding nodes moves nothing and is not kept
+	if profiles := lb.(StatsReporter[string, string]).RebalanceProfiles(); len(profiles) != 0 {
+		t.Fatalf("expected no profiles, got %v", profiles)
+	}
+
+	moved := 0
+	for range nodes[2].Objects() {
+		moved++
+	}
+	if _, err := lb.RemoveNodes(nodes[2:]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	profiles := lb.(StatsReporter[string, string]).RebalanceProfiles()
+	if len(profiles) != 1 {
+		t.Fatalf("expected 1 profile, got %d", len(profiles))
+	}
+	p := profiles[0]
+	if p.Op != "RemoveNodes" || p.Moves != moved {
+		t.Fatalf("expected RemoveNodes moving %d objects, got %s moving %d", moved, p.Op, p.Moves)
+	}
+	for _, phase := range []string{PhasePlanning, PhaseHashing, PhaseCallbacks, PhaseAcks} {
+		if _, ok := p.Phases[phase]; !ok {
+			t.Fatalf("expected time in phase %s, got %v", phase, p.Phases)
+		}
+	}
+	if p.Total < p.Phases[PhaseAcks] {
+		t.Fatalf("expected the acks within the total %v, got %v", p.Total, p.Phases[PhaseAcks])
+	}
+
+	var folded strings.Builder
+	if err := p.WriteFolded(&folded); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.Contains(folded.String(), "RemoveNodes;planning;hashing ") {
+		t.Fatalf("expected hashing nested in planning, got\n%s", folded.String())
+	}
+}
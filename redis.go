@@ -0,0 +1,411 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Shared state and change feed kept in Redis
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisError is an error reply from a Redis server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisClient speaks enough of the Redis protocol for the object store and
+// change feed, over a single connection dialed on first use and again
+// after a network error
+type RedisClient struct {
+	Addr string
+
+	// Password sent with AUTH after connecting, none if empty
+	Password string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *RedisClient) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	if c.Password != "" {
+		if _, err := redisRoundTrip(ctx, conn, r, "AUTH", c.Password); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, r, nil
+}
+
+// Do sends a command and returns its reply: a string for status replies,
+// []byte for bulk strings, int64, []any for arrays or nil. Error replies
+// are returned as RedisError.
+func (c *RedisClient) Do(ctx context.Context, args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		conn, r, err := c.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.r = conn, r
+	}
+	reply, err := redisRoundTrip(ctx, c.conn, c.r, args...)
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection may be out of step with the replies
+		c.conn.Close()
+		c.conn, c.r = nil, nil
+	}
+	return reply, err
+}
+
+// Close the connection, the next command dials again
+func (c *RedisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.r = nil, nil
+	return err
+}
+
+// Subscribe calls fn with each message published on the channel until the
+// context is done or the connection fails, on a connection of its own.
+// Messages published while not subscribed are not delivered.
+func (c *RedisClient) Subscribe(ctx context.Context, channel string, fn func(msg []byte)) error {
+	conn, r, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if err := writeRedisCommand(conn, "SUBSCRIBE", channel); err != nil {
+		return err
+	}
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if msg, ok := reply.([]any); ok && len(msg) == 3 && redisString(msg[0]) == "message" {
+			if payload, ok := msg[2].([]byte); ok {
+				fn(payload)
+			}
+		}
+	}
+}
+
+func redisRoundTrip(ctx context.Context, conn net.Conn, r *bufio.Reader, args ...string) (any, error) {
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := writeRedisCommand(conn, args...); err != nil {
+		return nil, err
+	}
+	return readRedisReply(r)
+}
+
+func writeRedisCommand(w io.Writer, args ...string) error {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// String of a status or bulk string reply
+func redisString(reply any) string {
+	switch v := reply.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// RedisSink publishes each record to a Redis channel. Pub/sub does not keep
+// messages, so only subscribers connected at the time receive a record.
+type RedisSink struct {
+	Client  *RedisClient
+	Channel string
+}
+
+func (s RedisSink) Write(ctx context.Context, records [][]byte) error {
+	for _, r := range records {
+		if _, err := s.Client.Do(ctx, "PUBLISH", s.Channel, string(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scripts checking the version of a record and writing it atomically. The
+// stored value is "<version>:<unix nanos>[:<node>]". Each write is
+// published as a JSON array of the id and new value, the id alone for a
+// delete. Both return -1 on success or else the stored version, 0 if
+// there is no record.
+const (
+	redisPutScript = `local cur = redis.call('HGET', KEYS[1], ARGV[1])
+local stored = 0
+if cur then stored = tonumber(string.match(cur, '^%d+')) end
+if stored ~= tonumber(ARGV[2]) then return stored end
+local value = (stored + 1) .. ':' .. ARGV[3]
+redis.call('HSET', KEYS[1], ARGV[1], value)
+redis.call('PUBLISH', ARGV[4], cjson.encode({ARGV[1], value}))
+return -1`
+
+	redisDeleteScript = `local cur = redis.call('HGET', KEYS[1], ARGV[1])
+local stored = 0
+if cur then stored = tonumber(string.match(cur, '^%d+')) end
+if stored == 0 or stored ~= tonumber(ARGV[2]) then return stored end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('PUBLISH', ARGV[3], cjson.encode({ARGV[1]}))
+return -1`
+)
+
+// RedisObjectStore keeps object records in a Redis hash, {prefix}:objects,
+// and publishes every write on the channel {prefix}:objects, so load
+// balancer instances can share ownership without etcd or a SQL database.
+// Versions are checked by scripts running atomically on the server. Ids and
+// node names are stored as text like in SQLObjectStore.
+type RedisObjectStore[T, O comparable] struct {
+	client  *RedisClient
+	key     string
+	channel string
+	clock   func() time.Time
+}
+
+// NewRedisObjectStore creates an object store in Redis under the given
+// prefix. The prefix is a hash tag of the key, so Redis Cluster keeps the
+// records in one slot.
+func NewRedisObjectStore[T, O comparable](client *RedisClient, prefix string) *RedisObjectStore[T, O] {
+	return &RedisObjectStore[T, O]{client: client, key: "{" + prefix + "}:objects",
+		channel: prefix + ":objects", clock: time.Now}
+}
+
+func (s *RedisObjectStore[T, O]) decode(id, value string) (ObjectRecord[T, O], error) {
+	var rec ObjectRecord[T, O]
+	var err error
+	if rec.ID, err = parseText[O](id); err != nil {
+		return rec, fmt.Errorf("object %q: %w", id, err)
+	}
+	fields := strings.SplitN(value, ":", 3)
+	if len(fields) < 2 {
+		return rec, fmt.Errorf("object %q: invalid record %q", id, value)
+	}
+	if rec.Version, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return rec, fmt.Errorf("object %q: %w", id, err)
+	}
+	nanos, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return rec, fmt.Errorf("object %q: %w", id, err)
+	}
+	rec.Updated = time.Unix(0, nanos)
+	if len(fields) == 3 {
+		node, err := parseText[T](fields[2])
+		if err != nil {
+			return rec, fmt.Errorf("object %q: node %q: %w", id, fields[2], err)
+		}
+		rec.Node = &node
+	}
+	return rec, nil
+}
+
+// Get the record of an object
+func (s *RedisObjectStore[T, O]) Get(ctx context.Context, id O) (ObjectRecord[T, O], error) {
+	key, err := formatText(id)
+	if err != nil {
+		return ObjectRecord[T, O]{}, err
+	}
+	reply, err := s.client.Do(ctx, "HGET", s.key, key)
+	if err != nil {
+		return ObjectRecord[T, O]{}, err
+	}
+	if reply == nil {
+		return ObjectRecord[T, O]{}, ErrObjectNotFound
+	}
+	return s.decode(key, redisString(reply))
+}
+
+// Iterate over all records with HSCAN, which may return a record changed
+// during the iteration twice
+func (s *RedisObjectStore[T, O]) Records(ctx context.Context) iter.Seq2[ObjectRecord[T, O], error] {
+	return func(yield func(ObjectRecord[T, O], error) bool) {
+		cursor := "0"
+		for {
+			reply, err := s.client.Do(ctx, "HSCAN", s.key, cursor, "COUNT", "1000")
+			if err != nil {
+				yield(ObjectRecord[T, O]{}, err)
+				return
+			}
+			page, ok := reply.([]any)
+			if !ok || len(page) != 2 {
+				yield(ObjectRecord[T, O]{}, fmt.Errorf("redis: unexpected HSCAN reply %v", reply))
+				return
+			}
+			fields, _ := page[1].([]any)
+			for i := 0; i+1 < len(fields); i += 2 {
+				rec, err := s.decode(redisString(fields[i]), redisString(fields[i+1]))
+				if !yield(rec, err) || err != nil {
+					return
+				}
+			}
+			if cursor = redisString(page[0]); cursor == "0" {
+				return
+			}
+		}
+	}
+}
+
+// Create or update a record, failing with ErrVersionConflict if the stored
+// record does not have the version of rec
+func (s *RedisObjectStore[T, O]) Put(ctx context.Context, rec ObjectRecord[T, O]) (ObjectRecord[T, O], error) {
+	key, err := formatText(rec.ID)
+	if err != nil {
+		return rec, err
+	}
+	now := s.clock()
+	value := strconv.FormatInt(now.UnixNano(), 10)
+	if rec.Node != nil {
+		node, err := formatText(*rec.Node)
+		if err != nil {
+			return rec, err
+		}
+		value += ":" + node
+	}
+	reply, err := s.client.Do(ctx, "EVAL", redisPutScript, "1", s.key,
+		key, strconv.FormatInt(rec.Version, 10), value, s.channel)
+	if err != nil {
+		return rec, err
+	}
+	if err := checkScriptReply(reply, rec.Version); err != nil {
+		return rec, err
+	}
+	rec.Version++
+	rec.Updated = time.Unix(0, now.UnixNano())
+	return rec, nil
+}
+
+// Delete a record if it still has the given version
+func (s *RedisObjectStore[T, O]) Delete(ctx context.Context, id O, version int64) error {
+	key, err := formatText(id)
+	if err != nil {
+		return err
+	}
+	reply, err := s.client.Do(ctx, "EVAL", redisDeleteScript, "1", s.key,
+		key, strconv.FormatInt(version, 10), s.channel)
+	if err != nil {
+		return err
+	}
+	if reply == int64(0) {
+		return ErrObjectNotFound
+	}
+	return checkScriptReply(reply, version)
+}
+
+func checkScriptReply(reply any, version int64) error {
+	stored, ok := reply.(int64)
+	switch {
+	case !ok:
+		return fmt.Errorf("redis: unexpected script reply %v", reply)
+	case stored == -1:
+		return nil
+	}
+	return checkVersion(stored, version, stored > 0)
+}
+
+// Watch calls fn with every record written by any instance until the
+// context is done or the subscription fails. Deleted records have version
+// 0. Writes made while not watching are missed, so a watcher that
+// reconnects should read the records again.
+func (s *RedisObjectStore[T, O]) Watch(ctx context.Context, fn func(ObjectRecord[T, O])) error {
+	return s.client.Subscribe(ctx, s.channel, func(msg []byte) {
+		var write []string
+		if json.Unmarshal(msg, &write) != nil || len(write) == 0 {
+			return
+		}
+		if len(write) == 1 {
+			if id, err := parseText[O](write[0]); err == nil {
+				fn(ObjectRecord[T, O]{ID: id})
+			}
+			return
+		}
+		if rec, err := s.decode(write[0], write[1]); err == nil {
+			fn(rec)
+		}
+	})
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is a Redis server understanding the commands and scripts of
+// RedisObjectStore and RedisSink
+type fakeRedis struct {
+	password string
+
+	mu     sync.Mutex
+	hashes map[string]map[string]string
+	subs   map[string][]chan []byte
+
+	// Receives the channel of each subscription
+	subscribed chan string
+}
+
+func newFakeRedis(t *testing.T, password string) (*fakeRedis, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{password: password, hashes: make(map[string]map[string]string),
+		subs: make(map[string][]chan []byte), subscribed: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func writeFakeRedisReply(w io.Writer, reply any) {
+	switch v := reply.(type) {
+	case nil:
+		io.WriteString(w, "$-1\r\n")
+	case string:
+		io.WriteString(w, "+"+v+"\r\n")
+	case RedisError:
+		io.WriteString(w, "-"+string(v)+"\r\n")
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeFakeRedisReply(w, item)
+		}
+	}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		cmd, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range cmd.([]any) {
+			args = append(args, redisString(arg))
+		}
+		switch {
+		case args[0] == "AUTH":
+			authed = args[1] == f.password
+			if !authed {
+				writeFakeRedisReply(conn, RedisError("WRONGPASS invalid password"))
+				continue
+			}
+			writeFakeRedisReply(conn, "OK")
+		case !authed:
+			writeFakeRedisReply(conn, RedisError("NOAUTH Authentication required."))
+		case args[0] == "SUBSCRIBE":
+			f.subscribe(conn, args[1])
+			return
+		default:
+			writeFakeRedisReply(conn, f.do(args))
+		}
+	}
+}
+
+func (f *fakeRedis) subscribe(conn net.Conn, channel string) {
+	msgs := make(chan []byte, 100)
+	f.mu.Lock()
+	f.subs[channel] = append(f.subs[channel], msgs)
+	f.mu.Unlock()
+	writeFakeRedisReply(conn, []any{[]byte("subscribe"), []byte(channel), int64(1)})
+	f.subscribed <- channel
+
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+	for {
+		select {
+		case msg := <-msgs:
+			writeFakeRedisReply(conn, []any{[]byte("message"), []byte(channel), msg})
+		case <-closed:
+			f.mu.Lock()
+			f.subs[channel] = slices.DeleteFunc(f.subs[channel], func(c chan []byte) bool { return c == msgs })
+			f.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) publish(channel string, msg []byte) int64 {
+	for _, sub := range f.subs[channel] {
+		sub <- msg
+	}
+	return int64(len(f.subs[channel]))
+}
+
+// Stored version of a hash field as the scripts read it
+func (f *fakeRedis) version(key, field string) int64 {
+	cur, ok := f.hashes[key][field]
+	if !ok {
+		return 0
+	}
+	v, _, _ := strings.Cut(cur, ":")
+	version, _ := strconv.ParseInt(v, 10, 64)
+	return version
+}
+
+func (f *fakeRedis) do(args []string) any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch args[0] {
+	case "PUBLISH":
+		return f.publish(args[1], []byte(args[2]))
+	case "HGET":
+		if v, ok := f.hashes[args[1]][args[2]]; ok {
+			return []byte(v)
+		}
+		return nil
+	case "HSCAN":
+		var fields []any
+		for _, field := range slices.Sorted(maps.Keys(f.hashes[args[1]])) {
+			fields = append(fields, []byte(field), []byte(f.hashes[args[1]][field]))
+		}
+		return []any{[]byte("0"), fields}
+	case "EVAL":
+		key, argv := args[3], args[4:]
+		want, _ := strconv.ParseInt(argv[1], 10, 64)
+		stored := f.version(key, argv[0])
+		switch args[1] {
+		case redisPutScript:
+			if stored != want {
+				return stored
+			}
+			if f.hashes[key] == nil {
+				f.hashes[key] = make(map[string]string)
+			}
+			value := strconv.FormatInt(stored+1, 10) + ":" + argv[2]
+			f.hashes[key][argv[0]] = value
+			msg, _ := json.Marshal([]string{argv[0], value})
+			f.publish(argv[3], msg)
+		case redisDeleteScript:
+			if stored == 0 || stored != want {
+				return stored
+			}
+			delete(f.hashes[key], argv[0])
+			msg, _ := json.Marshal([]string{argv[0]})
+			f.publish(argv[2], msg)
+		default:
+			return RedisError("NOSCRIPT unknown script")
+		}
+		return int64(-1)
+	}
+	return RedisError("ERR unknown command '" + args[0] + "'")
+}
+
+func TestRedisObjectStore(t *testing.T) {
+	fake, addr := newFakeRedis(t, "secret")
+	ctx := context.Background()
+
+	if _, err := (&RedisClient{Addr: addr, Password: "wrong"}).Do(ctx, "HGET", "k", "f"); err == nil {
+		t.Fatal("expected an error with a wrong password")
+	}
+	client := &RedisClient{Addr: addr, Password: "secret"}
+	defer client.Close()
+	store := NewRedisObjectStore[string, string](client, "lb")
+
+	// Every write reaches the watchers
+	watchCtx, cancel := context.WithCancel(ctx)
+	writes := make(chan ObjectRecord[string, string], 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- NewRedisObjectStore[string, string](&RedisClient{Addr: addr, Password: "secret"}, "lb").Watch(watchCtx,
+			func(rec ObjectRecord[string, string]) { writes <- rec })
+	}()
+	if channel := <-fake.subscribed; channel != "lb:objects" {
+		t.Fatalf("expected a subscription to lb:objects, got %s", channel)
+	}
+	checkObjectStore(t, "redis", store)
+	var versions []int64
+	for range 4 {
+		rec := <-writes
+		if rec.ID == "obj" {
+			versions = append(versions, rec.Version)
+		}
+	}
+	if !slices.Equal(versions, []int64{1, 2, 0}) {
+		t.Fatalf("expected obj written at versions 1 and 2 then deleted, got %v", versions)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the watch to end with the context, got %v", err)
+	}
+
+	// The change feed is published on a channel
+	sink, err := parseChangeSink("redis:" + addr + "/changes")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	sink.(RedisSink).Client.Password = "secret"
+	msgs := make(chan string, 10)
+	subCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go (&RedisClient{Addr: addr, Password: "secret"}).Subscribe(subCtx, "changes", func(msg []byte) { msgs <- string(msg) })
+	<-fake.subscribed
+	if err := sink.Write(ctx, [][]byte{[]byte(`{"version":1}`), []byte(`{"version":2}`)}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := []string{<-msgs, <-msgs}; !slices.Equal(got, []string{`{"version":1}`, `{"version":2}`}) {
+		t.Fatalf("unexpected records %v", got)
+	}
+}
+
+func TestRedisSharedObjectStore(t *testing.T) {
+	_, addr := newFakeRedis(t, "secret")
+	client1 := &RedisClient{Addr: addr, Password: "secret"}
+	defer client1.Close()
+	client2 := &RedisClient{Addr: addr, Password: "secret"}
+	defer client2.Close()
+	checkSharedObjectStore(t, NewRedisObjectStore[string, string](client1, "lb"), NewRedisObjectStore[string, string](client2, "lb"))
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Handling of objects assigned to nodes that are removed
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+)
+
+// RemovalPolicy decides what happens to the objects of a removed node
+type RemovalPolicy int
+
+const (
+	// Reassign the objects to the remaining nodes
+	ReassignOnRemoval RemovalPolicy = iota
+
+	// Unassign the objects and report them as orphaned
+	OrphanOnRemoval
+
+	// Refuse to remove nodes that still have objects assigned
+	FailOnRemoval
+)
+
+var (
+	// ErrObjectOrphaned is reported for objects left without a node
+	ErrObjectOrphaned = errors.New("object orphaned")
+
+	// ErrNodeNotEmpty is returned when removing a node that still has objects
+	ErrNodeNotEmpty = errors.New("node has objects assigned")
+)
+
+// ReassignmentError reports the objects of removed nodes that could not be
+// reassigned and the objects evicted from full nodes, keyed by object id
+type ReassignmentError[O comparable] struct {
+	Errors map[O]error
+}
+
+func (e *ReassignmentError[O]) Error() string {
+	return fmt.Sprintf("%d objects not reassigned", len(e.Errors))
+}
+
+func (e *ReassignmentError[O]) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Record a per-object error
+func (e *ReassignmentError[O]) add(id O, err error) {
+	if e.Errors == nil {
+		e.Errors = make(map[O]error)
+	}
+	e.Errors[id] = err
+}
+
+// Find the node registered in the server pool with the same name as node
+func (lb *loadBalancer[T, O]) lookupNode(node serverpool.Node[T, O]) (serverpool.Node[T, O], bool) {
+	return lb.nodeByName(node.Name())
+}
+
+// Find the node registered in the server pool with the given name
+func (lb *loadBalancer[T, O]) nodeByName(name T) (serverpool.Node[T, O], bool) {
+	for n := range lb.sp.Nodes() {
+		if n.Name() == name {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// Check that none of the nodes has objects assigned
+func (lb *loadBalancer[T, O]) checkEmpty(nodes []serverpool.Node[T, O]) error {
+	for _, node := range nodes {
+		n, ok := lb.lookupNode(node)
+		if !ok {
+			continue
+		}
+		for obj := range n.Objects() {
+			return fmt.Errorf("%w: %v has %v", ErrNodeNotEmpty, n, obj)
+		}
+	}
+	return nil
+}
+
+// Handle the objects of a node that has just been removed and return the
+// number of objects reassigned, deferred by the movement budget and orphaned
+func (lb *loadBalancer[T, O]) evacuate(removed serverpool.Node[T, O], errs *ReassignmentError[O]) (reassigned, deferred, orphaned int) {
+	if lb.removalPolicy == OrphanOnRemoval {
+		for obj := range removed.Objects() {
+			lb.undo.moving(obj)
+			removed.UnassignObject(obj)
+			obj.UnassignFromNode()
+			lb.notifyUnassigned(obj, removed)
+			errs.add(obj.Id, ErrObjectOrphaned)
+			orphaned++
+		}
+		return reassigned, deferred, orphaned
+	}
+
+	// Re-assign objects assigned to the deleted after removing the bucket
+	// so they are reassined to other nodes
+	lb.profiler.do("rebalance", func() {
+		moves, unmapped := lb.planMoves(removed, removed.Objects())
+		for id, err := range unmapped {
+			errs.add(id, err)
+			orphaned++
+		}
+
+		allowed, postponed := lb.admit(moves)
+		for _, m := range postponed {
+			lb.undo.moving(m.Object)
+			removed.UnassignObject(m.Object)
+			m.Object.UnassignFromNode()
+			lb.notifyUnassigned(m.Object, removed)
+		}
+		deferred = len(postponed)
+
+		for _, m := range allowed {
+			if err := lb.assignObject(m.Object); err != nil {
+				errs.add(m.Object.Id, err)
+				orphaned++
+				continue
+			}
+			reassigned++
+		}
+		lb.churn.record(reassigned)
+		lb.churn.postpone(postponed)
+	})
+	return reassigned, deferred, orphaned
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+	"testing"
+)
+
+func TestRemoveNodesPolicy(t *testing.T) {
+	newNodes := func() []serverpool.Node[string, string] {
+		return []serverpool.Node[string, string]{
+			&mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])},
+			&mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])},
+		}
+	}
+	objects := func() []*serverpool.Object[string, string] {
+		return []*serverpool.Object[string, string]{{Id: "obj1"}, {Id: "obj2"}, {Id: "obj3"}, {Id: "obj4"}}
+	}
+
+	setup := func(policy RemovalPolicy) (LoadBalancer[string, string], []serverpool.Node[string, string]) {
+		lb := NewLoadBalancerWithOptions(WithRemovalPolicy[string, string](policy))
+		nodes := newNodes()
+		if _, err := lb.AddNodes(nodes); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		objs := objects()
+		if _, err := lb.AddObjects(objs); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, obj := range objs {
+			if err := lb.AssignObject(obj); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		return lb, nodes
+	}
+
+	// Removing every node leaves nowhere to reassign the objects to
+	lb, nodes := setup(ReassignOnRemoval)
+	_, err := lb.RemoveNodes(nodes)
+	var rerr *ReassignmentError[string]
+	if !errors.As(err, &rerr) || len(rerr.Errors) != 4 {
+		t.Fatalf("expected 4 reassignment errors, got %v", err)
+	}
+
+	// Remove a node holding objects, which are orphaned
+	lb, nodes = setup(OrphanOnRemoval)
+	full := nodes[:1]
+	if len(nodes[0].(*mockNode).objects) == 0 {
+		full = nodes[1:]
+	}
+	held := len(full[0].(*mockNode).objects)
+	result, err := lb.RemoveNodes(full)
+	if !errors.Is(err, ErrObjectOrphaned) || !errors.As(err, &rerr) || len(rerr.Errors) != held {
+		t.Fatalf("expected %d orphaned objects, got %v", held, err)
+	}
+	if result.Nodes[0].Orphaned != held {
+		t.Fatalf("expected %d objects orphaned, got %+v", held, result.Nodes[0])
+	}
+	for obj := range full[0].Objects() {
+		t.Fatalf("expected %v to be unassigned from removed node", obj)
+	}
+
+	lb, nodes = setup(FailOnRemoval)
+	_, err = lb.RemoveNodes(nodes)
+	if !errors.Is(err, ErrNodeNotEmpty) {
+		t.Fatalf("expected ErrNodeNotEmpty, got %v", err)
+	}
+	if lb.NodeCount() != 2 {
+		t.Fatalf("expected 2 nodes, got %d", lb.NodeCount())
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Results of bulk node and object operations
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+)
+
+// ItemStatus is the outcome of a single item in a bulk operation
+type ItemStatus int
+
+const (
+	// The item was applied
+	StatusOK ItemStatus = iota
+
+	// The item was attempted and failed
+	StatusFailed
+
+	// The item was not attempted because an earlier item failed
+	StatusSkipped
+)
+
+var itemStatusNames = map[ItemStatus]string{
+	StatusOK:      "ok",
+	StatusFailed:  "failed",
+	StatusSkipped: "skipped",
+}
+
+func (s ItemStatus) String() string {
+	if name, ok := itemStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("ItemStatus(%d)", int(s))
+}
+
+// NodeResult is the outcome of adding or removing a single node
+type NodeResult[T, O comparable] struct {
+	Node   serverpool.Node[T, O]
+	Status ItemStatus
+
+	// Bucket assigned to an added node or released by a removed node, -1 if none
+	Bucket int
+
+	// Objects of a removed node moved to other nodes
+	Reassigned int
+
+	// Objects of a removed node waiting for movement budget or the end of
+	// a cool-down, see Rebalance
+	Deferred int
+
+	// Objects of a removed node left without a node
+	Orphaned int
+
+	// Error for a failed node
+	Err error
+}
+
+// NodesResult is the outcome of AddNodes or RemoveNodes, one entry per node
+// in the order the nodes were given
+type NodesResult[T, O comparable] struct {
+	Nodes []NodeResult[T, O]
+
+	// Objects that would move, only filled in a dry run
+	Moves []Move[T, O]
+}
+
+// Count the nodes with the given status
+func (r NodesResult[T, O]) Count(status ItemStatus) int {
+	n := 0
+	for _, nr := range r.Nodes {
+		if nr.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Total number of objects reassigned by a removal
+func (r NodesResult[T, O]) Reassigned() int {
+	n := 0
+	for _, nr := range r.Nodes {
+		n += nr.Reassigned
+	}
+	return n
+}
+
+// Total number of objects deferred by the movement budget or a cool-down
+func (r NodesResult[T, O]) Deferred() int {
+	n := 0
+	for _, nr := range r.Nodes {
+		n += nr.Deferred
+	}
+	return n
+}
+
+// Create a result where every node is skipped until it is processed
+func newNodesResult[T, O comparable](nodes []serverpool.Node[T, O]) NodesResult[T, O] {
+	r := NodesResult[T, O]{Nodes: make([]NodeResult[T, O], len(nodes))}
+	for i, node := range nodes {
+		r.Nodes[i] = NodeResult[T, O]{Node: node, Status: StatusSkipped, Bucket: -1}
+	}
+	return r
+}
+
+// ObjectResult is the outcome of adding or removing a single object
+type ObjectResult[T, O comparable] struct {
+	Object *serverpool.Object[T, O]
+	Status ItemStatus
+
+	// Error for a failed object
+	Err error
+}
+
+// ObjectsResult is the outcome of AddObjects or RemoveObjects, one entry per
+// object in the order the objects were given
+type ObjectsResult[T, O comparable] struct {
+	Objects []ObjectResult[T, O]
+}
+
+// Create a result where every object has the same status
+func newObjectsResult[T, O comparable](objects []*serverpool.Object[T, O], status ItemStatus) ObjectsResult[T, O] {
+	r := ObjectsResult[T, O]{Objects: make([]ObjectResult[T, O], len(objects))}
+	for i, obj := range objects {
+		r.Objects[i] = ObjectResult[T, O]{Object: obj, Status: status}
+	}
+	return r
+}
+
+// Count the objects with the given status
+func (r ObjectsResult[T, O]) Count(status ItemStatus) int {
+	n := 0
+	for _, or := range r.Objects {
+		if or.Status == status {
+			n++
+		}
+	}
+	return n
+}
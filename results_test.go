@@ -0,0 +1,47 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"serverpool"
+	"testing"
+)
+
+func TestAddNodesResult(t *testing.T) {
+	sp := serverpool.NewServerPool[string, string]()
+	ch := &mockConsistentHasher{}
+	lb := &loadBalancer[string, string]{sp: sp, ch: ch}
+
+	node1 := &mockNode{ID: "node1"}
+	result, err := lb.AddNodes([]serverpool.Node[string, string]{node1, &mockNode{ID: "node2"}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, nr := range result.Nodes {
+		if nr.Status != StatusOK || nr.Bucket != i {
+			t.Fatalf("expected node %d in bucket %d, got %v in bucket %d", i, i, nr.Status, nr.Bucket)
+		}
+	}
+
+	// The duplicate node fails, the node after it is not attempted
+	// and the bucket allocated for the duplicate is released
+	result, err = lb.AddNodes([]serverpool.Node[string, string]{
+		&mockNode{ID: "node3"},
+		node1,
+		&mockNode{ID: "node4"},
+	})
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	want := []ItemStatus{StatusOK, StatusFailed, StatusSkipped}
+	for i, nr := range result.Nodes {
+		if nr.Status != want[i] {
+			t.Fatalf("expected node %d to be %v, got %v", i, want[i], nr.Status)
+		}
+	}
+	if ch.Size() != 3 {
+		t.Fatalf("expected 3 buckets, got %d", ch.Size())
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Consistent hashing libraries adapted to the rings of comparisons
+
+package main
+
+import (
+	"consistenthash"
+	"hashing"
+
+	buraksezer "github.com/buraksezer/consistent"
+	lafikl "github.com/lafikl/consistent"
+	stathat "github.com/stathat/consistent"
+)
+
+// stathatRing is stathat/consistent, a classic ring of 20 points per node
+type stathatRing struct {
+	*stathat.Consistent
+}
+
+func (r stathatRing) Get(key string) string {
+	node, _ := r.Consistent.Get(key)
+	return node
+}
+
+// buraksezerRing is buraksezer/consistent, which hashes keys to a fixed
+// number of partitions and spreads the partitions over the nodes of a ring
+// with bounded loads
+type buraksezerRing struct {
+	*buraksezer.Consistent
+}
+
+// Name of a buraksezer/consistent member
+type ringMember string
+
+func (m ringMember) String() string {
+	return string(m)
+}
+
+// ringHasher hashes buraksezer/consistent keys with the algorithm of the
+// comparison
+type ringHasher struct {
+	hashing.HashFn
+}
+
+func (h ringHasher) Sum64(data []byte) uint64 {
+	return h.HashString(string(data))
+}
+
+func (r buraksezerRing) Add(node string) {
+	r.Consistent.Add(ringMember(node))
+}
+
+func (r buraksezerRing) Get(key string) string {
+	// Without members every partition is ownerless
+	if node := r.LocateKey([]byte(key)); node != nil {
+		return node.String()
+	}
+	return ""
+}
+
+// lafiklRing is lafikl/consistent, a classic ring of 10 points per node
+// with bounded loads. Comparisons look keys up without loads, which is
+// the plain ring.
+type lafiklRing struct {
+	*lafikl.Consistent
+}
+
+func (r lafiklRing) Remove(node string) {
+	r.Consistent.Remove(node)
+}
+
+func (r lafiklRing) Get(key string) string {
+	node, _ := r.Consistent.Get(key)
+	return node
+}
+
+// libraryRings are the consistent hashing libraries compared with the
+// hashers, each with its defaults
+func libraryRings(hashAlgo hashing.HashAlgorithm) map[string]func() consistenthash.Ring {
+	return map[string]func() consistenthash.Ring{
+		"stathat": func() consistenthash.Ring { return stathatRing{stathat.New()} },
+		"buraksezer": func() consistenthash.Ring {
+			return buraksezerRing{buraksezer.New(nil, buraksezer.Config{
+				Hasher:            ringHasher{hashing.NewHashFunction(hashAlgo)},
+				PartitionCount:    buraksezer.DefaultPartitionCount,
+				ReplicationFactor: buraksezer.DefaultReplicationFactor,
+				Load:              buraksezer.DefaultLoad,
+			})}
+		},
+		"lafikl": func() consistenthash.Ring { return lafiklRing{lafikl.New()} },
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"consistenthash"
+	"hashing"
+	"strings"
+	"testing"
+)
+
+func TestLibraryRings(t *testing.T) {
+	s := consistenthash.Scenario{Nodes: 20, Keys: 20000, Lookups: 1000}
+	results := consistenthash.Compare(libraryRings(hashing.DefaultHashAlgorithm), s)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	for _, r := range results {
+		// Every library moves some keys on a change but keeps most of them
+		if r.RemapOnAdd <= 0 || r.RemapOnAdd > 0.25 || r.RemapOnRemove <= 0 || r.RemapOnRemove > 0.25 {
+			t.Fatalf("%s: expected a small share of keys remapped, got %+v", r.Name, r)
+		}
+		if r.Lookup <= 0 || r.Memory == 0 {
+			t.Fatalf("%s: expected lookup time and memory, got %+v", r.Name, r)
+		}
+	}
+
+	// Keys are looked up on an empty ring too
+	for name, ring := range libraryRings(hashing.DefaultHashAlgorithm) {
+		r := ring()
+		if node := r.Get("key"); node != "" {
+			t.Fatalf("%s: expected no node on an empty ring, got %s", name, node)
+		}
+		r.Add("node")
+		if node := r.Get("key"); node != "node" {
+			t.Fatalf("%s: expected node, got %s", name, node)
+		}
+	}
+
+	var report strings.Builder
+	if err := consistenthash.WriteComparisonReport(&report, s, results); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, name := range []string{"| buraksezer |", "| lafikl |", "| stathat |"} {
+		if !strings.Contains(report.String(), name) {
+			t.Fatalf("expected %q in the report:\n%s", name, report.String())
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Saving and loading the state of a load balancer across restarts
+
+package main
+
+import (
+	"consistenthash"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"serverpool"
+	"slices"
+	"time"
+)
+
+// SaveVersion is the version of the format written by Save
+const SaveVersion = 1
+
+// Saved state of a load balancer, written as JSON
+type savedState[T, O comparable] struct {
+	Version int `json:"version"`
+
+	// Removed bucket table and bucket count of the hasher
+	Hasher consistenthash.MementoState `json:"hasher"`
+
+	// Node of each bucket in bucket order, a weighted node once per bucket
+	Buckets []savedBucket[T] `json:"buckets"`
+
+	Objects []savedObject[T, O] `json:"objects"`
+
+	// Nodes being drained, which have objects but no bucket
+	Drains []savedDrain[T] `json:"drains,omitempty"`
+
+	// Keys pinned by PinKey and their nodes
+	Pins map[string]T `json:"pins,omitempty"`
+
+	// Dials in progress
+	Dials []savedDial[T] `json:"dials,omitempty"`
+}
+
+type savedBucket[T comparable] struct {
+	Bucket int `json:"bucket"`
+	Node   T   `json:"node"`
+}
+
+type savedDrain[T comparable] struct {
+	Node    T         `json:"node"`
+	Batch   int       `json:"batch"`
+	Total   int       `json:"total"`
+	Moved   int       `json:"moved"`
+	Started time.Time `json:"started"`
+}
+
+type savedDial[T comparable] struct {
+	From     T          `json:"from"`
+	To       T          `json:"to"`
+	Schedule []DialStep `json:"schedule"`
+	Started  time.Time  `json:"started"`
+}
+
+type savedObject[T, O comparable] struct {
+	ID O `json:"id"`
+
+	// Node the object is assigned to, nil if unassigned
+	Node *T `json:"node,omitempty"`
+
+	// Node the object was transferred to, nil if none
+	Transferred *T `json:"transferred,omitempty"`
+}
+
+// Save writes the hasher state, the node of each bucket and the node of
+// each object as JSON, so Load can restore the mapping after a restart
+// without moving any key. Drains and dials in progress are saved with their
+// progress, pinned keys with their nodes and transferred objects with the
+// nodes they were transferred to. Node and object names must marshal to
+// JSON. Only the memento hasher can be saved.
+func (lb *loadBalancer[T, O]) Save(w io.Writer) error {
+	topo, err := lb.topology()
+	if err != nil {
+		return err
+	}
+	state := savedState[T, O]{Version: SaveVersion, Hasher: topo.Hasher,
+		Buckets: []savedBucket[T]{}, Objects: []savedObject[T, O]{}, Pins: lb.PinnedKeys()}
+	for bucket, node := range lb.sp.Buckets() {
+		state.Buckets = append(state.Buckets, savedBucket[T]{bucket, node.Name()})
+	}
+	slices.SortFunc(state.Buckets, func(a, b savedBucket[T]) int { return a.Bucket - b.Bucket })
+	for name, d := range lb.drains {
+		state.Drains = append(state.Drains, savedDrain[T]{Node: name, Batch: d.batch, Total: d.total,
+			Moved: d.moved, Started: d.started})
+	}
+	for name, d := range lb.dials {
+		state.Dials = append(state.Dials, savedDial[T]{From: name, To: d.to.Name(), Schedule: d.steps,
+			Started: d.started})
+	}
+	for obj := range lb.objects.all() {
+		saved := savedObject[T, O]{ID: obj.Id}
+		if n := obj.Node(); n != nil && *n != nil {
+			name := (*n).Name()
+			saved.Node = &name
+		}
+		if name, ok := lb.transferred[obj.Id]; ok {
+			saved.Transferred = &name
+		}
+		state.Objects = append(state.Objects, saved)
+	}
+	return json.NewEncoder(w).Encode(state)
+}
+
+// Load restores state written by Save into an empty load balancer created
+// with the options of the saved one. newNode creates the node of each
+// saved name, and objects are assigned back to the nodes they were on.
+// Drains and dials carry on where they were, keys stay pinned and
+// transferred objects stay on their nodes. The change feed starts over, so mirrors
+// must follow the load balancer from after the load.
+func (lb *loadBalancer[T, O]) Load(r io.Reader, newNode func(name T) serverpool.Node[T, O]) error {
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	if lb.sp.Len() > 0 || lb.objects.len() > 0 || lb.ch.Size() > 0 || len(lb.drains) > 0 {
+		return errors.New("cannot load into a load balancer with nodes or objects")
+	}
+	var state savedState[T, O]
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("decoding saved state: %w", err)
+	}
+	if state.Version != SaveVersion {
+		return fmt.Errorf("unsupported saved state version %d", state.Version)
+	}
+	names := make(map[T]bool)
+	for _, b := range state.Buckets {
+		names[b.Node] = true
+	}
+	for _, d := range state.Dials {
+		if !names[d.From] || !names[d.To] {
+			return fmt.Errorf("dial from %v to %v is not between nodes of the pool", d.From, d.To)
+		}
+	}
+	for _, d := range state.Drains {
+		if names[d.Node] {
+			return fmt.Errorf("draining node %v is also in the pool", d.Node)
+		}
+		if d.Batch <= 0 {
+			return fmt.Errorf("draining node %v has batch %d", d.Node, d.Batch)
+		}
+		names[d.Node] = true
+	}
+	for _, saved := range state.Objects {
+		if saved.Node != nil && !names[*saved.Node] {
+			return fmt.Errorf("object %v is on unknown node %v", saved.ID, *saved.Node)
+		}
+	}
+
+	// A failure leaves the load balancer empty again
+	empty, err := consistenthash.ExportState(lb.ch)
+	if err != nil {
+		return err
+	}
+	if err := consistenthash.RestoreState(lb.ch, state.Hasher); err != nil {
+		return err
+	}
+	var added []serverpool.Node[T, O]
+	fail := func(err error) error {
+		for _, node := range added {
+			lb.sp.RemoveNode(node)
+		}
+		consistenthash.RestoreState(lb.ch, empty)
+		return err
+	}
+	if len(state.Buckets) != lb.ch.Size() {
+		return fail(fmt.Errorf("saved state has %d buckets but its hasher has %d", len(state.Buckets), lb.ch.Size()))
+	}
+	nodes := make(map[T]serverpool.Node[T, O])
+	for _, b := range state.Buckets {
+		if node, ok := nodes[b.Node]; ok {
+			if err := lb.sp.AddBucket(node, b.Bucket); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		node := newNode(b.Node)
+		if err := lb.sp.AddNode(node, b.Bucket); err != nil {
+			return fail(err)
+		}
+		nodes[b.Node] = node
+		added = append(added, node)
+	}
+	for _, node := range added {
+		lb.tierAdd(node)
+	}
+	if len(state.Drains) > 0 {
+		lb.drains = make(map[T]*drain[T, O])
+	}
+	for _, d := range state.Drains {
+		node := newNode(d.Node)
+		nodes[d.Node] = node
+		lb.drains[d.Node] = &drain[T, O]{node: node, batch: d.Batch, total: d.Total, moved: d.Moved, started: d.Started}
+	}
+
+	for _, saved := range state.Objects {
+		obj := &serverpool.Object[T, O]{Id: saved.ID}
+		if saved.Node != nil {
+			node := nodes[*saved.Node]
+			node.AssignObject(obj)
+			obj.AssignToNode(&node)
+			lb.eviction.touch(obj.Id, lb.churn.clock())
+		}
+		if saved.Transferred != nil {
+			if lb.transferred == nil {
+				lb.transferred = make(map[O]T)
+			}
+			lb.transferred[obj.Id] = *saved.Transferred
+		}
+		lb.objects.set(obj)
+	}
+	lb.keyPins = state.Pins
+	if len(state.Dials) > 0 {
+		lb.dials = make(map[T]*dial[T, O])
+	}
+	for _, d := range state.Dials {
+		lb.dials[d.From] = &dial[T, O]{to: nodes[d.To], steps: d.Schedule, started: d.Started}
+	}
+	lb.churn.topologyChanged()
+	lb.reportMetrics()
+	lb.shedding.update(lb.ch.Size() > 0)
+	return nil
+}
+
+// Save the load balancer to a file, replacing it only once fully written
+func saveStateFile[T, O comparable](lb LoadBalancer[T, O], path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := lb.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load the load balancer from a file saved before, if there is one
+func loadStateFile[T, O comparable](lb LoadBalancer[T, O], path string, newNode func(name T) serverpool.Node[T, O]) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return lb.Load(f, newNode)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"serverpool"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	newNode := func(id string) serverpool.Node[string, string] {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	lb := NewLoadBalancer[string, string]()
+	var nodes []serverpool.Node[string, string]
+	for i := range 6 {
+		nodes = append(nodes, newNode(fmt.Sprintf("node%d", i)))
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objects []*serverpool.Object[string, string]
+	for i := range 100 {
+		objects = append(objects, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)})
+	}
+	if _, err := lb.AddObjects(objects); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objects[:90] {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{nodes[1], nodes[4]}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// A transferred object stays where it was put
+	to := nodes[0]
+	if *objects[0].Node() == to {
+		to = nodes[2]
+	}
+	if err := lb.TransferObject(objects[0], to); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var saved bytes.Buffer
+	if err := lb.Save(&saved); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	restored := NewConcurrentLoadBalancer[string, string]()
+	if err := restored.Load(bytes.NewReader(saved.Bytes()), newNode); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for i := range 1000 {
+		key := "key" + strconv.Itoa(i)
+		want, _ := lb.GetNode(key)
+		got, err := restored.GetNode(key)
+		if err != nil || got.Name() != want.Name() {
+			t.Fatalf("expected %s on %v, got %v, %v", key, want, got, err)
+		}
+	}
+	count := 0
+	for obj := range restored.Objects() {
+		stored, _ := lb.(*loadBalancer[string, string]).objects.get(obj.Id)
+		switch n := obj.Node(); {
+		case stored.Node() == nil:
+			if n != nil {
+				t.Fatalf("expected %v unassigned, got %v", obj, *n)
+			}
+		case n == nil || (*n).Name() != (*stored.Node()).Name():
+			t.Fatalf("expected %v on %v", obj, *stored.Node())
+		}
+		count++
+	}
+	if count != len(objects) {
+		t.Fatalf("expected %d objects, got %d", len(objects), count)
+	}
+	if err := restored.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Removed buckets are reused as they would have been
+	want, err := lb.AddNodes([]serverpool.Node[string, string]{newNode("node6")})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got, err := restored.AddNodes([]serverpool.Node[string, string]{newNode("node6")})
+	if err != nil || got.Nodes[0].Bucket != want.Nodes[0].Bucket {
+		t.Fatalf("expected bucket %d, got %+v, %v", want.Nodes[0].Bucket, got, err)
+	}
+
+	if err := restored.Load(bytes.NewReader(saved.Bytes()), newNode); err == nil {
+		t.Fatalf("expected an error loading into a load balancer with nodes")
+	}
+	if err := NewLoadBalancer[string, string]().Load(strings.NewReader(`{"version":2}`), newNode); err == nil {
+		t.Fatalf("expected an error for an unknown version")
+	}
+	maglev := NewLoadBalancerWithOptions(WithMaglevTable[string, string](0))
+	if err := maglev.Save(io.Discard); err == nil {
+		t.Fatalf("expected an error saving maglev hashing")
+	}
+	if err := maglev.Load(bytes.NewReader(saved.Bytes()), newNode); err == nil {
+		t.Fatalf("expected an error loading into maglev hashing")
+	}
+}
@@ -9,12 +9,22 @@ import (
 	"fmt"
 	"iter"
 	"net/netip"
+	"net/url"
 	"serverpool"
 )
 
 type serverNode[O comparable] struct {
 	ip netip.Addr
 
+	// Metadata of the server, such as the tags of a cloud instance
+	tags map[string]string
+
+	// Share of the keys relative to other nodes, 0 for the default of 1
+	weight int
+
+	// URL requests routed to the server are proxied to, nil if not set
+	backend *url.URL
+
 	// Objects assigned to the server node
 	objects map[O]*serverpool.Object[netip.Addr,O]
 }
@@ -23,6 +33,29 @@ func NewServerNode[O comparable](ip netip.Addr) serverNode[O] {
 	return serverNode[O]{ip: ip, objects: make(map[O]*serverpool.Object[netip.Addr,O])}
 }
 
+// NewTaggedServerNode creates a server node carrying the given tags
+func NewTaggedServerNode[O comparable](ip netip.Addr, tags map[string]string) serverNode[O] {
+	sn := NewServerNode[O](ip)
+	sn.tags = tags
+	return sn
+}
+
+// NewWeightedServerNode creates a server node receiving weight times the
+// keys of a node of weight 1
+func NewWeightedServerNode[O comparable](ip netip.Addr, weight int) serverNode[O] {
+	sn := NewServerNode[O](ip)
+	sn.weight = weight
+	return sn
+}
+
+// NewBackendServerNode creates a server node whose requests are proxied to
+// the given backend URL
+func NewBackendServerNode[O comparable](ip netip.Addr, backend *url.URL) serverNode[O] {
+	sn := NewServerNode[O](ip)
+	sn.backend = backend
+	return sn
+}
+
 func NewServerNodeBytes[O comparable](addr [4]byte) serverNode[O] {
 	return NewServerNode[O](netip.AddrFrom4(addr))
 }
@@ -39,6 +72,21 @@ func (sn *serverNode[O]) Name() netip.Addr {
 	return sn.ip
 }
 
+// Tags of the server node, nil if it has none
+func (sn *serverNode[O]) Tags() map[string]string {
+	return sn.tags
+}
+
+// Backend URL of the server node, nil if it has none
+func (sn *serverNode[O]) Backend() *url.URL {
+	return sn.backend
+}
+
+// Weight of the server node
+func (sn *serverNode[O]) Weight() int {
+	return max(sn.weight, 1)
+}
+
 
 func (sn *serverNode[O]) AssignObject(obj *serverpool.Object[netip.Addr,O]) {
 	sn.objects[obj.Id] = obj
@@ -0,0 +1,199 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package serverpool
+
+import (
+	"container/heap"
+	"iter"
+)
+
+const (
+	// Bucket ids below this bound are always stored densely
+	minDenseBuckets = 64
+
+	// Dense storage is used while the largest bucket id is below
+	// this multiple of the number of buckets in use
+	maxSparseFactor = 4
+)
+
+// bucketTable associates bucket indexes with nodes
+type bucketTable[T, O comparable] interface {
+	get(bucket int) (Node[T, O], bool)
+	set(bucket int, node Node[T, O])
+	delete(bucket int)
+	len() int
+
+	// Largest bucket id in use, -1 if empty
+	max() int
+
+	all() iter.Seq2[int, Node[T, O]]
+}
+
+// Check if buckets up to max are dense enough to be stored in a slice
+func dense(max, count int) bool {
+	return max < minDenseBuckets || max < maxSparseFactor*count
+}
+
+// Map backed table for sparse bucket ids
+type mapBuckets[T, O comparable] struct {
+	nodes map[int]Node[T, O]
+
+	// Bucket ids in use as a max-heap. Deleted ids are only dropped once
+	// they reach the top.
+	ids bucketHeap
+
+	// Number of negative bucket ids in use, which cannot be stored densely
+	negatives int
+}
+
+func newMapBuckets[T, O comparable](size int) *mapBuckets[T, O] {
+	return &mapBuckets[T, O]{nodes: make(map[int]Node[T, O], size), ids: make(bucketHeap, 0, size)}
+}
+
+func (m *mapBuckets[T, O]) get(bucket int) (Node[T, O], bool) {
+	node, ok := m.nodes[bucket]
+	return node, ok
+}
+
+func (m *mapBuckets[T, O]) set(bucket int, node Node[T, O]) {
+	if _, ok := m.nodes[bucket]; !ok {
+		if bucket < 0 {
+			m.negatives++
+		}
+
+		// Rebuild the heap once deleted ids make up most of it
+		if len(m.ids) > 2*len(m.nodes)+minDenseBuckets {
+			m.ids = m.ids[:0]
+			for b := range m.nodes {
+				m.ids = append(m.ids, b)
+			}
+			heap.Init(&m.ids)
+		}
+		heap.Push(&m.ids, bucket)
+	}
+	m.nodes[bucket] = node
+}
+
+func (m *mapBuckets[T, O]) delete(bucket int) {
+	if _, ok := m.nodes[bucket]; !ok {
+		return
+	}
+	if bucket < 0 {
+		m.negatives--
+	}
+	delete(m.nodes, bucket)
+}
+
+func (m *mapBuckets[T, O]) len() int {
+	return len(m.nodes)
+}
+
+func (m *mapBuckets[T, O]) max() int {
+	for len(m.ids) > 0 {
+		if _, ok := m.nodes[m.ids[0]]; ok {
+			return m.ids[0]
+		}
+		heap.Pop(&m.ids)
+	}
+	return -1
+}
+
+// Check if any bucket id is negative and cannot be stored densely
+func (m *mapBuckets[T, O]) negative() bool {
+	return m.negatives > 0
+}
+
+func (m *mapBuckets[T, O]) all() iter.Seq2[int, Node[T, O]] {
+	return func(yield func(int, Node[T, O]) bool) {
+		for bucket, node := range m.nodes {
+			if !yield(bucket, node) {
+				return
+			}
+		}
+	}
+}
+
+// Max-heap of bucket ids
+type bucketHeap []int
+
+func (h bucketHeap) Len() int           { return len(h) }
+func (h bucketHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h bucketHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *bucketHeap) Push(x any) {
+	*h = append(*h, x.(int))
+}
+
+func (h *bucketHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Slice backed table for dense bucket ids. Unused slots hold nil, so a
+// slot costs no more than the node interface.
+type sliceBuckets[T, O comparable] struct {
+	nodes []Node[T, O]
+
+	// Number of slots in use
+	count int
+}
+
+func (s *sliceBuckets[T, O]) get(bucket int) (Node[T, O], bool) {
+	if bucket < 0 || bucket >= len(s.nodes) || s.nodes[bucket] == nil {
+		return nil, false
+	}
+	return s.nodes[bucket], true
+}
+
+func (s *sliceBuckets[T, O]) set(bucket int, node Node[T, O]) {
+	if bucket >= len(s.nodes) {
+		s.nodes = append(s.nodes, make([]Node[T, O], bucket+1-len(s.nodes))...)
+	}
+	if s.nodes[bucket] == nil {
+		s.count++
+	}
+	s.nodes[bucket] = node
+}
+
+func (s *sliceBuckets[T, O]) delete(bucket int) {
+	if bucket < 0 || bucket >= len(s.nodes) || s.nodes[bucket] == nil {
+		return
+	}
+	s.nodes[bucket] = nil
+	s.count--
+
+	// Trim empty slots at the end so the table shrinks with the ring
+	for len(s.nodes) > 0 && s.nodes[len(s.nodes)-1] == nil {
+		s.nodes = s.nodes[:len(s.nodes)-1]
+	}
+}
+
+func (s *sliceBuckets[T, O]) len() int {
+	return s.count
+}
+
+func (s *sliceBuckets[T, O]) max() int {
+	return len(s.nodes) - 1
+}
+
+func (s *sliceBuckets[T, O]) all() iter.Seq2[int, Node[T, O]] {
+	return func(yield func(int, Node[T, O]) bool) {
+		for bucket, node := range s.nodes {
+			if node != nil && !yield(bucket, node) {
+				return
+			}
+		}
+	}
+}
+
+// Copy all buckets of a table into another
+func copyBuckets[T, O comparable](dst, src bucketTable[T, O]) bucketTable[T, O] {
+	for bucket, node := range src.all() {
+		dst.set(bucket, node)
+	}
+	return dst
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package serverpool
+
+import (
+	"iter"
+	"math/rand"
+	"testing"
+)
+
+// Node of a server pool test, named by an int
+type testNode int
+
+func (n testNode) Name() int                            { return int(n) }
+func (n testNode) AssignObject(obj *Object[int, int])   {}
+func (n testNode) UnassignObject(obj *Object[int, int]) {}
+func (n testNode) Objects() iter.Seq[*Object[int, int]] { return func(func(*Object[int, int]) bool) {} }
+
+// Buckets from first up to last, every step apart
+func bucketRange(first, last, step int) []int {
+	var buckets []int
+	for b := first; b <= last; b += step {
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+func TestBucketStorage(t *testing.T) {
+	type step struct {
+		// Buckets added, then buckets removed
+		add, remove []int
+
+		// Storage expected after the step
+		sparse bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"contiguous", []step{
+			{add: bucketRange(0, 99, 1)},
+			{remove: bucketRange(50, 99, 1)},
+		}},
+		{"outlier", []step{
+			{add: bucketRange(0, 9, 1)},
+			{add: []int{1000}, sparse: true},
+			{remove: []int{1000}},
+		}},
+		{"headroom", []step{
+			{add: bucketRange(0, 99, 1)},
+			{add: []int{450}, sparse: true},
+			// Dense enough, but not twice over
+			{add: []int{300}, remove: []int{450}, sparse: true},
+			{remove: []int{300}},
+		}},
+		{"negative", []step{
+			{add: bucketRange(0, 9, 1)},
+			{add: []int{-1}, sparse: true},
+			{add: []int{-2}, sparse: true},
+			{remove: []int{-1}, sparse: true},
+			{remove: []int{-2}},
+		}},
+		{"spread", []step{
+			{add: bucketRange(0, 99_000, 1000), sparse: true},
+			{remove: bucketRange(1000, 99_000, 1000)},
+		}},
+		{"shrinking", []step{
+			{add: bucketRange(0, 499, 1)},
+			{remove: bucketRange(0, 399, 1), sparse: true},
+			{add: bucketRange(0, 399, 1)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp := NewServerPool[int, int]()
+			want := make(map[int]bool)
+			for i, s := range tt.steps {
+				for _, b := range s.add {
+					if err := sp.AddNode(testNode(b), b); err != nil {
+						t.Fatalf("step %d: expected no error, got %v", i, err)
+					}
+					want[b] = true
+				}
+				for _, b := range s.remove {
+					if _, _, err := sp.RemoveNode(testNode(b)); err != nil {
+						t.Fatalf("step %d: expected no error, got %v", i, err)
+					}
+					delete(want, b)
+				}
+
+				if _, sparse := sp.bucketToNode.(*mapBuckets[int, int]); sparse != s.sparse {
+					t.Fatalf("step %d: expected sparse storage %v, got %T", i, s.sparse, sp.bucketToNode)
+				}
+				if sp.bucketToNode.len() != len(want) {
+					t.Fatalf("step %d: expected %d buckets, got %d", i, len(want), sp.bucketToNode.len())
+				}
+				for b := range want {
+					if node, ok := sp.GetNode(b); !ok || node.Name() != b {
+						t.Fatalf("step %d: expected bucket %d on node %d, got %v, %v", i, b, b, node, ok)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMapBucketsMax(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := newMapBuckets[int, int](0)
+	want := make(map[int]bool)
+	for i := range 20000 {
+		b := r.Intn(2000) - 100
+		if r.Intn(2) == 0 {
+			m.set(b, testNode(b))
+			want[b] = true
+		} else {
+			m.delete(b)
+			delete(want, b)
+		}
+
+		max, negative := -1, false
+		if len(want) > 0 {
+			max = -101
+		}
+		for b := range want {
+			if b > max {
+				max = b
+			}
+			negative = negative || b < 0
+		}
+		if m.max() != max || m.negative() != negative {
+			t.Fatalf("step %d: expected max %d and negative %v, got %d and %v", i, max, negative, m.max(), m.negative())
+		}
+	}
+
+	// Deleted ids do not pile up in the heap
+	if len(m.ids) > 2*m.len()+minDenseBuckets+1 {
+		t.Fatalf("expected at most %d ids in the heap, got %d", 2*m.len()+minDenseBuckets+1, len(m.ids))
+	}
+}
@@ -19,3 +19,18 @@ type Node[T,O comparable] interface {
 	// Get all objects assigned to the node
 	Objects() iter.Seq[*Object[T,O]]
 }
+
+// Weighted is implemented by nodes that should receive more or fewer keys
+// than others. A node gets a bucket per unit of weight, so a node of weight
+// 4 receives about four times the keys of a node of weight 1.
+type Weighted interface {
+	Weight() int
+}
+
+// Weight of a node, 1 unless it is Weighted with a larger weight
+func Weight[T,O comparable](node Node[T,O]) int {
+	if w, ok := node.(Weighted); ok {
+		return max(w.Weight(), 1)
+	}
+	return 1
+}
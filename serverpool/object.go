@@ -11,6 +11,18 @@ type Object[T,O comparable] struct {
 	// Unique identifier for the object
 	Id O
 
+	// Names of the nodes the object prefers, in order. The first of them
+	// in the pool is used instead of the node the object's key maps to.
+	Preferred []T
+
+	// Tier of nodes the object belongs on, such as "ssd" or "hdd". Empty
+	// for no tier.
+	Tier string
+
+	// Names of the placement constraints every node of the object must
+	// meet, such as "eu-only" for data residency. Nil for none.
+	Constraints []string
+
 	// Node the object is assigned to
 	node *Node[T,O]
 }
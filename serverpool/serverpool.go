@@ -6,7 +6,9 @@ package serverpool
 
 import (
 	"fmt"
+	"hashing"
 	"iter"
+	"unsafe"
 )
 
 // ServerPoolInterface defines the methods required for a server pool that manages nodes and their associated buckets.
@@ -16,7 +18,14 @@ type ServerPool[T,O comparable] interface {
 	// AddNode adds a node to the server pool with the specified bucket.
 	AddNode(node Node[T, O], bucket int) error
 
-	// RemoveNode removes a node from the server pool.
+	// AddBucket adds another bucket to a node of the server pool.
+	AddBucket(node Node[T, O], bucket int) error
+
+	// NodeBuckets returns the buckets of a node, the one it was added with first.
+	NodeBuckets(node Node[T, O]) []int
+
+	// RemoveNode removes a node and all its buckets from the server pool,
+	// returning the bucket it was added with.
 	RemoveNode(node Node[T, O]) (int, Node[T, O], error)
 
 	// GetNode retrieves a node from the server pool for the specified bucket.
@@ -27,6 +36,9 @@ type ServerPool[T,O comparable] interface {
 
 	// Buckets returns an iterator sequence of all buckets and their associated nodes in the server pool.
 	Buckets() iter.Seq2[int, Node[T, O]]
+
+	// Len returns the number of nodes in the server pool.
+	Len() int
 }
 
 type serverPool[T,O comparable] struct {
@@ -36,27 +48,59 @@ type serverPool[T,O comparable] struct {
 
 	// bucketToNode associates bucket indexes and the corresponding Node in the consistent hash ring.
 	// Each bucket represents a position in the hash space and maps to a specific node responsible for that range.
-	bucketToNode map[int]Node[T, O]
+	// Dense bucket ids are stored in a slice and sparse ones in a map, switching automatically.
+	bucketToNode bucketTable[T, O]
+
+	// Buckets of weighted nodes besides the one in nodeToBucket, nil until
+	// a node has more than one
+	extraBuckets map[T][]int
 }
 
 // Create a new server pool
 func NewServerPool[T, O comparable]() *serverPool[T, O] {
 	return &serverPool[T, O]{
 		nodeToBucket: make(map[T]int),
-		bucketToNode: make(map[int]Node[T, O]),
+		bucketToNode: &sliceBuckets[T, O]{},
+	}
+}
+
+// Switch the bucket table between dense and sparse storage when the
+// layout of bucket ids changes. Both tables know their largest bucket id
+// and whether any is negative without scanning.
+func (sp *serverPool[T, O]) rebalanceStorage() {
+	max, count := sp.bucketToNode.max(), sp.bucketToNode.len()
+	switch table := sp.bucketToNode.(type) {
+	case *sliceBuckets[T, O]:
+		if !dense(max, count) {
+			sp.bucketToNode = copyBuckets(newMapBuckets[T, O](count), bucketTable[T, O](table))
+		}
+	case *mapBuckets[T, O]:
+		// Require headroom before switching back to avoid flapping
+		if dense(2*max, count) && !table.negative() {
+			sp.bucketToNode = copyBuckets(&sliceBuckets[T, O]{}, bucketTable[T, O](table))
+		}
+	}
+}
+
+// Switch to sparse storage before storing a negative bucket id
+func (sp *serverPool[T, O]) sparseFor(bucket int) {
+	if table, ok := sp.bucketToNode.(*sliceBuckets[T, O]); ok && bucket < 0 {
+		sp.bucketToNode = copyBuckets(newMapBuckets[T, O](table.len()+1), bucketTable[T, O](table))
 	}
 }
 
 // Add a new node with a given bucket index to the server pool
 func (sp *serverPool[T, O]) AddNode(node Node[T, O], bucket int) error {
-	if _, ok := sp.bucketToNode[bucket]; ok {
+	if _, ok := sp.bucketToNode.get(bucket); ok {
 		return fmt.Errorf("bucket %d already exists", bucket)
 	}
 	if _, ok := sp.nodeToBucket[node.Name()]; ok {
 		return fmt.Errorf("node already exists")
 	}
 	sp.nodeToBucket[node.Name()] = bucket
-	sp.bucketToNode[bucket] = node
+	sp.sparseFor(bucket)
+	sp.bucketToNode.set(bucket, node)
+	sp.rebalanceStorage()
 
 	return nil
 }
@@ -69,25 +113,64 @@ func (sp *serverPool[T, O]) RemoveNode(node Node[T, O]) (int, Node[T, O], error)
 	}
 	delete(sp.nodeToBucket, node.Name())
 
-	n, ok := sp.bucketToNode[bucket]
+	n, ok := sp.bucketToNode.get(bucket)
 	if !ok {
 		return -1, nil, fmt.Errorf("bucket not found")
 	}
-	delete(sp.bucketToNode, bucket)
+	sp.bucketToNode.delete(bucket)
+	for _, b := range sp.extraBuckets[node.Name()] {
+		sp.bucketToNode.delete(b)
+	}
+	delete(sp.extraBuckets, node.Name())
+	sp.rebalanceStorage()
 
 	return bucket, n, nil
 }
 
+// Add another bucket to a node of the server pool
+func (sp *serverPool[T, O]) AddBucket(node Node[T, O], bucket int) error {
+	if _, ok := sp.bucketToNode.get(bucket); ok {
+		return fmt.Errorf("bucket %d already exists", bucket)
+	}
+	first, ok := sp.nodeToBucket[node.Name()]
+	if !ok {
+		return fmt.Errorf("node not found")
+	}
+	n, _ := sp.bucketToNode.get(first)
+	if sp.extraBuckets == nil {
+		sp.extraBuckets = make(map[T][]int)
+	}
+	sp.extraBuckets[node.Name()] = append(sp.extraBuckets[node.Name()], bucket)
+	sp.sparseFor(bucket)
+	sp.bucketToNode.set(bucket, n)
+	sp.rebalanceStorage()
+
+	return nil
+}
+
+// Get the buckets of a node in the order they were added, nil if the node
+// is not in the pool
+func (sp *serverPool[T, O]) NodeBuckets(node Node[T, O]) []int {
+	bucket, ok := sp.nodeToBucket[node.Name()]
+	if !ok {
+		return nil
+	}
+	return append([]int{bucket}, sp.extraBuckets[node.Name()]...)
+}
+
 // Get the node responsible for the given bucket
 func (sp *serverPool[T, O]) GetNode(bucket int) (Node[T, O], bool) {
-	node, ok := sp.bucketToNode[bucket]
-	return node, ok
+	return sp.bucketToNode.get(bucket)
 }
 
-// Iterate over all nodes in the server pool
+// Iterate over all nodes in the server pool with the bucket each was added
+// with
 func (sp *serverPool[T, O]) Nodes() iter.Seq2[Node[T, O], int] {
 	return func(yield func(Node[T,O], int) bool) {
-		for k, v := range sp.bucketToNode {
+		for k, v := range sp.bucketToNode.all() {
+			if len(sp.extraBuckets) > 0 && sp.nodeToBucket[v.Name()] != k {
+				continue
+			}
 			if !yield(v, k) {
 				return
 			}
@@ -98,10 +181,35 @@ func (sp *serverPool[T, O]) Nodes() iter.Seq2[Node[T, O], int] {
 // Iterate over all buckets in the server pool
 func (sp *serverPool[T, O]) Buckets() iter.Seq2[int, Node[T, O]] {
 	return func(yield func(int, Node[T,O]) bool) {
-		for k, v := range sp.bucketToNode {
+		for k, v := range sp.bucketToNode.all() {
 			if !yield(k, v) {
 				return
 			}
 		}
 	}
 }
+
+// Number of nodes in the server pool
+func (sp *serverPool[T, O]) Len() int {
+	return len(sp.nodeToBucket)
+}
+
+// Estimate the bytes used by the server pool, excluding the nodes themselves
+func (sp *serverPool[T, O]) MemoryUsage() int {
+	var name T
+	size := hashing.MapBytes(len(sp.nodeToBucket), unsafe.Sizeof(name)+unsafe.Sizeof(int(0)))
+	size += hashing.MapBytes(len(sp.extraBuckets), unsafe.Sizeof(name)+unsafe.Sizeof([]int(nil)))
+	for _, buckets := range sp.extraBuckets {
+		size += cap(buckets) * int(unsafe.Sizeof(int(0)))
+	}
+
+	var node Node[T, O]
+	switch table := sp.bucketToNode.(type) {
+	case *sliceBuckets[T, O]:
+		size += cap(table.nodes) * int(unsafe.Sizeof(node))
+	case *mapBuckets[T, O]:
+		size += hashing.MapBytes(len(table.nodes), unsafe.Sizeof(int(0))+unsafe.Sizeof(node))
+		size += cap(table.ids) * int(unsafe.Sizeof(int(0)))
+	}
+	return size
+}
@@ -0,0 +1,3 @@
+module sharding
+
+go 1.23.0
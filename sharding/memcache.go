@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Server selector for memcached clients.
+package sharding
+
+import (
+	"errors"
+	"hashing"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ErrNoServers is returned when picking a server from an empty selector
+var ErrNoServers = errors.New("no servers configured or available")
+
+// MemcacheSelector implements the ServerSelector interface of
+// github.com/bradfitz/gomemcache with a consistent hash ring:
+//
+//	var ss sharding.MemcacheSelector
+//	ss.SetServers("10.0.0.1:11211", "10.0.0.2:11211")
+//	client := memcache.NewFromSelector(&ss)
+type MemcacheSelector struct {
+	once sync.Once
+	ring *Ring
+
+	mu    sync.RWMutex
+	addrs map[string]net.Addr
+}
+
+// Resolve the servers and make them the servers of the selector. Servers
+// containing a slash are unix socket paths, others are TCP host:port pairs.
+func (ms *MemcacheSelector) SetServers(servers ...string) error {
+	addrs := make(map[string]net.Addr, len(servers))
+	for _, s := range servers {
+		var addr net.Addr
+		var err error
+		if strings.Contains(s, "/") {
+			addr, err = net.ResolveUnixAddr("unix", s)
+		} else {
+			addr, err = net.ResolveTCPAddr("tcp", s)
+		}
+		if err != nil {
+			return err
+		}
+		addrs[s] = addr
+	}
+
+	ms.once.Do(func() { ms.ring = NewRing(hashing.DefaultHashAlgorithm) })
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.ring.Set(servers)
+	ms.addrs = addrs
+	return nil
+}
+
+// PickServer returns the server of the key
+func (ms *MemcacheSelector) PickServer(key string) (net.Addr, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ms.ring == nil {
+		return nil, ErrNoServers
+	}
+	addr, ok := ms.addrs[ms.ring.Get(key)]
+	if !ok {
+		return nil, ErrNoServers
+	}
+	return addr, nil
+}
+
+// Each calls f for every server, stopping at the first error
+func (ms *MemcacheSelector) Each(f func(net.Addr) error) error {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ms.ring == nil {
+		return nil
+	}
+	for _, s := range ms.ring.Servers() {
+		if err := f(ms.addrs[s]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
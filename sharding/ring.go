@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// sharding package adapts the consistent hashers to the server selection
+// hooks of memcached and Redis clients.
+package sharding
+
+import (
+	"consistenthash"
+	"hashing"
+	"slices"
+	"sync"
+)
+
+// Ring maps keys to servers with a memento consistent hasher. Updating the
+// server list keeps the buckets of servers that remain, so only keys of
+// removed servers move, and only as many keys as needed move to added
+// servers. Servers are added in sorted order, so rings given the same
+// sequence of updates map keys the same way. A Ring is safe for concurrent use.
+type Ring struct {
+	mu sync.RWMutex
+	ch consistenthash.ConsistentHasher
+
+	buckets map[string]int
+	servers map[int]string
+}
+
+// NewRing creates an empty ring hashing keys with the given algorithm
+func NewRing(algo hashing.HashAlgorithm) *Ring {
+	return &Ring{ch: consistenthash.NewConsistentHasherWithAlgo(algo),
+		buckets: make(map[string]int), servers: make(map[int]string)}
+}
+
+// Set the servers of the ring, removing servers not in the list
+func (r *Ring) Set(servers []string) {
+	want := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		want[s] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var removed, added []string
+	for s := range r.buckets {
+		if !want[s] {
+			removed = append(removed, s)
+		}
+	}
+	for s := range want {
+		if _, ok := r.buckets[s]; !ok {
+			added = append(added, s)
+		}
+	}
+	slices.Sort(removed)
+	slices.Sort(added)
+
+	for _, s := range removed {
+		bucket := r.buckets[s]
+		r.ch.RemoveBucket(bucket)
+		delete(r.buckets, s)
+		delete(r.servers, bucket)
+	}
+	for _, s := range added {
+		bucket := r.ch.AddBucket()
+		r.buckets[s] = bucket
+		r.servers[bucket] = s
+	}
+}
+
+// Get the server of the key, empty if the ring has no servers
+func (r *Ring) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.servers[r.ch.GetBucket(key)]
+}
+
+// Servers of the ring in sorted order
+func (r *Ring) Servers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	servers := make([]string, 0, len(r.buckets))
+	for s := range r.buckets {
+		servers = append(servers, s)
+	}
+	slices.Sort(servers)
+	return servers
+}
+
+// Update sets the servers and returns the ring. It matches the
+// NewConsistentHash hook of go-redis ring options, which is called with
+// the live shards whenever they change:
+//
+//	ring := sharding.NewRing(hashing.DefaultHashAlgorithm)
+//	opts := &redis.RingOptions{
+//		NewConsistentHash: func(shards []string) redis.ConsistentHash {
+//			return ring.Update(shards)
+//		},
+//	}
+func (r *Ring) Update(shards []string) *Ring {
+	r.Set(shards)
+	return r
+}
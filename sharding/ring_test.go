@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package sharding
+
+import (
+	"hashing"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestRingRemoveServer(t *testing.T) {
+	r := NewRing(hashing.DefaultHashAlgorithm)
+	r.Set([]string{"a:1", "b:1", "c:1", "d:1"})
+
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key] = r.Get(key)
+	}
+
+	// Only keys of the removed server move
+	r.Update([]string{"d:1", "a:1", "c:1"})
+	for key, server := range before {
+		got := r.Get(key)
+		if server != "b:1" && got != server {
+			t.Fatalf("Get(%q) moved from %s to %s", key, server, got)
+		}
+		if got == "b:1" {
+			t.Fatalf("Get(%q) returned removed server", key)
+		}
+	}
+}
+
+func TestMemcacheSelector(t *testing.T) {
+	var ms MemcacheSelector
+	if _, err := ms.PickServer("key"); err != ErrNoServers {
+		t.Fatalf("PickServer() error = %v, want ErrNoServers", err)
+	}
+
+	if err := ms.SetServers("127.0.0.1:11211", "127.0.0.2:11211", "/tmp/memcached.sock"); err != nil {
+		t.Fatalf("SetServers() error = %v", err)
+	}
+	addr, err := ms.PickServer("key")
+	if err != nil || addr == nil {
+		t.Fatalf("PickServer() = %v, %v", addr, err)
+	}
+
+	n := 0
+	ms.Each(func(net.Addr) error { n++; return nil })
+	if n != 3 {
+		t.Fatalf("Each() visited %d servers, want 3", n)
+	}
+}
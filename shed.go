@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Handling of lookups while the load balancer has no nodes
+
+package main
+
+import (
+	"consistenthash"
+	"context"
+	"errors"
+	"fmt"
+	"serverpool"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClusterUnavailable is returned by GetNode when there are no nodes to
+// map keys to. Draining nodes no longer take keys, so they do not count.
+// It wraps consistenthash.ErrEmptyRing.
+var ErrClusterUnavailable = fmt.Errorf("no nodes available: %w", consistenthash.ErrEmptyRing)
+
+// ErrBucketNotFound is returned when a key maps to a bucket without a node
+var ErrBucketNotFound = errors.New("node not found for bucket")
+
+// Channel of waits that are already over
+var closed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// Lookups while there are no nodes
+type shedding[T, O comparable] struct {
+	// Node serving every key while there are no nodes, if set
+	fallback serverpool.Node[T, O]
+
+	// How long a lookup waits for a node to be added
+	wait time.Duration
+
+	// Set after each change while there are nodes. Waiting lookups check it
+	// rather than the hasher, which is written by the goroutine adding nodes.
+	available atomic.Bool
+
+	// Closed once nodes are added, nil while no lookup is waiting
+	mu    sync.Mutex
+	ready chan struct{}
+}
+
+// Channel closed once nodes are added
+func (s *shedding[T, O]) waiter() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.available.Load() {
+		return closed
+	}
+	if s.ready == nil {
+		s.ready = make(chan struct{})
+	}
+	return s.ready
+}
+
+// Record whether there are nodes and release the lookups waiting for them
+func (s *shedding[T, O]) update(available bool) {
+	s.available.Store(available)
+	if !available {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready != nil {
+		close(s.ready)
+		s.ready = nil
+	}
+}
+
+// Look up a key while there are no nodes: wait for a node to be added if
+// configured, then serve from the fallback node or fail
+func (lb *loadBalancer[T, O]) shed(key string, mapKey func(string) (serverpool.Node[T, O], error)) (serverpool.Node[T, O], error) {
+	if lb.shedding.wait > 0 {
+		timer := time.NewTimer(lb.shedding.wait)
+		defer timer.Stop()
+		select {
+		case <-lb.shedding.waiter():
+			if node, err := mapKey(key); err != ErrClusterUnavailable {
+				return node, err
+			}
+		case <-timer.C:
+		}
+	}
+	if lb.shedding.fallback != nil {
+		if m := lb.profiler.metrics; m != nil {
+			m.misses.Add(1)
+		}
+		return lb.shedding.fallback, nil
+	}
+	return nil, ErrClusterUnavailable
+}
+
+// GetNodeWait maps a key like GetNode, but while there are no nodes it
+// blocks until a node is added or ctx is done, for lookups during a cold
+// start before discovery has added the nodes. It does not fall back to the
+// fallback node. Once ctx is done the error wraps both
+// ErrClusterUnavailable and the error of ctx.
+func (lb *loadBalancer[T, O]) GetNodeWait(ctx context.Context, key string) (serverpool.Node[T, O], error) {
+	return lb.getNodeWait(ctx, key, lb.lookupKey)
+}
+
+func (lb *loadBalancer[T, O]) getNodeWait(ctx context.Context, key string, mapKey func(string) (serverpool.Node[T, O], error)) (serverpool.Node[T, O], error) {
+	if err := lb.faults.LookupError(); err != nil {
+		return nil, err
+	}
+	for {
+		node, err := mapKey(key)
+		if err != ErrClusterUnavailable {
+			return node, err
+		}
+
+		// Nodes may be removed again before the lookup gets to them
+		select {
+		case <-lb.shedding.waiter():
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrClusterUnavailable, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestLoadShedding(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	if _, err := lb.GetNode("key"); !errors.Is(err, ErrClusterUnavailable) {
+		t.Fatalf("expected ErrClusterUnavailable, got %v", err)
+	}
+
+	fallback := &mockNode{ID: "fallback"}
+	lb = NewLoadBalancerWithOptions(WithFallbackNode[string, string](fallback))
+	if node, err := lb.GetNode("key"); err != nil || node != fallback {
+		t.Fatalf("expected the fallback node, got %v, %v", node, err)
+	}
+
+	// A waiting lookup gets the node added while it waits
+	lb = NewLoadBalancerWithOptions(WithUnavailableWait[string, string](time.Second),
+		WithFallbackNode[string, string](fallback))
+	node := &mockNode{ID: "node1"}
+	added := make(chan error)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, err := lb.AddNodes([]serverpool.Node[string, string]{node})
+		added <- err
+	}()
+	if got, err := lb.GetNode("key"); err != nil || got != node {
+		t.Fatalf("expected %v, got %v, %v", node, got, err)
+	}
+	if err := <-added; err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Without a node the lookup falls back once the wait is over
+	lb = NewLoadBalancerWithOptions(WithUnavailableWait[string, string](10*time.Millisecond),
+		WithFallbackNode[string, string](fallback))
+	if got, err := lb.GetNode("key"); err != nil || got != fallback {
+		t.Fatalf("expected the fallback node, got %v, %v", got, err)
+	}
+}
+
+func TestConcurrentShedding(t *testing.T) {
+	lb := NewConcurrentLoadBalancer(WithUnavailableWait[string, string](5 * time.Second))
+
+	// A lookup waiting for nodes does not block adding them
+	done := make(chan error)
+	go func() {
+		_, err := lb.GetNode("key")
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{&mockNode{ID: "node0"}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the lookup to finish once a node was added")
+	}
+}
+
+func TestGetNodeWait(t *testing.T) {
+	lb := NewConcurrentLoadBalancer[string, string]()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := lb.GetNodeWait(ctx, "key"); !errors.Is(err, ErrClusterUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+
+	// A node added while waiting serves the key
+	got := make(chan serverpool.Node[string, string])
+	go func() {
+		node, err := lb.GetNodeWait(context.Background(), "key")
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		got <- node
+	}()
+	time.Sleep(10 * time.Millisecond)
+	node := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := <-got; n != node {
+		t.Fatalf("expected node1, got %v", n)
+	}
+
+	if _, err := lb.GetNodeWait(context.Background(), ""); err == nil {
+		t.Fatalf("expected an error for an empty key")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Line input for the CLI with editing, history and completion on terminals
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// lineReader shows a prompt and reads a line of input. Completions lists
+// the candidates offered when Tab is pressed, nil for none.
+type lineReader interface {
+	readLine(prompt string, completions func() []string) (string, error)
+}
+
+// Reads lines from a non-interactive input
+type bufferedReader struct {
+	*bufio.Reader
+}
+
+func (b bufferedReader) readLine(prompt string, _ func() []string) (string, error) {
+	out.prompt(prompt)
+	return readNewLine(b.Reader)
+}
+
+// Reads lines from a terminal in raw mode with line editing, history on the
+// arrow keys and completion on Tab
+type terminalReader struct {
+	*term.Terminal
+
+	// Candidates for the line being read
+	completions func() []string
+}
+
+// Open the terminal on stdin and stdout for line editing.
+// The returned function restores the terminal to its previous state.
+func newTerminalReader() (*terminalReader, func(), error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	screen := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+
+	t := &terminalReader{Terminal: term.NewTerminal(screen, "")}
+	t.AutoCompleteCallback = t.complete
+	return t, func() { term.Restore(fd, state) }, nil
+}
+
+// Check if both stdin and stdout are terminals
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+func (t *terminalReader) readLine(prompt string, completions func() []string) (string, error) {
+	t.completions = completions
+	t.SetPrompt(prompt)
+	return t.ReadLine()
+}
+
+// Complete the line on Tab to the longest prefix shared by all candidates
+// that start with it, listing the candidates when there is more than one
+func (t *terminalReader) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || t.completions == nil {
+		return "", 0, false
+	}
+
+	var matches []string
+	for _, c := range t.completions() {
+		if strings.HasPrefix(c, line) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(matches) > 1 && prefix == line {
+		t.Write([]byte(strings.Join(matches, "  ") + "\n"))
+	}
+	return prefix, len(prefix), true
+}
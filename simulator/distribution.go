@@ -0,0 +1,236 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// simulator package provides key distributions for simulated workloads.
+package simulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KeyDistribution generates the keys accessed by a simulated workload
+type KeyDistribution interface {
+	// Next returns the next key, false once the distribution is exhausted
+	Next() (string, bool)
+}
+
+// Factory creates a distribution over the given number of keys.
+// Args are the colon separated parameters following the name in a spec.
+type Factory func(r *rand.Rand, keys int, args []string) (KeyDistribution, error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Factory{
+		"uniform": newUniformFromArgs,
+		"zipf":    newZipfFromArgs,
+		"hotset":  newHotSetFromArgs,
+		"trace":   newTraceFromArgs,
+		"clf":     newCLFFromArgs,
+		"jsonl":   newJSONLFromArgs,
+		"pcap":    newPcapFromArgs,
+	}
+)
+
+// Register a custom distribution under the given name
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = factory
+}
+
+// Names of all registered distributions in sorted order
+func Names() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Parse creates a distribution from a spec of the form name[:arg...], e.g.
+// "uniform", "zipf:1.1", "hotset:0.2:0.8", "trace:/path/to/keys.txt" or
+// "clf:path:/var/log/access.log"
+func Parse(spec string, r *rand.Rand, keys int) (KeyDistribution, error) {
+	name, rest, _ := strings.Cut(spec, ":")
+	var args []string
+	if rest != "" {
+		args = strings.Split(rest, ":")
+	}
+
+	registryLock.RLock()
+	factory, ok := registry[name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown distribution %q, expected one of %v", name, Names())
+	}
+	if keys <= 0 {
+		return nil, fmt.Errorf("number of keys must be positive")
+	}
+	return factory(r, keys, args)
+}
+
+// Name of the synthetic key with the given index
+func key(i int) string {
+	return "key-" + strconv.Itoa(i)
+}
+
+// Parse an optional float argument
+func floatArg(args []string, i int, def float64) (float64, error) {
+	if len(args) <= i {
+		return def, nil
+	}
+	return strconv.ParseFloat(args[i], 64)
+}
+
+// Uniform accesses every key with the same probability
+type Uniform struct {
+	r    *rand.Rand
+	keys int
+}
+
+func NewUniform(r *rand.Rand, keys int) *Uniform {
+	return &Uniform{r: r, keys: keys}
+}
+
+func newUniformFromArgs(r *rand.Rand, keys int, _ []string) (KeyDistribution, error) {
+	return NewUniform(r, keys), nil
+}
+
+func (u *Uniform) Next() (string, bool) {
+	return key(u.r.Intn(u.keys)), true
+}
+
+// Zipf accesses key k with probability proportional to 1/(k+1)^s,
+// so a few keys receive most accesses
+type Zipf struct {
+	z *rand.Zipf
+}
+
+// NewZipf creates a zipfian distribution with exponent s > 1
+func NewZipf(r *rand.Rand, keys int, s float64) (*Zipf, error) {
+	if s <= 1 {
+		return nil, fmt.Errorf("zipf exponent must be greater than 1, got %v", s)
+	}
+	return &Zipf{z: rand.NewZipf(r, s, 1, uint64(keys-1))}, nil
+}
+
+func newZipfFromArgs(r *rand.Rand, keys int, args []string) (KeyDistribution, error) {
+	s, err := floatArg(args, 0, 1.1)
+	if err != nil {
+		return nil, err
+	}
+	return NewZipf(r, keys, s)
+}
+
+func (z *Zipf) Next() (string, bool) {
+	return key(int(z.z.Uint64())), true
+}
+
+// HotSet sends a fixed share of accesses to a small set of hot keys and
+// spreads the rest uniformly over the remaining keys
+type HotSet struct {
+	r *rand.Rand
+
+	keys int
+
+	// Number of hot keys
+	hot int
+
+	// Probability that an access goes to a hot key
+	hotProbability float64
+}
+
+// NewHotSet creates a distribution where hotFraction of the keys receive
+// hotProbability of the accesses
+func NewHotSet(r *rand.Rand, keys int, hotFraction, hotProbability float64) (*HotSet, error) {
+	if hotFraction <= 0 || hotFraction > 1 || hotProbability < 0 || hotProbability > 1 {
+		return nil, fmt.Errorf("hot set fraction and probability must be in (0, 1]")
+	}
+	hot := max(1, int(float64(keys)*hotFraction))
+	return &HotSet{r: r, keys: keys, hot: hot, hotProbability: hotProbability}, nil
+}
+
+func newHotSetFromArgs(r *rand.Rand, keys int, args []string) (KeyDistribution, error) {
+	fraction, err := floatArg(args, 0, 0.2)
+	if err != nil {
+		return nil, err
+	}
+	probability, err := floatArg(args, 1, 0.8)
+	if err != nil {
+		return nil, err
+	}
+	return NewHotSet(r, keys, fraction, probability)
+}
+
+func (h *HotSet) Next() (string, bool) {
+	if h.hot == h.keys || h.r.Float64() < h.hotProbability {
+		return key(h.r.Intn(h.hot)), true
+	}
+	return key(h.hot + h.r.Intn(h.keys-h.hot)), true
+}
+
+// Trace replays keys recorded one per line, skipping blank lines
+type Trace struct {
+	scanner *bufio.Scanner
+
+	// Key of a line, the line itself for plain traces
+	extract LineExtractor
+
+	// Lines without a key
+	skipped int
+}
+
+func NewTrace(r io.Reader) *Trace {
+	return NewLogTrace(r, func(line string) (string, bool) { return line, true })
+}
+
+// Open a trace file. The file is read until its last key and never closed
+// early, which is fine for the lifetime of a simulation.
+func newTraceFromArgs(_ *rand.Rand, _ int, args []string) (KeyDistribution, error) {
+	f, err := openArgs("trace", args)
+	if err != nil {
+		return nil, err
+	}
+	return NewTrace(f), nil
+}
+
+func (t *Trace) Next() (string, bool) {
+	for t.scanner.Scan() {
+		line := strings.TrimSpace(t.scanner.Text())
+		if line == "" {
+			continue
+		}
+		if k, ok := t.extract(line); ok && k != "" {
+			return k, true
+		}
+		t.skipped++
+	}
+	return "", false
+}
+
+// Skipped returns the number of non-blank lines read without a key, such
+// as malformed log lines
+func (t *Trace) Skipped() int {
+	return t.skipped
+}
+
+// Open the file named by the colon separated args of a spec, which may
+// contain colons
+func openArgs(kind string, args []string) (*os.File, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s requires a file name", kind)
+	}
+	return os.Open(strings.Join(args, ":"))
+}
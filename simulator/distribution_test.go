@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package simulator
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "uniform"},
+		{spec: "zipf"},
+		{spec: "zipf:1.5"},
+		{spec: "zipf:0.5", wantErr: true},
+		{spec: "hotset:0.1:0.9"},
+		{spec: "hotset:2", wantErr: true},
+		{spec: "trace", wantErr: true},
+		{spec: "clf:path", wantErr: true},
+		{spec: "clf:referrer:access.log", wantErr: true},
+		{spec: "jsonl:user", wantErr: true},
+		{spec: "jsonl:user..id:app.log", wantErr: true},
+		{spec: "pcap:port:capture.pcap", wantErr: true},
+		{spec: "gaussian", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := Parse(tt.spec, r, 100)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHotSet(t *testing.T) {
+	h, err := NewHotSet(rand.New(rand.NewSource(1)), 100, 0.1, 0.9)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	hot := 0
+	for i := 0; i < 10000; i++ {
+		k, _ := h.Next()
+		if len(k) == len("key-0") {
+			hot++
+		}
+	}
+	if hot < 8800 || hot > 9200 {
+		t.Errorf("expected about 9000 accesses to hot keys, got %d", hot)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	tr := NewTrace(strings.NewReader("a\n\n b \nc\n"))
+
+	var keys []string
+	for k, ok := tr.Next(); ok; k, ok = tr.Next() {
+		keys = append(keys, k)
+	}
+	if strings.Join(keys, ",") != "a,b,c" {
+		t.Errorf("expected keys a,b,c, got %v", keys)
+	}
+}
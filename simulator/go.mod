@@ -0,0 +1,3 @@
+module simulator
+
+go 1.23.0
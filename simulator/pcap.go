@@ -0,0 +1,299 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Replay of the keys of packets captured in pcap files
+
+package simulator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"net/netip"
+	"slices"
+)
+
+// Link types of the captures read
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLinuxSLL = 113
+)
+
+// Largest packet read from a capture
+const maxPacketSize = 1 << 18
+
+// Packet is an IP packet of a capture
+type Packet struct {
+	Src, Dst netip.Addr
+
+	// Ports of TCP and UDP packets, 0 for other protocols
+	SrcPort, DstPort uint16
+
+	// TCP or UDP payload, empty for other protocols and fragments
+	Payload []byte
+}
+
+// PacketExtractor extracts the key of a packet, false if it has none
+type PacketExtractor func(p Packet) (string, bool)
+
+// Extractors of the keys of packets by name
+var packetFields = map[string]PacketExtractor{
+	"src": func(p Packet) (string, bool) { return p.Src.String(), true },
+	"dst": func(p Packet) (string, bool) { return p.Dst.String(), true },
+	"flow": func(p Packet) (string, bool) {
+		src := netip.AddrPortFrom(p.Src, p.SrcPort)
+		dst := netip.AddrPortFrom(p.Dst, p.DstPort)
+		return src.String() + "-" + dst.String(), true
+	},
+	"http-path": func(p Packet) (string, bool) {
+		line, _, _ := bytes.Cut(p.Payload, []byte("\r\n"))
+		request := bytes.Fields(line)
+		if len(request) != 3 || !bytes.HasPrefix(request[2], []byte("HTTP/")) {
+			return "", false
+		}
+		return string(request[1]), true
+	},
+	"http-host": func(p Packet) (string, bool) {
+		head, _, _ := bytes.Cut(p.Payload, []byte("\r\n\r\n"))
+		for _, line := range bytes.Split(head, []byte("\r\n"))[1:] {
+			name, value, ok := bytes.Cut(line, []byte(":"))
+			if ok && bytes.EqualFold(bytes.TrimSpace(name), []byte("Host")) {
+				return string(bytes.TrimSpace(value)), true
+			}
+		}
+		return "", false
+	},
+}
+
+// PacketField extracts a field of packets: src or dst for the addresses,
+// flow for the addresses and ports, or http-path or http-host for the path
+// and Host header of HTTP requests starting in the packet
+func PacketField(field string) (PacketExtractor, error) {
+	extract, ok := packetFields[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown packet field %q, expected one of %v", field, slices.Sorted(maps.Keys(packetFields)))
+	}
+	return extract, nil
+}
+
+// Pcap replays the keys of the packets of a capture in the pcap format of
+// tcpdump, captured on Ethernet, loopback, Linux cooked or raw IP links.
+// Packets are not reassembled, so a key must be within a packet.
+type Pcap struct {
+	r       *bufio.Reader
+	order   binary.ByteOrder
+	link    uint32
+	extract PacketExtractor
+	buf     []byte
+
+	// Packets without a key and the error that ended the capture
+	skipped int
+	err     error
+}
+
+// NewPcap reads the header of a capture and replays the keys extract
+// finds in its packets
+func NewPcap(r io.Reader, extract PacketExtractor) (*Pcap, error) {
+	p := &Pcap{r: bufio.NewReader(r), extract: extract}
+	var header [24]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		return nil, fmt.Errorf("reading pcap header: %w", err)
+	}
+	// Microsecond and nanosecond captures only differ in their timestamps
+	switch binary.LittleEndian.Uint32(header[:4]) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		p.order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		p.order = binary.BigEndian
+	default:
+		return nil, errors.New("not a pcap file, pcapng is not supported")
+	}
+	p.link = p.order.Uint32(header[20:]) & 0xffff
+	switch p.link {
+	case linkNull, linkEthernet, linkRaw, linkLinuxSLL:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", p.link)
+	}
+	return p, nil
+}
+
+func (p *Pcap) Next() (string, bool) {
+	for p.err == nil {
+		data, err := p.read()
+		if err != nil {
+			if err != io.EOF {
+				p.err = err
+			}
+			break
+		}
+		if packet, ok := p.decode(data); ok {
+			if k, ok := p.extract(packet); ok && k != "" {
+				return k, true
+			}
+		}
+		p.skipped++
+	}
+	return "", false
+}
+
+// Skipped returns the number of packets read without a key, such as
+// packets of other protocols
+func (p *Pcap) Skipped() int {
+	return p.skipped
+}
+
+// Err returns the error that ended the capture early, nil at its end
+func (p *Pcap) Err() error {
+	return p.err
+}
+
+// Read the data of the next packet record, valid until the next read
+func (p *Pcap) read() ([]byte, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated pcap record header")
+		}
+		return nil, err
+	}
+	size := p.order.Uint32(header[8:])
+	if size > maxPacketSize {
+		return nil, fmt.Errorf("pcap record of %d bytes is too large", size)
+	}
+	if cap(p.buf) < int(size) {
+		p.buf = make([]byte, size)
+	}
+	p.buf = p.buf[:size]
+	if _, err := io.ReadFull(p.r, p.buf); err != nil {
+		return nil, errors.New("truncated pcap record")
+	}
+	return p.buf, nil
+}
+
+// Decode the IP packet of the link layer frame data, false if it is not
+// one
+func (p *Pcap) decode(data []byte) (Packet, bool) {
+	var ethertype uint16
+	switch p.link {
+	case linkNull:
+		if len(data) < 4 {
+			return Packet{}, false
+		}
+		// Address family in the byte order of the capturing host
+		family := p.order.Uint32(data)
+		data = data[4:]
+		switch family {
+		case 2:
+			ethertype = 0x0800
+		case 10, 24, 28, 30:
+			ethertype = 0x86dd
+		}
+	case linkEthernet:
+		if len(data) < 14 {
+			return Packet{}, false
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[12:]), data[14:]
+		// VLAN tags
+		for (ethertype == 0x8100 || ethertype == 0x88a8) && len(data) >= 4 {
+			ethertype, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+	case linkLinuxSLL:
+		if len(data) < 16 {
+			return Packet{}, false
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[14:]), data[16:]
+	case linkRaw:
+		// The IP version tells the protocol
+		if len(data) > 0 && data[0]>>4 == 4 {
+			ethertype = 0x0800
+		} else if len(data) > 0 && data[0]>>4 == 6 {
+			ethertype = 0x86dd
+		}
+	}
+
+	var packet Packet
+	var proto byte
+	switch ethertype {
+	case 0x0800:
+		if len(data) < 20 || data[0]>>4 != 4 {
+			return Packet{}, false
+		}
+		headerLen, total := int(data[0]&0xf)*4, int(binary.BigEndian.Uint16(data[2:]))
+		if headerLen < 20 || total < headerLen || headerLen > len(data) {
+			return Packet{}, false
+		}
+		packet.Src = netip.AddrFrom4([4]byte(data[12:16]))
+		packet.Dst = netip.AddrFrom4([4]byte(data[16:20]))
+		proto = data[9]
+		// Only the first fragment has the transport header
+		if binary.BigEndian.Uint16(data[6:])&0x1fff != 0 {
+			return packet, true
+		}
+		// Captures may cut packets short of their length
+		data = data[headerLen:min(total, len(data))]
+	case 0x86dd:
+		if len(data) < 40 || data[0]>>4 != 6 {
+			return Packet{}, false
+		}
+		total := 40 + int(binary.BigEndian.Uint16(data[4:]))
+		packet.Src = netip.AddrFrom16([16]byte(data[8:24]))
+		packet.Dst = netip.AddrFrom16([16]byte(data[24:40]))
+		// Extension headers are not followed
+		proto = data[6]
+		data = data[40:min(total, len(data))]
+	default:
+		return Packet{}, false
+	}
+
+	switch proto {
+	case 6:
+		if len(data) < 20 {
+			return packet, true
+		}
+		offset := int(data[12]>>4) * 4
+		if offset < 20 || offset > len(data) {
+			return packet, true
+		}
+		packet.Payload = data[offset:]
+	case 17:
+		if len(data) < 8 {
+			return packet, true
+		}
+		packet.Payload = data[8:]
+	default:
+		return packet, true
+	}
+	packet.SrcPort = binary.BigEndian.Uint16(data)
+	packet.DstPort = binary.BigEndian.Uint16(data[2:])
+	return packet, true
+}
+
+// Open a capture, from a spec such as pcap:http-path:capture.pcap
+func newPcapFromArgs(_ *rand.Rand, _ int, args []string) (KeyDistribution, error) {
+	field, file, err := fieldArgs("pcap", args)
+	if err != nil {
+		return nil, err
+	}
+	extract, err := PacketField(field)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openArgs("pcap", file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewPcap(f, extract)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	return p, nil
+}
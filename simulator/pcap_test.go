@@ -0,0 +1,118 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package simulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// Capture of frames on a link, in the byte order given
+func capture(order binary.AppendByteOrder, link uint32, frames ...[]byte) []byte {
+	var b []byte
+	b = order.AppendUint32(b, 0xa1b2c3d4)
+	b = order.AppendUint16(b, 2)
+	b = order.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = order.AppendUint32(b, 65535)
+	b = order.AppendUint32(b, link)
+	for _, frame := range frames {
+		b = append(b, make([]byte, 8)...)
+		b = order.AppendUint32(b, uint32(len(frame)))
+		b = order.AppendUint32(b, uint32(len(frame)))
+		b = append(b, frame...)
+	}
+	return b
+}
+
+// Ethernet frame of an IPv4 packet carrying payload over TCP or UDP
+func ipv4Frame(proto byte, src, dst string, srcPort, dstPort uint16, payload string) []byte {
+	transport := binary.BigEndian.AppendUint16(nil, srcPort)
+	transport = binary.BigEndian.AppendUint16(transport, dstPort)
+	if proto == 6 {
+		transport = append(transport, make([]byte, 8)...)
+		transport = append(transport, 5<<4, 0x18, 0, 0, 0, 0, 0, 0)
+	} else {
+		transport = append(transport, 0, byte(8+len(payload)), 0, 0)
+	}
+	transport = append(transport, payload...)
+
+	ip := []byte{0x45, 0}
+	ip = binary.BigEndian.AppendUint16(ip, uint16(20+len(transport)))
+	ip = append(ip, 0, 0, 0x40, 0, 64, proto, 0, 0)
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	ip = append(append(append(ip, s[:]...), d[:]...), transport...)
+
+	frame := append(make([]byte, 12), 0x08, 0x00)
+	return append(frame, ip...)
+}
+
+func TestPcap(t *testing.T) {
+	request := "GET /users/42 HTTP/1.1\r\nhost: api.example.com\r\nAccept: */*\r\n\r\n"
+	arp := append(make([]byte, 12), 0x08, 0x06, 0, 1)
+	data := capture(binary.LittleEndian, linkEthernet,
+		ipv4Frame(6, "10.0.0.1", "10.0.0.9", 40000, 80, request),
+		arp,
+		ipv4Frame(17, "10.0.0.2", "10.0.0.9", 5353, 53, "query"),
+		ipv4Frame(6, "10.0.0.3", "10.0.0.9", 40001, 80, "HTTP/1.1 200 OK\r\n\r\n"))
+
+	tests := []struct {
+		field   string
+		want    string
+		skipped int
+	}{
+		{field: "src", want: "10.0.0.1,10.0.0.2,10.0.0.3", skipped: 1},
+		{field: "flow", want: "10.0.0.1:40000-10.0.0.9:80,10.0.0.2:5353-10.0.0.9:53,10.0.0.3:40001-10.0.0.9:80", skipped: 1},
+		{field: "http-path", want: "/users/42", skipped: 3},
+		{field: "http-host", want: "api.example.com", skipped: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			extract, err := PacketField(tt.field)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			p, err := NewPcap(bytes.NewReader(data), extract)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got := strings.Join(replay(p), ","); got != tt.want {
+				t.Errorf("expected keys %s, got %s", tt.want, got)
+			}
+			if p.Skipped() != tt.skipped || p.Err() != nil {
+				t.Errorf("expected %d packets skipped, got %d and %v", tt.skipped, p.Skipped(), p.Err())
+			}
+		})
+	}
+}
+
+func TestPcapRawBigEndian(t *testing.T) {
+	// Raw IP frames are the packets without the Ethernet header
+	frame := ipv4Frame(17, "192.168.1.1", "192.168.1.2", 1, 2, "x")[14:]
+	data := capture(binary.BigEndian, linkRaw, frame, frame)
+	extract, _ := PacketField("dst")
+
+	// A record cut short ends the capture with an error
+	p, err := NewPcap(bytes.NewReader(data[:len(data)-1]), extract)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := strings.Join(replay(p), ","); got != "192.168.1.2" {
+		t.Errorf("expected key 192.168.1.2, got %s", got)
+	}
+	if p.Err() == nil {
+		t.Errorf("expected an error for a truncated record")
+	}
+
+	if _, err := NewPcap(strings.NewReader(strings.Repeat("\x0a\x0d\x0d\x0a", 6)), extract); err == nil {
+		t.Errorf("expected an error for a pcapng file")
+	}
+	if _, err := NewPcap(bytes.NewReader(capture(binary.LittleEndian, 105)), extract); err == nil {
+		t.Errorf("expected an error for an unsupported link type")
+	}
+}
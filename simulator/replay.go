@@ -0,0 +1,192 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Replay of the keys of production access logs
+
+package simulator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"strings"
+)
+
+// Longest log line read, longer lines end the trace
+const maxLineSize = 1 << 20
+
+// LineExtractor extracts the key of a log line, false if it has none
+type LineExtractor func(line string) (string, bool)
+
+// NewLogTrace replays the keys extract finds in the lines of r, skipping
+// blank lines and lines without a key
+func NewLogTrace(r io.Reader, extract LineExtractor) *Trace {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	return &Trace{scanner: scanner, extract: extract}
+}
+
+// Fields of a Common Log Format line. Request is split into method, path
+// and protocol, and referer and agent are those of the Combined Log Format.
+var clfFields = []string{"host", "ident", "user", "time", "request", "status", "bytes", "referer", "agent"}
+
+// Fields of a Common Log Format line without those of the Combined one
+const clfCommonFields = 7
+
+// CLFField extracts a field of Common or Combined Log Format lines, such
+// as
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326
+//
+// The field is one of host, ident, user, time, request, method, path,
+// protocol, status, bytes, referer or agent. Fields logged as "-" and
+// lines with fewer fields than the Common Log Format have no key.
+func CLFField(field string) (LineExtractor, error) {
+	part := ""
+	switch field {
+	case "method", "path", "protocol":
+		field, part = "request", field
+	}
+	i := slices.Index(clfFields, field)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown log field %q, expected one of %v or method, path or protocol", field, clfFields)
+	}
+	return func(line string) (string, bool) {
+		fields := splitCLF(line)
+		if len(fields) < clfCommonFields || len(fields) <= i || fields[i] == "-" {
+			return "", false
+		}
+		if part == "" {
+			return fields[i], true
+		}
+		// Paths may have spaces, escaped or not
+		method, rest, _ := strings.Cut(fields[i], " ")
+		path, protocol, found := cutLast(rest, " ")
+		switch {
+		case !found:
+			return "", false
+		case part == "method":
+			return method, true
+		case part == "path":
+			return path, true
+		}
+		return protocol, true
+	}, nil
+}
+
+// Cut s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Split a log line into fields separated by spaces, keeping the spaces of
+// fields in brackets or double quotes, which are stripped along with the
+// backslashes escaping quotes
+func splitCLF(line string) []string {
+	var fields []string
+	for line = strings.TrimLeft(line, " "); line != ""; line = strings.TrimLeft(line, " ") {
+		end := " "
+		switch line[0] {
+		case '[':
+			end, line = "]", line[1:]
+		case '"':
+			end, line = `"`, line[1:]
+		}
+		field, rest, _ := strings.Cut(line, end)
+		// Quotes within quoted fields are escaped with a backslash
+		for end == `"` && strings.HasSuffix(field, `\`) {
+			more, after, found := strings.Cut(rest, end)
+			field, rest = field+end+more, after
+			if !found {
+				break
+			}
+		}
+		if end == `"` {
+			field = strings.ReplaceAll(field, `\"`, `"`)
+		}
+		fields, line = append(fields, field), rest
+	}
+	return fields
+}
+
+// JSONField extracts the field at a dot separated path, such as
+// "request.user", of JSON lines. Strings are keys as they are, numbers and
+// booleans as they are written, and other values, missing fields and lines
+// that are not JSON have no key.
+func JSONField(path string) LineExtractor {
+	names := strings.Split(path, ".")
+	return func(line string) (string, bool) {
+		d := json.NewDecoder(strings.NewReader(line))
+		d.UseNumber()
+		var v any
+		if err := d.Decode(&v); err != nil {
+			return "", false
+		}
+		for _, name := range names {
+			object, ok := v.(map[string]any)
+			if !ok {
+				return "", false
+			}
+			if v, ok = object[name]; !ok {
+				return "", false
+			}
+		}
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case json.Number:
+			return v.String(), true
+		case bool:
+			return fmt.Sprint(v), true
+		}
+		return "", false
+	}
+}
+
+// Split the args of a spec into the field that comes first and the file
+func fieldArgs(kind string, args []string) (string, []string, error) {
+	if len(args) < 2 {
+		return "", nil, fmt.Errorf("%s requires a field and a file name", kind)
+	}
+	return args[0], args[1:], nil
+}
+
+// Open a Common Log Format file, from a spec such as clf:path:access.log
+func newCLFFromArgs(_ *rand.Rand, _ int, args []string) (KeyDistribution, error) {
+	field, file, err := fieldArgs("clf", args)
+	if err != nil {
+		return nil, err
+	}
+	extract, err := CLFField(field)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openArgs("clf", file)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogTrace(f, extract), nil
+}
+
+// Open a JSON lines file, from a spec such as jsonl:request.user:app.log
+func newJSONLFromArgs(_ *rand.Rand, _ int, args []string) (KeyDistribution, error) {
+	field, file, err := fieldArgs("jsonl", args)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(strings.Split(field, "."), "") {
+		return nil, fmt.Errorf("invalid JSON field %q", field)
+	}
+	f, err := openArgs("jsonl", file)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogTrace(f, JSONField(field)), nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package simulator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const accessLog = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+10.0.0.2 - - [10/Oct/2000:13:55:37 -0700] "POST /api/users?id=7 HTTP/1.1" 201 - "http://example.com/" "Mozilla/5.0 (X11; Linux)"
+
+not a log line
+10.0.0.3 - - [10/Oct/2000:13:55:38 -0700] "GET /say?q=\"hi there\" HTTP/1.1" 200 12
+`
+
+// Keys of a distribution until it is exhausted
+func replay(d KeyDistribution) []string {
+	var keys []string
+	for k, ok := d.Next(); ok; k, ok = d.Next() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestCLFField(t *testing.T) {
+	tests := []struct {
+		field   string
+		want    string
+		skipped int
+	}{
+		{field: "host", want: "127.0.0.1,10.0.0.2,10.0.0.3", skipped: 1},
+		{field: "user", want: "frank", skipped: 3},
+		{field: "time", want: "10/Oct/2000:13:55:36 -0700,10/Oct/2000:13:55:37 -0700,10/Oct/2000:13:55:38 -0700", skipped: 1},
+		{field: "path", want: `/apache_pb.gif,/api/users?id=7,/say?q="hi there"`, skipped: 1},
+		{field: "protocol", want: "HTTP/1.0,HTTP/1.1,HTTP/1.1", skipped: 1},
+		{field: "method", want: "GET,POST,GET", skipped: 1},
+		{field: "status", want: "200,201,200", skipped: 1},
+		{field: "bytes", want: "2326,12", skipped: 2},
+		{field: "agent", want: "Mozilla/5.0 (X11; Linux)", skipped: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			extract, err := CLFField(tt.field)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			tr := NewLogTrace(strings.NewReader(accessLog), extract)
+			if got := strings.Join(replay(tr), ","); got != tt.want {
+				t.Errorf("expected keys %s, got %s", tt.want, got)
+			}
+			if tr.Skipped() != tt.skipped {
+				t.Errorf("expected %d lines skipped, got %d", tt.skipped, tr.Skipped())
+			}
+		})
+	}
+
+	if _, err := CLFField("referrer"); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
+
+func TestJSONField(t *testing.T) {
+	lines := `{"user": {"id": 42, "name": "ada"}, "ok": true}
+{"user": {"id": "u-7"}}
+{"user": {"id": null}}
+{"user": "flat"}
+not json
+{"user": {"id": 1e3}}
+`
+	tr := NewLogTrace(strings.NewReader(lines), JSONField("user.id"))
+	if got := strings.Join(replay(tr), ","); got != "42,u-7,1e3" {
+		t.Errorf("expected keys 42,u-7,1e3, got %s", got)
+	}
+	if tr.Skipped() != 3 {
+		t.Errorf("expected 3 lines skipped, got %d", tr.Skipped())
+	}
+
+	if got := replay(NewLogTrace(strings.NewReader(lines), JSONField("ok"))); strings.Join(got, ",") != "true" {
+		t.Errorf("expected key true, got %v", got)
+	}
+}
+
+func TestParseLogs(t *testing.T) {
+	dir := t.TempDir()
+	clf := filepath.Join(dir, "access.log")
+	jsonl := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(clf, []byte(accessLog), 0o644); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := os.WriteFile(jsonl, []byte(`{"tenant": "a"}`+"\n"+`{"tenant": "b"}`+"\n"), 0o644); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	d, err := Parse("clf:method:"+clf, nil, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := strings.Join(replay(d), ","); got != "GET,POST,GET" {
+		t.Errorf("expected keys GET,POST,GET, got %s", got)
+	}
+
+	d, err = Parse("jsonl:tenant:"+jsonl, nil, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := strings.Join(replay(d), ","); got != "a,b" {
+		t.Errorf("expected keys a,b, got %s", got)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Snapshot of the nodes shared with background servers
+
+package main
+
+import (
+	"cmp"
+	"net/netip"
+	"serverpool"
+	"slices"
+	"sync"
+)
+
+// nodeSnapshot holds the nodes taken between commands, since the load
+// balancer is not safe for use by the goroutines of the EDS and DNS servers
+type nodeSnapshot struct {
+	mu sync.Mutex
+
+	// Nodes in bucket order
+	nodes   []snapshotNode
+	version uint64
+}
+
+type snapshotNode struct {
+	addr netip.Addr
+
+	// First bucket of the node and how many buckets it has
+	bucket int
+	weight int
+}
+
+// Snapshot the nodes of the load balancer, each once
+func (s *nodeSnapshot) update(lb LoadBalancer[netip.Addr, int]) {
+	var nodes []snapshotNode
+	for node, bucket := range lb.Nodes() {
+		nodes = append(nodes, snapshotNode{addr: node.Name(), bucket: bucket, weight: serverpool.Weight(node)})
+	}
+	slices.SortFunc(nodes, func(a, b snapshotNode) int { return cmp.Compare(a.bucket, b.bucket) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes, s.version = nodes, lb.Version()
+}
+
+// Nodes of the last snapshot and the load balancer version it was taken at
+func (s *nodeSnapshot) get() ([]snapshotNode, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nodes, s.version
+}
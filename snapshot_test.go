@@ -0,0 +1,61 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"serverpool"
+	"strings"
+	"testing"
+)
+
+func TestWeightedNodesPublished(t *testing.T) {
+	lb := NewLoadBalancer[netip.Addr, int]()
+	heavy := NewWeightedServerNode[int](netip.MustParseAddr("10.0.0.1"), 3)
+	light := NewServerNode[int](netip.MustParseAddr("10.0.0.2"))
+	if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&heavy, &light}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	snapshot := &nodeSnapshot{}
+	snapshot.update(lb)
+
+	// Each node is published once with its weight
+	weights := map[string]int{"10.0.0.1": 3, "10.0.0.2": 1}
+	endpoints, _ := edsSource{snapshot, 80}.Endpoints()
+	if len(endpoints) != len(weights) {
+		t.Fatalf("expected %d endpoints, got %+v", len(weights), endpoints)
+	}
+	for _, e := range endpoints {
+		if int(e.Weight) != weights[e.Address] {
+			t.Fatalf("expected %s with weight %d, got %d", e.Address, weights[e.Address], e.Weight)
+		}
+	}
+	targets := dnsSource{snapshot, 80}.Targets()
+	if len(targets) != len(weights) {
+		t.Fatalf("expected %d targets, got %+v", len(weights), targets)
+	}
+	for _, target := range targets {
+		if int(target.Weight) != weights[target.Addr.String()] {
+			t.Fatalf("expected %v with weight %d, got %d", target.Addr, weights[target.Addr.String()], target.Weight)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "upstream.conf")
+	newUpstreamWriter(path, "nginx", "backend", 80, "").update(lb)
+	conf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, want := range []string{"server 10.0.0.1:80 weight=3;", "server 10.0.0.2:80 weight=1;"} {
+		if strings.Count(string(conf), want) != 1 {
+			t.Fatalf("expected %q once in\n%s", want, conf)
+		}
+	}
+	if n := strings.Count(string(conf), "server "); n != len(weights) {
+		t.Fatalf("expected %d servers, got %d in\n%s", len(weights), n, conf)
+	}
+}
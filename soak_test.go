@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build soak
+
+// Long-running randomized operations with continuous invariant checks, run
+// with go test -tags soak -run Soak -timeout 0 -soak.duration 4h
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+var (
+	soakDuration = flag.Duration("soak.duration", time.Minute, "how long the soak test runs")
+	soakSeed     = flag.Int64("soak.seed", 0, "seed of the random operations, 0 picks one")
+)
+
+// Live heap after a collection
+func soakHeap() int {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int(m.HeapAlloc)
+}
+
+func TestSoak(t *testing.T) {
+	seed := *soakSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	lb := NewLoadBalancerWithOptions(
+		WithCooperativeRebalance(RebalanceCallbacks[string, string]{}),
+		WithMovementBudget[string, string](50, time.Second, nil),
+	)
+
+	// Nodes and objects come from fixed sets so the working set stays bounded
+	nodes := make([]*mockNode, 32)
+	for i := range nodes {
+		nodes[i] = &mockNode{ID: fmt.Sprintf("node%d", i), objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	objects := make([]*serverpool.Object[string, string], 2000)
+	for i := range objects {
+		objects[i] = &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i)}
+	}
+	node := func() []serverpool.Node[string, string] {
+		return []serverpool.Node[string, string]{nodes[r.Intn(len(nodes))]}
+	}
+	object := func() *serverpool.Object[string, string] {
+		return objects[r.Intn(len(objects))]
+	}
+
+	// Operations fail when they do not apply, e.g. removing a missing node,
+	// only the invariants matter
+	ops := []func(){
+		func() { lb.AddNodes(node()) },
+		func() { lb.RemoveNodes(node()) },
+		func() { lb.AddObjects([]*serverpool.Object[string, string]{object()}) },
+		func() { lb.RemoveObjects([]*serverpool.Object[string, string]{object()}) },
+		func() { lb.AssignObject(object()) },
+		func() { lb.AssignObject(object()) },
+		func() { lb.UnassignObject(object()) },
+		func() { lb.TransferObject(object(), node()[0]) },
+		func() { lb.DrainNode(node()[0], 1+r.Intn(20)) },
+		func() { lb.Rebalance() },
+		func() {
+			tx := lb.Begin()
+			tx.AddNodes(node())
+			tx.AssignObject(object())
+			tx.Commit()
+		},
+	}
+
+	// The change feed keeps its history on purpose and grows with every
+	// change, so its growth is tracked apart from the heap's
+	var heaps, feeds []int
+	deadline := time.Now().Add(*soakDuration)
+	sample := time.Now()
+	for n := 1; time.Now().Before(deadline); n++ {
+		ops[r.Intn(len(ops))]()
+		if err := lb.Verify(); err != nil {
+			t.Fatalf("after %d operations: %v", n, err)
+		}
+
+		if time.Since(sample) >= *soakDuration/20 {
+			sample = time.Now()
+			heap, stats := soakHeap(), lb.MemoryStats()
+			heaps, feeds = append(heaps, heap), append(feeds, stats.ChangeFeed)
+			t.Logf("%d operations, %d nodes, heap %d bytes, %v, %+v", n, lb.NodeCount(), heap, stats, lb.ChurnStats())
+		}
+	}
+
+	// The working set is bounded, so after warming up the heap should only
+	// keep growing with the change feed
+	if len(heaps) >= 8 {
+		warm, last := len(heaps)/4, len(heaps)-1
+		growing := true
+		for i := warm; i < last; i++ {
+			growing = growing && heaps[i+1] > heaps[i]
+		}
+		growth, feed := heaps[last]-heaps[warm], feeds[last]-feeds[warm]
+		if growing && growth-feed > max(1<<20, feed/2) {
+			t.Fatalf("heap grew %d bytes beyond the change feed's %d: %v", growth-feed, feed, heaps[warm:])
+		}
+	}
+}
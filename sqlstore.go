@@ -0,0 +1,299 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Object store kept in a Postgres or MySQL database
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLDialect is the flavour of SQL spoken by the database of a
+// SQLObjectStore
+type SQLDialect int
+
+const (
+	Postgres SQLDialect = iota + 1
+	MySQL
+)
+
+func (d SQLDialect) String() string {
+	switch d {
+	case Postgres:
+		return "postgres"
+	case MySQL:
+		return "mysql"
+	}
+	return fmt.Sprintf("SQLDialect(%d)", int(d))
+}
+
+// ParseSQLDialect parses the name of a SQL dialect
+func ParseSQLDialect(s string) (SQLDialect, error) {
+	switch strings.ToLower(s) {
+	case "postgres", "postgresql", "pgx":
+		return Postgres, nil
+	case "mysql", "mariadb":
+		return MySQL, nil
+	}
+	return 0, fmt.Errorf("unknown SQL dialect %q", s)
+}
+
+// Schema migrations of the object store, applied in order. {table} is
+// replaced with the table name. Only append to this list, the position of a
+// migration is its version.
+var sqlObjectStoreMigrations = []map[SQLDialect]string{
+	{
+		Postgres: `CREATE TABLE {table} (
+	id VARCHAR(255) PRIMARY KEY,
+	node VARCHAR(255),
+	version BIGINT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+)`,
+		MySQL: `CREATE TABLE {table} (
+	id VARCHAR(255) PRIMARY KEY,
+	node VARCHAR(255),
+	version BIGINT NOT NULL,
+	updated_at DATETIME(6) NOT NULL
+)`,
+	},
+	{
+		Postgres: `CREATE INDEX {table}_node ON {table} (node)`,
+		MySQL:    `CREATE INDEX {table}_node ON {table} (node)`,
+	},
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,47}$`)
+
+// SQLObjectStore keeps object records in a table of a Postgres or MySQL
+// database, for load balancer replicas sharing ownership. Object ids and
+// node names are stored as text, so they must be strings, integers or
+// implement encoding.TextMarshaler and encoding.TextUnmarshaler.
+//
+// The caller opens the database with the driver of their choice. MySQL
+// drivers must parse DATETIME columns into time.Time, e.g. with
+// parseTime=true for go-sql-driver/mysql.
+type SQLObjectStore[T, O comparable] struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+	clock   func() time.Time
+}
+
+// NewSQLObjectStore creates an object store in the given table of a
+// database. Call Migrate to create or upgrade the table before using it.
+func NewSQLObjectStore[T, O comparable](db *sql.DB, dialect SQLDialect, table string) (*SQLObjectStore[T, O], error) {
+	if dialect != Postgres && dialect != MySQL {
+		return nil, fmt.Errorf("unsupported SQL dialect %v", dialect)
+	}
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	return &SQLObjectStore[T, O]{db: db, dialect: dialect, table: table, clock: time.Now}, nil
+}
+
+// Rewrite a query for the dialect, replacing {table} with the table name
+// and, for Postgres, ? placeholders with $1, $2, ...
+func (s *SQLObjectStore[T, O]) query(q string) string {
+	q = strings.ReplaceAll(q, "{table}", s.table)
+	if s.dialect != Postgres {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SchemaVersion returns the number of migrations applied to the database
+func (s *SQLObjectStore[T, O]) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT COALESCE(MAX(version), 0) FROM {table}_migrations`)).Scan(&version)
+	return version, err
+}
+
+// Migrate brings the schema up to date, recording the applied migrations
+// in the table {table}_migrations. Each migration is recorded and applied
+// in one transaction, so a replica migrating at the same time fails on the
+// duplicate version instead of applying it twice. MySQL commits schema
+// changes immediately, so there a failed migration must be fixed by hand.
+func (s *SQLObjectStore[T, O]) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.query(`CREATE TABLE IF NOT EXISTS {table}_migrations (
+	version INT PRIMARY KEY,
+	applied_at BIGINT NOT NULL
+)`))
+	if err != nil {
+		return fmt.Errorf("migrations table: %w", err)
+	}
+	applied, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("schema version: %w", err)
+	}
+	if applied > len(sqlObjectStoreMigrations) {
+		return fmt.Errorf("schema version %d is newer than this build, which knows %d", applied, len(sqlObjectStoreMigrations))
+	}
+	for version := applied + 1; version <= len(sqlObjectStoreMigrations); version++ {
+		if err := s.migrate(ctx, version); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLObjectStore[T, O]) migrate(ctx context.Context, version int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, s.query(`INSERT INTO {table}_migrations (version, applied_at) VALUES (?, ?)`), version, s.clock().Unix())
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.query(sqlObjectStoreMigrations[version-1][s.dialect])); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get the record of an object
+func (s *SQLObjectStore[T, O]) Get(ctx context.Context, id O) (ObjectRecord[T, O], error) {
+	key, err := formatText(id)
+	if err != nil {
+		return ObjectRecord[T, O]{}, err
+	}
+	row := s.db.QueryRowContext(ctx, s.query(`SELECT id, node, version, updated_at FROM {table} WHERE id = ?`), key)
+	rec, err := s.scan(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rec, ErrObjectNotFound
+	}
+	return rec, err
+}
+
+// Iterate over all records in id order
+func (s *SQLObjectStore[T, O]) Records(ctx context.Context) iter.Seq2[ObjectRecord[T, O], error] {
+	return func(yield func(ObjectRecord[T, O], error) bool) {
+		rows, err := s.db.QueryContext(ctx, s.query(`SELECT id, node, version, updated_at FROM {table} ORDER BY id`))
+		if err != nil {
+			yield(ObjectRecord[T, O]{}, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			rec, err := s.scan(rows)
+			if !yield(rec, err) || err != nil {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(ObjectRecord[T, O]{}, err)
+		}
+	}
+}
+
+func (s *SQLObjectStore[T, O]) scan(row interface{ Scan(...any) error }) (ObjectRecord[T, O], error) {
+	var rec ObjectRecord[T, O]
+	var id string
+	var node sql.NullString
+	if err := row.Scan(&id, &node, &rec.Version, &rec.Updated); err != nil {
+		return rec, err
+	}
+	var err error
+	if rec.ID, err = parseText[O](id); err != nil {
+		return rec, fmt.Errorf("object %q: %w", id, err)
+	}
+	if node.Valid {
+		name, err := parseText[T](node.String)
+		if err != nil {
+			return rec, fmt.Errorf("object %q: node %q: %w", id, node.String, err)
+		}
+		rec.Node = &name
+	}
+	return rec, nil
+}
+
+// Create or update a record, failing with ErrVersionConflict if the stored
+// record does not have the version of rec
+func (s *SQLObjectStore[T, O]) Put(ctx context.Context, rec ObjectRecord[T, O]) (ObjectRecord[T, O], error) {
+	key, err := formatText(rec.ID)
+	if err != nil {
+		return rec, err
+	}
+	var node sql.NullString
+	if rec.Node != nil {
+		if node.String, err = formatText(*rec.Node); err != nil {
+			return rec, err
+		}
+		node.Valid = true
+	}
+	now := s.clock().UTC()
+
+	if rec.Version == 0 {
+		_, err := s.db.ExecContext(ctx, s.query(`INSERT INTO {table} (id, node, version, updated_at) VALUES (?, ?, 1, ?)`), key, node, now)
+		if err != nil {
+			// The error for a duplicate key depends on the driver, so
+			// look for the record the insert collided with
+			if stored, getErr := s.Get(ctx, rec.ID); getErr == nil {
+				return rec, checkVersion(stored.Version, 0, true)
+			}
+			return rec, err
+		}
+	} else {
+		res, err := s.db.ExecContext(ctx, s.query(`UPDATE {table} SET node = ?, version = version + 1, updated_at = ? WHERE id = ? AND version = ?`),
+			node, now, key, rec.Version)
+		if err != nil {
+			return rec, err
+		}
+		if err := s.checkWritten(ctx, res, rec.ID, rec.Version); err != nil {
+			return rec, err
+		}
+	}
+	rec.Version++
+	rec.Updated = now
+	return rec, nil
+}
+
+// Delete a record if it still has the given version
+func (s *SQLObjectStore[T, O]) Delete(ctx context.Context, id O, version int64) error {
+	key, err := formatText(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, s.query(`DELETE FROM {table} WHERE id = ? AND version = ?`), key, version)
+	if err != nil {
+		return err
+	}
+	return s.checkWritten(ctx, res, id, version)
+}
+
+// Check that a conditional write hit a row, or tell why it did not
+func (s *SQLObjectStore[T, O]) checkWritten(ctx context.Context, res sql.Result, id O, version int64) error {
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	stored, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(stored.Version, version, true); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: record replaced during the write", ErrVersionConflict)
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQL is a database/sql driver understanding the statements of
+// SQLObjectStore, standing in for a database
+type fakeSQL struct {
+	mu         sync.Mutex
+	migrations map[int64]bool
+	rows       map[string]fakeSQLRow
+
+	// Schema statements run by migrations
+	schema []string
+}
+
+type fakeSQLRow struct {
+	node    driver.Value
+	version int64
+	updated time.Time
+}
+
+func (f *fakeSQL) Connect(context.Context) (driver.Conn, error) { return fakeSQLConn{f}, nil }
+func (f *fakeSQL) Driver() driver.Driver                        { return nil }
+
+type fakeSQLConn struct{ f *fakeSQL }
+
+func (c fakeSQLConn) Prepare(query string) (driver.Stmt, error) { return fakeSQLStmt{c.f, query}, nil }
+func (c fakeSQLConn) Close() error                              { return nil }
+func (c fakeSQLConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c fakeSQLConn) Commit() error                             { return nil }
+func (c fakeSQLConn) Rollback() error                           { return nil }
+
+type fakeSQLStmt struct {
+	f     *fakeSQL
+	query string
+}
+
+func (s fakeSQLStmt) Close() error  { return nil }
+func (s fakeSQLStmt) NumInput() int { return -1 }
+
+func (s fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	f := s.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := s.query
+	switch {
+	case strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS objects_migrations"):
+	case strings.HasPrefix(q, "CREATE"):
+		f.schema = append(f.schema, q)
+	case strings.HasPrefix(q, "INSERT INTO objects_migrations"):
+		if f.migrations[args[0].(int64)] {
+			return nil, errors.New("duplicate migration")
+		}
+		f.migrations[args[0].(int64)] = true
+	case strings.HasPrefix(q, "INSERT INTO objects "):
+		id := args[0].(string)
+		if _, ok := f.rows[id]; ok {
+			return nil, errors.New("duplicate key")
+		}
+		f.rows[id] = fakeSQLRow{args[1], 1, args[2].(time.Time)}
+	case strings.HasPrefix(q, "UPDATE objects "):
+		id := args[2].(string)
+		row, ok := f.rows[id]
+		if !ok || row.version != args[3].(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		f.rows[id] = fakeSQLRow{args[0], row.version + 1, args[1].(time.Time)}
+	case strings.HasPrefix(q, "DELETE FROM objects "):
+		id := args[0].(string)
+		row, ok := f.rows[id]
+		if !ok || row.version != args[1].(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		delete(f.rows, id)
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", q)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	f := s.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := s.query
+	rows := &fakeSQLRows{columns: []string{"id", "node", "version", "updated_at"}}
+	switch {
+	case strings.HasPrefix(q, "SELECT COALESCE(MAX(version), 0) FROM objects_migrations"):
+		var version int64
+		for v := range f.migrations {
+			version = max(version, v)
+		}
+		rows.columns, rows.values = []string{"version"}, [][]driver.Value{{version}}
+	case strings.HasPrefix(q, "SELECT id, node, version, updated_at FROM objects WHERE id = ?"):
+		if row, ok := f.rows[args[0].(string)]; ok {
+			rows.values = [][]driver.Value{{args[0], row.node, row.version, row.updated}}
+		}
+	case strings.HasPrefix(q, "SELECT id, node, version, updated_at FROM objects ORDER BY id"):
+		for _, id := range slices.Sorted(maps.Keys(f.rows)) {
+			row := f.rows[id]
+			rows.values = append(rows.values, []driver.Value{id, row.node, row.version, row.updated})
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query %q", q)
+	}
+	return rows, nil
+}
+
+type fakeSQLRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return r.columns }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestSQLObjectStore(t *testing.T) {
+	fake := &fakeSQL{migrations: make(map[int64]bool), rows: make(map[string]fakeSQLRow)}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	ctx := context.Background()
+
+	if _, err := NewSQLObjectStore[string, string](db, MySQL, "objects; DROP TABLE x"); err == nil {
+		t.Fatalf("expected an error for an invalid table name")
+	}
+	pg, err := NewSQLObjectStore[string, string](db, Postgres, "objects")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if q := pg.query("UPDATE {table} SET node = ? WHERE id = ?"); q != "UPDATE objects SET node = $1 WHERE id = $2" {
+		t.Fatalf("unexpected postgres query %q", q)
+	}
+
+	store, err := NewSQLObjectStore[string, string](db, MySQL, "objects")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for range 2 {
+		if err := store.Migrate(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if version, err := store.SchemaVersion(ctx); err != nil || version != len(sqlObjectStoreMigrations) {
+		t.Fatalf("expected schema version %d, got %d, %v", len(sqlObjectStoreMigrations), version, err)
+	}
+	if len(fake.schema) != len(sqlObjectStoreMigrations) {
+		t.Fatalf("expected each migration to run once, ran %q", fake.schema)
+	}
+
+	checkObjectStore(t, "memory", NewMemoryObjectStore[string, string]())
+	checkObjectStore(t, "sql", store)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Metrics sent to a statsd server
+
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// StatsdMetrics writes each metric as a statsd line, with labels as
+// DogStatsD tags. Counters are sent as deltas, gauges as values and
+// histograms as h samples.
+type StatsdMetrics struct {
+	w      io.Writer
+	prefix string
+}
+
+// Create statsd metrics written to w, usually a UDP connection to the
+// server, with every name prefixed by prefix
+func NewStatsdMetrics(w io.Writer, prefix string) *StatsdMetrics {
+	return &StatsdMetrics{w: w, prefix: prefix}
+}
+
+type statsdMetric struct {
+	s    *StatsdMetrics
+	name string
+	tags string
+}
+
+func (s *StatsdMetrics) metric(name string, labels []string) statsdMetric {
+	var tags []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		tags = append(tags, labels[i]+":"+labels[i+1])
+	}
+	m := statsdMetric{s: s, name: s.prefix + name}
+	if len(tags) > 0 {
+		m.tags = "|#" + strings.Join(tags, ",")
+	}
+	return m
+}
+
+func (s *StatsdMetrics) Counter(name string, labels ...string) Counter {
+	return statsdCounter{s.metric(name, labels)}
+}
+
+func (s *StatsdMetrics) Gauge(name string, labels ...string) Gauge {
+	return statsdGauge{s.metric(name, labels)}
+}
+
+func (s *StatsdMetrics) Histogram(name string, labels ...string) Histogram {
+	return statsdHistogram{s.metric(name, labels)}
+}
+
+// Send one line, statsd is best effort so errors are dropped
+func (m statsdMetric) send(value float64, kind string) {
+	io.WriteString(m.s.w, m.line(value, kind))
+}
+
+func (m statsdMetric) line(value float64, kind string) string {
+	return m.name + ":" + strconv.FormatFloat(value, 'g', -1, 64) + "|" + kind + m.tags + "\n"
+}
+
+type statsdCounter struct{ statsdMetric }
+type statsdGauge struct{ statsdMetric }
+type statsdHistogram struct{ statsdMetric }
+
+func (c statsdCounter) Add(delta float64)       { c.send(delta, "c") }
+func (h statsdHistogram) Observe(value float64) { h.send(value, "h") }
+
+// Set a gauge. A signed value changes a gauge by that much, so a negative
+// one is sent as a reset to 0 followed by the value as a change, together.
+func (g statsdGauge) Set(value float64) {
+	if value >= 0 {
+		g.send(value, "g")
+		return
+	}
+	io.WriteString(g.s.w, g.line(0, "g")+g.line(value, "g"))
+}
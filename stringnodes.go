@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Adapter naming nodes with strings for string-only integrations
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"slices"
+	"sync"
+)
+
+// StringNodes adapts a load balancer to integrations that only deal in
+// strings, such as memcached server selectors, Envoy clusters and DNS
+// records. Every node has a canonical string form of its name, which maps
+// back to the node while it is in the load balancer.
+type StringNodes[T, O comparable] struct {
+	lb     LoadBalancer[T, O]
+	format func(T) string
+
+	mu sync.Mutex
+
+	// Version of the load balancer the index was built at
+	version uint64
+	built   bool
+
+	// Nodes by canonical name
+	byName map[string]serverpool.Node[T, O]
+}
+
+// NewStringNodes creates an adapter naming the nodes of lb with format, or
+// CanonicalName if format is nil. Names must be unique to find nodes by
+// name.
+func NewStringNodes[T, O comparable](lb LoadBalancer[T, O], format func(T) string) *StringNodes[T, O] {
+	if format == nil {
+		format = CanonicalName[T]
+	}
+	return &StringNodes[T, O]{lb: lb, format: format}
+}
+
+// CanonicalName is the text form of a node name for strings, integers and
+// names implementing encoding.TextMarshaler, such as netip.Addr, and
+// otherwise its default format
+func CanonicalName[T comparable](name T) string {
+	if s, err := formatText(name); err == nil {
+		return s
+	}
+	return fmt.Sprint(name)
+}
+
+// Name returns the canonical name of a node
+func (s *StringNodes[T, O]) Name(node serverpool.Node[T, O]) string {
+	return s.format(node.Name())
+}
+
+// Node finds the node with a canonical name
+func (s *StringNodes[T, O]) Node(name string) (serverpool.Node[T, O], bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index()
+	node, ok := s.byName[name]
+	return node, ok
+}
+
+// Names returns the canonical names of the nodes, sorted
+func (s *StringNodes[T, O]) Names() []string {
+	var names []string
+	for node := range s.lb.Nodes() {
+		names = append(names, s.Name(node))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// GetNode returns the canonical name of the node responsible for a key
+func (s *StringNodes[T, O]) GetNode(key string) (string, error) {
+	node, err := s.lb.GetNode(key)
+	if err != nil {
+		return "", err
+	}
+	return s.Name(node), nil
+}
+
+// GetNodes returns the canonical names of the nodes of the replicas of a
+// key
+func (s *StringNodes[T, O]) GetNodes(key string, n int) ([]string, error) {
+	nodes, err := s.lb.GetNodes(key, n)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = s.Name(node)
+	}
+	return names, nil
+}
+
+// Rebuild the index of nodes by name if the load balancer changed since
+func (s *StringNodes[T, O]) index() {
+	version := s.lb.Version()
+	if s.built && version == s.version {
+		return
+	}
+	s.byName = make(map[string]serverpool.Node[T, O])
+	for node := range s.lb.Nodes() {
+		s.byName[s.Name(node)] = node
+	}
+	s.version, s.built = version, true
+}
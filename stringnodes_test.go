@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"net/netip"
+	"serverpool"
+	"slices"
+	"testing"
+)
+
+func TestStringNodes(t *testing.T) {
+	lb := NewLoadBalancer[netip.Addr, int]()
+	names := NewStringNodes(lb, nil)
+	if _, ok := names.Node("10.0.0.1"); ok {
+		t.Fatalf("expected no node before adding one")
+	}
+
+	var nodes []serverpool.Node[netip.Addr, int]
+	for _, addr := range []string{"10.0.0.2", "10.0.0.1", "::1"} {
+		node := NewServerNode[int](netip.MustParseAddr(addr))
+		nodes = append(nodes, &node)
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := names.Names(); !slices.Equal(got, []string{"10.0.0.1", "10.0.0.2", "::1"}) {
+		t.Fatalf("expected the addresses as names, got %v", got)
+	}
+	for _, node := range nodes {
+		name := names.Name(node)
+		if found, ok := names.Node(name); !ok || found != node {
+			t.Fatalf("expected %s to find %v, got %v", name, node, found)
+		}
+	}
+	name, err := names.GetNode("key")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if node, _ := lb.GetNode("key"); name != node.Name().String() {
+		t.Fatalf("expected %v, got %s", node, name)
+	}
+	replicas, err := names.GetNodes("key", 2)
+	if err != nil || len(replicas) != 2 || replicas[0] != name {
+		t.Fatalf("expected 2 replicas starting with %s, got %v, %v", name, replicas, err)
+	}
+
+	// The index follows removals
+	if _, err := lb.RemoveNodes(nodes[:1]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := names.Node("10.0.0.2"); ok {
+		t.Fatalf("expected no removed node")
+	}
+
+	custom := NewStringNodes(lb, func(addr netip.Addr) string { return "memcached://" + addr.String() + ":11211" })
+	if node, ok := custom.Node("memcached://10.0.0.1:11211"); !ok || node != nodes[1] {
+		t.Fatalf("expected %v, got %v", nodes[1], node)
+	}
+	if CanonicalName(42) != "42" || CanonicalName(struct{ A int }{1}) != "{1}" {
+		t.Fatalf("expected integers as text and other names in their default format")
+	}
+}
@@ -0,0 +1,3 @@
+module tcpproxy
+
+go 1.23.0
@@ -0,0 +1,241 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// tcpproxy package forwards TCP connections to the backend the address of
+// their client maps to, so all connections of a client reach the same
+// backend. Connections to a backend that leaves are drained: they carry on
+// for a grace period and are then closed.
+package tcpproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Serve after Close
+var ErrClosed = errors.New("proxy closed")
+
+// Router maps the IP address of a client to the address of a backend,
+// host and port, usually through the node the load balancer maps the
+// client address to
+type Router interface {
+	Route(client string) (string, error)
+}
+
+// RouterFunc adapts a function to a Router
+type RouterFunc func(client string) (string, error)
+
+func (f RouterFunc) Route(client string) (string, error) {
+	return f(client)
+}
+
+// DefaultDialTimeout is the time to connect to a backend if DialTimeout is
+// not set
+const DefaultDialTimeout = 10 * time.Second
+
+// Proxy forwards connections to their backend
+type Proxy struct {
+	router Router
+
+	// Time to connect to a backend, DefaultDialTimeout if 0
+	DialTimeout time.Duration
+
+	// Time connections to a drained backend carry on before they are
+	// closed, 0 to close them at once
+	DrainTimeout time.Duration
+
+	// Receives the errors of connections that could not be forwarded, if
+	// set
+	ErrorLog func(error)
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[string]map[*proxied]struct{}
+	closed    bool
+}
+
+// A forwarded connection with both of its ends
+type proxied struct {
+	client  net.Conn
+	backend net.Conn
+}
+
+func (c *proxied) close() {
+	c.client.Close()
+	c.backend.Close()
+}
+
+// New creates a proxy routing connections with router
+func New(router Router) *Proxy {
+	return &Proxy{router: router, listeners: make(map[net.Listener]struct{}),
+		conns: make(map[string]map[*proxied]struct{})}
+}
+
+// ListenAndServe forwards the connections accepted on the TCP address addr
+func (p *Proxy) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(ln)
+}
+
+// Serve forwards the connections accepted on ln until it fails or the
+// proxy is closed
+func (p *Proxy) Serve(ln net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		ln.Close()
+		return ErrClosed
+	}
+	p.listeners[ln] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.listeners, ln)
+		p.mu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+		go p.forward(conn)
+	}
+}
+
+// Forward a client connection to its backend until either end closes
+func (p *Proxy) forward(client net.Conn) {
+	host, _, err := net.SplitHostPort(client.RemoteAddr().String())
+	if err != nil {
+		host = client.RemoteAddr().String()
+	}
+	addr, err := p.router.Route(host)
+	if err != nil {
+		p.fail(client, err)
+		return
+	}
+	backend, err := net.DialTimeout("tcp", addr, p.dialTimeout())
+	if err != nil {
+		p.fail(client, err)
+		return
+	}
+
+	c := &proxied{client: client, backend: backend}
+	if !p.track(addr, c) {
+		c.close()
+		return
+	}
+	defer p.untrack(addr, c)
+
+	// Each direction closes the other's write side when it ends, so half
+	// closed connections keep working
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		splice(backend, client)
+	}()
+	splice(client, backend)
+	wg.Wait()
+	c.close()
+}
+
+// Copy from src to dst, then close the write side of dst
+func splice(dst, src net.Conn) {
+	io.Copy(dst, src)
+	if tc, ok := dst.(interface{ CloseWrite() error }); ok {
+		tc.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+func (p *Proxy) fail(client net.Conn, err error) {
+	client.Close()
+	if p.ErrorLog != nil {
+		p.ErrorLog(err)
+	}
+}
+
+func (p *Proxy) track(addr string, c *proxied) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	if p.conns[addr] == nil {
+		p.conns[addr] = make(map[*proxied]struct{})
+	}
+	p.conns[addr][c] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(addr string, c *proxied) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns[addr], c)
+	if len(p.conns[addr]) == 0 {
+		delete(p.conns, addr)
+	}
+}
+
+// Conns returns the number of connections forwarded to a backend
+func (p *Proxy) Conns(backend string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns[backend])
+}
+
+// Drain closes the connections to a backend that left after DrainTimeout.
+// New connections no longer reach it as long as the router stops routing
+// to it.
+func (p *Proxy) Drain(backend string) {
+	p.mu.Lock()
+	var draining []*proxied
+	for c := range p.conns[backend] {
+		draining = append(draining, c)
+	}
+	p.mu.Unlock()
+	time.AfterFunc(p.DrainTimeout, func() {
+		for _, c := range draining {
+			c.close()
+		}
+	})
+}
+
+// Close stops accepting connections and closes the connections being
+// forwarded
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for ln := range p.listeners {
+		ln.Close()
+	}
+	for _, conns := range p.conns {
+		for c := range conns {
+			c.close()
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) dialTimeout() time.Duration {
+	if p.DialTimeout > 0 {
+		return p.DialTimeout
+	}
+	return DefaultDialTimeout
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package tcpproxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Backend echoing lines prefixed with its name
+func echo(t *testing.T, name string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					io.WriteString(conn, name+":"+line)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// Start a proxy on a local port
+func start(t *testing.T, p *Proxy) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	go p.Serve(ln)
+	t.Cleanup(func() { p.Close() })
+	return ln.Addr().String()
+}
+
+func roundTrip(t *testing.T, conn net.Conn, r *bufio.Reader, msg string) string {
+	t.Helper()
+	if _, err := io.WriteString(conn, msg+"\n"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return line
+}
+
+func TestProxy(t *testing.T) {
+	backend := echo(t, "a")
+	clients := make(chan string, 1)
+	p := New(RouterFunc(func(client string) (string, error) {
+		clients <- client
+		return backend, nil
+	}))
+	addr := start(t, p)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if got := roundTrip(t, conn, r, "hello"); got != "a:hello\n" {
+		t.Fatalf("expected the backend to echo, got %q", got)
+	}
+	if client := <-clients; client != "127.0.0.1" {
+		t.Fatalf("expected to route by the client IP, got %q", client)
+	}
+	if p.Conns(backend) != 1 {
+		t.Fatalf("expected 1 connection to the backend, got %d", p.Conns(backend))
+	}
+
+	// Draining closes the connection after the grace period
+	p.DrainTimeout = 50 * time.Millisecond
+	p.Drain(backend)
+	if got := roundTrip(t, conn, r, "still there"); got != "a:still there\n" {
+		t.Fatalf("expected the connection to carry on while draining, got %q", got)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Fatalf("expected the drained connection closed, got %v", err)
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	errNoNodes := errors.New("no nodes")
+	errs := make(chan error, 1)
+	p := New(RouterFunc(func(string) (string, error) { return "", errNoNodes }))
+	p.ErrorLog = func(err error) { errs <- err }
+	addr := start(t, p)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection closed without a backend, got %v", err)
+	}
+	if err := <-errs; !errors.Is(err, errNoNodes) {
+		t.Fatalf("expected the routing error, got %v", err)
+	}
+
+	p.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := p.Serve(ln); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// TCP proxy forwarding connections to the node of their client address
+
+package main
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"tcpproxy"
+	"time"
+)
+
+// Address of the backend of a node, its address on port
+func tcpBackend(node netip.Addr, port uint16) string {
+	return net.JoinHostPort(node.String(), strconv.Itoa(int(port)))
+}
+
+// Forward connections on addr to port on the node of their client address
+// in the background, draining the connections of removed nodes for grace.
+// lb must be safe for concurrent use, as for serveProxy.
+func serveTCPProxy(addr string, lb LoadBalancer[netip.Addr, int], port uint16, grace time.Duration) {
+	p := tcpproxy.New(tcpproxy.RouterFunc(func(client string) (string, error) {
+		node, err := lb.GetNode(client)
+		if err != nil {
+			return "", err
+		}
+		return tcpBackend(node.Name(), port), nil
+	}))
+	p.DrainTimeout = grace
+	p.ErrorLog = func(err error) { out.info("TCP proxy error:", err) }
+
+	lb.Subscribe(func(e Event[netip.Addr, int]) {
+		p.Drain(tcpBackend(e.Node.Name(), port))
+	}, DefaultSubscriptionBuffer, EventNodeRemoved)
+
+	go func() {
+		if err := p.ListenAndServe(addr); err != nil {
+			out.info("TCP proxy stopped:", err)
+		}
+	}()
+}
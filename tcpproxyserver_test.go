@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestTCPProxyServer(t *testing.T) {
+	saved := out
+	out = &output{out: io.Discard, log: io.Discard}
+	defer func() { out = saved }()
+
+	// Backend on the address of the only node
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	port := backend.Addr().(*net.TCPAddr).Port
+
+	lb := NewConcurrentLoadBalancer[netip.Addr, int]()
+	node := NewServerNode[int](netip.MustParseAddr("127.0.0.1"))
+	if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&node}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	addr := free.Addr().String()
+	free.Close()
+	serveTCPProxy(addr, lb, uint16(port), 0)
+
+	var conn net.Conn
+	for range 100 {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 5)
+	io.WriteString(conn, "hello")
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("expected the node to echo, got %q, %v", buf, err)
+	}
+
+	// Removing the node drains its connections
+	lb.RemoveNodes([]serverpool.Node[netip.Addr, int]{&node})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Fatalf("expected the connection closed after the node left, got %v", err)
+	}
+}
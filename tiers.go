@@ -0,0 +1,142 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Placement of objects on tiers of nodes, such as SSD and HDD workers
+
+package main
+
+import (
+	"consistenthash"
+	"fmt"
+	"serverpool"
+	"slices"
+)
+
+// Nodes of one tier with a hasher of their own, weighted as in the ring
+type tier[T, O comparable] struct {
+	ch      consistenthash.ConsistentHasher
+	nodes   map[int]serverpool.Node[T, O]
+	buckets map[T][]int
+}
+
+type tiers[T, O comparable] struct {
+	// Tier of a node, "" for none. Tiers are off if nil.
+	of func(node serverpool.Node[T, O]) string
+
+	tiers map[string]*tier[T, O]
+
+	// Creates the hasher of a tier, memento hashing for tiers not in it
+	hashers map[string]func() consistenthash.ConsistentHasher
+}
+
+// Add a node to the hasher of its tier with a bucket per unit of weight,
+// or a single weighted bucket as addNodeBuckets does. A node added again
+// replaces its buckets, so a new weight or tier takes effect.
+func (lb *loadBalancer[T, O]) tierAdd(node serverpool.Node[T, O]) {
+	if lb.tiers.of == nil {
+		return
+	}
+	lb.tierRemove(node)
+	name := lb.tiers.of(node)
+	if name == "" {
+		return
+	}
+	if lb.tiers.tiers == nil {
+		lb.tiers.tiers = make(map[string]*tier[T, O])
+	}
+	t, ok := lb.tiers.tiers[name]
+	if !ok {
+		var ch consistenthash.ConsistentHasher
+		if newHasher, ok := lb.tiers.hashers[name]; ok {
+			ch = newHasher()
+		} else {
+			ch = lb.newHasher()
+		}
+		t = &tier[T, O]{ch: ch,
+			nodes: make(map[int]serverpool.Node[T, O]), buckets: make(map[T][]int)}
+		lb.tiers.tiers[name] = t
+	}
+	bucket := t.ch.AddBucket()
+	t.nodes[bucket] = node
+	t.buckets[node.Name()] = []int{bucket}
+	if wh, ok := t.ch.(consistenthash.WeightedHasher); ok {
+		if w := serverpool.Weight(node); w > 1 {
+			wh.SetBucketWeight(bucket, float64(w))
+		}
+		return
+	}
+	for range serverpool.Weight(node) - 1 {
+		b := t.ch.AddBucket()
+		t.nodes[b] = node
+		t.buckets[node.Name()] = append(t.buckets[node.Name()], b)
+	}
+}
+
+// Remove a node from the hasher of its tier, releasing its buckets in the
+// reverse order they were added
+func (lb *loadBalancer[T, O]) tierRemove(node serverpool.Node[T, O]) {
+	for _, t := range lb.tiers.tiers {
+		if buckets, ok := t.buckets[node.Name()]; ok {
+			for _, b := range slices.Backward(buckets) {
+				t.ch.RemoveBucket(b)
+				delete(t.nodes, b)
+			}
+			delete(t.buckets, node.Name())
+			return
+		}
+	}
+}
+
+// Node of the tier a normalized key maps to, false if the tier has no nodes
+func (lb *loadBalancer[T, O]) tierNode(key, name string) (serverpool.Node[T, O], bool) {
+	t, ok := lb.tiers.tiers[name]
+	if !ok || t.ch.Size() == 0 {
+		return nil, false
+	}
+	node, ok := t.nodes[t.ch.GetBucket(key)]
+	return node, ok
+}
+
+// GetTierNode maps a key to a node of the tier. Keys of a tier without
+// nodes map to any node, as with GetNode. Lookups are shed, fail with
+// injected faults and are measured as with GetNode.
+func (lb *loadBalancer[T, O]) GetTierNode(key, tier string) (serverpool.Node[T, O], error) {
+	return lb.getNode(key, func(key string) (serverpool.Node[T, O], error) {
+		normalized := key
+		if lb.normalize != nil {
+			normalized = lb.normalize(key)
+		}
+		if node, ok := lb.tierNode(normalized, tier); ok && normalized != "" {
+			return node, nil
+		}
+		return lb.lookupKey(key)
+	})
+}
+
+// SetObjectTier moves an object to another tier to promote or demote it,
+// reassigning it to a node of the new tier if it is assigned
+func (lb *loadBalancer[T, O]) SetObjectTier(obj *serverpool.Object[T, O], tier string) error {
+	o, ok := lb.objects.get(obj.Id)
+	if !ok {
+		return fmt.Errorf("%v not found", obj)
+	}
+	if lb.dryRun {
+		return nil
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+
+	var err error
+	lb.profiler.do("SetObjectTier", func() {
+		o.Tier = tier
+		if node := o.Node(); node == nil || *node == nil {
+			return
+		}
+		if err = lb.assignObject(o); err == nil {
+			lb.publish(ChangeAssignObject, nil, []*serverpool.Object[T, O]{o})
+		}
+	})
+	return err
+}
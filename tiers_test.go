@@ -0,0 +1,226 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"consistenthash"
+	"fmt"
+	"hashing"
+	"net/netip"
+	"serverpool"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNodeTiers(t *testing.T) {
+	tierOf := func(node serverpool.Node[string, string]) string {
+		name, _, _ := strings.Cut(node.Name(), "-")
+		return name
+	}
+	lb := NewLoadBalancerWithOptions(WithNodeTiers(tierOf))
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	nodes := []serverpool.Node[string, string]{newNode("ssd-1"), newNode("ssd-2"),
+		newNode("hdd-1"), newNode("hdd-2"), newNode("hdd-3")}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var objs []*serverpool.Object[string, string]
+	for i := 0; i < 40; i++ {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i), Tier: "hdd"})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	onTier := func(obj *serverpool.Object[string, string], tier string) bool {
+		return tierOf(*obj.Node()) == tier
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !onTier(obj, "hdd") {
+			t.Fatalf("expected %v on an hdd node, got %v", obj, *obj.Node())
+		}
+	}
+
+	// Promoted objects move to the ssd tier, removing a node keeps them there
+	for _, obj := range objs[:10] {
+		if err := lb.SetObjectTier(obj, "ssd"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if _, err := lb.RemoveNodes(nodes[:1]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i, obj := range objs {
+		want := "hdd"
+		if i < 10 {
+			want = "ssd"
+		}
+		if !onTier(obj, want) {
+			t.Fatalf("expected %v on an %s node, got %v", obj, want, *obj.Node())
+		}
+	}
+	if node, err := lb.GetTierNode("key", "ssd"); err != nil || node.Name() != "ssd-2" {
+		t.Fatalf("expected ssd-2, got %v, %v", node, err)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestTierHasher(t *testing.T) {
+	tierOf := func(node serverpool.Node[string, string]) string {
+		name, _, _ := strings.Cut(node.Name(), "-")
+		return name
+	}
+	newMaglev := func() consistenthash.ConsistentHasher {
+		return consistenthash.NewMaglevHasher(hashing.DefaultHashAlgorithm, 251)
+	}
+	lb := NewLoadBalancerWithOptions(WithNodeTiers(tierOf), WithTierHasher[string, string]("edge", newMaglev))
+	var nodes []serverpool.Node[string, string]
+	for _, id := range []string{"edge-0", "edge-1", "edge-2", "storage-0", "storage-1", "storage-2"} {
+		nodes = append(nodes, &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Each tier maps keys as its own hasher does on its own
+	for tier, h := range map[string]consistenthash.ConsistentHasher{"edge": newMaglev(), "storage": consistenthash.NewConsistentHasher()} {
+		for range 3 {
+			h.AddBucket()
+		}
+		for i := range 200 {
+			key := "key" + strconv.Itoa(i)
+			node, err := lb.GetTierNode(key, tier)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if want := fmt.Sprintf("%s-%d", tier, h.GetBucket(key)); node.Name() != want {
+				t.Fatalf("expected %s on %s, got %v", key, want, node)
+			}
+		}
+	}
+}
+
+func TestTierWeights(t *testing.T) {
+	// Nodes of the 10.0.1.0/24 are the ssd tier
+	ssd := netip.MustParsePrefix("10.0.1.0/24")
+	tierOf := func(node serverpool.Node[netip.Addr, int]) string {
+		if ssd.Contains(node.Name()) {
+			return "ssd"
+		}
+		return ""
+	}
+	for _, strategy := range []consistenthash.Strategy{consistenthash.StrategyMemento, consistenthash.StrategyRendezvous} {
+		lb := NewLoadBalancerWithOptions(WithNodeTiers(tierOf), WithHashStrategy[netip.Addr, int](strategy))
+		big := NewWeightedServerNode[int](netip.MustParseAddr("10.0.1.1"), 3)
+		small := NewServerNode[int](netip.MustParseAddr("10.0.1.2"))
+		other := NewWeightedServerNode[int](netip.MustParseAddr("10.0.2.1"), 8)
+		if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&big, &small, &other}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		// The weighted node takes its share of the tier as in the ring
+		share := 0
+		for i := range 4000 {
+			node, err := lb.GetTierNode(fmt.Sprintf("key%d", i), "ssd")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if node.Name() == other.Name() {
+				t.Fatalf("%v: expected key%d on an ssd node, got %v", strategy, i, node)
+			}
+			if node.Name() == big.Name() {
+				share++
+			}
+		}
+		if share < 2700 || share > 3300 {
+			t.Fatalf("%v: expected about 3000 keys on the weighted node, got %d", strategy, share)
+		}
+
+		// A node added again with another weight takes its new share
+		if _, err := lb.RemoveNodes([]serverpool.Node[netip.Addr, int]{&big}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		big = NewServerNode[int](big.Name())
+		if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&big}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		share = 0
+		for i := range 4000 {
+			if node, err := lb.GetTierNode(fmt.Sprintf("key%d", i), "ssd"); err == nil && node.Name() == big.Name() {
+				share++
+			}
+		}
+		if share < 1800 || share > 2200 {
+			t.Fatalf("%v: expected about 2000 keys on the reweighted node, got %d", strategy, share)
+		}
+	}
+}
+
+func TestTierDrain(t *testing.T) {
+	tierOf := func(node serverpool.Node[string, string]) string {
+		name, _, _ := strings.Cut(node.Name(), "-")
+		return name
+	}
+	lb := NewLoadBalancerWithOptions(WithNodeTiers(tierOf))
+	var nodes []serverpool.Node[string, string]
+	for _, id := range []string{"ssd-0", "ssd-1", "ssd-2", "hdd-0", "hdd-1"} {
+		nodes = append(nodes, &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])})
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[string, string]
+	for i := range 60 {
+		objs = append(objs, &serverpool.Object[string, string]{Id: fmt.Sprintf("obj%d", i), Tier: "ssd"})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	draining := nodes[1].(*mockNode)
+	if len(draining.objects) == 0 {
+		t.Fatalf("expected objects on %v", draining)
+	}
+
+	// Keys of the tier stop mapping to the draining node at once, its
+	// objects move to the rest of the tier
+	if err := lb.(Drainer[string, string]).DrainNode(draining, 5); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for i := range 200 {
+		node, err := lb.GetTierNode(fmt.Sprintf("key%d", i), "ssd")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if node == serverpool.Node[string, string](draining) || tierOf(node) != "ssd" {
+			t.Fatalf("expected key%d on a live ssd node, got %v", i, node)
+		}
+	}
+	for len(draining.objects) > 0 {
+		if _, err := lb.Rebalance(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	for _, obj := range objs {
+		if node := *obj.Node(); node == serverpool.Node[string, string](draining) || tierOf(node) != "ssd" {
+			t.Fatalf("expected %v on a live ssd node, got %v", obj, node)
+		}
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
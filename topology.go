@@ -0,0 +1,43 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Export of the key to node mapping
+
+package main
+
+import (
+	"consistenthash"
+	"errors"
+	"fmt"
+)
+
+// ErrNotExportable is returned by Topology while keys map to nodes in ways
+// a snapshot does not capture
+var ErrNotExportable = errors.New("mapping cannot be exported")
+
+// Topology snapshots the hasher state and node names so that the same key
+// to node mapping can be computed elsewhere, e.g. by the wasm bindings.
+// Keys must be normalized by the consumer if a KeyNormalizer is set. Keys
+// pinned by PinKey or WithPrefixPin do not hash to their nodes, nor do keys
+// shifted by DialTraffic, so it fails with ErrNotExportable while there are
+// any pins or dials.
+func (lb *loadBalancer[T, O]) Topology() (consistenthash.Topology, error) {
+	if len(lb.keyPins) > 0 || len(lb.pins) > 0 {
+		return consistenthash.Topology{}, fmt.Errorf("%w: keys are pinned", ErrNotExportable)
+	}
+	if len(lb.dials) > 0 {
+		return consistenthash.Topology{}, fmt.Errorf("%w: nodes are being dialed", ErrNotExportable)
+	}
+	return lb.topology()
+}
+
+// Snapshot the hasher state and node names, whatever else changes the
+// mapping
+func (lb *loadBalancer[T, O]) topology() (consistenthash.Topology, error) {
+	nodes := make(map[int]string)
+	for bucket, node := range lb.sp.Buckets() {
+		nodes[bucket] = fmt.Sprint(node.Name())
+	}
+	return consistenthash.NewTopology(lb.ch, nodes)
+}
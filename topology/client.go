@@ -0,0 +1,185 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Thin client following the snapshots of a Server
+
+package topology
+
+import (
+	"consistenthash"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Client fetches snapshots from a Server and maps keys to node names with
+// the last one. Lookups are safe from any goroutine and see either the
+// previous or the new snapshot. Keys are mapped as given, without the key
+// normalization, pins or tiers a load balancer may apply.
+type Client struct {
+	// URL of the server, e.g. http://lb:8300, Path is appended
+	URL string
+
+	// How long Watch asks the server to hold requests until a new
+	// snapshot, 30 seconds if 0
+	Wait time.Duration
+
+	// HTTP client, http.DefaultClient if nil
+	HTTP *http.Client
+
+	// HMAC key of the server, snapshots that are not signed with it are
+	// rejected with ErrBadSignature. Signatures are not checked if nil.
+	Key []byte
+
+	lookup  atomic.Pointer[consistenthash.TopologyLookup]
+	version atomic.Uint64
+
+	// Loaded snapshot, deltas apply to it
+	topology consistenthash.Topology
+}
+
+func NewClient(url string) *Client {
+	return &Client{URL: url}
+}
+
+// Fetch the current snapshot if it is newer than the loaded one and report
+// whether it was. With a wait the server holds the request until there is
+// a newer snapshot or the wait is over. Once a snapshot is loaded, the
+// server may send only the changes since. Fetch is not safe to call
+// concurrently with itself.
+func (c *Client) Fetch(ctx context.Context, wait time.Duration) (bool, error) {
+	u, err := url.Parse(c.URL + Path)
+	if err != nil {
+		return false, err
+	}
+	query := url.Values{}
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+	_, loaded := c.Version()
+	if loaded {
+		query.Set("since", strconv.FormatUint(c.version.Load(), 10))
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	if loaded {
+		req.Header.Set("If-None-Match", etag(c.version.Load()))
+	}
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return false, nil
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, fmt.Errorf("fetching topology: %s: %s", resp.Status, strconv.Quote(string(body)))
+	}
+
+	version, err := strconv.ParseUint(resp.Header.Get(VersionHeader), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid topology version %q", resp.Header.Get(VersionHeader))
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if c.Key != nil {
+		if err := verify(c.Key, version, body, resp.Header.Get(SignatureHeader)); err != nil {
+			return false, err
+		}
+	}
+	if resp.Header.Get("Content-Type") == DeltaContentType {
+		var d Delta
+		if err := d.UnmarshalBinary(body); err != nil {
+			return false, err
+		}
+		if !loaded || d.From != c.version.Load() || d.To != version {
+			return false, fmt.Errorf("topology delta from %d to %d does not apply", d.From, d.To)
+		}
+		return c.load(d.Apply(c.topology), version)
+	}
+	var t consistenthash.Topology
+	if err := t.UnmarshalBinary(body); err != nil {
+		return false, err
+	}
+	return c.load(t, version)
+}
+
+// Load a snapshot unless it is older than the loaded one
+func (c *Client) load(t consistenthash.Topology, version uint64) (bool, error) {
+	if c.lookup.Load() != nil && version <= c.version.Load() {
+		return false, nil
+	}
+	lookup, err := consistenthash.NewTopologyLookup(t)
+	if err != nil {
+		return false, err
+	}
+	c.topology = t
+	c.version.Store(version)
+	c.lookup.Store(lookup)
+	return true, nil
+}
+
+// Watch follows the snapshots of the server with long polls until ctx is
+// done. Errors are passed to onError, if not nil, and retried after a
+// second.
+func (c *Client) Watch(ctx context.Context, onError func(error)) {
+	wait := c.Wait
+	if wait <= 0 {
+		wait = 30 * time.Second
+	}
+	for ctx.Err() == nil {
+		if _, err := c.Fetch(ctx, wait); err != nil && ctx.Err() == nil {
+			if onError != nil {
+				onError(err)
+			}
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// GetNode returns the name of the node of the key, false if no snapshot is
+// loaded or the key has no node
+func (c *Client) GetNode(key string) (string, bool) {
+	lookup := c.lookup.Load()
+	if lookup == nil {
+		return "", false
+	}
+	return lookup.GetNode(key)
+}
+
+// GetNodes returns the names of up to n distinct nodes for the key, nil if
+// no snapshot is loaded
+func (c *Client) GetNodes(key string, n int) []string {
+	lookup := c.lookup.Load()
+	if lookup == nil {
+		return nil
+	}
+	return lookup.GetNodes(key, n)
+}
+
+// Version of the loaded snapshot and whether one is loaded
+func (c *Client) Version() (uint64, bool) {
+	return c.version.Load(), c.lookup.Load() != nil
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Changes between snapshots, so clients that are slightly behind do not
+// have to fetch the whole removed table and node list again.
+//
+// The binary form of a delta is big endian:
+//
+//	offset  size  field
+//	0       4     magic "TDLT"
+//	4       8     version the delta applies to
+//	12      8     version the delta produces
+//	20      4     buckets
+//	24      4     last removed bucket
+//	28      4     number of removed buckets set r, then 12*r bytes as in
+//	              the removed table of the hasher state
+//	              number of buckets no longer removed u, then 4*u bytes
+//	              1 byte, 0 if the bucket ids are unchanged, 2 if there are
+//	              no ids anymore, 1 if they are replaced, followed by the
+//	              number of ids i and 8*i bytes of ids
+//	              number of nodes set n, then each node as in a topology
+//	              number of buckets without a node anymore g, then 4*g bytes
+
+package topology
+
+import (
+	"consistenthash"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+)
+
+// DeltaContentType of delta responses
+const DeltaContentType = "application/vnd.loadbalance.topology-delta"
+
+var deltaMagic = [4]byte{'T', 'D', 'L', 'T'}
+
+// Delta turns the snapshot of one version into that of a later one
+type Delta struct {
+	From, To uint64
+
+	Buckets, LastRemoved int
+
+	// Entries of the removed table that are new or changed
+	Removed []consistenthash.RemovedBucket
+
+	// Buckets that are no longer removed
+	Restored []int
+
+	// New bucket ids if they changed, ReplaceIDs distinguishes no ids
+	// from unchanged ones
+	ReplaceIDs bool
+	IDs        []int
+
+	// Nodes that are new or renamed and buckets without a node anymore
+	Nodes map[int]string
+	Gone  []int
+}
+
+// Diff returns the delta from a to b, false if b cannot be reached from a
+// with a delta because the hash algorithm or state version changed
+func Diff(a, b consistenthash.Topology, from, to uint64) (Delta, bool) {
+	if a.Hasher.Version != b.Hasher.Version || a.Hasher.Algorithm != b.Hasher.Algorithm {
+		return Delta{}, false
+	}
+	d := Delta{From: from, To: to, Buckets: b.Hasher.Buckets, LastRemoved: b.Hasher.LastRemoved,
+		Nodes: make(map[int]string)}
+
+	old := make(map[int]consistenthash.RemovedBucket, len(a.Hasher.Removed))
+	for _, r := range a.Hasher.Removed {
+		old[r.Bucket] = r
+	}
+	for _, r := range b.Hasher.Removed {
+		if prev, ok := old[r.Bucket]; !ok || prev != r {
+			d.Removed = append(d.Removed, r)
+		}
+		delete(old, r.Bucket)
+	}
+	d.Restored = slices.Sorted(maps.Keys(old))
+
+	if (a.Hasher.IDs == nil) != (b.Hasher.IDs == nil) || !slices.Equal(a.Hasher.IDs, b.Hasher.IDs) {
+		d.ReplaceIDs, d.IDs = true, b.Hasher.IDs
+	}
+
+	for bucket, name := range b.Nodes {
+		if prev, ok := a.Nodes[bucket]; !ok || prev != name {
+			d.Nodes[bucket] = name
+		}
+	}
+	for bucket := range a.Nodes {
+		if _, ok := b.Nodes[bucket]; !ok {
+			d.Gone = append(d.Gone, bucket)
+		}
+	}
+	slices.Sort(d.Gone)
+	return d, true
+}
+
+// Apply the delta to the snapshot of its From version
+func (d Delta) Apply(t consistenthash.Topology) consistenthash.Topology {
+	removed := make(map[int]consistenthash.RemovedBucket, len(t.Hasher.Removed)+len(d.Removed))
+	for _, r := range t.Hasher.Removed {
+		removed[r.Bucket] = r
+	}
+	for _, r := range d.Removed {
+		removed[r.Bucket] = r
+	}
+	for _, bucket := range d.Restored {
+		delete(removed, bucket)
+	}
+
+	state := t.Hasher
+	state.Buckets, state.LastRemoved = d.Buckets, d.LastRemoved
+	state.Removed = slices.SortedFunc(maps.Values(removed), func(a, b consistenthash.RemovedBucket) int {
+		return a.Bucket - b.Bucket
+	})
+	if d.ReplaceIDs {
+		state.IDs = d.IDs
+	}
+
+	nodes := maps.Clone(t.Nodes)
+	if nodes == nil {
+		nodes = make(map[int]string)
+	}
+	maps.Copy(nodes, d.Nodes)
+	for _, bucket := range d.Gone {
+		delete(nodes, bucket)
+	}
+	return consistenthash.Topology{Hasher: state, Nodes: nodes}
+}
+
+// MarshalBinary encodes the delta in the binary form
+func (d Delta) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 48+12*len(d.Removed)+4*len(d.Restored)+8*len(d.IDs)+16*len(d.Nodes)+4*len(d.Gone))
+	b = append(b, deltaMagic[:]...)
+	b = binary.BigEndian.AppendUint64(b, d.From)
+	b = binary.BigEndian.AppendUint64(b, d.To)
+	b = binary.BigEndian.AppendUint32(b, uint32(d.Buckets))
+	b = binary.BigEndian.AppendUint32(b, uint32(d.LastRemoved))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(d.Removed)))
+	for _, r := range d.Removed {
+		b = binary.BigEndian.AppendUint32(b, uint32(r.Bucket))
+		b = binary.BigEndian.AppendUint32(b, uint32(r.Replacement))
+		b = binary.BigEndian.AppendUint32(b, uint32(r.PrevRemoved))
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(d.Restored)))
+	for _, bucket := range d.Restored {
+		b = binary.BigEndian.AppendUint32(b, uint32(bucket))
+	}
+	switch {
+	case !d.ReplaceIDs:
+		b = append(b, 0)
+	case d.IDs == nil:
+		b = append(b, 2)
+	default:
+		b = append(b, 1)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(d.IDs)))
+		for _, id := range d.IDs {
+			b = binary.BigEndian.AppendUint64(b, uint64(int64(id)))
+		}
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(d.Nodes)))
+	for _, bucket := range slices.Sorted(maps.Keys(d.Nodes)) {
+		name := d.Nodes[bucket]
+		if len(name) > math.MaxUint16 {
+			return nil, fmt.Errorf("name of node in bucket %d is too long", bucket)
+		}
+		b = binary.BigEndian.AppendUint32(b, uint32(bucket))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(name)))
+		b = append(b, name...)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(d.Gone)))
+	for _, bucket := range d.Gone {
+		b = binary.BigEndian.AppendUint32(b, uint32(bucket))
+	}
+	return b, nil
+}
+
+var errTruncatedDelta = errors.New("truncated topology delta")
+
+// Reads a delta, remembering the first read past the end
+type deltaReader struct {
+	b         []byte
+	truncated bool
+}
+
+func (r *deltaReader) next(n int) []byte {
+	if r.truncated || len(r.b) < n {
+		r.truncated = true
+		return make([]byte, n)
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p
+}
+
+func (r *deltaReader) uint32() int { return int(binary.BigEndian.Uint32(r.next(4))) }
+
+// Number of entries of size bytes each that follow, bounded by the data
+// left so corrupt counts cannot cause huge allocations
+func (r *deltaReader) count(size int) int {
+	n := r.uint32()
+	if n > len(r.b)/size {
+		r.truncated = true
+		return 0
+	}
+	return n
+}
+
+// UnmarshalBinary decodes a delta in the binary form
+func (d *Delta) UnmarshalBinary(b []byte) error {
+	if len(b) < 4 || [4]byte(b[:4]) != deltaMagic {
+		return errors.New("not a topology delta")
+	}
+	r := &deltaReader{b: b[4:]}
+	nd := Delta{Nodes: make(map[int]string)}
+	nd.From = binary.BigEndian.Uint64(r.next(8))
+	nd.To = binary.BigEndian.Uint64(r.next(8))
+	nd.Buckets, nd.LastRemoved = r.uint32(), r.uint32()
+	for range r.count(12) {
+		nd.Removed = append(nd.Removed, consistenthash.RemovedBucket{
+			Bucket: r.uint32(), Replacement: r.uint32(), PrevRemoved: r.uint32()})
+	}
+	for range r.count(4) {
+		nd.Restored = append(nd.Restored, r.uint32())
+	}
+	switch r.next(1)[0] {
+	case 0:
+	case 1:
+		nd.ReplaceIDs, nd.IDs = true, []int{}
+		for range r.count(8) {
+			nd.IDs = append(nd.IDs, int(int64(binary.BigEndian.Uint64(r.next(8)))))
+		}
+	case 2:
+		nd.ReplaceIDs = true
+	default:
+		return errors.New("invalid bucket ids in topology delta")
+	}
+	for range r.count(6) {
+		bucket, length := r.uint32(), int(binary.BigEndian.Uint16(r.next(2)))
+		nd.Nodes[bucket] = string(r.next(length))
+	}
+	for range r.count(4) {
+		nd.Gone = append(nd.Gone, r.uint32())
+	}
+	if r.truncated {
+		return errTruncatedDelta
+	}
+	if len(r.b) != 0 {
+		return errors.New("trailing data after topology delta")
+	}
+	*d = nd
+	return nil
+}
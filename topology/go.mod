@@ -0,0 +1,3 @@
+module topology
+
+go 1.23.0
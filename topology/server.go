@@ -0,0 +1,256 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// topology package distributes topology snapshots of a load balancer to
+// thin clients, which then map keys to nodes locally with a read-only
+// hasher instead of asking the load balancer for every key.
+//
+// The Server answers GET requests to Path with the last published snapshot
+// in the binary form of consistenthash.Topology. The snapshot version is
+// its ETag and is also sent in the Topology-Version header. A request with
+// If-None-Match set to the current version gets 304 Not Modified, unless
+// it asks to wait for a newer snapshot with the wait query parameter, e.g.
+// ?wait=30s, in which case the response is held until a snapshot is
+// published or the wait is over.
+//
+// A client that has a snapshot passes its version in the since query
+// parameter. If the server still has that snapshot it answers with a
+// Delta from it instead of the full snapshot, with DeltaContentType. A
+// client too far behind, or one for which the delta would not be smaller,
+// gets the full snapshot.
+//
+// With a key shared by the server and its clients, snapshots are signed
+// with HMAC-SHA256 in the Topology-Signature header and clients reject
+// snapshots whose signature does not match, so that an intermediary cannot
+// redirect keys to nodes of its choosing.
+package topology
+
+import (
+	"consistenthash"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Path clients fetch snapshots from
+const Path = "/v1/topology"
+
+// ContentType of snapshots
+const ContentType = "application/vnd.loadbalance.topology"
+
+// VersionHeader carries the version of a snapshot
+const VersionHeader = "Topology-Version"
+
+// DefaultMaxWait bounds how long a request may wait for a new snapshot
+const DefaultMaxWait = time.Minute
+
+// DefaultHistory is the number of past snapshots deltas are served from
+const DefaultHistory = 16
+
+// Server publishes topology snapshots over HTTP
+type Server struct {
+	// Longest wait a request may ask for, DefaultMaxWait if 0
+	MaxWait time.Duration
+
+	// HMAC key snapshots are signed with, unsigned if nil
+	Key []byte
+
+	// Past snapshots kept to serve deltas from, DefaultHistory if 0 and
+	// none if negative
+	History int
+
+	mu      sync.Mutex
+	current *published
+	past    []*published
+
+	// Deltas from past versions to the current one, by past version
+	deltas map[uint64]*published
+
+	// Closed when a snapshot is published, to wake waiting requests
+	changed chan struct{}
+}
+
+// A published snapshot, or a delta to it
+type published struct {
+	version   uint64
+	topology  consistenthash.Topology
+	body      []byte
+	signature string
+	delta     bool
+}
+
+func NewServer() *Server {
+	return &Server{changed: make(chan struct{})}
+}
+
+// Publish a snapshot taken at version. Versions must increase, publishing
+// a version that is not newer than the current one does nothing.
+func (s *Server) Publish(t consistenthash.Topology, version uint64) error {
+	body, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil && version <= s.current.version {
+		return nil
+	}
+	history := s.History
+	if history == 0 {
+		history = DefaultHistory
+	}
+	if s.current != nil && history > 0 {
+		s.past = append(s.past, s.current)
+		s.past = s.past[max(len(s.past)-history, 0):]
+	}
+	s.current = &published{version: version, topology: t, body: body}
+	if s.Key != nil {
+		s.current.signature = sign(s.Key, version, body)
+	}
+	clear(s.deltas)
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return nil
+}
+
+// Version of the current snapshot and whether one was published
+func (s *Server) Version() (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return 0, false
+	}
+	return s.current.version, true
+}
+
+// Current snapshot, nil if none, and a channel closed when it is replaced
+func (s *Server) snapshot() (*published, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current, s.changed
+}
+
+// Delta from a past version to the current snapshot, the snapshot itself
+// if the past version is gone or the delta would not be smaller
+func (s *Server) delta(current *published, since uint64) *published {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current != s.current {
+		return current
+	}
+	if d, ok := s.deltas[since]; ok {
+		return d
+	}
+	i := slices.IndexFunc(s.past, func(p *published) bool { return p.version == since })
+	if i < 0 {
+		return current
+	}
+	delta, ok := Diff(s.past[i].topology, current.topology, since, current.version)
+	if !ok {
+		return current
+	}
+	body, err := delta.MarshalBinary()
+	if err != nil || len(body) >= len(current.body) {
+		return current
+	}
+	d := &published{version: current.version, body: body, delta: true}
+	if s.Key != nil {
+		d.signature = sign(s.Key, current.version, body)
+	}
+	if s.deltas == nil {
+		s.deltas = make(map[uint64]*published)
+	}
+	s.deltas[since] = d
+	return d
+}
+
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// Parse the wait query parameter, bounded by MaxWait
+func (s *Server) wait(r *http.Request) (time.Duration, error) {
+	q := r.URL.Query().Get("wait")
+	if q == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(q)
+	if err != nil || wait < 0 {
+		return 0, errors.New("invalid wait " + strconv.Quote(q))
+	}
+	maxWait := s.MaxWait
+	if maxWait <= 0 {
+		maxWait = DefaultMaxWait
+	}
+	return min(wait, maxWait), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != Path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	wait, err := s.wait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var since *uint64
+	if q := r.URL.Query().Get("since"); q != "" {
+		v, err := strconv.ParseUint(q, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since "+strconv.Quote(q), http.StatusBadRequest)
+			return
+		}
+		since = &v
+	}
+
+	current, changed := s.snapshot()
+	if match := r.Header.Get("If-None-Match"); current != nil && match == etag(current.version) && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-changed:
+			current, _ = s.snapshot()
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if current == nil {
+		http.Error(w, "no topology published", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("ETag", etag(current.version))
+	w.Header().Set(VersionHeader, strconv.FormatUint(current.version, 10))
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag(current.version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	resp := current
+	if since != nil && *since < current.version {
+		resp = s.delta(current, *since)
+	}
+	if resp.signature != "" {
+		w.Header().Set(SignatureHeader, resp.signature)
+	}
+	if resp.delta {
+		w.Header().Set("Content-Type", DeltaContentType)
+	} else {
+		w.Header().Set("Content-Type", ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.body)))
+	w.Write(resp.body)
+}
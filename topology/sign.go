@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// HMAC signatures of snapshots
+
+package topology
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// SignatureHeader carries the signature of a snapshot as
+// "hmac-sha256=<hex>". The MAC covers the version, 8 bytes big endian,
+// followed by the body, so a snapshot cannot be replayed as a newer version.
+const SignatureHeader = "Topology-Signature"
+
+const signaturePrefix = "hmac-sha256="
+
+// ErrBadSignature is returned for snapshots that are unsigned or whose
+// signature does not match
+var ErrBadSignature = errors.New("bad topology signature")
+
+// MAC of a snapshot
+func mac(key []byte, version uint64, body []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(binary.BigEndian.AppendUint64(nil, version))
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// Signature header value of a snapshot
+func sign(key []byte, version uint64, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(key, version, body))
+}
+
+// Check the signature header value of a snapshot
+func verify(key []byte, version uint64, body []byte, signature string) error {
+	sum, ok := strings.CutPrefix(signature, signaturePrefix)
+	if !ok {
+		return ErrBadSignature
+	}
+	got, err := hex.DecodeString(sum)
+	if err != nil || !hmac.Equal(got, mac(key, version, body)) {
+		return ErrBadSignature
+	}
+	return nil
+}
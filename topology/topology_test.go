@@ -0,0 +1,333 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package topology
+
+import (
+	"bytes"
+	"consistenthash"
+	"context"
+	"fmt"
+	"hashing"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Snapshot of a hasher with n nodes
+func snapshot(t *testing.T, n int) (consistenthash.Topology, consistenthash.ConsistentHasher) {
+	t.Helper()
+	h := consistenthash.NewMementoHasher(hashing.CRC32)
+	nodes := make(map[int]string)
+	for i := range n {
+		nodes[h.AddBucket()] = fmt.Sprintf("node-%d", i)
+	}
+	h.RemoveBucket(1)
+	delete(nodes, 1)
+	topo, err := consistenthash.NewTopology(h, nodes)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return topo, h
+}
+
+func TestClientFetch(t *testing.T) {
+	server := NewServer()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client := NewClient(ts.URL)
+	ctx := context.Background()
+
+	if _, err := client.Fetch(ctx, 0); err == nil {
+		t.Fatal("expected an error before a snapshot is published")
+	}
+
+	topo, h := snapshot(t, 5)
+	if err := server.Publish(topo, 1); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	changed, err := client.Fetch(ctx, 0)
+	if err != nil || !changed {
+		t.Fatalf("expected the snapshot to be loaded, got %v, %v", changed, err)
+	}
+	for i := range 100 {
+		key := fmt.Sprintf("key-%d", i)
+		node, ok := client.GetNode(key)
+		if want := topo.Nodes[h.GetBucket(key)]; !ok || node != want {
+			t.Fatalf("expected %s for %s, got %s", want, key, node)
+		}
+	}
+
+	// The same version is not modified
+	if changed, err := client.Fetch(ctx, 0); err != nil || changed {
+		t.Fatalf("expected no change, got %v, %v", changed, err)
+	}
+
+	// Older versions are ignored
+	older, _ := snapshot(t, 3)
+	if err := server.Publish(older, 0); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if version, _ := server.Version(); version != 1 {
+		t.Fatalf("expected version 1, got %d", version)
+	}
+}
+
+func TestLongPoll(t *testing.T) {
+	server := NewServer()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client := NewClient(ts.URL)
+	ctx := context.Background()
+
+	topo, _ := snapshot(t, 5)
+	server.Publish(topo, 1)
+	if _, err := client.Fetch(ctx, 0); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// A wait without a new snapshot ends not modified
+	start := time.Now()
+	if changed, err := client.Fetch(ctx, 50*time.Millisecond); err != nil || changed {
+		t.Fatalf("expected no change, got %v, %v", changed, err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("expected the request to be held")
+	}
+
+	// Publishing wakes the waiting request
+	next, _ := snapshot(t, 8)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.Publish(next, 2)
+	}()
+	changed, err := client.Fetch(ctx, 10*time.Second)
+	if err != nil || !changed {
+		t.Fatalf("expected the new snapshot, got %v, %v", changed, err)
+	}
+	if version, _ := client.Version(); version != 2 {
+		t.Fatalf("expected version 2, got %d", version)
+	}
+}
+
+func TestServerRequests(t *testing.T) {
+	server := NewServer()
+	topo, _ := snapshot(t, 2)
+	server.Publish(topo, 7)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		match  string
+		status int
+	}{
+		{name: "full", method: http.MethodGet, target: Path, status: http.StatusOK},
+		{name: "not modified", method: http.MethodGet, target: Path, match: `"7"`, status: http.StatusNotModified},
+		{name: "stale", method: http.MethodGet, target: Path, match: `"6"`, status: http.StatusOK},
+		{name: "bad wait", method: http.MethodGet, target: Path + "?wait=soon", status: http.StatusBadRequest},
+		{name: "method", method: http.MethodPost, target: Path, status: http.StatusMethodNotAllowed},
+		{name: "path", method: http.MethodGet, target: "/", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.match != "" {
+				req.Header.Set("If-None-Match", tt.match)
+			}
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusOK && w.Header().Get("ETag") != `"7"` {
+				t.Fatalf("expected ETag \"7\", got %s", w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestSignedSnapshots(t *testing.T) {
+	key := []byte("secret")
+	server := NewServer()
+	server.Key = key
+	topo, _ := snapshot(t, 4)
+	server.Publish(topo, 3)
+
+	// A proxy rewriting the snapshot or its version
+	var tamper func(w http.ResponseWriter, r *http.Request)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tamper != nil {
+			tamper(w, r)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+
+	rogue, _ := snapshot(t, 6)
+	body, _ := rogue.MarshalBinary()
+	tests := []struct {
+		name   string
+		tamper func(w http.ResponseWriter, r *http.Request)
+		key    []byte
+		err    error
+	}{
+		{name: "signed", key: key},
+		{name: "unverified", key: nil},
+		{name: "wrong key", key: []byte("other"), err: ErrBadSignature},
+		{name: "body", key: key, err: ErrBadSignature, tamper: func(w http.ResponseWriter, r *http.Request) {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, r)
+			w.Header().Set(VersionHeader, "3")
+			w.Header().Set(SignatureHeader, rec.Header().Get(SignatureHeader))
+			w.Write(body)
+		}},
+		{name: "version", key: key, err: ErrBadSignature, tamper: func(w http.ResponseWriter, r *http.Request) {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, r)
+			w.Header().Set(VersionHeader, "4")
+			w.Header().Set(SignatureHeader, rec.Header().Get(SignatureHeader))
+			w.Write(rec.Body.Bytes())
+		}},
+		{name: "unsigned", key: key, err: ErrBadSignature, tamper: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(VersionHeader, "5")
+			w.Write(body)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tamper = tt.tamper
+			client := NewClient(ts.URL)
+			client.Key = tt.key
+			_, err := client.Fetch(ctx, 0)
+			if err != tt.err {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if _, loaded := client.Version(); loaded != (err == nil) {
+				t.Fatalf("expected loaded %v", err == nil)
+			}
+		})
+	}
+}
+
+func TestDelta(t *testing.T) {
+	h := consistenthash.NewMementoHasher(hashing.CRC32)
+	nodes := make(map[int]string)
+	for i := range 50 {
+		nodes[h.AddBucket()] = fmt.Sprintf("node-%d", i)
+	}
+	for _, bucket := range []int{3, 17, 30} {
+		h.RemoveBucket(bucket)
+		delete(nodes, bucket)
+	}
+	a, _ := consistenthash.NewTopology(h, maps.Clone(nodes))
+
+	// Restore a bucket, remove others and rename a node
+	bucket := h.AddBucket()
+	nodes[bucket] = "restored"
+	h.RemoveBucket(40)
+	delete(nodes, 40)
+	nodes[5] = "renamed"
+	b, _ := consistenthash.NewTopology(h, nodes)
+
+	delta, ok := Diff(a, b, 1, 2)
+	if !ok {
+		t.Fatal("expected a delta")
+	}
+	enc, err := delta.MarshalBinary()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	full, _ := b.MarshalBinary()
+	if len(enc) >= len(full) {
+		t.Fatalf("expected the delta to be smaller than %d bytes, got %d", len(full), len(enc))
+	}
+	var decoded Delta
+	if err := decoded.UnmarshalBinary(enc); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got, _ := decoded.Apply(a).MarshalBinary()
+	if !bytes.Equal(got, full) {
+		t.Fatal("expected the delta to produce the new snapshot")
+	}
+
+	for i := range enc {
+		if err := new(Delta).UnmarshalBinary(enc[:i]); err == nil {
+			t.Fatalf("expected an error for %d of %d bytes", i, len(enc))
+		}
+	}
+}
+
+func TestDeltaFetch(t *testing.T) {
+	server := NewServer()
+	server.Key = []byte("secret")
+	server.History = 1
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	ctx := context.Background()
+
+	h := consistenthash.NewMementoHasher(hashing.CRC32)
+	nodes := make(map[int]string)
+	version := uint64(0)
+	publish := func(add int) {
+		for range add {
+			bucket := h.AddBucket()
+			nodes[bucket] = fmt.Sprintf("node-%d", bucket)
+		}
+		topo, err := consistenthash.NewTopology(h, maps.Clone(nodes))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		version++
+		server.Publish(topo, version)
+	}
+
+	// Count the responses of each type
+	types := make(map[string]int)
+	client := NewClient(ts.URL)
+	client.Key = server.Key
+	client.HTTP = &http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err == nil {
+			types[resp.Header.Get("Content-Type")]++
+		}
+		return resp, err
+	})}
+	check := func() {
+		t.Helper()
+		if _, err := client.Fetch(ctx, 0); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for i := range 100 {
+			key := fmt.Sprintf("key-%d", i)
+			if node, _ := client.GetNode(key); node != nodes[h.GetBucket(key)] {
+				t.Fatalf("expected %s for %s, got %s", nodes[h.GetBucket(key)], key, node)
+			}
+		}
+	}
+
+	publish(100)
+	check()
+	publish(1)
+	check()
+	if types[DeltaContentType] != 1 {
+		t.Fatalf("expected a delta one version behind, got %v", types)
+	}
+
+	// Two versions behind is beyond the history
+	publish(1)
+	publish(1)
+	check()
+	if types[ContentType] != 2 {
+		t.Fatalf("expected a full snapshot two versions behind, got %v", types)
+	}
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
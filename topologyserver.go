@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Publishing of topology snapshots to thin clients
+
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"topology"
+)
+
+// Serve topology snapshots on addr in the background, signed with key if
+// it is not nil
+func serveTopology(addr string, key []byte) *topology.Server {
+	server := topology.NewServer()
+	server.Key = key
+	go func() {
+		if err := http.ListenAndServe(addr, server); err != nil {
+			out.info("Topology server stopped:", err)
+		}
+	}()
+	return server
+}
+
+// Publish a snapshot of the load balancer if it changed since the last one
+func publishTopology(server *topology.Server, lb LoadBalancer[netip.Addr, int]) {
+	if version, ok := server.Version(); ok && version == lb.Version() {
+		return
+	}
+	t, err := lb.Topology()
+	if err != nil {
+		out.info("Cannot publish topology:", err)
+		return
+	}
+	if err := server.Publish(t, lb.Version()); err != nil {
+		out.info("Cannot publish topology:", err)
+	}
+}
@@ -0,0 +1,527 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Transactions that apply several operations as a single change
+
+package main
+
+import (
+	"consistenthash"
+	"errors"
+	"fmt"
+	"maps"
+	"serverpool"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrTransactionDone is returned when committing or rolling back a
+// transaction that was already committed or rolled back
+var ErrTransactionDone = errors.New("transaction already committed or rolled back")
+
+// Transaction queues node and object operations until Commit applies them in
+// order as a single change with a single version. Queueing an operation has
+// no effect on the load balancer.
+type Transaction[T, O comparable] struct {
+	lb   *loadBalancer[T, O]
+	ops  []txOp[T, O]
+	done bool
+
+	// Held while committing, if set
+	locker sync.Locker
+}
+
+// Operation queued in a transaction
+type txOp[T, O comparable] struct {
+	op      ChangeOp
+	nodes   []serverpool.Node[T, O]
+	objects []*serverpool.Object[T, O]
+}
+
+// Begin a transaction of operations applied atomically by Commit
+func (lb *loadBalancer[T, O]) Begin() *Transaction[T, O] {
+	return &Transaction[T, O]{lb: lb}
+}
+
+func (tx *Transaction[T, O]) queue(op ChangeOp, nodes []serverpool.Node[T, O], objects []*serverpool.Object[T, O]) {
+	tx.ops = append(tx.ops, txOp[T, O]{op: op, nodes: nodes, objects: objects})
+}
+
+// Queue adding nodes
+func (tx *Transaction[T, O]) AddNodes(nodes []serverpool.Node[T, O]) {
+	tx.queue(ChangeAddNodes, nodes, nil)
+}
+
+// Queue removing nodes
+func (tx *Transaction[T, O]) RemoveNodes(nodes []serverpool.Node[T, O]) {
+	tx.queue(ChangeRemoveNodes, nodes, nil)
+}
+
+// Queue adding objects
+func (tx *Transaction[T, O]) AddObjects(objects []*serverpool.Object[T, O]) {
+	tx.queue(ChangeAddObjects, nil, objects)
+}
+
+// Queue removing objects
+func (tx *Transaction[T, O]) RemoveObjects(objects []*serverpool.Object[T, O]) {
+	tx.queue(ChangeRemoveObjects, nil, objects)
+}
+
+// Queue assigning an object
+func (tx *Transaction[T, O]) AssignObject(obj *serverpool.Object[T, O]) {
+	tx.queue(ChangeAssignObject, nil, []*serverpool.Object[T, O]{obj})
+}
+
+// Queue unassigning an object
+func (tx *Transaction[T, O]) UnassignObject(obj *serverpool.Object[T, O]) {
+	tx.queue(ChangeUnassignObject, nil, []*serverpool.Object[T, O]{obj})
+}
+
+// Commit checks every queued operation against the state the earlier ones
+// leave and applies them only if all would succeed, publishing one
+// ChangeBatch. An operation failing on what the checks do not cover, such
+// as placement constraints, undoes those applied before it: nothing is
+// published, and neither subscriptions, webhooks nor the object store see
+// any of it. Objects of removed nodes that cannot be reassigned are
+// reported with a ReassignmentError after the transaction is applied.
+func (tx *Transaction[T, O]) Commit() (err error) {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	tx.done = true
+	if tx.locker != nil {
+		tx.locker.Lock()
+		defer tx.locker.Unlock()
+	}
+
+	lb := tx.lb
+	if lb.dryRun {
+		return lb.validate(tx.ops)
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	lb.profiler.do("Commit", func() { err = lb.commit(tx.ops) })
+	return err
+}
+
+// Rollback discards the queued operations
+func (tx *Transaction[T, O]) Rollback() error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	tx.done = true
+	tx.ops = nil
+	return nil
+}
+
+func (lb *loadBalancer[T, O]) commit(ops []txOp[T, O]) error {
+	if err := lb.validate(ops); err != nil {
+		return err
+	}
+
+	// Memento restores the most recently removed bucket first, so undoing
+	// membership changes in reverse order gives every node back its bucket.
+	// A rebuild would not be undone, so it is held off while committing.
+	if lb.removedLimit > 0 {
+		consistenthash.SetRemovedLimit(lb.ch, 0)
+		defer consistenthash.SetRemovedLimit(lb.ch, lb.removedLimit)
+	}
+	undo := lb.undoState()
+	lb.journal(undo)
+	defer lb.journal(nil)
+
+	var changes []Change[T, O]
+	var effects []func()
+	lb.batch, lb.effects = &changes, &effects
+	defer func() { lb.batch, lb.effects = nil, nil }()
+
+	var errs ReassignmentError[O]
+	for _, op := range ops {
+		var err error
+		switch op.op {
+		case ChangeAddNodes:
+			var result NodesResult[T, O]
+			result, err = lb.addNodes(op.nodes)
+			undo.step(true, op.nodes, result)
+		case ChangeRemoveNodes:
+			registered := make([]serverpool.Node[T, O], len(op.nodes))
+			for i, node := range op.nodes {
+				registered[i], _ = lb.lookupNode(node)
+			}
+			var result NodesResult[T, O]
+			result, err = lb.decommission(op.nodes, func() (NodesResult[T, O], error) { return lb.removeNodes(op.nodes) })
+			undo.step(false, registered, result)
+		case ChangeAddObjects:
+			_, err = lb.addObjects(op.objects)
+		case ChangeRemoveObjects:
+			_, err = lb.removeObjects(op.objects)
+		case ChangeAssignObject:
+			if err = lb.assignObject(op.objects[0]); err == nil {
+				lb.publish(op.op, nil, op.objects)
+			}
+		case ChangeUnassignObject:
+			if err = lb.unassignObject(op.objects[0]); err == nil {
+				lb.publish(op.op, nil, op.objects)
+			}
+		}
+
+		var rerr *ReassignmentError[O]
+		if errors.As(err, &rerr) {
+			for id, e := range rerr.Errors {
+				errs.add(id, e)
+			}
+		} else if err != nil {
+			lb.rollback(undo)
+			return err
+		}
+	}
+
+	lb.batch, lb.effects = nil, nil
+	for _, fn := range effects {
+		fn()
+	}
+	lb.publishBatch(changes)
+	if len(errs.Errors) > 0 {
+		return &errs
+	}
+	return nil
+}
+
+// State a failing transaction is rolled back to. The state of objects is
+// saved as the transaction first changes it, so committing costs as much
+// as the objects the transaction touches rather than all objects.
+type txUndo[T, O comparable] struct {
+	// Nodes each membership change added or removed, in order
+	steps []txStep[T, O]
+
+	// Stored objects and the node of each object changed, nil if it was
+	// unassigned
+	objects entryUndo[O, *serverpool.Object[T, O]]
+	placed  map[*serverpool.Object[T, O]]serverpool.Node[T, O]
+
+	transferred entryUndo[O, T]
+	assigned    entryUndo[O, time.Time]
+	hinted      entryUndo[*serverpool.Object[T, O], hint[T, O]]
+
+	// State kept per node or bucket, saved whole. Deferred objects are only
+	// ever appended to, so the slice is saved as it is.
+	churn   churnTracker[T, O]
+	flaps   flapDetector[T]
+	drains  map[T]*drain[T, O]
+	dials   map[T]*dial[T, O]
+	stale   bool
+	owners  map[T][]O
+	reports []DecommissionReport[T, O]
+
+	// Bucket hits and alarms
+	hits          map[int]uint64
+	total         uint64
+	hitsSkewed    map[int]bool
+	objectsSkewed map[int]bool
+}
+
+// Entry of a map before a transaction first changed it
+type savedEntry[V any] struct {
+	value V
+	ok    bool
+}
+
+// Entries of a map as they were before the transaction being committed
+// first changed them. Saving into a nil journal does nothing.
+type entryUndo[K comparable, V any] map[K]savedEntry[V]
+
+// Save the entry of key in m unless it was saved already
+func (u entryUndo[K, V]) save(m map[K]V, key K) {
+	if u == nil {
+		return
+	}
+	if _, ok := u[key]; ok {
+		return
+	}
+	value, ok := m[key]
+	u[key] = savedEntry[V]{value, ok}
+}
+
+// Put the saved entries back into m and return it
+func (u entryUndo[K, V]) restore(m map[K]V) map[K]V {
+	for key, e := range u {
+		if !e.ok {
+			delete(m, key)
+			continue
+		}
+		if m == nil {
+			m = make(map[K]V)
+		}
+		m[key] = e.value
+	}
+	return m
+}
+
+// Nodes added, or else removed, by an operation
+type txStep[T, O comparable] struct {
+	added bool
+	nodes []serverpool.Node[T, O]
+}
+
+// Record the state kept per node or bucket before a transaction is applied
+func (lb *loadBalancer[T, O]) undoState() *txUndo[T, O] {
+	u := &txUndo[T, O]{
+		objects:     make(entryUndo[O, *serverpool.Object[T, O]]),
+		placed:      make(map[*serverpool.Object[T, O]]serverpool.Node[T, O]),
+		transferred: make(entryUndo[O, T]),
+		assigned:    make(entryUndo[O, time.Time]),
+		hinted:      make(entryUndo[*serverpool.Object[T, O], hint[T, O]]),
+
+		churn:   lb.churn,
+		flaps:   lb.flaps,
+		drains:  maps.Clone(lb.drains),
+		dials:   maps.Clone(lb.dials),
+		stale:   lb.stale,
+		reports: slices.Clone(lb.decommissions.reports),
+	}
+	if lb.records != nil {
+		u.owners = maps.Clone(lb.records.owners)
+	}
+	s := &lb.bucketStats
+	u.objectsSkewed = maps.Clone(s.objectsSkewed)
+	s.mu.Lock()
+	u.hits, u.total, u.hitsSkewed = maps.Clone(s.hits), s.total, maps.Clone(s.hitsSkewed)
+	s.mu.Unlock()
+	u.flaps.changes = maps.Clone(lb.flaps.changes)
+	u.flaps.quarantined = maps.Clone(lb.flaps.quarantined)
+	return u
+}
+
+// Save the changes of objects into u until called with nil
+func (lb *loadBalancer[T, O]) journal(u *txUndo[T, O]) {
+	lb.undo = u
+	if u == nil {
+		lb.objects.undo, lb.eviction.undo, lb.prefetcher.undo = nil, nil, nil
+		return
+	}
+	lb.objects.undo, lb.eviction.undo, lb.prefetcher.undo = u.objects, u.assigned, u.hinted
+}
+
+// Save the node of an object before the transaction first moves it
+func (u *txUndo[T, O]) moving(obj *serverpool.Object[T, O]) {
+	if u == nil {
+		return
+	}
+	if _, ok := u.placed[obj]; ok {
+		return
+	}
+	var node serverpool.Node[T, O]
+	if n := obj.Node(); n != nil {
+		node = *n
+	}
+	u.placed[obj] = node
+}
+
+// Save the node an object was transferred to before the transaction first
+// changes it
+func (u *txUndo[T, O]) transferring(transferred map[O]T, id O) {
+	if u != nil {
+		u.transferred.save(transferred, id)
+	}
+}
+
+// Record the nodes of an operation it added or removed
+func (u *txUndo[T, O]) step(added bool, nodes []serverpool.Node[T, O], result NodesResult[T, O]) {
+	s := txStep[T, O]{added: added}
+	for i, nr := range result.Nodes {
+		if nr.Status == StatusOK {
+			s.nodes = append(s.nodes, nodes[i])
+		}
+	}
+	u.steps = append(u.steps, s)
+}
+
+// Undo the operations applied by a failing transaction. Their side effects
+// were held back, so nothing is notified of the state put back.
+func (lb *loadBalancer[T, O]) rollback(u *txUndo[T, O]) {
+	lb.journal(nil)
+	for _, s := range slices.Backward(u.steps) {
+		for _, node := range slices.Backward(s.nodes) {
+			if s.added {
+				lb.removeNodeBuckets(node)
+				lb.tierRemove(node)
+			} else {
+				lb.addNodeBuckets(node)
+				lb.tierAdd(node)
+			}
+		}
+	}
+
+	for id, e := range u.objects {
+		if e.ok {
+			lb.objects.set(e.value)
+		} else if obj, ok := lb.objects.get(id); ok {
+			lb.detach(obj)
+			lb.objects.delete(id)
+		}
+	}
+	for obj, node := range u.placed {
+		var from serverpool.Node[T, O]
+		if n := obj.Node(); n != nil {
+			from = *n
+		}
+		if from == node {
+			continue
+		}
+		lb.detach(obj)
+		if node != nil {
+			node.AssignObject(obj)
+			obj.AssignToNode(&node)
+		}
+	}
+	lb.transferred = u.transferred.restore(lb.transferred)
+	lb.eviction.assigned = u.assigned.restore(lb.eviction.assigned)
+	lb.prefetcher.hinted = u.hinted.restore(lb.prefetcher.hinted)
+
+	// The objects queued are those deferred
+	lb.churn, lb.flaps = u.churn, u.flaps
+	lb.churn.queued = nil
+	if len(lb.churn.deferred) > 0 {
+		lb.churn.queued = make(map[*serverpool.Object[T, O]]bool, len(lb.churn.deferred))
+		for _, obj := range lb.churn.deferred {
+			lb.churn.queued[obj] = true
+		}
+	}
+	lb.drains, lb.dials, lb.stale = u.drains, u.dials, u.stale
+	lb.decommissions.reports = u.reports
+	if lb.records != nil {
+		lb.records.owners = u.owners
+	}
+	s := &lb.bucketStats
+	s.objectsSkewed = u.objectsSkewed
+	s.mu.Lock()
+	s.hits, s.total, s.hitsSkewed = u.hits, u.total, u.hitsSkewed
+	s.mu.Unlock()
+}
+
+// Run fn now, or hold it until the transaction being committed succeeds
+func (lb *loadBalancer[T, O]) afterCommit(fn func()) {
+	if lb.effects != nil {
+		*lb.effects = append(*lb.effects, fn)
+		return
+	}
+	fn()
+}
+
+// Check that the operations would succeed if applied in order
+func (lb *loadBalancer[T, O]) validate(ops []txOp[T, O]) error {
+	nodes := make(map[T]bool)
+	for node := range lb.sp.Nodes() {
+		nodes[node.Name()] = true
+	}
+	size := lb.sp.Len()
+
+	// Objects added or removed by earlier operations
+	objects := make(map[O]bool)
+	exists := func(obj *serverpool.Object[T, O]) bool {
+		if present, ok := objects[obj.Id]; ok {
+			return present
+		}
+		_, ok := lb.objects.get(obj.Id)
+		return ok
+	}
+
+	// Objects assigned, or else unassigned or removed, by earlier operations
+	assigned := make(map[O]bool)
+	checkEmpty := func(nodes []serverpool.Node[T, O]) error {
+		for _, node := range nodes {
+			// Where objects assigned earlier go depends on the membership
+			// when they are assigned, so any node could receive them
+			for id, a := range assigned {
+				if a {
+					return fmt.Errorf("%w: %v may be assigned %v", ErrNodeNotEmpty, node, id)
+				}
+			}
+
+			n, ok := lb.lookupNode(node)
+			if !ok {
+				continue
+			}
+			for obj := range n.Objects() {
+				if _, ok := assigned[obj.Id]; !ok {
+					return fmt.Errorf("%w: %v has %v", ErrNodeNotEmpty, n, obj)
+				}
+			}
+		}
+		return nil
+	}
+
+	now := lb.churn.clock()
+	for i, op := range ops {
+		var err error
+		switch op.op {
+		case ChangeAddNodes:
+			if len(op.nodes) == 0 {
+				err = errors.New("no nodes to add")
+			}
+			for _, node := range op.nodes {
+				if err != nil {
+					break
+				}
+				switch {
+				case lb.flaps.isQuarantined(node.Name(), now):
+					err = fmt.Errorf("%w: %v", ErrNodeQuarantined, node)
+				case nodes[node.Name()]:
+					err = fmt.Errorf("%v already exists", node)
+				}
+				nodes[node.Name()] = true
+				size++
+			}
+		case ChangeRemoveNodes:
+			switch {
+			case len(op.nodes) == 0:
+				err = errors.New("no nodes to remove")
+			case len(op.nodes) > size:
+				err = fmt.Errorf("cannot remove more nodes than the size of the working set %d", size)
+			case lb.removalPolicy == FailOnRemoval:
+				err = checkEmpty(op.nodes)
+			}
+			for _, node := range op.nodes {
+				if err != nil {
+					break
+				}
+				if !nodes[node.Name()] {
+					err = fmt.Errorf("%v not found", node)
+				}
+				delete(nodes, node.Name())
+				size--
+			}
+		case ChangeAddObjects:
+			if len(op.objects) == 0 {
+				err = errors.New("no objects to add")
+			}
+			for _, obj := range op.objects {
+				objects[obj.Id] = true
+			}
+		case ChangeRemoveObjects:
+			if len(op.objects) == 0 {
+				err = errors.New("no objects to remove")
+			}
+			for _, obj := range op.objects {
+				objects[obj.Id] = false
+				assigned[obj.Id] = false
+			}
+		case ChangeAssignObject, ChangeUnassignObject:
+			obj := op.objects[0]
+			switch {
+			case !exists(obj):
+				err = fmt.Errorf("%v not found", obj)
+			case op.op == ChangeAssignObject && size == 0:
+				err = fmt.Errorf("no node for %v", obj)
+			}
+			assigned[obj.Id] = op.op == ChangeAssignObject
+		}
+		if err != nil {
+			return fmt.Errorf("operation %d %v: %w", i+1, op.op, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"maps"
+	"net/netip"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestTransaction(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	mirror := newMirror(t, lb)
+	var changes []Change[string, string]
+	lb.(Feeder[string, string]).Feed(0, func(c Change[string, string]) { changes = append(changes, c) })
+
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	node1, node2 := newNode("node1"), newNode("node2")
+	obj := &serverpool.Object[string, string]{Id: "obj1"}
+
+	tx := lb.(Transactor[string, string]).Begin()
+	tx.AddNodes([]serverpool.Node[string, string]{node1, node2})
+	tx.AddObjects([]*serverpool.Object[string, string]{obj})
+	tx.AssignObject(obj)
+	if lb.NodeCount() != 0 {
+		t.Fatalf("expected queued operations to have no effect")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if lb.Version() != 1 || len(changes) != 1 || changes[0].Op != ChangeBatch || len(changes[0].Changes) != 3 {
+		t.Fatalf("expected a single batch of 3 changes, got %v", changes)
+	}
+	if obj.Node() == nil || lb.NodeCount() != 2 {
+		t.Fatalf("expected the transaction to be applied")
+	}
+	if mirror.Version() != 1 || mirror.NodeCount() != 2 {
+		t.Fatalf("expected the mirror to apply the batch")
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTransactionDone) {
+		t.Fatalf("expected ErrTransactionDone, got %v", err)
+	}
+
+	// A failing operation leaves the earlier ones unapplied
+	tx = lb.(Transactor[string, string]).Begin()
+	tx.AddNodes([]serverpool.Node[string, string]{newNode("node3")})
+	tx.RemoveNodes([]serverpool.Node[string, string]{newNode("node4")})
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("expected an error removing an unknown node")
+	}
+	if lb.Version() != 1 || lb.NodeCount() != 2 {
+		t.Fatalf("expected a failed transaction to have no effect")
+	}
+
+	tx = lb.(Transactor[string, string]).Begin()
+	tx.RemoveNodes([]serverpool.Node[string, string]{node1})
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTransactionDone) {
+		t.Fatalf("expected ErrTransactionDone, got %v", err)
+	}
+	if lb.NodeCount() != 2 {
+		t.Fatalf("expected a rolled back transaction to have no effect")
+	}
+}
+
+func TestTransactionUndo(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithPlacementConstraints(map[string]PlacementConstraint[netip.Addr, int]{
+		"apac-only": TagConstraint[netip.Addr, int]("region", "apac"),
+	}))
+	var nodes []serverpool.Node[netip.Addr, int]
+	for i, region := range []string{"eu", "us", "eu", "us"} {
+		node := NewTaggedServerNode[int](netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 1)}), map[string]string{"region": region})
+		nodes = append(nodes, &node)
+	}
+	if _, err := lb.AddNodes(nodes[:3]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[netip.Addr, int]
+	for i := 0; i < 40; i++ {
+		objs = append(objs, &serverpool.Object[netip.Addr, int]{Id: i})
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	want := make(map[int]serverpool.Node[netip.Addr, int])
+	for _, obj := range objs {
+		want[obj.Id] = *obj.Node()
+	}
+	version := lb.Version()
+	events, watch := lb.Watch(100)
+	defer watch.Unsubscribe()
+
+	// No node meets the constraint of the last operation, which the checks
+	// before applying do not cover
+	apac := &serverpool.Object[netip.Addr, int]{Id: 100, Constraints: []string{"apac-only"}}
+	tx := lb.(Transactor[netip.Addr, int]).Begin()
+	tx.RemoveNodes(nodes[1:2])
+	tx.AddNodes(nodes[3:])
+	tx.UnassignObject(objs[0])
+	tx.AddObjects([]*serverpool.Object[netip.Addr, int]{apac})
+	tx.AssignObject(apac)
+	if err := tx.Commit(); !errors.Is(err, ErrConstraintViolated) {
+		t.Fatalf("expected ErrConstraintViolated, got %v", err)
+	}
+
+	if lb.Version() != version || lb.NodeCount() != 3 {
+		t.Fatalf("expected the failed transaction to be undone")
+	}
+	for obj := range lb.Objects() {
+		if obj == apac {
+			t.Fatalf("expected %v to be removed again", obj)
+		}
+	}
+	for _, obj := range objs {
+		if n := obj.Node(); n == nil || *n != want[obj.Id] {
+			t.Fatalf("expected %v back on %v", obj, want[obj.Id])
+		}
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Events are held back until a transaction succeeds, so the first one
+	// seen comes after the failed transaction
+	if _, err := lb.AddNodes(nodes[3:]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case e := <-events:
+		if e.Type != EventNodeAdded || e.Node != nodes[3] {
+			t.Fatalf("expected %v added first, got %+v", nodes[3], e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected an event")
+	}
+}
+
+func TestTransactionJournal(t *testing.T) {
+	lb := NewLoadBalancerWithOptions(WithEviction(EvictionPolicy[netip.Addr, int]{
+		Capacity: func(serverpool.Node[netip.Addr, int]) int { return 100 },
+	})).(*loadBalancer[netip.Addr, int])
+	var nodes []serverpool.Node[netip.Addr, int]
+	for i := 0; i < 3; i++ {
+		node := NewServerNode[int](netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 1)}))
+		nodes = append(nodes, &node)
+	}
+	if _, err := lb.AddNodes(nodes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var objs []*serverpool.Object[netip.Addr, int]
+	for i := 0; i < 40; i++ {
+		obj := &serverpool.Object[netip.Addr, int]{Id: i}
+		objs = append(objs, obj)
+		if _, err := lb.AddObjects(objs[i:]); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	node := *objs[0].Node()
+	assigned := maps.Clone(lb.eviction.assigned)
+
+	// Only the objects the operations change are saved
+	u := lb.undoState()
+	lb.journal(u)
+	added := &serverpool.Object[netip.Addr, int]{Id: 100}
+	if err := lb.UnassignObject(objs[0]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.AddObjects([]*serverpool.Object[netip.Addr, int]{added}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AssignObject(added); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(u.placed) != 2 || len(u.objects) != 1 || len(u.assigned) > 2 {
+		t.Fatalf("expected only %v and %v saved, got %d placements, %d objects and %d times",
+			objs[0], added, len(u.placed), len(u.objects), len(u.assigned))
+	}
+
+	lb.rollback(u)
+	if lb.undo != nil || lb.objects.undo != nil || lb.eviction.undo != nil {
+		t.Fatalf("expected the journal to be cleared")
+	}
+	if n := objs[0].Node(); n == nil || *n != node {
+		t.Fatalf("expected %v back on %v", objs[0], node)
+	}
+	if _, ok := lb.objects.get(added.Id); ok || added.Node() != nil && *added.Node() != nil {
+		t.Fatalf("expected %v to be removed again", added)
+	}
+	if !maps.Equal(lb.eviction.assigned, assigned) {
+		t.Fatalf("expected the assignment times to be restored")
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
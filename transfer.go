@@ -0,0 +1,348 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Explicit transfer of object ownership between nodes
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+	"slices"
+)
+
+var (
+	// ErrTransferInProgress is returned when transferring an object that
+	// is already migrating
+	ErrTransferInProgress = errors.New("transfer in progress")
+
+	// ErrNodeFull is returned when moving more objects to a node than its
+	// capacity under the eviction policy allows
+	ErrNodeFull = errors.New("node is full")
+
+	// ErrTransferInterrupted is returned when the objects or the node of a
+	// transfer changed while the objects were copied
+	ErrTransferInterrupted = errors.New("transfer interrupted")
+)
+
+// TransferHooks let the application move an object's data during a transfer
+type TransferHooks[T, O comparable] struct {
+	// Called while the object is migrating and still owned by the source
+	// node, to copy its data to the destination. An error aborts the
+	// transfer and the object stays on the source node.
+	Copy func(m Move[T, O]) error
+
+	// Called once the destination owns the object, e.g. to release the
+	// data on the source node
+	Done func(m Move[T, O])
+}
+
+// TransferObject moves the object to the given node. The object enters the
+// migrating state, the Copy hook runs, and ownership then switches from the
+// source to the destination in one step, so the object is always owned by
+// exactly one node. The source is nil for an object that is not assigned.
+// Rebalancing leaves the object on the node, as it does objects of pinned
+// keys, until it is unassigned, and places it as if it was not transferred
+// while the node is not in the pool.
+func (lb *loadBalancer[T, O]) TransferObject(obj *serverpool.Object[T, O], to serverpool.Node[T, O]) error {
+	if lb.dryRun {
+		return lb.planTransferObject(obj, to)
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	var err error
+	lb.profiler.do("TransferObject", func() {
+		err = lb.runMoves(lb.transferCheck(obj, to), transferAborted(obj, to), unlocked)
+	})
+	return err
+}
+
+// Check a transfer of obj to node, returning the node registered in the
+// pool and the move of the object, none if it is on the node already
+func (lb *loadBalancer[T, O]) transferCheck(obj *serverpool.Object[T, O], to serverpool.Node[T, O]) moveCheck[T, O] {
+	return func() (serverpool.Node[T, O], []Move[T, O], error) {
+		o, ok := lb.objects.get(obj.Id)
+		if !ok {
+			return nil, nil, fmt.Errorf("%v not found", obj)
+		}
+		if _, ok := lb.migrating[o.Id]; ok {
+			return nil, nil, fmt.Errorf("%w: %v", ErrTransferInProgress, o)
+		}
+		node, ok := lb.lookupNode(to)
+		if !ok {
+			return nil, nil, fmt.Errorf("%v not found", to)
+		}
+		if err := lb.checkConstraints(o, node); err != nil {
+			return nil, nil, err
+		}
+
+		m := Move[T, O]{Object: o, To: node}
+		if from := o.Node(); from != nil {
+			m.From = *from
+		}
+		if m.From == node {
+			return node, nil, nil
+		}
+		return node, []Move[T, O]{m}, nil
+	}
+}
+
+// Error of a transfer whose copy failed
+func transferAborted[T, O comparable](obj *serverpool.Object[T, O], to serverpool.Node[T, O]) func([]Move[T, O], Move[T, O], error) error {
+	return func(_ []Move[T, O], _ Move[T, O], err error) error {
+		return fmt.Errorf("transfer of %v to %v aborted: %w", obj, to, err)
+	}
+}
+
+// Replay a transfer of the primary, whose hooks already ran
+func (lb *loadBalancer[T, O]) transferObjects(objects []*serverpool.Object[T, O], to serverpool.Node[T, O]) {
+	var moves []Move[T, O]
+	for _, obj := range objects {
+		if _, m, err := lb.transferCheck(obj, to)(); err == nil {
+			moves = append(moves, m...)
+		}
+	}
+	lb.switchOwners(moves)
+}
+
+// MoveObjects moves the objects with the given ids to the given node, all
+// of them or none. The whole set is checked first: every object must exist
+// and not be migrating, belong to the tier of the node if it has one, and
+// fit on the node within its capacity, and the node must not be draining.
+// The Copy hook runs for every object before ownership switches for all of
+// them, so a failing copy leaves every object where it was. The move is
+// published as a single ChangeTransferObject, and the objects stay on the
+// node as transferred objects do.
+func (lb *loadBalancer[T, O]) MoveObjects(ids []O, to serverpool.Node[T, O]) error {
+	if lb.dryRun {
+		_, _, err := lb.checkMoves(ids, to)
+		return err
+	}
+	if lb.readOnly {
+		return ErrReadOnly
+	}
+	var err error
+	lb.profiler.do("MoveObjects", func() {
+		err = lb.runMoves(lb.movesCheck(ids, to), movesAborted[T, O](to), unlocked)
+	})
+	return err
+}
+
+// Check of MoveObjects
+func (lb *loadBalancer[T, O]) movesCheck(ids []O, to serverpool.Node[T, O]) moveCheck[T, O] {
+	return func() (serverpool.Node[T, O], []Move[T, O], error) { return lb.checkMoves(ids, to) }
+}
+
+// Error of MoveObjects whose copy failed
+func movesAborted[T, O comparable](to serverpool.Node[T, O]) func([]Move[T, O], Move[T, O], error) error {
+	return func(moves []Move[T, O], failed Move[T, O], err error) error {
+		return fmt.Errorf("move of %d objects to %v aborted at %v: %w", len(moves), to, failed.Object, err)
+	}
+}
+
+// Checks the objects of a transfer can move to a node, returning the node
+// registered in the pool and the moves of the objects not on it yet
+type moveCheck[T, O comparable] func() (serverpool.Node[T, O], []Move[T, O], error)
+
+// Run checked moves in the steps of a transfer. The steps that read or
+// change the load balancer run through locked, the hooks run outside it so
+// that lookups and Migrating are served while objects are copied.
+func (lb *loadBalancer[T, O]) runMoves(check moveCheck[T, O], aborted func([]Move[T, O], Move[T, O], error) error, locked func(func())) error {
+	var moves []Move[T, O]
+	var err error
+	locked(func() { moves, err = lb.startMoves(check) })
+	if err != nil || len(moves) == 0 {
+		return err
+	}
+	failed, copyErr := lb.transferHooks.copy(moves)
+	locked(func() {
+		if copyErr != nil {
+			lb.abortMoves(moves)
+			err = aborted(moves, failed, copyErr)
+			return
+		}
+		err = lb.finishMoves(moves, check)
+	})
+	if err != nil {
+		return err
+	}
+	lb.transferHooks.done(moves)
+	return nil
+}
+
+// Run fn on a load balancer that is not shared
+func unlocked(fn func()) { fn() }
+
+// Transfers are planned or refused rather than run
+func (lb *loadBalancer[T, O]) planned() bool { return lb.dryRun || lb.readOnly }
+
+// Check moves and mark their objects migrating while the Copy hook runs
+func (lb *loadBalancer[T, O]) startMoves(check moveCheck[T, O]) ([]Move[T, O], error) {
+	_, moves, err := check()
+	if err != nil || len(moves) == 0 || lb.transferHooks.Copy == nil {
+		return moves, err
+	}
+	if lb.migrating == nil {
+		lb.migrating = make(map[O]Move[T, O])
+	}
+	for _, m := range moves {
+		lb.migrating[m.Object.Id] = m
+	}
+	return moves, nil
+}
+
+// Run the Copy hook of every move, returning the move that failed. The
+// load balancer may be changed meanwhile.
+func (h TransferHooks[T, O]) copy(moves []Move[T, O]) (Move[T, O], error) {
+	if h.Copy == nil {
+		return Move[T, O]{}, nil
+	}
+	for _, m := range moves {
+		if err := h.Copy(m); err != nil {
+			return m, err
+		}
+	}
+	return Move[T, O]{}, nil
+}
+
+// Run the Done hook of every move
+func (h TransferHooks[T, O]) done(moves []Move[T, O]) {
+	if h.Done == nil {
+		return
+	}
+	for _, m := range moves {
+		h.Done(m)
+	}
+}
+
+// End the migration of the objects of moves
+func (lb *loadBalancer[T, O]) abortMoves(moves []Move[T, O]) {
+	for _, m := range moves {
+		delete(lb.migrating, m.Object.Id)
+	}
+}
+
+// Switch the owners of moves whose copy succeeded, unless the objects or
+// the node changed while they were copied
+func (lb *loadBalancer[T, O]) finishMoves(moves []Move[T, O], check moveCheck[T, O]) error {
+	lb.abortMoves(moves)
+	if lb.transferHooks.Copy != nil {
+		if _, again, err := check(); err != nil {
+			return fmt.Errorf("%w: %w", ErrTransferInterrupted, err)
+		} else if !slices.Equal(again, moves) {
+			return ErrTransferInterrupted
+		}
+	}
+	lb.switchOwners(moves)
+	return nil
+}
+
+// Switch the objects of moves, all to the same node, over to it and keep
+// them there
+func (lb *loadBalancer[T, O]) switchOwners(moves []Move[T, O]) {
+	if len(moves) == 0 {
+		return
+	}
+	to := moves[0].To
+	objects := make([]*serverpool.Object[T, O], len(moves))
+	for i, m := range moves {
+		lb.detach(m.Object)
+		to.AssignObject(m.Object)
+		m.Object.AssignToNode(&to)
+		lb.notifyPlaced(m.Object, m.From, to)
+		if lb.transferred == nil {
+			lb.transferred = make(map[O]T)
+		}
+		lb.undo.transferring(lb.transferred, m.Object.Id)
+		lb.transferred[m.Object.Id] = to.Name()
+		objects[i] = m.Object
+	}
+	lb.publish(ChangeTransferObject, []serverpool.Node[T, O]{to}, objects)
+}
+
+// Check that every object of ids can move to the node, returning the node
+// registered in the pool and the moves of the objects not on it yet.
+// Objects that cannot move are reported with a ReassignmentError.
+func (lb *loadBalancer[T, O]) checkMoves(ids []O, to serverpool.Node[T, O]) (serverpool.Node[T, O], []Move[T, O], error) {
+	if len(ids) == 0 {
+		return nil, nil, errors.New("no objects to move")
+	}
+	node, ok := lb.lookupNode(to)
+	if !ok {
+		return nil, nil, fmt.Errorf("%v not found", to)
+	}
+	if _, ok := lb.drains[node.Name()]; ok {
+		return nil, nil, fmt.Errorf("%v is draining", node)
+	}
+
+	var tier string
+	if lb.tiers.of != nil {
+		tier = lb.tiers.of(node)
+	}
+	var moves []Move[T, O]
+	var errs ReassignmentError[O]
+	seen := make(map[O]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		o, ok := lb.objects.get(id)
+		if !ok {
+			errs.add(id, ErrObjectNotFound)
+			continue
+		}
+		if _, ok := lb.migrating[id]; ok {
+			errs.add(id, ErrTransferInProgress)
+			continue
+		}
+		if lb.tiers.of != nil && o.Tier != "" && o.Tier != tier {
+			errs.add(id, fmt.Errorf("%v is not in tier %q", node, o.Tier))
+			continue
+		}
+		if err := lb.checkConstraints(o, node); err != nil {
+			errs.add(id, err)
+			continue
+		}
+		m := Move[T, O]{Object: o, To: node}
+		if from := o.Node(); from != nil {
+			m.From = *from
+		}
+		if m.From != node {
+			moves = append(moves, m)
+		}
+	}
+	if len(errs.Errors) > 0 {
+		return nil, nil, &errs
+	}
+
+	if p := lb.eviction.policy; p != nil && p.Capacity != nil {
+		held := 0
+		for range node.Objects() {
+			held++
+		}
+		if capacity := p.Capacity(node); held+len(moves) > capacity {
+			return nil, nil, fmt.Errorf("%w: %v holds %d objects of %d, cannot take %d more",
+				ErrNodeFull, node, held, capacity, len(moves))
+		}
+	}
+	return node, moves, nil
+}
+
+// Migrating reports the transfer of the object that is in progress, if any
+func (lb *loadBalancer[T, O]) Migrating(obj *serverpool.Object[T, O]) (Move[T, O], bool) {
+	m, ok := lb.migrating[obj.Id]
+	return m, ok
+}
+
+// Node an object was transferred to, while it is in the pool
+func (lb *loadBalancer[T, O]) transferredTo(obj *serverpool.Object[T, O]) (serverpool.Node[T, O], bool) {
+	name, ok := lb.transferred[obj.Id]
+	if !ok {
+		return nil, false
+	}
+	return lb.nodeByName(name)
+}
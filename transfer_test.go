@@ -0,0 +1,225 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+	"testing"
+)
+
+func TestTransferObject(t *testing.T) {
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	obj := &serverpool.Object[string, string]{Id: "obj1"}
+
+	var lb LoadBalancer[string, string]
+	var copyErr error
+	var done []Move[string, string]
+	lb = NewLoadBalancerWithOptions(WithTransferHooks(TransferHooks[string, string]{
+		Copy: func(m Move[string, string]) error {
+			// The source keeps ownership while the data is copied
+			if _, ok := lb.Migrating(obj); !ok {
+				t.Errorf("expected %v to be migrating", obj)
+			}
+			if *obj.Node() != m.From || len(node1.objects)+len(node2.objects) != 1 {
+				t.Errorf("expected %v to be owned by %v only", obj, m.From)
+			}
+			return copyErr
+		},
+		Done: func(m Move[string, string]) { done = append(done, m) },
+	}))
+	mirror := newMirror(t, lb)
+
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	from, to := *obj.Node(), serverpool.Node[string, string](node1)
+	if from == node1 {
+		to = node2
+	}
+
+	copyErr = errors.New("copy failed")
+	if err := lb.TransferObject(obj, to); !errors.Is(err, copyErr) {
+		t.Fatalf("expected copy error, got %v", err)
+	}
+	if *obj.Node() != from || len(done) != 0 {
+		t.Fatalf("expected aborted transfer to leave %v on %v", obj, from)
+	}
+
+	copyErr = nil
+	if err := lb.TransferObject(obj, to); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if *obj.Node() != to || len(to.(*mockNode).objects) != 1 || len(from.(*mockNode).objects) != 0 {
+		t.Fatalf("expected %v to be owned by %v only", obj, to)
+	}
+	if _, ok := lb.Migrating(obj); ok {
+		t.Fatalf("expected transfer to be complete")
+	}
+	if len(done) != 1 || done[0].From != from || done[0].To != to {
+		t.Fatalf("expected one completed transfer from %v to %v, got %v", from, to, done)
+	}
+
+	for o := range mirror.Objects() {
+		if (*o.Node()).Name() != to.Name() {
+			t.Fatalf("expected mirror to follow the transfer, got %v", *o.Node())
+		}
+	}
+
+	// Rebalancing leaves the transferred object where it was put
+	if _, err := lb.RebalanceAll(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if *obj.Node() != to {
+		t.Fatalf("expected %v to stay on %v, got %v", obj, to, *obj.Node())
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Until it is unassigned
+	if err := lb.UnassignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if *obj.Node() != from {
+		t.Fatalf("expected %v to be back on %v, got %v", obj, from, *obj.Node())
+	}
+}
+
+func TestConcurrentTransferObject(t *testing.T) {
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	obj := &serverpool.Object[string, string]{Id: "obj1"}
+
+	var lb LoadBalancer[string, string]
+	removed := make(chan error, 1)
+	lb = NewConcurrentLoadBalancer(WithTransferHooks(TransferHooks[string, string]{
+		Copy: func(m Move[string, string]) error {
+			// Other goroutines see the transfer and can change the load
+			// balancer while the data is copied
+			seen := make(chan bool)
+			go func() {
+				_, ok := lb.Migrating(obj)
+				seen <- ok
+			}()
+			if !<-seen {
+				t.Errorf("expected %v to be migrating", obj)
+			}
+			if _, err := lb.GetNode(obj.Name()); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			go func() {
+				_, err := lb.RemoveNodes([]serverpool.Node[string, string]{m.To})
+				removed <- err
+			}()
+			if err := <-removed; err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			return nil
+		},
+	}))
+
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	to := serverpool.Node[string, string](node1)
+	if *obj.Node() == node1 {
+		to = node2
+	}
+
+	// The destination left while the object was copied
+	if err := lb.TransferObject(obj, to); !errors.Is(err, ErrTransferInterrupted) {
+		t.Fatalf("expected interrupted transfer, got %v", err)
+	}
+	if *obj.Node() == to {
+		t.Fatalf("expected %v not to be on %v", obj, to)
+	}
+	if _, ok := lb.Migrating(obj); ok {
+		t.Fatalf("expected transfer to be over")
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestMoveObjects(t *testing.T) {
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	objs := []*serverpool.Object[string, string]{
+		{Id: "obj1", Preferred: []string{"node1"}}, {Id: "obj2", Preferred: []string{"node1"}},
+		{Id: "obj3", Preferred: []string{"node1"}}, {Id: "obj4", Preferred: []string{"node2"}}}
+
+	failing := ""
+	lb := NewLoadBalancerWithOptions(
+		WithEviction(EvictionPolicy[string, string]{Capacity: func(serverpool.Node[string, string]) int { return 3 }}),
+		WithTransferHooks(TransferHooks[string, string]{Copy: func(m Move[string, string]) error {
+			if m.Object.Id == failing {
+				return errors.New("copy failed")
+			}
+			return nil
+		}}))
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	version := lb.Version()
+
+	// Nothing moves unless everything can
+	var rerr *ReassignmentError[string]
+	if err := lb.MoveObjects([]string{"obj1", "missing"}, node2); !errors.As(err, &rerr) || !errors.Is(rerr.Errors["missing"], ErrObjectNotFound) {
+		t.Fatalf("expected the missing object to be reported, got %v", err)
+	}
+	if err := lb.MoveObjects([]string{"obj1", "obj2", "obj3"}, node2); !errors.Is(err, ErrNodeFull) {
+		t.Fatalf("expected ErrNodeFull, got %v", err)
+	}
+	failing = "obj2"
+	if err := lb.MoveObjects([]string{"obj1", "obj2"}, node2); err == nil {
+		t.Fatalf("expected the failing copy to abort the move")
+	}
+	if len(node1.objects) != 3 || lb.Version() != version {
+		t.Fatalf("expected no object to move, got %d objects on node1 at version %d", len(node1.objects), lb.Version())
+	}
+
+	// Objects already on the node do not count against its capacity
+	failing = ""
+	if err := lb.MoveObjects([]string{"obj1", "obj2", "obj4"}, node2); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(node1.objects) != 1 || len(node2.objects) != 3 || *objs[0].Node() != node2 {
+		t.Fatalf("expected obj1 and obj2 on node2, got %v and %v", node1.objects, node2.objects)
+	}
+	var changes []Change[string, string]
+	cancel, err := lb.(Feeder[string, string]).Feed(version, func(c Change[string, string]) { changes = append(changes, c) })
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	cancel()
+	if lb.Version() != version+1 || len(changes) != 1 || changes[0].Op != ChangeTransferObject || len(changes[0].Objects) != 2 {
+		t.Fatalf("expected one change moving 2 objects, got %v", changes)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Generation of HAProxy and nginx upstream configuration
+
+package main
+
+import (
+	"net/netip"
+	"proxyconf"
+	"serverpool"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Writes the proxy configuration after each command
+type upstreamWriter struct {
+	path   string
+	format proxyconf.Format
+	name   string
+	port   uint32
+	reload []string
+}
+
+func newUpstreamWriter(path, format, name string, port uint32, reload string) *upstreamWriter {
+	return &upstreamWriter{path: path, format: proxyconf.Format(format), name: name, port: port,
+		reload: strings.Fields(reload)}
+}
+
+// Render the nodes of the load balancer, each once with its weight and
+// named after its first bucket, reloading the proxy on changes
+func (u *upstreamWriter) update(lb LoadBalancer[netip.Addr, int]) {
+	upstream := proxyconf.Upstream{Name: u.name}
+	for node, bucket := range lb.Nodes() {
+		upstream.Servers = append(upstream.Servers, proxyconf.Server{Name: "node" + strconv.Itoa(bucket),
+			Address: node.Name().String(), Port: u.port, Weight: uint32(serverpool.Weight(node))})
+	}
+
+	// Keep the order stable so unchanged nodes do not trigger a reload
+	slices.SortFunc(upstream.Servers, func(a, b proxyconf.Server) int { return strings.Compare(a.Name, b.Name) })
+	if _, err := proxyconf.WriteFile(u.path, u.format, upstream, u.reload); err != nil {
+		out.info("Error writing proxy configuration:", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Consistency checks of the load balancer's internal state
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"serverpool"
+)
+
+// ErrInvariant is wrapped by every violation Verify reports
+var ErrInvariant = errors.New("invariant violated")
+
+// Verify checks that the hasher, server pool, nodes and objects agree with
+// each other and returns every violation found. A mirror shares its nodes
+// with the primary, so only the primary's assignments can be verified.
+func (lb *loadBalancer[T, O]) Verify() error {
+	var errs []error
+	violation := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvariant}, args...)...))
+	}
+
+	// The hasher has every bucket of the nodes in the pool
+	nodes := make(map[T]serverpool.Node[T, O])
+	for node := range lb.sp.Nodes() {
+		if other, ok := nodes[node.Name()]; ok {
+			violation("%v and %v share a name", node, other)
+		}
+		nodes[node.Name()] = node
+	}
+	buckets := 0
+	for range lb.sp.Buckets() {
+		buckets++
+	}
+	if buckets != lb.ch.Size() {
+		violation("pool has %d buckets but the hasher has %d", buckets, lb.ch.Size())
+	}
+
+	// Every tier hashes over nodes of the pool
+	for name, t := range lb.tiers.tiers {
+		if len(t.nodes) != t.ch.Size() {
+			violation("tier %q has %d nodes but its hasher has %d buckets", name, len(t.nodes), t.ch.Size())
+		}
+		for _, node := range t.nodes {
+			if nodes[node.Name()] != node {
+				violation("%v of tier %q is not in the pool", node, name)
+			}
+		}
+	}
+
+	// Objects on a node are stored and record that node, draining nodes
+	// keep their objects until they are moved off
+	for _, d := range lb.drains {
+		nodes[d.node.Name()] = d.node
+	}
+	onNode := make(map[*serverpool.Object[T, O]]serverpool.Node[T, O])
+	for _, node := range nodes {
+		for obj := range node.Objects() {
+			if stored, ok := lb.objects.get(obj.Id); !ok || stored != obj {
+				violation("%v on %v is not stored", obj, node)
+			}
+			if n := obj.Node(); n == nil || *n != node {
+				violation("%v on %v is not recorded as assigned to it", obj, node)
+			}
+			onNode[obj] = node
+		}
+	}
+
+	// Assigned objects are on a node of the pool meeting their constraints
+	// and every key has a node. Unless moves were left for RebalanceAll or
+	// dials shift keys over time, objects are on the node they map to.
+	settled := !lb.stale && len(lb.dials) == 0
+	for obj := range lb.objects.all() {
+		if n := obj.Node(); n != nil && *n != nil {
+			if onNode[obj] != *n {
+				violation("%v is recorded on %v but is not among its objects", obj, *n)
+			}
+			if err := lb.checkConstraints(obj, *n); err != nil {
+				violation("%w", err)
+			}
+			if _, draining := lb.drains[(*n).Name()]; settled && !draining {
+				if to, err := lb.placement(obj); err == nil && to != *n {
+					violation("%v is on %v but maps to %v", obj, *n, to)
+				}
+			}
+		}
+		if lb.ch.Size() > 0 {
+			if _, err := lb.mapKey(obj.Name()); err != nil {
+				violation("%v has no node: %v", obj, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
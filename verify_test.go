@@ -0,0 +1,55 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"serverpool"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	lb := NewLoadBalancer[string, string]()
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+	node1, node2 := newNode("node1"), newNode("node2")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	objs := []*serverpool.Object[string, string]{{Id: "obj1"}, {Id: "obj2"}}
+	if _, err := lb.AddObjects(objs); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, obj := range objs {
+		if err := lb.AssignObject(obj); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// An object put on a node behind the load balancer's back
+	node1.AssignObject(&serverpool.Object[string, string]{Id: "stray"})
+	if err := lb.Verify(); !errors.Is(err, ErrInvariant) {
+		t.Fatalf("expected ErrInvariant, got %v", err)
+	}
+	node1.UnassignObject(&serverpool.Object[string, string]{Id: "stray"})
+
+	// An object recorded on a node it does not map to
+	obj := objs[0]
+	from := *obj.Node()
+	to := serverpool.Node[string, string](node1)
+	if from == to {
+		to = node2
+	}
+	from.UnassignObject(obj)
+	to.AssignObject(obj)
+	obj.AssignToNode(&to)
+	if err := lb.Verify(); !errors.Is(err, ErrInvariant) {
+		t.Fatalf("expected ErrInvariant, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+//go:build js && wasm
+
+// JavaScript bindings computing the key to node mapping from a topology
+// snapshot exported by the load balancer. Build with
+//
+//	GOOS=js GOARCH=wasm go build -o loadbalance.wasm ./wasm
+//
+// and load it with wasm_exec.js from the Go distribution. The module
+// defines a global loadbalance object with the functions
+//
+//	loadTopology(json)  load a snapshot, returns an error message or null
+//	getBucket(key)      bucket of the key, -1 if there is none
+//	getNode(key)        node name of the key, null if there is none
+
+package main
+
+import (
+	"consistenthash"
+	"syscall/js"
+)
+
+// Lookup loaded by the last successful loadTopology call
+var lookup *consistenthash.TopologyLookup
+
+func loadTopology(_ js.Value, args []js.Value) any {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return "loadTopology expects a JSON string"
+	}
+
+	l, err := consistenthash.ParseTopologyLookup([]byte(args[0].String()))
+	if err != nil {
+		return err.Error()
+	}
+	lookup = l
+	return nil
+}
+
+func getBucket(_ js.Value, args []js.Value) any {
+	if lookup == nil || len(args) != 1 {
+		return -1
+	}
+	return lookup.GetBucket(args[0].String())
+}
+
+func getNode(_ js.Value, args []js.Value) any {
+	if lookup == nil || len(args) != 1 {
+		return nil
+	}
+	if node, ok := lookup.GetNode(args[0].String()); ok {
+		return node
+	}
+	return nil
+}
+
+func main() {
+	js.Global().Set("loadbalance", js.ValueOf(map[string]any{
+		"loadTopology": js.FuncOf(loadTopology),
+		"getBucket":    js.FuncOf(getBucket),
+		"getNode":      js.FuncOf(getNode),
+	}))
+
+	// Keep the functions alive for the lifetime of the page or worker
+	select {}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Subscriptions to typed events of node and object changes
+
+package main
+
+import (
+	"serverpool"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Types of events only delivered to subscriptions
+const (
+	EventNodeAdded        = "NodeAdded"
+	EventObjectUnassigned = "ObjectUnassigned"
+)
+
+// DefaultSubscriptionBuffer is the number of events waiting for a
+// subscriber beyond which new ones are dropped
+const DefaultSubscriptionBuffer = 1024
+
+// Event is a change of a node or of the node of an object
+type Event[T, O comparable] struct {
+	// One of EventNodeAdded, EventNodeRemoved, EventObjectAssigned,
+	// EventObjectMoved, EventObjectUnassigned, EventObjectCollected,
+	// EventObjectEvicted, EventBucketHitsSkewed or EventBucketObjectsSkewed
+	Type string
+	Time time.Time
+
+	// Node added or removed, the node an object was assigned or moved to,
+	// or the node it was unassigned, collected or evicted from
+	Node serverpool.Node[T, O]
+
+	// Id of the object of an object event
+	Object O
+
+	// Node a moved object was on
+	From serverpool.Node[T, O]
+
+	// Bucket of a bucket event, owned by Node
+	Bucket int
+}
+
+// Subscription delivers events to a subscriber in the order they happened
+// from a goroutine of its own, so unlike Feed consumers, subscribers may
+// call back into the load balancer. Events wait in a buffer while the
+// subscriber is busy and are dropped once it is full.
+type Subscription[T, O comparable] struct {
+	fn     func(Event[T, O])
+	types  []string
+	buffer int
+
+	// Takes the subscription off the load balancer
+	remove func()
+
+	// Closed by Unsubscribe to interrupt a delivery in progress
+	stop chan struct{}
+
+	// Called once delivery is over after Unsubscribe, if set
+	closed func()
+
+	mu      sync.Mutex
+	pending []Event[T, O]
+	dropped uint64
+
+	// Delivery goroutine is running
+	running bool
+
+	// Unsubscribe was called
+	stopped bool
+}
+
+// Subscriptions registered with a load balancer
+type subscriptions[T, O comparable] struct {
+	subs map[int]*Subscription[T, O]
+
+	// Id of the next subscription
+	next int
+}
+
+// Subscribe calls fn with the events of the given types, or of all types
+// if none are given, until Unsubscribe is called. Up to buffer events wait
+// for fn, DefaultSubscriptionBuffer if buffer is not positive.
+func (lb *loadBalancer[T, O]) Subscribe(fn func(Event[T, O]), buffer int, types ...string) *Subscription[T, O] {
+	return lb.subscribe(newSubscription(fn, buffer, types))
+}
+
+// Watch sends the events of the given types, or of all types if none are
+// given, on the returned channel, which is closed after Unsubscribe. Up to
+// buffer events wait for the receiver, DefaultSubscriptionBuffer if buffer
+// is not positive.
+func (lb *loadBalancer[T, O]) Watch(buffer int, types ...string) (<-chan Event[T, O], *Subscription[T, O]) {
+	ch, s := newWatch[T, O](buffer, types)
+	return ch, lb.subscribe(s)
+}
+
+func newSubscription[T, O comparable](fn func(Event[T, O]), buffer int, types []string) *Subscription[T, O] {
+	if buffer <= 0 {
+		buffer = DefaultSubscriptionBuffer
+	}
+	return &Subscription[T, O]{fn: fn, types: slices.Clone(types), buffer: buffer, stop: make(chan struct{})}
+}
+
+// Create a subscription sending events on a channel
+func newWatch[T, O comparable](buffer int, types []string) (chan Event[T, O], *Subscription[T, O]) {
+	ch := make(chan Event[T, O])
+	s := newSubscription[T, O](nil, buffer, types)
+	s.fn = func(e Event[T, O]) {
+		select {
+		case ch <- e:
+		case <-s.stop:
+		}
+	}
+	s.closed = func() { close(ch) }
+	return ch, s
+}
+
+// Register a subscription
+func (lb *loadBalancer[T, O]) subscribe(s *Subscription[T, O]) *Subscription[T, O] {
+	if lb.subscriptions.subs == nil {
+		lb.subscriptions.subs = make(map[int]*Subscription[T, O])
+	}
+	id := lb.subscriptions.next
+	lb.subscriptions.next++
+	lb.subscriptions.subs[id] = s
+	s.remove = func() { delete(lb.subscriptions.subs, id) }
+	return s
+}
+
+// Send an event to the subscriptions to its type, once the transaction
+// being committed succeeds
+func (lb *loadBalancer[T, O]) emit(e Event[T, O]) {
+	lb.afterCommit(func() {
+		for _, s := range lb.subscriptions.subs {
+			if len(s.types) == 0 || slices.Contains(s.types, e.Type) {
+				s.enqueue(e)
+			}
+		}
+	})
+}
+
+func (s *Subscription[T, O]) enqueue(e Event[T, O]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if len(s.pending) >= s.buffer {
+		s.dropped++
+		return
+	}
+	s.pending = append(s.pending, e)
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+}
+
+// Deliver the pending events in order until there are none left or the
+// subscription is cancelled
+func (s *Subscription[T, O]) run() {
+	for {
+		s.mu.Lock()
+		if s.stopped || len(s.pending) == 0 {
+			s.running = false
+			stopped := s.stopped
+			s.mu.Unlock()
+			if stopped && s.closed != nil {
+				s.closed()
+			}
+			return
+		}
+		e := s.pending[0]
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+
+		s.fn(e)
+	}
+}
+
+// Unsubscribe stops the delivery of events, dropping those still waiting.
+// An event being delivered may still reach the subscriber. Calling it again
+// has no effect.
+func (s *Subscription[T, O]) Unsubscribe() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	s.pending = nil
+	running := s.running
+	s.mu.Unlock()
+
+	s.remove()
+	close(s.stop)
+	if !running && s.closed != nil {
+		s.closed()
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full
+func (s *Subscription[T, O]) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Tell the subscriptions of a node joining
+func (lb *loadBalancer[T, O]) notifyAdded(node serverpool.Node[T, O]) {
+	lb.emit(Event[T, O]{Type: EventNodeAdded, Time: lb.churn.clock(), Node: node})
+}
+
+// Tell the subscriptions of an object taken off a node without a new one
+// and record it unassigned
+func (lb *loadBalancer[T, O]) notifyUnassigned(obj *serverpool.Object[T, O], from serverpool.Node[T, O]) {
+	defer lb.rebalances.enter(PhaseCallbacks)()
+	lb.putRecord(obj, nil)
+	lb.emit(Event[T, O]{Type: EventObjectUnassigned, Time: lb.churn.clock(), Node: from, Object: obj.Id})
+}
+
+// Tell the subscriptions of the removal of a node that is still there, if
+// the fault injector calls for it
+func (lb *loadBalancer[T, O]) injectRemoval() {
+	k, ok := lb.faults.SpuriousRemoval(lb.sp.Len())
+	if !ok {
+		return
+	}
+	for node := range lb.sp.Nodes() {
+		if k == 0 {
+			lb.emit(Event[T, O]{Type: EventNodeRemoved, Time: lb.churn.clock(), Node: node})
+			return
+		}
+		k--
+	}
+}
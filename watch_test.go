@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"serverpool"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	lb := NewConcurrentLoadBalancer[string, string]()
+	newNode := func(id string) *mockNode {
+		return &mockNode{ID: id, objects: make(map[string]*serverpool.Object[string, string])}
+	}
+
+	// Subscribers run outside the lock, so they may call back
+	events := make(chan Event[string, string], 100)
+	sub := lb.Subscribe(func(e Event[string, string]) {
+		lb.NodeCount()
+		events <- e
+	}, 0)
+	ch, watch := lb.Watch(0, EventNodeAdded, EventNodeRemoved)
+
+	node1, node2 := newNode("node1"), newNode("node2")
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	obj := &serverpool.Object[string, string]{Id: "obj"}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	on := *obj.Node()
+	other := serverpool.Node[string, string](node1)
+	if on == node1 {
+		other = node2
+	}
+	if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{on}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.UnassignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	next := func(ch <-chan Event[string, string]) Event[string, string] {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected an event")
+		}
+		return Event[string, string]{}
+	}
+	want := []Event[string, string]{
+		{Type: EventNodeAdded, Node: node1},
+		{Type: EventNodeAdded, Node: node2},
+		{Type: EventObjectAssigned, Node: on, Object: "obj"},
+		{Type: EventNodeRemoved, Node: on},
+		{Type: EventObjectMoved, Node: other, Object: "obj", From: on},
+		{Type: EventObjectUnassigned, Node: other, Object: "obj"},
+	}
+	for _, w := range want {
+		e := next(events)
+		e.Time = time.Time{}
+		if e != w {
+			t.Fatalf("expected %+v, got %+v", w, e)
+		}
+	}
+	for _, w := range []Event[string, string]{want[0], want[1], want[3]} {
+		if e := next(ch); e.Type != w.Type || e.Node != w.Node {
+			t.Fatalf("expected %+v, got %+v", w, e)
+		}
+	}
+
+	// Watching closes the channel once unsubscribed, and events beyond
+	// the buffer of a receiver that is not reading are dropped
+	watch.Unsubscribe()
+	if _, ok := <-ch; ok {
+		t.Fatalf("expected the channel closed")
+	}
+	ch, watch = lb.Watch(1)
+	for i := range 5 {
+		if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode(fmt.Sprintf("node%d", i+3))}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if watch.Dropped() == 0 {
+		t.Fatalf("expected events dropped")
+	}
+	watch.Unsubscribe()
+	watch.Unsubscribe()
+	for range ch {
+	}
+
+	sub.Unsubscribe()
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{newNode("node9")}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for len(events) > 0 {
+		if e := <-events; e.Node.Name() == "node9" {
+			t.Fatalf("expected no events after unsubscribing, got %+v", e)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Webhooks notified of object assignments, moves and collections and node
+// removals
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"serverpool"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of webhook events
+const (
+	EventObjectAssigned  = "ObjectAssigned"
+	EventObjectMoved     = "ObjectMoved"
+	EventNodeRemoved     = "NodeRemoved"
+	EventObjectCollected = "ObjectCollected"
+	EventObjectEvicted   = "ObjectEvicted"
+)
+
+// Headers of webhook requests. The signature is "hmac-sha256=<hex>" over
+// the timestamp, a dot and the body, so a request cannot be replayed later
+// with another timestamp.
+const (
+	WebhookIDHeader        = "Webhook-Id"
+	WebhookTimestampHeader = "Webhook-Timestamp"
+	WebhookSignatureHeader = "Webhook-Signature"
+)
+
+// DefaultWebhookAttempts is the number of times an event is sent before it
+// is dropped
+const DefaultWebhookAttempts = 8
+
+// Events waiting for a webhook beyond which new ones are dropped
+const webhookQueueLimit = 10000
+
+// ErrBadWebhookSignature is returned by VerifyWebhook for requests that
+// are unsigned or whose signature does not match
+var ErrBadWebhookSignature = errors.New("bad webhook signature")
+
+// WebhookEvent is the body of a webhook request
+type WebhookEvent[T, O comparable] struct {
+	// Sequence number of the event, the same for every attempt so
+	// receivers can drop redeliveries
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Object assigned, moved, collected or evicted
+	Object *O `json:"object,omitempty"`
+
+	// Node an object was assigned or moved to, the node a stray object was
+	// collected or an object evicted from, or the removed node
+	Node T `json:"node"`
+
+	// Node a moved object was on
+	From *T `json:"from,omitempty"`
+}
+
+// Webhook is an endpoint notified of events with a POST of the event as
+// JSON. An event is sent again with backoff until a 2xx response or it
+// runs out of attempts. Events are sent one at a time in the order they
+// happened.
+type Webhook struct {
+	URL string
+
+	// Event types to send, all of them if empty
+	Events []string
+
+	// HMAC key signing the requests, unsigned if empty
+	Secret []byte
+
+	// Attempts per event, DefaultWebhookAttempts if 0
+	Attempts int
+
+	// HTTP client, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// Delivers events to the webhooks
+type webhooks[T, O comparable] struct {
+	endpoints []*webhookEndpoint[T, O]
+
+	// Called with delivery errors and dropped events, if set
+	onError func(error)
+
+	// Id of the last event
+	last uint64
+}
+
+type webhookEndpoint[T, O comparable] struct {
+	Webhook
+
+	mu      sync.Mutex
+	pending []WebhookEvent[T, O]
+
+	// Delivery goroutine is running
+	running bool
+}
+
+// Send an event to the webhooks, once the transaction being committed
+// succeeds
+func (lb *loadBalancer[T, O]) notifyWebhooks(e WebhookEvent[T, O]) {
+	lb.afterCommit(func() { lb.webhooks.notify(e) })
+}
+
+// Send an event to the webhooks subscribed to its type
+func (w *webhooks[T, O]) notify(e WebhookEvent[T, O]) {
+	if w == nil {
+		return
+	}
+	w.last++
+	e.ID = w.last
+	for _, ep := range w.endpoints {
+		if len(ep.Events) == 0 || slices.Contains(ep.Events, e.Type) {
+			ep.enqueue(e, w.onError)
+		}
+	}
+}
+
+func (ep *webhookEndpoint[T, O]) enqueue(e WebhookEvent[T, O], onError func(error)) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if len(ep.pending) >= webhookQueueLimit {
+		if onError != nil {
+			onError(fmt.Errorf("webhook %s: queue full, dropped event %d", ep.URL, e.ID))
+		}
+		return
+	}
+	ep.pending = append(ep.pending, e)
+	if !ep.running {
+		ep.running = true
+		go ep.run(onError)
+	}
+}
+
+// Send the pending events in order until there are none left
+func (ep *webhookEndpoint[T, O]) run(onError func(error)) {
+	for {
+		ep.mu.Lock()
+		if len(ep.pending) == 0 {
+			ep.running = false
+			ep.mu.Unlock()
+			return
+		}
+		e := ep.pending[0]
+		ep.mu.Unlock()
+
+		ep.deliver(e, onError)
+
+		ep.mu.Lock()
+		ep.pending = ep.pending[1:]
+		ep.mu.Unlock()
+	}
+}
+
+// Send an event until it is accepted or out of attempts, passing each
+// failed attempt to onError if not nil
+func (ep *webhookEndpoint[T, O]) deliver(e WebhookEvent[T, O], onError func(error)) {
+	report := func(err error) {
+		if onError != nil {
+			onError(err)
+		}
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		report(fmt.Errorf("webhook %s: dropped event %d: %w", ep.URL, e.ID, err))
+		return
+	}
+	attempts := ep.Attempts
+	if attempts <= 0 {
+		attempts = DefaultWebhookAttempts
+	}
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := ep.post(e.ID, body)
+		if err == nil {
+			return
+		}
+		if attempt == attempts {
+			report(fmt.Errorf("webhook %s: dropped event %d after %d attempts: %w", ep.URL, e.ID, attempts, err))
+			return
+		}
+		report(fmt.Errorf("webhook %s: event %d, attempt %d: %w", ep.URL, e.ID, attempt, err))
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+func (ep *webhookEndpoint[T, O]) post(id uint64, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, strconv.FormatUint(id, 10))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if len(ep.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, signWebhook(ep.Secret, timestamp, body))
+	}
+
+	client := ep.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strconv.Quote(string(msg)))
+	}
+	return nil
+}
+
+func webhookMAC(secret []byte, timestamp string, body []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(timestamp + "."))
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	return "hmac-sha256=" + hex.EncodeToString(webhookMAC(secret, timestamp, body))
+}
+
+// VerifyWebhook checks the signature of a webhook request with its
+// timestamp and body, for receivers written in Go
+func VerifyWebhook(secret []byte, timestamp string, body []byte, signature string) error {
+	sum, ok := strings.CutPrefix(signature, "hmac-sha256=")
+	if !ok {
+		return ErrBadWebhookSignature
+	}
+	got, err := hex.DecodeString(sum)
+	if err != nil || !hmac.Equal(got, webhookMAC(secret, timestamp, body)) {
+		return ErrBadWebhookSignature
+	}
+	return nil
+}
+
+// Notify the subscriptions and webhooks of an object placed on a node, as
+// moved if it was on another node, and record its new owner. Objects held
+// back by the movement budget were taken off their node, so they are
+// reported as assigned once placed.
+func (lb *loadBalancer[T, O]) notifyPlaced(obj *serverpool.Object[T, O], from, to serverpool.Node[T, O]) {
+	defer lb.rebalances.enter(PhaseCallbacks)()
+	if from == nil {
+		lb.eviction.touch(obj.Id, lb.churn.clock())
+	}
+	if from != nil && from.Name() == to.Name() {
+		return
+	}
+	lb.putRecord(obj, to)
+	event := Event[T, O]{Type: EventObjectAssigned, Time: lb.churn.clock(), Node: to, Object: obj.Id}
+	if from != nil {
+		event.Type, event.From = EventObjectMoved, from
+	}
+	lb.emit(event)
+
+	if lb.webhooks == nil || lb.readOnly {
+		return
+	}
+	id := obj.Id
+	e := WebhookEvent[T, O]{Type: EventObjectAssigned, Time: lb.churn.clock(), Object: &id, Node: to.Name()}
+	if from != nil {
+		name := from.Name()
+		e.Type, e.From = EventObjectMoved, &name
+	}
+	lb.notifyWebhooks(e)
+}
+
+// Notify the subscriptions and webhooks of a node leaving
+func (lb *loadBalancer[T, O]) notifyRemoved(node serverpool.Node[T, O]) {
+	lb.emit(Event[T, O]{Type: EventNodeRemoved, Time: lb.churn.clock(), Node: node})
+	if lb.webhooks == nil || lb.readOnly {
+		return
+	}
+	lb.notifyWebhooks(WebhookEvent[T, O]{Type: EventNodeRemoved, Time: lb.churn.clock(), Node: node.Name()})
+}
+
+// Notify the subscriptions and webhooks of a stray object taken off a node
+func (lb *loadBalancer[T, O]) notifyCollected(s Stray[T, O]) {
+	lb.emit(Event[T, O]{Type: EventObjectCollected, Time: lb.churn.clock(), Node: s.Node, Object: s.Object.Id})
+	if lb.webhooks == nil || lb.readOnly {
+		return
+	}
+	id := s.Object.Id
+	lb.notifyWebhooks(WebhookEvent[T, O]{Type: EventObjectCollected, Time: lb.churn.clock(), Object: &id, Node: s.Node.Name()})
+}
+
+// Notify the subscriptions and webhooks of an object evicted from a full
+// node and record it unassigned
+func (lb *loadBalancer[T, O]) notifyEvicted(obj *serverpool.Object[T, O], from serverpool.Node[T, O]) {
+	lb.putRecord(obj, nil)
+	lb.emit(Event[T, O]{Type: EventObjectEvicted, Time: lb.churn.clock(), Node: from, Object: obj.Id})
+	if lb.webhooks == nil || lb.readOnly {
+		return
+	}
+	id := obj.Id
+	lb.notifyWebhooks(WebhookEvent[T, O]{Type: EventObjectEvicted, Time: lb.churn.clock(), Object: &id, Node: from.Name()})
+}
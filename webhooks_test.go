@@ -0,0 +1,106 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"serverpool"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	secret := []byte("secret")
+	var mu sync.Mutex
+	var events []WebhookEvent[string, string]
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if err := VerifyWebhook(secret, r.Header.Get(WebhookTimestampHeader), body, r.Header.Get(WebhookSignatureHeader)); err != nil {
+			t.Errorf("expected a valid signature, got %v", err)
+		}
+		// The first attempt fails and is retried
+		if requests++; requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var e WebhookEvent[string, string]
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		events = append(events, e)
+	}))
+	defer ts.Close()
+
+	errs := make(chan error, 10)
+	lb := NewLoadBalancerWithOptions(WithWebhooks[string, string]([]Webhook{{URL: ts.URL, Secret: secret}},
+		func(err error) { errs <- err }))
+	node1 := &mockNode{ID: "node1", objects: make(map[string]*serverpool.Object[string, string])}
+	node2 := &mockNode{ID: "node2", objects: make(map[string]*serverpool.Object[string, string])}
+	if _, err := lb.AddNodes([]serverpool.Node[string, string]{node1, node2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	obj := &serverpool.Object[string, string]{Id: "obj1"}
+	if _, err := lb.AddObjects([]*serverpool.Object[string, string]{obj}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := lb.AssignObject(obj); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	first := (*obj.Node()).Name()
+	other := node1
+	if first == "node1" {
+		other = node2
+	}
+	if err := lb.TransferObject(obj, other); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := lb.RemoveNodes([]serverpool.Node[string, string]{other}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 4 events, got %d", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected the failed attempt to be reported, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []struct{ typ, node, from string }{
+		{EventObjectAssigned, first, ""},
+		{EventObjectMoved, other.ID, first},
+		{EventNodeRemoved, other.ID, ""},
+		{EventObjectMoved, first, other.ID},
+	}
+	for i, w := range want {
+		e := events[i]
+		from := ""
+		if e.From != nil {
+			from = *e.From
+		}
+		if e.ID != uint64(i+1) || e.Type != w.typ || e.Node != w.node || from != w.from {
+			t.Fatalf("expected event %d to be %+v, got %+v", i+1, w, e)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// Weighted nodes, which hold a bucket per unit of weight
+
+package main
+
+import (
+	"consistenthash"
+	"serverpool"
+	"slices"
+)
+
+// Add a node to the pool with a bucket per unit of its weight, returning
+// the first bucket, which the pool reports for the node. A hasher taking
+// weights gives the node a single bucket of its weight instead.
+func (lb *loadBalancer[T, O]) addNodeBuckets(node serverpool.Node[T, O]) (int, error) {
+	bucket := lb.ch.AddBucket()
+	if err := lb.sp.AddNode(node, bucket); err != nil {
+		// Release the bucket so the hasher matches the server pool
+		lb.ch.RemoveBucket(bucket)
+		return -1, err
+	}
+	if wh, ok := lb.ch.(consistenthash.WeightedHasher); ok {
+		if w := serverpool.Weight(node); w > 1 {
+			wh.SetBucketWeight(bucket, float64(w))
+		}
+		return bucket, nil
+	}
+	for range serverpool.Weight(node) - 1 {
+		b := lb.ch.AddBucket()
+		if err := lb.sp.AddBucket(node, b); err != nil {
+			lb.ch.RemoveBucket(b)
+			lb.removeNodeBuckets(node)
+			return -1, err
+		}
+	}
+	return bucket, nil
+}
+
+// Remove a node from the pool and release its buckets in the reverse order
+// they were added, so Memento gives a node added back the same buckets
+func (lb *loadBalancer[T, O]) removeNodeBuckets(node serverpool.Node[T, O]) (int, serverpool.Node[T, O], error) {
+	buckets := lb.sp.NodeBuckets(node)
+	bucket, removed, err := lb.sp.RemoveNode(node)
+	if err != nil {
+		return -1, nil, err
+	}
+	for _, b := range slices.Backward(buckets) {
+		lb.ch.RemoveBucket(b)
+	}
+	return bucket, removed, nil
+}
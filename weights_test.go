@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"serverpool"
+	"testing"
+)
+
+func TestWeightedNodes(t *testing.T) {
+	lb := NewLoadBalancer[netip.Addr, int]()
+	big := NewWeightedServerNode[int](netip.MustParseAddr("10.0.0.1"), 4)
+	small1 := NewServerNode[int](netip.MustParseAddr("10.0.0.2"))
+	small2 := NewServerNode[int](netip.MustParseAddr("10.0.0.3"))
+	if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&big, &small1, &small2}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if lb.NodeCount() != 3 {
+		t.Fatalf("expected 3 nodes, got %d", lb.NodeCount())
+	}
+	buckets := 0
+	for range lb.Buckets() {
+		buckets++
+	}
+	if buckets != 6 {
+		t.Fatalf("expected 6 buckets, got %d", buckets)
+	}
+	nodes := 0
+	for range lb.Nodes() {
+		nodes++
+	}
+	if nodes != 3 {
+		t.Fatalf("expected each node once, got %d", nodes)
+	}
+
+	// The node of weight 4 receives about two thirds of the keys
+	mapping := func() map[string]netip.Addr {
+		m := make(map[string]netip.Addr)
+		for i := range 6000 {
+			key := fmt.Sprintf("key%d", i)
+			node, err := lb.GetNode(key)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			m[key] = node.Name()
+		}
+		return m
+	}
+	before := mapping()
+	share := 0
+	for _, name := range before {
+		if name == big.Name() {
+			share++
+		}
+	}
+	if share < 3400 || share > 4600 {
+		t.Fatalf("expected about 4000 keys on the weighted node, got %d", share)
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Removing the node releases all its buckets
+	if _, err := lb.RemoveNodes([]serverpool.Node[netip.Addr, int]{&big}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for key, name := range mapping() {
+		if name == big.Name() {
+			t.Fatalf("expected %q off the removed node", key)
+		}
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Adding it back restores its buckets and the mapping
+	if _, err := lb.AddNodes([]serverpool.Node[netip.Addr, int]{&big}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if after := mapping(); !maps.Equal(before, after) {
+		t.Fatal("expected the mapping to be restored")
+	}
+
+	// Draining the node also releases its buckets
+	if err := lb.(Drainer[netip.Addr, int]).DrainNode(&big, 10); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if lb.NodeCount() != 2 {
+		t.Fatalf("expected 2 nodes, got %d", lb.NodeCount())
+	}
+	if err := lb.Verify(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
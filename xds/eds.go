@@ -0,0 +1,155 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+// xds package serves the load balancer's nodes to Envoy with the endpoint
+// discovery service (EDS) over the REST-JSON transport.
+//
+// Configure the Envoy cluster with an eds_cluster_config whose eds_config
+// uses api_config_source with api_type REST and transport_api_version V3,
+// pointing at a cluster that reaches this server. Envoy polls
+// POST /v3/discovery:endpoints and receives a ClusterLoadAssignment.
+package xds
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// TypeURL of the ClusterLoadAssignment resources served
+const TypeURL = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+
+// Path Envoy polls for endpoints with the REST transport
+const Path = "/v3/discovery:endpoints"
+
+// HealthStatus of an endpoint as defined by envoy.config.core.v3.HealthStatus
+type HealthStatus string
+
+const (
+	Healthy   HealthStatus = "HEALTHY"
+	Unhealthy HealthStatus = "UNHEALTHY"
+	Draining  HealthStatus = "DRAINING"
+)
+
+// Endpoint is a node published to Envoy
+type Endpoint struct {
+	Address string
+	Port    uint32
+
+	// Relative load balancing weight, at least 1
+	Weight uint32
+
+	Health HealthStatus
+}
+
+// Source provides the endpoints of the cluster and a version that changes
+// whenever the endpoints change
+type Source interface {
+	Endpoints() ([]Endpoint, uint64)
+}
+
+// Server answers EDS requests for a single cluster
+type Server struct {
+	cluster string
+	source  Source
+}
+
+func NewServer(cluster string, source Source) *Server {
+	return &Server{cluster: cluster, source: source}
+}
+
+type discoveryRequest struct {
+	VersionInfo   string   `json:"version_info"`
+	ResourceNames []string `json:"resource_names"`
+	TypeURL       string   `json:"type_url"`
+}
+
+type discoveryResponse struct {
+	VersionInfo string                  `json:"version_info"`
+	Resources   []clusterLoadAssignment `json:"resources"`
+	TypeURL     string                  `json:"type_url"`
+}
+
+type clusterLoadAssignment struct {
+	Type        string             `json:"@type"`
+	ClusterName string             `json:"cluster_name"`
+	Endpoints   []localityEndpoint `json:"endpoints"`
+}
+
+type localityEndpoint struct {
+	LbEndpoints []lbEndpoint `json:"lb_endpoints"`
+}
+
+type lbEndpoint struct {
+	Endpoint            endpoint     `json:"endpoint"`
+	HealthStatus        HealthStatus `json:"health_status"`
+	LoadBalancingWeight uint32       `json:"load_balancing_weight"`
+}
+
+type endpoint struct {
+	Address address `json:"address"`
+}
+
+type address struct {
+	SocketAddress socketAddress `json:"socket_address"`
+}
+
+type socketAddress struct {
+	Address   string `json:"address"`
+	PortValue uint32 `json:"port_value"`
+}
+
+// Build the response for the current endpoints
+func (s *Server) response(names []string) (discoveryResponse, string) {
+	endpoints, v := s.source.Endpoints()
+	version := strconv.FormatUint(v, 10)
+	resp := discoveryResponse{VersionInfo: version, Resources: []clusterLoadAssignment{}, TypeURL: TypeURL}
+	if len(names) > 0 && !slices.Contains(names, s.cluster) {
+		return resp, version
+	}
+
+	cla := clusterLoadAssignment{Type: TypeURL, ClusterName: s.cluster,
+		Endpoints: []localityEndpoint{{LbEndpoints: []lbEndpoint{}}}}
+	for _, e := range endpoints {
+		cla.Endpoints[0].LbEndpoints = append(cla.Endpoints[0].LbEndpoints, lbEndpoint{
+			Endpoint:            endpoint{address{socketAddress{e.Address, e.Port}}},
+			HealthStatus:        e.Health,
+			LoadBalancingWeight: max(e.Weight, 1),
+		})
+	}
+	resp.Resources = append(resp.Resources, cla)
+	return resp, version
+}
+
+// ServeHTTP answers POST requests to Path. A request carrying the current
+// version gets 304 Not Modified, as the REST transport expects.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != Path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req discoveryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.TypeURL != "" && req.TypeURL != TypeURL {
+		http.Error(w, "unsupported type "+req.TypeURL, http.StatusBadRequest)
+		return
+	}
+
+	resp, version := s.response(req.ResourceNames)
+	if req.VersionInfo == version {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Rishabh Parekh
+// Use of this source code is governed by an MIT license that can be
+// found in the LICENSE file.
+
+package xds
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type staticSource struct {
+	endpoints []Endpoint
+	version   uint64
+}
+
+func (s *staticSource) Endpoints() ([]Endpoint, uint64) {
+	return s.endpoints, s.version
+}
+
+func TestServer(t *testing.T) {
+	source := &staticSource{endpoints: []Endpoint{
+		{Address: "10.0.0.1", Port: 8080, Weight: 2, Health: Healthy},
+		{Address: "10.0.0.2", Port: 8080, Health: Draining},
+	}, version: 3}
+	s := NewServer("backend", source)
+
+	tests := []struct {
+		name      string
+		method    string
+		body      string
+		wantCode  int
+		wantNodes int
+	}{
+		{name: "all clusters", method: http.MethodPost, body: `{}`, wantCode: http.StatusOK, wantNodes: 2},
+		{name: "named cluster", method: http.MethodPost, body: `{"resource_names":["backend"]}`, wantCode: http.StatusOK, wantNodes: 2},
+		{name: "other cluster", method: http.MethodPost, body: `{"resource_names":["other"]}`, wantCode: http.StatusOK},
+		{name: "current version", method: http.MethodPost, body: `{"version_info":"3"}`, wantCode: http.StatusNotModified},
+		{name: "wrong type", method: http.MethodPost, body: `{"type_url":"cluster"}`, wantCode: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, wantCode: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(tt.method, Path, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp discoveryResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			nodes := 0
+			for _, cla := range resp.Resources {
+				for _, e := range cla.Endpoints {
+					nodes += len(e.LbEndpoints)
+				}
+			}
+			if resp.VersionInfo != "3" || nodes != tt.wantNodes {
+				t.Fatalf("got version %q with %d endpoints, want 3 with %d", resp.VersionInfo, nodes, tt.wantNodes)
+			}
+			if nodes > 0 && resp.Resources[0].Endpoints[0].LbEndpoints[1].LoadBalancingWeight != 1 {
+				t.Fatalf("expected weight to default to 1")
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module xds
+
+go 1.23.0